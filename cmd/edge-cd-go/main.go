@@ -2,27 +2,65 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/config"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/facts"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/files"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/git"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/inventory"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/notify"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/pkgmgr"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/reconcile"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/selfupdate"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/svcmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
 )
 
 func main() {
+	printInventory := flag.Bool("inventory", false, "Print a JSON inventory of the device state and exit")
+	printDiff := flag.Bool("diff", false, "Print a JSON unified diff of each drifted file without writing anything and exit")
+	applyPaths := flag.String("apply", "",
+		"Reconcile once only the files at these comma-separated destination paths, restarting their services, print the JSON result and exit")
+	printPlan := flag.Bool("plan", false,
+		"Sync the repositories, print the JSON plan of the changes a reconciliation would apply without applying them and exit")
+	applyPlan := flag.String("apply-plan", "",
+		"Apply exactly the JSON plan at this path, printed by -plan, print the JSON result and exit")
+	verifyUpdate := flag.String(selfupdate.VerifyUpdateFlag, "",
+		"Verify the edge-cd service after the binary at this path was updated, rolling it back if unhealthy, and exit")
+	verifyAfterPID := flag.Int(selfupdate.VerifyAfterPIDFlag, 0,
+		"Wait for this process to exit before verifying the update")
+	validatePath := flag.String("validate", "",
+		"Validate the config spec at this path without reading the environment, and exit")
+	flag.Parse()
+
 	// Configure default slog handler (JSON handler for production)
-	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	oneShot := *printInventory || *printDiff || *applyPaths != "" || *printPlan || *applyPlan != "" || *validatePath != ""
+	handler := slog.NewJSONHandler(logOutput(oneShot), &slog.HandlerOptions{
 		Level: slog.LevelInfo,
 	})
 	slog.SetDefault(slog.New(handler))
 
+	if *validatePath != "" {
+		if err := config.ValidateSpecFile(*validatePath); err != nil {
+			slog.Error("Invalid configuration", "path", *validatePath, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Configuration is valid", "path", *validatePath)
+		return
+	}
+
 	slog.Info("Starting edge-cd-go")
 
 	// Load configuration
@@ -33,30 +71,151 @@ func main() {
 	}
 
 	slog.Info("Configuration loaded successfully",
-		"edgecd_repo", cfg.Spec.EdgeCD.Repo.URL,
-		"config_repo", cfg.Spec.Config.Repo.URL,
+		"edgecd_repo", git.RedactURL(cfg.Spec.EdgeCD.Repo.URL),
+		"config_repo", git.RedactURL(cfg.Spec.Config.Repo.URL),
 		"polling_interval", cfg.Spec.PollingInterval,
+		"sync_failure_policy", cfg.SyncFailurePolicy,
 	)
 
 	// Wire dependencies: create all managers
-	gitMgr := git.NewRepoManager()
+	gitMgr := git.NewRepoManager(git.WithCredentialHelper(cfg.GitCredentialHelper))
 
-	pkgMgr, err := pkgmgr.NewPackageManager(cfg.Spec.PackageManager.Name, cfg.EdgeCDRepoPath)
+	pkgMgr, err := pkgmgr.NewPackageManager(cfg.Spec.PackageManager.Name, cfg.EdgeCDRepoPath,
+		pkgmgr.WithEscalation(cfg.Escalation))
 	if err != nil {
 		slog.Error("Failed to create package manager", "error", err)
 		os.Exit(1)
 	}
 
-	svcMgr, err := svcmgr.NewServiceManager(cfg.Spec.ServiceManager.Name, cfg.EdgeCDRepoPath)
+	svcMgr, err := svcmgr.NewServiceManager(cfg.Spec.ServiceManager.Name, cfg.EdgeCDRepoPath,
+		svcmgr.WithEscalation(cfg.Escalation))
 	if err != nil {
 		slog.Error("Failed to create service manager", "error", err)
 		os.Exit(1)
 	}
 
-	fileRec := files.NewFileReconciler()
+	collector := inventory.NewCollector(cfg, gitMgr, pkgMgr, svcMgr)
+	if *printInventory {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(collector.Collect()); err != nil {
+			slog.Error("Failed to write inventory", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	factsCache := facts.NewCache()
+	fileOpts := []files.FileReconcilerOption{
+		files.WithValues(cfg.Spec.Values, cfg.Spec.ValuesFrom),
+		files.WithFacts(factsCache),
+		files.WithEscalation(cfg.Escalation),
+		files.WithDefaultFileMode(cfg.Spec.DefaultFileMode),
+		files.WithRootPrefix(cfg.RootPrefix),
+	}
+	fileRec := files.NewFileReconciler(append(fileOpts, files.WithManifest(cfg.FilesManifestPath))...)
+	differ := files.NewDiffHandler(fileRec, cfg.ConfigRepoPath, cfg.Spec.Config.Path, cfg.Spec.Files)
+	if *printDiff {
+		diffs, err := differ.Diff()
+		if err != nil {
+			slog.Error("Failed to diff files", "error", err)
+			os.Exit(1)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diffs); err != nil {
+			slog.Error("Failed to write file diff", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *verifyUpdate != "" {
+		var spec userconfig.SelfUpdateSection
+		if cfg.Spec.EdgeCD.SelfUpdate != nil {
+			spec = *cfg.Spec.EdgeCD.SelfUpdate
+		}
+		verifyCfg := selfupdate.NewVerifyConfig(spec, *verifyUpdate, *verifyAfterPID)
+		if err := selfupdate.Verify(svcMgr, verifyCfg); err != nil {
+			slog.Error("EdgeCD update verification failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	updater := selfupdate.NewUpdater(selfupdate.NewHTTPDownloader())
+
+	var notifier notify.Notifier
+	if cfg.Spec.Notify != nil {
+		notifier = notify.NewWebhookNotifier(*cfg.Spec.Notify)
+	}
+
+	var heartbeat notify.Heartbeater
+	if cfg.Spec.Heartbeat != nil {
+		heartbeat = notify.NewWebhookHeartbeater(*cfg.Spec.Heartbeat)
+	}
+
+	if *applyPaths != "" {
+		// ReconcilePaths only updates the manifest entries of the applied files
+		applyRec := files.NewFileReconciler(fileOpts...)
+		reconciler := reconcile.NewReconciler(cfg, gitMgr, pkgMgr, svcMgr, applyRec, updater, notifier, heartbeat, factsCache)
+		result, applyErr := reconciler.ReconcilePaths(context.Background(), strings.Split(*applyPaths, ","))
+		if applyErr != nil {
+			slog.Error("Failed to apply files", "error", applyErr)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			slog.Error("Failed to write apply result", "error", err)
+			os.Exit(1)
+		}
+		if applyErr != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *printPlan || *applyPlan != "" {
+		// Apply only updates the manifest entries of the planned files
+		applyRec := files.NewFileReconciler(fileOpts...)
+		reconciler := reconcile.NewReconciler(cfg, gitMgr, pkgMgr, svcMgr, applyRec, updater, notifier, heartbeat, factsCache)
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if *printPlan {
+			plan, err := reconciler.Plan(context.Background())
+			if err != nil {
+				slog.Error("Failed to plan reconciliation", "error", err)
+				os.Exit(1)
+			}
+			if err := enc.Encode(plan); err != nil {
+				slog.Error("Failed to write plan", "error", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		plan, err := readPlan(*applyPlan)
+		if err != nil {
+			slog.Error("Failed to read plan", "path", *applyPlan, "error", err)
+			os.Exit(1)
+		}
+		result, applyErr := reconciler.Apply(context.Background(), plan)
+		if applyErr != nil {
+			slog.Error("Failed to apply plan", "error", applyErr)
+		}
+		if err := enc.Encode(result); err != nil {
+			slog.Error("Failed to write apply result", "error", err)
+			os.Exit(1)
+		}
+		if applyErr != nil {
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Create reconciler with all dependencies
-	reconciler := reconcile.NewReconciler(cfg, gitMgr, pkgMgr, svcMgr, fileRec)
+	reconciler := reconcile.NewReconciler(cfg, gitMgr, pkgMgr, svcMgr, fileRec, updater, notifier, heartbeat, factsCache)
 
 	// Set up context with cancellation for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -66,6 +225,44 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Serve the inventory endpoint if enabled
+	if cfg.InventoryListenAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/inventory", collector)
+		// The diff shows the rendered files, which may hold secrets
+		if cfg.DiffEndpointEnabled {
+			mux.Handle("/diff", differ)
+		}
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprintln(w, "ok")
+		})
+		server := &http.Server{Addr: cfg.InventoryListenAddr, Handler: mux}
+
+		go func() {
+			slog.Info("Serving inventory", "addr", cfg.InventoryListenAddr)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Inventory endpoint failed", "error", err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	// Serve the rendered config of the devices to pull-based agents if enabled
+	if cfg.DevicesListenAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /device/{id}", files.NewDeviceHandler(
+			cfg.ConfigRepoPath, cfg.DevicesPath, filepath.Base(cfg.ConfigSpecPath)))
+		server := &http.Server{Addr: cfg.DevicesListenAddr, Handler: mux}
+
+		go func() {
+			slog.Info("Serving device configs", "addr", cfg.DevicesListenAddr, "devicesPath", cfg.DevicesPath)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Device config endpoint failed", "error", err)
+			}
+		}()
+		defer server.Close()
+	}
+
 	// Start reconciler in a goroutine
 	go func() {
 		reconciler.Run(ctx)
@@ -81,3 +278,26 @@ func main() {
 	slog.Info("edge-cd-go stopped")
 	fmt.Println("edge-cd-go stopped successfully")
 }
+
+// readPlan reads a JSON plan printed by -plan.
+func readPlan(path string) (reconcile.Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return reconcile.Plan{}, err
+	}
+	var plan reconcile.Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return reconcile.Plan{}, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	return plan, nil
+}
+
+// logOutput returns where the logs are written: stderr in the one-shot modes,
+// which keep stdout for their JSON output so that it can be parsed, e.g. by
+// edgectl, and stdout otherwise.
+func logOutput(oneShot bool) io.Writer {
+	if oneShot {
+		return os.Stderr
+	}
+	return os.Stdout
+}
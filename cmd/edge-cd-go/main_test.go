@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/files"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/apply"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/inventory"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runMainEnv makes the test binary run main with the arguments it holds,
+// separated by spaces, instead of the tests.
+const runMainEnv = "EDGE_CD_GO_TEST_MAIN_ARGS"
+
+func TestMain(m *testing.M) {
+	if args := os.Getenv(runMainEnv); args != "" {
+		os.Args = append([]string{os.Args[0]}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runEdgeCDGo runs edge-cd-go with args against a config repository in
+// tmpDir holding a single content file spec, of tmpDir/motd, and returns what
+// it wrote to stdout and stderr.
+func runEdgeCDGo(t *testing.T, tmpDir string, args ...string) (stdout, stderr string) {
+	t.Helper()
+
+	configDir := filepath.Join(tmpDir, "config", "test-device")
+	require.NoError(t, os.MkdirAll(configDir, 0755))
+	spec := `
+edgeCD:
+  repo:
+    url: https://github.com/test/edge-cd.git
+    branch: main
+    destinationPath: /opt/edge-cd
+config:
+  spec: spec.yaml
+  path: test-device
+  repo:
+    url: https://github.com/test/config.git
+    branch: main
+    destPath: /opt/config
+serviceManager:
+  name: systemd
+packageManager:
+  name: apt
+files:
+  - type: content
+    destPath: ` + filepath.Join(tmpDir, "motd") + `
+    content: welcome
+`
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "spec.yaml"), []byte(spec), 0644))
+
+	edgeCDRepoPath, err := filepath.Abs(filepath.Join("..", ".."))
+	require.NoError(t, err)
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(),
+		runMainEnv+"="+strings.Join(args, " "),
+		"CONFIG_PATH=test-device",
+		"CONFIG_REPO_DEST_PATH="+filepath.Join(tmpDir, "config"),
+		"EDGE_CD_REPO_DESTINATION_PATH="+edgeCDRepoPath,
+		"LOCK_FILE_DIRNAME="+tmpDir,
+		"EDGE_CD_COMMIT_PATH="+filepath.Join(tmpDir, "edge-cd-commit.txt"),
+		"CONFIG_COMMIT_PATH="+filepath.Join(tmpDir, "config-commit.txt"),
+		"FILES_MANIFEST_PATH="+filepath.Join(tmpDir, "files-manifest.json"),
+		"RECONCILE_SUMMARY_PATH="+filepath.Join(tmpDir, "reconcile-summary.json"),
+	)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &outBuf, &errBuf
+	require.NoError(t, cmd.Run(), "stderr=%s", errBuf.String())
+
+	return outBuf.String(), errBuf.String()
+}
+
+func TestInventoryOutput(t *testing.T) {
+	stdout, stderr := runEdgeCDGo(t, t.TempDir(), "--inventory")
+
+	// The logs go to stderr, so that edgectl can parse stdout
+	assert.Contains(t, stderr, "Configuration loaded successfully")
+
+	ctx := execcontext.New(nil, nil)
+	runner := ssh.NewMockRunner()
+	runner.SetResponse(execcontext.FormatCmd(ctx, "edge-cd-go", "--inventory"), stdout, stderr, nil)
+
+	inv, err := inventory.Fetch(ctx, runner, "edge-cd-go")
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/test/config.git", inv.Config.URL)
+	require.Len(t, inv.Files, 1)
+	assert.False(t, inv.Files[0].Present)
+}
+
+func TestDiffOutput(t *testing.T) {
+	stdout, stderr := runEdgeCDGo(t, t.TempDir(), "--diff")
+
+	// The logs go to stderr, so that the diff can be parsed from stdout
+	assert.Contains(t, stderr, "Configuration loaded successfully")
+
+	var diffs []files.FileDiff
+	require.NoError(t, json.Unmarshal([]byte(stdout), &diffs), "stdout=%s", stdout)
+	require.Len(t, diffs, 1)
+	assert.True(t, diffs[0].Missing)
+	assert.Contains(t, diffs[0].Diff, "+welcome")
+}
+
+func TestApplyOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+	motd := filepath.Join(tmpDir, "motd")
+	stdout, stderr := runEdgeCDGo(t, tmpDir, "--apply", motd)
+
+	// The logs go to stderr, so that edgectl can parse the result from stdout
+	assert.Contains(t, stderr, "Configuration loaded successfully")
+
+	ctx := execcontext.New(nil, nil)
+	runner := ssh.NewMockRunner()
+	runner.SetResponse(execcontext.FormatCmd(ctx, "edge-cd-go", "--apply", motd), stdout, stderr, nil)
+
+	result, err := apply.Run(ctx, runner, "edge-cd-go", []string{motd})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.FileChanges["content"])
+
+	content, err := os.ReadFile(motd)
+	require.NoError(t, err)
+	assert.Equal(t, "welcome", string(content))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	te2e "github.com/alexandremahdhaoui/edge-cd/pkg/test/e2e"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errInvalidDeleteArgs  = errors.New("invalid delete arguments")
+	errDeleteNotConfirmed = errors.New("deletion of all the test environments not confirmed")
+	errDeleteAllFailed    = errors.New("failed to delete all test environments")
+)
+
+// statusDeleted is the status reported for an environment torn down and
+// removed from the store.
+const statusDeleted = "deleted"
+
+// deleteArgs is the configuration of the delete command.
+type deleteArgs struct {
+	testID string
+	// all deletes every environment of the store instead of testID
+	all bool
+	// yes deletes all the environments without asking for confirmation
+	yes bool
+}
+
+// parseDeleteArgs returns the configuration of the delete command:
+// <test-id> or --all [--yes].
+func parseDeleteArgs(args []string) (deleteArgs, error) {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	all := fs.Bool("all", false, "delete every test environment")
+	yes := fs.Bool("yes", false, "do not ask for confirmation before deleting every test environment")
+	if err := fs.Parse(args); err != nil {
+		return deleteArgs{}, flaterrors.Join(err, errInvalidDeleteArgs)
+	}
+
+	switch {
+	case *all && fs.NArg() > 0:
+		return deleteArgs{}, flaterrors.Join(
+			fmt.Errorf("unexpected test IDs with --all: %v", fs.Args()), errInvalidDeleteArgs)
+	case *all:
+		return deleteArgs{all: true, yes: *yes}, nil
+	case *yes:
+		return deleteArgs{}, flaterrors.Join(fmt.Errorf("--yes requires --all"), errInvalidDeleteArgs)
+	case fs.NArg() != 1:
+		return deleteArgs{}, flaterrors.Join(
+			fmt.Errorf("expected one test ID, got %v", fs.Args()), errInvalidDeleteArgs)
+	}
+	return deleteArgs{testID: fs.Arg(0)}, nil
+}
+
+// deleteResult is the outcome of the deletion of one of the environments of
+// `delete --all`.
+type deleteResult struct {
+	envID string
+	// status is statusDeleted, te2e.StatusPartiallyDeleted, or empty if the
+	// store could not be updated
+	status string
+	err    error
+}
+
+// cmdDeleteAll tears down every test environment of the store, after asking
+// for confirmation unless yes is set. At most parallel environments are torn
+// down at a time.
+func cmdDeleteAll(
+	ctx execcontext.Context,
+	prov EnvironmentProvisioner,
+	artifactStoreDir string,
+	yes bool,
+	parallel int,
+) {
+	store := te2e.NewJSONArtifactStore(filepath.Join(artifactStoreDir, "artifacts.json"))
+	envs, err := store.ListAll(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to list environments: %v\n", err)
+		os.Exit(1)
+	}
+	if len(envs) == 0 {
+		fmt.Println("No test environments found")
+		return
+	}
+
+	if !yes {
+		if stat, _ := os.Stdin.Stat(); stat.Mode()&os.ModeCharDevice == 0 {
+			fmt.Fprintf(os.Stderr, "Error: --all requires --yes when stdin is not a terminal\n")
+			os.Exit(1)
+		}
+		if !confirmDeleteAll(os.Stdin, os.Stderr, envs) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", errDeleteNotConfirmed)
+			os.Exit(1)
+		}
+	}
+
+	results, err := deleteAll(ctx, prov, store, envs, parallel)
+	fmt.Println()
+	writeDeleteResults(os.Stdout, results)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		fmt.Fprintf(os.Stderr, "To retry cleanup, run: edgectl-e2e delete --all\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n✅ %d test environments have been fully deleted\n", len(results))
+}
+
+// confirmDeleteAll lists envs on out and returns true if the answer read from
+// in is yes.
+func confirmDeleteAll(in io.Reader, out io.Writer, envs []*te2e.TestEnvironment) bool {
+	fmt.Fprintf(out, "The following %d test environments will be deleted:\n", len(envs))
+	for _, env := range envs {
+		fmt.Fprintf(out, "  - %s (%s)\n", env.ID, env.Status)
+	}
+	fmt.Fprint(out, "Delete them? [y/N] ")
+
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// deleteAll tears down envs, at most parallel at a time, like the VM creations
+// are bounded by E2E_MAX_CONCURRENT_VMS. Like `delete`, each environment is
+// removed from the store once torn down, or marked partially_deleted if its
+// teardown failed. A failure does not stop the deletion of the other
+// environments: the results are returned sorted by environment ID, with an
+// error if any environment was not fully deleted.
+func deleteAll(
+	ctx execcontext.Context,
+	prov EnvironmentProvisioner,
+	store te2e.ArtifactStore,
+	envs []*te2e.TestEnvironment,
+	parallel int,
+) ([]deleteResult, error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	slots := make(chan struct{}, parallel)
+
+	results := make([]deleteResult, len(envs))
+	var wg sync.WaitGroup
+	for i, env := range envs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			fmt.Printf("Deleting test environment: %s\n", env.ID)
+			results[i] = deleteStoredEnvironment(ctx, prov, store, env)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].envID < results[j].envID })
+
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, flaterrors.Join(
+			fmt.Errorf("%d of %d environments not fully deleted", failed, len(results)), errDeleteAllFailed)
+	}
+	return results, nil
+}
+
+// deleteStoredEnvironment tears down env and removes it from store, or marks
+// it partially_deleted in store if the teardown failed.
+func deleteStoredEnvironment(
+	ctx execcontext.Context,
+	prov EnvironmentProvisioner,
+	store te2e.ArtifactStore,
+	env *te2e.TestEnvironment,
+) deleteResult {
+	result := deleteResult{envID: env.ID}
+
+	if _, teardownErr := teardownEnvironment(ctx, prov, env); teardownErr != nil {
+		result.err = flaterrors.Join(teardownErr, errTeardownEnvironment)
+		env.Status = te2e.StatusPartiallyDeleted
+		env.UpdatedAt = time.Now()
+		if err := store.Save(ctx, env); err != nil {
+			result.err = flaterrors.Join(result.err, err, errSaveEnvironment)
+			return result
+		}
+		result.status = te2e.StatusPartiallyDeleted
+		return result
+	}
+
+	if err := store.Delete(ctx, env.ID); err != nil {
+		result.err = err
+		return result
+	}
+	result.status = statusDeleted
+	return result
+}
+
+// writeDeleteResults writes the outcome of the deletion of each environment as
+// an aligned table.
+func writeDeleteResults(w io.Writer, results []deleteResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENVIRONMENT\tSTATUS\tERROR")
+	for _, r := range results {
+		status, detail := r.status, ""
+		if status == "" {
+			status = "failed"
+		}
+		if r.err != nil {
+			detail = r.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.envID, status, detail)
+	}
+	tw.Flush()
+}
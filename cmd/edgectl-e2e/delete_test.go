@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	te2e "github.com/alexandremahdhaoui/edge-cd/pkg/test/e2e"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeleteArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    deleteArgs
+		wantErr bool
+	}{
+		{name: "test ID", args: []string{"e2e-20231025-abc123"}, want: deleteArgs{testID: "e2e-20231025-abc123"}},
+		{name: "all", args: []string{"--all"}, want: deleteArgs{all: true}},
+		{name: "all confirmed", args: []string{"--all", "--yes"}, want: deleteArgs{all: true, yes: true}},
+		{name: "missing test ID", args: []string{}, wantErr: true},
+		{name: "all with a test ID", args: []string{"--all", "e2e-20231025-abc123"}, wantErr: true},
+		{name: "yes without all", args: []string{"--yes", "e2e-20231025-abc123"}, wantErr: true},
+		{name: "unknown flag", args: []string{"--force", "e2e-20231025-abc123"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDeleteArgs(tt.args)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errInvalidDeleteArgs)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// newStoreWithEnvironments saves n environments to a new store.
+func newStoreWithEnvironments(t *testing.T, n int) (*te2e.JSONArtifactStore, []*te2e.TestEnvironment) {
+	t.Helper()
+	store := te2e.NewJSONArtifactStore(filepath.Join(t.TempDir(), "artifacts.json"))
+	var envs []*te2e.TestEnvironment
+	for i := range n {
+		env := &te2e.TestEnvironment{
+			ID:       fmt.Sprintf("e2e-20231025-env%d", i+1),
+			Status:   te2e.StatusCreated,
+			TargetVM: vmm.VMMetadata{Name: fmt.Sprintf("target-%d", i+1)},
+		}
+		require.NoError(t, store.Save(newTestExecCtx(), env))
+		envs = append(envs, env)
+	}
+	return store, envs
+}
+
+func TestDeleteAll_DeletesEveryEnvironment(t *testing.T) {
+	store, envs := newStoreWithEnvironments(t, 5)
+	prov := &fakeProvisioner{teardownDelay: 20 * time.Millisecond}
+
+	results, err := deleteAll(newTestExecCtx(), prov, store, envs, 2)
+	require.NoError(t, err)
+
+	require.Len(t, results, 5)
+	for i, r := range results {
+		assert.Equal(t, envs[i].ID, r.envID)
+		assert.Equal(t, statusDeleted, r.status)
+		assert.NoError(t, r.err)
+	}
+	assert.Len(t, prov.tornDownIDs, 5)
+	assert.LessOrEqual(t, prov.maxTeardownsRunning, 2, "at most 2 environments are torn down at a time")
+
+	stored, err := store.ListAll(newTestExecCtx())
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestDeleteAll_ReportsFailuresWithoutAborting(t *testing.T) {
+	store, envs := newStoreWithEnvironments(t, 3)
+	prov := &fakeProvisioner{
+		teardownErrs: map[string]error{"e2e-20231025-env2": errors.New("failed to undefine domain")},
+	}
+
+	results, err := deleteAll(newTestExecCtx(), prov, store, envs, 1)
+
+	require.ErrorIs(t, err, errDeleteAllFailed)
+	assert.Contains(t, err.Error(), "1 of 3 environments not fully deleted")
+
+	// Every environment is attempted, even after the failure
+	assert.ElementsMatch(t, []string{"e2e-20231025-env1", "e2e-20231025-env2", "e2e-20231025-env3"}, prov.tornDownIDs)
+	require.Len(t, results, 3)
+	assert.Equal(t, statusDeleted, results[0].status)
+	assert.Equal(t, te2e.StatusPartiallyDeleted, results[1].status)
+	assert.ErrorIs(t, results[1].err, errTeardownEnvironment)
+	assert.Equal(t, statusDeleted, results[2].status)
+
+	// The failed environment is kept in the store to retry its cleanup
+	stored, err := store.ListAll(newTestExecCtx())
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, "e2e-20231025-env2", stored[0].ID)
+	assert.Equal(t, te2e.StatusPartiallyDeleted, stored[0].Status)
+
+	var out bytes.Buffer
+	writeDeleteResults(&out, results)
+	assert.Regexp(t, `e2e-20231025-env1\s+deleted`, out.String())
+	assert.Regexp(t, `e2e-20231025-env2\s+partially_deleted\s+.*failed to undefine domain`, out.String())
+}
+
+func TestConfirmDeleteAll(t *testing.T) {
+	_, envs := newStoreWithEnvironments(t, 2)
+
+	for answer, want := range map[string]bool{"y\n": true, "YES\n": true, "n\n": false, "\n": false, "": false} {
+		var out bytes.Buffer
+		got := confirmDeleteAll(strings.NewReader(answer), &out, envs)
+		assert.Equal(t, want, got, "answer %q", answer)
+		assert.Contains(t, out.String(), "The following 2 test environments will be deleted")
+		assert.Contains(t, out.String(), "e2e-20231025-env1 (created)")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	te2e "github.com/alexandremahdhaoui/edge-cd/pkg/test/e2e"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errInvalidImportArgs = errors.New("invalid import arguments")
+	errImportEnvironment = errors.New("failed to import test environment")
+)
+
+// parseImportArgs returns the configuration of the import command:
+// [--own] [--ssh-key <path>] <domain>.
+func parseImportArgs(args []string) (te2e.ImportConfig, error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	own := fs.Bool("own", false, "destroy the domain when the environment is deleted")
+	sshKey := fs.String("ssh-key", "", "private key logging in to the domain as ubuntu")
+	if err := fs.Parse(args); err != nil {
+		return te2e.ImportConfig{}, flaterrors.Join(err, errInvalidImportArgs)
+	}
+	if fs.NArg() != 1 {
+		return te2e.ImportConfig{}, flaterrors.Join(
+			fmt.Errorf("expected one domain name, got %v", fs.Args()), errInvalidImportArgs)
+	}
+	return te2e.ImportConfig{DomainName: fs.Arg(0), HostKeyPath: *sshKey, Own: *own}, nil
+}
+
+// importEnvironment imports an existing domain as a test environment and saves
+// it to the artifact store. The domain is left untouched if the environment
+// cannot be saved.
+func importEnvironment(
+	execCtx execcontext.Context,
+	prov EnvironmentProvisioner,
+	artifactStoreDir string,
+	config te2e.ImportConfig,
+) (*te2e.TestEnvironment, error) {
+	env, err := prov.Import(execCtx, config)
+	if err != nil {
+		return nil, flaterrors.Join(err, errImportEnvironment)
+	}
+
+	store := te2e.NewJSONArtifactStore(filepath.Join(artifactStoreDir, "artifacts.json"))
+	if err := os.MkdirAll(artifactStoreDir, 0o755); err != nil {
+		return nil, flaterrors.Join(err, errSaveEnvironment)
+	}
+	if err := store.Save(execCtx, env); err != nil {
+		return nil, flaterrors.Join(err, errSaveEnvironment)
+	}
+	return env, nil
+}
+
+// cmdImport registers an existing libvirt domain as the target VM of a new test environment
+func cmdImport(
+	execCtx execcontext.Context,
+	prov EnvironmentProvisioner,
+	artifactStoreDir string,
+	config te2e.ImportConfig,
+) {
+	env, err := importEnvironment(execCtx, prov, artifactStoreDir, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Output environment ID (this is the primary output for scripting)
+	fmt.Println(env.ID)
+
+	if !isPiped() {
+		fmt.Fprintf(os.Stderr, "\n✅ Domain %s imported as test environment: %s\n", config.DomainName, env.ID)
+		fmt.Fprintf(os.Stderr, "   Target VM: %s (%s)\n", env.TargetVM.Name, env.TargetVM.IP)
+		if env.KeepTargetVM {
+			fmt.Fprintf(os.Stderr, "   The domain is kept when the environment is deleted (use --own to destroy it)\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "   The domain is destroyed when the environment is deleted\n")
+		}
+	}
+}
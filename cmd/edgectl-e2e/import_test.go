@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	te2e "github.com/alexandremahdhaoui/edge-cd/pkg/test/e2e"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImportArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    te2e.ImportConfig
+		wantErr bool
+	}{
+		{name: "domain only", args: []string{"dev-vm"}, want: te2e.ImportConfig{DomainName: "dev-vm"}},
+		{
+			name: "owned with key",
+			args: []string{"--own", "--ssh-key", "/home/dev/.ssh/id_ed25519", "dev-vm"},
+			want: te2e.ImportConfig{DomainName: "dev-vm", HostKeyPath: "/home/dev/.ssh/id_ed25519", Own: true},
+		},
+		{name: "missing domain", args: []string{"--own"}, wantErr: true},
+		{name: "two domains", args: []string{"dev-vm", "other-vm"}, wantErr: true},
+		{name: "unknown flag", args: []string{"--force", "dev-vm"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseImportArgs(tt.args)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errInvalidImportArgs)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestImportEnvironment_SavesToStore(t *testing.T) {
+	storeDir := filepath.Join(t.TempDir(), "nested")
+	ctx := newTestExecCtx()
+	prov := &fakeProvisioner{}
+
+	env, err := importEnvironment(ctx, prov, storeDir, te2e.ImportConfig{DomainName: "dev-vm"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"import"}, prov.calls)
+
+	loaded, err := te2e.NewJSONArtifactStore(filepath.Join(storeDir, "artifacts.json")).Load(ctx, env.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "dev-vm", loaded.TargetVM.Name)
+	assert.True(t, loaded.KeepTargetVM, "the domain must not be owned without --own")
+}
+
+func TestImportEnvironment_Failure(t *testing.T) {
+	storeDir := t.TempDir()
+	prov := &fakeProvisioner{importErr: errors.New("domain not found")}
+
+	_, err := importEnvironment(newTestExecCtx(), prov, storeDir, te2e.ImportConfig{DomainName: "missing"})
+
+	assert.ErrorIs(t, err, errImportEnvironment)
+	assert.NoFileExists(t, filepath.Join(storeDir, "artifacts.json"))
+}
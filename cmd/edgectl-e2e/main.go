@@ -2,16 +2,32 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"text/tabwriter"
 	"time"
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
 	te2e "github.com/alexandremahdhaoui/edge-cd/pkg/test/e2e"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errCreateEnvironment   = errors.New("failed to create test environment")
+	errSaveEnvironment     = errors.New("failed to save environment to artifact store")
+	errTeardownEnvironment = errors.New("failed to tear down test environment")
+	errBuildEdgectl        = errors.New("failed to build edgectl binary")
+	errBootstrapTest       = errors.New("bootstrap tests failed")
+	errInvalidMaxVMs       = errors.New("invalid E2E_MAX_CONCURRENT_VMS")
+	errLoadEnvironment     = errors.New("failed to load test environment")
+	errDescribeEnvironment = errors.New("failed to describe test environment")
 )
 
 func main() {
@@ -22,27 +38,47 @@ func main() {
 
 Commands:
   create             Create a new test environment
+  import [--own] [--ssh-key <path>] <domain>
+                     Register an existing libvirt domain as the target VM of a new test environment
   get <test-id>      Get information about a test environment
-  run <test-id>      Run tests in an existing environment
+  describe <test-id> Show the stored information and the live state of a test environment
+  run [--follow-logs] <test-id>
+                     Run tests in an existing environment, tailing the edge-cd logs of the target VM to stderr with --follow-logs
+  health <test-id>   Check the VMs and git server of a test environment are reachable
   delete <test-id>   Cleanup and destroy a test environment
+  delete --all [--yes]
+                     Cleanup and destroy every test environment, after confirmation unless --yes is set
+  rotate-keys <test-id>  Replace the host SSH key of a test environment
   list               List all known test environments and their status
   logs <test-id> <log-type>  Display logs for a test environment
                              Log types: bootstrap, service
-  test               One-shot test (create → run → delete)
+  test [--parallel N] One-shot test (create → run → delete), N environments at once
 
 Environment Variables:
-  E2E_ARTIFACTS_DIR  Override artifact storage location (default: ~/.edge-cd/e2e/)
+  E2E_ARTIFACTS_DIR       Override artifact storage location (default: ~/.edge-cd/e2e/)
+  E2E_MAX_CONCURRENT_VMS  Maximum number of VMs created at the same time (default: 2)
+  E2E_IMAGE_SHA256        Expected SHA-256 of the downloaded VM image (default: size check only)
 
 Examples:
   # Create test environment
   edgectl-e2e create
 
+  # Manage a VM created by hand, without destroying it on delete
+  edgectl-e2e import --ssh-key ~/.ssh/id_ed25519 dev-vm
+
   # Get environment information
   edgectl-e2e get e2e-20231025-abc123
 
-  # Run tests in that environment
+  # Show the current IPs, service state and applied commit of the environment
+  edgectl-e2e describe e2e-20231025-abc123
+
+  # Check the environment is reachable, then run tests in it
+  edgectl-e2e health e2e-20231025-abc123
   edgectl-e2e run e2e-20231025-abc123
 
+  # Run tests while watching edge-cd reconcile on the target VM
+  edgectl-e2e run --follow-logs e2e-20231025-abc123
+
   # View bootstrap logs
   edgectl-e2e logs e2e-20231025-abc123 bootstrap
 
@@ -52,11 +88,17 @@ Examples:
   # Cleanup when done
   edgectl-e2e delete e2e-20231025-abc123
 
+  # Cleanup every environment at the end of a testing session
+  edgectl-e2e delete --all --yes
+
   # List all environments
   edgectl-e2e list
 
   # One-shot test
   edgectl-e2e test
+
+  # Three one-shot tests running concurrently
+  edgectl-e2e test --parallel 3
 `)
 	}
 
@@ -68,11 +110,27 @@ Examples:
 	command := os.Args[1]
 	artifactStoreDir := getArtifactDir()
 
+	maxVMs, err := getMaxConcurrentVMs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	vmm.SetMaxConcurrentCreations(maxVMs)
+
 	execCtx := execcontext.New(make(map[string]string), []string{})
+	prov := NewEnvironmentProvisioner()
 
 	switch command {
 	case "create":
-		cmdCreate(execCtx, artifactStoreDir)
+		cmdCreate(execCtx, prov, artifactStoreDir)
+	case "import":
+		config, err := parseImportArgs(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Usage: edgectl-e2e import [--own] [--ssh-key <path>] <domain>\n")
+			os.Exit(1)
+		}
+		cmdImport(execCtx, prov, artifactStoreDir, config)
 	case "get":
 		if len(os.Args) < 3 {
 			fmt.Fprintf(os.Stderr, "Error: 'get' requires a test ID\n")
@@ -80,20 +138,47 @@ Examples:
 			os.Exit(1)
 		}
 		cmdGet(execCtx, artifactStoreDir, os.Args[2])
+	case "describe":
+		if len(os.Args) < 3 {
+			fmt.Fprintf(os.Stderr, "Error: 'describe' requires a test ID\n")
+			fmt.Fprintf(os.Stderr, "Usage: edgectl-e2e describe <test-id>\n")
+			os.Exit(1)
+		}
+		cmdDescribe(execCtx, prov, artifactStoreDir, os.Args[2])
 	case "run":
+		testID, followLogs, err := parseRunArgs(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Usage: edgectl-e2e run [--follow-logs] <test-id>\n")
+			os.Exit(1)
+		}
+		cmdRun(execCtx, prov, artifactStoreDir, testID, followLogs)
+	case "health":
 		if len(os.Args) < 3 {
-			fmt.Fprintf(os.Stderr, "Error: 'run' requires a test ID\n")
-			fmt.Fprintf(os.Stderr, "Usage: edgectl-e2e run <test-id>\n")
+			fmt.Fprintf(os.Stderr, "Error: 'health' requires a test ID\n")
+			fmt.Fprintf(os.Stderr, "Usage: edgectl-e2e health <test-id>\n")
 			os.Exit(1)
 		}
-		cmdRun(execCtx, artifactStoreDir, os.Args[2])
+		cmdHealth(execCtx, prov, artifactStoreDir, os.Args[2])
 	case "delete":
+		args, err := parseDeleteArgs(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Usage: edgectl-e2e delete <test-id> | --all [--yes]\n")
+			os.Exit(1)
+		}
+		if args.all {
+			cmdDeleteAll(execCtx, prov, artifactStoreDir, args.yes, maxVMs)
+			return
+		}
+		cmdDelete(execCtx, prov, artifactStoreDir, args.testID)
+	case "rotate-keys":
 		if len(os.Args) < 3 {
-			fmt.Fprintf(os.Stderr, "Error: 'delete' requires a test ID\n")
-			fmt.Fprintf(os.Stderr, "Usage: edgectl-e2e delete <test-id>\n")
+			fmt.Fprintf(os.Stderr, "Error: 'rotate-keys' requires a test ID\n")
+			fmt.Fprintf(os.Stderr, "Usage: edgectl-e2e rotate-keys <test-id>\n")
 			os.Exit(1)
 		}
-		cmdDelete(execCtx, artifactStoreDir, os.Args[2])
+		cmdRotateKeys(execCtx, prov, artifactStoreDir, os.Args[2])
 	case "list":
 		cmdList(execCtx, artifactStoreDir)
 	case "logs":
@@ -105,7 +190,13 @@ Examples:
 		}
 		cmdLogs(execCtx, artifactStoreDir, os.Args[2], os.Args[3])
 	case "test":
-		cmdTest(execCtx, artifactStoreDir)
+		parallel, err := parseTestArgs(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Usage: edgectl-e2e test [--parallel N]\n")
+			os.Exit(1)
+		}
+		cmdTest(execCtx, prov, artifactStoreDir, parallel)
 	case "-h", "--help", "help":
 		fs.Usage()
 		os.Exit(0)
@@ -124,6 +215,20 @@ func getArtifactDir() string {
 	return filepath.Join(os.ExpandEnv("$HOME"), ".edge-cd", "e2e")
 }
 
+// getMaxConcurrentVMs returns the number of VMs that may be created at the same time
+func getMaxConcurrentVMs() (int, error) {
+	value := os.Getenv("E2E_MAX_CONCURRENT_VMS")
+	if value == "" {
+		return vmm.DefaultMaxConcurrentCreations, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, flaterrors.Join(fmt.Errorf("value=%q: must be a positive integer", value), errInvalidMaxVMs)
+	}
+	return n, nil
+}
+
 // getEdgeCDRepoPath returns the path to the edge-cd repository
 func getEdgeCDRepoPath() string {
 	// Try to find the repo root relative to current directory
@@ -148,40 +253,115 @@ func getEdgeCDRepoPath() string {
 	return "."
 }
 
-// cmdCreate creates and provisions a complete test environment with VMs
-func cmdCreate(
+// createEnvironment creates a test environment with VMs and saves it to the artifact store.
+// If the environment cannot be saved, it is torn down so no resources are leaked.
+func createEnvironment(
 	execCtx execcontext.Context,
+	prov EnvironmentProvisioner,
 	artifactStoreDir string,
-) {
+) (*te2e.TestEnvironment, error) {
+	return newOneShotRun(prov, artifactStoreDir).createEnvironment(execCtx)
+}
+
+// oneShotRun is one create → run → delete cycle of the test command.
+type oneShotRun struct {
+	prov             EnvironmentProvisioner
+	artifactStoreDir string
+	// store is shared by parallel runs, so that they do not overwrite each other's environments
+	store *te2e.JSONArtifactStore
+	// isolatedNetwork attaches the VMs to a dedicated network, so that parallel runs do not collide
+	isolatedNetwork bool
+	// binaryPath is the edgectl binary to test. It is built by the run if empty.
+	binaryPath string
+	// prefix tells apart the progress messages of parallel runs
+	prefix string
+}
+
+func newOneShotRun(prov EnvironmentProvisioner, artifactStoreDir string) oneShotRun {
+	return oneShotRun{
+		prov:             prov,
+		artifactStoreDir: artifactStoreDir,
+		store:            te2e.NewJSONArtifactStore(filepath.Join(artifactStoreDir, "artifacts.json")),
+	}
+}
+
+func (r oneShotRun) printf(format string, a ...interface{}) {
+	fmt.Printf(r.prefix+format, a...)
+}
+
+func (r oneShotRun) createEnvironment(execCtx execcontext.Context) (*te2e.TestEnvironment, error) {
 	// Get paths
 	cacheDir := filepath.Join(os.TempDir(), "edgectl")
 	edgeCDRepoPath := getEdgeCDRepoPath()
 
 	// Setup configuration
 	setupConfig := te2e.SetupConfig{
-		ArtifactDir:    filepath.Join(artifactStoreDir, "artifacts"),
-		ImageCacheDir:  cacheDir,
-		EdgeCDRepoPath: edgeCDRepoPath,
-		DownloadImages: true,
+		ArtifactDir:     filepath.Join(r.artifactStoreDir, "artifacts"),
+		ImageCacheDir:   cacheDir,
+		EdgeCDRepoPath:  edgeCDRepoPath,
+		DownloadImages:  true,
+		ImageSHA256:     os.Getenv("E2E_IMAGE_SHA256"),
+		IsolatedNetwork: r.isolatedNetwork,
 	}
 
-	// Create test environment with VMs
-	fmt.Fprintf(os.Stderr, "Creating test environment...\n")
-	testEnv, err := te2e.SetupTestEnvironment(execCtx, setupConfig)
+	testEnv, err := r.prov.Setup(execCtx, setupConfig)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to create test environment: %v\n", err)
-		os.Exit(1)
+		return nil, flaterrors.Join(err, errCreateEnvironment)
 	}
 
 	// Save to artifact store
-	if err := os.MkdirAll(artifactStoreDir, 0o755); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to create artifact store directory: %v\n", err)
-		os.Exit(1)
+	if err := os.MkdirAll(r.artifactStoreDir, 0o755); err != nil {
+		return nil, flaterrors.Join(err, teardownAfterFailure(execCtx, r.prov, testEnv), errSaveEnvironment)
+	}
+	if err := r.store.Save(execCtx, testEnv); err != nil {
+		return nil, flaterrors.Join(err, teardownAfterFailure(execCtx, r.prov, testEnv), errSaveEnvironment)
+	}
+
+	return testEnv, nil
+}
+
+// teardownAfterFailure destroys an environment that could not be tracked in the artifact store.
+func teardownAfterFailure(
+	execCtx execcontext.Context,
+	prov EnvironmentProvisioner,
+	env *te2e.TestEnvironment,
+) error {
+	if _, err := teardownEnvironment(execCtx, prov, env); err != nil {
+		return flaterrors.Join(err, errTeardownEnvironment)
 	}
+	return nil
+}
 
-	store := te2e.NewJSONArtifactStore(filepath.Join(artifactStoreDir, "artifacts.json"))
-	if err := store.Save(execCtx, testEnv); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to save environment to artifact store: %v\n", err)
+// teardownEnvironment destroys env and returns the report of the teardown
+// phases, with the errors of the failed phases.
+func teardownEnvironment(
+	execCtx execcontext.Context,
+	prov EnvironmentProvisioner,
+	env *te2e.TestEnvironment,
+) (*te2e.TeardownReport, error) {
+	report, err := prov.Teardown(execCtx, env)
+	if err != nil {
+		return nil, err
+	}
+	return report, report.Err()
+}
+
+// defaultExecutorConfig returns the bootstrap test configuration used by the CLI.
+func defaultExecutorConfig(binaryPath string) te2e.ExecutorConfig {
+	return te2e.ExecutorConfig{EdgectlBinaryPath: binaryPath}.WithDefaults()
+}
+
+// cmdCreate creates and provisions a complete test environment with VMs
+func cmdCreate(
+	execCtx execcontext.Context,
+	prov EnvironmentProvisioner,
+	artifactStoreDir string,
+) {
+	// Create test environment with VMs
+	fmt.Fprintf(os.Stderr, "Creating test environment...\n")
+	testEnv, err := createEnvironment(execCtx, prov, artifactStoreDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
@@ -221,7 +401,13 @@ func cmdCreate(
 }
 
 // cmdRun executes bootstrap tests in an existing environment
-func cmdRun(ctx execcontext.Context, artifactStoreDir string, testID string) {
+func cmdRun(
+	ctx execcontext.Context,
+	prov EnvironmentProvisioner,
+	artifactStoreDir string,
+	testID string,
+	followLogs bool,
+) {
 	artifactStoreFile := filepath.Join(artifactStoreDir, "artifacts.json")
 	store := te2e.NewJSONArtifactStore(artifactStoreFile)
 
@@ -250,24 +436,26 @@ func cmdRun(ctx execcontext.Context, artifactStoreDir string, testID string) {
 	fmt.Printf("Git Server: %s (IP: %s)\n", env.GitServerVM.Name, env.GitServerVM.IP)
 
 	// Build edgectl binary
-	binaryPath, err := te2e.BuildEdgectlBinary("./cmd/edgectl")
+	binaryPath, err := prov.BuildEdgectl("./cmd/edgectl")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: failed to build edgectl binary: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Execute bootstrap test
-	executorConfig := te2e.ExecutorConfig{
-		EdgectlBinaryPath: binaryPath,
-		ConfigPath:        "./test/edgectl/e2e/config",
-		ConfigSpec:        "config.yaml",
-		Packages:          "git,curl,openssh-client",
-		ServiceManager:    "systemd",
-		PackageManager:    "apt",
-	}
-
 	fmt.Printf("Executing bootstrap tests...\n")
-	if err := te2e.ExecuteBootstrapTest(ctx, env, executorConfig); err != nil {
+	var logs io.Writer
+	if followLogs {
+		logs = os.Stderr
+	}
+	result, err := executeBootstrap(ctx, prov, env, defaultExecutorConfig(binaryPath), logs)
+	if len(result.Phases) > 0 {
+		fmt.Printf("\n")
+		if err := result.WriteTable(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to print bootstrap test result: %v\n", err)
+		}
+	}
+	if err != nil {
 		env.Status = "failed"
 		store.Save(ctx, env)
 		fmt.Fprintf(os.Stderr, "Error: bootstrap tests failed: %v\n", err)
@@ -285,8 +473,45 @@ func cmdRun(ctx execcontext.Context, artifactStoreDir string, testID string) {
 	}
 }
 
+// cmdHealth prints the health of a test environment and exits non-zero if any check failed
+func cmdHealth(
+	ctx execcontext.Context,
+	prov EnvironmentProvisioner,
+	artifactStoreDir string,
+	testID string,
+) {
+	artifactStoreFile := filepath.Join(artifactStoreDir, "artifacts.json")
+	store := te2e.NewJSONArtifactStore(artifactStoreFile)
+
+	env, err := store.Load(ctx, testID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to load test environment: %v\n", err)
+		os.Exit(1)
+	}
+
+	report, err := prov.CheckHealth(ctx, env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to check environment health: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := report.WriteTable(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to print health report: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !report.Healthy() {
+		os.Exit(1)
+	}
+}
+
 // cmdDelete destroys a test environment and cleans up all resources
-func cmdDelete(ctx execcontext.Context, artifactStoreDir string, testID string) {
+func cmdDelete(
+	ctx execcontext.Context,
+	prov EnvironmentProvisioner,
+	artifactStoreDir string,
+	testID string,
+) {
 	artifactStoreFile := filepath.Join(artifactStoreDir, "artifacts.json")
 	store := te2e.NewJSONArtifactStore(artifactStoreFile)
 
@@ -321,7 +546,7 @@ func cmdDelete(ctx execcontext.Context, artifactStoreDir string, testID string)
 
 	// Use the reusable teardown function with logging
 	// Important: capture the error to determine if cleanup was successful
-	teardownErr := te2e.TeardownTestEnvironmentWithLogging(ctx, env)
+	report, teardownErr := teardownEnvironment(ctx, prov, env)
 
 	// Determine deletion strategy based on teardown success
 	if teardownErr == nil {
@@ -339,7 +564,15 @@ func cmdDelete(ctx execcontext.Context, artifactStoreDir string, testID string)
 		// Do NOT delete from artifact store, instead mark as partially_deleted
 		// so the user knows cleanup was incomplete
 		fmt.Fprintf(os.Stderr, "\n⚠️  Cleanup encountered errors. Marking environment as partially_deleted.\n")
-		fmt.Fprintf(os.Stderr, "Error details:\n%v\n", teardownErr)
+		if report != nil {
+			// Show which phases failed, so the remaining resources can be cleaned up by hand
+			fmt.Fprintln(os.Stderr, "Teardown phases:")
+			if err := report.WriteTable(os.Stderr); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to write teardown report: %v\n", err)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "Error details:\n%v\n", teardownErr)
+		}
 
 		env.Status = te2e.StatusPartiallyDeleted
 		env.UpdatedAt = time.Now()
@@ -369,51 +602,138 @@ func cmdGet(ctx execcontext.Context, artifactStoreDir string, testID string) {
 		os.Exit(1)
 	}
 
-	// Display environment information
-	fmt.Fprintf(os.Stderr, "\n=== Test Environment: %s ===\n", env.ID)
-	fmt.Fprintf(os.Stderr, "Status: %s\n", env.Status)
-	fmt.Fprintf(os.Stderr, "Created: %s\n", env.CreatedAt.Format("2006-01-02 15:04:05"))
-	fmt.Fprintf(os.Stderr, "Artifacts: %s\n\n", env.ArtifactPath)
+	printEnvironment(os.Stderr, env)
+}
+
+// printEnvironment writes the stored metadata of env to w
+func printEnvironment(w io.Writer, env *te2e.TestEnvironment) {
+	fmt.Fprintf(w, "\n=== Test Environment: %s ===\n", env.ID)
+	fmt.Fprintf(w, "Status: %s\n", env.Status)
+	fmt.Fprintf(w, "Created: %s\n", env.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "Artifacts: %s\n\n", env.ArtifactPath)
 
-	fmt.Fprintf(os.Stderr, "=== Target VM ===\n")
-	fmt.Fprintf(os.Stderr, "Name: %s\n", env.TargetVM.Name)
-	fmt.Fprintf(os.Stderr, "IP: %s\n", env.TargetVM.IP)
+	fmt.Fprintf(w, "=== Target VM ===\n")
+	fmt.Fprintf(w, "Name: %s\n", env.TargetVM.Name)
+	fmt.Fprintf(w, "IP: %s\n", env.TargetVM.IP)
 	if env.TargetVM.IP != "" {
 		fmt.Fprintf(
-			os.Stderr,
+			w,
 			"SSH: ssh -i %s ubuntu@%s\n",
 			env.SSHKeys.HostKeyPath,
 			env.TargetVM.IP,
 		)
 	}
-	fmt.Fprintf(os.Stderr, "Memory: %dMiB\n", env.TargetVM.MemoryMB)
-	fmt.Fprintf(os.Stderr, "vCPUs: %d\n\n", env.TargetVM.VCPUs)
+	if env.TargetVM.CloudInitDir != "" {
+		fmt.Fprintf(w, "Cloud-init: %s\n", env.TargetVM.CloudInitDir)
+	}
+	fmt.Fprintf(w, "Memory: %dMiB\n", env.TargetVM.MemoryMB)
+	fmt.Fprintf(w, "vCPUs: %d\n\n", env.TargetVM.VCPUs)
 
-	fmt.Fprintf(os.Stderr, "=== Git Server VM ===\n")
-	fmt.Fprintf(os.Stderr, "Name: %s\n", env.GitServerVM.Name)
-	fmt.Fprintf(os.Stderr, "IP: %s\n", env.GitServerVM.IP)
+	fmt.Fprintf(w, "=== Git Server VM ===\n")
+	fmt.Fprintf(w, "Name: %s\n", env.GitServerVM.Name)
+	fmt.Fprintf(w, "IP: %s\n", env.GitServerVM.IP)
 	if env.GitServerVM.IP != "" {
 		fmt.Fprintf(
-			os.Stderr,
+			w,
 			"SSH: ssh -i %s git@%s\n",
 			env.SSHKeys.HostKeyPath,
 			env.GitServerVM.IP,
 		)
 	}
-	fmt.Fprintf(os.Stderr, "Memory: %dMiB\n", env.GitServerVM.MemoryMB)
-	fmt.Fprintf(os.Stderr, "vCPUs: %d\n\n", env.GitServerVM.VCPUs)
+	if env.GitServerVM.CloudInitDir != "" {
+		fmt.Fprintf(w, "Cloud-init: %s\n", env.GitServerVM.CloudInitDir)
+	}
+	fmt.Fprintf(w, "Memory: %dMiB\n", env.GitServerVM.MemoryMB)
+	fmt.Fprintf(w, "vCPUs: %d\n\n", env.GitServerVM.VCPUs)
 
 	if len(env.GitSSHURLs) > 0 {
-		fmt.Fprintf(os.Stderr, "=== Git Repositories ===\n")
+		fmt.Fprintf(w, "=== Git Repositories ===\n")
 		for repoName, repoURL := range env.GitSSHURLs {
-			fmt.Fprintf(os.Stderr, "%s: %s\n", repoName, repoURL)
+			fmt.Fprintf(w, "%s: %s\n", repoName, repoURL)
 		}
-		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(w, "\n")
 	}
 
-	fmt.Fprintf(os.Stderr, "=== SSH Keys ===\n")
-	fmt.Fprintf(os.Stderr, "Host Key: %s\n", env.SSHKeys.HostKeyPath)
-	fmt.Fprintf(os.Stderr, "Host Pub: %s\n", env.SSHKeys.HostKeyPubPath)
+	fmt.Fprintf(w, "=== SSH Keys ===\n")
+	fmt.Fprintf(w, "Host Key: %s\n", env.SSHKeys.HostKeyPath)
+	fmt.Fprintf(w, "Host Pub: %s\n", env.SSHKeys.HostKeyPubPath)
+}
+
+// cmdDescribe prints the stored metadata of a test environment together with
+// its live state: the current IP addresses of its VMs, their health, and the
+// edge-cd service state and applied commit on the target VM
+func cmdDescribe(
+	ctx execcontext.Context,
+	prov EnvironmentProvisioner,
+	artifactStoreDir string,
+	testID string,
+) {
+	artifactStoreFile := filepath.Join(artifactStoreDir, "artifacts.json")
+	store := te2e.NewJSONArtifactStore(artifactStoreFile)
+
+	if err := describeEnvironment(ctx, prov, store, testID, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// describeEnvironment writes the stored and the live state of the environment to w.
+// The refreshed IP addresses are shown but not saved to the store.
+func describeEnvironment(
+	ctx execcontext.Context,
+	prov EnvironmentProvisioner,
+	store te2e.ArtifactStore,
+	testID string,
+	w io.Writer,
+) error {
+	env, err := store.Load(ctx, testID)
+	if err != nil {
+		return flaterrors.Join(err, errLoadEnvironment)
+	}
+
+	desc, err := prov.Describe(ctx, env)
+	if err != nil {
+		return flaterrors.Join(err, errDescribeEnvironment)
+	}
+
+	printEnvironment(w, desc.Env)
+	fmt.Fprintf(w, "\n=== Live State ===\n")
+	if err := desc.WriteTable(w); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n=== Health ===\n")
+	return desc.Health.WriteTable(w)
+}
+
+// cmdRotateKeys replaces the host SSH key of a test environment and persists the new key paths
+func cmdRotateKeys(
+	ctx execcontext.Context,
+	prov EnvironmentProvisioner,
+	artifactStoreDir string,
+	testID string,
+) {
+	artifactStoreFile := filepath.Join(artifactStoreDir, "artifacts.json")
+	store := te2e.NewJSONArtifactStore(artifactStoreFile)
+
+	env, err := store.Load(ctx, testID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to load test environment: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Rotating SSH keys of environment: %s\n", env.ID)
+	if err := prov.RotateKeys(ctx, env); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to rotate SSH keys: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := store.Save(ctx, env); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to save new key paths: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Host Key: %s\n", env.SSHKeys.HostKeyPath)
+	fmt.Printf("Host Pub: %s\n", env.SSHKeys.HostKeyPubPath)
 }
 
 // cmdLogs displays logs for a test environment
@@ -546,85 +866,76 @@ func cmdList(ctx execcontext.Context, artifactStoreDir string) {
 	w.Flush()
 }
 
-// cmdTest runs a one-shot test (create → run → delete)
-func cmdTest(ctx execcontext.Context, artifactStoreDir string) {
-	fmt.Println("Running one-shot e2e test...")
-
-	// Get paths
-	cacheDir := filepath.Join(os.TempDir(), "edgectl")
-	edgeCDRepoPath := getEdgeCDRepoPath()
-
-	// Step 1: Create
-	fmt.Println("\n[1/3] Creating test environment...")
-	setupConfig := te2e.SetupConfig{
-		ArtifactDir:    filepath.Join(artifactStoreDir, "artifacts"),
-		ImageCacheDir:  cacheDir,
-		EdgeCDRepoPath: edgeCDRepoPath,
-		DownloadImages: true,
+// cmdTest runs a one-shot test (create → run → delete), or parallel ones if parallel > 1
+func cmdTest(ctx execcontext.Context, prov EnvironmentProvisioner, artifactStoreDir string, parallel int) {
+	var err error
+	if parallel > 1 {
+		err = runParallel(ctx, prov, artifactStoreDir, parallel)
+	} else {
+		err = runOneShot(ctx, prov, artifactStoreDir)
 	}
-
-	testEnv, err := te2e.SetupTestEnvironment(ctx, setupConfig)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to create test environment: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+}
 
-	// Save to artifact store
-	if err := os.MkdirAll(artifactStoreDir, 0o755); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to create artifact store directory: %v\n", err)
-		os.Exit(1)
+// runOneShot creates a test environment, runs the bootstrap test in it and
+// always deletes it afterwards, whether the test passed or not.
+func runOneShot(ctx execcontext.Context, prov EnvironmentProvisioner, artifactStoreDir string) error {
+	fmt.Println("Running one-shot e2e test...")
+	if _, err := newOneShotRun(prov, artifactStoreDir).run(ctx); err != nil {
+		return err
 	}
+	fmt.Println("\n✅ One-shot e2e test completed successfully!")
+	return nil
+}
 
-	store := te2e.NewJSONArtifactStore(filepath.Join(artifactStoreDir, "artifacts.json"))
-	if err := store.Save(ctx, testEnv); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to save environment to artifact store: %v\n", err)
-		os.Exit(1)
+// run creates the test environment, runs the bootstrap test in it and always
+// deletes it afterwards. It returns the ID of the environment, if it was created.
+func (r oneShotRun) run(ctx execcontext.Context) (string, error) {
+	// Step 1: Create
+	r.printf("\n[1/3] Creating test environment...\n")
+	testEnv, err := r.createEnvironment(ctx)
+	if err != nil {
+		return "", err
 	}
 
-	fmt.Printf("✓ Test environment created: %s\n", testEnv.ID)
+	r.printf("✓ Test environment created: %s\n", testEnv.ID)
 
 	// Cleanup at the end
 	defer func() {
-		fmt.Println("\n[3/3] Deleting test environment...")
-		if err := te2e.TeardownTestEnvironmentWithLogging(ctx, testEnv); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: encountered errors during cleanup: %v\n", err)
+		r.printf("\n[3/3] Deleting test environment %s...\n", testEnv.ID)
+		if _, err := teardownEnvironment(ctx, r.prov, testEnv); err != nil {
+			fmt.Fprintf(os.Stderr, "%sWarning: encountered errors during cleanup: %v\n", r.prefix, err)
 		}
-		if err := store.Delete(ctx, testEnv.ID); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to delete environment from store: %v\n", err)
+		if err := r.store.Delete(ctx, testEnv.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "%sWarning: failed to delete environment from store: %v\n", r.prefix, err)
 		}
 	}()
 
 	// Step 2: Run tests
-	fmt.Println("\n[2/3] Running tests...")
+	r.printf("\n[2/3] Running tests...\n")
 
 	// Build edgectl binary
-	binaryPath, err := te2e.BuildEdgectlBinary("./cmd/edgectl")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to build edgectl binary: %v\n", err)
-		os.Exit(1)
+	binaryPath := r.binaryPath
+	if binaryPath == "" {
+		if binaryPath, err = r.prov.BuildEdgectl("./cmd/edgectl"); err != nil {
+			return testEnv.ID, flaterrors.Join(err, errBuildEdgectl)
+		}
 	}
 
 	// Execute bootstrap test
-	executorConfig := te2e.ExecutorConfig{
-		EdgectlBinaryPath: binaryPath,
-		ConfigPath:        "./test/edgectl/e2e/config",
-		ConfigSpec:        "config.yaml",
-		Packages:          "git,curl,openssh-client",
-		ServiceManager:    "systemd",
-		PackageManager:    "apt",
-	}
-
-	if err := te2e.ExecuteBootstrapTest(ctx, testEnv, executorConfig); err != nil {
+	if _, err := r.prov.ExecuteBootstrap(ctx, testEnv, defaultExecutorConfig(binaryPath)); err != nil {
 		testEnv.Status = "failed"
-		store.Save(ctx, testEnv)
-		fmt.Fprintf(os.Stderr, "Error: bootstrap tests failed: %v\n", err)
-		os.Exit(1)
+		r.store.Save(ctx, testEnv)
+		return testEnv.ID, flaterrors.Join(err, errBootstrapTest)
 	}
 
 	testEnv.Status = "passed"
-	store.Save(ctx, testEnv)
+	r.store.Save(ctx, testEnv)
 
-	fmt.Println("\n✅ One-shot e2e test completed successfully!")
+	return testEnv.ID, nil
 }
 
 // printEnvironmentJSON prints environment as JSON for parsing by other tools
@@ -5,6 +5,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,6 +29,25 @@ func TestGetArtifactDirWithEnvVar(t *testing.T) {
 	assert.Equal(t, customDir, dir)
 }
 
+// TestGetMaxConcurrentVMs verifies the VM creation limit resolution
+func TestGetMaxConcurrentVMs(t *testing.T) {
+	t.Setenv("E2E_MAX_CONCURRENT_VMS", "")
+	n, err := getMaxConcurrentVMs()
+	assert.NoError(t, err)
+	assert.Equal(t, vmm.DefaultMaxConcurrentCreations, n)
+
+	t.Setenv("E2E_MAX_CONCURRENT_VMS", "4")
+	n, err = getMaxConcurrentVMs()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	for _, invalid := range []string{"0", "-1", "many"} {
+		t.Setenv("E2E_MAX_CONCURRENT_VMS", invalid)
+		_, err = getMaxConcurrentVMs()
+		assert.ErrorIs(t, err, errInvalidMaxVMs, "value %q", invalid)
+	}
+}
+
 // TestDebugf verifies debug logging
 func TestDebugf(t *testing.T) {
 	// Test with debug disabled
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errInvalidParallel     = errors.New("invalid --parallel")
+	errParallelTestsFailed = errors.New("parallel e2e tests failed")
+)
+
+// parseTestArgs returns the number of environments the test command runs at once.
+func parseTestArgs(args []string) (int, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	parallel := fs.Int("parallel", 1, "number of environments to create, test and delete concurrently")
+	if err := fs.Parse(args); err != nil {
+		return 0, flaterrors.Join(err, errInvalidParallel)
+	}
+	if fs.NArg() > 0 {
+		return 0, flaterrors.Join(fmt.Errorf("unexpected arguments: %v", fs.Args()), errInvalidParallel)
+	}
+	if *parallel < 1 {
+		return 0, flaterrors.Join(fmt.Errorf("value=%d: must be a positive integer", *parallel), errInvalidParallel)
+	}
+	return *parallel, nil
+}
+
+// oneShotResult is the outcome of one of the runs of `test --parallel`.
+type oneShotResult struct {
+	run   int
+	envID string
+	err   error
+}
+
+// runParallel runs n one-shot tests concurrently, each in its own environment
+// and network. The VMs are still created at most E2E_MAX_CONCURRENT_VMS at a
+// time. Every environment is deleted once its test finished, and an error is
+// returned if any of the runs failed.
+func runParallel(ctx execcontext.Context, prov EnvironmentProvisioner, artifactStoreDir string, n int) error {
+	fmt.Printf("Running %d e2e tests in parallel...\n", n)
+
+	// The binary is the same for every run
+	binaryPath, err := prov.BuildEdgectl("./cmd/edgectl")
+	if err != nil {
+		return flaterrors.Join(err, errBuildEdgectl)
+	}
+
+	// All runs track their environment in the same store
+	base := newOneShotRun(prov, artifactStoreDir)
+	base.isolatedNetwork = true
+	base.binaryPath = binaryPath
+
+	results := make([]oneShotResult, n)
+	var wg sync.WaitGroup
+	for i := range results {
+		run := base
+		run.prefix = fmt.Sprintf("[run %d] ", i+1)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			envID, err := run.run(ctx)
+			results[i] = oneShotResult{run: i + 1, envID: envID, err: err}
+		}()
+	}
+	wg.Wait()
+
+	fmt.Println()
+	writeParallelResults(os.Stdout, results)
+
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return flaterrors.Join(fmt.Errorf("%d of %d runs failed", failed, n), errParallelTestsFailed)
+	}
+
+	fmt.Printf("\n✅ %d e2e tests completed successfully!\n", n)
+	return nil
+}
+
+// writeParallelResults writes the outcome of each run as an aligned table.
+func writeParallelResults(w io.Writer, results []oneShotResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tENVIRONMENT\tSTATUS\tERROR")
+	for _, r := range results {
+		envID, status, detail := r.envID, "passed", ""
+		if envID == "" {
+			envID = "-"
+		}
+		if r.err != nil {
+			status, detail = "failed", r.err.Error()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", r.run, envID, status, detail)
+	}
+	tw.Flush()
+}
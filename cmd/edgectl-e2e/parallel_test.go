@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	te2e "github.com/alexandremahdhaoui/edge-cd/pkg/test/e2e"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTestArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{name: "default", args: nil, want: 1},
+		{name: "parallel", args: []string{"--parallel", "3"}, want: 3},
+		{name: "parallel with equal sign", args: []string{"--parallel=2"}, want: 2},
+		{name: "zero", args: []string{"--parallel", "0"}, wantErr: true},
+		{name: "not a number", args: []string{"--parallel", "many"}, wantErr: true},
+		{name: "unexpected argument", args: []string{"e2e-20231025-abc123"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTestArgs(tt.args)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errInvalidParallel)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// runParallelWithTimeout fails the test if the runs do not complete, e.g.
+// because they were not started concurrently and block on the bootstrap barrier.
+func runParallelWithTimeout(t *testing.T, prov *fakeProvisioner, storeDir string, n int) error {
+	done := make(chan error, 1)
+	go func() { done <- runParallel(newTestExecCtx(), prov, storeDir, n) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("parallel runs did not complete: the environments are not tested concurrently")
+		return nil
+	}
+}
+
+func countCalls(calls []string) map[string]int {
+	count := make(map[string]int)
+	for _, c := range calls {
+		count[c]++
+	}
+	return count
+}
+
+func TestRunParallel_RunsEnvironmentsConcurrently(t *testing.T) {
+	storeDir := t.TempDir()
+	barrier := &sync.WaitGroup{}
+	barrier.Add(3)
+	prov := &fakeProvisioner{bootstrapBarrier: barrier}
+
+	require.NoError(t, runParallelWithTimeout(t, prov, storeDir, 3))
+
+	// The binary is built once for all runs
+	assert.Equal(t, map[string]int{"build": 1, "setup": 3, "bootstrap": 3, "teardown": 3}, countCalls(prov.calls))
+
+	// Each run has its own network...
+	require.Len(t, prov.setupConfigs, 3)
+	for _, config := range prov.setupConfigs {
+		assert.True(t, config.IsolatedNetwork)
+	}
+
+	// ...and every environment is deleted
+	assert.ElementsMatch(t, []string{
+		"e2e-20231025-fake",
+		"e2e-20231025-fake-2",
+		"e2e-20231025-fake-3",
+	}, prov.tornDownIDs)
+	envs, err := te2e.NewJSONArtifactStore(filepath.Join(storeDir, "artifacts.json")).ListAll(newTestExecCtx())
+	require.NoError(t, err)
+	assert.Empty(t, envs)
+}
+
+func TestRunParallel_FailsIfAnyRunFails(t *testing.T) {
+	storeDir := t.TempDir()
+	prov := &fakeProvisioner{
+		bootstrapErrs: map[string]error{"e2e-20231025-fake-2": errors.New("reconcile timed out")},
+	}
+
+	err := runParallelWithTimeout(t, prov, storeDir, 3)
+
+	assert.ErrorIs(t, err, errParallelTestsFailed)
+	assert.Contains(t, err.Error(), "1 of 3 runs failed")
+
+	// The failed environment is cleaned up like the others
+	assert.Len(t, prov.tornDownIDs, 3)
+	envs, err := te2e.NewJSONArtifactStore(filepath.Join(storeDir, "artifacts.json")).ListAll(newTestExecCtx())
+	require.NoError(t, err)
+	assert.Empty(t, envs)
+}
+
+func TestRunParallel_BuildFailure(t *testing.T) {
+	buildErr := errors.New("go build failed")
+	prov := &fakeProvisioner{buildErr: buildErr}
+
+	err := runParallel(newTestExecCtx(), prov, t.TempDir(), 3)
+
+	assert.ErrorIs(t, err, errBuildEdgectl)
+	assert.Equal(t, []string{"build"}, prov.calls, "no environment is created without a binary")
+}
+
+func TestWriteParallelResults(t *testing.T) {
+	var out bytes.Buffer
+	writeParallelResults(&out, []oneShotResult{
+		{run: 1, envID: "e2e-20231025-fake"},
+		{run: 2, err: errCreateEnvironment},
+	})
+
+	assert.Regexp(t, `1\s+e2e-20231025-fake\s+passed`, out.String())
+	assert.Regexp(t, `2\s+-\s+failed\s+failed to create test environment`, out.String())
+}
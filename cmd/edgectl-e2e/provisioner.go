@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	te2e "github.com/alexandremahdhaoui/edge-cd/pkg/test/e2e"
+)
+
+// EnvironmentProvisioner performs the operations the CLI commands need to
+// manage a test environment. The real implementation drives libvirt VMs, which
+// allows the command orchestration to be tested against a fake.
+type EnvironmentProvisioner interface {
+	// Setup creates and provisions a new test environment.
+	Setup(ctx execcontext.Context, config te2e.SetupConfig) (*te2e.TestEnvironment, error)
+	// Import registers an existing libvirt domain as the target VM of a new environment.
+	Import(ctx execcontext.Context, config te2e.ImportConfig) (*te2e.TestEnvironment, error)
+	// BuildEdgectl builds the edgectl binary and returns its path.
+	BuildEdgectl(sourceDir string) (string, error)
+	// ExecuteBootstrap runs the bootstrap test in an existing environment and
+	// reports the outcome and duration of each phase, whether the test passed or not.
+	ExecuteBootstrap(ctx execcontext.Context, env *te2e.TestEnvironment, config te2e.ExecutorConfig) (*te2e.BootstrapTestResult, error)
+	// FollowServiceLogs writes the logs of the edge-cd service on the target VM
+	// to w as they are produced, until ctx is cancelled.
+	FollowServiceLogs(ctx context.Context, env *te2e.TestEnvironment, w io.Writer) error
+	// Teardown destroys all resources of a test environment and reports the
+	// result of each teardown phase. The error is only returned if env is invalid.
+	Teardown(ctx execcontext.Context, env *te2e.TestEnvironment) (*te2e.TeardownReport, error)
+	// RotateKeys replaces the host SSH key of an existing environment.
+	RotateKeys(ctx execcontext.Context, env *te2e.TestEnvironment) error
+	// CheckHealth checks the VMs and the git server of an environment are reachable.
+	CheckHealth(ctx execcontext.Context, env *te2e.TestEnvironment) (*te2e.HealthReport, error)
+	// Describe reads the live state of an environment: the current IP addresses
+	// of its VMs, their health, and the edge-cd service state on the target VM.
+	Describe(ctx execcontext.Context, env *te2e.TestEnvironment) (*te2e.Description, error)
+}
+
+// NewEnvironmentProvisioner returns the provisioner backed by the e2e test harness.
+func NewEnvironmentProvisioner() EnvironmentProvisioner {
+	return &provisioner{}
+}
+
+type provisioner struct{}
+
+func (p *provisioner) Setup(
+	ctx execcontext.Context,
+	config te2e.SetupConfig,
+) (*te2e.TestEnvironment, error) {
+	return te2e.SetupTestEnvironment(ctx, config)
+}
+
+func (p *provisioner) Import(
+	ctx execcontext.Context,
+	config te2e.ImportConfig,
+) (*te2e.TestEnvironment, error) {
+	return te2e.ImportEnvironment(ctx, config)
+}
+
+func (p *provisioner) BuildEdgectl(sourceDir string) (string, error) {
+	return te2e.BuildEdgectlBinary(sourceDir)
+}
+
+func (p *provisioner) ExecuteBootstrap(
+	ctx execcontext.Context,
+	env *te2e.TestEnvironment,
+	config te2e.ExecutorConfig,
+) (*te2e.BootstrapTestResult, error) {
+	return te2e.ExecuteBootstrapTest(ctx, env, config)
+}
+
+func (p *provisioner) FollowServiceLogs(
+	ctx context.Context,
+	env *te2e.TestEnvironment,
+	w io.Writer,
+) error {
+	return te2e.FollowServiceLogs(ctx, env, w)
+}
+
+func (p *provisioner) Teardown(
+	ctx execcontext.Context,
+	env *te2e.TestEnvironment,
+) (*te2e.TeardownReport, error) {
+	return te2e.TeardownTestEnvironmentWithReport(ctx, env)
+}
+
+func (p *provisioner) RotateKeys(ctx execcontext.Context, env *te2e.TestEnvironment) error {
+	return te2e.RotateKeys(ctx, env)
+}
+
+func (p *provisioner) CheckHealth(
+	ctx execcontext.Context,
+	env *te2e.TestEnvironment,
+) (*te2e.HealthReport, error) {
+	return te2e.CheckHealth(ctx, env)
+}
+
+func (p *provisioner) Describe(
+	ctx execcontext.Context,
+	env *te2e.TestEnvironment,
+) (*te2e.Description, error) {
+	return te2e.Describe(ctx, env)
+}
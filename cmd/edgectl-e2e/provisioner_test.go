@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	te2e "github.com/alexandremahdhaoui/edge-cd/pkg/test/e2e"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ EnvironmentProvisioner = (*fakeProvisioner)(nil)
+
+// fakeProvisioner records the operations called on it and fails with the configured errors.
+// It is safe for concurrent use, as parallel runs share it.
+type fakeProvisioner struct {
+	mu    sync.Mutex
+	calls []string
+
+	setupErr     error
+	importErr    error
+	buildErr     error
+	bootstrapErr error
+	teardownErr  error
+
+	// storedDuringBootstrap captures the environment found in the store while bootstrapping
+	storeFile             string
+	storedDuringBootstrap *te2e.TestEnvironment
+	tornDown              *te2e.TestEnvironment
+
+	// setups counts the created environments, so that each gets its own ID
+	setups       int
+	setupConfigs []te2e.SetupConfig
+	tornDownIDs  []string
+	// bootstrapErrs fails the bootstrap of the environments with the given IDs
+	bootstrapErrs map[string]error
+	// bootstrapBarrier, if set, blocks each bootstrap until all its counted bootstraps started
+	bootstrapBarrier *sync.WaitGroup
+
+	// liveIPs are the IP addresses reported by Describe, keyed by VM name
+	liveIPs     map[string]string
+	describeErr error
+
+	// teardownErrs fails the teardown of the environments with the given IDs
+	teardownErrs map[string]error
+	// teardownDelay makes each teardown last long enough to overlap with the others
+	teardownDelay       time.Duration
+	teardownsRunning    int
+	maxTeardownsRunning int
+
+	// serviceLogs is written by FollowServiceLogs before it waits to be stopped
+	serviceLogs string
+	followErr   error
+}
+
+func (f *fakeProvisioner) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeProvisioner) Setup(
+	ctx execcontext.Context,
+	config te2e.SetupConfig,
+) (*te2e.TestEnvironment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "setup")
+	if f.setupErr != nil {
+		return nil, f.setupErr
+	}
+	f.setups++
+	f.setupConfigs = append(f.setupConfigs, config)
+	id := "e2e-20231025-fake"
+	if f.setups > 1 {
+		id = fmt.Sprintf("%s-%d", id, f.setups)
+	}
+	return &te2e.TestEnvironment{
+		ID:          id,
+		Status:      "created",
+		TargetVM:    vmm.VMMetadata{Name: "target", IP: "192.168.1.100"},
+		GitServerVM: vmm.VMMetadata{Name: "gitserver", IP: "192.168.1.101"},
+	}, nil
+}
+
+func (f *fakeProvisioner) Import(
+	ctx execcontext.Context,
+	config te2e.ImportConfig,
+) (*te2e.TestEnvironment, error) {
+	f.record("import")
+	if f.importErr != nil {
+		return nil, f.importErr
+	}
+	return &te2e.TestEnvironment{
+		ID:           "e2e-20231025-imported",
+		Status:       te2e.StatusCreated,
+		TargetVM:     vmm.VMMetadata{Name: config.DomainName, IP: "192.168.1.110"},
+		KeepTargetVM: !config.Own,
+	}, nil
+}
+
+func (f *fakeProvisioner) BuildEdgectl(sourceDir string) (string, error) {
+	f.record("build")
+	if f.buildErr != nil {
+		return "", f.buildErr
+	}
+	return "/tmp/edgectl-fake", nil
+}
+
+func (f *fakeProvisioner) ExecuteBootstrap(
+	ctx execcontext.Context,
+	env *te2e.TestEnvironment,
+	config te2e.ExecutorConfig,
+) (*te2e.BootstrapTestResult, error) {
+	f.record("bootstrap")
+	if f.bootstrapBarrier != nil {
+		f.bootstrapBarrier.Done()
+		f.bootstrapBarrier.Wait()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.storeFile != "" {
+		f.storedDuringBootstrap, _ = te2e.NewJSONArtifactStore(f.storeFile).Load(ctx, env.ID)
+	}
+	result := &te2e.BootstrapTestResult{EnvID: env.ID}
+	if err, ok := f.bootstrapErrs[env.ID]; ok {
+		return result, err
+	}
+	return result, f.bootstrapErr
+}
+
+func (f *fakeProvisioner) FollowServiceLogs(
+	ctx context.Context,
+	env *te2e.TestEnvironment,
+	w io.Writer,
+) error {
+	f.record("follow-logs")
+	if f.followErr != nil {
+		return f.followErr
+	}
+	io.WriteString(w, f.serviceLogs)
+	<-ctx.Done()
+	f.record("follow-logs-stopped")
+	return nil
+}
+
+func (f *fakeProvisioner) Teardown(
+	ctx execcontext.Context,
+	env *te2e.TestEnvironment,
+) (*te2e.TeardownReport, error) {
+	f.mu.Lock()
+	f.teardownsRunning++
+	f.maxTeardownsRunning = max(f.maxTeardownsRunning, f.teardownsRunning)
+	f.mu.Unlock()
+
+	time.Sleep(f.teardownDelay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.teardownsRunning--
+	f.calls = append(f.calls, "teardown")
+	f.tornDown = env
+	f.tornDownIDs = append(f.tornDownIDs, env.ID)
+	// teardownErr fails the VM phase, as libvirt would
+	teardownErr := f.teardownErr
+	if err, ok := f.teardownErrs[env.ID]; ok {
+		teardownErr = err
+	}
+	report := &te2e.TeardownReport{EnvID: env.ID, Phases: []te2e.TeardownPhaseResult{
+		{Phase: te2e.TeardownPhaseDestroyVMs, Targets: []string{env.TargetVM.Name}, Err: teardownErr},
+	}}
+	return report, nil
+}
+
+func (f *fakeProvisioner) RotateKeys(ctx execcontext.Context, env *te2e.TestEnvironment) error {
+	f.record("rotate-keys")
+	return nil
+}
+
+func (f *fakeProvisioner) CheckHealth(
+	ctx execcontext.Context,
+	env *te2e.TestEnvironment,
+) (*te2e.HealthReport, error) {
+	f.record("health")
+	return &te2e.HealthReport{EnvID: env.ID}, nil
+}
+
+func (f *fakeProvisioner) Describe(
+	ctx execcontext.Context,
+	env *te2e.TestEnvironment,
+) (*te2e.Description, error) {
+	f.record("describe")
+	if f.describeErr != nil {
+		return nil, f.describeErr
+	}
+
+	// The VMs are reachable at the live IPs, if any
+	live := *env
+	desc := &te2e.Description{
+		Env:          &live,
+		TargetVM:     te2e.LiveVM{Name: env.TargetVM.Name, State: "running", StoredIP: env.TargetVM.IP, IP: env.TargetVM.IP},
+		GitServerVM:  te2e.LiveVM{Name: env.GitServerVM.Name, State: "running", StoredIP: env.GitServerVM.IP, IP: env.GitServerVM.IP},
+		Health:       &te2e.HealthReport{EnvID: env.ID},
+		ServiceState: "active",
+	}
+	if ip, ok := f.liveIPs[env.TargetVM.Name]; ok {
+		desc.TargetVM.IP, live.TargetVM.IP = ip, ip
+	}
+	if ip, ok := f.liveIPs[env.GitServerVM.Name]; ok {
+		desc.GitServerVM.IP, live.GitServerVM.IP = ip, ip
+	}
+	return desc, nil
+}
+
+func newTestExecCtx() execcontext.Context {
+	return execcontext.New(make(map[string]string), []string{})
+}
+
+func TestRunOneShot_Success(t *testing.T) {
+	storeDir := t.TempDir()
+	storeFile := filepath.Join(storeDir, "artifacts.json")
+	ctx := newTestExecCtx()
+	prov := &fakeProvisioner{storeFile: storeFile}
+
+	require.NoError(t, runOneShot(ctx, prov, storeDir))
+
+	assert.Equal(t, []string{"setup", "build", "bootstrap", "teardown"}, prov.calls)
+
+	// The environment is tracked in the store while the test runs...
+	require.NotNil(t, prov.storedDuringBootstrap)
+	assert.Equal(t, "e2e-20231025-fake", prov.storedDuringBootstrap.ID)
+
+	// ...and removed once it has been torn down
+	require.NotNil(t, prov.tornDown)
+	assert.Equal(t, "passed", prov.tornDown.Status)
+	envs, err := te2e.NewJSONArtifactStore(storeFile).ListAll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, envs)
+}
+
+func TestRunOneShot_SetupFailure(t *testing.T) {
+	storeDir := t.TempDir()
+	setupErr := errors.New("no libvirt")
+	prov := &fakeProvisioner{setupErr: setupErr}
+
+	err := runOneShot(newTestExecCtx(), prov, storeDir)
+
+	assert.ErrorIs(t, err, setupErr)
+	assert.ErrorIs(t, err, errCreateEnvironment)
+	// Nothing was created, so nothing is run or torn down
+	assert.Equal(t, []string{"setup"}, prov.calls)
+}
+
+func TestRunOneShot_BuildFailureCleansUp(t *testing.T) {
+	storeDir := t.TempDir()
+	ctx := newTestExecCtx()
+	buildErr := errors.New("go build failed")
+	prov := &fakeProvisioner{buildErr: buildErr}
+
+	err := runOneShot(ctx, prov, storeDir)
+
+	assert.ErrorIs(t, err, buildErr)
+	assert.ErrorIs(t, err, errBuildEdgectl)
+	assert.Equal(t, []string{"setup", "build", "teardown"}, prov.calls)
+
+	envs, err := te2e.NewJSONArtifactStore(filepath.Join(storeDir, "artifacts.json")).ListAll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, envs)
+}
+
+func TestRunOneShot_BootstrapFailureCleansUp(t *testing.T) {
+	storeDir := t.TempDir()
+	ctx := newTestExecCtx()
+	bootstrapErr := errors.New("edge-cd did not converge")
+	prov := &fakeProvisioner{bootstrapErr: bootstrapErr}
+
+	err := runOneShot(ctx, prov, storeDir)
+
+	assert.ErrorIs(t, err, bootstrapErr)
+	assert.ErrorIs(t, err, errBootstrapTest)
+	assert.Equal(t, []string{"setup", "build", "bootstrap", "teardown"}, prov.calls)
+
+	require.NotNil(t, prov.tornDown)
+	assert.Equal(t, "failed", prov.tornDown.Status)
+
+	envs, err := te2e.NewJSONArtifactStore(filepath.Join(storeDir, "artifacts.json")).ListAll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, envs)
+}
+
+func TestRunOneShot_TeardownFailureIsNotFatal(t *testing.T) {
+	storeDir := t.TempDir()
+	prov := &fakeProvisioner{teardownErr: errors.New("domain busy")}
+
+	// The test itself passed: cleanup errors are only reported as warnings
+	require.NoError(t, runOneShot(newTestExecCtx(), prov, storeDir))
+	assert.Equal(t, []string{"setup", "build", "bootstrap", "teardown"}, prov.calls)
+}
+
+func TestTeardownEnvironment_ReportsFailedPhases(t *testing.T) {
+	prov := &fakeProvisioner{teardownErr: errors.New("domain busy")}
+	env := &te2e.TestEnvironment{ID: "e2e-20231025-fake", TargetVM: vmm.VMMetadata{Name: "target"}}
+
+	report, err := teardownEnvironment(newTestExecCtx(), prov, env)
+
+	require.Error(t, err)
+	require.NotNil(t, report)
+	assert.Equal(t, []string{te2e.TeardownPhaseDestroyVMs}, report.FailedPhases())
+	assert.Contains(t, err.Error(), "domain busy")
+}
+
+func TestCreateEnvironment_SavesToStore(t *testing.T) {
+	storeDir := filepath.Join(t.TempDir(), "nested")
+	ctx := newTestExecCtx()
+	prov := &fakeProvisioner{}
+
+	env, err := createEnvironment(ctx, prov, storeDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"setup"}, prov.calls)
+
+	loaded, err := te2e.NewJSONArtifactStore(filepath.Join(storeDir, "artifacts.json")).Load(ctx, env.ID)
+	require.NoError(t, err)
+	assert.Equal(t, env.TargetVM.Name, loaded.TargetVM.Name)
+}
+
+func TestCreateEnvironment_TearsDownWhenStoreUnavailable(t *testing.T) {
+	// The artifact store directory is a regular file, so the environment cannot be saved
+	storeDir := filepath.Join(t.TempDir(), "artifacts.json")
+	require.NoError(t, te2e.NewJSONArtifactStore(storeDir).Save(newTestExecCtx(), &te2e.TestEnvironment{ID: "other"}))
+	prov := &fakeProvisioner{}
+
+	_, err := createEnvironment(newTestExecCtx(), prov, storeDir)
+
+	assert.ErrorIs(t, err, errSaveEnvironment)
+	assert.Equal(t, []string{"setup", "teardown"}, prov.calls)
+}
+
+func TestDescribeEnvironment_ShowsLiveIP(t *testing.T) {
+	ctx := newTestExecCtx()
+	store := te2e.NewJSONArtifactStore(filepath.Join(t.TempDir(), "artifacts.json"))
+	env := &te2e.TestEnvironment{
+		ID:          "e2e-20231025-describe",
+		TargetVM:    vmm.VMMetadata{Name: "target", IP: "192.168.1.100"},
+		GitServerVM: vmm.VMMetadata{Name: "gitserver", IP: "192.168.1.101"},
+	}
+	require.NoError(t, store.Save(ctx, env))
+
+	// The target VM got a new IP address after a reboot
+	prov := &fakeProvisioner{liveIPs: map[string]string{"target": "192.168.1.200"}}
+
+	var out bytes.Buffer
+	require.NoError(t, describeEnvironment(ctx, prov, store, env.ID, &out))
+
+	assert.Equal(t, []string{"describe"}, prov.calls)
+	assert.Contains(t, out.String(), "IP: 192.168.1.200")
+	assert.NotContains(t, out.String(), "IP: 192.168.1.100")
+	assert.Contains(t, out.String(), "192.168.1.200 (changed)")
+	assert.Contains(t, out.String(), "Service: active")
+
+	// The refreshed IP is not saved
+	stored, err := store.Load(ctx, env.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.100", stored.TargetVM.IP)
+}
+
+func TestDescribeEnvironment_Failure(t *testing.T) {
+	ctx := newTestExecCtx()
+	store := te2e.NewJSONArtifactStore(filepath.Join(t.TempDir(), "artifacts.json"))
+	require.NoError(t, store.Save(ctx, &te2e.TestEnvironment{ID: "e2e-20231025-describe"}))
+
+	prov := &fakeProvisioner{describeErr: errors.New("libvirt unavailable")}
+
+	err := describeEnvironment(ctx, prov, store, "e2e-20231025-describe", io.Discard)
+	assert.ErrorIs(t, err, errDescribeEnvironment)
+
+	err = describeEnvironment(ctx, prov, store, "e2e-20231025-unknown", io.Discard)
+	assert.ErrorIs(t, err, errLoadEnvironment)
+}
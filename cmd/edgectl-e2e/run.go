@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	te2e "github.com/alexandremahdhaoui/edge-cd/pkg/test/e2e"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var errInvalidRunArgs = errors.New("invalid run arguments")
+
+// parseRunArgs returns the test ID and whether the logs of edge-cd are followed
+// by the run command: [--follow-logs] <test-id>.
+func parseRunArgs(args []string) (string, bool, error) {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	followLogs := fs.Bool("follow-logs", false, "tail the edge-cd logs of the target VM while the tests run")
+	if err := fs.Parse(args); err != nil {
+		return "", false, flaterrors.Join(err, errInvalidRunArgs)
+	}
+	if fs.NArg() != 1 {
+		return "", false, flaterrors.Join(
+			fmt.Errorf("expected one test ID, got %v", fs.Args()), errInvalidRunArgs)
+	}
+	return fs.Arg(0), *followLogs, nil
+}
+
+// executeBootstrap runs the bootstrap test in env. If followLogs is not nil,
+// the logs of the edge-cd service on the target VM are written to it while the
+// test runs. Following the logs is stopped before returning, and failing to
+// follow them does not fail the test.
+func executeBootstrap(
+	ctx execcontext.Context,
+	prov EnvironmentProvisioner,
+	env *te2e.TestEnvironment,
+	config te2e.ExecutorConfig,
+	followLogs io.Writer,
+) (*te2e.BootstrapTestResult, error) {
+	if followLogs == nil {
+		return prov.ExecuteBootstrap(ctx, env, config)
+	}
+
+	followCtx, stopFollowing := context.WithCancel(context.Background())
+	followed := make(chan struct{})
+	go func() {
+		defer close(followed)
+		if err := prov.FollowServiceLogs(followCtx, env, followLogs); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to follow edge-cd service logs: %v\n", err)
+		}
+	}()
+	defer func() {
+		stopFollowing()
+		<-followed
+	}()
+
+	return prov.ExecuteBootstrap(ctx, env, config)
+}
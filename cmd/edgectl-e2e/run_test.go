@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	te2e "github.com/alexandremahdhaoui/edge-cd/pkg/test/e2e"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRunArgs(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		wantID         string
+		wantFollowLogs bool
+		wantErr        bool
+	}{
+		{name: "test ID only", args: []string{"e2e-20231025-abc123"}, wantID: "e2e-20231025-abc123"},
+		{
+			name:           "follow logs",
+			args:           []string{"--follow-logs", "e2e-20231025-abc123"},
+			wantID:         "e2e-20231025-abc123",
+			wantFollowLogs: true,
+		},
+		{name: "missing test ID", args: []string{"--follow-logs"}, wantErr: true},
+		{name: "two test IDs", args: []string{"e2e-20231025-abc123", "e2e-20231025-def456"}, wantErr: true},
+		{name: "unknown flag", args: []string{"--follow", "e2e-20231025-abc123"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, followLogs, err := parseRunArgs(tt.args)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errInvalidRunArgs)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantID, id)
+			assert.Equal(t, tt.wantFollowLogs, followLogs)
+		})
+	}
+}
+
+func TestExecuteBootstrap_FollowLogs(t *testing.T) {
+	env := &te2e.TestEnvironment{
+		ID:       "e2e-20231025-fake",
+		TargetVM: vmm.VMMetadata{Name: "target", IP: "192.168.1.100"},
+	}
+	logLine := "time=2025-01-01T00:00:00Z level=INFO msg=\"Reconciling files\"\n"
+
+	t.Run("should follow the logs while the test runs", func(t *testing.T) {
+		prov := &fakeProvisioner{serviceLogs: logLine}
+		var logs bytes.Buffer
+
+		_, err := executeBootstrap(newTestExecCtx(), prov, env, defaultExecutorConfig("/tmp/edgectl-fake"), &logs)
+		require.NoError(t, err)
+
+		// Following the logs is stopped once the test finished, before returning
+		assert.ElementsMatch(t, []string{"follow-logs", "bootstrap", "follow-logs-stopped"}, prov.calls)
+		assert.Equal(t, "follow-logs-stopped", prov.calls[len(prov.calls)-1])
+		assert.Equal(t, logLine, logs.String())
+	})
+
+	t.Run("should stop following the logs when the test fails", func(t *testing.T) {
+		prov := &fakeProvisioner{serviceLogs: logLine, bootstrapErr: errors.New("bootstrap failed")}
+
+		_, err := executeBootstrap(newTestExecCtx(), prov, env, defaultExecutorConfig("/tmp/edgectl-fake"), &bytes.Buffer{})
+		require.ErrorIs(t, err, prov.bootstrapErr)
+		assert.Equal(t, "follow-logs-stopped", prov.calls[len(prov.calls)-1])
+	})
+
+	t.Run("should not fail the test when the logs cannot be followed", func(t *testing.T) {
+		prov := &fakeProvisioner{followErr: errors.New("connection refused")}
+
+		_, err := executeBootstrap(newTestExecCtx(), prov, env, defaultExecutorConfig("/tmp/edgectl-fake"), &bytes.Buffer{})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"follow-logs", "bootstrap"}, prov.calls)
+	})
+
+	t.Run("should not follow the logs by default", func(t *testing.T) {
+		prov := &fakeProvisioner{}
+
+		_, err := executeBootstrap(newTestExecCtx(), prov, env, defaultExecutorConfig("/tmp/edgectl-fake"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bootstrap"}, prov.calls)
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -8,11 +9,17 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/apply"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/doctor"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/inventory"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/logs"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/provision"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
 	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
 )
 
@@ -27,6 +34,15 @@ var (
 	errRenderConfig        = errors.New("failed to render config template")
 	errPlaceConfig         = errors.New("failed to place config.yaml")
 	errSetupService        = errors.New("failed to setup edge-cd service")
+	errSetupServiceUser    = errors.New("failed to setup edge-cd service user")
+	errFetchInventory      = errors.New("failed to fetch inventory")
+	errApplyPaths          = errors.New("failed to apply files")
+	errCheckPrivileges     = errors.New("privilege preflight check failed")
+	errStreamLogs          = errors.New("failed to stream logs")
+	errWriteOutputDir      = errors.New("failed to write rendered files to the output directory")
+	errInvalidInjectEnv    = errors.New("invalid --inject-env, expected KEY=value")
+
+	errConflictingGitSSHCommand = errors.New("--inject-env cannot set GIT_SSH_COMMAND with --git-ssh-key or --ssh-opts")
 )
 
 func main() {
@@ -37,6 +53,10 @@ func main() {
 		fmt.Fprintf(rootCmd.Output(), "  %s <command> [arguments]\n", os.Args[0])
 		fmt.Fprintf(rootCmd.Output(), "The commands are:\n")
 		fmt.Fprintf(rootCmd.Output(), "  bootstrap   Bootstrap an edge device\n")
+		fmt.Fprintf(rootCmd.Output(), "  inventory   Print a JSON inventory of an edge device's current state\n")
+		fmt.Fprintf(rootCmd.Output(), "  apply       Reconcile only the given files of an edge device\n")
+		fmt.Fprintf(rootCmd.Output(), "  logs        Print or follow the edge-cd service logs of an edge device\n")
+		fmt.Fprintf(rootCmd.Output(), "  doctor      Check the local prerequisites of the commands\n")
 		rootCmd.PrintDefaults()
 	}
 
@@ -119,10 +139,31 @@ func main() {
 			"/usr/local/src/edge-cd-config",
 			"Destination path for user config repository on target device",
 		)
-		injectEnv := bootstrapCmd.String(
+		var injectEnv envFlag
+		bootstrapCmd.Var(
+			&injectEnv,
 			"inject-env",
+			"Environment variable to inject to target, repeatable (e.g., 'GIT_SSH_COMMAND=ssh -o StrictHostKeyChecking=no')",
+		)
+		gitSSHKey := bootstrapCmd.String(
+			"git-ssh-key",
+			"",
+			"Path on the target device to the SSH private key of the git operations (sets GIT_SSH_COMMAND)",
+		)
+		sshOpts := bootstrapCmd.String(
+			"ssh-opts",
 			"",
-			"Environment variables to inject to target (e.g., 'GIT_SSH_COMMAND=ssh -o StrictHostKeyChecking=no')",
+			"Comma-separated ssh options of the git operations on the target device (e.g., 'StrictHostKeyChecking=yes,UserKnownHostsFile=/etc/edge-cd/known_hosts'), sets GIT_SSH_COMMAND",
+		)
+		outputDir := bootstrapCmd.String(
+			"output-dir",
+			"",
+			"Local directory to write the rendered config.yaml and service file to before placing them on the target (default: disabled)",
+		)
+		validateCmd := bootstrapCmd.String(
+			"validate-cmd",
+			"",
+			"Command validating the placed config.yaml, given as its last argument, before the service is enabled (e.g., 'edge-cd-go --validate') (default: disabled)",
 		)
 
 		bootstrapCmd.Usage = func() {
@@ -169,20 +210,23 @@ func main() {
 
 		// Create execution contexts
 		// Build environment variables map
-		targetInjectedEnvs := make(map[string]string)
-
-		// Add injected environment variables if provided
-		if *injectEnv != "" {
-			envKey, envValue := parseEnvFromFlag(*injectEnv)
-			if envKey != "" {
-				targetInjectedEnvs[envKey] = envValue
-			}
+		targetInjectedEnvs, err := bootstrapEnvs(injectEnv, *gitSSHKey, *sshOpts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			bootstrapCmd.Usage()
+			os.Exit(1)
 		}
 
 		// Create contexts using the immutable factory function
 		// targetExecCtx: for remote commands requiring privilege escalation (sudo -E)
 		targetExecCtx := execcontext.New(targetInjectedEnvs, []string{"sudo", "-E"})
 
+		// Preflight: fail before changing anything if the target user cannot use sudo
+		if err := provision.CheckPrivileges(targetExecCtx, sshClient); err != nil {
+			slog.Error("bootstrap failed", "error", flaterrors.Join(err, errCheckPrivileges).Error())
+			os.Exit(1)
+		}
+
 		// Clone edge-cd repo locally to get package manager configs
 		localEdgeCDRepoTempDir, err := os.MkdirTemp("", "edgectl-local-edge-cd-repo-")
 		if err != nil {
@@ -285,11 +329,31 @@ func main() {
 			}
 		}
 
-		if err := provision.PlaceConfigYAML(targetExecCtx, sshClient, configContent, "/etc/edge-cd/config.yaml"); err != nil {
-			slog.Error("bootstrap failed", "error", flaterrors.Join(err, errPlaceConfig).Error())
+		configDestPath := "/etc/edge-cd/config.yaml"
+		if err := placeConfig(targetExecCtx, sshClient, configContent, configDestPath, *outputDir); err != nil {
+			slog.Error("bootstrap failed", "error", err.Error())
 			os.Exit(1)
 		}
 
+		// Service User: run edge-cd as the unprivileged user of the spec, if any
+		runAs, err := provision.RunAsFromConfig(configContent)
+		if err != nil {
+			slog.Error("bootstrap failed", "error", flaterrors.Join(err, errSetupServiceUser).Error())
+			os.Exit(1)
+		}
+		if runAs != nil {
+			escalatedCommands, err := provision.EscalatedCommands(configContent, localEdgeCDRepoTempDir)
+			if err != nil {
+				slog.Error("bootstrap failed", "error", flaterrors.Join(err, errSetupServiceUser).Error())
+				os.Exit(1)
+			}
+			ownedPaths := []string{remoteEdgeCDRepoDestPath, userConfigRepoPath, "/tmp/edge-cd"}
+			if err := provision.SetupServiceUser(targetExecCtx, sshClient, *runAs, ownedPaths, escalatedCommands); err != nil {
+				slog.Error("bootstrap failed", "error", flaterrors.Join(err, errSetupServiceUser).Error())
+				os.Exit(1)
+			}
+		}
+
 		// Build service template data
 		// These environment variables will be passed to edge-cd when it runs as a service
 		serviceTemplateData := provision.ServiceTemplateData{
@@ -307,15 +371,262 @@ func main() {
 			EnvironmentVars:    []provision.EnvVar{},    // Optional: can be extended later
 			Args:               []string{},              // Optional: can be extended later
 		}
+		if runAs != nil {
+			serviceTemplateData.User = runAs.User
+			serviceTemplateData.Group = runAs.Group
+			if runAs.Escalation == userconfig.EscalationCapabilities {
+				serviceTemplateData.Capabilities = provision.ServiceCapabilities
+			}
+		}
+
+		if *outputDir != "" {
+			if err := writeServiceFile(*outputDir, localEdgeCDRepoTempDir, *serviceManager, serviceTemplateData); err != nil {
+				slog.Error("bootstrap failed", "error", err.Error())
+				os.Exit(1)
+			}
+		}
 
-		// Service Setup
-		if err := provision.SetupEdgeCDService(targetExecCtx, sshClient, *serviceManager, localEdgeCDRepoTempDir, remoteEdgeCDRepoDestPath, serviceTemplateData); err != nil {
+		// Service Setup: the placed config is validated first, if --validate-cmd is set
+		configValidation := provision.ConfigValidation{
+			Command:        strings.Fields(*validateCmd),
+			ConfigDestPath: configDestPath,
+		}
+		if err := provision.SetupEdgeCDService(targetExecCtx, sshClient, *serviceManager, localEdgeCDRepoTempDir, remoteEdgeCDRepoDestPath, serviceTemplateData, configValidation); err != nil {
 			slog.Error("bootstrap failed", "error", flaterrors.Join(err, errSetupService).Error())
 			os.Exit(1)
 		}
 
 		slog.Info("bootstrap completed successfully")
 
+	case "inventory":
+		inventoryCmd := flag.NewFlagSet("inventory", flag.ExitOnError)
+
+		targetAddr := inventoryCmd.String("target-addr", "", "Target device address (required)")
+		targetUser := inventoryCmd.String("target-user", "root", "SSH user for the target device")
+		sshPrivateKey := inventoryCmd.String(
+			"ssh-private-key",
+			"",
+			"Path to the SSH private key (required)",
+		)
+		configPath := inventoryCmd.String(
+			"config-path",
+			"",
+			"Path to the directory containing the config spec file in the config repository (required)",
+		)
+		edgeCDBinary := inventoryCmd.String(
+			"edge-cd-bin",
+			"edge-cd-go",
+			"Path to the edge-cd-go binary on the target device",
+		)
+		var injectEnv envFlag
+		inventoryCmd.Var(
+			&injectEnv,
+			"inject-env",
+			"Environment variable to inject to target, repeatable (e.g., 'CONFIG_SPEC_FILE=device.yaml')",
+		)
+
+		inventoryCmd.Usage = func() {
+			fmt.Fprintf(inventoryCmd.Output(), "Usage of %s inventory:\n", os.Args[0])
+			fmt.Fprintf(inventoryCmd.Output(), "  Print a JSON inventory of an edge device's current state.\n\n")
+			fmt.Fprintf(inventoryCmd.Output(), "Flags:\n")
+			inventoryCmd.PrintDefaults()
+		}
+		inventoryCmd.Parse(rootCmd.Args()[1:])
+
+		for flagName, value := range map[string]string{
+			"target-addr":     *targetAddr,
+			"ssh-private-key": *sshPrivateKey,
+			"config-path":     *configPath,
+		} {
+			if value == "" {
+				fmt.Fprintf(os.Stderr, "Error: --%s is required\n", flagName)
+				inventoryCmd.Usage()
+				os.Exit(1)
+			}
+		}
+
+		sshClient, err := ssh.NewClient(*targetAddr, *targetUser, *sshPrivateKey, "22")
+		if err != nil {
+			slog.Error(
+				"inventory failed",
+				"error",
+				flaterrors.Join(err, errCreateSSHClient).Error(),
+			)
+			os.Exit(1)
+		}
+
+		envs := map[string]string{"CONFIG_PATH": *configPath}
+		injectEnv.apply(envs)
+		targetExecCtx := execcontext.New(envs, []string{"sudo", "-E"})
+
+		inv, err := inventory.Fetch(targetExecCtx, sshClient, *edgeCDBinary)
+		if err != nil {
+			slog.Error("inventory failed", "error", flaterrors.Join(err, errFetchInventory).Error())
+			os.Exit(1)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(inv); err != nil {
+			slog.Error("inventory failed", "error", err.Error())
+			os.Exit(1)
+		}
+
+	case "apply":
+		applyCmd := flag.NewFlagSet("apply", flag.ExitOnError)
+
+		targetAddr := applyCmd.String("target-addr", "", "Target device address (required)")
+		targetUser := applyCmd.String("target-user", "root", "SSH user for the target device")
+		sshPrivateKey := applyCmd.String(
+			"ssh-private-key",
+			"",
+			"Path to the SSH private key (required)",
+		)
+		configPath := applyCmd.String(
+			"config-path",
+			"",
+			"Path to the directory containing the config spec file in the config repository (required)",
+		)
+		edgeCDBinary := applyCmd.String(
+			"edge-cd-bin",
+			"edge-cd-go",
+			"Path to the edge-cd-go binary on the target device",
+		)
+		var injectEnv envFlag
+		applyCmd.Var(
+			&injectEnv,
+			"inject-env",
+			"Environment variable to inject to target, repeatable (e.g., 'CONFIG_SPEC_FILE=device.yaml')",
+		)
+
+		applyCmd.Usage = func() {
+			fmt.Fprintf(applyCmd.Output(), "Usage of %s apply:\n", os.Args[0])
+			fmt.Fprintf(applyCmd.Output(), "  %s apply [flags] <dest-path>...\n", os.Args[0])
+			fmt.Fprintf(applyCmd.Output(), "  Reconcile only the files at the given destination paths of an edge device,\n")
+			fmt.Fprintf(applyCmd.Output(), "  restarting only their services, and print the JSON result.\n\n")
+			fmt.Fprintf(applyCmd.Output(), "Flags:\n")
+			applyCmd.PrintDefaults()
+		}
+		applyCmd.Parse(rootCmd.Args()[1:])
+
+		for flagName, value := range map[string]string{
+			"target-addr":     *targetAddr,
+			"ssh-private-key": *sshPrivateKey,
+			"config-path":     *configPath,
+		} {
+			if value == "" {
+				fmt.Fprintf(os.Stderr, "Error: --%s is required\n", flagName)
+				applyCmd.Usage()
+				os.Exit(1)
+			}
+		}
+		if applyCmd.NArg() == 0 {
+			fmt.Fprintf(os.Stderr, "Error: at least one destination path is required\n")
+			applyCmd.Usage()
+			os.Exit(1)
+		}
+
+		sshClient, err := ssh.NewClient(*targetAddr, *targetUser, *sshPrivateKey, "22")
+		if err != nil {
+			slog.Error(
+				"apply failed",
+				"error",
+				flaterrors.Join(err, errCreateSSHClient).Error(),
+			)
+			os.Exit(1)
+		}
+
+		envs := map[string]string{"CONFIG_PATH": *configPath}
+		injectEnv.apply(envs)
+		targetExecCtx := execcontext.New(envs, []string{"sudo", "-E"})
+
+		result, applyErr := apply.Run(targetExecCtx, sshClient, *edgeCDBinary, applyCmd.Args())
+		if result != nil {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(result); err != nil {
+				slog.Error("apply failed", "error", err.Error())
+				os.Exit(1)
+			}
+		}
+		if applyErr != nil {
+			slog.Error("apply failed", "error", flaterrors.Join(applyErr, errApplyPaths).Error())
+			os.Exit(1)
+		}
+
+	case "logs":
+		logsCmd := flag.NewFlagSet("logs", flag.ExitOnError)
+
+		targetAddr := logsCmd.String("target-addr", "", "Target device address (or pass it as argument)")
+		targetUser := logsCmd.String("target-user", "root", "SSH user for the target device")
+		sshPrivateKey := logsCmd.String(
+			"ssh-private-key",
+			"",
+			"Path to the SSH private key (required)",
+		)
+		follow := logsCmd.Bool("follow", false, "Keep printing new log lines until interrupted")
+		lines := logsCmd.Int("lines", logs.DefaultLines, "Number of last lines to print, 0 for all")
+
+		logsCmd.Usage = func() {
+			fmt.Fprintf(logsCmd.Output(), "Usage of %s logs:\n", os.Args[0])
+			fmt.Fprintf(logsCmd.Output(), "  %s logs [flags] <target-addr>\n", os.Args[0])
+			fmt.Fprintf(logsCmd.Output(), "  Print the edge-cd service logs of an edge device, from %s or the journal.\n\n", logs.LogFilePath)
+			fmt.Fprintf(logsCmd.Output(), "Flags:\n")
+			logsCmd.PrintDefaults()
+		}
+		logsCmd.Parse(rootCmd.Args()[1:])
+
+		if *targetAddr == "" && logsCmd.NArg() == 1 {
+			*targetAddr = logsCmd.Arg(0)
+		}
+		for flagName, value := range map[string]string{
+			"target-addr":     *targetAddr,
+			"ssh-private-key": *sshPrivateKey,
+		} {
+			if value == "" {
+				fmt.Fprintf(os.Stderr, "Error: --%s is required\n", flagName)
+				logsCmd.Usage()
+				os.Exit(1)
+			}
+		}
+
+		sshClient, err := ssh.NewClient(*targetAddr, *targetUser, *sshPrivateKey, "22")
+		if err != nil {
+			slog.Error("logs failed", "error", flaterrors.Join(err, errCreateSSHClient).Error())
+			os.Exit(1)
+		}
+
+		// The log file and the journal are only readable by privileged users
+		targetExecCtx := execcontext.New(map[string]string{}, []string{"sudo"})
+
+		opts := logs.Options{Follow: *follow, Lines: *lines}
+		if err := logs.Stream(targetExecCtx, sshClient, os.Stdout, os.Stderr, opts); err != nil {
+			slog.Error("logs failed", "error", flaterrors.Join(err, errStreamLogs).Error())
+			os.Exit(1)
+		}
+
+	case "doctor":
+		doctorCmd := flag.NewFlagSet("doctor", flag.ExitOnError)
+		doctorCmd.Usage = func() {
+			fmt.Fprintf(doctorCmd.Output(), "Usage of %s doctor:\n", os.Args[0])
+			fmt.Fprintf(doctorCmd.Output(), "  %s doctor [command]...\n", os.Args[0])
+			fmt.Fprintf(doctorCmd.Output(), "  Check the local tools the commands depend on, all of them by default.\n")
+			fmt.Fprintf(doctorCmd.Output(), "  The commands are: %s\n", strings.Join(doctor.Commands(), ", "))
+		}
+		doctorCmd.Parse(rootCmd.Args()[1:])
+
+		tools, err := doctor.ToolsFor(doctorCmd.Args()...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			doctorCmd.Usage()
+			os.Exit(1)
+		}
+
+		if err := doctor.Report(os.Stdout, doctor.NewChecker().Check(tools)); err != nil {
+			slog.Error("doctor failed", "error", err.Error())
+			os.Exit(1)
+		}
+
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", cmd)
 		rootCmd.Usage()
@@ -323,12 +634,122 @@ func main() {
 	}
 }
 
-// parseEnvFromFlag parses an environment variable string in the format "KEY=value"
-// and returns the key and value separately. If the format is invalid, it returns empty strings.
-func parseEnvFromFlag(envVar string) (key, value string) {
-	parts := strings.SplitN(envVar, "=", 2)
-	if len(parts) != 2 {
-		return "", ""
+// placeConfig places the config content at destPath on the target. If outputDir
+// is set, the exact content is first written to outputDir for review.
+func placeConfig(
+	execCtx execcontext.Context,
+	runner ssh.Runner,
+	content, destPath, outputDir string,
+) error {
+	if outputDir != "" {
+		if err := provision.WriteRenderedFile(outputDir, filepath.Base(destPath), content); err != nil {
+			return flaterrors.Join(err, errWriteOutputDir)
+		}
+		slog.Info("wrote rendered config", "path", filepath.Join(outputDir, filepath.Base(destPath)))
+	}
+
+	if err := provision.PlaceConfigYAML(execCtx, runner, content, destPath); err != nil {
+		return flaterrors.Join(err, errPlaceConfig)
+	}
+	return nil
+}
+
+// writeServiceFile writes the service file rendered for the service manager
+// svcmgrName to outputDir, named like on the target.
+func writeServiceFile(
+	outputDir, localEdgeCDRepoPath, svcmgrName string,
+	data provision.ServiceTemplateData,
+) error {
+	destPath, err := provision.ServiceFileDestPath(localEdgeCDRepoPath, svcmgrName)
+	if err != nil {
+		return flaterrors.Join(err, errWriteOutputDir)
+	}
+	content, err := provision.RenderServiceFile(localEdgeCDRepoPath, svcmgrName, data)
+	if err != nil {
+		return flaterrors.Join(err, errWriteOutputDir)
+	}
+	if err := provision.WriteRenderedFile(outputDir, filepath.Base(destPath), content); err != nil {
+		return flaterrors.Join(err, errWriteOutputDir)
+	}
+	slog.Info("wrote rendered service file", "path", filepath.Join(outputDir, filepath.Base(destPath)))
+	return nil
+}
+
+// bootstrapEnvs returns the environment variables injected on the target: the
+// --inject-env variables, and the GIT_SSH_COMMAND composed from --git-ssh-key
+// and --ssh-opts, if either is set. Setting GIT_SSH_COMMAND with both
+// --inject-env and these flags is an error.
+func bootstrapEnvs(injectEnv envFlag, gitSSHKey, sshOpts string) (map[string]string, error) {
+	envs := make(map[string]string)
+	injectEnv.apply(envs)
+
+	if gitSSHKey == "" && sshOpts == "" {
+		return envs, nil
+	}
+	if _, ok := envs["GIT_SSH_COMMAND"]; ok {
+		return nil, errConflictingGitSSHCommand
+	}
+	var opts []string
+	for _, opt := range strings.Split(sshOpts, ",") {
+		if opt = strings.TrimSpace(opt); opt != "" {
+			opts = append(opts, opt)
+		}
+	}
+	envs["GIT_SSH_COMMAND"] = provision.GitSSHCommand(gitSSHKey, opts)
+	return envs, nil
+}
+
+// envVarNameRegexp matches the names of the environment variables that can be
+// injected: they are set unquoted in the remote commands.
+var envVarNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envVar is an environment variable injected with --inject-env.
+type envVar struct {
+	key, value string
+}
+
+// envFlag is the repeatable --inject-env flag: the environment variables, in
+// the order they were given.
+type envFlag []envVar
+
+// String implements flag.Value.
+func (f *envFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	vars := make([]string, 0, len(*f))
+	for _, v := range *f {
+		vars = append(vars, v.key+"="+v.value)
+	}
+	return strings.Join(vars, ",")
+}
+
+// Set implements flag.Value. It appends the variable of s, rejecting it if it
+// is not in the format KEY=value.
+func (f *envFlag) Set(s string) error {
+	key, value, err := parseEnvFromFlag(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, envVar{key: key, value: value})
+	return nil
+}
+
+// apply sets the variables in envs, in order: a variable given several times
+// is set to its last value.
+func (f envFlag) apply(envs map[string]string) {
+	for _, v := range f {
+		envs[v.key] = v.value
+	}
+}
+
+// parseEnvFromFlag parses an environment variable string in the format
+// "KEY=value" and returns the key and value separately. The value may be
+// empty or contain "=", the key must be a valid variable name.
+func parseEnvFromFlag(envVar string) (key, value string, err error) {
+	key, value, ok := strings.Cut(envVar, "=")
+	if !ok || !envVarNameRegexp.MatchString(key) {
+		return "", "", flaterrors.Join(fmt.Errorf("env=%q", envVar), errInvalidInjectEnv)
 	}
-	return parts[0], parts[1]
+	return key, value, nil
 }
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"encoding/base64"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/provision"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestCloneOrPullRepoWithInjectEnvEmpty verifies that CloneOrPullRepoWithBranchAndEnv handles empty env
@@ -127,3 +134,172 @@ func TestBootstrapCommandWithInjectEnv(t *testing.T) {
 
 	assert.NoError(t, err, "bootstrap should work with --inject-env flag set")
 }
+
+// TestPlaceConfigWithOutputDir verifies that --output-dir receives the exact config placed on the target
+func TestPlaceConfigWithOutputDir(t *testing.T) {
+	content, err := provision.RenderConfig(provision.ConfigTemplateData{
+		EdgeCDRepoURL:      "https://github.com/test/edge-cd.git",
+		EdgeCDRepoDestPath: "/usr/local/src/edge-cd",
+		ConfigRepoURL:      "https://github.com/test/config.git",
+		ServiceManagerName: "systemd",
+		PackageManagerName: "apt",
+		RequiredPackages:   []string{"git", "curl"},
+	})
+	require.NoError(t, err)
+
+	outputDir := filepath.Join(t.TempDir(), "rendered")
+	mockRunner := ssh.NewMockRunner()
+	ctx := execcontext.New(make(map[string]string), []string{})
+
+	err = placeConfig(ctx, mockRunner, content, "/etc/edge-cd/config.yaml", outputDir)
+	require.NoError(t, err)
+
+	written, err := os.ReadFile(filepath.Join(outputDir, "config.yaml"))
+	require.NoError(t, err, "the rendered config should be written to the output directory")
+
+	// Decode the content transferred by PlaceConfigYAML: sh -c "echo <base64> | base64 -d > <dest>"
+	var placed []byte
+	for _, cmd := range mockRunner.Commands {
+		fields := strings.Fields(cmd)
+		for i, field := range fields {
+			if strings.TrimLeft(field, `"`) == "echo" && i+1 < len(fields) {
+				placed, err = base64.StdEncoding.DecodeString(fields[i+1])
+				require.NoError(t, err)
+			}
+		}
+	}
+	require.NotNil(t, placed, "the config should be placed on the target")
+	assert.Equal(t, string(placed), string(written), "the output directory should hold the config placed on the target")
+}
+
+// TestPlaceConfigWithoutOutputDir verifies that nothing is written locally by default
+func TestPlaceConfigWithoutOutputDir(t *testing.T) {
+	workDir := t.TempDir()
+	t.Chdir(workDir)
+
+	mockRunner := ssh.NewMockRunner()
+	ctx := execcontext.New(make(map[string]string), []string{})
+
+	err := placeConfig(ctx, mockRunner, "hello: world\n", "/etc/edge-cd/config.yaml", "")
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(workDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "no file should be written without --output-dir")
+	assert.NoError(t, mockRunner.AssertNumberOfCommandsRun(2))
+}
+
+// TestBootstrapEnvs verifies the GIT_SSH_COMMAND composed from --git-ssh-key and --ssh-opts
+func TestBootstrapEnvs(t *testing.T) {
+	t.Run("inject-env only", func(t *testing.T) {
+		envs, err := bootstrapEnvs(envFlag{{"GIT_SSH_COMMAND", "ssh -o StrictHostKeyChecking=no"}}, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"GIT_SSH_COMMAND": "ssh -o StrictHostKeyChecking=no"}, envs)
+	})
+
+	t.Run("composed from the key and options", func(t *testing.T) {
+		envs, err := bootstrapEnvs(
+			envFlag{{"CONFIG_SPEC_FILE", "device.yaml"}},
+			"/home/ubuntu/.ssh/id_ed25519",
+			"StrictHostKeyChecking=yes, UserKnownHostsFile=/etc/edge-cd/known_hosts,",
+		)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"CONFIG_SPEC_FILE": "device.yaml",
+			"GIT_SSH_COMMAND":  "ssh -i /home/ubuntu/.ssh/id_ed25519 -o StrictHostKeyChecking=yes -o UserKnownHostsFile=/etc/edge-cd/known_hosts",
+		}, envs)
+	})
+
+	t.Run("options without key", func(t *testing.T) {
+		envs, err := bootstrapEnvs(nil, "", "StrictHostKeyChecking=accept-new")
+		require.NoError(t, err)
+		assert.Equal(t, "ssh -o StrictHostKeyChecking=accept-new", envs["GIT_SSH_COMMAND"])
+	})
+
+	t.Run("conflicts with inject-env", func(t *testing.T) {
+		_, err := bootstrapEnvs(envFlag{{"GIT_SSH_COMMAND", "ssh"}}, "/home/ubuntu/.ssh/id_ed25519", "")
+		assert.ErrorIs(t, err, errConflictingGitSSHCommand)
+	})
+}
+
+// TestEnvFlag verifies that --inject-env can be given several times
+func TestEnvFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    envFlag
+		wantEnv map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "none",
+			args:    nil,
+			want:    nil,
+			wantEnv: map[string]string{},
+		},
+		{
+			name: "several variables in order",
+			args: []string{
+				"--inject-env", "GIT_SSH_COMMAND=ssh -o StrictHostKeyChecking=no",
+				"--inject-env=HTTP_PROXY=http://proxy:3128",
+			},
+			want: envFlag{
+				{"GIT_SSH_COMMAND", "ssh -o StrictHostKeyChecking=no"},
+				{"HTTP_PROXY", "http://proxy:3128"},
+			},
+			wantEnv: map[string]string{
+				"GIT_SSH_COMMAND": "ssh -o StrictHostKeyChecking=no",
+				"HTTP_PROXY":      "http://proxy:3128",
+			},
+		},
+		{
+			name:    "empty value and value with =",
+			args:    []string{"--inject-env", "NO_PROXY=", "--inject-env", "OPTS=a=b"},
+			want:    envFlag{{"NO_PROXY", ""}, {"OPTS", "a=b"}},
+			wantEnv: map[string]string{"NO_PROXY": "", "OPTS": "a=b"},
+		},
+		{
+			name:    "last value wins",
+			args:    []string{"--inject-env", "CONFIG_SPEC_FILE=a.yaml", "--inject-env", "CONFIG_SPEC_FILE=b.yaml"},
+			want:    envFlag{{"CONFIG_SPEC_FILE", "a.yaml"}, {"CONFIG_SPEC_FILE", "b.yaml"}},
+			wantEnv: map[string]string{"CONFIG_SPEC_FILE": "b.yaml"},
+		},
+		{
+			name:    "missing =",
+			args:    []string{"--inject-env", "HTTP_PROXY=http://proxy:3128", "--inject-env", "GIT_SSH_COMMAND"},
+			wantErr: true,
+		},
+		{
+			name:    "empty key",
+			args:    []string{"--inject-env", "=value"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid key",
+			args:    []string{"--inject-env", "GIT SSH=ssh"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			var injectEnv envFlag
+			fs.Var(&injectEnv, "inject-env", "")
+
+			err := fs.Parse(tt.args)
+			if tt.wantErr {
+				// The flag package does not wrap the error of Set
+				assert.ErrorContains(t, err, errInvalidInjectEnv.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, injectEnv)
+
+			envs := map[string]string{}
+			injectEnv.apply(envs)
+			assert.Equal(t, tt.wantEnv, envs)
+		})
+	}
+}
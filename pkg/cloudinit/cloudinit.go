@@ -52,7 +52,9 @@ type SSHKeys struct {
 type WriteFile struct {
 	Path        string `json:"path"`
 	Permissions string `json:"permissions,omitempty"`
-	Content     string `json:"content"`
+	// Encoding of Content, e.g. "b64". Plain text if empty.
+	Encoding string `json:"encoding,omitempty"`
+	Content  string `json:"content"`
 }
 
 type UserData struct {
@@ -64,7 +66,12 @@ type UserData struct {
 	RunCommands   []string    `json:"runcmd,omitempty"`
 }
 
+// Render validates the UserData and renders it as a cloud-config document.
 func (ud UserData) Render() (string, error) {
+	if err := ud.Validate(); err != nil {
+		return "", fmt.Errorf("Invalid UserData: %w", err)
+	}
+
 	b, err := yaml.Marshal(ud)
 	if err != nil {
 		return "", fmt.Errorf("Cannot render cloud-config from UserData: %v", err)
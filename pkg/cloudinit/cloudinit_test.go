@@ -0,0 +1,173 @@
+package cloudinit
+
+import (
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestUserDataRender(t *testing.T) {
+	ud := UserData{
+		Hostname:      "test-vm",
+		PackageUpdate: true,
+		Packages:      []string{"git", "curl"},
+		Users:         []User{NewUserWithAuthorizedKeys("ubuntu", []string{"ssh-ed25519 AAAA"})},
+		WriteFiles: []WriteFile{
+			{Path: "/etc/motd", Permissions: "0644", Content: "hello\nworld\n"},
+		},
+		RunCommands: []string{"echo 'quoted: value'"},
+	}
+
+	rendered, err := ud.Render()
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.HasPrefix(rendered, "#cloud-config\n") {
+		t.Fatalf("rendered user-data must start with #cloud-config header, got %q", rendered)
+	}
+
+	// The document must round-trip into a generic map with the expected top-level keys
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(rendered), &doc); err != nil {
+		t.Fatalf("rendered user-data is not valid YAML: %v", err)
+	}
+	for _, key := range []string{"hostname", "package_update", "packages", "users", "write_files", "runcmd"} {
+		if _, ok := doc[key]; !ok {
+			t.Errorf("expected top-level key %q in rendered user-data", key)
+		}
+	}
+
+	var parsed UserData
+	if err := yaml.UnmarshalStrict([]byte(rendered), &parsed); err != nil {
+		t.Fatalf("rendered user-data does not match UserData schema: %v", err)
+	}
+	if parsed.WriteFiles[0].Content != "hello\nworld\n" {
+		t.Errorf("multi-line content was not preserved: %q", parsed.WriteFiles[0].Content)
+	}
+	if parsed.RunCommands[0] != "echo 'quoted: value'" {
+		t.Errorf("run command was not preserved: %q", parsed.RunCommands[0])
+	}
+}
+
+func TestUserDataRenderOmitsEmptyFields(t *testing.T) {
+	rendered, err := UserData{Hostname: "test-vm"}.Render()
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	for _, key := range []string{"packages:", "write_files:", "runcmd:", "package_update:"} {
+		if strings.Contains(rendered, key) {
+			t.Errorf("expected %q to be omitted from rendered user-data:\n%s", key, rendered)
+		}
+	}
+}
+
+func TestUserDataValidate(t *testing.T) {
+	newUserData := func() UserData {
+		return UserData{
+			Hostname: "test-vm",
+			Users:    []User{NewUserWithAuthorizedKeys("ubuntu", []string{"ssh-ed25519 AAAA"})},
+			WriteFiles: []WriteFile{
+				{Path: "/etc/motd", Permissions: "0644", Content: "hello\n"},
+				{Path: "/usr/local/bin/hello", Permissions: "755", Encoding: "b64", Content: "ZWNobyBoZWxsbwo="},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		configure func(ud *UserData)
+		wantErr   string
+	}{
+		{name: "valid"},
+		{
+			name:      "missing hostname",
+			configure: func(ud *UserData) { ud.Hostname = "" },
+			wantErr:   "hostname is required",
+		},
+		{
+			name:      "empty username",
+			configure: func(ud *UserData) { ud.Users[0].Name = "" },
+			wantErr:   "users[0] validation failed: name is required",
+		},
+		{
+			name:      "username with whitespace",
+			configure: func(ud *UserData) { ud.Users[0].Name = "edge cd" },
+			wantErr:   "must not contain whitespace",
+		},
+		{
+			name:      "duplicate user",
+			configure: func(ud *UserData) { ud.Users = append(ud.Users, ud.Users[0]) },
+			wantErr:   `users[1]: duplicate user "ubuntu"`,
+		},
+		{
+			name:      "incomplete ssh keys",
+			configure: func(ud *UserData) { ud.Users[0].SSHKeys = &SSHKeys{RSAPrivate: "key"} },
+			wantErr:   "ssh_keys requires both",
+		},
+		{
+			name:      "file without path",
+			configure: func(ud *UserData) { ud.WriteFiles[0].Path = "" },
+			wantErr:   "write_files[0] validation failed: path is required",
+		},
+		{
+			name:      "relative file path",
+			configure: func(ud *UserData) { ud.WriteFiles[0].Path = "etc/motd" },
+			wantErr:   "path must be absolute",
+		},
+		{
+			name:      "bad file mode",
+			configure: func(ud *UserData) { ud.WriteFiles[0].Permissions = "rw-r--r--" },
+			wantErr:   "permissions must be an octal file mode",
+		},
+		{
+			name:      "non-octal file mode",
+			configure: func(ud *UserData) { ud.WriteFiles[0].Permissions = "0648" },
+			wantErr:   "permissions must be an octal file mode",
+		},
+		{
+			name:      "file mode out of range",
+			configure: func(ud *UserData) { ud.WriteFiles[0].Permissions = "17777" },
+			wantErr:   "permissions must be an octal file mode",
+		},
+		{
+			name:      "unknown encoding",
+			configure: func(ud *UserData) { ud.WriteFiles[1].Encoding = "base32" },
+			wantErr:   `write_files[1] validation failed: unknown encoding "base32"`,
+		},
+		{
+			name:      "invalid base64 content",
+			configure: func(ud *UserData) { ud.WriteFiles[1].Content = "not base64!" },
+			wantErr:   "content is not valid b64",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ud := newUserData()
+			if tt.configure != nil {
+				tt.configure(&ud)
+			}
+
+			err := ud.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate failed: %v", err)
+				}
+				if _, err := ud.Render(); err != nil {
+					t.Fatalf("Render failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate error = %v, want an error containing %q", err, tt.wantErr)
+			}
+
+			// Render refuses to render an invalid UserData
+			if rendered, err := ud.Render(); err == nil {
+				t.Fatalf("expected Render to fail, got:\n%s", rendered)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package cloudinit
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// writeFileEncodings are the encodings of the write_files content cloud-init
+// decodes. The base64 ones are checked to decode.
+var writeFileEncodings = map[string]bool{
+	"":            false,
+	"text/plain":  false,
+	"b64":         true,
+	"base64":      true,
+	"gz":          false,
+	"gzip":        false,
+	"gz+b64":      true,
+	"gz+base64":   true,
+	"gzip+b64":    true,
+	"gzip+base64": true,
+}
+
+// Validate checks the UserData renders into a cloud-config that cloud-init
+// applies as intended, instead of silently skipping the inconsistent parts.
+func (ud UserData) Validate() error {
+	if ud.Hostname == "" {
+		return fmt.Errorf("hostname is required")
+	}
+
+	names := make(map[string]struct{}, len(ud.Users))
+	for i, user := range ud.Users {
+		if err := user.Validate(); err != nil {
+			return fmt.Errorf("users[%d] validation failed: %w", i, err)
+		}
+		if _, ok := names[user.Name]; ok {
+			return fmt.Errorf("users[%d]: duplicate user %q", i, user.Name)
+		}
+		names[user.Name] = struct{}{}
+	}
+
+	for i, file := range ud.WriteFiles {
+		if err := file.Validate(); err != nil {
+			return fmt.Errorf("write_files[%d] validation failed: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks if the User is valid
+func (u User) Validate() error {
+	if u.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if strings.ContainsAny(u.Name, " \t\n:") {
+		return fmt.Errorf("name %q must not contain whitespace or colons", u.Name)
+	}
+
+	if u.SSHKeys != nil && (u.SSHKeys.RSAPrivate == "" || u.SSHKeys.RSAPublic == "") {
+		return fmt.Errorf("ssh_keys requires both rsa_private and rsa_public")
+	}
+
+	return nil
+}
+
+// Validate checks if the WriteFile is valid
+func (f WriteFile) Validate() error {
+	if f.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if !path.IsAbs(f.Path) {
+		return fmt.Errorf("path must be absolute, got %q", f.Path)
+	}
+
+	if f.Permissions != "" {
+		mode, err := strconv.ParseUint(f.Permissions, 8, 32)
+		if err != nil || mode > 07777 {
+			return fmt.Errorf("permissions must be an octal file mode (e.g., '0644'), got %q", f.Permissions)
+		}
+	}
+
+	isBase64, ok := writeFileEncodings[f.Encoding]
+	if !ok {
+		return fmt.Errorf("unknown encoding %q", f.Encoding)
+	}
+	if isBase64 {
+		if _, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(f.Content), "")); err != nil {
+			return fmt.Errorf("content is not valid %s: %w", f.Encoding, err)
+		}
+	}
+
+	return nil
+}
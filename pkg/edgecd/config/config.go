@@ -2,9 +2,12 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
 
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/facts"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
 	"gopkg.in/yaml.v3"
 )
@@ -21,6 +24,53 @@ type Config struct {
 	ConfigRepoPath   string
 	ConfigCommitPath string
 	ConfigSpecPath   string
+	// FilesManifestPath is where the checksums of the managed files are written
+	// after each successful reconciliation, to detect out-of-band changes.
+	FilesManifestPath string
+	// SummaryPath is where the Result of the latest reconciliation is written
+	// as JSON, for the local tools and monitoring agents. Disabled if empty.
+	SummaryPath string
+
+	// InventoryListenAddr is the address the inventory HTTP endpoint listens on.
+	// The endpoint is disabled if empty.
+	InventoryListenAddr string
+	// DiffEndpointEnabled also serves the /diff endpoint on InventoryListenAddr.
+	// Disabled by default: the diff holds the rendered files, secrets included.
+	DiffEndpointEnabled bool
+
+	// DevicesListenAddr is the address the /device/{id} HTTP endpoint, serving
+	// the rendered files of the devices of DevicesPath to pull-based agents,
+	// listens on. The endpoint is disabled if empty.
+	DevicesListenAddr string
+	// DevicesPath is the directory of the config repository holding one
+	// directory per device served on DevicesListenAddr.
+	DevicesPath string
+
+	// SyncFailurePolicy is what to do when the config repo cannot be synced.
+	// One of userconfig.SyncFailurePolicyFailClosed (default) or SyncFailurePolicyFailOpen.
+	SyncFailurePolicy string
+
+	// GitCredentialHelper is the git credential.helper used to fetch the
+	// repositories over HTTPS. Git's own configuration is used if empty.
+	GitCredentialHelper string
+
+	// RootPrefix is the root filesystem the managed files are written to, e.g.
+	// an image mounted at /mnt/target. The files are written under / if empty.
+	RootPrefix string
+
+	// MinPollingInterval is the shortest polling interval in seconds. A shorter
+	// Spec.PollingInterval is raised to it.
+	MinPollingInterval int
+
+	// MinFetchInterval is the shortest interval in seconds between two syncs of a
+	// repository with its remote. The iterations in between reconcile the current
+	// checkout without hitting the git servers. Disabled if 0.
+	MinFetchInterval int
+
+	// Escalation prefixes the privileged commands and file writes when edge-cd
+	// runs as the unprivileged user of Spec.RunAs, e.g. ["sudo", "-n"]. Nothing
+	// is escalated if empty.
+	Escalation []string
 }
 
 // LoadConfig reads configuration from environment variables and YAML file.
@@ -29,8 +79,17 @@ type Config struct {
 // Required environment variables:
 //   - CONFIG_PATH: Path within config repository
 //
+// CONFIG_PATH, CONFIG_SPEC_FILE and the config.path and config.spec of the spec
+// may be templates resolved from the host facts, e.g. devices/${MAC} (see ResolvePath).
+//
 // Returns error if CONFIG_PATH is not set or if configuration is invalid.
 func LoadConfig() (*Config, error) {
+	return loadConfig(facts.Gather)
+}
+
+// loadConfig is LoadConfig with the host facts used to resolve path templates
+// gathered by gatherFacts. The facts are only gathered if a path is a template.
+func loadConfig(gatherFacts func() (*facts.Facts, error)) (*Config, error) {
 	// CONFIG_PATH is required
 	configPath := os.Getenv("CONFIG_PATH")
 	if configPath == "" {
@@ -41,12 +100,26 @@ func LoadConfig() (*Config, error) {
 	configSpecFile := getConfigValue("CONFIG_SPEC_FILE", "", "spec.yaml")
 	configRepoDestPath := getConfigValue("CONFIG_REPO_DEST_PATH", "", "/usr/local/src/edge-cd-config")
 
+	resolver := &pathResolver{gatherFacts: gatherFacts}
+	configPath, err := resolver.resolve(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONFIG_PATH: %w", err)
+	}
+	configSpecFile, err = resolver.resolve(configSpecFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONFIG_SPEC_FILE: %w", err)
+	}
+
 	// Build config spec path
 	configSpecPath := filepath.Join(configRepoDestPath, configPath, configSpecFile)
 
 	// Parse YAML using userconfig.Spec
 	data, err := os.ReadFile(configSpecPath)
 	if err != nil {
+		if resolver.facts != nil && os.IsNotExist(err) {
+			return nil, fmt.Errorf("no config file for this device at %s, resolved from CONFIG_PATH=%s and CONFIG_SPEC_FILE=%s: %w",
+				configSpecPath, os.Getenv("CONFIG_PATH"), getConfigValue("CONFIG_SPEC_FILE", "", "spec.yaml"), err)
+		}
 		return nil, fmt.Errorf("failed to read config file %s: %w", configSpecPath, err)
 	}
 
@@ -55,6 +128,16 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
+	// The files of the device are read relative to the resolved config path
+	spec.Config.Path, err = resolver.resolve(spec.Config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config.path: %w", err)
+	}
+	spec.Config.Spec, err = resolver.resolve(spec.Config.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config.spec: %w", err)
+	}
+
 	// Validate configuration
 	if err := spec.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -69,11 +152,125 @@ func LoadConfig() (*Config, error) {
 		ConfigRepoPath:   configRepoDestPath,
 		ConfigCommitPath: getConfigValue("CONFIG_COMMIT_PATH", spec.Config.CommitPath, "/tmp/edge-cd/config-last-synchronized-commit.txt"),
 		ConfigSpecPath:   configSpecPath,
+		FilesManifestPath: getConfigValue(
+			"FILES_MANIFEST_PATH", "", "/tmp/edge-cd/files-manifest.json"),
+		SummaryPath: getConfigValue(
+			"RECONCILE_SUMMARY_PATH", "", "/tmp/edge-cd/reconcile-summary.json"),
+
+		InventoryListenAddr: getConfigValue("INVENTORY_LISTEN_ADDR", "", ""),
+		DevicesListenAddr:   getConfigValue("DEVICES_LISTEN_ADDR", "", ""),
+		DevicesPath:         getConfigValue("DEVICES_PATH", "", "devices"),
+		SyncFailurePolicy: getConfigValue(
+			"SYNC_FAILURE_POLICY", spec.Config.SyncFailurePolicy, userconfig.SyncFailurePolicyFailClosed),
+		GitCredentialHelper: getConfigValue(
+			"GIT_CREDENTIAL_HELPER", spec.Config.Repo.CredentialHelper, ""),
+		RootPrefix: getConfigValue("ROOT_PREFIX", spec.RootPrefix, ""),
+	}
+
+	if err := userconfig.ValidateSyncFailurePolicy(cfg.SyncFailurePolicy); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := userconfig.ValidateRootPrefix("ROOT_PREFIX", cfg.RootPrefix); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	cfg.MinPollingInterval, err = strconv.Atoi(getConfigValue(
+		"MIN_POLLING_INTERVAL_SECOND", "", strconv.Itoa(userconfig.DefaultMinPollingInterval)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: MIN_POLLING_INTERVAL_SECOND must be an integer: %w", err)
+	}
+	if err := userconfig.ValidatePollingInterval("MIN_POLLING_INTERVAL_SECOND", cfg.MinPollingInterval); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
+	spec.PollingInterval = pollingInterval(spec.PollingInterval, cfg.MinPollingInterval)
+
+	cfg.MinFetchInterval, err = strconv.Atoi(getConfigValue("MIN_FETCH_INTERVAL_SECOND", "", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: MIN_FETCH_INTERVAL_SECOND must be an integer: %w", err)
+	}
+	if err := userconfig.ValidatePollingInterval("MIN_FETCH_INTERVAL_SECOND", cfg.MinFetchInterval); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	cfg.DiffEndpointEnabled, err = strconv.ParseBool(getConfigValue("DIFF_ENDPOINT_ENABLED", "", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: DIFF_ENDPOINT_ENABLED must be a boolean: %w", err)
+	}
+	cfg.Escalation = escalation(spec.RunAs, os.Geteuid())
 
 	return cfg, nil
 }
 
+// ValidateSpecFile parses and validates the spec at specPath, e.g. a config
+// placed on a device before its edge-cd service is enabled. Unlike LoadConfig,
+// it neither reads the environment nor resolves the path templates.
+func ValidateSpecFile(specPath string) error {
+	data, err := os.ReadFile(specPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", specPath, err)
+	}
+
+	var spec userconfig.Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if err := spec.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	return nil
+}
+
+// escalation returns the command prefixing the privileged commands of edge-cd
+// running as euid with runAs. It is nil when nothing must be escalated: edge-cd
+// runs as root, or was granted the capabilities to write the managed files.
+func escalation(runAs *userconfig.RunAsSection, euid int) []string {
+	if runAs == nil || euid == 0 {
+		return nil
+	}
+	switch runAs.Escalation {
+	case "", userconfig.EscalationSudo:
+		return []string{"sudo", "-n"}
+	default:
+		return nil
+	}
+}
+
+// pollingInterval returns the interval in seconds to poll at: interval, the
+// default interval if unset, raised to minInterval if shorter.
+func pollingInterval(interval, minInterval int) int {
+	if interval == 0 {
+		interval = userconfig.DefaultPollingInterval
+	}
+	if interval < minInterval {
+		slog.Warn("Polling interval is below the minimum, using the minimum",
+			"pollingIntervalSecond", interval, "minPollingIntervalSecond", minInterval)
+		return minInterval
+	}
+	return interval
+}
+
+// pathResolver resolves path templates, gathering the host facts on first use.
+type pathResolver struct {
+	gatherFacts func() (*facts.Facts, error)
+	facts       *facts.Facts
+}
+
+// resolve returns path with its placeholders replaced by the host facts, or
+// path itself if it is not a template.
+func (r *pathResolver) resolve(path string) (string, error) {
+	if !isPathTemplate(path) {
+		return path, nil
+	}
+	if r.facts == nil {
+		f, err := r.gatherFacts()
+		if err != nil {
+			return "", fmt.Errorf("failed to gather host facts: %w", err)
+		}
+		r.facts = f
+	}
+	return ResolvePath(path, r.facts)
+}
+
 // getConfigValue reads a value with precedence: env > yaml > default.
 //
 // Parameters:
@@ -3,7 +3,12 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/facts"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
 )
 
 func TestGetConfigValue(t *testing.T) {
@@ -316,4 +321,530 @@ packageManager:
 	if cfg.ConfigCommitPath != "/tmp/edge-cd/config-last-synchronized-commit.txt" {
 		t.Errorf("ConfigCommitPath = %v, want default", cfg.ConfigCommitPath)
 	}
+
+	if cfg.SyncFailurePolicy != "fail-closed" {
+		t.Errorf("SyncFailurePolicy = %v, want fail-closed (default)", cfg.SyncFailurePolicy)
+	}
+
+	if cfg.DiffEndpointEnabled {
+		t.Error("DiffEndpointEnabled = true, want false (default)")
+	}
+}
+
+func TestLoadConfig_DiffEndpointEnabled(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "test-device")
+	os.MkdirAll(configDir, 0755)
+
+	minimalConfig := `
+edgeCD:
+  repo:
+    url: https://github.com/test/edge-cd.git
+    branch: main
+    destinationPath: /opt/edge-cd
+
+config:
+  spec: spec.yaml
+  path: test-device
+  repo:
+    url: https://github.com/test/config.git
+    branch: main
+    destPath: /opt/config
+
+serviceManager:
+  name: systemd
+
+packageManager:
+  name: apt
+`
+	os.WriteFile(filepath.Join(configDir, "spec.yaml"), []byte(minimalConfig), 0644)
+
+	t.Setenv("CONFIG_PATH", "test-device")
+	t.Setenv("CONFIG_REPO_DEST_PATH", tempDir)
+
+	t.Setenv("DIFF_ENDPOINT_ENABLED", "true")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if !cfg.DiffEndpointEnabled {
+		t.Error("DiffEndpointEnabled = false, want true")
+	}
+
+	t.Setenv("DIFF_ENDPOINT_ENABLED", "sometimes")
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("LoadConfig() should fail for a non-boolean DIFF_ENDPOINT_ENABLED")
+	}
+}
+
+func TestLoadConfig_InvalidSyncFailurePolicy(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "test-device")
+	os.MkdirAll(configDir, 0755)
+
+	minimalConfig := `
+edgeCD:
+  repo:
+    url: https://github.com/test/edge-cd.git
+    branch: main
+    destinationPath: /opt/edge-cd
+
+config:
+  spec: spec.yaml
+  path: test-device
+  repo:
+    url: https://github.com/test/config.git
+    branch: main
+    destPath: /opt/config
+
+serviceManager:
+  name: systemd
+
+packageManager:
+  name: apt
+`
+
+	configFile := filepath.Join(configDir, "spec.yaml")
+	os.WriteFile(configFile, []byte(minimalConfig), 0644)
+
+	os.Setenv("CONFIG_PATH", "test-device")
+	defer os.Unsetenv("CONFIG_PATH")
+
+	os.Setenv("CONFIG_REPO_DEST_PATH", tempDir)
+	defer os.Unsetenv("CONFIG_REPO_DEST_PATH")
+
+	os.Setenv("SYNC_FAILURE_POLICY", "fail-sometimes")
+	defer os.Unsetenv("SYNC_FAILURE_POLICY")
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("LoadConfig() should fail for an unknown sync failure policy")
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	f := &facts.Facts{
+		Hostname: "edge-01",
+		MAC:      "52:54:00:12:34:56",
+		Serial:   "10000000abcdef01",
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{name: "mac", path: "devices/${MAC}/spec.yaml", want: "devices/52:54:00:12:34:56/spec.yaml"},
+		{name: "serial", path: "devices/$SERIAL", want: "devices/10000000abcdef01"},
+		{name: "hostname and serial", path: "${HOSTNAME}-${SERIAL}.yaml", want: "edge-01-10000000abcdef01.yaml"},
+		{name: "not a template", path: "devices/edge-01", want: "devices/edge-01"},
+		{name: "unknown placeholder", path: "devices/${UUID}", wantErr: "unknown placeholders [UUID]"},
+		{name: "fact not gathered", path: "devices/${PRIMARY_IP}", wantErr: "no value on this host for [PRIMARY_IP]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolvePath(tt.path, f)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolvePath() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolvePath() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ResolvePath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveFileSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostname string
+		file     userconfig.FileSpec
+		want     userconfig.FileSpec
+		wantErr  string
+	}{
+		{
+			name:     "destPath",
+			hostname: "edge-01",
+			file:     userconfig.FileSpec{Type: "content", DestPath: "/var/log/${HOSTNAME}/app.log"},
+			want:     userconfig.FileSpec{Type: "content", DestPath: "/var/log/edge-01/app.log"},
+		},
+		{
+			name:     "srcPath and destPath",
+			hostname: "edge-01",
+			file:     userconfig.FileSpec{Type: "file", SrcPath: "hosts/${HOSTNAME}.conf", DestPath: "/etc/app/app-${HOSTNAME}.conf"},
+			want:     userconfig.FileSpec{Type: "file", SrcPath: "hosts/edge-01.conf", DestPath: "/etc/app/app-edge-01.conf"},
+		},
+		{
+			name:     "destPath escaping its directory",
+			hostname: "../../etc",
+			file:     userconfig.FileSpec{Type: "content", DestPath: "/var/log/${HOSTNAME}/passwd"},
+			wantErr:  "invalid destPath: path /var/log/${HOSTNAME}/passwd resolves to /var/log/../../etc/passwd, outside of /var/log",
+		},
+		{
+			name:     "srcPath escaping the config directory",
+			hostname: "../../secrets",
+			file:     userconfig.FileSpec{Type: "file", SrcPath: "${HOSTNAME}/key", DestPath: "/etc/app/key"},
+			wantErr:  "invalid srcPath: path ${HOSTNAME}/key resolves to ../../secrets/key, outside of .",
+		},
+		{
+			name:     "relative srcPath made absolute",
+			hostname: "/etc",
+			file:     userconfig.FileSpec{Type: "file", SrcPath: "${HOSTNAME}/shadow", DestPath: "/etc/app/key"},
+			wantErr:  "outside of .",
+		},
+		{
+			name:     "unknown placeholder",
+			hostname: "edge-01",
+			file:     userconfig.FileSpec{Type: "content", DestPath: "/etc/${UUID}.conf"},
+			wantErr:  "unknown placeholders [UUID]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveFileSpec(tt.file, func() (*facts.Facts, error) {
+				return &facts.Facts{Hostname: tt.hostname}, nil
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveFileSpec() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveFileSpec() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveFileSpec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveFileSpec_Literal(t *testing.T) {
+	file := userconfig.FileSpec{Type: "file", SrcPath: "../shared/motd", DestPath: "/etc/motd"}
+
+	got, err := ResolveFileSpec(file, func() (*facts.Facts, error) {
+		t.Error("facts must not be gathered when no path is a template")
+		return &facts.Facts{}, nil
+	})
+	if err != nil {
+		t.Fatalf("ResolveFileSpec() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, file) {
+		t.Errorf("ResolveFileSpec() = %+v, want the literal paths %+v", got, file)
+	}
+}
+
+func TestLoadConfig_PathTemplate(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "devices", "52:54:00:12:34:56")
+	os.MkdirAll(configDir, 0755)
+
+	deviceConfig := `
+edgeCD:
+  repo:
+    url: https://github.com/test/edge-cd.git
+    branch: main
+    destinationPath: /opt/edge-cd
+
+config:
+  spec: ${SERIAL}.yaml
+  path: devices/${MAC}
+  repo:
+    url: https://github.com/test/config.git
+    branch: main
+    destPath: /opt/config
+
+serviceManager:
+  name: systemd
+
+packageManager:
+  name: apt
+`
+	os.WriteFile(filepath.Join(configDir, "10000000abcdef01.yaml"), []byte(deviceConfig), 0644)
+
+	t.Setenv("CONFIG_PATH", "devices/${MAC}")
+	t.Setenv("CONFIG_SPEC_FILE", "${SERIAL}.yaml")
+	t.Setenv("CONFIG_REPO_DEST_PATH", tempDir)
+
+	gathered := 0
+	gatherFacts := func() (*facts.Facts, error) {
+		gathered++
+		return &facts.Facts{MAC: "52:54:00:12:34:56", Serial: "10000000abcdef01"}, nil
+	}
+
+	cfg, err := loadConfig(gatherFacts)
+	if err != nil {
+		t.Fatalf("loadConfig() failed: %v", err)
+	}
+
+	if want := filepath.Join(configDir, "10000000abcdef01.yaml"); cfg.ConfigSpecPath != want {
+		t.Errorf("ConfigSpecPath = %v, want %v", cfg.ConfigSpecPath, want)
+	}
+	if cfg.Spec.Config.Path != "devices/52:54:00:12:34:56" || cfg.Spec.Config.Spec != "10000000abcdef01.yaml" {
+		t.Errorf("Spec.Config = %v/%v, want the resolved path", cfg.Spec.Config.Path, cfg.Spec.Config.Spec)
+	}
+	if gathered != 1 {
+		t.Errorf("facts gathered %d times, want once", gathered)
+	}
+}
+
+func TestLoadConfig_PathTemplateMissingDevice(t *testing.T) {
+	t.Setenv("CONFIG_PATH", "devices/${MAC}")
+	t.Setenv("CONFIG_REPO_DEST_PATH", t.TempDir())
+
+	_, err := loadConfig(func() (*facts.Facts, error) {
+		return &facts.Facts{MAC: "52:54:00:ff:ff:ff"}, nil
+	})
+	if err == nil {
+		t.Fatal("loadConfig() should fail when no spec exists for the device")
+	}
+
+	for _, want := range []string{"no config file for this device", "devices/52:54:00:ff:ff:ff/spec.yaml", "CONFIG_PATH=devices/${MAC}"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestLoadConfig_NoFactsWithoutTemplate(t *testing.T) {
+	t.Setenv("CONFIG_PATH", "nonexistent")
+	t.Setenv("CONFIG_REPO_DEST_PATH", t.TempDir())
+
+	_, err := loadConfig(func() (*facts.Facts, error) {
+		t.Error("facts must not be gathered when no path is a template")
+		return &facts.Facts{}, nil
+	})
+	if err == nil {
+		t.Fatal("loadConfig() should fail when the config file is missing")
+	}
+}
+
+func TestPollingInterval(t *testing.T) {
+	tests := []struct {
+		name        string
+		interval    int
+		minInterval int
+		want        int
+	}{
+		{name: "above the minimum", interval: 30, minInterval: 10, want: 30},
+		{name: "default", interval: 0, minInterval: 10, want: 60},
+		{name: "below the minimum is clamped", interval: 1, minInterval: 10, want: 10},
+		{name: "no minimum", interval: 1, minInterval: 0, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pollingInterval(tt.interval, tt.minInterval); got != tt.want {
+				t.Errorf("pollingInterval(%d, %d) = %d, want %d", tt.interval, tt.minInterval, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	tests := []struct {
+		name  string
+		runAs *userconfig.RunAsSection
+		euid  int
+		want  []string
+	}{
+		{name: "root without runAs", runAs: nil, euid: 0, want: nil},
+		{name: "sudo", runAs: &userconfig.RunAsSection{User: "edge-cd"}, euid: 1000, want: []string{"sudo", "-n"}},
+		{name: "sudo but running as root", runAs: &userconfig.RunAsSection{Escalation: userconfig.EscalationSudo}, euid: 0, want: nil},
+		{name: "capabilities", runAs: &userconfig.RunAsSection{Escalation: userconfig.EscalationCapabilities}, euid: 1000, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escalation(tt.runAs, tt.euid); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("escalation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// writePollingIntervalSpec writes a minimal spec polling every interval seconds
+// and points CONFIG_PATH at it.
+func writePollingIntervalSpec(t *testing.T, interval string) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "test-device")
+	os.MkdirAll(configDir, 0755)
+
+	spec := `
+edgeCD:
+  repo:
+    url: https://github.com/test/edge-cd.git
+    branch: main
+    destinationPath: /opt/edge-cd
+
+config:
+  spec: spec.yaml
+  path: test-device
+  repo:
+    url: https://github.com/test/config.git
+    branch: main
+    destPath: /opt/config
+
+pollingIntervalSecond: ` + interval + `
+
+serviceManager:
+  name: systemd
+
+packageManager:
+  name: apt
+`
+	os.WriteFile(filepath.Join(configDir, "spec.yaml"), []byte(spec), 0644)
+
+	t.Setenv("CONFIG_PATH", "test-device")
+	t.Setenv("CONFIG_REPO_DEST_PATH", tempDir)
+}
+
+func TestLoadConfig_PollingIntervalBounds(t *testing.T) {
+	t.Run("negative interval", func(t *testing.T) {
+		writePollingIntervalSpec(t, "-1")
+
+		_, err := LoadConfig()
+		if err == nil || !strings.Contains(err.Error(), "pollingIntervalSecond must not be negative") {
+			t.Fatalf("LoadConfig() error = %v, want a negative interval error", err)
+		}
+	})
+
+	t.Run("interval below the default minimum", func(t *testing.T) {
+		writePollingIntervalSpec(t, "1")
+
+		cfg, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("LoadConfig() failed: %v", err)
+		}
+		if cfg.Spec.PollingInterval != 10 {
+			t.Errorf("PollingInterval = %d, want the default minimum 10", cfg.Spec.PollingInterval)
+		}
+	})
+
+	t.Run("configurable minimum", func(t *testing.T) {
+		writePollingIntervalSpec(t, "1")
+		t.Setenv("MIN_POLLING_INTERVAL_SECOND", "2")
+
+		cfg, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("LoadConfig() failed: %v", err)
+		}
+		if cfg.MinPollingInterval != 2 || cfg.Spec.PollingInterval != 2 {
+			t.Errorf("MinPollingInterval = %d and PollingInterval = %d, want 2", cfg.MinPollingInterval, cfg.Spec.PollingInterval)
+		}
+	})
+
+	t.Run("invalid minimum", func(t *testing.T) {
+		writePollingIntervalSpec(t, "30")
+		t.Setenv("MIN_POLLING_INTERVAL_SECOND", "-3")
+
+		if _, err := LoadConfig(); err == nil {
+			t.Fatal("LoadConfig() should fail for a negative minimum")
+		}
+	})
+}
+
+func TestLoadConfig_MinFetchInterval(t *testing.T) {
+	t.Run("disabled by default", func(t *testing.T) {
+		writePollingIntervalSpec(t, "30")
+
+		cfg, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("LoadConfig() failed: %v", err)
+		}
+		if cfg.MinFetchInterval != 0 {
+			t.Errorf("MinFetchInterval = %d, want 0", cfg.MinFetchInterval)
+		}
+	})
+
+	t.Run("from the environment", func(t *testing.T) {
+		writePollingIntervalSpec(t, "30")
+		t.Setenv("MIN_FETCH_INTERVAL_SECOND", "300")
+
+		cfg, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("LoadConfig() failed: %v", err)
+		}
+		if cfg.MinFetchInterval != 300 {
+			t.Errorf("MinFetchInterval = %d, want 300", cfg.MinFetchInterval)
+		}
+	})
+
+	for _, value := range []string{"-1", "5m"} {
+		t.Run("invalid "+value, func(t *testing.T) {
+			writePollingIntervalSpec(t, "30")
+			t.Setenv("MIN_FETCH_INTERVAL_SECOND", value)
+
+			_, err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), "MIN_FETCH_INTERVAL_SECOND") {
+				t.Fatalf("LoadConfig() error = %v, want a MIN_FETCH_INTERVAL_SECOND error", err)
+			}
+		})
+	}
+}
+
+func TestValidateSpecFile(t *testing.T) {
+	validSpec := `
+edgeCD:
+  repo:
+    url: https://github.com/test/edge-cd.git
+    branch: main
+    destinationPath: /usr/local/src/edge-cd
+config:
+  spec: spec.yaml
+  path: ./devices/${HOSTNAME}
+  repo:
+    url: https://github.com/test/config.git
+    branch: main
+    destPath: /usr/local/src/deployment
+serviceManager:
+  name: systemd
+packageManager:
+  name: apt
+`
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "valid", content: validSpec},
+		{name: "invalid YAML", content: "invalid: yaml: content: [", wantErr: "failed to parse config"},
+		{name: "missing config path", content: strings.Replace(validSpec, "path: ./devices/${HOSTNAME}", "", 1), wantErr: "config.path is required"},
+		{name: "negative polling interval", content: validSpec + "pollingIntervalSecond: -1\n", wantErr: "invalid configuration"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(specPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write spec: %v", err)
+			}
+
+			err := ValidateSpecFile(specPath)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateSpecFile() failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateSpecFile() error = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if err := ValidateSpecFile(filepath.Join(t.TempDir(), "config.yaml")); err == nil {
+			t.Fatal("ValidateSpecFile() succeeded, want an error for a missing file")
+		}
+	})
 }
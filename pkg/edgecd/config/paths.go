@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/facts"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// pathVars returns the placeholders available to path templates, e.g.
+// devices/${MAC}/spec.yaml, with their value for the host facts f.
+func pathVars(f *facts.Facts) map[string]string {
+	return map[string]string{
+		"HOSTNAME":       f.Hostname,
+		"MAC":            f.MAC,
+		"SERIAL":         f.Serial,
+		"OS":             f.OS,
+		"ARCH":           f.Arch,
+		"DISTRO":         f.Distro,
+		"DISTRO_VERSION": f.DistroVersion,
+		"PRIMARY_IP":     f.PrimaryIP,
+	}
+}
+
+// isPathTemplate reports whether path contains placeholders to resolve.
+func isPathTemplate(path string) bool {
+	return strings.Contains(path, "$")
+}
+
+// ResolvePath replaces the ${VAR} placeholders of path with the host facts f
+// (see pathVars). It returns an error if a placeholder is unknown or if the
+// fact it refers to could not be gathered on this host.
+func ResolvePath(path string, f *facts.Facts) (string, error) {
+	vars := pathVars(f)
+
+	var unknown, empty []string
+	resolved := os.Expand(path, func(name string) string {
+		value, ok := vars[name]
+		switch {
+		case !ok:
+			unknown = append(unknown, name)
+		case value == "":
+			empty = append(empty, name)
+		}
+		return value
+	})
+
+	if len(unknown) > 0 {
+		known := make([]string, 0, len(vars))
+		for name := range vars {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return "", fmt.Errorf("failed to resolve path %s: unknown placeholders %v, must be one of %v", path, unknown, known)
+	}
+	if len(empty) > 0 {
+		return "", fmt.Errorf("failed to resolve path %s: no value on this host for %v", path, empty)
+	}
+	return resolved, nil
+}
+
+// ResolveFileSpec returns file with the ${VAR} placeholders of its SrcPath and
+// DestPath replaced by the host facts, like the path of the config (see
+// ResolvePath), e.g. /var/log/${HOSTNAME}/app.log. The facts are only gathered
+// if a path is a template, and literal paths are kept verbatim.
+//
+// A placeholder may only add path elements under the literal directory before
+// it: it returns an error if a fact such as "../../etc" moves the path out of
+// that directory.
+func ResolveFileSpec(file userconfig.FileSpec, gatherFacts func() (*facts.Facts, error)) (userconfig.FileSpec, error) {
+	r := &pathResolver{gatherFacts: gatherFacts}
+
+	destPath, err := r.resolveContained(file.DestPath)
+	if err != nil {
+		return file, fmt.Errorf("invalid destPath: %w", err)
+	}
+	srcPath, err := r.resolveContained(file.SrcPath)
+	if err != nil {
+		return file, fmt.Errorf("invalid srcPath: %w", err)
+	}
+
+	file.DestPath, file.SrcPath = destPath, srcPath
+	return file, nil
+}
+
+// resolveContained resolves path like resolve, and returns an error if the
+// resolved path is not under the literal directory before its first placeholder.
+func (r *pathResolver) resolveContained(path string) (string, error) {
+	resolved, err := r.resolve(path)
+	if err != nil || !isPathTemplate(path) {
+		return resolved, err
+	}
+
+	// e.g. /var/log for /var/log/${HOSTNAME}/app.log or /var/log/app-${HOSTNAME}.log
+	base := filepath.Dir(path[:strings.Index(path, "$")])
+	rel, err := filepath.Rel(base, filepath.Clean(resolved))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s resolves to %s, outside of %s", path, resolved, base)
+	}
+	return resolved, nil
+}
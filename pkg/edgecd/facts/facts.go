@@ -0,0 +1,258 @@
+package facts
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	goruntime "runtime"
+	"strings"
+	"sync"
+)
+
+// osReleasePath is the file the distribution is read from.
+const osReleasePath = "/etc/os-release"
+
+// serialPaths are the files the serial number is read from, in order: the DMI
+// serial of x86 machines, then the device tree serial of ARM boards.
+var serialPaths = []string{
+	"/sys/class/dmi/id/product_serial",
+	"/proc/device-tree/serial-number",
+}
+
+// Facts describes the host edge-cd runs on.
+type Facts struct {
+	Hostname string `json:"hostname"`
+	// OS is the operating system, e.g. "linux"
+	OS string `json:"os"`
+	// Distro and DistroVersion are the ID and VERSION_ID of /etc/os-release, e.g. "debian" and "12"
+	Distro        string `json:"distro,omitempty"`
+	DistroVersion string `json:"distroVersion,omitempty"`
+	// Arch is the CPU architecture, e.g. "amd64"
+	Arch string `json:"arch"`
+	// Serial is the serial number of the machine, if exposed by the firmware
+	Serial string `json:"serial,omitempty"`
+	// PrimaryIP is the address of the host on its default route
+	PrimaryIP string `json:"primaryIP,omitempty"`
+	// MAC is the hardware address of the interface holding PrimaryIP, e.g. "52:54:00:12:34:56"
+	MAC        string      `json:"mac,omitempty"`
+	Interfaces []Interface `json:"interfaces,omitempty"`
+}
+
+// Interface is a network interface of the host.
+type Interface struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac,omitempty"`
+	Up        bool     `json:"up"`
+	Addresses []string `json:"addresses,omitempty"` // CIDR notation, e.g. "192.168.1.10/24"
+}
+
+// Gather collects the facts of the host. Only the hostname is required: the
+// other facts are left empty when they cannot be determined.
+func Gather() (*Facts, error) {
+	return gather(osReleasePath)
+}
+
+func gather(osRelease string) (*Facts, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	}
+
+	f := &Facts{
+		Hostname: hostname,
+		OS:       goruntime.GOOS,
+		Arch:     goruntime.GOARCH,
+	}
+
+	release, err := readOSRelease(osRelease)
+	if err != nil {
+		slog.Debug("Failed to read os-release", "path", osRelease, "error", err)
+	}
+	f.Distro = release["ID"]
+	f.DistroVersion = release["VERSION_ID"]
+
+	f.Interfaces, err = interfaces()
+	if err != nil {
+		slog.Debug("Failed to list network interfaces", "error", err)
+	}
+	f.PrimaryIP = primaryIP(f.Interfaces)
+	f.MAC = primaryMAC(f.Interfaces, f.PrimaryIP)
+	f.Serial = readSerial(serialPaths)
+
+	return f, nil
+}
+
+// Map returns the facts keyed by field name, as exposed to templates.
+func (f *Facts) Map() map[string]any {
+	return map[string]any{
+		"Hostname":      f.Hostname,
+		"OS":            f.OS,
+		"Distro":        f.Distro,
+		"DistroVersion": f.DistroVersion,
+		"Arch":          f.Arch,
+		"Serial":        f.Serial,
+		"PrimaryIP":     f.PrimaryIP,
+		"MAC":           f.MAC,
+		"Interfaces":    f.Interfaces,
+	}
+}
+
+// LogValue implements slog.LogValuer. Interfaces are omitted to keep logs short.
+func (f *Facts) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("hostname", f.Hostname),
+		slog.String("os", f.OS),
+		slog.String("distro", strings.TrimSpace(f.Distro+" "+f.DistroVersion)),
+		slog.String("arch", f.Arch),
+		slog.String("primaryIP", f.PrimaryIP),
+	)
+}
+
+// readOSRelease parses the KEY=value lines of an os-release file.
+func readOSRelease(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	release := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		release[key] = strings.Trim(value, `"'`)
+	}
+	return release, scanner.Err()
+}
+
+// interfaces lists the network interfaces of the host with their addresses.
+func interfaces() ([]Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Interface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		i := Interface{
+			Name: iface.Name,
+			MAC:  iface.HardwareAddr.String(),
+			Up:   iface.Flags&net.FlagUp != 0,
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			slog.Debug("Failed to list interface addresses", "interface", iface.Name, "error", err)
+		}
+		for _, addr := range addrs {
+			i.Addresses = append(i.Addresses, addr.String())
+		}
+		result = append(result, i)
+	}
+	return result, nil
+}
+
+// primaryIP returns the source address of the default route. Connecting a UDP
+// socket sends no packet. Without a default route, the first IPv4 address of
+// an interface that is up and not a loopback is returned.
+func primaryIP(ifaces []Interface) string {
+	if conn, err := net.Dial("udp", "192.0.2.1:9"); err == nil {
+		defer conn.Close()
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			return addr.IP.String()
+		}
+	}
+
+	for _, iface := range ifaces {
+		if !iface.Up {
+			continue
+		}
+		for _, cidr := range iface.Addresses {
+			ip, _, err := net.ParseCIDR(cidr)
+			if err != nil || ip.IsLoopback() || ip.To4() == nil {
+				continue
+			}
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// primaryMAC returns the hardware address of the interface holding ip. Without
+// such an interface, the address of the first interface that is up and has one
+// is returned.
+func primaryMAC(ifaces []Interface, ip string) string {
+	for _, iface := range ifaces {
+		for _, cidr := range iface.Addresses {
+			if addr, _, err := net.ParseCIDR(cidr); err == nil && addr.String() == ip && iface.MAC != "" {
+				return iface.MAC
+			}
+		}
+	}
+
+	for _, iface := range ifaces {
+		if iface.Up && iface.MAC != "" {
+			return iface.MAC
+		}
+	}
+	return ""
+}
+
+// readSerial returns the content of the first readable and non-empty file of
+// paths, without surrounding whitespace and NUL bytes.
+func readSerial(paths []string) string {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Debug("Failed to read serial number", "path", path, "error", err)
+			continue
+		}
+		if serial := strings.Trim(string(data), " \t\n\x00"); serial != "" {
+			return serial
+		}
+	}
+	return ""
+}
+
+// Cache holds the facts gathered once per reconciliation iteration.
+// It is safe for concurrent use.
+type Cache struct {
+	mu     sync.Mutex
+	facts  *Facts
+	gather func() (*Facts, error)
+}
+
+// NewCache creates a new empty Cache.
+func NewCache() *Cache {
+	return &Cache{gather: Gather}
+}
+
+// Get returns the cached facts, gathering them if the cache is empty.
+func (c *Cache) Get() (*Facts, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.facts == nil {
+		f, err := c.gather()
+		if err != nil {
+			return nil, err
+		}
+		c.facts = f
+	}
+	return c.facts, nil
+}
+
+// Reset empties the cache, so that the facts are gathered again on the next Get.
+func (c *Cache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.facts = nil
+}
@@ -0,0 +1,156 @@
+package facts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"testing"
+)
+
+func TestGather(t *testing.T) {
+	f, err := Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("Failed to get hostname: %v", err)
+	}
+
+	if f.Hostname != hostname {
+		t.Errorf("Hostname = %q, want %q", f.Hostname, hostname)
+	}
+	if f.OS != goruntime.GOOS {
+		t.Errorf("OS = %q, want %q", f.OS, goruntime.GOOS)
+	}
+	if f.Arch != goruntime.GOARCH {
+		t.Errorf("Arch = %q, want %q", f.Arch, goruntime.GOARCH)
+	}
+	if len(f.Interfaces) == 0 {
+		t.Error("Interfaces is empty, want at least the loopback interface")
+	}
+}
+
+func TestGather_OSRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "os-release")
+	content := "# comment\nNAME=\"Debian GNU/Linux\"\nID=debian\nVERSION_ID=\"12\"\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write os-release: %v", err)
+	}
+
+	f, err := gather(path)
+	if err != nil {
+		t.Fatalf("gather() error = %v", err)
+	}
+	if f.Distro != "debian" || f.DistroVersion != "12" {
+		t.Errorf("Distro = %q %q, want debian 12", f.Distro, f.DistroVersion)
+	}
+}
+
+func TestGather_MissingOSRelease(t *testing.T) {
+	f, err := gather(filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatalf("gather() error = %v, want missing os-release to be ignored", err)
+	}
+	if f.Distro != "" {
+		t.Errorf("Distro = %q, want empty", f.Distro)
+	}
+}
+
+func TestPrimaryIP_FallsBackToInterfaces(t *testing.T) {
+	ifaces := []Interface{
+		{Name: "lo", Up: true, Addresses: []string{"127.0.0.1/8"}},
+		{Name: "eth1", Up: false, Addresses: []string{"10.0.0.2/24"}},
+		{Name: "eth0", Up: true, Addresses: []string{"fe80::1/64", "192.168.1.10/24"}},
+	}
+
+	// The default route may exist on the test host, in which case it wins
+	ip := primaryIP(ifaces)
+	if ip == "" {
+		t.Fatal("primaryIP() returned an empty address")
+	}
+	if ip == "127.0.0.1" || ip == "10.0.0.2" {
+		t.Errorf("primaryIP() = %q, want a non-loopback address of an interface that is up", ip)
+	}
+}
+
+func TestPrimaryMAC(t *testing.T) {
+	ifaces := []Interface{
+		{Name: "lo", Up: true, Addresses: []string{"127.0.0.1/8"}},
+		{Name: "eth0", Up: true, MAC: "52:54:00:00:00:01", Addresses: []string{"10.0.0.2/24"}},
+		{Name: "eth1", Up: true, MAC: "52:54:00:00:00:02", Addresses: []string{"192.168.1.10/24"}},
+	}
+
+	if got := primaryMAC(ifaces, "192.168.1.10"); got != "52:54:00:00:00:02" {
+		t.Errorf("primaryMAC() = %q, want the MAC of the interface holding the primary IP", got)
+	}
+	if got := primaryMAC(ifaces, ""); got != "52:54:00:00:00:01" {
+		t.Errorf("primaryMAC() = %q, want the MAC of the first interface that is up", got)
+	}
+}
+
+func TestReadSerial(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "product_serial")
+	if err := os.WriteFile(empty, []byte("\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deviceTree := filepath.Join(dir, "serial-number")
+	if err := os.WriteFile(deviceTree, []byte("10000000abcdef01\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readSerial([]string{filepath.Join(dir, "missing"), empty, deviceTree})
+	if got != "10000000abcdef01" {
+		t.Errorf("readSerial() = %q, want %q", got, "10000000abcdef01")
+	}
+	if got := readSerial([]string{filepath.Join(dir, "missing")}); got != "" {
+		t.Errorf("readSerial() = %q, want empty serial", got)
+	}
+}
+
+func TestCache(t *testing.T) {
+	calls := 0
+	c := &Cache{gather: func() (*Facts, error) {
+		calls++
+		return &Facts{Hostname: "edge-1"}, nil
+	}}
+
+	for i := 0; i < 3; i++ {
+		f, err := c.Get()
+		if err != nil || f.Hostname != "edge-1" {
+			t.Fatalf("Get() = %+v, %v", f, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("gather called %d times, want 1", calls)
+	}
+
+	c.Reset()
+	if _, err := c.Get(); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("gather called %d times after Reset, want 2", calls)
+	}
+}
+
+func TestCache_DoesNotCacheErrors(t *testing.T) {
+	calls := 0
+	c := &Cache{gather: func() (*Facts, error) {
+		calls++
+		return nil, errors.New("hostname unavailable")
+	}}
+
+	if _, err := c.Get(); err == nil {
+		t.Fatal("Get() expected an error")
+	}
+	if _, err := c.Get(); err == nil {
+		t.Fatal("Get() expected an error")
+	}
+	if calls != 2 {
+		t.Errorf("gather called %d times, want failures to be retried", calls)
+	}
+}
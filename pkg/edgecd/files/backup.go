@@ -0,0 +1,67 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// FileBackup is the state of a file before the reconciliation changed it, to
+// roll the change back with FileReconciler.Restore.
+type FileBackup struct {
+	Path string
+	// Existed is false if the file was created by the reconciliation.
+	Existed bool
+	Content []byte
+	Mode    os.FileMode
+	// Target is the target of the file if it was a symlink, restored as is.
+	Target string
+}
+
+// backupFile returns the current state of the file at path, about to be changed.
+func backupFile(fsys FS, path string) (FileBackup, error) {
+	if target, err := fsys.Readlink(path); err == nil {
+		return FileBackup{Path: path, Existed: true, Target: target}, nil
+	}
+
+	info, err := fsys.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return FileBackup{Path: path}, nil
+	} else if err != nil {
+		return FileBackup{}, fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+
+	content, err := readFile(fsys, path)
+	if err != nil {
+		return FileBackup{}, fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	return FileBackup{Path: path, Existed: true, Content: content, Mode: info.Mode().Perm()}, nil
+}
+
+// Restore puts the files of backups back in the state they had before the
+// reconciliation, in the reverse order of the changes. The files created by
+// the reconciliation are removed, but not the directories created for them.
+// Each file is restored even if another one fails.
+func (fr *fileReconciler) Restore(backups []FileBackup) error {
+	var errs []error
+	for i := len(backups) - 1; i >= 0; i-- {
+		b := backups[i]
+		if !b.Existed {
+			if err := fr.fs.Remove(b.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("failed to remove %s: %w", b.Path, err))
+			}
+			continue
+		}
+		if b.Target != "" {
+			if err := fr.fs.Symlink(b.Target, b.Path); err != nil {
+				errs = append(errs, fmt.Errorf("failed to restore %s: %w", b.Path, err))
+			}
+			continue
+		}
+		if err := writeFileAtomic(fr.fs, b.Path, b.Content, b.Mode); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore %s: %w", b.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+package files
+
+import (
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+func TestRestore(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.WriteFile("/etc/app.conf", []byte("port=80"), 0600)
+	fsys.WriteFile("/etc/motd", []byte("welcome"), 0640)
+
+	fr := NewFileReconciler(WithFS(fsys))
+	result, err := fr.ReconcileFiles("/repo", "config", []userconfig.FileSpec{
+		{Type: "content", DestPath: "/etc/app.conf", Content: "port=443", FileMod: "644"},
+		{Type: "content", DestPath: "/etc/motd", Content: "welcome", FileMod: "644"},
+		{Type: "content", DestPath: "/etc/app/feature.conf", Content: "enabled=true", FileMod: "644"},
+	})
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if len(result.Backups) != len(result.Changes) {
+		t.Fatalf("Backups = %+v, want one per change %+v", result.Backups, result.Changes)
+	}
+
+	if err := fr.Restore(result.Backups); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	for path, want := range map[string]struct {
+		content string
+		mode    uint32
+	}{
+		"/etc/app.conf": {"port=80", 0600},
+		"/etc/motd":     {"welcome", 0640},
+	} {
+		got, err := fsys.ReadFile(path)
+		if err != nil || string(got) != want.content {
+			t.Errorf("content of %s = %q (error %v), want %q", path, got, err, want.content)
+		}
+		if info, err := fsys.Stat(path); err != nil || uint32(info.Mode().Perm()) != want.mode {
+			t.Errorf("mode of %s = %v (error %v), want %o", path, info.Mode().Perm(), err, want.mode)
+		}
+	}
+	// The files created by the reconciliation are removed
+	if _, err := fsys.Stat("/etc/app/feature.conf"); err == nil {
+		t.Error("/etc/app/feature.conf exists, want it removed")
+	}
+}
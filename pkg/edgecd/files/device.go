@@ -0,0 +1,167 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/facts"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownDevice is returned by DeviceHandler.Render when the config repository
+// has no spec for the device.
+var ErrUnknownDevice = errors.New("unknown device")
+
+// DeviceConfig is the rendered file set of a device, as pulled by thin agents.
+type DeviceConfig struct {
+	ID    string         `json:"id"`
+	Files []RenderedFile `json:"files"`
+}
+
+// RenderedFile is a file of a device with its rendered content.
+type RenderedFile struct {
+	DestPath string `json:"destPath"`
+	// Mode is the octal file mode, e.g. "644"
+	Mode    string `json:"mode"`
+	Content string `json:"content"`
+	// Target is the target of a symlink, whose Mode and Content are empty
+	Target string `json:"target,omitempty"`
+	// Delete is true if the file must be removed. Mode and Content are empty
+	Delete          bool     `json:"delete,omitempty"`
+	RestartServices []string `json:"restartServices,omitempty"`
+	Reboot          bool     `json:"reboot,omitempty"`
+}
+
+// DeviceHandler renders and serves the file set of the devices of a config
+// repository. Each device has a directory under devicesPath, named after its
+// ID, holding its spec and the sources of its files.
+type DeviceHandler struct {
+	configRepoPath string
+	devicesPath    string
+	specFile       string
+}
+
+// NewDeviceHandler creates a new DeviceHandler for the devices in the devicesPath
+// directory of the config repository, whose spec is named specFile.
+func NewDeviceHandler(configRepoPath, devicesPath, specFile string) *DeviceHandler {
+	return &DeviceHandler{
+		configRepoPath: configRepoPath,
+		devicesPath:    devicesPath,
+		specFile:       specFile,
+	}
+}
+
+// Render renders the files of the device with the given ID from the current
+// checkout of the config repository. Templates see the values of the device
+// spec, and the device ID as Hostname: the other host facts are only known on
+// the device and are empty.
+func (h *DeviceHandler) Render(id string) (*DeviceConfig, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("%w: invalid device ID %q", ErrUnknownDevice, id)
+	}
+
+	configPath := filepath.Join(h.devicesPath, id)
+	specPath := filepath.Join(h.configRepoPath, configPath, h.specFile)
+	raw, err := os.ReadFile(specPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: no spec at %s", ErrUnknownDevice, specPath)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read device spec: %w", err)
+	}
+
+	var spec userconfig.Spec
+	if err := yaml.Unmarshal(raw, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse device spec %s: %w", specPath, err)
+	}
+	for i, file := range spec.Files {
+		if err := file.Validate(); err != nil {
+			return nil, fmt.Errorf("device spec %s: file[%d] validation failed: %w", specPath, i, err)
+		}
+	}
+
+	fr := &fileReconciler{
+		fs:              OSFS{},
+		values:          spec.Values,
+		valuesFrom:      spec.ValuesFrom,
+		defaultFileMode: spec.DefaultFileMode,
+		gatherFacts: func() (*facts.Facts, error) {
+			return &facts.Facts{Hostname: id}, nil
+		},
+	}
+
+	data, err := fr.templateData(h.configRepoPath, configPath, spec.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	device := &DeviceConfig{ID: id, Files: []RenderedFile{}}
+	for _, file := range spec.Files {
+		if !file.IsEnabled() {
+			continue
+		}
+
+		if file.Type == "symlink" || file.Type == "delete" {
+			rendered := RenderedFile{DestPath: file.DestPath, Target: file.SrcPath, Delete: file.Type == "delete"}
+			if file.SyncBehavior != nil {
+				rendered.RestartServices = file.SyncBehavior.RestartServices
+				rendered.Reboot = file.SyncBehavior.Reboot
+			}
+			device.Files = append(device.Files, rendered)
+			continue
+		}
+
+		desired, err := fr.desiredFiles(h.configRepoPath, configPath, file, data)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range desired {
+			rendered := RenderedFile{
+				DestPath: d.destPath,
+				Mode:     fmt.Sprintf("%o", fr.fileMode(file)),
+				Content:  string(d.content),
+			}
+			if file.SyncBehavior != nil {
+				rendered.RestartServices = file.SyncBehavior.RestartServices
+				rendered.Reboot = file.SyncBehavior.Reboot
+			}
+			device.Files = append(device.Files, rendered)
+		}
+	}
+
+	return device, nil
+}
+
+// ServeHTTP writes the rendered file set of a device as JSON. The handler must
+// be registered with a pattern holding the device ID, e.g. "GET /device/{id}".
+// Unknown devices are answered with 404. The errors are logged, and answered
+// with a generic message not to disclose the paths and specs of the server.
+func (h *DeviceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	device, err := h.Render(r.PathValue("id"))
+	if errors.Is(err, ErrUnknownDevice) {
+		slog.Warn("Unknown device requested", "device", r.PathValue("id"), "error", err)
+		http.Error(w, "unknown device", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("Failed to render device config", "device", r.PathValue("id"), "error", err)
+		http.Error(w, "failed to render device config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(device); err != nil {
+		slog.Error("Failed to write device config", "error", err)
+	}
+}
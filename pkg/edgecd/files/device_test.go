@@ -0,0 +1,144 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newDeviceTestServer serves the devices of a config repository holding the
+// device "router1", whose spec renders a template and copies a directory.
+func newDeviceTestServer(t *testing.T) *httptest.Server {
+	configRepoPath := t.TempDir()
+	deviceDir := filepath.Join(configRepoPath, "devices", "router1")
+	for path, content := range map[string]string{
+		"spec.yaml": `
+values:
+  asn: 65001
+valuesFrom:
+  - file: values.yaml
+files:
+  - type: content
+    destPath: /etc/motd
+    content: "Welcome to {{ .Hostname }} in {{ .region }}\n"
+    template: true
+  - type: directory
+    srcPath: files/quagga
+    destPath: /etc/quagga
+    fileMod: "600"
+    template: true
+    syncBehavior:
+      restartServices: [bgpd]
+`,
+		"values.yaml":               "region: eu-west\n",
+		"files/quagga/bgpd.conf":    "router bgp {{ .asn }}\n",
+		"files/quagga/daemons.conf": "bgpd=yes\n",
+	} {
+		path = filepath.Join(deviceDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /device/{id}", NewDeviceHandler(configRepoPath, "devices", "spec.yaml"))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDeviceHandler_ServesRenderedConfig(t *testing.T) {
+	srv := newDeviceTestServer(t)
+
+	resp, err := http.Get(srv.URL + "/device/router1")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var got DeviceConfig
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	want := DeviceConfig{
+		ID: "router1",
+		Files: []RenderedFile{
+			{DestPath: "/etc/motd", Mode: "644", Content: "Welcome to router1 in eu-west\n"},
+			{DestPath: "/etc/quagga/bgpd.conf", Mode: "600", Content: "router bgp 65001\n", RestartServices: []string{"bgpd"}},
+			{DestPath: "/etc/quagga/daemons.conf", Mode: "600", Content: "bgpd=yes\n", RestartServices: []string{"bgpd"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("response = %+v, want %+v", got, want)
+	}
+}
+
+func TestDeviceHandler_UnknownDevice(t *testing.T) {
+	srv := newDeviceTestServer(t)
+
+	for _, path := range []string{"/device/router2", "/device/..", "/device/%2E%2E"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s status = %d, want %d", path, resp.StatusCode, http.StatusNotFound)
+		}
+		// The path of the spec is not disclosed
+		if strings.Contains(string(body), "spec.yaml") {
+			t.Errorf("GET %s body = %q, want the path of the spec not disclosed", path, body)
+		}
+	}
+}
+
+func TestDeviceHandler_RenderError(t *testing.T) {
+	configRepoPath := t.TempDir()
+	specPath := filepath.Join(configRepoPath, "devices", "router1", "spec.yaml")
+	if err := os.MkdirAll(filepath.Dir(specPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(specPath, []byte("files: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/device/router1", nil)
+	req.SetPathValue("id", "router1")
+	NewDeviceHandler(configRepoPath, "devices", "spec.yaml").ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	// Neither the path nor the parse error of the spec are disclosed
+	if body := rec.Body.String(); body != "failed to render device config\n" {
+		t.Errorf("body = %q, want %q", body, "failed to render device config\n")
+	}
+}
+
+func TestDeviceHandler_Render_InvalidID(t *testing.T) {
+	h := NewDeviceHandler(t.TempDir(), "devices", "spec.yaml")
+
+	for _, id := range []string{"", "..", "router1/../../etc"} {
+		if _, err := h.Render(id); !errors.Is(err, ErrUnknownDevice) {
+			t.Errorf("Render(%q) error = %v, want ErrUnknownDevice", id, err)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package files
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// FileDiff is the drift of a single file, as a unified diff from the on-disk
+// content to the desired content.
+type FileDiff struct {
+	DestPath string `json:"destPath"`
+	// Missing is true if the file does not exist on disk
+	Missing bool `json:"missing,omitempty"`
+	// Deleted is true if the file exists on disk but must be removed
+	Deleted bool `json:"deleted,omitempty"`
+	// Mode is the octal mode the file must be given, if its permissions drifted
+	Mode string `json:"mode,omitempty"`
+	// Owner and Group are the ownership the file must be given, if it drifted
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+	Diff  string `json:"diff"`
+}
+
+// desiredFile is the content a file must have on disk.
+type desiredFile struct {
+	destPath string
+	content  []byte
+}
+
+// Diff renders the desired content of each file spec, compares it to the file
+// on disk and returns a unified diff for each file that drifted, along with the
+// permissions and ownership it must be given if they drifted too. Files
+// matching their desired content, permissions and ownership are omitted.
+// Nothing is written.
+func (fr *fileReconciler) Diff(configRepoPath, configPath string, files []userconfig.FileSpec) ([]FileDiff, error) {
+	diffs := []FileDiff{}
+
+	data, err := fr.templateData(configRepoPath, configPath, files)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if !file.IsEnabled() {
+			continue
+		}
+
+		if file.Type == "symlink" {
+			fd, drifted, err := fr.diffSymlink(file)
+			if err != nil {
+				return nil, err
+			}
+			if drifted {
+				diffs = append(diffs, fd)
+			}
+			continue
+		}
+
+		if file.Type == "delete" {
+			fd, drifted, err := fr.diffDelete(file)
+			if err != nil {
+				return nil, err
+			}
+			if drifted {
+				diffs = append(diffs, fd)
+			}
+			continue
+		}
+
+		desired, err := fr.desiredFiles(configRepoPath, configPath, file, data)
+		if err != nil {
+			return nil, err
+		}
+
+		owner, err := resolveOwner(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range desired {
+			fd, drifted, err := fr.diffDesired(d, file, owner)
+			if err != nil {
+				return nil, err
+			}
+			if drifted {
+				diffs = append(diffs, fd)
+			}
+		}
+
+		if file.Type == "directory" && file.Prune {
+			pruned, err := fr.diffPruned(configRepoPath, configPath, file)
+			if err != nil {
+				return nil, err
+			}
+			diffs = append(diffs, pruned...)
+		}
+	}
+
+	return diffs, nil
+}
+
+// desiredFiles returns the destination files of a file spec with their rendered content.
+func (fr *fileReconciler) desiredFiles(configRepoPath, configPath string, file userconfig.FileSpec, data TemplateData) ([]desiredFile, error) {
+	switch file.Type {
+	case "file":
+		content, err := readSourceFile(fr.fs, filepath.Join(configRepoPath, configPath, file.SrcPath), file, data)
+		if err != nil {
+			return nil, err
+		}
+		destPath, err := fr.dest(file.DestPath)
+		if err != nil {
+			return nil, err
+		}
+		return []desiredFile{{destPath: destPath, content: content}}, nil
+	case "directory":
+		srcDirPath := filepath.Join(configRepoPath, configPath, file.SrcPath)
+		var desired []desiredFile
+		err := walkFiles(fr.fs, srcDirPath, func(srcPath string, isDir bool) error {
+			if isDir {
+				return nil
+			}
+
+			relPath, err := filepath.Rel(srcDirPath, srcPath)
+			if err != nil {
+				return fmt.Errorf("failed to compute relative path: %w", err)
+			}
+
+			content, err := readDesired(fr.fs, srcPath, file, data)
+			if err != nil {
+				return err
+			}
+			destPath, err := fr.dest(filepath.Join(file.DestPath, relPath))
+			if err != nil {
+				return err
+			}
+			desired = append(desired, desiredFile{destPath: destPath, content: content})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return desired, nil
+	case "content":
+		content, err := inlineDesired(file, data)
+		if err != nil {
+			return nil, err
+		}
+		destPath, err := fr.dest(file.DestPath)
+		if err != nil {
+			return nil, err
+		}
+		return []desiredFile{{destPath: destPath, content: content}}, nil
+	default:
+		return nil, fmt.Errorf("unknown file type: %s", file.Type)
+	}
+}
+
+// diffDesired returns the diff of the desired file d of file, which must be
+// owned by owner, and true if its content, permissions or ownership drifted.
+func (fr *fileReconciler) diffDesired(d desiredFile, file userconfig.FileSpec, owner fileOwner) (FileDiff, bool, error) {
+	fd := FileDiff{DestPath: d.destPath}
+	if !contentEqual(fr.fs, d.destPath, d.content) {
+		var err error
+		if fd, err = diffFile(fr.fs, d); err != nil {
+			return FileDiff{}, false, err
+		}
+		if fd.Missing {
+			return fd, true, nil
+		}
+	}
+
+	if mode := fr.fileMode(file); !modeEqual(fr.fs, d.destPath, mode) {
+		fd.Mode = fmt.Sprintf("%04o", mode.Perm())
+	}
+	if !ownerEqual(fr.fs, d.destPath, owner) {
+		fd.Owner, fd.Group = file.Owner, file.Group
+	}
+	return fd, fd.Diff != "" || fd.Mode != "" || fd.Owner != "" || fd.Group != "", nil
+}
+
+// diffFile computes the unified diff from the content of a file in fsys to its desired content.
+func diffFile(fsys FS, d desiredFile) (FileDiff, error) {
+	fd := FileDiff{DestPath: d.destPath}
+	fromFile := d.destPath
+
+	current, err := readFile(fsys, d.destPath)
+	if os.IsNotExist(err) {
+		fd.Missing = true
+		fromFile = "/dev/null"
+	} else if err != nil {
+		return FileDiff{}, fmt.Errorf("failed to read %s: %w", d.destPath, err)
+	}
+
+	diff, err := unifiedDiff(current, d.content, fromFile, d.destPath)
+	if err != nil {
+		return FileDiff{}, err
+	}
+
+	fd.Diff = diff
+	return fd, nil
+}
+
+// diffSymlink returns the diff of a "symlink" spec and true if the file at its
+// destination is not a symlink to its target. A symlink is shown as the line
+// "symlink to <target>", a file as its content.
+func (fr *fileReconciler) diffSymlink(file userconfig.FileSpec) (FileDiff, bool, error) {
+	destPath, err := fr.linkDest(file.DestPath)
+	if err != nil {
+		return FileDiff{}, false, err
+	}
+	fd := FileDiff{DestPath: destPath}
+	fromFile := destPath
+
+	var current []byte
+	if target, err := fr.fs.Readlink(destPath); err == nil {
+		if target == file.SrcPath {
+			return FileDiff{}, false, nil // No drift
+		}
+		current = []byte("symlink to " + target + "\n")
+	} else if current, err = readFile(fr.fs, destPath); os.IsNotExist(err) {
+		fd.Missing = true
+		fromFile = "/dev/null"
+	} else if err != nil {
+		return FileDiff{}, false, fmt.Errorf("failed to read %s: %w", destPath, err)
+	}
+
+	diff, err := unifiedDiff(current, []byte("symlink to "+file.SrcPath+"\n"), fromFile, destPath)
+	if err != nil {
+		return FileDiff{}, false, err
+	}
+	fd.Diff = diff
+	return fd, true, nil
+}
+
+// diffDelete returns the diff of a "delete" spec and true if a file exists at
+// its destination.
+func (fr *fileReconciler) diffDelete(file userconfig.FileSpec) (FileDiff, bool, error) {
+	destPath, err := fr.linkDest(file.DestPath)
+	if err != nil {
+		return FileDiff{}, false, err
+	}
+	return diffRemoved(fr.fs, destPath)
+}
+
+// diffPruned returns the diffs of the files the pruning of the "directory"
+// spec would remove.
+func (fr *fileReconciler) diffPruned(configRepoPath, configPath string, file userconfig.FileSpec) ([]FileDiff, error) {
+	destDirPath, err := fr.dest(file.DestPath)
+	if err != nil {
+		return nil, err
+	}
+	stale, err := fr.staleFiles(filepath.Join(configRepoPath, configPath, file.SrcPath), destDirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []FileDiff
+	for _, destPath := range stale {
+		fd, drifted, err := diffRemoved(fr.fs, destPath)
+		if err != nil {
+			return nil, err
+		}
+		if drifted {
+			diffs = append(diffs, fd)
+		}
+	}
+	return diffs, nil
+}
+
+// diffRemoved returns the diff of the file at destPath, which must be removed,
+// and true if it exists: its content, or "symlink to <target>", to /dev/null.
+func diffRemoved(fsys FS, destPath string) (FileDiff, bool, error) {
+	var current []byte
+	if target, err := fsys.Readlink(destPath); err == nil {
+		current = []byte("symlink to " + target + "\n")
+	} else if current, err = readFile(fsys, destPath); os.IsNotExist(err) {
+		return FileDiff{}, false, nil // No drift
+	} else if err != nil {
+		return FileDiff{}, false, fmt.Errorf("failed to read %s: %w", destPath, err)
+	}
+
+	diff, err := unifiedDiff(current, nil, destPath, "/dev/null")
+	if err != nil {
+		return FileDiff{}, false, err
+	}
+	return FileDiff{DestPath: destPath, Deleted: true, Diff: diff}, true, nil
+}
+
+// unifiedDiff returns the unified diff from current to desired.
+func unifiedDiff(current, desired []byte, fromFile, toFile string) (string, error) {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(current)),
+		B:        difflib.SplitLines(string(desired)),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to diff %s: %w", toFile, err)
+	}
+	return diff, nil
+}
+
+// DiffHandler serves the drift of the files as JSON.
+type DiffHandler struct {
+	fileRec        FileReconciler
+	configRepoPath string
+	configPath     string
+	files          []userconfig.FileSpec
+}
+
+// NewDiffHandler creates a new DiffHandler for the given file specs.
+func NewDiffHandler(fileRec FileReconciler, configRepoPath, configPath string, files []userconfig.FileSpec) *DiffHandler {
+	return &DiffHandler{
+		fileRec:        fileRec,
+		configRepoPath: configRepoPath,
+		configPath:     configPath,
+		files:          files,
+	}
+}
+
+// Diff returns the drift of the files.
+func (h *DiffHandler) Diff() ([]FileDiff, error) {
+	return h.fileRec.Diff(h.configRepoPath, h.configPath, h.files)
+}
+
+// ServeHTTP writes the drift of the files as JSON.
+func (h *DiffHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	diffs, err := h.Diff()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diffs); err != nil {
+		slog.Error("Failed to write file diff", "error", err)
+	}
+}
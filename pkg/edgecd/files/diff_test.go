@@ -0,0 +1,250 @@
+package files
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+func TestDiff_TemplateRenderedDrift(t *testing.T) {
+	tmpDir := t.TempDir()
+	configRepoPath := filepath.Join(tmpDir, "config-repo")
+	configPath := "devices/router1"
+	fr := NewFileReconciler()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("Failed to get hostname: %v", err)
+	}
+
+	srcDir := filepath.Join(configRepoPath, configPath, "files")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "motd"), []byte("Welcome to {{ .Hostname }}\nManaged by edge-cd\n"), 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+
+	fileDest := filepath.Join(tmpDir, "dest", "motd")
+	contentDest := filepath.Join(tmpDir, "dest", "hostname.conf")
+	if err := os.MkdirAll(filepath.Dir(fileDest), 0755); err != nil {
+		t.Fatalf("Failed to create destination directory: %v", err)
+	}
+	if err := os.WriteFile(fileDest, []byte("Welcome to old-host\nManaged by edge-cd\n"), 0644); err != nil {
+		t.Fatalf("Failed to create destination file: %v", err)
+	}
+
+	files := []userconfig.FileSpec{
+		{Type: "file", SrcPath: "files/motd", DestPath: fileDest, Template: true},
+		{Type: "content", DestPath: contentDest, Content: "name={{ .Hostname }}\n", Template: true},
+	}
+
+	diffs, err := fr.Diff(configRepoPath, configPath, files)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 2 {
+		t.Fatalf("Diff() returned %d diffs, want 2", len(diffs))
+	}
+
+	motd := diffs[0]
+	if motd.DestPath != fileDest || motd.Missing {
+		t.Errorf("diffs[0] = %+v, want a drift of existing %s", motd, fileDest)
+	}
+	for _, want := range []string{
+		"--- " + fileDest,
+		"+++ " + fileDest,
+		"-Welcome to old-host\n",
+		"+Welcome to " + hostname + "\n",
+		" Managed by edge-cd\n",
+	} {
+		if !strings.Contains(motd.Diff, want) {
+			t.Errorf("diffs[0].Diff does not contain %q:\n%s", want, motd.Diff)
+		}
+	}
+
+	conf := diffs[1]
+	if conf.DestPath != contentDest || !conf.Missing {
+		t.Errorf("diffs[1] = %+v, want a drift of missing %s", conf, contentDest)
+	}
+	for _, want := range []string{"--- /dev/null", "+name=" + hostname + "\n"} {
+		if !strings.Contains(conf.Diff, want) {
+			t.Errorf("diffs[1].Diff does not contain %q:\n%s", want, conf.Diff)
+		}
+	}
+
+	// Nothing is written
+	got, err := os.ReadFile(fileDest)
+	if err != nil {
+		t.Fatalf("Failed to read destination file: %v", err)
+	}
+	if string(got) != "Welcome to old-host\nManaged by edge-cd\n" {
+		t.Errorf("Diff() modified %s: %q", fileDest, got)
+	}
+	if _, err := os.Stat(contentDest); !os.IsNotExist(err) {
+		t.Errorf("Diff() created %s", contentDest)
+	}
+}
+
+func TestDiff_MatchingRenderedContent(t *testing.T) {
+	tmpDir := t.TempDir()
+	configRepoPath := filepath.Join(tmpDir, "config-repo")
+	configPath := "devices/router1"
+	fr := NewFileReconciler()
+
+	srcDir := filepath.Join(configRepoPath, configPath, "etc")
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "app.conf"), []byte("host={{ .Hostname }}\n"), 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+
+	files := []userconfig.FileSpec{
+		{Type: "directory", SrcPath: "etc", DestPath: filepath.Join(tmpDir, "dest"), Template: true},
+		{Type: "content", DestPath: filepath.Join(tmpDir, "dest", "id"), Content: "{{ .Hostname }}", Template: true},
+		{Type: "content", DestPath: filepath.Join(tmpDir, "dest", "raw"), Content: "{{ .Hostname }}"},
+	}
+
+	// Reconcile, then the rendered content matches the files on disk
+	if _, err := fr.ReconcileFiles(configRepoPath, configPath, files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	diffs, err := fr.Diff(configRepoPath, configPath, files)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Diff() = %+v, want no diff", diffs)
+	}
+
+	// Non-templated content is written as is
+	raw, err := os.ReadFile(filepath.Join(tmpDir, "dest", "raw"))
+	if err != nil {
+		t.Fatalf("Failed to read destination file: %v", err)
+	}
+	if string(raw) != "{{ .Hostname }}" {
+		t.Errorf("raw content = %q, want it unrendered", raw)
+	}
+}
+
+func TestDiff_TemplateError(t *testing.T) {
+	fr := NewFileReconciler()
+
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: filepath.Join(t.TempDir(), "conf"), Content: "{{ .Unknown }}", Template: true},
+	}
+
+	if _, err := fr.Diff("", "", files); err == nil {
+		t.Error("Diff() expected an error for an invalid template")
+	}
+}
+
+func TestDiff_Symlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	link := filepath.Join(tmpDir, "sites-enabled", "app")
+	fr := NewFileReconciler()
+
+	files := []userconfig.FileSpec{
+		{Type: "symlink", SrcPath: "/etc/nginx/sites-available/app", DestPath: link},
+	}
+
+	diffs, err := fr.Diff("", "", files)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 1 || !diffs[0].Missing || !strings.Contains(diffs[0].Diff, "+symlink to /etc/nginx/sites-available/app") {
+		t.Fatalf("Diff() = %+v, want the missing symlink", diffs)
+	}
+
+	if _, err := fr.ReconcileFiles("", "", files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if target, err := os.Readlink(link); err != nil || target != "/etc/nginx/sites-available/app" {
+		t.Fatalf("target of %s = %q (error %v), want /etc/nginx/sites-available/app", link, target, err)
+	}
+
+	diffs, err = fr.Diff("", "", files)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Diff() = %+v, want no diff", diffs)
+	}
+}
+
+func TestDiff_ModeAndOwnerDrift(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.WriteFile("/etc/app.conf", []byte("port=8080"), 0600)
+	fsys.WriteFile("/etc/db.conf", []byte("size=1G"), 0644)
+	fr := NewFileReconciler(WithFS(fsys))
+
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: "/etc/app.conf", Content: "port=8080", FileMod: "644"},
+		{Type: "content", DestPath: "/etc/db.conf", Content: "size=1G", FileMod: "644", Owner: "1000", Group: "1000"},
+	}
+
+	diffs, err := fr.Diff("", "", files)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	want := []FileDiff{
+		{DestPath: "/etc/app.conf", Mode: "0644"},
+		{DestPath: "/etc/db.conf", Owner: "1000", Group: "1000"},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("Diff() = %+v, want %+v", diffs, want)
+	}
+
+	if _, err := fr.ReconcileFiles("", "", files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	diffs, err = fr.Diff("", "", files)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Diff() = %+v, want no diff", diffs)
+	}
+}
+
+func TestDiffHandler_ServeHTTP(t *testing.T) {
+	want := []FileDiff{{DestPath: "/etc/motd", Diff: "-old\n+new\n"}}
+	fileRec := &MockFileReconciler{
+		DiffFunc: func(configRepoPath, configPath string, files []userconfig.FileSpec) ([]FileDiff, error) {
+			if configRepoPath != "/opt/config" || configPath != "devices/router1" {
+				t.Errorf("Diff() called with %q, %q", configRepoPath, configPath)
+			}
+			return want, nil
+		},
+	}
+	handler := NewDiffHandler(fileRec, "/opt/config", "devices/router1", nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/diff", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got []FileDiff
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(got) != 1 || got[0] != want[0] {
+		t.Errorf("response = %+v, want %+v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/diff", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
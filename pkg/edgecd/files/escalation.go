@@ -0,0 +1,111 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// EscalatedCommands are the commands an escalatedFS runs with its escalation
+// command, e.g. to allow them in a sudoers rule.
+var EscalatedCommands = []string{"mkdir", "tee", "chmod", "chown", "mv", "rm", "ln"}
+
+// escalatedFS writes with commands prefixed with an escalation command,
+// e.g. "sudo -n", when edge-cd runs as an unprivileged user. Reads are not
+// escalated: the managed files must stay readable by that user.
+type escalatedFS struct {
+	OSFS
+	prefix []string
+	// run runs args with stdin as standard input
+	run func(stdin []byte, args ...string) error
+}
+
+// WithEscalation makes the reconciler write the files with commands prefixed
+// with escalation, e.g. []string{"sudo", "-n"}. An empty escalation writes the
+// files directly.
+func WithEscalation(escalation []string) FileReconcilerOption {
+	return func(fr *fileReconciler) {
+		if len(escalation) > 0 {
+			fr.fs = &escalatedFS{prefix: escalation, run: runCommand}
+		}
+	}
+}
+
+func (e *escalatedFS) MkdirAll(name string) error {
+	return e.exec(nil, "mkdir", "-p", name)
+}
+
+// Create returns a writer buffering the content, written to name on Close.
+func (e *escalatedFS) Create(name string) (io.WriteCloser, error) {
+	return &escalatedFile{fs: e, name: name}, nil
+}
+
+func (e *escalatedFS) Chmod(name string, mode fs.FileMode) error {
+	return e.exec(nil, "chmod", fmt.Sprintf("%o", mode.Perm()), name)
+}
+
+func (e *escalatedFS) Chown(name string, uid, gid int) error {
+	return e.exec(nil, "chown", fmt.Sprintf("%d:%d", uid, gid), name)
+}
+
+func (e *escalatedFS) Rename(oldpath, newpath string) error {
+	// -T renames onto newpath, like os.Rename, instead of moving oldpath into a
+	// directory at newpath
+	return e.exec(nil, "mv", "-fT", oldpath, newpath)
+}
+
+func (e *escalatedFS) Remove(name string) error {
+	return e.exec(nil, "rm", "-f", name)
+}
+
+// Symlink runs ln -sfn, which does not follow a symlink at name to a
+// directory. A directory at name is not replaced, like with OSFS.
+func (e *escalatedFS) Symlink(target, name string) error {
+	if info, err := os.Lstat(name); err == nil && info.IsDir() {
+		return &fs.PathError{Op: "symlink", Path: name, Err: syscall.EISDIR}
+	}
+	return e.exec(nil, "ln", "-sfn", target, name)
+}
+
+func (e *escalatedFS) exec(stdin []byte, args ...string) error {
+	cmd := append(append([]string{}, e.prefix...), args...)
+	if err := e.run(stdin, cmd...); err != nil {
+		return fmt.Errorf("failed to run %q: %w", strings.Join(cmd, " "), err)
+	}
+	return nil
+}
+
+// escalatedFile is a file created by an escalatedFS.
+type escalatedFile struct {
+	fs   *escalatedFS
+	name string
+	buf  bytes.Buffer
+}
+
+func (f *escalatedFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *escalatedFile) Close() error {
+	// tee opens the file escalated, unlike "sudo cat > path"
+	return f.fs.exec(f.buf.Bytes(), "tee", f.name)
+}
+
+// runCommand runs args with stdin as standard input. The standard output is
+// discarded, e.g. the content echoed by tee, and the standard error of a failed
+// command is included in the error.
+func runCommand(stdin []byte, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+func TestReconcileFiles_Escalation(t *testing.T) {
+	destPath := filepath.Join(t.TempDir(), "etc", "motd")
+
+	type call struct {
+		stdin string
+		args  []string
+	}
+	var calls []call
+	fr := NewFileReconciler(WithEscalation([]string{"sudo", "-n"})).(*fileReconciler)
+	fr.fs.(*escalatedFS).run = func(stdin []byte, args ...string) error {
+		calls = append(calls, call{stdin: string(stdin), args: args})
+		return nil
+	}
+
+	files := []userconfig.FileSpec{{Type: "content", DestPath: destPath, Content: "welcome\n", FileMod: "600"}}
+	if _, err := fr.ReconcileFiles("", "", files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	// The file is written to a temporary file renamed over the destination
+	tmpPath := filepath.Join(filepath.Dir(destPath), ".motd.edge-cd.tmp")
+	want := []call{
+		{args: []string{"sudo", "-n", "mkdir", "-p", filepath.Dir(destPath)}},
+		{stdin: "welcome\n", args: []string{"sudo", "-n", "tee", tmpPath}},
+		{args: []string{"sudo", "-n", "chmod", "600", tmpPath}},
+		{args: []string{"sudo", "-n", "mv", "-fT", tmpPath, destPath}},
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("escalated commands = %+v, want %+v", calls, want)
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("file was written without escalation: stat error = %v", err)
+	}
+}
+
+func TestReconcileFiles_EscalationRunsCommands(t *testing.T) {
+	destPath := filepath.Join(t.TempDir(), "etc", "motd")
+	// "env" runs the commands unchanged, as sudo would
+	fr := NewFileReconciler(WithEscalation([]string{"env"}))
+
+	files := []userconfig.FileSpec{{Type: "content", DestPath: destPath, Content: "welcome\n", FileMod: "600"}}
+	if _, err := fr.ReconcileFiles("", "", files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	got, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("Failed to read destination file: %v", err)
+	}
+	if string(got) != "welcome\n" {
+		t.Errorf("content = %q, want %q", got, "welcome\n")
+	}
+	info, err := os.Stat(destPath)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("File permissions = %o, want %o", info.Mode().Perm(), 0600)
+	}
+}
@@ -2,53 +2,205 @@ package files
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"text/template"
 
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/facts"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrSourceNotFound is returned when the source of a "file" spec does not
+	// exist in the config repo.
+	ErrSourceNotFound = errors.New("source file not found")
+	// ErrEmptySource is returned when the source of a "file" spec is empty and
+	// the spec does not allow it.
+	ErrEmptySource = errors.New("source file is empty")
 )
 
 // FileReconciler reconciles file specifications to ensure files on the system
 // match those defined in the configuration repository.
 type FileReconciler interface {
 	ReconcileFiles(configRepoPath, configPath string, files []userconfig.FileSpec) (*ReconcileResult, error)
+	// Diff returns the drift between the desired and the on-disk content of the
+	// files, without writing anything.
+	Diff(configRepoPath, configPath string, files []userconfig.FileSpec) ([]FileDiff, error)
+	// Restore rolls back the changes of a reconciliation, given its Backups.
+	Restore(backups []FileBackup) error
 }
 
 // fileReconciler is the implementation of FileReconciler.
-type fileReconciler struct{}
+type fileReconciler struct {
+	values       map[string]any
+	valuesFrom   []userconfig.ValuesSource
+	gatherFacts  func() (*facts.Facts, error)
+	manifestPath string
+	fs           FS
+	// defaultFileMode is the mode of the files without FileMod
+	defaultFileMode string
+	// rootPrefix is the root filesystem the files are written to, / if empty
+	rootPrefix string
+}
+
+// FileReconcilerOption configures a FileReconciler.
+type FileReconcilerOption func(*fileReconciler)
+
+// WithValues sets the values passed to templated files, in addition to the
+// built-in host facts. See TemplateData for the precedence.
+func WithValues(values map[string]any, valuesFrom []userconfig.ValuesSource) FileReconcilerOption {
+	return func(fr *fileReconciler) {
+		fr.values = values
+		fr.valuesFrom = valuesFrom
+	}
+}
 
 // ReconcileResult contains the results of file reconciliation.
 type ReconcileResult struct {
 	ServicesToRestart []string
 	RequiresReboot    bool
+	// Changes lists the files changed on disk, in the order they were reconciled.
+	Changes []FileChange
+	// Backups holds the state of the files of Changes before they were
+	// changed, in the same order, to roll them back (see Restore).
+	Backups []FileBackup
+	// Checksums maps the DestPath of each managed file to the sha256 of its
+	// desired content. It is written as the Manifest after a successful reconciliation.
+	Checksums map[string]string
+	// Tampered lists the files modified or removed out-of-band since the last
+	// successful reconciliation, as detected with the manifest (see WithManifest).
+	Tampered []string
+	// ReadOnly lists the read-only filesystems holding destinations, detected
+	// before any write. Their drifted files are not written.
+	ReadOnly []ReadOnlyMount
+}
+
+// ReadOnlyMount is a read-only filesystem holding destination files.
+type ReadOnlyMount struct {
+	// Mount is the mount point of the filesystem.
+	Mount string
+	// Drifted lists the files under Mount that drifted but could not be
+	// updated, in the order they were reconciled.
+	Drifted []string
+}
+
+// Actions of a FileChange.
+const (
+	// ChangeContent means the file was created or its content was rewritten.
+	ChangeContent = "content"
+	// ChangeMode means only the permissions of the file, and possibly its
+	// ownership, were fixed.
+	ChangeMode = "mode"
+	// ChangeOwner means only the ownership of the file was fixed.
+	ChangeOwner = "owner"
+	// ChangeRemoved means the file was removed.
+	ChangeRemoved = "removed"
+)
+
+// FileChange is a change applied to a file during reconciliation.
+type FileChange struct {
+	Path   string
+	Action string
+}
+
+// Counts returns the number of changes per action.
+func (r *ReconcileResult) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, c := range r.Changes {
+		counts[c.Action]++
+	}
+	return counts
+}
+
+// WithFacts makes templates use the host facts of cache instead of gathering
+// them on each reconciliation.
+func WithFacts(cache *facts.Cache) FileReconcilerOption {
+	return func(fr *fileReconciler) {
+		fr.gatherFacts = cache.Get
+	}
+}
+
+// WithManifest makes the reconciler write the checksums of the managed files
+// to the Manifest at path after each successful reconciliation, and report the
+// files that no longer match it at the beginning of the next one.
+func WithManifest(path string) FileReconcilerOption {
+	return func(fr *fileReconciler) {
+		fr.manifestPath = path
+	}
+}
+
+// WithDefaultFileMode sets the octal mode, e.g. "640", of the files whose spec
+// has no FileMod. An empty mode keeps the 0644 default.
+func WithDefaultFileMode(mode string) FileReconcilerOption {
+	return func(fr *fileReconciler) {
+		fr.defaultFileMode = mode
+	}
 }
 
 // NewFileReconciler creates a new FileReconciler instance.
-func NewFileReconciler() FileReconciler {
-	return &fileReconciler{}
+func NewFileReconciler(opts ...FileReconcilerOption) FileReconciler {
+	fr := &fileReconciler{gatherFacts: facts.Gather, fs: OSFS{}}
+	for _, opt := range opts {
+		opt(fr)
+	}
+	return fr
 }
 
 // ReconcileFiles reconciles all file specifications.
 func (fr *fileReconciler) ReconcileFiles(configRepoPath, configPath string, files []userconfig.FileSpec) (*ReconcileResult, error) {
 	result := &ReconcileResult{
 		ServicesToRestart: []string{},
+		Checksums:         make(map[string]string),
+	}
+
+	// Detect the out-of-band changes cheaply, before the sources are read
+	if fr.manifestPath != "" {
+		result.Tampered = fr.tamperedFiles()
 	}
 
+	data, err := fr.templateData(configRepoPath, configPath, files)
+	if err != nil {
+		return nil, err
+	}
+
+	// Detect the read-only destinations once, instead of failing on each file
+	result.ReadOnly = fr.readOnlyMounts(files)
+
 	for _, file := range files {
+		if !file.IsEnabled() {
+			slog.Info("File spec disabled, skipping", "destPath", file.DestPath)
+			continue
+		}
+
 		switch file.Type {
 		case "file":
-			if err := fr.reconcileFile(configRepoPath, configPath, file, result); err != nil {
+			if err := fr.reconcileFile(configRepoPath, configPath, file, data, result); err != nil {
 				return nil, err
 			}
 		case "directory":
-			if err := fr.reconcileDirectory(configRepoPath, configPath, file, result); err != nil {
+			if err := fr.reconcileDirectory(configRepoPath, configPath, file, data, result); err != nil {
 				return nil, err
 			}
 		case "content":
-			if err := fr.reconcileContent(file, result); err != nil {
+			if err := fr.reconcileContent(file, data, result); err != nil {
+				return nil, err
+			}
+		case "symlink":
+			if err := fr.reconcileSymlink(file, result); err != nil {
+				return nil, err
+			}
+		case "delete":
+			if err := fr.reconcileDelete(file, result); err != nil {
 				return nil, err
 			}
 		default:
@@ -56,165 +208,672 @@ func (fr *fileReconciler) ReconcileFiles(configRepoPath, configPath string, file
 		}
 	}
 
-	return result, nil
-}
-
-// reconcileFile reconciles a single file from the config repository.
-func (fr *fileReconciler) reconcileFile(configRepoPath, configPath string, file userconfig.FileSpec, result *ReconcileResult) error {
-	srcPath := filepath.Join(configRepoPath, configPath, file.SrcPath)
-	destPath := file.DestPath
+	for _, ro := range result.ReadOnly {
+		if len(ro.Drifted) > 0 {
+			slog.Error("Drifted files not updated: destination filesystem is mounted read-only",
+				"mount", ro.Mount, "files", len(ro.Drifted))
+		}
+	}
 
-	// Check if files are identical (drift detection)
-	if filesEqual(srcPath, destPath) {
-		return nil // No drift
+	if fr.manifestPath != "" {
+		if err := WriteManifest(fr.manifestPath, result.Checksums); err != nil {
+			slog.Warn("Failed to write file manifest", "path", fr.manifestPath, "error", err)
+		}
 	}
 
-	// Drift detected - copy file
-	slog.Info("Drift detected: updating file", "destPath", destPath)
+	return result, nil
+}
 
-	// Ensure destination directory exists
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+// tamperedFiles returns the files that no longer match the manifest. A manifest
+// that cannot be read is logged and ignored.
+func (fr *fileReconciler) tamperedFiles() []string {
+	manifest, err := ReadManifest(fr.manifestPath)
+	if err != nil {
+		slog.Warn("Failed to read file manifest", "path", fr.manifestPath, "error", err)
+		return nil
 	}
 
-	// Copy file
-	if err := copyFile(srcPath, destPath); err != nil {
-		return fmt.Errorf("failed to copy file: %w", err)
+	tampered := manifest.Drifted()
+	for _, path := range tampered {
+		slog.Warn("Out-of-band change detected: file does not match the manifest", "destPath", path)
 	}
+	return tampered
+}
 
-	// Set permissions
-	fileMode := parseFileMode(file.FileMod)
-	if err := os.Chmod(destPath, fileMode); err != nil {
-		return fmt.Errorf("failed to set file permissions: %w", err)
+// reconcileFile reconciles a single file from the config repository.
+func (fr *fileReconciler) reconcileFile(configRepoPath, configPath string, file userconfig.FileSpec, data TemplateData, result *ReconcileResult) error {
+	srcPath := filepath.Join(configRepoPath, configPath, file.SrcPath)
+	destPath, err := fr.dest(file.DestPath)
+	if err != nil {
+		return err
 	}
 
-	// Track services to restart
-	if file.SyncBehavior != nil {
-		result.ServicesToRestart = append(result.ServicesToRestart, file.SyncBehavior.RestartServices...)
-		if file.SyncBehavior.Reboot {
-			result.RequiresReboot = true
-		}
+	desired, err := readSourceFile(fr.fs, srcPath, file, data)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return fr.applyFile(destPath, desired, file, result)
 }
 
-// reconcileDirectory reconciles all files from a directory in the config repository.
-func (fr *fileReconciler) reconcileDirectory(configRepoPath, configPath string, file userconfig.FileSpec, result *ReconcileResult) error {
+// reconcileDirectory reconciles all files from a directory in the config
+// repository. With Prune, the files under DestPath absent from the source are
+// then removed, like "delete" specs.
+func (fr *fileReconciler) reconcileDirectory(configRepoPath, configPath string, file userconfig.FileSpec, data TemplateData, result *ReconcileResult) error {
 	srcDirPath := filepath.Join(configRepoPath, configPath, file.SrcPath)
-	destDirPath := file.DestPath
-
-	// Ensure destination directory exists
-	if err := os.MkdirAll(destDirPath, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+	destDirPath, err := fr.dest(file.DestPath)
+	if err != nil {
+		return err
 	}
 
-	// Walk the source directory and copy all files
-	return filepath.Walk(srcDirPath, func(srcPath string, info os.FileInfo, err error) error {
-		if err != nil {
+	// Ensure destination directory exists
+	if readOnlyMount(result, destDirPath) == nil {
+		if err := fr.checkParent(destDirPath, file); err != nil {
 			return err
 		}
-
-		// Skip the root directory itself
-		if srcPath == srcDirPath {
-			return nil
+		if err := fr.fs.MkdirAll(destDirPath); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
 		}
+	}
 
+	// Walk the source directory and copy all files
+	err = walkFiles(fr.fs, srcDirPath, func(srcPath string, isDir bool) error {
 		// Compute relative path
 		relPath, err := filepath.Rel(srcDirPath, srcPath)
 		if err != nil {
 			return fmt.Errorf("failed to compute relative path: %w", err)
 		}
 
-		destPath := filepath.Join(destDirPath, relPath)
+		// Each path is resolved on its own, as a subdirectory may be a symlink
+		destPath, err := fr.dest(filepath.Join(file.DestPath, relPath))
+		if err != nil {
+			return err
+		}
 
-		if info.IsDir() {
+		if isDir {
+			if readOnlyMount(result, destPath) != nil {
+				return nil
+			}
 			// Create subdirectory
-			if err := os.MkdirAll(destPath, 0755); err != nil {
+			if err := fr.fs.MkdirAll(destPath); err != nil {
 				return fmt.Errorf("failed to create directory: %w", err)
 			}
 			return nil
 		}
 
-		// Check if files are identical (drift detection)
-		if filesEqual(srcPath, destPath) {
-			return nil // No drift
+		desired, err := readDesired(fr.fs, srcPath, file, data)
+		if err != nil {
+			return err
 		}
 
-		// Drift detected - copy file
-		slog.Info("Drift detected: updating file", "destPath", destPath)
+		return fr.applyFile(destPath, desired, file, result)
+	})
+	if err != nil || !file.Prune {
+		return err
+	}
 
-		// Copy file
-		if err := copyFile(srcPath, destPath); err != nil {
-			return fmt.Errorf("failed to copy file: %w", err)
+	stale, err := fr.staleFiles(srcDirPath, destDirPath)
+	if err != nil {
+		return err
+	}
+	for _, destPath := range stale {
+		if err := fr.removeFile(destPath, file, result); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		// Set permissions
-		fileMode := parseFileMode(file.FileMod)
-		if err := os.Chmod(destPath, fileMode); err != nil {
-			return fmt.Errorf("failed to set file permissions: %w", err)
+// staleFiles returns the files and symlinks under destDirPath, the destination
+// of a "directory" spec, that have no counterpart under its source srcDirPath,
+// in lexical order. A symlink under destDirPath is never followed, so that
+// pruning cannot escape it: it is stale itself unless the source has a file or
+// a directory at its path. The directories are not returned.
+func (fr *fileReconciler) staleFiles(srcDirPath, destDirPath string) ([]string, error) {
+	wanted := make(map[string]bool)
+	err := walkFiles(fr.fs, srcDirPath, func(srcPath string, _ bool) error {
+		relPath, err := filepath.Rel(srcDirPath, srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to compute relative path: %w", err)
 		}
+		wanted[relPath] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// Track services to restart
-		if file.SyncBehavior != nil {
-			result.ServicesToRestart = append(result.ServicesToRestart, file.SyncBehavior.RestartServices...)
-			if file.SyncBehavior.Reboot {
-				result.RequiresReboot = true
-			}
+	var stale []string
+	err = walkFiles(fr.fs, destDirPath, func(destPath string, isDir bool) error {
+		relPath, err := filepath.Rel(destDirPath, destPath)
+		if err != nil {
+			return fmt.Errorf("failed to compute relative path: %w", err)
+		}
+		if !isDir && !wanted[relPath] {
+			stale = append(stale, destPath)
 		}
-
 		return nil
 	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil // Nothing to prune
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", destDirPath, err)
+	}
+	return stale, nil
 }
 
 // reconcileContent reconciles inline content to a file.
-func (fr *fileReconciler) reconcileContent(file userconfig.FileSpec, result *ReconcileResult) error {
-	destPath := file.DestPath
+func (fr *fileReconciler) reconcileContent(file userconfig.FileSpec, data TemplateData, result *ReconcileResult) error {
+	destPath, err := fr.dest(file.DestPath)
+	if err != nil {
+		return err
+	}
+
+	desired, err := inlineDesired(file, data)
+	if err != nil {
+		return err
+	}
+
+	return fr.applyFile(destPath, desired, file, result)
+}
+
+// reconcileSymlink makes DestPath a symlink to SrcPath, replacing the file or
+// the symlink to another target found there, and records the change as a
+// ChangeContent. A symlink to SrcPath is not touched. The target itself is
+// neither created nor checked, and is not part of the checksums.
+func (fr *fileReconciler) reconcileSymlink(file userconfig.FileSpec, result *ReconcileResult) error {
+	destPath, err := fr.linkDest(file.DestPath)
+	if err != nil {
+		return err
+	}
 
-	// Check if destination file exists and matches content
-	existingContent, err := os.ReadFile(destPath)
-	if err == nil && bytes.Equal(existingContent, []byte(file.Content)) {
+	if target, err := fr.fs.Readlink(destPath); err == nil && target == file.SrcPath {
 		return nil // No drift
 	}
 
-	// Drift detected - write content
-	slog.Info("Drift detected: updating file", "destPath", destPath)
+	if ro := readOnlyMount(result, destPath); ro != nil {
+		ro.Drifted = append(ro.Drifted, destPath)
+		return nil
+	}
 
-	// Ensure destination directory exists
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+	backup, err := backupFile(fr.fs, destPath)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Drift detected: updating symlink", "destPath", destPath, "target", file.SrcPath)
+	if err := fr.checkParent(destPath, file); err != nil {
+		return err
+	}
+	if err := fr.fs.MkdirAll(filepath.Dir(destPath)); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
+	if err := fr.fs.Symlink(file.SrcPath, destPath); err != nil {
+		return fmt.Errorf("failed to create symlink: %w", err)
+	}
+
+	result.Changes = append(result.Changes, FileChange{Path: destPath, Action: ChangeContent})
+	result.Backups = append(result.Backups, backup)
+	recordSyncBehavior(file, result)
+	return nil
+}
+
+// reconcileDelete removes the file or symlink at DestPath, and records the
+// removal as a ChangeRemoved. A missing file is not drift. A directory is not
+// removed: it is an error.
+func (fr *fileReconciler) reconcileDelete(file userconfig.FileSpec, result *ReconcileResult) error {
+	destPath, err := fr.linkDest(file.DestPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fr.fs.Readlink(destPath); err != nil {
+		info, err := fr.fs.Stat(destPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // No drift
+		} else if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", destPath, err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("failed to delete %s: it is a directory", destPath)
+		}
+	}
+
+	return fr.removeFile(destPath, file, result)
+}
+
+// removeFile removes the file or symlink at destPath, which exists, and records
+// the removal of this file of the file spec as a ChangeRemoved.
+func (fr *fileReconciler) removeFile(destPath string, file userconfig.FileSpec, result *ReconcileResult) error {
+	if ro := readOnlyMount(result, destPath); ro != nil {
+		ro.Drifted = append(ro.Drifted, destPath)
+		return nil
+	}
+
+	backup, err := backupFile(fr.fs, destPath)
+	if err != nil {
+		return err
+	}
 
-	// Write content
-	fileMode := parseFileMode(file.FileMod)
-	if err := os.WriteFile(destPath, []byte(file.Content), fileMode); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	slog.Info("Drift detected: removing file", "destPath", destPath)
+	if err := fr.fs.Remove(destPath); err != nil {
+		return fmt.Errorf("failed to remove file: %w", err)
 	}
 
-	// Track services to restart
+	result.Changes = append(result.Changes, FileChange{Path: destPath, Action: ChangeRemoved})
+	result.Backups = append(result.Backups, backup)
+	recordSyncBehavior(file, result)
+	return nil
+}
+
+// applyFile makes the file at destPath hold desired with the mode and the
+// ownership of file, and records the change in result. A file whose content
+// matches but whose permissions drifted is only chmod-ed and recorded as a
+// ChangeMode, and one whose ownership only drifted is chown-ed and recorded as
+// a ChangeOwner. An empty owner or group is left unchanged. A file
+// without drift is not touched at all, so that its mtime is preserved and
+// inotify-based watchers are not triggered. A drifted file on a read-only
+// filesystem is only recorded in the Drifted files of its ReadOnlyMount.
+func (fr *fileReconciler) applyFile(destPath string, desired []byte, file userconfig.FileSpec, result *ReconcileResult) error {
+	fileMode := fr.fileMode(file)
+
+	if result.Checksums == nil {
+		result.Checksums = make(map[string]string)
+	}
+	result.Checksums[destPath] = checksum(desired)
+
+	owner, err := resolveOwner(file)
+	if err != nil {
+		return err
+	}
+
+	action := ChangeContent
+	sameContent := contentEqual(fr.fs, destPath, desired)
+	sameMode := modeEqual(fr.fs, destPath, fileMode)
+	if sameContent && sameMode && ownerEqual(fr.fs, destPath, owner) {
+		return nil // No drift
+	}
+
+	if ro := readOnlyMount(result, destPath); ro != nil {
+		// Not managed until it can be written, or the manifest would report
+		// it as tampered on each reconciliation
+		delete(result.Checksums, destPath)
+		ro.Drifted = append(ro.Drifted, destPath)
+		return nil
+	}
+
+	backup, err := backupFile(fr.fs, destPath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case sameContent && sameMode:
+		action = ChangeOwner
+		slog.Info("Drift detected: updating file ownership", "destPath", destPath, "owner", file.Owner, "group", file.Group)
+	case sameContent:
+		action = ChangeMode
+		slog.Info("Drift detected: updating file permissions", "destPath", destPath, "mode", fmt.Sprintf("%o", fileMode))
+
+		if err := fr.fs.Chmod(destPath, fileMode); err != nil {
+			return fmt.Errorf("failed to set file permissions: %w", err)
+		}
+	default:
+		slog.Info("Drift detected: updating file", "destPath", destPath)
+
+		// Ensure destination directory exists
+		if err := fr.checkParent(destPath, file); err != nil {
+			return err
+		}
+		if err := fr.fs.MkdirAll(filepath.Dir(destPath)); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+
+		// The file is replaced atomically, with its permissions already set
+		if err := writeFileAtomic(fr.fs, destPath, desired, fileMode); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+	}
+
+	if owner.isSet() {
+		if err := chownFile(fr.fs, destPath, owner); err != nil {
+			return err
+		}
+	}
+
+	result.Changes = append(result.Changes, FileChange{Path: destPath, Action: action})
+	result.Backups = append(result.Backups, backup)
+	recordSyncBehavior(file, result)
+
+	return nil
+}
+
+// recordSyncBehavior records the services to restart and the reboot of file,
+// which changed, in result.
+func recordSyncBehavior(file userconfig.FileSpec, result *ReconcileResult) {
 	if file.SyncBehavior != nil {
 		result.ServicesToRestart = append(result.ServicesToRestart, file.SyncBehavior.RestartServices...)
 		if file.SyncBehavior.Reboot {
 			result.RequiresReboot = true
 		}
 	}
+}
 
+// checkParent returns an error if the parent directory of destPath does not
+// exist and file does not create it. The parents are created by default.
+func (fr *fileReconciler) checkParent(destPath string, file userconfig.FileSpec) error {
+	if file.ShouldCreateParents() {
+		return nil
+	}
+
+	parent := filepath.Dir(destPath)
+	info, err := fr.fs.Stat(parent)
+	if err != nil {
+		return fmt.Errorf("parent directory %s of %s must exist, createParents is false: %w", parent, file.DestPath, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("parent %s of %s is not a directory", parent, file.DestPath)
+	}
 	return nil
 }
 
-// filesEqual compares two files byte-by-byte (equivalent to cmp command).
+// readOnlyMounts returns the read-only filesystems holding the destinations of
+// the enabled files. A destination that cannot be checked is assumed writable:
+// writing it reports the actual error.
+func (fr *fileReconciler) readOnlyMounts(files []userconfig.FileSpec) []ReadOnlyMount {
+	var mounts []ReadOnlyMount
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if !file.IsEnabled() {
+			continue
+		}
+		destPath, err := fr.dest(file.DestPath)
+		if file.Type == "symlink" || file.Type == "delete" {
+			// The link is written to, or the file removed from, its parent
+			destPath, err = fr.linkDest(file.DestPath)
+			destPath = filepath.Dir(destPath)
+		}
+		if err != nil {
+			continue
+		}
+		mount, err := fr.fs.ReadOnlyMount(destPath)
+		if err != nil {
+			slog.Debug("Failed to check whether the destination is read-only", "destPath", destPath, "error", err)
+			continue
+		}
+		if mount == "" || seen[mount] {
+			continue
+		}
+		seen[mount] = true
+		slog.Warn("Destination filesystem is mounted read-only", "mount", mount, "destPath", destPath)
+		mounts = append(mounts, ReadOnlyMount{Mount: mount})
+	}
+	return mounts
+}
+
+// readOnlyMount returns the innermost read-only mount of result holding path,
+// or nil.
+func readOnlyMount(result *ReconcileResult, path string) *ReadOnlyMount {
+	var found *ReadOnlyMount
+	for i := range result.ReadOnly {
+		ro := &result.ReadOnly[i]
+		if within(ro.Mount, path) && (found == nil || len(ro.Mount) > len(found.Mount)) {
+			found = ro
+		}
+	}
+	return found
+}
+
+// filesEqual compares two files byte-by-byte (equivalent to cmp command). Files
+// of different sizes differ without being read, and the others are compared
+// chunk by chunk instead of being read whole. Missing files are not equal.
 func filesEqual(path1, path2 string) bool {
-	// Read both files
-	data1, err1 := os.ReadFile(path1)
-	data2, err2 := os.ReadFile(path2)
+	info1, err1 := os.Stat(path1)
+	info2, err2 := os.Stat(path2)
+	if err1 != nil || err2 != nil || info1.Size() != info2.Size() {
+		return false
+	}
+
+	f1, err := os.Open(path1)
+	if err != nil {
+		return false
+	}
+	defer f1.Close()
+	f2, err := os.Open(path2)
+	if err != nil {
+		return false
+	}
+	defer f2.Close()
+
+	return readersEqual(f1, f2)
+}
+
+// compareChunkSize is the size of the chunks compared by readersEqual.
+const compareChunkSize = 64 << 10
+
+// readersEqual reports whether r1 and r2 hold the same bytes, reading them
+// chunk by chunk. A read error is not equal.
+func readersEqual(r1, r2 io.Reader) bool {
+	buf1 := make([]byte, compareChunkSize)
+	buf2 := make([]byte, compareChunkSize)
+	for {
+		n1, err1 := io.ReadFull(r1, buf1)
+		n2, err2 := io.ReadFull(r2, buf2)
+		if n1 != n2 || !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			return false
+		}
+		end1 := err1 == io.EOF || err1 == io.ErrUnexpectedEOF
+		end2 := err2 == io.EOF || err2 == io.ErrUnexpectedEOF
+		switch {
+		case end1 && end2:
+			return true
+		case err1 != nil || err2 != nil:
+			return false
+		}
+	}
+}
+
+// contentEqual reports whether the file at path exists in fsys and holds
+// content. Like filesEqual, the file is not read if its size differs, and is
+// otherwise compared chunk by chunk.
+func contentEqual(fsys FS, path string, content []byte) bool {
+	info, err := fsys.Stat(path)
+	if err != nil || info.Size() != int64(len(content)) {
+		return false
+	}
+
+	f, err := fsys.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return readersEqual(f, bytes.NewReader(content))
+}
 
-	// If either read fails, they're not equal
-	if err1 != nil || err2 != nil {
+// modeEqual reports whether the file at path exists in fsys and has the permissions mode.
+func modeEqual(fsys FS, path string, mode os.FileMode) bool {
+	info, err := fsys.Stat(path)
+	if err != nil {
 		return false
 	}
+	return info.Mode().Perm() == mode.Perm()
+}
+
+// TemplateData is the data available to templated file specs, e.g. {{ .Hostname }}.
+//
+// It holds the built-in host facts (see facts.Facts.Map), overridden by the values loaded from
+// valuesFrom in order, themselves overridden by the values of the spec. The
+// variables of a file spec override them all when rendering this file.
+type TemplateData map[string]any
+
+// templateData builds the TemplateData of files. It returns nil if no file is templated.
+func (fr *fileReconciler) templateData(configRepoPath, configPath string, files []userconfig.FileSpec) (TemplateData, error) {
+	templated := false
+	for _, file := range files {
+		templated = templated || file.Template
+	}
+	if !templated {
+		return nil, nil
+	}
+
+	hostFacts, err := fr.gatherFacts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to gather host facts: %w", err)
+	}
+	data := TemplateData(hostFacts.Map())
+
+	for _, source := range fr.valuesFrom {
+		values, err := loadValues(fr.fs, configRepoPath, configPath, source)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range values {
+			data[k] = v
+		}
+	}
+
+	for k, v := range fr.values {
+		data[k] = v
+	}
+	return data, nil
+}
+
+// loadValues reads the values of a valuesFrom source.
+func loadValues(fsys FS, configRepoPath, configPath string, source userconfig.ValuesSource) (map[string]any, error) {
+	if source.Env != "" {
+		value, ok := os.LookupEnv(source.Env)
+		if !ok {
+			return nil, fmt.Errorf("failed to load values: environment variable %s is not set", source.Env)
+		}
+		key := source.Key
+		if key == "" {
+			key = source.Env
+		}
+		return map[string]any{key: value}, nil
+	}
+
+	path := filepath.Join(configRepoPath, configPath, source.File)
+	raw, err := readFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load values: %w", err)
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse values file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// renderContent renders content as a text/template executed with data and the
+// variables of the file spec if it is templated, and returns it unchanged
+// otherwise. A key missing from both is an error.
+func renderContent(file userconfig.FileSpec, content []byte, data TemplateData) ([]byte, error) {
+	if !file.Template {
+		return content, nil
+	}
+
+	if len(file.Variables) > 0 {
+		fileData := make(TemplateData, len(data)+len(file.Variables))
+		for k, v := range data {
+			fileData[k] = v
+		}
+		for k, v := range file.Variables {
+			fileData[k] = v
+		}
+		data = fileData
+	}
+
+	tmpl, err := template.New(file.DestPath).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template for %s: %w", file.DestPath, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render template for %s: %w", file.DestPath, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// readSourceFile returns the content the destination of the "file" spec must
+// have, read from its source at srcPath. A missing source is an error naming
+// it, instead of an opaque read error, and so is an empty source unless the
+// spec allows it: an empty file in the config repo is more likely truncated
+// than meant to empty the destination.
+func readSourceFile(fsys FS, srcPath string, file userconfig.FileSpec, data TemplateData) ([]byte, error) {
+	info, err := fsys.Stat(srcPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s of %s", ErrSourceNotFound, file.SrcPath, file.DestPath)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read source file %s: %w", file.SrcPath, err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("source %s of %s is a directory, use the directory type", file.SrcPath, file.DestPath)
+	}
+	if info.Size() == 0 && !file.AllowEmpty {
+		return nil, fmt.Errorf("%w: %s of %s, set allowEmpty to write an empty file", ErrEmptySource, file.SrcPath, file.DestPath)
+	}
+	return readDesired(fsys, srcPath, file, data)
+}
 
-	// Byte-by-byte comparison (equivalent to cmp)
-	return bytes.Equal(data1, data2)
+// readDesired reads srcPath from fsys and returns the content its destination must have.
+func readDesired(fsys FS, srcPath string, file userconfig.FileSpec, data TemplateData) ([]byte, error) {
+	content, err := readFile(fsys, srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source file: %w", err)
+	}
+	content, err = decompress(file, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", srcPath, err)
+	}
+	return renderContent(file, content, data)
+}
+
+// inlineDesired returns the desired content of a "content" file spec: its
+// decompressed and rendered inline content.
+func inlineDesired(file userconfig.FileSpec, data TemplateData) ([]byte, error) {
+	content := []byte(file.Content)
+	if file.Compression != "" {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(file.Content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64 content of %s: %w", file.DestPath, err)
+		}
+		content = decoded
+	}
+
+	content, err := decompress(file, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress content of %s: %w", file.DestPath, err)
+	}
+	return renderContent(file, content, data)
+}
+
+// decompress returns content decompressed with the compression of the file
+// spec. Templates are rendered after decompression.
+func decompress(file userconfig.FileSpec, content []byte) ([]byte, error) {
+	switch file.Compression {
+	case "":
+		return content, nil
+	case userconfig.CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unknown compression: %s", file.Compression)
+	}
+}
+
+// fileMode returns the mode of file: its FileMod, else the default file mode of
+// the reconciler, else 0644.
+func (fr *fileReconciler) fileMode(file userconfig.FileSpec) os.FileMode {
+	if file.FileMod == "" {
+		return parseFileMode(fr.defaultFileMode)
+	}
+	return parseFileMode(file.FileMod)
 }
 
 // parseFileMode parses an octal file mode string (e.g., "755" → 0755).
@@ -233,13 +892,3 @@ func parseFileMode(modeStr string) os.FileMode {
 
 	return os.FileMode(mode)
 }
-
-// copyFile copies a file from src to dst.
-func copyFile(src, dst string) error {
-	input, err := os.ReadFile(src)
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(dst, input, 0644) // chmod happens after
-}
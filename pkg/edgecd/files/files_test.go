@@ -1,10 +1,22 @@
 package files
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"io/fs"
 	"os"
+	"os/user"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/facts"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
 )
 
@@ -46,6 +58,24 @@ func TestFilesEqual(t *testing.T) {
 			content2: "",
 			want:     false,
 		},
+		{
+			name:     "large identical files",
+			content1: strings.Repeat("a", 3*compareChunkSize+1),
+			content2: strings.Repeat("a", 3*compareChunkSize+1),
+			want:     true,
+		},
+		{
+			name:     "large files differing in the last chunk",
+			content1: strings.Repeat("a", 3*compareChunkSize) + "a",
+			content2: strings.Repeat("a", 3*compareChunkSize) + "b",
+			want:     false,
+		},
+		{
+			name:     "large files differing in size",
+			content1: strings.Repeat("a", 2*compareChunkSize),
+			content2: strings.Repeat("a", 2*compareChunkSize+1),
+			want:     false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -96,6 +126,120 @@ func TestFilesEqual_NonExistentFiles(t *testing.T) {
 	}
 }
 
+func TestContentEqual(t *testing.T) {
+	tests := []struct {
+		name     string
+		content1 string
+		content2 string
+		want     bool
+	}{
+		{
+			name:     "identical content",
+			content1: "hello world",
+			content2: "hello world",
+			want:     true,
+		},
+		{
+			name:     "different content",
+			content1: "hello",
+			content2: "world",
+			want:     false,
+		},
+		{
+			name:     "empty content",
+			content1: "",
+			content2: "",
+			want:     true,
+		},
+		{
+			name:     "one empty one not",
+			content1: "hello",
+			content2: "",
+			want:     false,
+		},
+		{
+			name:     "large identical content",
+			content1: strings.Repeat("a", 3*compareChunkSize+1),
+			content2: strings.Repeat("a", 3*compareChunkSize+1),
+			want:     true,
+		},
+		{
+			name:     "large content differing in the last chunk",
+			content1: strings.Repeat("a", 3*compareChunkSize) + "a",
+			content2: strings.Repeat("a", 3*compareChunkSize) + "b",
+			want:     false,
+		},
+		{
+			name:     "large content differing in size",
+			content1: strings.Repeat("a", 2*compareChunkSize),
+			content2: strings.Repeat("a", 2*compareChunkSize+1),
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "file.txt")
+			if err := os.WriteFile(file, []byte(tt.content1), 0644); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+
+			got := contentEqual(OSFS{}, file, []byte(tt.content2))
+			if got != tt.want {
+				t.Errorf("contentEqual() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentEqual_NonExistentFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file.txt")
+	if contentEqual(OSFS{}, file, []byte("hello")) {
+		t.Error("contentEqual() should return false when the file doesn't exist")
+	}
+	if contentEqual(OSFS{}, file, nil) {
+		t.Error("contentEqual() should return false for empty content when the file doesn't exist")
+	}
+}
+
+// readAllContentEqual reads the file whole before comparing it, as a baseline
+// for BenchmarkContentEqual.
+func readAllContentEqual(path string, content []byte) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(data, content)
+}
+
+// BenchmarkContentEqual compares a 16 MiB file to identical content, the worst
+// case, with the streaming contentEqual and by reading the file whole.
+func BenchmarkContentEqual(b *testing.B) {
+	content := bytes.Repeat([]byte("edge-cd\n"), 2<<20)
+	file := filepath.Join(b.TempDir(), "file.bin")
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		b.Fatalf("Failed to create %s: %v", file, err)
+	}
+
+	for _, bm := range []struct {
+		name  string
+		equal func(path string, content []byte) bool
+	}{
+		{name: "streaming", equal: func(path string, content []byte) bool { return contentEqual(OSFS{}, path, content) }},
+		{name: "read all", equal: readAllContentEqual},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				if !bm.equal(file, content) {
+					b.Fatal("content is not equal")
+				}
+			}
+		})
+	}
+}
+
 func TestParseFileMode(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -149,32 +293,23 @@ func TestParseFileMode(t *testing.T) {
 	}
 }
 
-func TestCopyFile(t *testing.T) {
-	tmpDir := t.TempDir()
-
-	srcFile := filepath.Join(tmpDir, "source.txt")
-	dstFile := filepath.Join(tmpDir, "dest.txt")
-
-	content := "test content"
-
-	// Create source file
-	if err := os.WriteFile(srcFile, []byte(content), 0644); err != nil {
-		t.Fatalf("Failed to create source file: %v", err)
+func TestWriteFileAtomic_WriteFailure(t *testing.T) {
+	dstFile := filepath.Join(t.TempDir(), "dest.txt")
+	if err := os.WriteFile(dstFile, []byte("original content"), 0600); err != nil {
+		t.Fatalf("Failed to create destination file: %v", err)
 	}
 
-	// Copy file
-	if err := copyFile(srcFile, dstFile); err != nil {
-		t.Fatalf("copyFile() error = %v", err)
+	// The temporary file cannot be created where a directory exists
+	if err := os.Mkdir(tmpPath(dstFile), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
 	}
-
-	// Verify destination file exists and has correct content
-	gotContent, err := os.ReadFile(dstFile)
-	if err != nil {
-		t.Fatalf("Failed to read destination file: %v", err)
+	if err := writeFileAtomic(OSFS{}, dstFile, []byte("new content"), 0644); err == nil {
+		t.Fatal("writeFileAtomic() expected an error")
 	}
 
-	if string(gotContent) != content {
-		t.Errorf("Destination file content = %q, want %q", gotContent, content)
+	got, err := os.ReadFile(dstFile)
+	if err != nil || string(got) != "original content" {
+		t.Errorf("destination content = %q (error %v), want the original", got, err)
 	}
 }
 
@@ -277,7 +412,7 @@ func TestReconcileContent(t *testing.T) {
 				ServicesToRestart: []string{},
 			}
 
-			err := fr.reconcileContent(tt.file, result)
+			err := fr.reconcileContent(tt.file, nil, result)
 			if err != nil {
 				t.Fatalf("reconcileContent() error = %v", err)
 			}
@@ -424,7 +559,7 @@ func TestReconcileFile(t *testing.T) {
 				ServicesToRestart: []string{},
 			}
 
-			err := fr.reconcileFile(configRepoPath, configPath, tt.file, result)
+			err := fr.reconcileFile(configRepoPath, configPath, tt.file, nil, result)
 			if err != nil {
 				t.Fatalf("reconcileFile() error = %v", err)
 			}
@@ -494,7 +629,7 @@ func TestReconcileDirectory(t *testing.T) {
 		ServicesToRestart: []string{},
 	}
 
-	err := fr.reconcileDirectory(configRepoPath, configPath, file, result)
+	err := fr.reconcileDirectory(configRepoPath, configPath, file, nil, result)
 	if err != nil {
 		t.Fatalf("reconcileDirectory() error = %v", err)
 	}
@@ -636,7 +771,7 @@ func TestReconcileFile_PermissionsSet(t *testing.T) {
 		ServicesToRestart: []string{},
 	}
 
-	err := fr.reconcileFile(configRepoPath, configPath, file, result)
+	err := fr.reconcileFile(configRepoPath, configPath, file, nil, result)
 	if err != nil {
 		t.Fatalf("reconcileFile() error = %v", err)
 	}
@@ -654,3 +789,949 @@ func TestReconcileFile_PermissionsSet(t *testing.T) {
 		t.Errorf("File permissions = %o, want %o", gotMode, wantMode)
 	}
 }
+
+func TestReconcileFiles_DefaultFileMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	fr := NewFileReconciler(WithDefaultFileMode("640"))
+
+	defaulted := filepath.Join(tmpDir, "defaulted.conf")
+	explicit := filepath.Join(tmpDir, "explicit.conf")
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: defaulted, Content: "a"},
+		{Type: "content", DestPath: explicit, Content: "b", FileMod: "600"},
+	}
+	if _, err := fr.ReconcileFiles("", "", files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	for path, want := range map[string]os.FileMode{defaulted: 0640, explicit: 0600} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat file: %v", err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s permissions = %o, want %o", filepath.Base(path), got, want)
+		}
+	}
+}
+
+func TestReconcileFiles_CreateParents(t *testing.T) {
+	yes, no := true, false
+	srcDir := filepath.Join("devices", "router-1", "app")
+
+	tests := []struct {
+		name          string
+		createParents *bool
+		wantErr       bool
+	}{
+		{name: "default", createParents: nil},
+		{name: "true", createParents: &yes},
+		{name: "false", createParents: &no, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		for _, fileType := range []string{"content", "directory"} {
+			t.Run(tt.name+"/"+fileType, func(t *testing.T) {
+				configRepo := t.TempDir()
+				if err := os.MkdirAll(filepath.Join(configRepo, srcDir), 0755); err != nil {
+					t.Fatalf("Failed to create source directory: %v", err)
+				}
+				if err := os.WriteFile(filepath.Join(configRepo, srcDir, "app.conf"), []byte("managed"), 0644); err != nil {
+					t.Fatalf("Failed to create source file: %v", err)
+				}
+
+				missingParent := filepath.Join(t.TempDir(), "opt", "app")
+				spec := userconfig.FileSpec{Type: fileType, CreateParents: tt.createParents}
+				wantFile := filepath.Join(missingParent, "app.conf")
+				if fileType == "content" {
+					spec.DestPath, spec.Content = wantFile, "managed"
+				} else {
+					spec.DestPath, spec.SrcPath = filepath.Join(missingParent, "conf.d"), "app"
+					wantFile = filepath.Join(spec.DestPath, "app.conf")
+				}
+
+				_, err := NewFileReconciler().ReconcileFiles(configRepo, filepath.Dir(srcDir), []userconfig.FileSpec{spec})
+				if tt.wantErr {
+					if err == nil || !strings.Contains(err.Error(), "createParents is false") {
+						t.Fatalf("ReconcileFiles() error = %v, want a missing parent error", err)
+					}
+					if _, err := os.Stat(missingParent); !os.IsNotExist(err) {
+						t.Errorf("parent directory was created: stat error = %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("ReconcileFiles() error = %v", err)
+				}
+				if got, _ := os.ReadFile(wantFile); string(got) != "managed" {
+					t.Errorf("%s content = %q, want %q", wantFile, got, "managed")
+				}
+			})
+		}
+	}
+
+	t.Run("false with existing parent", func(t *testing.T) {
+		destPath := filepath.Join(t.TempDir(), "motd")
+		spec := userconfig.FileSpec{Type: "content", DestPath: destPath, Content: "welcome", CreateParents: &no}
+
+		if _, err := NewFileReconciler().ReconcileFiles("", "", []userconfig.FileSpec{spec}); err != nil {
+			t.Fatalf("ReconcileFiles() error = %v", err)
+		}
+		if got, _ := os.ReadFile(destPath); string(got) != "welcome" {
+			t.Errorf("content = %q, want %q", got, "welcome")
+		}
+	})
+}
+
+func TestReconcileFiles_Disabled(t *testing.T) {
+	tmpDir := t.TempDir()
+	fr := NewFileReconciler()
+
+	disabled := false
+	motd := filepath.Join(tmpDir, "motd")
+	issue := filepath.Join(tmpDir, "issue")
+	if err := os.WriteFile(issue, []byte("managed out of band"), 0600); err != nil {
+		t.Fatalf("Failed to create existing file: %v", err)
+	}
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: motd, Content: "welcome", Enabled: &disabled},
+		{Type: "content", DestPath: issue, Content: "edge-cd", Enabled: &disabled},
+	}
+
+	result, err := fr.ReconcileFiles("", "", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("Changes = %v, want none for disabled specs", result.Changes)
+	}
+	if _, err := os.Stat(motd); !os.IsNotExist(err) {
+		t.Errorf("disabled file was created: stat error = %v", err)
+	}
+	if got, _ := os.ReadFile(issue); string(got) != "managed out of band" {
+		t.Errorf("disabled file content = %q, want it untouched", got)
+	}
+
+	diffs, err := fr.Diff("", "", files)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Diff() = %v, want no drift for disabled specs", diffs)
+	}
+
+	// Re-enabling the specs reconciles the files
+	for i := range files {
+		files[i].Enabled = nil
+	}
+	result, err = fr.ReconcileFiles("", "", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if len(result.Changes) != 2 {
+		t.Errorf("Changes = %v, want both files", result.Changes)
+	}
+	for path, want := range map[string]string{motd: "welcome", issue: "edge-cd"} {
+		if got, _ := os.ReadFile(path); string(got) != want {
+			t.Errorf("%s content = %q, want %q", filepath.Base(path), got, want)
+		}
+	}
+}
+
+func TestReconcileFiles_ModeOnlyDrift(t *testing.T) {
+	tmpDir := t.TempDir()
+	fr := NewFileReconciler()
+
+	modeOnly := filepath.Join(tmpDir, "mode-only.sh")
+	if err := os.WriteFile(modeOnly, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatalf("Failed to create existing file: %v", err)
+	}
+	stale := filepath.Join(tmpDir, "stale.txt")
+	if err := os.WriteFile(stale, []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to create existing file: %v", err)
+	}
+	created := filepath.Join(tmpDir, "created.txt")
+
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: modeOnly, Content: "#!/bin/sh\n", FileMod: "755"},
+		{Type: "content", DestPath: stale, Content: "new", FileMod: "644"},
+		{Type: "content", DestPath: created, Content: "new", FileMod: "600"},
+	}
+
+	result, err := fr.ReconcileFiles("", "", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	wantChanges := []FileChange{
+		{Path: modeOnly, Action: ChangeMode},
+		{Path: stale, Action: ChangeContent},
+		{Path: created, Action: ChangeContent},
+	}
+	if !reflect.DeepEqual(result.Changes, wantChanges) {
+		t.Errorf("Changes = %v, want %v", result.Changes, wantChanges)
+	}
+
+	wantCounts := map[string]int{ChangeContent: 2, ChangeMode: 1}
+	if !reflect.DeepEqual(result.Counts(), wantCounts) {
+		t.Errorf("Counts() = %v, want %v", result.Counts(), wantCounts)
+	}
+
+	info, err := os.Stat(modeOnly)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if got := info.Mode().Perm(); got != 0755 {
+		t.Errorf("File permissions = %o, want %o", got, 0755)
+	}
+
+	// The files are in sync: nothing changes on the next reconciliation
+	result, err = fr.ReconcileFiles("", "", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("Changes = %v, want none", result.Changes)
+	}
+}
+
+func TestReconcileFiles_PreservesMtime(t *testing.T) {
+	tmpDir := t.TempDir()
+	fr := NewFileReconciler()
+
+	destPath := filepath.Join(tmpDir, "motd")
+	if err := os.WriteFile(destPath, []byte("welcome\n"), 0600); err != nil {
+		t.Fatalf("Failed to create existing file: %v", err)
+	}
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(destPath, past, past); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+
+	mtime := func() time.Time {
+		t.Helper()
+		info, err := os.Stat(destPath)
+		if err != nil {
+			t.Fatalf("Failed to stat file: %v", err)
+		}
+		return info.ModTime()
+	}
+
+	// No content nor mode drift: the file is not touched
+	files := []userconfig.FileSpec{{Type: "content", DestPath: destPath, Content: "welcome\n", FileMod: "600"}}
+	result, err := fr.ReconcileFiles("", "", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("Changes = %v, want none", result.Changes)
+	}
+	if got := mtime(); !got.Equal(past) {
+		t.Errorf("mtime = %v after a no-drift reconciliation, want %v", got, past)
+	}
+
+	// A content change updates the mtime
+	files[0].Content = "hello\n"
+	if _, err := fr.ReconcileFiles("", "", files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if got := mtime(); !got.After(past) {
+		t.Errorf("mtime = %v after a content change, want after %v", got, past)
+	}
+}
+
+func gzipped(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to compress content: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to compress content: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReconcileFiles_Gzip(t *testing.T) {
+	tmpDir := t.TempDir()
+	configRepoPath := filepath.Join(tmpDir, "repo")
+	configPath := "config"
+	fr := NewFileReconciler(WithValues(map[string]any{"site": "router1"}, nil))
+
+	srcDir := filepath.Join(configRepoPath, configPath)
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "hosts.gz"), gzipped(t, "127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+
+	hosts := filepath.Join(tmpDir, "hosts")
+	motd := filepath.Join(tmpDir, "motd")
+	files := []userconfig.FileSpec{
+		{Type: "file", SrcPath: "hosts.gz", DestPath: hosts, Compression: userconfig.CompressionGzip},
+		{
+			Type:        "content",
+			DestPath:    motd,
+			Content:     base64.StdEncoding.EncodeToString(gzipped(t, "Welcome to {{ .site }}\n")),
+			Compression: userconfig.CompressionGzip,
+			Template:    true,
+		},
+	}
+
+	result, err := fr.ReconcileFiles(configRepoPath, configPath, files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if len(result.Changes) != 2 {
+		t.Errorf("Changes = %v, want 2", result.Changes)
+	}
+
+	for path, want := range map[string]string{
+		hosts: "127.0.0.1 localhost\n",
+		motd:  "Welcome to router1\n",
+	} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s content = %q, want %q", path, got, want)
+		}
+	}
+
+	// Drift is detected on the decompressed content: nothing changes on the next reconciliation
+	result, err = fr.ReconcileFiles(configRepoPath, configPath, files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("Changes = %v, want none", result.Changes)
+	}
+}
+
+func TestReconcileFiles_GzipInvalidSource(t *testing.T) {
+	fr := NewFileReconciler()
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: filepath.Join(t.TempDir(), "motd"), Content: "not base64!", Compression: userconfig.CompressionGzip},
+	}
+
+	if _, err := fr.ReconcileFiles("", "", files); err == nil {
+		t.Error("ReconcileFiles() error = nil, want an error for content that is not gzip")
+	}
+}
+
+func TestReconcileFiles_MissingSource(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.WriteFile("/etc/app.conf", []byte("port=80"), 0644)
+	fr := NewFileReconciler(WithFS(fsys))
+	files := []userconfig.FileSpec{{Type: "file", SrcPath: "app/app.conf", DestPath: "/etc/app.conf", FileMod: "644"}}
+
+	_, err := fr.ReconcileFiles("/repo", "config", files)
+	if !errors.Is(err, ErrSourceNotFound) {
+		t.Fatalf("ReconcileFiles() error = %v, want %v", err, ErrSourceNotFound)
+	}
+	if !strings.Contains(err.Error(), "app/app.conf of /etc/app.conf") {
+		t.Errorf("error %q does not name the missing source", err)
+	}
+	if got, _ := fsys.ReadFile("/etc/app.conf"); string(got) != "port=80" {
+		t.Errorf("content of /etc/app.conf = %q, want it untouched", got)
+	}
+
+	if _, err := fr.Diff("/repo", "config", files); !errors.Is(err, ErrSourceNotFound) {
+		t.Errorf("Diff() error = %v, want %v", err, ErrSourceNotFound)
+	}
+}
+
+func TestReconcileFiles_EmptySource(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowEmpty bool
+		wantErr    error
+	}{
+		{name: "empty source is an error by default", wantErr: ErrEmptySource},
+		{name: "empty source allowed", allowEmpty: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := NewMemFS()
+			fsys.WriteFile("/repo/config/app.conf", nil, 0644)
+			fsys.WriteFile("/etc/app.conf", []byte("port=80"), 0644)
+			fr := NewFileReconciler(WithFS(fsys))
+			files := []userconfig.FileSpec{
+				{Type: "file", SrcPath: "app.conf", DestPath: "/etc/app.conf", FileMod: "644", AllowEmpty: tt.allowEmpty},
+			}
+
+			_, err := fr.ReconcileFiles("/repo", "config", files)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReconcileFiles() error = %v, want %v", err, tt.wantErr)
+			}
+
+			want := ""
+			if tt.wantErr != nil {
+				want = "port=80"
+			}
+			if got, err := fsys.ReadFile("/etc/app.conf"); err != nil || string(got) != want {
+				t.Errorf("content of /etc/app.conf = %q (error %v), want %q", got, err, want)
+			}
+		})
+	}
+}
+
+func TestReconcileFiles_Symlink(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(fsys *MemFS)
+		wantChanged bool
+	}{
+		{name: "missing link is created", wantChanged: true},
+		{
+			name:  "link to the target is not touched",
+			setup: func(fsys *MemFS) { fsys.Symlink("/etc/nginx/sites-available/app", "/etc/nginx/sites-enabled/app") },
+		},
+		{
+			name:        "link to another target is retargeted",
+			setup:       func(fsys *MemFS) { fsys.Symlink("/etc/nginx/sites-available/old", "/etc/nginx/sites-enabled/app") },
+			wantChanged: true,
+		},
+		{
+			name:        "regular file is replaced",
+			setup:       func(fsys *MemFS) { fsys.WriteFile("/etc/nginx/sites-enabled/app", []byte("server {}"), 0644) },
+			wantChanged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := NewMemFS()
+			if tt.setup != nil {
+				tt.setup(fsys)
+			}
+			fr := NewFileReconciler(WithFS(fsys))
+			files := []userconfig.FileSpec{
+				{
+					Type:     "symlink",
+					SrcPath:  "/etc/nginx/sites-available/app",
+					DestPath: "/etc/nginx/sites-enabled/app",
+					SyncBehavior: &userconfig.SyncBehavior{
+						RestartServices: []string{"nginx"},
+					},
+				},
+			}
+
+			result, err := fr.ReconcileFiles("/repo", "config", files)
+			if err != nil {
+				t.Fatalf("ReconcileFiles() error = %v", err)
+			}
+
+			if target, err := fsys.Readlink("/etc/nginx/sites-enabled/app"); err != nil || target != "/etc/nginx/sites-available/app" {
+				t.Errorf("target of /etc/nginx/sites-enabled/app = %q (error %v), want /etc/nginx/sites-available/app", target, err)
+			}
+			if got := len(result.Changes) > 0; got != tt.wantChanged {
+				t.Errorf("Changes = %+v, want changed %v", result.Changes, tt.wantChanged)
+			}
+			if got := len(result.ServicesToRestart) > 0; got != tt.wantChanged {
+				t.Errorf("ServicesToRestart = %v, want restart %v", result.ServicesToRestart, tt.wantChanged)
+			}
+
+			// Restoring puts back what was there before
+			if err := fr.Restore(result.Backups); err != nil {
+				t.Fatalf("Restore() error = %v", err)
+			}
+			if tt.setup == nil {
+				if _, err := fsys.Stat("/etc/nginx/sites-enabled/app"); err == nil {
+					t.Error("/etc/nginx/sites-enabled/app exists after Restore(), want it removed")
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileFiles_Delete(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(fsys *MemFS)
+		wantChanged bool
+		wantErr     error
+	}{
+		{
+			name:        "present file is removed",
+			setup:       func(fsys *MemFS) { fsys.WriteFile("/etc/cron.d/legacy", []byte("* * * * * root true"), 0644) },
+			wantChanged: true,
+		},
+		{
+			name:        "symlink is removed, not its target",
+			setup:       func(fsys *MemFS) { fsys.Symlink("/etc/cron.d/shared", "/etc/cron.d/legacy") },
+			wantChanged: true,
+		},
+		{name: "absent file is not drift"},
+		{
+			name: "permission denied",
+			setup: func(fsys *MemFS) {
+				fsys.WriteFile("/etc/cron.d/legacy", []byte("* * * * * root true"), 0644)
+				fsys.Fail = func(op, name string) error {
+					if op == "remove" {
+						return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+					}
+					return nil
+				}
+			},
+			wantErr: fs.ErrPermission,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := NewMemFS()
+			fsys.WriteFile("/etc/cron.d/shared", []byte("0 * * * * root true"), 0644)
+			if tt.setup != nil {
+				tt.setup(fsys)
+			}
+			fr := NewFileReconciler(WithFS(fsys))
+			files := []userconfig.FileSpec{
+				{
+					Type:     "delete",
+					DestPath: "/etc/cron.d/legacy",
+					SyncBehavior: &userconfig.SyncBehavior{
+						RestartServices: []string{"cron"},
+					},
+				},
+			}
+
+			result, err := fr.ReconcileFiles("/repo", "config", files)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReconcileFiles() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if _, err := fsys.Stat("/etc/cron.d/legacy"); err != nil {
+					t.Errorf("/etc/cron.d/legacy is gone after a failed removal: %v", err)
+				}
+				return
+			}
+
+			if _, err := fsys.Stat("/etc/cron.d/legacy"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat(/etc/cron.d/legacy) error = %v, want it removed", err)
+			}
+			if _, err := fsys.Stat("/etc/cron.d/shared"); err != nil {
+				t.Errorf("/etc/cron.d/shared, the target of the removed symlink, is gone: %v", err)
+			}
+			wantChanges := 0
+			if tt.wantChanged {
+				wantChanges = 1
+			}
+			if len(result.Changes) != wantChanges || (wantChanges == 1 && result.Changes[0].Action != ChangeRemoved) {
+				t.Errorf("Changes = %+v, want %d removal", result.Changes, wantChanges)
+			}
+			if got := len(result.ServicesToRestart) > 0; got != tt.wantChanged {
+				t.Errorf("ServicesToRestart = %v, want restart %v", result.ServicesToRestart, tt.wantChanged)
+			}
+
+			// Restoring puts the removed file back
+			if err := fr.Restore(result.Backups); err != nil {
+				t.Fatalf("Restore() error = %v", err)
+			}
+			if _, err := fsys.Stat("/etc/cron.d/legacy"); tt.wantChanged && err != nil {
+				t.Errorf("/etc/cron.d/legacy not restored: %v", err)
+			}
+		})
+	}
+}
+
+func TestReconcileFiles_DeleteDirectory(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.WriteFile("/etc/cron.d/legacy", []byte("* * * * * root true"), 0644)
+	fr := NewFileReconciler(WithFS(fsys))
+
+	files := []userconfig.FileSpec{{Type: "delete", DestPath: "/etc/cron.d"}}
+	if _, err := fr.ReconcileFiles("/repo", "config", files); err == nil {
+		t.Error("ReconcileFiles() expected an error for a directory")
+	}
+	if _, err := fsys.Stat("/etc/cron.d/legacy"); err != nil {
+		t.Errorf("/etc/cron.d/legacy is gone: %v", err)
+	}
+}
+
+func TestReconcileFiles_Ownership(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("Failed to get current user: %v", err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Fatalf("Failed to get current group: %v", err)
+	}
+
+	tmpDir := t.TempDir()
+	configRepoPath := filepath.Join(tmpDir, "config-repo")
+	configPath := "devices/router1"
+	srcDir := filepath.Join(configRepoPath, configPath)
+	if err := os.MkdirAll(filepath.Join(srcDir, "conf.d"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for _, name := range []string{"app.conf", "conf.d/site.conf"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte("port=80"), 0644); err != nil {
+			t.Fatalf("Failed to create source file: %v", err)
+		}
+	}
+
+	destDir := filepath.Join(tmpDir, "dest")
+	files := []userconfig.FileSpec{
+		{Type: "file", SrcPath: "app.conf", DestPath: filepath.Join(destDir, "app.conf"), Owner: current.Username, Group: group.Name},
+		{Type: "content", DestPath: filepath.Join(destDir, "motd"), Content: "welcome", Owner: current.Uid, Group: current.Gid},
+		{Type: "directory", SrcPath: "conf.d", DestPath: filepath.Join(destDir, "conf.d"), Owner: current.Uid},
+	}
+
+	fr := NewFileReconciler()
+	if _, err := fr.ReconcileFiles(configRepoPath, configPath, files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	for _, path := range []string{"app.conf", "motd", "conf.d/site.conf"} {
+		info, err := os.Stat(filepath.Join(destDir, path))
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		st := info.Sys().(*syscall.Stat_t)
+		if strconv.Itoa(int(st.Uid)) != current.Uid || strconv.Itoa(int(st.Gid)) != current.Gid {
+			t.Errorf("owner of %s = %d:%d, want %s:%s", path, st.Uid, st.Gid, current.Uid, current.Gid)
+		}
+	}
+
+	// The ownership matches: no drift
+	result, err := fr.ReconcileFiles(configRepoPath, configPath, files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("Changes = %+v, want none", result.Changes)
+	}
+}
+
+func TestReconcileFiles_OwnershipDrift(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.WriteFile("/etc/app.conf", []byte("port=80"), 0644)
+	fsys.Chown("/etc/app.conf", 0, 0)
+	fr := NewFileReconciler(WithFS(fsys))
+
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: "/etc/app.conf", Content: "port=80", FileMod: "644", Owner: "1000"},
+		{Type: "content", DestPath: "/etc/web.conf", Content: "port=443", FileMod: "644", Group: "33"},
+	}
+	result, err := fr.ReconcileFiles("/repo", "config", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	want := []FileChange{
+		{Path: "/etc/app.conf", Action: ChangeOwner},
+		{Path: "/etc/web.conf", Action: ChangeContent},
+	}
+	if !reflect.DeepEqual(result.Changes, want) {
+		t.Errorf("Changes = %+v, want %+v", result.Changes, want)
+	}
+	// The empty group or owner is left unchanged
+	for path, want := range map[string][2]int{"/etc/app.conf": {1000, 0}, "/etc/web.conf": {0, 33}} {
+		if uid, gid, err := fsys.Owner(path); err != nil || uid != want[0] || gid != want[1] {
+			t.Errorf("owner of %s = %d:%d (error %v), want %d:%d", path, uid, gid, err, want[0], want[1])
+		}
+	}
+}
+
+func TestReconcileFiles_UnknownOwner(t *testing.T) {
+	fsys := NewMemFS()
+	fr := NewFileReconciler(WithFS(fsys))
+
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: "/etc/app.conf", Content: "port=80", Owner: "no-such-user-edge-cd"},
+	}
+	if _, err := fr.ReconcileFiles("/repo", "config", files); err == nil {
+		t.Error("ReconcileFiles() expected an error for an unknown owner")
+	}
+	if _, err := fsys.Stat("/etc/app.conf"); err == nil {
+		t.Error("/etc/app.conf was written, want it untouched")
+	}
+}
+
+func TestReconcileFiles_DirectoryPrune(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.WriteFile("/repo/config/conf.d/a.conf", []byte("a"), 0644)
+	fsys.WriteFile("/repo/config/conf.d/b.conf", []byte("b"), 0644)
+	fsys.WriteFile("/etc/app/conf.d/stale.conf", []byte("stale"), 0644)
+	fsys.WriteFile("/etc/app/conf.d/sub/old.conf", []byte("old"), 0644)
+	fsys.WriteFile("/etc/passwd", []byte("root:x:0:0"), 0644)
+	fsys.Symlink("/etc/passwd", "/etc/app/conf.d/passwd")
+	fr := NewFileReconciler(WithFS(fsys))
+
+	files := []userconfig.FileSpec{
+		{
+			Type:     "directory",
+			SrcPath:  "conf.d",
+			DestPath: "/etc/app/conf.d",
+			FileMod:  "644",
+			Prune:    true,
+			SyncBehavior: &userconfig.SyncBehavior{
+				RestartServices: []string{"app"},
+			},
+		},
+	}
+
+	reconcile := func(t *testing.T, wantChanges []FileChange) {
+		t.Helper()
+		result, err := fr.ReconcileFiles("/repo", "config", files)
+		if err != nil {
+			t.Fatalf("ReconcileFiles() error = %v", err)
+		}
+		if !reflect.DeepEqual(result.Changes, wantChanges) {
+			t.Errorf("Changes = %+v, want %+v", result.Changes, wantChanges)
+		}
+		if got := len(result.ServicesToRestart) > 0; got != (len(wantChanges) > 0) {
+			t.Errorf("ServicesToRestart = %v, want restart %v", result.ServicesToRestart, len(wantChanges) > 0)
+		}
+	}
+
+	// The stale files are removed, but not the target of a stale symlink
+	diffs, err := fr.Diff("/repo", "config", files)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	var deleted []string
+	for _, d := range diffs {
+		if d.Deleted {
+			deleted = append(deleted, d.DestPath)
+		}
+	}
+	if want := []string{"/etc/app/conf.d/passwd", "/etc/app/conf.d/stale.conf", "/etc/app/conf.d/sub/old.conf"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("Diff() deleted files = %v, want %v", deleted, want)
+	}
+
+	reconcile(t, []FileChange{
+		{Path: "/etc/app/conf.d/a.conf", Action: ChangeContent},
+		{Path: "/etc/app/conf.d/b.conf", Action: ChangeContent},
+		{Path: "/etc/app/conf.d/passwd", Action: ChangeRemoved},
+		{Path: "/etc/app/conf.d/stale.conf", Action: ChangeRemoved},
+		{Path: "/etc/app/conf.d/sub/old.conf", Action: ChangeRemoved},
+	})
+	if _, err := fsys.Stat("/etc/passwd"); err != nil {
+		t.Errorf("/etc/passwd, outside of the destination, was removed: %v", err)
+	}
+
+	// Nothing changed: no drift
+	reconcile(t, nil)
+
+	// A file removed from the source is pruned, a new one is added
+	if err := fsys.Remove("/repo/config/conf.d/b.conf"); err != nil {
+		t.Fatalf("Failed to remove source file: %v", err)
+	}
+	fsys.WriteFile("/repo/config/conf.d/c.conf", []byte("c"), 0644)
+	reconcile(t, []FileChange{
+		{Path: "/etc/app/conf.d/c.conf", Action: ChangeContent},
+		{Path: "/etc/app/conf.d/b.conf", Action: ChangeRemoved},
+	})
+
+	want := []string{"/etc/app/conf.d/a.conf", "/etc/app/conf.d/c.conf"}
+	var got []string
+	for _, path := range fsys.Paths() {
+		if info, err := fsys.Stat(path); err == nil && !info.IsDir() && strings.HasPrefix(path, "/etc/app/") {
+			got = append(got, path)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files under /etc/app = %v, want %v", got, want)
+	}
+}
+
+func TestReconcileFiles_DirectoryWithoutPrune(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.WriteFile("/repo/config/conf.d/a.conf", []byte("a"), 0644)
+	fsys.WriteFile("/etc/app/conf.d/stale.conf", []byte("stale"), 0644)
+	fr := NewFileReconciler(WithFS(fsys))
+
+	files := []userconfig.FileSpec{{Type: "directory", SrcPath: "conf.d", DestPath: "/etc/app/conf.d", FileMod: "644"}}
+	if _, err := fr.ReconcileFiles("/repo", "config", files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if got, err := fsys.ReadFile("/etc/app/conf.d/stale.conf"); err != nil || string(got) != "stale" {
+		t.Errorf("content of stale.conf = %q (error %v), want it kept", got, err)
+	}
+}
+
+func TestReconcileFiles_TemplateValues(t *testing.T) {
+	tmpDir := t.TempDir()
+	configRepoPath := filepath.Join(tmpDir, "config-repo")
+	configPath := "devices/router1"
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("Failed to get hostname: %v", err)
+	}
+
+	valuesDir := filepath.Join(configRepoPath, configPath, "values")
+	if err := os.MkdirAll(valuesDir, 0755); err != nil {
+		t.Fatalf("Failed to create values directory: %v", err)
+	}
+	valuesFile := "region: file-region\nsite: file-site\nntp:\n  - ntp1.example.com\n  - ntp2.example.com\n"
+	if err := os.WriteFile(filepath.Join(valuesDir, "common.yaml"), []byte(valuesFile), 0644); err != nil {
+		t.Fatalf("Failed to create values file: %v", err)
+	}
+	t.Setenv("EDGE_CD_TEST_RACK", "r42")
+
+	fr := NewFileReconciler(WithValues(
+		map[string]any{"site": "spec-site"},
+		[]userconfig.ValuesSource{
+			{File: "values/common.yaml"},
+			{Env: "EDGE_CD_TEST_RACK", Key: "rack"},
+		},
+	))
+
+	destPath := filepath.Join(tmpDir, "dest", "site.conf")
+	files := []userconfig.FileSpec{{
+		Type:     "content",
+		DestPath: destPath,
+		Content:  "host={{ .Hostname }} region={{ .region }} site={{ .site }} rack={{ .rack }} ntp={{ index .ntp 1 }}",
+		Template: true,
+	}}
+
+	if _, err := fr.ReconcileFiles(configRepoPath, configPath, files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	got, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("Failed to read destination file: %v", err)
+	}
+	want := "host=" + hostname + " region=file-region site=spec-site rack=r42 ntp=ntp2.example.com"
+	if string(got) != want {
+		t.Errorf("Rendered content = %q, want %q", got, want)
+	}
+}
+
+func TestReconcileFiles_TemplateVariables(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    userconfig.FileSpec
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "variables and built-in facts are substituted",
+			file: userconfig.FileSpec{
+				Content:   "{{ .Hostname }} {{ .IP }} {{ .Site }}",
+				Template:  true,
+				Variables: map[string]string{"IP": "10.0.0.1"},
+			},
+			want: "<hostname> 10.0.0.1 spec-site",
+		},
+		{
+			name: "variables override the values of the spec",
+			file: userconfig.FileSpec{
+				Content:   "{{ .Site }}",
+				Template:  true,
+				Variables: map[string]string{"Site": "file-site"},
+			},
+			want: "file-site",
+		},
+		{
+			name: "missing variable fails the file",
+			file: userconfig.FileSpec{
+				Content:  "{{ .Hostname }} {{ .IP }}",
+				Template: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "non-template file is written as is",
+			file: userconfig.FileSpec{Content: "{{ .Hostname }} {{ .IP }}"},
+			want: "{{ .Hostname }} {{ .IP }}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := NewMemFS()
+			fsys.WriteFile("/etc/app.conf", []byte("previous"), 0644)
+			cache := facts.NewCache()
+			hostFacts, err := cache.Get()
+			if err != nil {
+				t.Fatalf("Failed to get facts: %v", err)
+			}
+			fr := NewFileReconciler(WithFS(fsys), WithFacts(cache), WithValues(map[string]any{"Site": "spec-site"}, nil))
+
+			file := tt.file
+			file.Type = "content"
+			file.DestPath = "/etc/app.conf"
+			file.FileMod = "644"
+
+			_, err = fr.ReconcileFiles("/repo", "config", []userconfig.FileSpec{file})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReconcileFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			want := strings.ReplaceAll(tt.want, "<hostname>", hostFacts.Hostname)
+			if tt.wantErr {
+				want = "previous"
+			}
+			if got, err := fsys.ReadFile("/etc/app.conf"); err != nil || string(got) != want {
+				t.Errorf("content of /etc/app.conf = %q (error %v), want %q", got, err, want)
+			}
+		})
+	}
+}
+
+func TestReconcileFiles_ValuesOverrideFacts(t *testing.T) {
+	destPath := filepath.Join(t.TempDir(), "hostname.conf")
+	fr := NewFileReconciler(WithValues(map[string]any{"Hostname": "edge-override"}, nil))
+
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: destPath, Content: "{{ .Hostname }}", Template: true},
+	}
+	if _, err := fr.ReconcileFiles("", "", files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	got, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("Failed to read destination file: %v", err)
+	}
+	if string(got) != "edge-override" {
+		t.Errorf("Rendered content = %q, want the value to override the host fact", got)
+	}
+}
+
+func TestReconcileFiles_MissingValuesEnv(t *testing.T) {
+	fr := NewFileReconciler(WithValues(nil, []userconfig.ValuesSource{{Env: "EDGE_CD_TEST_UNSET_VALUE"}}))
+
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: filepath.Join(t.TempDir(), "conf"), Content: "{{ .Hostname }}", Template: true},
+	}
+	if _, err := fr.ReconcileFiles("", "", files); err == nil {
+		t.Error("ReconcileFiles() expected an error for an unset values environment variable")
+	}
+}
+
+func TestReconcileFiles_HostFacts(t *testing.T) {
+	destPath := filepath.Join(t.TempDir(), "facts.conf")
+	cache := facts.NewCache()
+	fr := NewFileReconciler(WithFacts(cache))
+
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: destPath, Content: "{{ .OS }}/{{ .Arch }} {{ .Hostname }}", Template: true},
+	}
+	if _, err := fr.ReconcileFiles("", "", files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	hostFacts, err := cache.Get()
+	if err != nil {
+		t.Fatalf("Failed to get facts: %v", err)
+	}
+	got, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("Failed to read destination file: %v", err)
+	}
+	want := hostFacts.OS + "/" + hostFacts.Arch + " " + hostFacts.Hostname
+	if string(got) != want {
+		t.Errorf("Rendered content = %q, want %q", got, want)
+	}
+}
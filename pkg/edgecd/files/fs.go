@@ -0,0 +1,220 @@
+package files
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// FS is the filesystem the reconciler reads the sources and reads and writes
+// the destination files with. OSFS is the default; MemFS keeps the files in
+// memory for testing.
+type FS interface {
+	Open(name string) (io.ReadCloser, error)
+	// Create creates or truncates the file, with the 0644 mode if created
+	Create(name string) (io.WriteCloser, error)
+	Stat(name string) (fs.FileInfo, error)
+	Chmod(name string, mode fs.FileMode) error
+	Chown(name string, uid, gid int) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	MkdirAll(name string) error
+	Readlink(name string) (string, error)
+	// Symlink replaces name, if it exists and is not a directory, with a
+	// symlink to target
+	Symlink(target, name string) error
+	// ReadOnlyMount returns the mount point of the filesystem holding name, or
+	// its closest existing parent, if it is mounted read-only, "" otherwise
+	ReadOnlyMount(name string) (string, error)
+}
+
+// WithFS makes the reconciler use fsys instead of the host filesystem.
+func WithFS(fsys FS) FileReconcilerOption {
+	return func(fr *fileReconciler) {
+		fr.fs = fsys
+	}
+}
+
+// OSFS is the host filesystem, accessed with the privileges of edge-cd.
+type OSFS struct{}
+
+func (OSFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (OSFS) Create(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (OSFS) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+func (OSFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OSFS) MkdirAll(name string) error {
+	return os.MkdirAll(name, 0755)
+}
+
+func (OSFS) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// Symlink creates the symlink under a temporary name in the same directory and
+// renames it over name, so that name is replaced atomically.
+func (OSFS) Symlink(target, name string) error {
+	tmp := tmpPath(name)
+	// Best effort: a previous attempt may have been interrupted
+	_ = os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// stRdonly is the ST_RDONLY flag of statfs(2).
+const stRdonly = 0x1
+
+func (OSFS) ReadOnlyMount(name string) (string, error) {
+	path := filepath.Clean(name)
+	for {
+		if _, err := os.Lstat(path); err == nil || path == "/" || path == "." {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err != nil {
+		return "", err
+	}
+	if statfs.Flags&stRdonly == 0 {
+		return "", nil
+	}
+
+	// The mount point is the topmost parent on the same device
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", err
+	}
+	for path != "/" && path != "." {
+		var parent syscall.Stat_t
+		if err := syscall.Stat(filepath.Dir(path), &parent); err != nil || parent.Dev != st.Dev {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+	return path, nil
+}
+
+// readFile reads the whole file at name from fsys.
+func readFile(fsys FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// writeFileAtomic replaces the file at name with content and mode. The content
+// is written to a temporary file in the same directory, which is renamed over
+// name once complete: a failed or interrupted write leaves the previous file
+// intact, and readers never see a partially written or mis-permissioned file.
+// The replaced file keeps its owner and group, e.g. a service user.
+func writeFileAtomic(fsys FS, name string, content []byte, mode fs.FileMode) error {
+	tmp := tmpPath(name)
+	uid, gid, ownerErr := statOwner(fsys, name)
+
+	f, err := fsys.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if s, ok := f.(interface{ Sync() error }); ok && err == nil {
+		err = s.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && ownerErr == nil {
+		// Before the chmod, as chown clears the setuid and setgid bits
+		err = preserveOwner(fsys, tmp, uid, gid)
+	}
+	if err == nil {
+		err = fsys.Chmod(tmp, mode)
+	}
+	if err == nil {
+		err = fsys.Rename(tmp, name)
+	}
+	if err != nil {
+		// Best effort: the temporary file may not exist
+		_ = fsys.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", name, err)
+	}
+	return nil
+}
+
+// preserveOwner gives the file at tmp the owner uid and group gid of the file
+// it replaces. It does nothing if tmp already has them, e.g. as both were
+// written by the same user.
+func preserveOwner(fsys FS, tmp string, uid, gid int) error {
+	tmpUID, tmpGID, err := statOwner(fsys, tmp)
+	if err == nil && tmpUID == uid && tmpGID == gid {
+		return nil
+	}
+	return fsys.Chown(tmp, uid, gid)
+}
+
+// tmpPath returns the temporary path name is written to before it is renamed
+// over name.
+func tmpPath(name string) string {
+	return filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".edge-cd.tmp")
+}
+
+// walkFiles calls fn for each file and directory under root, in lexical order
+// and excluding root itself, like filepath.Walk.
+func walkFiles(fsys FS, root string, fn func(path string, isDir bool) error) error {
+	entries, err := fsys.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		if err := fn(path, entry.IsDir()); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if err := walkFiles(fsys, path, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
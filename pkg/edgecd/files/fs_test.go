@@ -0,0 +1,253 @@
+package files
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// recordWrites makes fsys record the mutating operations in ops.
+func recordWrites(fsys *MemFS, ops *[]string) {
+	fsys.Fail = func(op, name string) error {
+		switch op {
+		case "create", "write", "chmod", "chown", "rename", "remove", "mkdir":
+			*ops = append(*ops, op+" "+name)
+		}
+		return nil
+	}
+}
+
+func TestMemFS_DriftDetection(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.WriteFile("/repo/config/motd", []byte("welcome"), 0644)
+	fsys.WriteFile("/etc/motd", []byte("welcome"), 0644)
+	fsys.WriteFile("/etc/issue", []byte("old"), 0644)
+	fsys.WriteFile("/etc/hostname", []byte("edge"), 0666)
+
+	fr := NewFileReconciler(WithFS(fsys))
+	files := []userconfig.FileSpec{
+		{Type: "file", SrcPath: "motd", DestPath: "/etc/motd", FileMod: "644"},
+		{Type: "content", DestPath: "/etc/issue", Content: "new", FileMod: "644"},
+		{Type: "content", DestPath: "/etc/hostname", Content: "edge", FileMod: "644"},
+		{Type: "content", DestPath: "/etc/app/app.conf", Content: "port=80", FileMod: "600"},
+	}
+
+	diffs, err := fr.Diff("/repo", "config", files)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	var drifted []string
+	for _, d := range diffs {
+		drifted = append(drifted, d.DestPath)
+	}
+	// Diff reports the same drift as the reconciliation fixes, permissions included
+	if want := []string{"/etc/issue", "/etc/hostname", "/etc/app/app.conf"}; !reflect.DeepEqual(drifted, want) {
+		t.Errorf("Diff() drifted files = %v, want %v", drifted, want)
+	}
+
+	var ops []string
+	recordWrites(fsys, &ops)
+	result, err := fr.ReconcileFiles("/repo", "config", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	wantChanges := []FileChange{
+		{Path: "/etc/issue", Action: ChangeContent},
+		{Path: "/etc/hostname", Action: ChangeMode},
+		{Path: "/etc/app/app.conf", Action: ChangeContent},
+	}
+	if !reflect.DeepEqual(result.Changes, wantChanges) {
+		t.Errorf("Changes = %+v, want %+v", result.Changes, wantChanges)
+	}
+	for _, op := range ops {
+		if strings.HasSuffix(op, "/etc/motd") {
+			t.Errorf("file without drift was touched: %s", op)
+		}
+	}
+
+	for path, want := range map[string]string{"/etc/issue": "new", "/etc/app/app.conf": "port=80"} {
+		if got, err := fsys.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("content of %s = %q (error %v), want %q", path, got, err, want)
+		}
+	}
+	info, err := fsys.Stat("/etc/app/app.conf")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode of /etc/app/app.conf = %o, want 600", info.Mode().Perm())
+	}
+
+	// A second reconciliation finds no drift and writes nothing
+	ops = nil
+	result, err = fr.ReconcileFiles("/repo", "config", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if len(result.Changes) != 0 || len(ops) != 0 {
+		t.Errorf("second reconciliation: Changes = %v, operations = %v, want none", result.Changes, ops)
+	}
+}
+
+func TestMemFS_AtomicWrite(t *testing.T) {
+	files := []userconfig.FileSpec{{Type: "content", DestPath: "/etc/app.conf", Content: "port=443", FileMod: "600"}}
+
+	t.Run("content and mode are set before the rename", func(t *testing.T) {
+		fsys := NewMemFS()
+		fsys.WriteFile("/etc/app.conf", []byte("port=80"), 0644)
+		var ops []string
+		recordWrites(fsys, &ops)
+
+		if _, err := NewFileReconciler(WithFS(fsys)).ReconcileFiles("", "", files); err != nil {
+			t.Fatalf("ReconcileFiles() error = %v", err)
+		}
+
+		want := []string{
+			"mkdir /etc",
+			"create /etc/.app.conf.edge-cd.tmp",
+			"write /etc/.app.conf.edge-cd.tmp",
+			"chmod /etc/.app.conf.edge-cd.tmp",
+			"rename /etc/app.conf",
+		}
+		if !reflect.DeepEqual(ops, want) {
+			t.Errorf("operations = %v, want %v", ops, want)
+		}
+		if got, _ := fsys.ReadFile("/etc/app.conf"); string(got) != "port=443" {
+			t.Errorf("content = %q, want %q", got, "port=443")
+		}
+	})
+
+	t.Run("ownership is preserved", func(t *testing.T) {
+		fsys := NewMemFS()
+		fsys.WriteFile("/etc/app.conf", []byte("port=80"), 0644)
+		fsys.Chown("/etc/app.conf", 1000, 1000)
+		var ops []string
+		recordWrites(fsys, &ops)
+
+		if _, err := NewFileReconciler(WithFS(fsys)).ReconcileFiles("", "", files); err != nil {
+			t.Fatalf("ReconcileFiles() error = %v", err)
+		}
+
+		want := []string{
+			"mkdir /etc",
+			"create /etc/.app.conf.edge-cd.tmp",
+			"write /etc/.app.conf.edge-cd.tmp",
+			"chown /etc/.app.conf.edge-cd.tmp",
+			"chmod /etc/.app.conf.edge-cd.tmp",
+			"rename /etc/app.conf",
+		}
+		if !reflect.DeepEqual(ops, want) {
+			t.Errorf("operations = %v, want %v", ops, want)
+		}
+		if uid, gid, err := fsys.Owner("/etc/app.conf"); err != nil || uid != 1000 || gid != 1000 {
+			t.Errorf("owner = %d:%d (error %v), want 1000:1000", uid, gid, err)
+		}
+	})
+
+	for _, failedOp := range []string{"write", "chown", "chmod", "rename"} {
+		t.Run(failedOp+" failure keeps the original file", func(t *testing.T) {
+			fsys := NewMemFS()
+			fsys.WriteFile("/etc/app.conf", []byte("port=80"), 0644)
+			fsys.Chown("/etc/app.conf", 1000, 1000)
+			fsys.Fail = func(op, name string) error {
+				if op == failedOp {
+					return syscall.EIO
+				}
+				return nil
+			}
+
+			_, err := NewFileReconciler(WithFS(fsys)).ReconcileFiles("", "", files)
+			if !errors.Is(err, syscall.EIO) {
+				t.Fatalf("ReconcileFiles() error = %v, want %v", err, syscall.EIO)
+			}
+
+			if got, _ := fsys.ReadFile("/etc/app.conf"); string(got) != "port=80" {
+				t.Errorf("content = %q, want the original %q", got, "port=80")
+			}
+			if want := []string{"/", "/etc", "/etc/app.conf"}; !reflect.DeepEqual(fsys.Paths(), want) {
+				t.Errorf("paths = %v, want %v without the temporary file", fsys.Paths(), want)
+			}
+		})
+	}
+}
+
+func TestMemFS_Directory(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.WriteFile("/repo/config/app/a.conf", []byte("a"), 0644)
+	fsys.WriteFile("/repo/config/app/sub/b.conf", []byte("b"), 0644)
+	// The image has a merged /usr
+	fsys.WriteFile("/mnt/target/usr/lib/.keep", nil, 0644)
+	fsys.Symlink("usr/lib", "/mnt/target/lib")
+
+	fr := NewFileReconciler(WithFS(fsys), WithRootPrefix("/mnt/target"))
+	files := []userconfig.FileSpec{{Type: "directory", SrcPath: "app", DestPath: "/lib/app", FileMod: "644"}}
+
+	result, err := fr.ReconcileFiles("/repo", "config", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	wantChanges := []FileChange{
+		{Path: "/mnt/target/usr/lib/app/a.conf", Action: ChangeContent},
+		{Path: "/mnt/target/usr/lib/app/sub/b.conf", Action: ChangeContent},
+	}
+	if !reflect.DeepEqual(result.Changes, wantChanges) {
+		t.Errorf("Changes = %+v, want %+v", result.Changes, wantChanges)
+	}
+	if got, _ := fsys.ReadFile("/mnt/target/usr/lib/app/sub/b.conf"); string(got) != "b" {
+		t.Errorf("content of b.conf = %q, want %q", got, "b")
+	}
+}
+
+func TestMemFS_ReadOnlyMount(t *testing.T) {
+	fsys := NewMemFS()
+	fsys.WriteFile("/repo/config/app/a.conf", []byte("a"), 0644)
+	fsys.WriteFile("/repo/config/app/b.conf", []byte("b"), 0644)
+	fsys.WriteFile("/etc/motd", []byte("welcome"), 0644)
+	fsys.WriteFile("/etc/issue", []byte("old"), 0644)
+	fsys.WriteFile("/var/lib/app/state", []byte("old"), 0644)
+	fsys.MountReadOnly("/etc")
+
+	var ops []string
+	recordWrites(fsys, &ops)
+	fr := NewFileReconciler(WithFS(fsys))
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: "/etc/motd", Content: "welcome", FileMod: "644"},
+		{Type: "content", DestPath: "/etc/issue", Content: "new", FileMod: "644"},
+		{Type: "directory", SrcPath: "app", DestPath: "/etc/app", FileMod: "644"},
+		{Type: "content", DestPath: "/var/lib/app/state", Content: "new", FileMod: "644"},
+	}
+
+	result, err := fr.ReconcileFiles("/repo", "config", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v, want the read-only files reported in the result", err)
+	}
+
+	// A single entry for the mount, listing only the drifted files
+	wantReadOnly := []ReadOnlyMount{{Mount: "/etc", Drifted: []string{"/etc/issue", "/etc/app/a.conf", "/etc/app/b.conf"}}}
+	if !reflect.DeepEqual(result.ReadOnly, wantReadOnly) {
+		t.Errorf("ReadOnly = %+v, want %+v", result.ReadOnly, wantReadOnly)
+	}
+	if _, ok := result.Checksums["/etc/issue"]; ok {
+		t.Errorf("Checksums has an entry for /etc/issue, which was not written")
+	}
+
+	// The writable files are still reconciled, without attempting the read-only ones
+	wantChanges := []FileChange{{Path: "/var/lib/app/state", Action: ChangeContent}}
+	if !reflect.DeepEqual(result.Changes, wantChanges) {
+		t.Errorf("Changes = %+v, want %+v", result.Changes, wantChanges)
+	}
+	for _, op := range ops {
+		if strings.HasPrefix(strings.SplitN(op, " ", 2)[1], "/etc") {
+			t.Errorf("write attempted on the read-only mount: %s", op)
+		}
+	}
+	if got, _ := fsys.ReadFile("/var/lib/app/state"); string(got) != "new" {
+		t.Errorf("content of /var/lib/app/state = %q, want %q", got, "new")
+	}
+}
@@ -0,0 +1,87 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Manifest maps the DestPath of each managed file to the hex-encoded sha256 of
+// the content it had after the last successful reconciliation.
+type Manifest map[string]string
+
+// ReadManifest reads the manifest at path. A missing manifest is empty.
+func ReadManifest(path string) (Manifest, error) {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Manifest{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// WriteManifest writes m to path. The manifest is written to a temporary file
+// renamed over path, so that a crash never leaves a truncated manifest.
+func WriteManifest(path string, m Manifest) error {
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// UpdateManifest sets the checksums of the files of m in the manifest at path,
+// keeping the entries of the other files, e.g. after reconciling a subset of
+// the managed files.
+func UpdateManifest(path string, m Manifest) error {
+	manifest, err := ReadManifest(path)
+	if err != nil {
+		return err
+	}
+	for file, sum := range m {
+		manifest[file] = sum
+	}
+	return WriteManifest(path, manifest)
+}
+
+// Drifted returns the sorted paths of the files of m that were modified or
+// removed since the manifest was written. Only the files on disk are read.
+func (m Manifest) Drifted() []string {
+	drifted := []string{}
+	for path, sum := range m {
+		content, err := os.ReadFile(path)
+		if err != nil || checksum(content) != sum {
+			drifted = append(drifted, path)
+		}
+	}
+	sort.Strings(drifted)
+	return drifted
+}
+
+// checksum returns the hex-encoded sha256 of content.
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
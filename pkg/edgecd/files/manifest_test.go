@@ -0,0 +1,138 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestReconcileFiles_WritesManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	manifestPath := filepath.Join(tmpDir, "state", "manifest.json")
+	motd := filepath.Join(tmpDir, "motd")
+	hosts := filepath.Join(tmpDir, "hosts")
+	fr := NewFileReconciler(WithManifest(manifestPath))
+
+	// hosts is already in sync: it is managed, hence in the manifest, too
+	if err := os.WriteFile(hosts, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: motd, Content: "welcome\n"},
+		{Type: "content", DestPath: hosts, Content: "127.0.0.1 localhost\n"},
+	}
+	if _, err := fr.ReconcileFiles("", "", files); err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	got, err := ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	want := Manifest{
+		motd:  sha256Hex("welcome\n"),
+		hosts: sha256Hex("127.0.0.1 localhost\n"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest = %v, want %v", got, want)
+	}
+}
+
+func TestReconcileFiles_ManifestDetectsTampering(t *testing.T) {
+	tmpDir := t.TempDir()
+	manifestPath := filepath.Join(tmpDir, "manifest.json")
+	motd := filepath.Join(tmpDir, "motd")
+	hosts := filepath.Join(tmpDir, "hosts")
+	fr := NewFileReconciler(WithManifest(manifestPath))
+
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: motd, Content: "welcome\n"},
+		{Type: "content", DestPath: hosts, Content: "127.0.0.1 localhost\n"},
+	}
+	result, err := fr.ReconcileFiles("", "", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if len(result.Tampered) != 0 {
+		t.Errorf("Tampered = %v, want none on the first reconciliation", result.Tampered)
+	}
+
+	// Modify a managed file manually
+	if err := os.WriteFile(motd, []byte("hacked\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if got := manifest.Drifted(); !reflect.DeepEqual(got, []string{motd}) {
+		t.Errorf("Drifted() = %v, want %v", got, []string{motd})
+	}
+
+	result, err = fr.ReconcileFiles("", "", files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+	if !reflect.DeepEqual(result.Tampered, []string{motd}) {
+		t.Errorf("Tampered = %v, want %v", result.Tampered, []string{motd})
+	}
+
+	// The reconciliation restored the file, which matches the manifest again
+	manifest, err = ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if got := manifest.Drifted(); len(got) != 0 {
+		t.Errorf("Drifted() = %v after reconciliation, want none", got)
+	}
+}
+
+func TestManifest_DriftedRemovedFile(t *testing.T) {
+	m := Manifest{filepath.Join(t.TempDir(), "removed"): sha256Hex("content")}
+
+	if got := m.Drifted(); len(got) != 1 {
+		t.Errorf("Drifted() = %v, want the removed file", got)
+	}
+}
+
+func TestReadManifest_Missing(t *testing.T) {
+	m, err := ReadManifest(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if len(m) != 0 {
+		t.Errorf("ReadManifest() = %v, want an empty manifest", m)
+	}
+}
+
+func TestUpdateManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := WriteManifest(path, Manifest{"/etc/a": "old-a", "/etc/b": "b"}); err != nil {
+		t.Fatalf("WriteManifest() error = %v", err)
+	}
+
+	if err := UpdateManifest(path, Manifest{"/etc/a": "new-a", "/etc/c": "c"}); err != nil {
+		t.Fatalf("UpdateManifest() error = %v", err)
+	}
+
+	got, err := ReadManifest(path)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	want := Manifest{"/etc/a": "new-a", "/etc/b": "b", "/etc/c": "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest = %v, want %v", got, want)
+	}
+}
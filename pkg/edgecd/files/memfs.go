@@ -0,0 +1,391 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS is an in-memory FS for testing. Paths are cleaned and "/" always
+// exists. Symlinks, created with Symlink, are only resolved by Readlink.
+type MemFS struct {
+	// Fail, if set, is called before each operation, e.g. ("create", path),
+	// and the operation fails with its error, if any, without any effect.
+	// Writes to a created file are checked with the "write" operation.
+	Fail func(op, name string) error
+
+	mu       sync.Mutex
+	nodes    map[string]*memNode
+	readOnly []string
+}
+
+// memNode is a file, a directory or a symlink of a MemFS.
+type memNode struct {
+	data    []byte
+	mode    fs.FileMode
+	target  string
+	uid     int
+	gid     int
+	modTime time.Time
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{nodes: map[string]*memNode{"/": {mode: fs.ModeDir | 0755}}}
+}
+
+// WriteFile creates or replaces the file at name with content and mode,
+// creating its parent directories.
+func (m *MemFS) WriteFile(name string, content []byte, mode fs.FileMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = path.Clean(name)
+	m.mkdirAll(path.Dir(name))
+	m.nodes[name] = &memNode{data: append([]byte{}, content...), mode: mode.Perm(), modTime: time.Now()}
+}
+
+// ReadFile returns the content of the file at name.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	return readFile(m, name)
+}
+
+// Symlink replaces name with a symlink pointing to target, creating its parent
+// directories.
+func (m *MemFS) Symlink(target, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("symlink", name); err != nil {
+		return err
+	}
+	name = path.Clean(name)
+	if n, ok := m.nodes[name]; ok && n.mode.IsDir() {
+		return &fs.PathError{Op: "symlink", Path: name, Err: syscall.EISDIR}
+	}
+	m.mkdirAll(path.Dir(name))
+	m.nodes[name] = &memNode{target: target, mode: fs.ModeSymlink | 0777, modTime: time.Now()}
+	return nil
+}
+
+// MountReadOnly makes the files under mountPoint read-only, as if mountPoint
+// were a read-only mount: the operations modifying them fail with EROFS.
+func (m *MemFS) MountReadOnly(mountPoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readOnly = append(m.readOnly, path.Clean(mountPoint))
+}
+
+// Owner returns the uid and gid of the file at name.
+func (m *MemFS) Owner(name string) (uid, gid int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.node("owner", name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return n.uid, n.gid, nil
+}
+
+// Paths returns the sorted paths of the files, directories and symlinks.
+func (m *MemFS) Paths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	paths := make([]string, 0, len(m.nodes))
+	for name := range m.nodes {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (m *MemFS) Open(name string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("open", name); err != nil {
+		return nil, err
+	}
+	n, err := m.node("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	return io.NopCloser(bytes.NewReader(append([]byte{}, n.data...))), nil
+}
+
+func (m *MemFS) Create(name string) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("create", name); err != nil {
+		return nil, err
+	}
+	name = path.Clean(name)
+	if err := m.parentExists("create", name); err != nil {
+		return nil, err
+	}
+
+	n, ok := m.nodes[name]
+	switch {
+	case !ok:
+		n = &memNode{mode: 0644}
+		m.nodes[name] = n
+	case n.mode.IsDir():
+		return nil, &fs.PathError{Op: "create", Path: name, Err: syscall.EISDIR}
+	}
+	n.data, n.modTime = nil, time.Now()
+	return &memFile{fs: m, node: n, name: name}, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("stat", name); err != nil {
+		return nil, err
+	}
+	n, err := m.node("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return memFileInfo{name: path.Base(path.Clean(name)), node: *n}, nil
+}
+
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("chmod", name); err != nil {
+		return err
+	}
+	n, err := m.node("chmod", name)
+	if err != nil {
+		return err
+	}
+	n.mode = n.mode.Type() | mode.Perm()
+	return nil
+}
+
+func (m *MemFS) Chown(name string, uid, gid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("chown", name); err != nil {
+		return err
+	}
+	n, err := m.node("chown", name)
+	if err != nil {
+		return err
+	}
+	n.uid, n.gid = uid, gid
+	return nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("rename", newpath); err != nil {
+		return err
+	}
+	oldpath, newpath = path.Clean(oldpath), path.Clean(newpath)
+	n, err := m.node("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	if err := m.parentExists("rename", newpath); err != nil {
+		return err
+	}
+	if n.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: syscall.EISDIR}
+	}
+	delete(m.nodes, oldpath)
+	m.nodes[newpath] = n
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("remove", name); err != nil {
+		return err
+	}
+	name = path.Clean(name)
+	if _, err := m.node("remove", name); err != nil {
+		return err
+	}
+	if len(m.children(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(m.nodes, name)
+	return nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("readdir", name); err != nil {
+		return nil, err
+	}
+	name = path.Clean(name)
+	n, err := m.node("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	var entries []fs.DirEntry
+	for _, child := range m.children(name) {
+		entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{name: path.Base(child), node: *m.nodes[child]}))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *MemFS) MkdirAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fail("mkdir", name); err != nil {
+		return err
+	}
+	name = path.Clean(name)
+	for dir := name; dir != "/" && dir != "."; dir = path.Dir(dir) {
+		n, ok := m.nodes[dir]
+		if ok && !n.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		// Like os.MkdirAll, existing directories are not an error
+		if !ok && m.readOnlyMount(dir) != "" {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.EROFS}
+		}
+	}
+	m.mkdirAll(name)
+	return nil
+}
+
+func (m *MemFS) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.node("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if n.mode.Type() != fs.ModeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return n.target, nil
+}
+
+func (m *MemFS) ReadOnlyMount(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.readOnlyMount(path.Clean(name)), nil
+}
+
+func (m *MemFS) fail(op, name string) error {
+	switch op {
+	case "create", "write", "chmod", "chown", "rename", "remove", "symlink":
+		if m.readOnlyMount(path.Clean(name)) != "" {
+			return &fs.PathError{Op: op, Path: name, Err: syscall.EROFS}
+		}
+	}
+	if m.Fail == nil {
+		return nil
+	}
+	if err := m.Fail(op, path.Clean(name)); err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// readOnlyMount returns the innermost read-only mount holding the clean name,
+// or "".
+func (m *MemFS) readOnlyMount(name string) string {
+	mount := ""
+	for _, mp := range m.readOnly {
+		if within(mp, name) && len(mp) > len(mount) {
+			mount = mp
+		}
+	}
+	return mount
+}
+
+// node returns the node at name, or fs.ErrNotExist.
+func (m *MemFS) node(op, name string) (*memNode, error) {
+	n, ok := m.nodes[path.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// parentExists returns an error if the parent directory of name does not exist.
+func (m *MemFS) parentExists(op, name string) error {
+	parent, ok := m.nodes[path.Dir(name)]
+	if !ok {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+// mkdirAll creates the missing directories of name.
+func (m *MemFS) mkdirAll(name string) {
+	for dir := name; dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if _, ok := m.nodes[dir]; !ok {
+			m.nodes[dir] = &memNode{mode: fs.ModeDir | 0755, modTime: time.Now()}
+		}
+	}
+}
+
+// children returns the paths of the direct children of the directory dir.
+func (m *MemFS) children(dir string) []string {
+	var children []string
+	for name := range m.nodes {
+		if name != dir && path.Dir(name) == dir {
+			children = append(children, name)
+		}
+	}
+	return children
+}
+
+// memFile is a file created by a MemFS. Its content is visible as it is written.
+type memFile struct {
+	fs   *MemFS
+	node *memNode
+	name string
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.fs.fail("write", f.name); err != nil {
+		return 0, err
+	}
+	f.node.data = append(f.node.data, p...)
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+// memFileInfo is the fs.FileInfo of a memNode.
+type memFileInfo struct {
+	name string
+	node memNode
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return int64(len(i.node.data)) }
+func (i memFileInfo) Mode() fs.FileMode  { return i.node.mode }
+func (i memFileInfo) ModTime() time.Time { return i.node.modTime }
+func (i memFileInfo) IsDir() bool        { return i.node.mode.IsDir() }
+func (i memFileInfo) Sys() any {
+	return &syscall.Stat_t{Uid: uint32(i.node.uid), Gid: uint32(i.node.gid)}
+}
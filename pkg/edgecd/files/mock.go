@@ -5,6 +5,8 @@ import "github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
 // MockFileReconciler is a mock implementation of FileReconciler for testing.
 type MockFileReconciler struct {
 	ReconcileFilesFunc func(configRepoPath, configPath string, files []userconfig.FileSpec) (*ReconcileResult, error)
+	DiffFunc           func(configRepoPath, configPath string, files []userconfig.FileSpec) ([]FileDiff, error)
+	RestoreFunc        func(backups []FileBackup) error
 }
 
 // ReconcileFiles calls the mock function if set, otherwise returns empty result.
@@ -17,3 +19,19 @@ func (m *MockFileReconciler) ReconcileFiles(configRepoPath, configPath string, f
 		RequiresReboot:    false,
 	}, nil
 }
+
+// Diff calls the mock function if set, otherwise returns no drift.
+func (m *MockFileReconciler) Diff(configRepoPath, configPath string, files []userconfig.FileSpec) ([]FileDiff, error) {
+	if m.DiffFunc != nil {
+		return m.DiffFunc(configRepoPath, configPath, files)
+	}
+	return []FileDiff{}, nil
+}
+
+// Restore calls the mock function if set, otherwise restores nothing.
+func (m *MockFileReconciler) Restore(backups []FileBackup) error {
+	if m.RestoreFunc != nil {
+		return m.RestoreFunc(backups)
+	}
+	return nil
+}
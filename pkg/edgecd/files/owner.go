@@ -0,0 +1,111 @@
+package files
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// fileOwner is the ownership a file spec requires. An id of -1 is left
+// unchanged.
+type fileOwner struct {
+	uid, gid int
+}
+
+// isSet reports whether the spec requires an owner or a group.
+func (o fileOwner) isSet() bool {
+	return o.uid != -1 || o.gid != -1
+}
+
+// resolveOwner resolves the owner and group of the file spec, user and group
+// names or numeric ids, to a fileOwner. The names are looked up on the host.
+func resolveOwner(file userconfig.FileSpec) (fileOwner, error) {
+	owner := fileOwner{uid: -1, gid: -1}
+	if file.Owner != "" {
+		uid, err := lookupID(file.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fileOwner{}, fmt.Errorf("failed to resolve owner of %s: %w", file.DestPath, err)
+		}
+		owner.uid = uid
+	}
+	if file.Group != "" {
+		gid, err := lookupID(file.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fileOwner{}, fmt.Errorf("failed to resolve group of %s: %w", file.DestPath, err)
+		}
+		owner.gid = gid
+	}
+	return owner, nil
+}
+
+// lookupID returns the numeric id nameOrID, or the id lookup returns for it.
+func lookupID(nameOrID string, lookup func(name string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	id, err := lookup(nameOrID)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// ownerEqual reports whether the file at path exists in fsys and has the
+// ownership owner requires.
+func ownerEqual(fsys FS, path string, owner fileOwner) bool {
+	if !owner.isSet() {
+		return true
+	}
+	uid, gid, err := statOwner(fsys, path)
+	if err != nil {
+		return false
+	}
+	return (owner.uid == -1 || owner.uid == uid) && (owner.gid == -1 || owner.gid == gid)
+}
+
+// chownFile gives the file at path the ownership owner requires, keeping its
+// current owner or group where owner leaves it unchanged.
+func chownFile(fsys FS, path string, owner fileOwner) error {
+	uid, gid, err := statOwner(fsys, path)
+	if err != nil {
+		return err
+	}
+	if owner.uid != -1 {
+		uid = owner.uid
+	}
+	if owner.gid != -1 {
+		gid = owner.gid
+	}
+	if err := fsys.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("failed to set file ownership: %w", err)
+	}
+	return nil
+}
+
+// statOwner returns the uid and gid of the file at path in fsys.
+func statOwner(fsys FS, path string) (uid, gid int, err error) {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, fmt.Errorf("failed to read the ownership of %s", path)
+	}
+	return int(st.Uid), int(st.Gid), nil
+}
@@ -0,0 +1,101 @@
+package files
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinks bounds the symlinks followed while resolving a destination in
+// the root prefix, to detect loops.
+const maxSymlinks = 255
+
+// WithRootPrefix makes the reconciler write the files under prefix, e.g. the
+// root filesystem of an image mounted at /mnt/target, instead of under /. The
+// checksums and changes of the ReconcileResult are keyed by the prefixed paths.
+// An empty prefix writes the files at their DestPath.
+func WithRootPrefix(prefix string) FileReconcilerOption {
+	return func(fr *fileReconciler) {
+		fr.rootPrefix = prefix
+	}
+}
+
+// dest returns the path destPath is written to: destPath itself without root
+// prefix, its path in the root prefix otherwise (see resolveInRoot).
+func (fr *fileReconciler) dest(destPath string) (string, error) {
+	if fr.rootPrefix == "" {
+		return destPath, nil
+	}
+	return resolveInRoot(fr.fs, fr.rootPrefix, destPath)
+}
+
+// linkDest returns the path the symlink destPath is written to, or removed
+// from for a "delete" spec: like dest, but a symlink already at destPath is not
+// followed, as it is the managed one.
+func (fr *fileReconciler) linkDest(destPath string) (string, error) {
+	if fr.rootPrefix == "" {
+		return destPath, nil
+	}
+	destPath = filepath.Clean(destPath)
+	if filepath.Base(destPath) == string(filepath.Separator) {
+		return "", fmt.Errorf("destPath %s of a symlink is the root prefix %s", destPath, fr.rootPrefix)
+	}
+	parent, err := resolveInRoot(fr.fs, fr.rootPrefix, filepath.Dir(destPath))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(destPath)), nil
+}
+
+// resolveInRoot returns the path of destPath in the root filesystem at root.
+// The symlinks found on the way are resolved as if root were /, like in a
+// chroot: an absolute symlink of the image points into the image, not to the
+// host. It returns an error if ".." in destPath or in a symlink leaves root.
+func resolveInRoot(fsys FS, root, destPath string) (string, error) {
+	root = filepath.Clean(root)
+	if !within(root, filepath.Join(root, destPath)) {
+		return "", fmt.Errorf("destPath %s escapes the root prefix %s", destPath, root)
+	}
+
+	resolved := root
+	parts := strings.Split(destPath, "/")
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == root {
+				return "", fmt.Errorf("destPath %s escapes the root prefix %s", destPath, root)
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		target, err := fsys.Readlink(next)
+		if err != nil {
+			// Not a symlink, or it does not exist yet
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("destPath %s: too many levels of symbolic links in the root prefix %s", destPath, root)
+		}
+		if filepath.IsAbs(target) {
+			resolved = root
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+
+	return resolved, nil
+}
+
+// within reports whether the clean path is root or under root.
+func within(root, path string) bool {
+	return path == root || root == "/" || strings.HasPrefix(path, root+string(filepath.Separator))
+}
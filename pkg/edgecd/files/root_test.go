@@ -0,0 +1,116 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "usr", "lib"), 0755); err != nil {
+		t.Fatalf("Failed to create image directories: %v", err)
+	}
+	// A merged /usr and an absolute symlink, as found in image root filesystems
+	if err := os.Symlink("usr/lib", filepath.Join(root, "lib")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Symlink("/usr/lib", filepath.Join(root, "abslib")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Symlink("../../..", filepath.Join(root, "usr", "lib", "up")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Symlink("loop", filepath.Join(root, "loop")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		destPath string
+		want     string
+		wantErr  bool
+	}{
+		{name: "absolute path", destPath: "/etc/motd", want: filepath.Join(root, "etc", "motd")},
+		{name: "relative path", destPath: "etc/motd", want: filepath.Join(root, "etc", "motd")},
+		{name: "dot-dot within the root", destPath: "/etc/../opt/app.conf", want: filepath.Join(root, "opt", "app.conf")},
+		{name: "relative symlink", destPath: "/lib/app.so", want: filepath.Join(root, "usr", "lib", "app.so")},
+		{name: "absolute symlink stays in the root", destPath: "/abslib/app.so", want: filepath.Join(root, "usr", "lib", "app.so")},
+		{name: "dot-dot escapes the root", destPath: "/../etc/passwd", wantErr: true},
+		{name: "nested dot-dot escapes the root", destPath: "/etc/../../tmp/x", wantErr: true},
+		{name: "symlink escapes the root", destPath: "/usr/lib/up/etc/passwd", wantErr: true},
+		{name: "symlink loop", destPath: "/loop/x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveInRoot(OSFS{}, root, tt.destPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveInRoot(%q) error = %v, wantErr %v", tt.destPath, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveInRoot(%q) = %q, want %q", tt.destPath, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileFiles_RootPrefix(t *testing.T) {
+	tmpDir := t.TempDir()
+	root := filepath.Join(tmpDir, "target")
+	configPath := "config"
+	if err := os.MkdirAll(filepath.Join(tmpDir, configPath, "etc-app"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, configPath, "etc-app", "app.conf"), []byte("port=80"), 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+
+	fr := NewFileReconciler(WithRootPrefix(root))
+	files := []userconfig.FileSpec{
+		{Type: "content", DestPath: "/etc/motd", Content: "welcome", FileMod: "644"},
+		{Type: "directory", SrcPath: "etc-app", DestPath: "/etc/app", FileMod: "644"},
+	}
+
+	result, err := fr.ReconcileFiles(tmpDir, configPath, files)
+	if err != nil {
+		t.Fatalf("ReconcileFiles() error = %v", err)
+	}
+
+	for path, want := range map[string]string{
+		filepath.Join(root, "etc", "motd"):            "welcome",
+		filepath.Join(root, "etc", "app", "app.conf"): "port=80",
+	} {
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("content of %s = %q (error %v), want %q", path, got, err, want)
+		}
+		if _, ok := result.Checksums[path]; !ok {
+			t.Errorf("Checksums has no entry for the prefixed path %s: %v", path, result.Checksums)
+		}
+	}
+	if _, err := os.Stat("/etc/app/app.conf"); err == nil {
+		t.Errorf("file written outside the root prefix")
+	}
+
+	diffs, err := fr.Diff(tmpDir, configPath, files)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Diff() = %v, want no drift once reconciled in the root prefix", diffs)
+	}
+
+	// A destination escaping the root prefix is rejected, by both the reconciliation and the diff
+	escaping := []userconfig.FileSpec{{Type: "content", DestPath: "/../escaped", Content: "x", FileMod: "644"}}
+	if _, err := fr.ReconcileFiles(tmpDir, configPath, escaping); err == nil {
+		t.Errorf("ReconcileFiles() error = nil, want an error for a destPath escaping the root prefix")
+	}
+	if _, err := fr.Diff(tmpDir, configPath, escaping); err == nil {
+		t.Errorf("Diff() error = nil, want an error for a destPath escaping the root prefix")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("file written outside the root prefix: stat error = %v", err)
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"log/slog"
 	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
-// RepoManager defines operations for Git repository management
+// RepoManager defines operations for Git repository management.
+// CloneRepo and SyncRepo check out the whole repository when sparseCheckoutPaths is empty.
 type RepoManager interface {
 	CloneRepo(url, branch, destPath string, sparseCheckoutPaths []string) error
 	SyncRepo(repoPath, branch string, sparseCheckoutPaths []string) error
@@ -17,14 +20,82 @@ type RepoManager interface {
 }
 
 // gitRepoManager implements RepoManager
-type gitRepoManager struct{}
+type gitRepoManager struct {
+	credentialHelper string
+}
+
+// RepoManagerOption configures a RepoManager.
+type RepoManagerOption func(*gitRepoManager)
+
+// WithCredentialHelper makes git resolve the credentials of HTTPS repositories
+// with helper, the value of git's credential.helper, e.g. "store" or the path
+// of a helper binary, instead of credentials embedded in the URLs. The helper
+// is passed to git through its environment and is never logged.
+func WithCredentialHelper(helper string) RepoManagerOption {
+	return func(g *gitRepoManager) {
+		g.credentialHelper = helper
+	}
+}
 
 // NewRepoManager creates a new RepoManager instance
-func NewRepoManager() RepoManager {
-	return &gitRepoManager{}
+func NewRepoManager(opts ...RepoManagerOption) RepoManager {
+	g := &gitRepoManager{}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
 }
 
-// CloneRepo clones a Git repository with sparse checkout
+// command returns the git command run with args. Git never prompts for
+// credentials: they must come from the credential helper or the URL.
+func (g *gitRepoManager) command(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if g.credentialHelper != "" {
+		// Set through the environment rather than "-c", so that the helper
+		// does not show in the process list. The entries follow the ones
+		// already in the environment, and the empty helper resets the list of
+		// helpers configured elsewhere, so that only this one is queried. The
+		// last GIT_CONFIG_COUNT of cmd.Env wins.
+		n, err := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+		if err != nil || n < 0 {
+			n = 0
+		}
+		cmd.Env = append(cmd.Env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=credential.helper", n),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=", n),
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=credential.helper", n+1),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", n+1, g.credentialHelper),
+			fmt.Sprintf("GIT_CONFIG_COUNT=%d", n+2),
+		)
+	}
+	return cmd
+}
+
+// userinfoPattern matches the scheme and user info of the URLs in a text.
+var userinfoPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/@\s'"]+@`)
+
+// redactOutput returns the output of a git command with the credentials of the
+// URLs it holds redacted like RedactURL does, so that it can be logged.
+func redactOutput(output []byte) string {
+	return userinfoPattern.ReplaceAllString(string(output), "${1}REDACTED@")
+}
+
+// RedactURL returns url without the credentials of its user info, if any, so
+// that it can be logged.
+func RedactURL(url string) string {
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok {
+		return url
+	}
+	userinfo, host, ok := strings.Cut(rest, "@")
+	if !ok || strings.Contains(userinfo, "/") {
+		return url
+	}
+	return scheme + "://REDACTED@" + host
+}
+
+// CloneRepo clones a Git repository, with sparse checkout if sparseCheckoutPaths is not empty
 func (g *gitRepoManager) CloneRepo(url, branch, destPath string, sparseCheckoutPaths []string) error {
 	// Handle file:// URLs - skip git operations
 	if strings.HasPrefix(url, "file://") {
@@ -32,43 +103,45 @@ func (g *gitRepoManager) CloneRepo(url, branch, destPath string, sparseCheckoutP
 		return nil
 	}
 
-	slog.Info("Cloning repository", "url", url, "branch", branch, "destPath", destPath)
+	slog.Info("Cloning repository", "url", RedactURL(url), "branch", branch, "destPath", destPath)
 
 	// git clone --filter=blob:none --no-checkout
-	cmd := exec.Command("git", "clone", "--filter=blob:none", "--no-checkout", url, destPath)
+	cmd := g.command("clone", "--filter=blob:none", "--no-checkout", url, destPath)
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("git clone failed: %w: %s", err, string(output))
+		return fmt.Errorf("git clone failed: %w: %s", err, redactOutput(output))
 	}
 
-	// git sparse-checkout init
-	cmd = exec.Command("git", "-C", destPath, "sparse-checkout", "init")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("sparse-checkout init failed: %w: %s", err, string(output))
-	}
-
-	// git sparse-checkout set <paths>
-	args := append([]string{"-C", destPath, "sparse-checkout", "set"}, sparseCheckoutPaths...)
-	cmd = exec.Command("git", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("sparse-checkout set failed: %w: %s", err, string(output))
+	if len(sparseCheckoutPaths) > 0 {
+		// git sparse-checkout init
+		cmd = g.command("-C", destPath, "sparse-checkout", "init")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("sparse-checkout init failed: %w: %s", err, string(output))
+		}
+
+		// git sparse-checkout set <paths>
+		args := append([]string{"-C", destPath, "sparse-checkout", "set"}, sparseCheckoutPaths...)
+		cmd = g.command(args...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("sparse-checkout set failed: %w: %s", err, string(output))
+		}
 	}
 
 	// git checkout <branch>
-	cmd = exec.Command("git", "-C", destPath, "checkout", branch)
+	cmd = g.command("-C", destPath, "checkout", branch)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("git checkout failed: %w: %s", err, string(output))
 	}
 
 	// git fetch origin <branch>
-	cmd = exec.Command("git", "-C", destPath, "fetch", "origin", branch)
+	cmd = g.command("-C", destPath, "fetch", "origin", branch)
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("git fetch failed: %w: %s", err, string(output))
+		return fmt.Errorf("git fetch failed: %w: %s", err, redactOutput(output))
 	}
 
 	// git pull
-	cmd = exec.Command("git", "-C", destPath, "pull")
+	cmd = g.command("-C", destPath, "pull")
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("git pull failed: %w: %s", err, string(output))
+		return fmt.Errorf("git pull failed: %w: %s", err, redactOutput(output))
 	}
 
 	slog.Info("Repository cloned successfully", "destPath", destPath)
@@ -86,21 +159,25 @@ func (g *gitRepoManager) SyncRepo(repoPath, branch string, sparseCheckoutPaths [
 
 	slog.Info("Syncing repository", "repoPath", repoPath, "branch", branch)
 
-	// git sparse-checkout set <paths>
+	// git sparse-checkout set <paths>, or disable it to switch an existing
+	// sparse checkout to a full one
 	args := append([]string{"-C", repoPath, "sparse-checkout", "set"}, sparseCheckoutPaths...)
-	cmd := exec.Command("git", args...)
+	if len(sparseCheckoutPaths) == 0 {
+		args = []string{"-C", repoPath, "sparse-checkout", "disable"}
+	}
+	cmd := g.command(args...)
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("sparse-checkout set failed: %w: %s", err, string(output))
+		return fmt.Errorf("sparse-checkout %s failed: %w: %s", args[3], err, string(output))
 	}
 
 	// git fetch origin <branch>
-	cmd = exec.Command("git", "-C", repoPath, "fetch", "origin", branch)
+	cmd = g.command("-C", repoPath, "fetch", "origin", branch)
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("git fetch failed: %w: %s", err, string(output))
+		return fmt.Errorf("git fetch failed: %w: %s", err, redactOutput(output))
 	}
 
 	// git reset --hard FETCH_HEAD
-	cmd = exec.Command("git", "-C", repoPath, "reset", "--hard", "FETCH_HEAD")
+	cmd = g.command("-C", repoPath, "reset", "--hard", "FETCH_HEAD")
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("git reset failed: %w: %s", err, string(output))
 	}
@@ -111,7 +188,7 @@ func (g *gitRepoManager) SyncRepo(repoPath, branch string, sparseCheckoutPaths [
 
 // GetCurrentCommit returns the current commit hash
 func (g *gitRepoManager) GetCurrentCommit(repoPath string) (string, error) {
-	cmd := exec.Command("git", "-C", repoPath, "rev-parse", "HEAD")
+	cmd := g.command("-C", repoPath, "rev-parse", "HEAD")
 	output, err := cmd.Output()
 	if err != nil {
 		return "", fmt.Errorf("git rev-parse failed: %w", err)
@@ -123,7 +200,7 @@ func (g *gitRepoManager) GetCurrentCommit(repoPath string) (string, error) {
 
 // GetCommitDiff returns the list of files changed between two commits
 func (g *gitRepoManager) GetCommitDiff(repoPath, oldCommit, newCommit string) ([]string, error) {
-	cmd := exec.Command("git", "-C", repoPath, "diff", "--name-only", oldCommit, newCommit)
+	cmd := g.command("-C", repoPath, "diff", "--name-only", oldCommit, newCommit)
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("git diff failed: %w", err)
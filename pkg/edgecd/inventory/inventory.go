@@ -0,0 +1,191 @@
+package inventory
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/config"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/git"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/pkgmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/svcmgr"
+)
+
+// Inventory is a snapshot of the current state of a device, used for fleet auditing.
+type Inventory struct {
+	Hostname    string            `json:"hostname"`
+	GeneratedAt time.Time         `json:"generatedAt"`
+	EdgeCD      RepoState         `json:"edgeCD"`
+	Config      RepoState         `json:"config"`
+	Packages    map[string]string `json:"packages"`
+	Services    []ServiceState    `json:"services"`
+	Files       []FileState       `json:"files"`
+	// Errors lists the parts of the inventory that could not be collected
+	Errors []string `json:"errors,omitempty"`
+}
+
+// RepoState describes a repository checked out on the device.
+type RepoState struct {
+	URL    string `json:"url"`
+	Branch string `json:"branch,omitempty"`
+	Path   string `json:"path"`
+	// HeadCommit is the commit currently checked out
+	HeadCommit string `json:"headCommit,omitempty"`
+	// AppliedCommit is the last commit successfully reconciled, read from the commit state file
+	AppliedCommit string `json:"appliedCommit,omitempty"`
+}
+
+// ServiceState describes whether a service managed by edge-cd is running.
+type ServiceState struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+// FileState describes a file reconciled by edge-cd.
+type FileState struct {
+	Type     string `json:"type"`
+	DestPath string `json:"destPath"`
+	Present  bool   `json:"present"`
+}
+
+// Collector assembles an Inventory from the managers and the state files.
+type Collector struct {
+	config *config.Config
+	gitMgr git.RepoManager
+	pkgMgr pkgmgr.PackageManager
+	svcMgr svcmgr.ServiceManager
+}
+
+// NewCollector creates a new Collector with injected dependencies.
+func NewCollector(
+	cfg *config.Config,
+	gitMgr git.RepoManager,
+	pkgMgr pkgmgr.PackageManager,
+	svcMgr svcmgr.ServiceManager,
+) *Collector {
+	return &Collector{
+		config: cfg,
+		gitMgr: gitMgr,
+		pkgMgr: pkgMgr,
+		svcMgr: svcMgr,
+	}
+}
+
+// Collect gathers the inventory. Collection is best-effort: failures are
+// recorded in Inventory.Errors instead of aborting.
+func (c *Collector) Collect() *Inventory {
+	inv := &Inventory{
+		GeneratedAt: time.Now().UTC(),
+		Packages:    map[string]string{},
+		Services:    []ServiceState{},
+		Files:       []FileState{},
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		inv.addError("hostname", err)
+	}
+	inv.Hostname = hostname
+
+	spec := c.config.Spec
+
+	inv.EdgeCD = c.repoState(inv, "edgeCD",
+		spec.EdgeCD.Repo.URL, spec.EdgeCD.Repo.Branch, c.config.EdgeCDRepoPath, c.config.EdgeCDCommitPath)
+	inv.Config = c.repoState(inv, "config",
+		spec.Config.Repo.URL, spec.Config.Repo.Branch, c.config.ConfigRepoPath, c.config.ConfigCommitPath)
+
+	packages, err := c.pkgMgr.ListInstalled()
+	if err != nil {
+		inv.addError("packages", err)
+	} else {
+		inv.Packages = packages
+	}
+
+	for _, svc := range managedServices(c.config) {
+		active, err := c.svcMgr.IsActive(svc)
+		if err != nil {
+			inv.addError("service "+svc, err)
+		}
+		inv.Services = append(inv.Services, ServiceState{Name: svc, Active: active})
+	}
+
+	for _, f := range spec.Files {
+		if !f.IsEnabled() {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(c.config.RootPrefix, f.DestPath))
+		inv.Files = append(inv.Files, FileState{
+			Type:     f.Type,
+			DestPath: f.DestPath,
+			Present:  err == nil,
+		})
+	}
+
+	return inv
+}
+
+// ServeHTTP writes the current inventory as JSON.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.Collect()); err != nil {
+		slog.Error("Failed to write inventory", "error", err)
+	}
+}
+
+// repoState reads the checked out and applied commits of a repository.
+func (c *Collector) repoState(inv *Inventory, name, url, branch, path, commitPath string) RepoState {
+	state := RepoState{URL: url, Branch: branch, Path: path}
+
+	head, err := c.gitMgr.GetCurrentCommit(path)
+	if err != nil {
+		inv.addError(name+" head commit", err)
+	}
+	state.HeadCommit = head
+
+	// A missing commit file means nothing was applied yet
+	if data, err := os.ReadFile(commitPath); err == nil {
+		state.AppliedCommit = strings.TrimSpace(string(data))
+	} else if !os.IsNotExist(err) {
+		inv.addError(name+" applied commit", err)
+	}
+
+	return state
+}
+
+// addError records a collection failure.
+func (inv *Inventory) addError(what string, err error) {
+	slog.Error("Failed to collect inventory", "item", what, "error", err)
+	inv.Errors = append(inv.Errors, what+": "+err.Error())
+}
+
+// managedServices returns the sorted, de-duplicated list of services edge-cd manages:
+// edge-cd itself and every service restarted by a file's sync behavior.
+func managedServices(cfg *config.Config) []string {
+	set := map[string]struct{}{"edge-cd": {}}
+	for _, f := range cfg.Spec.Files {
+		if f.SyncBehavior == nil || !f.IsEnabled() {
+			continue
+		}
+		for _, svc := range f.SyncBehavior.RestartServices {
+			set[svc] = struct{}{}
+		}
+	}
+
+	services := make([]string, 0, len(set))
+	for svc := range set {
+		services = append(services, svc)
+	}
+	sort.Strings(services)
+
+	return services
+}
@@ -0,0 +1,204 @@
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/config"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/git"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/pkgmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/svcmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+func newTestCollector(t *testing.T) (*Collector, string) {
+	t.Helper()
+	tempDir := t.TempDir()
+
+	configCommitPath := filepath.Join(tempDir, "config-commit.txt")
+	if err := os.WriteFile(configCommitPath, []byte("applied123\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	presentFile := filepath.Join(tempDir, "present.conf")
+	if err := os.WriteFile(presentFile, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			EdgeCD: userconfig.EdgeCDSection{
+				Repo: userconfig.RepoConfig{URL: "https://github.com/test/edge-cd.git", Branch: "main"},
+			},
+			Config: userconfig.ConfigSection{
+				Repo: userconfig.ConfigRepo{URL: "https://github.com/test/config.git", Branch: "prod"},
+			},
+			Files: []userconfig.FileSpec{
+				{
+					Type:         "content",
+					DestPath:     presentFile,
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"nginx"}},
+				},
+				{
+					Type:         "file",
+					DestPath:     filepath.Join(tempDir, "missing.conf"),
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"dnsmasq", "nginx"}},
+				},
+			},
+		},
+		EdgeCDRepoPath:   filepath.Join(tempDir, "edge-cd"),
+		EdgeCDCommitPath: filepath.Join(tempDir, "edge-cd-commit.txt"), // never written
+		ConfigRepoPath:   filepath.Join(tempDir, "config"),
+		ConfigCommitPath: configCommitPath,
+	}
+
+	gitMgr := &git.MockRepoManager{
+		GetCurrentCommitFunc: func(repoPath string) (string, error) {
+			if repoPath == cfg.ConfigRepoPath {
+				return "head456", nil
+			}
+			return "edgecd789", nil
+		},
+	}
+	pkgMgr := &pkgmgr.MockPackageManager{
+		ListInstalledFunc: func() (map[string]string, error) {
+			return map[string]string{"git": "2.43.0", "nginx": "1.24.0"}, nil
+		},
+	}
+	svcMgr := &svcmgr.MockServiceManager{
+		IsActiveFunc: func(serviceName string) (bool, error) {
+			return serviceName != "dnsmasq", nil
+		},
+	}
+
+	return NewCollector(cfg, gitMgr, pkgMgr, svcMgr), presentFile
+}
+
+func TestCollect(t *testing.T) {
+	c, presentFile := newTestCollector(t)
+
+	b, err := json.Marshal(c.Collect())
+	if err != nil {
+		t.Fatalf("Failed to marshal inventory: %v", err)
+	}
+
+	var inv Inventory
+	if err := json.Unmarshal(b, &inv); err != nil {
+		t.Fatalf("Inventory JSON is invalid: %v", err)
+	}
+
+	// Packages
+	if inv.Packages["git"] != "2.43.0" || inv.Packages["nginx"] != "1.24.0" {
+		t.Errorf("Unexpected packages: %v", inv.Packages)
+	}
+
+	// Commits
+	if inv.Config.HeadCommit != "head456" {
+		t.Errorf("Config.HeadCommit = %q, want head456", inv.Config.HeadCommit)
+	}
+	if inv.Config.AppliedCommit != "applied123" {
+		t.Errorf("Config.AppliedCommit = %q, want applied123", inv.Config.AppliedCommit)
+	}
+	if inv.Config.Branch != "prod" || inv.Config.URL != "https://github.com/test/config.git" {
+		t.Errorf("Unexpected config repo state: %+v", inv.Config)
+	}
+	if inv.EdgeCD.HeadCommit != "edgecd789" {
+		t.Errorf("EdgeCD.HeadCommit = %q, want edgecd789", inv.EdgeCD.HeadCommit)
+	}
+	if inv.EdgeCD.AppliedCommit != "" {
+		t.Errorf("EdgeCD.AppliedCommit = %q, want empty when commit file is missing", inv.EdgeCD.AppliedCommit)
+	}
+
+	// Services: sorted, de-duplicated, including edge-cd itself
+	expectedServices := []ServiceState{
+		{Name: "dnsmasq", Active: false},
+		{Name: "edge-cd", Active: true},
+		{Name: "nginx", Active: true},
+	}
+	if len(inv.Services) != len(expectedServices) {
+		t.Fatalf("Services = %+v, want %+v", inv.Services, expectedServices)
+	}
+	for i, want := range expectedServices {
+		if inv.Services[i] != want {
+			t.Errorf("Services[%d] = %+v, want %+v", i, inv.Services[i], want)
+		}
+	}
+
+	// Files
+	if len(inv.Files) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(inv.Files))
+	}
+	if inv.Files[0].DestPath != presentFile || !inv.Files[0].Present {
+		t.Errorf("Expected %s to be present, got %+v", presentFile, inv.Files[0])
+	}
+	if inv.Files[1].Present {
+		t.Errorf("Expected missing file to be reported absent, got %+v", inv.Files[1])
+	}
+
+	if len(inv.Errors) != 0 {
+		t.Errorf("Expected no errors, got %v", inv.Errors)
+	}
+}
+
+func TestCollect_RecordsErrors(t *testing.T) {
+	c, _ := newTestCollector(t)
+	c.pkgMgr = &pkgmgr.MockPackageManager{
+		ListInstalledFunc: func() (map[string]string, error) {
+			return nil, errors.New("dpkg-query not found")
+		},
+	}
+	c.svcMgr = &svcmgr.MockServiceManager{
+		IsActiveFunc: func(serviceName string) (bool, error) {
+			return false, errors.New("isActive command not configured")
+		},
+	}
+
+	inv := c.Collect()
+
+	if len(inv.Packages) != 0 {
+		t.Errorf("Expected no packages, got %v", inv.Packages)
+	}
+	if len(inv.Services) != 3 {
+		t.Errorf("Expected services to still be listed, got %+v", inv.Services)
+	}
+	// 1 package error + 3 service errors
+	if len(inv.Errors) != 4 {
+		t.Errorf("Expected 4 errors, got %v", inv.Errors)
+	}
+	if inv.Config.HeadCommit != "head456" {
+		t.Errorf("Expected commits to still be collected, got %+v", inv.Config)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	c, _ := newTestCollector(t)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/inventory", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %q", ct)
+	}
+
+	var inv Inventory
+	if err := json.Unmarshal(rec.Body.Bytes(), &inv); err != nil {
+		t.Fatalf("Response is not valid inventory JSON: %v", err)
+	}
+	if inv.Packages["git"] != "2.43.0" || inv.Config.AppliedCommit != "applied123" || len(inv.Services) != 3 {
+		t.Errorf("Unexpected inventory: %+v", inv)
+	}
+
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/inventory", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for POST, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,37 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/runtime"
+)
+
+// MockNotifier is a mock implementation of Notifier for testing.
+type MockNotifier struct {
+	NotifyFunc func(ctx context.Context, result runtime.Result) error
+
+	// Track calls for verification
+	Results []runtime.Result
+}
+
+// Notify records result and calls the mock function if provided, otherwise returns nil.
+func (m *MockNotifier) Notify(ctx context.Context, result runtime.Result) error {
+	m.Results = append(m.Results, result)
+	if m.NotifyFunc != nil {
+		return m.NotifyFunc(ctx, result)
+	}
+	return nil
+}
+
+// MockHeartbeater is a mock implementation of Heartbeater for testing.
+type MockHeartbeater struct {
+	BeatFunc func(ctx context.Context, hb Heartbeat) error
+}
+
+// Beat calls the mock function if provided, otherwise returns nil.
+func (m *MockHeartbeater) Beat(ctx context.Context, hb Heartbeat) error {
+	if m.BeatFunc != nil {
+		return m.BeatFunc(ctx, hb)
+	}
+	return nil
+}
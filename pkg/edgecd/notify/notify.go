@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/runtime"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/waitutil"
+)
+
+// Notifier sends the result of a reconciliation iteration to an external sink.
+type Notifier interface {
+	// Notify delivers result, retrying transient failures.
+	Notify(ctx context.Context, result runtime.Result) error
+}
+
+// Heartbeat tells a monitoring endpoint that a device reconciled successfully.
+type Heartbeat struct {
+	Hostname string    `json:"hostname"`
+	Time     time.Time `json:"time"`
+	Commit   string    `json:"commit,omitempty"`
+	// CommitAgeSeconds is the time since the applied commit last changed
+	CommitAgeSeconds *int64 `json:"commitAgeSeconds,omitempty"`
+}
+
+// Heartbeater sends heartbeats to a dead man's switch.
+type Heartbeater interface {
+	// Beat delivers hb, retrying transient failures.
+	Beat(ctx context.Context, hb Heartbeat) error
+}
+
+// webhook POSTs JSON payloads to a URL.
+// It implements both Notifier and Heartbeater.
+type webhook struct {
+	url        string
+	authHeader string
+	client     *http.Client
+	backoff    waitutil.Backoff
+	timeout    time.Duration
+}
+
+// NewWebhookNotifier creates a new Notifier POSTing results to the URL of spec.
+// Network errors and 5xx responses are retried with a backoff for up to 30 seconds.
+func NewWebhookNotifier(spec userconfig.NotifySection) Notifier {
+	return &webhook{
+		url:        spec.URL,
+		authHeader: spec.AuthHeader,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		backoff:    waitutil.Backoff{Initial: 1 * time.Second, Max: 10 * time.Second, Factor: 2},
+		timeout:    30 * time.Second,
+	}
+}
+
+// NewWebhookHeartbeater creates a new Heartbeater POSTing heartbeats to the URL of spec.
+// Heartbeats are retried for up to 10 seconds: a missed heartbeat is superseded
+// by the one of the next iteration.
+func NewWebhookHeartbeater(spec userconfig.HeartbeatSection) Heartbeater {
+	return &webhook{
+		url:        spec.URL,
+		authHeader: spec.AuthHeader,
+		client:     &http.Client{Timeout: 5 * time.Second},
+		backoff:    waitutil.Backoff{Initial: 1 * time.Second, Max: 5 * time.Second, Factor: 2},
+		timeout:    10 * time.Second,
+	}
+}
+
+// Notify POSTs result to the webhook until it is accepted, a 4xx response is
+// received or the retry timeout expires.
+func (n *webhook) Notify(ctx context.Context, result runtime.Result) error {
+	return n.send(ctx, result)
+}
+
+// Beat POSTs hb to the webhook until it is accepted, a 4xx response is
+// received or the retry timeout expires.
+func (n *webhook) Beat(ctx context.Context, hb Heartbeat) error {
+	return n.send(ctx, hb)
+}
+
+// send POSTs payload as JSON, retrying network errors and 5xx responses.
+func (n *webhook) send(ctx context.Context, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	var lastErr error
+	err = waitutil.PollWithBackoff(ctx, n.backoff, n.timeout, func() (bool, error) {
+		retry, err := n.post(ctx, body)
+		if err == nil {
+			return true, nil
+		}
+		if !retry {
+			return false, err
+		}
+		slog.Debug("Webhook request failed, retrying", "url", n.url, "error", err)
+		lastErr = err
+		return false, nil
+	})
+	if err != nil && lastErr != nil {
+		return fmt.Errorf("failed to post to %s: %w (last error: %v)", n.url, err, lastErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to post to %s: %w", n.url, err)
+	}
+	return nil
+}
+
+// post sends body once. It returns true if a failed request is worth retrying.
+func (n *webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.authHeader != "" {
+		req.Header.Set("Authorization", n.authHeader)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,158 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/runtime"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/waitutil"
+)
+
+// newTestNotifier creates a Notifier webhook retrying quickly.
+func newTestNotifier(url, authHeader string) *webhook {
+	n := NewWebhookNotifier(userconfig.NotifySection{URL: url, AuthHeader: authHeader}).(*webhook)
+	n.backoff = waitutil.Backoff{Initial: 10 * time.Millisecond}
+	n.timeout = time.Second
+	return n
+}
+
+func TestNotify_PostsResult(t *testing.T) {
+	var (
+		received   runtime.Result
+		authHeader string
+		method     string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		authHeader = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	result := runtime.Result{
+		Hostname:          "edge-1",
+		Time:              time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Commit:            "abc123",
+		ConfigChanged:     true,
+		ServicesRestarted: []string{"nginx"},
+		Errors:            []string{"install packages: apt failed"},
+	}
+
+	n := newTestNotifier(server.URL, "Bearer secret")
+	if err := n.Notify(context.Background(), result); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if method != http.MethodPost {
+		t.Errorf("Expected POST, got %s", method)
+	}
+	if authHeader != "Bearer secret" {
+		t.Errorf("Expected Authorization header 'Bearer secret', got %q", authHeader)
+	}
+	if !reflect.DeepEqual(received, result) {
+		t.Errorf("Expected payload %+v, got %+v", result, received)
+	}
+}
+
+func TestNotify_RetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := newTestNotifier(server.URL, "")
+	if err := n.Notify(context.Background(), runtime.Result{Hostname: "edge-1"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls.Load())
+	}
+}
+
+func TestNotify_DoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	n := newTestNotifier(server.URL, "")
+	if err := n.Notify(context.Background(), runtime.Result{Hostname: "edge-1"}); err == nil {
+		t.Fatal("Expected error for 401 response")
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected 1 attempt, got %d", calls.Load())
+	}
+}
+
+func TestNotify_GivesUpAfterTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n := newTestNotifier(server.URL, "")
+	n.timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if err := n.Notify(context.Background(), runtime.Result{Hostname: "edge-1"}); err == nil {
+		t.Fatal("Expected error when the webhook keeps failing")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Notify took %s, expected to give up after the timeout", elapsed)
+	}
+}
+
+func TestBeat_PostsHeartbeat(t *testing.T) {
+	var (
+		received   map[string]any
+		authHeader string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	h := NewWebhookHeartbeater(userconfig.HeartbeatSection{URL: server.URL, AuthHeader: "Bearer secret"})
+	hb := Heartbeat{
+		Hostname: "edge-1",
+		Time:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Commit:   "abc123",
+	}
+	if err := h.Beat(context.Background(), hb); err != nil {
+		t.Fatalf("Beat failed: %v", err)
+	}
+
+	if authHeader != "Bearer secret" {
+		t.Errorf("Expected Authorization header 'Bearer secret', got %q", authHeader)
+	}
+	expected := map[string]any{
+		"hostname": "edge-1",
+		"time":     "2024-01-02T03:04:05Z",
+		"commit":   "abc123",
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected payload %v, got %v", expected, received)
+	}
+}
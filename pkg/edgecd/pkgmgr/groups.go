@@ -0,0 +1,57 @@
+package pkgmgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// RequiredPackages returns the packages required by section: the required
+// packages of its package groups, read from configDir, in order, followed by
+// its own, without duplicates. The excluded packages of section are removed
+// from the result, even when required by a group.
+func RequiredPackages(configDir string, section userconfig.PackageManagerSection) ([]string, error) {
+	var lists [][]string
+	for _, name := range section.PackageGroups {
+		group, err := loadPackageGroup(filepath.Join(configDir, name))
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, group.RequiredPackages)
+	}
+	lists = append(lists, section.RequiredPackages)
+
+	skip := make(map[string]bool, len(section.ExcludedPackages))
+	for _, pkg := range section.ExcludedPackages {
+		skip[pkg] = true
+	}
+
+	var packages []string
+	for _, list := range lists {
+		for _, pkg := range list {
+			if skip[pkg] {
+				continue
+			}
+			skip[pkg] = true
+			packages = append(packages, pkg)
+		}
+	}
+	return packages, nil
+}
+
+// loadPackageGroup reads the PackageGroup file at path.
+func loadPackageGroup(path string) (*userconfig.PackageGroup, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package group: %w", err)
+	}
+
+	var group userconfig.PackageGroup
+	if err := yaml.Unmarshal(data, &group); err != nil {
+		return nil, fmt.Errorf("failed to parse package group %s: %w", path, err)
+	}
+	return &group, nil
+}
@@ -0,0 +1,86 @@
+package pkgmgr
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+func TestRequiredPackages(t *testing.T) {
+	configDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(configDir, "groups"), 0755); err != nil {
+		t.Fatalf("Failed to create groups directory: %v", err)
+	}
+	for name, content := range map[string]string{
+		"base.yaml":       "requiredPackages: [git, curl, vim]\n",
+		"monitoring.yaml": "requiredPackages: [node-exporter, curl]\n",
+		"invalid.yaml":    "requiredPackages: {git: true}\n",
+	} {
+		if err := os.WriteFile(filepath.Join(configDir, "groups", name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write package group: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		section userconfig.PackageManagerSection
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "without groups",
+			section: userconfig.PackageManagerSection{RequiredPackages: []string{"htop", "git"}},
+			want:    []string{"htop", "git"},
+		},
+		{
+			name: "union of the groups and the device packages",
+			section: userconfig.PackageManagerSection{
+				PackageGroups:    []string{"groups/base.yaml", "groups/monitoring.yaml"},
+				RequiredPackages: []string{"htop", "git"},
+			},
+			want: []string{"git", "curl", "vim", "node-exporter", "htop"},
+		},
+		{
+			name: "device exclusion removes a group package",
+			section: userconfig.PackageManagerSection{
+				PackageGroups:    []string{"groups/base.yaml"},
+				RequiredPackages: []string{"nano"},
+				ExcludedPackages: []string{"vim"},
+			},
+			want: []string{"git", "curl", "nano"},
+		},
+		{
+			name: "device exclusion wins over the device packages",
+			section: userconfig.PackageManagerSection{
+				RequiredPackages: []string{"nano", "vim"},
+				ExcludedPackages: []string{"vim"},
+			},
+			want: []string{"nano"},
+		},
+		{
+			name:    "missing group",
+			section: userconfig.PackageManagerSection{PackageGroups: []string{"groups/missing.yaml"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid group",
+			section: userconfig.PackageManagerSection{PackageGroups: []string{"groups/invalid.yaml"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RequiredPackages(configDir, tt.section)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RequiredPackages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RequiredPackages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
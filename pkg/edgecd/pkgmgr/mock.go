@@ -5,6 +5,9 @@ type MockPackageManager struct {
 	UpdateFunc  func() error
 	InstallFunc func(packages []string) error
 	UpgradeFunc func(packages []string) error
+
+	InstalledVersionFunc func(pkg string) (string, error)
+	ListInstalledFunc    func() (map[string]string, error)
 }
 
 // Update calls the mock UpdateFunc if set, otherwise returns nil
@@ -30,3 +33,19 @@ func (m *MockPackageManager) Upgrade(packages []string) error {
 	}
 	return nil
 }
+
+// InstalledVersion calls the mock InstalledVersionFunc if set, otherwise returns ErrPackageNotInstalled
+func (m *MockPackageManager) InstalledVersion(pkg string) (string, error) {
+	if m.InstalledVersionFunc != nil {
+		return m.InstalledVersionFunc(pkg)
+	}
+	return "", ErrPackageNotInstalled
+}
+
+// ListInstalled calls the mock ListInstalledFunc if set, otherwise returns an empty map
+func (m *MockPackageManager) ListInstalled() (map[string]string, error) {
+	if m.ListInstalledFunc != nil {
+		return m.ListInstalledFunc()
+	}
+	return map[string]string{}, nil
+}
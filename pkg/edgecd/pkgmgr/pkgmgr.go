@@ -1,20 +1,30 @@
 package pkgmgr
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+// ErrPackageNotInstalled is returned by InstalledVersion when the package is not installed
+var ErrPackageNotInstalled = errors.New("package not installed")
+
 // PackageManager interface defines operations for package management
 type PackageManager interface {
 	Update() error
 	Install(packages []string) error
 	Upgrade(packages []string) error
+	// InstalledVersion returns the installed version of pkg or ErrPackageNotInstalled
+	InstalledVersion(pkg string) (string, error)
+	// ListInstalled returns the installed packages keyed by name with their version as value
+	ListInstalled() (map[string]string, error)
 }
 
 // packageManager is the concrete implementation
@@ -28,11 +38,34 @@ type PackageManagerConfig struct {
 	Update  []string `yaml:"update"`
 	Install []string `yaml:"install"`
 	Upgrade []string `yaml:"upgrade"`
+	// ListInstalled prints one installed package per line as "<name> <version>"
+	// or "<name> - <version>"
+	ListInstalled []string `yaml:"list_installed"`
+}
+
+// Option configures a PackageManager.
+type Option func(*PackageManagerConfig)
+
+// WithEscalation prefixes the commands changing the installed packages with
+// escalation, e.g. []string{"sudo", "-n"}, when edge-cd runs as an unprivileged
+// user.
+func WithEscalation(escalation []string) Option {
+	return func(c *PackageManagerConfig) {
+		if len(escalation) == 0 {
+			return
+		}
+		for _, cmd := range []*[]string{&c.Update, &c.Install, &c.Upgrade} {
+			// Commands already escalated, e.g. "sudo apt-get update", are kept
+			if len(*cmd) > 0 && (*cmd)[0] != escalation[0] {
+				*cmd = append(append([]string{}, escalation...), *cmd...)
+			}
+		}
+	}
 }
 
 // NewPackageManager creates a new PackageManager by loading configuration
 // from {edgeCDRepoPath}/cmd/edge-cd/package-managers/{name}.yaml
-func NewPackageManager(name string, edgeCDRepoPath string) (PackageManager, error) {
+func NewPackageManager(name string, edgeCDRepoPath string, opts ...Option) (PackageManager, error) {
 	// Load config from cmd/edge-cd/package-managers/{name}.yaml
 	configPath := filepath.Join(edgeCDRepoPath, "cmd", "edge-cd", "package-managers", fmt.Sprintf("%s.yaml", name))
 	data, err := os.ReadFile(configPath)
@@ -44,6 +77,9 @@ func NewPackageManager(name string, edgeCDRepoPath string) (PackageManager, erro
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse package manager config: %w", err)
 	}
+	for _, opt := range opts {
+		opt(&config)
+	}
 
 	return &packageManager{
 		name:   name,
@@ -127,3 +163,51 @@ func (pm *packageManager) Upgrade(packages []string) error {
 
 	return nil
 }
+
+// InstalledVersion returns the installed version of the given package
+func (pm *packageManager) InstalledVersion(pkg string) (string, error) {
+	installed, err := pm.ListInstalled()
+	if err != nil {
+		return "", err
+	}
+
+	version, ok := installed[pkg]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrPackageNotInstalled, pkg)
+	}
+
+	return version, nil
+}
+
+// ListInstalled runs the list_installed command and parses its output
+func (pm *packageManager) ListInstalled() (map[string]string, error) {
+	if len(pm.config.ListInstalled) == 0 {
+		return nil, fmt.Errorf("list_installed command not configured")
+	}
+
+	cmd := exec.Command(pm.config.ListInstalled[0], pm.config.ListInstalled[1:]...)
+	out, err := cmd.Output()
+	if err != nil {
+		slog.Error("Listing installed packages failed", "packageManager", pm.name, "error", err)
+		return nil, fmt.Errorf("list installed failed: %w", err)
+	}
+
+	return parseInstalled(string(out)), nil
+}
+
+// parseInstalled parses lines formatted as "<name> <version>" (dpkg-query) or
+// "<name> - <version>" (opkg list-installed). Lines without a version are skipped.
+func parseInstalled(output string) map[string]string {
+	installed := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		installed[fields[0]] = fields[len(fields)-1]
+	}
+
+	return installed
+}
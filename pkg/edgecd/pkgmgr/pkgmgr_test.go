@@ -1,7 +1,9 @@
 package pkgmgr
 
 import (
+	"errors"
 	"os"
+	"reflect"
 	"testing"
 
 	"gopkg.in/yaml.v3"
@@ -76,6 +78,35 @@ func TestNewPackageManager_Apt(t *testing.T) {
 	}
 }
 
+func TestNewPackageManager_Escalation(t *testing.T) {
+	tests := []struct {
+		name        string
+		wantInstall []string
+		wantList    []string
+	}{
+		{name: "opkg", wantInstall: []string{"sudo", "-n", "opkg", "install"}, wantList: []string{"opkg", "list-installed"}},
+		// apt commands already run with sudo
+		{name: "apt", wantInstall: []string{"sudo", "apt-get", "install", "-y"}, wantList: []string{"dpkg-query", "-W", "-f=${Package} ${Version}\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm, err := NewPackageManager(tt.name, "../../..", WithEscalation([]string{"sudo", "-n"}))
+			if err != nil {
+				t.Fatalf("Failed to create %s package manager: %v", tt.name, err)
+			}
+
+			concrete := pm.(*packageManager)
+			if !reflect.DeepEqual(concrete.config.Install, tt.wantInstall) {
+				t.Errorf("install = %v, want %v", concrete.config.Install, tt.wantInstall)
+			}
+			if !reflect.DeepEqual(concrete.config.ListInstalled, tt.wantList) {
+				t.Errorf("list_installed = %v, want %v", concrete.config.ListInstalled, tt.wantList)
+			}
+		})
+	}
+}
+
 func TestNewPackageManager_UnknownManager(t *testing.T) {
 	_, err := NewPackageManager("nonexistent", "../../..")
 	if err == nil {
@@ -299,3 +330,172 @@ upgrade: ["cmd3"]
 		t.Errorf("Upgrade command not parsed correctly: %v", config.Upgrade)
 	}
 }
+
+func TestNewPackageManager_AptListInstalledCommand(t *testing.T) {
+	pm, err := NewPackageManager("apt", "../../..")
+	if err != nil {
+		t.Fatalf("Failed to create apt package manager: %v", err)
+	}
+
+	concrete := pm.(*packageManager)
+	expected := []string{"dpkg-query", "-W", "-f=${Package} ${Version}\n"}
+	if len(concrete.config.ListInstalled) != len(expected) {
+		t.Fatalf("Expected list_installed command %q, got %q", expected, concrete.config.ListInstalled)
+	}
+	for i := range expected {
+		if concrete.config.ListInstalled[i] != expected[i] {
+			t.Errorf("Expected list_installed arg %d to be %q, got %q", i, expected[i], concrete.config.ListInstalled[i])
+		}
+	}
+}
+
+func TestNewPackageManager_OpkgListInstalledCommand(t *testing.T) {
+	pm, err := NewPackageManager("opkg", "../../..")
+	if err != nil {
+		t.Fatalf("Failed to create opkg package manager: %v", err)
+	}
+
+	concrete := pm.(*packageManager)
+	if len(concrete.config.ListInstalled) != 2 ||
+		concrete.config.ListInstalled[0] != "opkg" ||
+		concrete.config.ListInstalled[1] != "list-installed" {
+		t.Errorf("Expected list_installed command [opkg list-installed], got %q", concrete.config.ListInstalled)
+	}
+}
+
+func TestParseInstalled(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected map[string]string
+	}{
+		{
+			name:   "dpkg-query format",
+			output: "git 1:2.43.0-1ubuntu7\ncurl 8.5.0-2ubuntu10.1\n",
+			expected: map[string]string{
+				"git":  "1:2.43.0-1ubuntu7",
+				"curl": "8.5.0-2ubuntu10.1",
+			},
+		},
+		{
+			name:   "opkg format",
+			output: "busybox - 1.36.1-1\nopkg - 2024.10.16~38eccbb1-r1\n",
+			expected: map[string]string{
+				"busybox": "1.36.1-1",
+				"opkg":    "2024.10.16~38eccbb1-r1",
+			},
+		},
+		{
+			name:     "skips lines without version",
+			output:   "removed-pkg \n\ngit 2.43.0\n",
+			expected: map[string]string{"git": "2.43.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseInstalled(tt.output)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, got)
+			}
+			for name, version := range tt.expected {
+				if got[name] != version {
+					t.Errorf("Expected %s=%s, got %s", name, version, got[name])
+				}
+			}
+		})
+	}
+}
+
+func TestListInstalled_ExecutesCommand(t *testing.T) {
+	pm := &packageManager{
+		name: "test",
+		config: &PackageManagerConfig{
+			ListInstalled: []string{"printf", "git 2.43.0\ncurl 8.5.0\n"},
+		},
+	}
+
+	installed, err := pm.ListInstalled()
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if installed["git"] != "2.43.0" || installed["curl"] != "8.5.0" {
+		t.Errorf("Unexpected installed packages: %v", installed)
+	}
+
+	version, err := pm.InstalledVersion("git")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if version != "2.43.0" {
+		t.Errorf("Expected version 2.43.0, got %s", version)
+	}
+
+	_, err = pm.InstalledVersion("vim")
+	if !errors.Is(err, ErrPackageNotInstalled) {
+		t.Errorf("Expected ErrPackageNotInstalled, got: %v", err)
+	}
+}
+
+func TestListInstalled_NotConfigured(t *testing.T) {
+	pm := &packageManager{
+		name:   "test",
+		config: &PackageManagerConfig{},
+	}
+
+	if _, err := pm.ListInstalled(); err == nil {
+		t.Error("Expected error when list_installed is not configured")
+	}
+}
+
+func TestListInstalled_CommandFailure(t *testing.T) {
+	pm := &packageManager{
+		name: "test",
+		config: &PackageManagerConfig{
+			ListInstalled: []string{"false"},
+		},
+	}
+
+	if _, err := pm.ListInstalled(); err == nil {
+		t.Error("Expected error when list_installed command fails")
+	}
+}
+
+func TestMockPackageManager_InstalledVersions(t *testing.T) {
+	mock := &MockPackageManager{
+		ListInstalledFunc: func() (map[string]string, error) {
+			return map[string]string{"git": "2.43.0"}, nil
+		},
+		InstalledVersionFunc: func(pkg string) (string, error) {
+			if pkg == "git" {
+				return "2.43.0", nil
+			}
+			return "", ErrPackageNotInstalled
+		},
+	}
+
+	var pm PackageManager = mock
+
+	installed, err := pm.ListInstalled()
+	if err != nil || installed["git"] != "2.43.0" {
+		t.Errorf("Unexpected ListInstalled result: %v, %v", installed, err)
+	}
+
+	version, err := pm.InstalledVersion("git")
+	if err != nil || version != "2.43.0" {
+		t.Errorf("Unexpected InstalledVersion result: %s, %v", version, err)
+	}
+
+	if _, err := pm.InstalledVersion("vim"); !errors.Is(err, ErrPackageNotInstalled) {
+		t.Errorf("Expected ErrPackageNotInstalled, got: %v", err)
+	}
+
+	// Unset funcs fall back to defaults
+	empty := &MockPackageManager{}
+	if installed, err := empty.ListInstalled(); err != nil || len(installed) != 0 {
+		t.Errorf("Expected empty map, got %v, %v", installed, err)
+	}
+	if _, err := empty.InstalledVersion("git"); !errors.Is(err, ErrPackageNotInstalled) {
+		t.Errorf("Expected ErrPackageNotInstalled, got: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/files"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/runtime"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/selfupdate"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// canaryCheckInterval is how often the canary services are checked while they
+// must stay active (see checkCanaryHealth).
+var canaryCheckInterval = time.Second
+
+// reconcileCanary reconciles the canary files of specs, restarts their
+// services and checks the health of the device, before reconciling the other
+// files. If the canary fails, the canary files are rolled back, their services
+// are marked for restart so that they run the restored files again, and the
+// other files are skipped. The failed config commit is then skipped until a new
+// one is pushed, instead of being rolled out and back in each iteration.
+//
+// It returns false if the files of the config commit were not applied, and
+// the checksums of the reconciled files otherwise, nil if they are unknown
+// because a reconciliation failed. The caller writes them to the manifest.
+func (r *Reconciler) reconcileCanary(ctx context.Context, state *runtime.RuntimeState, specs []userconfig.FileSpec) (bool, files.Manifest) {
+	commit := r.configCommit()
+	if commit != "" && commit == r.canaryFailedCommit {
+		err := fmt.Errorf("config commit %s failed its canary health check, waiting for a new commit", commit)
+		slog.Warn("Skipping file reconciliation", "error", err)
+		state.AddError("canary", err)
+		return false, nil
+	}
+
+	canary, rest := splitCanaryFiles(specs)
+
+	// The manifest is restored if the canary is rolled back
+	var previous files.Manifest
+	if r.config.FilesManifestPath != "" {
+		m, err := files.ReadManifest(r.config.FilesManifestPath)
+		if err != nil {
+			slog.Warn("Failed to read file manifest", "path", r.config.FilesManifestPath, "error", err)
+		}
+		previous = m
+	}
+
+	slog.Info("Reconciling canary files", "files", len(canary))
+	canaryState := runtime.NewRuntimeState()
+	canaryResult := r.reconcileFileSpecs(canaryState, canary)
+
+	err := stateErr(canaryState)
+	if err == nil && canaryResult != nil && len(canaryResult.Changes) > 0 {
+		r.restartServices(ctx, canaryState)
+		err = stateErr(canaryState)
+		if err == nil {
+			err = r.checkCanaryHealth(*r.config.Spec.Canary)
+		}
+	}
+	if err != nil {
+		r.rollbackCanary(state, canaryState, canaryResult, previous, commit, err)
+		return false, nil
+	}
+	if canaryResult != nil && len(canaryResult.Changes) > 0 {
+		slog.Info("Canary is healthy, reconciling the other files")
+	}
+
+	// The canary services are restarted again with the services of the other
+	// files, as they may read them too
+	for _, svc := range canaryState.GetServicesToRestart() {
+		state.AddServiceRestart(svc)
+	}
+	state.AddFileChanges(canaryState.FileChanges)
+
+	restResult := r.reconcileFileSpecs(state, rest)
+	if (canaryResult == nil && len(canary) > 0) || (restResult == nil && len(rest) > 0) {
+		return true, nil
+	}
+
+	checksums := files.Manifest{}
+	for _, result := range []*files.ReconcileResult{canaryResult, restResult} {
+		if result == nil {
+			continue
+		}
+		for path, sum := range result.Checksums {
+			checksums[path] = sum
+		}
+	}
+	return true, checksums
+}
+
+// rollbackCanary restores the files changed by the canary, whose result is
+// given, after it failed with cause, and restores the previous manifest.
+func (r *Reconciler) rollbackCanary(
+	state, canaryState *runtime.RuntimeState,
+	canaryResult *files.ReconcileResult,
+	previous files.Manifest,
+	commit string,
+	cause error,
+) {
+	slog.Error("Canary failed, rolling back the canary files and skipping the other files", "commit", commit, "error", cause)
+	state.AddError("canary", cause)
+	r.canaryFailedCommit = commit
+
+	if canaryResult == nil || len(canaryResult.Backups) == 0 {
+		return
+	}
+
+	if err := r.fileRec.Restore(canaryResult.Backups); err != nil {
+		slog.Error("Failed to roll back the canary files", "error", err)
+		state.AddError("roll back canary files", err)
+	}
+	for _, svc := range canaryState.GetServicesToRestart() {
+		state.AddServiceRestart(svc)
+	}
+
+	if r.config.FilesManifestPath != "" && previous != nil {
+		if err := files.WriteManifest(r.config.FilesManifestPath, previous); err != nil {
+			slog.Warn("Failed to restore file manifest", "path", r.config.FilesManifestPath, "error", err)
+		}
+	}
+}
+
+// checkCanaryHealth checks the services of canary stay active for its stable
+// period, then that its URL answers 200.
+func (r *Reconciler) checkCanaryHealth(canary userconfig.CanarySection) error {
+	if len(canary.Services) > 0 {
+		stableSeconds := canary.StableSeconds
+		if stableSeconds <= 0 {
+			stableSeconds = userconfig.DefaultCanaryStableSeconds
+		}
+
+		deadline := time.Now().Add(time.Duration(stableSeconds) * time.Second)
+		for {
+			for _, svc := range canary.Services {
+				active, err := r.svcMgr.IsActive(svc)
+				if err != nil {
+					return fmt.Errorf("failed to check service %s: %w", svc, err)
+				}
+				if !active {
+					return fmt.Errorf("service %s is not active", svc)
+				}
+			}
+
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				break
+			}
+			time.Sleep(min(canaryCheckInterval, remaining))
+		}
+	}
+
+	if canary.URL != "" {
+		return selfupdate.NewHTTPHealthChecker(canary.URL).Check()
+	}
+	return nil
+}
+
+// configCommit returns the current commit of the config repo, or "" if it is
+// not tracked.
+func (r *Reconciler) configCommit() string {
+	if strings.HasPrefix(r.config.Spec.Config.Repo.URL, "file://") {
+		return ""
+	}
+	commit, err := r.gitMgr.GetCurrentCommit(r.config.ConfigRepoPath)
+	if err != nil {
+		return ""
+	}
+	return commit
+}
+
+// splitCanaryFiles returns the specs marked canary and the other specs, in
+// the order of the config.
+func splitCanaryFiles(specs []userconfig.FileSpec) (canary, rest []userconfig.FileSpec) {
+	for _, spec := range specs {
+		if spec.Canary {
+			canary = append(canary, spec)
+		} else {
+			rest = append(rest, spec)
+		}
+	}
+	return canary, rest
+}
+
+// stateErr returns the errors recorded in state as a single error, or nil.
+func stateErr(state *runtime.RuntimeState) error {
+	if len(state.Errors) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(state.Errors, "; "))
+}
@@ -0,0 +1,169 @@
+package reconcile
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/config"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/files"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/git"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/runtime"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/svcmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// newCanaryTestReconciler returns a reconciler of a spec with a canary file
+// restarting app and a file restarting web, written to fsys.
+func newCanaryTestReconciler(fsys *files.MemFS, canary userconfig.CanarySection, svcMgr *svcmgr.MockServiceManager) *Reconciler {
+	cfg := &config.Config{
+		ConfigRepoPath: "/config",
+		Spec: &userconfig.Spec{
+			Config: userconfig.ConfigSection{
+				Repo: userconfig.ConfigRepo{URL: "https://github.com/test/config.git"},
+			},
+			Files: []userconfig.FileSpec{
+				{
+					Type: "content", DestPath: "/etc/app/app.conf", Content: "port=8080", FileMod: "644", Canary: true,
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"app"}},
+				},
+				{
+					Type: "content", DestPath: "/etc/app/feature.conf", Content: "enabled=true", FileMod: "644", Canary: true,
+				},
+				{
+					Type: "content", DestPath: "/etc/web/web.conf", Content: "upstream=8080", FileMod: "644",
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"web"}},
+				},
+			},
+			Canary: &canary,
+		},
+	}
+	gitMgr := &git.MockRepoManager{
+		GetCurrentCommitFunc: func(repoPath string) (string, error) { return "abc123", nil },
+	}
+
+	return NewReconciler(cfg, gitMgr, nil, svcMgr, files.NewFileReconciler(files.WithFS(fsys)), nil, nil, nil, nil)
+}
+
+func TestReconcileCanary_Healthy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	fsys := files.NewMemFS()
+	fsys.WriteFile("/etc/app/app.conf", []byte("port=80"), 0644)
+	svcMgr := &svcmgr.MockServiceManager{}
+	r := newCanaryTestReconciler(fsys, userconfig.CanarySection{URL: server.URL}, svcMgr)
+	state := runtime.NewRuntimeState()
+
+	if !r.reconcileFiles(context.Background(), state) {
+		t.Fatal("reconcileFiles() = false, want the files applied")
+	}
+
+	if len(state.Errors) != 0 {
+		t.Errorf("Errors = %v, want none", state.Errors)
+	}
+	for path, want := range map[string]string{
+		"/etc/app/app.conf":     "port=8080",
+		"/etc/app/feature.conf": "enabled=true",
+		"/etc/web/web.conf":     "upstream=8080",
+	} {
+		if got, err := fsys.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("content of %s = %q (error %v), want %q", path, got, err, want)
+		}
+	}
+	// The canary service is restarted before the health check
+	if want := []string{"app"}; !reflect.DeepEqual(svcMgr.RestartCalls, want) {
+		t.Errorf("RestartCalls = %v, want %v", svcMgr.RestartCalls, want)
+	}
+	if got, want := state.GetServicesToRestart(), []string{"app", "web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("services to restart = %v, want %v", got, want)
+	}
+	if got := state.FileChanges[files.ChangeContent]; got != 3 {
+		t.Errorf("content changes = %d, want 3", got)
+	}
+}
+
+func TestReconcileCanary_UnhealthyRollsBack(t *testing.T) {
+	fsys := files.NewMemFS()
+	fsys.WriteFile("/etc/app/app.conf", []byte("port=80"), 0600)
+	svcMgr := &svcmgr.MockServiceManager{
+		IsActiveFunc: func(serviceName string) (bool, error) { return serviceName != "app", nil },
+	}
+	r := newCanaryTestReconciler(fsys, userconfig.CanarySection{Services: []string{"app"}}, svcMgr)
+	state := runtime.NewRuntimeState()
+
+	if r.reconcileFiles(context.Background(), state) {
+		t.Fatal("reconcileFiles() = true, want the files of the failed canary not applied")
+	}
+
+	// The canary files are restored, the other files are skipped
+	got, err := fsys.ReadFile("/etc/app/app.conf")
+	if err != nil || string(got) != "port=80" {
+		t.Errorf("content of /etc/app/app.conf = %q (error %v), want the previous content", got, err)
+	}
+	if info, err := fsys.Stat("/etc/app/app.conf"); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode of /etc/app/app.conf = %v (error %v), want the previous mode 600", info.Mode().Perm(), err)
+	}
+	for _, path := range []string{"/etc/app/feature.conf", "/etc/web/web.conf"} {
+		if _, err := fsys.Stat(path); err == nil {
+			t.Errorf("%s exists, want it not written", path)
+		}
+	}
+
+	if len(state.Errors) != 1 || state.Errors[0] != "canary: service app is not active" {
+		t.Errorf("Errors = %v, want the failed canary", state.Errors)
+	}
+	// The canary service is restarted again on the restored files
+	if got, want := state.GetServicesToRestart(), []string{"app"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("services to restart = %v, want %v", got, want)
+	}
+	if len(state.FileChanges) != 0 {
+		t.Errorf("FileChanges = %v, want none for rolled back files", state.FileChanges)
+	}
+
+	// The failed commit is not rolled out again
+	svcMgr.RestartCalls = nil
+	state = runtime.NewRuntimeState()
+	if r.reconcileFiles(context.Background(), state) {
+		t.Fatal("reconcileFiles() = true for the failed commit, want it skipped")
+	}
+	if len(svcMgr.RestartCalls) != 0 {
+		t.Errorf("RestartCalls = %v, want none", svcMgr.RestartCalls)
+	}
+	if len(state.Errors) != 1 || !strings.Contains(state.Errors[0], "config commit abc123 failed its canary health check") {
+		t.Errorf("Errors = %v, want the skipped commit", state.Errors)
+	}
+	if got, _ := fsys.ReadFile("/etc/app/app.conf"); string(got) != "port=80" {
+		t.Errorf("content of /etc/app/app.conf = %q, want the previous content", got)
+	}
+}
+
+func TestReconcileCanary_UnchangedSkipsHealthCheck(t *testing.T) {
+	fsys := files.NewMemFS()
+	fsys.WriteFile("/etc/app/app.conf", []byte("port=8080"), 0644)
+	fsys.WriteFile("/etc/app/feature.conf", []byte("enabled=true"), 0644)
+	checked := false
+	svcMgr := &svcmgr.MockServiceManager{
+		IsActiveFunc: func(serviceName string) (bool, error) {
+			checked = true
+			return false, nil
+		},
+	}
+	r := newCanaryTestReconciler(fsys, userconfig.CanarySection{Services: []string{"app"}}, svcMgr)
+	state := runtime.NewRuntimeState()
+
+	if !r.reconcileFiles(context.Background(), state) {
+		t.Fatal("reconcileFiles() = false, want the files applied")
+	}
+	if checked {
+		t.Error("health check run without canary change")
+	}
+	if got, err := fsys.ReadFile("/etc/web/web.conf"); err != nil || string(got) != "upstream=8080" {
+		t.Errorf("content of /etc/web/web.conf = %q (error %v), want it written", got, err)
+	}
+}
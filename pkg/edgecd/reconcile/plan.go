@@ -0,0 +1,204 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/files"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/runtime"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// ErrStalePlan is returned by Apply when the config commit changed since the
+// plan was computed.
+var ErrStalePlan = errors.New("plan is stale: the config commit changed since it was computed")
+
+// Plan is the set of changes a reconciliation would apply to the device,
+// computed by Reconciler.Plan without changing the device, so that it can be
+// reviewed before it is applied with Reconciler.Apply.
+type Plan struct {
+	// Commit is the config commit the plan was computed from, empty if the
+	// config repo is not tracked
+	Commit        string `json:"commit,omitempty"`
+	ConfigChanged bool   `json:"configChanged"`
+	// InstallPackages are installed, as the part of the config the packages
+	// depend on changed
+	InstallPackages []string `json:"installPackages,omitempty"`
+	// UpgradePackages are upgraded, as packageManager.autoUpgrade is set
+	UpgradePackages []string `json:"upgradePackages,omitempty"`
+	// Files are the diffs of the files whose content, permissions or ownership
+	// drifted
+	Files []files.FileDiff `json:"files,omitempty"`
+	// FileSpecs are the specs of Files, with their paths resolved, the only
+	// ones reconciled by Apply
+	FileSpecs         []userconfig.FileSpec `json:"fileSpecs,omitempty"`
+	ServicesToRestart []string              `json:"servicesToRestart,omitempty"`
+	Reboot            bool                  `json:"reboot"`
+}
+
+// Empty returns true if applying the plan changes nothing on the device.
+func (p Plan) Empty() bool {
+	return len(p.InstallPackages) == 0 && len(p.UpgradePackages) == 0 && len(p.FileSpecs) == 0
+}
+
+// Plan syncs the repositories and computes the changes the reconciliation of
+// their current checkout would apply: the packages to install or upgrade, the
+// files whose content, permissions or ownership drifted, and the services to
+// restart or the reboot they trigger. Nothing is written on the device. edge-cd
+// itself is only updated by the reconciliation loop.
+//
+// Unlike the reconciliation loop, a repo that cannot be synced fails the plan,
+// whatever config.syncFailurePolicy: a plan of a stale checkout is not worth
+// reviewing.
+func (r *Reconciler) Plan(ctx context.Context) (Plan, error) {
+	r.refreshFacts()
+
+	if err := r.syncEdgeCDRepo(); err != nil {
+		return Plan{}, fmt.Errorf("failed to sync edge-cd repo: %w", err)
+	}
+	if err := r.syncConfigRepo(); err != nil {
+		return Plan{}, fmt.Errorf("failed to sync config repo: %w", err)
+	}
+
+	plan := Plan{Commit: r.configCommit(), ConfigChanged: r.isConfigChanged()}
+
+	if plan.ConfigChanged && r.isPackageConfigChanged() {
+		packages, err := r.requiredPackages()
+		if err != nil {
+			return Plan{}, fmt.Errorf("failed to resolve required packages: %w", err)
+		}
+		plan.InstallPackages = packages
+	}
+	if r.config.Spec.PackageManager.AutoUpgrade {
+		packages, err := r.requiredPackages()
+		if err != nil {
+			return Plan{}, fmt.Errorf("failed to resolve required packages: %w", err)
+		}
+		plan.UpgradePackages = packages
+	}
+
+	state := runtime.NewRuntimeState()
+	specs := r.allowedFileSpecs(state, r.resolvedFileSpecs(state, r.config.Spec.Files))
+	if len(state.Errors) > 0 {
+		return Plan{}, fmt.Errorf("failed to plan files: %w", stateErr(state))
+	}
+
+	services := make(map[string]bool)
+	for _, spec := range specs {
+		diffs, err := r.fileRec.Diff(r.config.ConfigRepoPath, r.config.Spec.Config.Path, []userconfig.FileSpec{spec})
+		if err != nil {
+			return Plan{}, fmt.Errorf("failed to plan %s: %w", spec.DestPath, err)
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+
+		plan.Files = append(plan.Files, diffs...)
+		plan.FileSpecs = append(plan.FileSpecs, spec)
+		if spec.SyncBehavior != nil {
+			for _, svc := range spec.SyncBehavior.RestartServices {
+				services[svc] = true
+			}
+			plan.Reboot = plan.Reboot || spec.SyncBehavior.Reboot
+		}
+	}
+	for svc := range services {
+		plan.ServicesToRestart = append(plan.ServicesToRestart, svc)
+	}
+	sort.Strings(plan.ServicesToRestart)
+
+	slog.Info("Planned reconciliation",
+		"commit", plan.Commit,
+		"installPackages", len(plan.InstallPackages),
+		"upgradePackages", len(plan.UpgradePackages),
+		"files", len(plan.Files),
+		"servicesToRestart", plan.ServicesToRestart,
+		"reboot", plan.Reboot,
+	)
+	return plan, nil
+}
+
+// Apply applies exactly the changes of plan: it installs and upgrades its
+// packages, reconciles its file specs, the canary ones first if a canary is
+// configured, then reboots or restarts the services of the reconciled files,
+// and records the config commit as synced. The repositories are not synced
+// again: ErrStalePlan is returned, without applying anything, if the config
+// commit changed since the plan was computed.
+//
+// Like ReconcilePaths, the file reconciler must not write the manifest itself
+// (see files.WithManifest): only the entries of the reconciled files are
+// updated. It returns an error listing the failed steps, if any.
+func (r *Reconciler) Apply(ctx context.Context, plan Plan) (runtime.Result, error) {
+	if commit := r.configCommit(); commit != plan.Commit {
+		return runtime.Result{}, fmt.Errorf("%w: planned %q, current %q", ErrStalePlan, plan.Commit, commit)
+	}
+
+	r.refreshFacts()
+	state := runtime.NewRuntimeState()
+
+	if len(plan.InstallPackages) > 0 {
+		slog.Info("Installing planned packages", "packages", plan.InstallPackages)
+		if err := r.pkgMgr.Install(plan.InstallPackages); err != nil {
+			slog.Error("Failed to install packages", "error", err)
+			state.AddError("install packages", err)
+		}
+	}
+	if len(plan.UpgradePackages) > 0 {
+		slog.Info("Upgrading planned packages", "packages", plan.UpgradePackages)
+		if err := r.pkgMgr.Upgrade(plan.UpgradePackages); err != nil {
+			slog.Error("Failed to upgrade packages", "error", err)
+			state.AddError("upgrade packages", err)
+		}
+	}
+
+	applied := r.applyFileSpecs(ctx, state, plan.FileSpecs)
+
+	// The files out of the plan did not drift: the commit is applied once the
+	// planned files are, and recorded before a reboot like in the loop
+	if applied {
+		if err := r.commitLastChange(); err != nil {
+			state.AddError("commit last change", err)
+		}
+	}
+
+	if state.RequireReboot {
+		return r.reboot(ctx, state, plan.ConfigChanged), nil
+	}
+
+	r.restartServices(ctx, state)
+
+	result := r.result(state, plan.ConfigChanged)
+	r.writeSummary(result)
+	r.notify(ctx, result)
+	if len(result.Errors) > 0 {
+		return result, fmt.Errorf("failed to apply the plan: %s", strings.Join(result.Errors, "; "))
+	}
+	return result, nil
+}
+
+// applyFileSpecs reconciles the planned specs and updates their entries of the
+// manifest. It returns false if they were not applied because their canary
+// failed.
+func (r *Reconciler) applyFileSpecs(ctx context.Context, state *runtime.RuntimeState, specs []userconfig.FileSpec) bool {
+	if len(specs) == 0 {
+		return true
+	}
+
+	applied, checksums := true, files.Manifest(nil)
+	if r.config.Spec.Canary != nil {
+		applied, checksums = r.reconcileCanary(ctx, state, specs)
+	} else if result := r.reconcileFileSpecs(state, specs); result != nil {
+		checksums = result.Checksums
+	}
+
+	if checksums != nil && r.config.FilesManifestPath != "" {
+		if err := files.UpdateManifest(r.config.FilesManifestPath, checksums); err != nil {
+			slog.Warn("Failed to update file manifest", "path", r.config.FilesManifestPath, "error", err)
+		}
+	}
+	return applied
+}
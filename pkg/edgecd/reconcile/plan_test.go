@@ -0,0 +1,202 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/config"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/files"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/git"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/pkgmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/svcmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// newPlanTestReconciler returns a reconciler of a spec with a drifted file
+// restarting app, a missing file restarting web and an in-sync file, written
+// to fsys, whose config repo is at *commit.
+func newPlanTestReconciler(t *testing.T, fsys *files.MemFS, commit *string, pkgMgr *pkgmgr.MockPackageManager, svcMgr *svcmgr.MockServiceManager) *Reconciler {
+	t.Helper()
+	tmp := t.TempDir()
+	cfg := &config.Config{
+		EdgeCDRepoPath:   tmp,
+		ConfigRepoPath:   tmp,
+		ConfigCommitPath: filepath.Join(tmp, "state", "config-commit"),
+		Spec: &userconfig.Spec{
+			Config: userconfig.ConfigSection{
+				Repo: userconfig.ConfigRepo{URL: "https://github.com/test/config.git"},
+			},
+			PackageManager: userconfig.PackageManagerSection{Name: "apt", RequiredPackages: []string{"curl", "jq"}},
+			Files: []userconfig.FileSpec{
+				{
+					Type: "content", DestPath: "/etc/app/app.conf", Content: "port=8080", FileMod: "644",
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"app"}},
+				},
+				{
+					Type: "content", DestPath: "/etc/web/web.conf", Content: "upstream=8080", FileMod: "644",
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"web"}},
+				},
+				{
+					Type: "content", DestPath: "/etc/db/db.conf", Content: "size=1G", FileMod: "644",
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"db"}},
+				},
+			},
+		},
+	}
+	gitMgr := &git.MockRepoManager{
+		GetCurrentCommitFunc: func(repoPath string) (string, error) { return *commit, nil },
+	}
+
+	return NewReconciler(cfg, gitMgr, pkgMgr, svcMgr, files.NewFileReconciler(files.WithFS(fsys)), nil, nil, nil, nil)
+}
+
+func TestPlanApply(t *testing.T) {
+	fsys := files.NewMemFS()
+	fsys.WriteFile("/etc/app/app.conf", []byte("port=80"), 0644)
+	fsys.WriteFile("/etc/db/db.conf", []byte("size=1G"), 0644)
+	commit := "abc123"
+	var installed []string
+	pkgMgr := &pkgmgr.MockPackageManager{
+		InstallFunc: func(packages []string) error { installed = packages; return nil },
+	}
+	svcMgr := &svcmgr.MockServiceManager{}
+	r := newPlanTestReconciler(t, fsys, &commit, pkgMgr, svcMgr)
+
+	plan, err := r.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	if plan.Commit != "abc123" || !plan.ConfigChanged {
+		t.Errorf("commit = %q, configChanged = %v, want abc123 changed", plan.Commit, plan.ConfigChanged)
+	}
+	if want := []string{"curl", "jq"}; !reflect.DeepEqual(plan.InstallPackages, want) {
+		t.Errorf("InstallPackages = %v, want %v", plan.InstallPackages, want)
+	}
+	if len(plan.UpgradePackages) != 0 {
+		t.Errorf("UpgradePackages = %v, want none without autoUpgrade", plan.UpgradePackages)
+	}
+	var planned []string
+	for _, diff := range plan.Files {
+		planned = append(planned, diff.DestPath)
+	}
+	if want := []string{"/etc/app/app.conf", "/etc/web/web.conf"}; !reflect.DeepEqual(planned, want) {
+		t.Errorf("planned files = %v, want %v", planned, want)
+	}
+	if !plan.Files[1].Missing {
+		t.Errorf("%s is not planned missing", plan.Files[1].DestPath)
+	}
+	if want := []string{"app", "web"}; !reflect.DeepEqual(plan.ServicesToRestart, want) {
+		t.Errorf("ServicesToRestart = %v, want %v", plan.ServicesToRestart, want)
+	}
+	if plan.Reboot {
+		t.Error("Reboot = true, want false")
+	}
+
+	// Planning writes nothing
+	if got, _ := fsys.ReadFile("/etc/app/app.conf"); string(got) != "port=80" {
+		t.Errorf("content of /etc/app/app.conf = %q after planning, want it unchanged", got)
+	}
+	if len(installed) != 0 || len(svcMgr.RestartCalls) != 0 {
+		t.Errorf("installed %v and restarted %v while planning, want nothing", installed, svcMgr.RestartCalls)
+	}
+
+	result, err := r.Apply(context.Background(), plan)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(installed, plan.InstallPackages) {
+		t.Errorf("installed %v, want %v", installed, plan.InstallPackages)
+	}
+	if got := result.FileChanges[files.ChangeContent]; got != len(plan.Files) {
+		t.Errorf("content changes = %d, want the %d planned files", got, len(plan.Files))
+	}
+	if !reflect.DeepEqual(result.ServicesRestarted, plan.ServicesToRestart) {
+		t.Errorf("ServicesRestarted = %v, want the planned %v", result.ServicesRestarted, plan.ServicesToRestart)
+	}
+	if !reflect.DeepEqual(svcMgr.RestartCalls, plan.ServicesToRestart) {
+		t.Errorf("RestartCalls = %v, want the planned %v", svcMgr.RestartCalls, plan.ServicesToRestart)
+	}
+	if got, err := os.ReadFile(r.config.ConfigCommitPath); err != nil || string(got) != "abc123" {
+		t.Errorf("config commit = %q (error %v), want abc123 recorded", got, err)
+	}
+
+	// Once applied, there is nothing left to plan
+	plan, err = r.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if !plan.Empty() || len(plan.Files) != 0 || len(plan.ServicesToRestart) != 0 {
+		t.Errorf("plan after apply = %+v, want it empty", plan)
+	}
+}
+
+func TestPlanApply_ModeDrift(t *testing.T) {
+	fsys := files.NewMemFS()
+	fsys.WriteFile("/etc/app/app.conf", []byte("port=8080"), 0644)
+	fsys.WriteFile("/etc/web/web.conf", []byte("upstream=8080"), 0644)
+	fsys.WriteFile("/etc/db/db.conf", []byte("size=1G"), 0600)
+	commit := "abc123"
+	svcMgr := &svcmgr.MockServiceManager{}
+	r := newPlanTestReconciler(t, fsys, &commit, &pkgmgr.MockPackageManager{}, svcMgr)
+
+	plan, err := r.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	if want := []files.FileDiff{{DestPath: "/etc/db/db.conf", Mode: "0644"}}; !reflect.DeepEqual(plan.Files, want) {
+		t.Fatalf("planned files = %+v, want %+v", plan.Files, want)
+	}
+	if want := []string{"db"}; !reflect.DeepEqual(plan.ServicesToRestart, want) {
+		t.Errorf("ServicesToRestart = %v, want %v", plan.ServicesToRestart, want)
+	}
+
+	result, err := r.Apply(context.Background(), plan)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if got := result.FileChanges[files.ChangeMode]; got != 1 {
+		t.Errorf("mode changes = %d, want 1", got)
+	}
+	if info, err := fsys.Stat("/etc/db/db.conf"); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("mode of /etc/db/db.conf = %v (error %v), want 0644", info.Mode().Perm(), err)
+	}
+	if !reflect.DeepEqual(svcMgr.RestartCalls, []string{"db"}) {
+		t.Errorf("RestartCalls = %v, want [db]", svcMgr.RestartCalls)
+	}
+}
+
+func TestApply_StalePlan(t *testing.T) {
+	fsys := files.NewMemFS()
+	commit := "abc123"
+	var installed []string
+	pkgMgr := &pkgmgr.MockPackageManager{
+		InstallFunc: func(packages []string) error { installed = packages; return nil },
+	}
+	r := newPlanTestReconciler(t, fsys, &commit, pkgMgr, &svcmgr.MockServiceManager{})
+
+	plan, err := r.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	// A new commit is pushed between the review and the apply
+	commit = "def456"
+	if _, err := r.Apply(context.Background(), plan); !errors.Is(err, ErrStalePlan) {
+		t.Fatalf("Apply() error = %v, want %v", err, ErrStalePlan)
+	}
+
+	if len(installed) != 0 {
+		t.Errorf("installed %v, want nothing applied", installed)
+	}
+	if _, err := fsys.Stat("/etc/app/app.conf"); err == nil {
+		t.Error("/etc/app/app.conf exists, want nothing applied")
+	}
+}
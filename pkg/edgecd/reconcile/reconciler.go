@@ -2,29 +2,54 @@ package reconcile
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/config"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/facts"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/files"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/git"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/notify"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/pkgmgr"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/runtime"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/selfupdate"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/svcmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
 )
 
+// verifyActiveInterval is how often the state of a restarted service is
+// checked (see verifyActive).
+var verifyActiveInterval = time.Second
+
 // Reconciler orchestrates the edge-cd reconciliation loop.
 // It coordinates all operations: syncing repos, reconciling packages/files/services.
 type Reconciler struct {
-	config  *config.Config
-	gitMgr  git.RepoManager
-	pkgMgr  pkgmgr.PackageManager
-	svcMgr  svcmgr.ServiceManager
-	fileRec files.FileReconciler
+	config   *config.Config
+	gitMgr   git.RepoManager
+	pkgMgr   pkgmgr.PackageManager
+	svcMgr   svcmgr.ServiceManager
+	fileRec  files.FileReconciler
+	updater  selfupdate.Updater
+	notifier notify.Notifier
+	facts    *facts.Cache
+
+	heartbeat notify.Heartbeater
+	beating   atomic.Bool // true while a heartbeat is being sent
+
+	// lastFetch is when each repository, keyed by its path, was last synced
+	// successfully (see shouldFetch)
+	lastFetch map[string]time.Time
+	now       func() time.Time
+
+	// canaryFailedCommit is the last config commit whose canary failed and was
+	// rolled back (see reconcileCanary)
+	canaryFailedCommit string
 }
 
 // NewReconciler creates a new Reconciler with injected dependencies.
@@ -34,13 +59,23 @@ func NewReconciler(
 	pkgMgr pkgmgr.PackageManager,
 	svcMgr svcmgr.ServiceManager,
 	fileRec files.FileReconciler,
+	updater selfupdate.Updater,
+	notifier notify.Notifier,
+	heartbeat notify.Heartbeater,
+	factsCache *facts.Cache,
 ) *Reconciler {
 	return &Reconciler{
-		config:  cfg,
-		gitMgr:  gitMgr,
-		pkgMgr:  pkgMgr,
-		svcMgr:  svcMgr,
-		fileRec: fileRec,
+		config:    cfg,
+		gitMgr:    gitMgr,
+		pkgMgr:    pkgMgr,
+		svcMgr:    svcMgr,
+		fileRec:   fileRec,
+		updater:   updater,
+		notifier:  notifier,
+		heartbeat: heartbeat,
+		facts:     factsCache,
+		lastFetch: make(map[string]time.Time),
+		now:       time.Now,
 	}
 }
 
@@ -62,81 +97,180 @@ func (r *Reconciler) Run(ctx context.Context) {
 func (r *Reconciler) reconcile(ctx context.Context) {
 	state := runtime.NewRuntimeState()
 
+	// 0. Gather the host facts once for the iteration
+	r.refreshFacts()
+
 	// 1. Sync edge-cd repo
-	r.syncEdgeCDRepo()
+	if err := r.syncEdgeCDRepo(); err != nil {
+		state.AddError("sync edge-cd repo", err)
+	}
 
 	// 2. Sync config repo
-	r.syncConfigRepo()
+	syncErr := r.syncConfigRepo()
+	failClosed := syncErr != nil && r.config.SyncFailurePolicy != userconfig.SyncFailurePolicyFailOpen
+	if syncErr != nil {
+		state.AddError("sync config repo", syncErr)
+	}
 
 	// 3. Check if config changed
 	configChanged := r.isConfigChanged()
 
-	// 4. Reconcile packages (if changed)
-	if configChanged {
-		r.reconcilePackages()
+	// 4. Reconcile packages (if their part of the config changed)
+	if configChanged && r.isPackageConfigChanged() {
+		if err := r.reconcilePackages(); err != nil {
+			state.AddError("install packages", err)
+		}
 	}
 
 	// 5. Reconcile auto-upgrade
-	r.reconcileAutoUpgrade()
+	if err := r.reconcileAutoUpgrade(); err != nil {
+		state.AddError("upgrade packages", err)
+	}
 
 	// 6. Reconcile edge-cd
 	r.reconcileEdgeCD(state)
 
-	// 7. Reconcile files
-	r.reconcileFiles(state)
+	// 7. Reconcile pinned edge-cd release
+	r.reconcileSelfUpdate(state)
 
-	// 8. Handle reboot
+	// 8. Reconcile files, unless they would come from a stale checkout
+	filesApplied := false
+	if failClosed {
+		slog.Warn("Config repo sync failed, skipping file reconciliation",
+			"policy", userconfig.SyncFailurePolicyFailClosed, "error", syncErr)
+	} else {
+		if syncErr != nil {
+			slog.Warn("Config repo sync failed, reconciling files from the current checkout",
+				"policy", userconfig.SyncFailurePolicyFailOpen, "error", syncErr)
+		}
+		filesApplied = r.reconcileFiles(ctx, state)
+	}
+
+	// 9. Handle reboot. The commit is recorded first, so that the files
+	// requiring the reboot are not applied again once rebooted
 	if state.RequireReboot {
-		r.reboot()
+		if filesApplied {
+			if err := r.commitLastChange(); err != nil {
+				state.AddError("commit last change", err)
+			}
+		}
+		r.reboot(ctx, state, configChanged)
 		return
 	}
 
-	// 9. Restart services
-	r.restartServices(state)
+	// 10. Restart services
+	r.restartServices(ctx, state)
 
-	// 10. Commit changes
-	r.commitLastChange()
+	// 11. Commit changes. The config is not recorded as synced when its files
+	// were skipped or rolled back
+	if filesApplied {
+		if err := r.commitLastChange(); err != nil {
+			state.AddError("commit last change", err)
+		}
+	}
+
+	// 12. Report the outcome of the iteration
+	result := r.result(state, configChanged)
+	r.writeSummary(result)
+	r.notify(ctx, result)
+	r.beat(ctx, result)
+}
+
+// refreshFacts empties the facts cache so that the facts are gathered again
+// for this iteration, and logs them.
+func (r *Reconciler) refreshFacts() {
+	if r.facts == nil {
+		return
+	}
+
+	r.facts.Reset()
+	hostFacts, err := r.facts.Get()
+	if err != nil {
+		slog.Error("Failed to gather host facts", "error", err)
+		return
+	}
+	slog.Info("Gathered host facts", "facts", hostFacts)
 }
 
 // syncEdgeCDRepo clones or syncs the edge-cd repository.
-func (r *Reconciler) syncEdgeCDRepo() {
+// It returns the clone or sync error, which is also logged.
+func (r *Reconciler) syncEdgeCDRepo() error {
 	url := r.config.Spec.EdgeCD.Repo.URL
 	branch := r.config.Spec.EdgeCD.Repo.Branch
 	destPath := r.config.EdgeCDRepoPath
+	// nil checks out the whole repo
+	checkoutPaths := r.config.Spec.EdgeCD.CheckoutPaths()
 
 	if _, err := os.Stat(destPath); os.IsNotExist(err) {
-		if err := r.gitMgr.CloneRepo(url, branch, destPath, []string{"cmd/edge-cd"}); err != nil {
+		if err := r.gitMgr.CloneRepo(url, branch, destPath, checkoutPaths); err != nil {
 			slog.Error("Failed to clone edge-cd repo", "error", err)
+			return err
 		}
 	} else {
-		if err := r.gitMgr.SyncRepo(destPath, branch, []string{"cmd/edge-cd"}); err != nil {
+		if !r.shouldFetch(destPath) {
+			return nil
+		}
+		if err := r.gitMgr.SyncRepo(destPath, branch, checkoutPaths); err != nil {
 			slog.Error("Failed to sync edge-cd repo", "error", err)
+			return err
 		}
 	}
+	r.lastFetch[destPath] = r.now()
+	return nil
 }
 
 // syncConfigRepo clones or syncs the configuration repository.
-func (r *Reconciler) syncConfigRepo() {
+// It returns the clone or sync error, which is also logged.
+func (r *Reconciler) syncConfigRepo() error {
 	url := r.config.Spec.Config.Repo.URL
 	branch := r.config.Spec.Config.Repo.Branch
 	destPath := r.config.ConfigRepoPath
-	configPath := r.config.Spec.Config.Path
+	checkoutPaths := r.config.Spec.Config.Repo.CheckoutPaths(r.config.Spec.Config.Path)
 
 	// Skip git operations for file:// URLs
 	if strings.HasPrefix(url, "file://") {
 		slog.Info("Using local file-based repository for config, skipping git clone")
-		return
+		return nil
 	}
 
 	if _, err := os.Stat(destPath); os.IsNotExist(err) {
-		if err := r.gitMgr.CloneRepo(url, branch, destPath, []string{configPath}); err != nil {
+		if err := r.gitMgr.CloneRepo(url, branch, destPath, checkoutPaths); err != nil {
 			slog.Error("Failed to clone config repo", "error", err)
+			return err
 		}
 	} else {
-		if err := r.gitMgr.SyncRepo(destPath, branch, []string{configPath}); err != nil {
+		if !r.shouldFetch(destPath) {
+			return nil
+		}
+		if err := r.gitMgr.SyncRepo(destPath, branch, checkoutPaths); err != nil {
 			slog.Error("Failed to sync config repo", "error", err)
+			return err
 		}
 	}
+	r.lastFetch[destPath] = r.now()
+	return nil
+}
+
+// shouldFetch reports whether the repository at destPath must be synced with
+// its remote in this iteration. With a MinFetchInterval, a repository synced
+// successfully less than MinFetchInterval ago is not fetched again, and the
+// iteration reconciles its current checkout. A repository whose last sync
+// failed is fetched in each iteration, as its checkout may be out of date.
+func (r *Reconciler) shouldFetch(destPath string) bool {
+	if r.config.MinFetchInterval <= 0 {
+		return true
+	}
+	last, ok := r.lastFetch[destPath]
+	if !ok {
+		return true
+	}
+	minInterval := time.Duration(r.config.MinFetchInterval) * time.Second
+	if elapsed := r.now().Sub(last); elapsed < minInterval {
+		slog.Info("Skipping fetch, the repo was fetched recently",
+			"path", destPath, "elapsed", elapsed.Round(time.Second), "minFetchIntervalSecond", r.config.MinFetchInterval)
+		return false
+	}
+	return true
 }
 
 // isConfigChanged checks if the config repository commit has changed.
@@ -168,34 +302,110 @@ func (r *Reconciler) isConfigChanged() bool {
 	return true
 }
 
+// isPackageConfigChanged reports whether the files of the config the packages
+// depend on changed since the last synced commit: the spec file, the package
+// groups and the files matching packageManager.reconcileOn. It returns true if
+// the changes cannot be listed, e.g. on the first sync.
+func (r *Reconciler) isPackageConfigChanged() bool {
+	lastCommitData, _ := os.ReadFile(r.config.ConfigCommitPath)
+	lastCommit := strings.TrimSpace(string(lastCommitData))
+	if lastCommit == "" {
+		return true
+	}
+
+	currentCommit, err := r.gitMgr.GetCurrentCommit(r.config.ConfigRepoPath)
+	if err != nil {
+		slog.Warn("Failed to get current commit, reconciling packages", "error", err)
+		return true
+	}
+
+	changed, err := r.gitMgr.GetCommitDiff(r.config.ConfigRepoPath, lastCommit, currentCommit)
+	if err != nil {
+		slog.Warn("Failed to list the changed config files, reconciling packages", "error", err)
+		return true
+	}
+
+	for _, file := range changed {
+		if r.isPackageConfigFile(file) {
+			slog.Info("Package config changed", "file", file)
+			return true
+		}
+	}
+
+	slog.Info("Package config unchanged, skipping package reconciliation", "commit", currentCommit)
+	return false
+}
+
+// isPackageConfigFile reports whether file, relative to the config repo,
+// is one of the files the packages depend on (see isPackageConfigChanged).
+func (r *Reconciler) isPackageConfigFile(file string) bool {
+	if specFile, err := filepath.Rel(r.config.ConfigRepoPath, r.config.ConfigSpecPath); err == nil && file == specFile {
+		return true
+	}
+
+	section := r.config.Spec.PackageManager
+	for _, group := range section.PackageGroups {
+		if file == filepath.Join(r.config.Spec.Config.Path, group) {
+			return true
+		}
+	}
+	for _, pattern := range section.ReconcileOn {
+		if ok, _ := filepath.Match(filepath.Join(r.config.Spec.Config.Path, pattern), file); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // reconcilePackages installs required packages.
-func (r *Reconciler) reconcilePackages() {
-	packages := r.config.Spec.PackageManager.RequiredPackages
+// It returns the install error, which is also logged.
+func (r *Reconciler) reconcilePackages() error {
+	packages, err := r.requiredPackages()
+	if err != nil {
+		slog.Error("Failed to resolve required packages", "error", err)
+		return err
+	}
 	if len(packages) == 0 {
-		return
+		return nil
 	}
 
 	slog.Info("Reconciling packages")
 	if err := r.pkgMgr.Install(packages); err != nil {
 		slog.Error("Failed to install packages", "error", err)
+		return err
 	}
+	return nil
 }
 
 // reconcileAutoUpgrade upgrades packages if auto-upgrade is enabled.
-func (r *Reconciler) reconcileAutoUpgrade() {
+// It returns the upgrade error, which is also logged.
+func (r *Reconciler) reconcileAutoUpgrade() error {
 	if !r.config.Spec.PackageManager.AutoUpgrade {
-		return
+		return nil
 	}
 
-	packages := r.config.Spec.PackageManager.RequiredPackages
+	packages, err := r.requiredPackages()
+	if err != nil {
+		slog.Error("Failed to resolve required packages", "error", err)
+		return err
+	}
 	if len(packages) == 0 {
-		return
+		return nil
 	}
 
 	slog.Info("Auto-upgrading packages")
 	if err := r.pkgMgr.Upgrade(packages); err != nil {
 		slog.Error("Failed to upgrade packages", "error", err)
+		return err
 	}
+	return nil
+}
+
+// requiredPackages returns the required packages of the spec, merged with its
+// package groups read from the current checkout of the config repo.
+func (r *Reconciler) requiredPackages() ([]string, error) {
+	configDir := filepath.Join(r.config.ConfigRepoPath, r.config.Spec.Config.Path)
+	return pkgmgr.RequiredPackages(configDir, r.config.Spec.PackageManager)
 }
 
 // reconcileEdgeCD checks if edge-cd script has changed and marks service for restart.
@@ -209,6 +419,7 @@ func (r *Reconciler) reconcileEdgeCD(state *runtime.RuntimeState) {
 	currentCommit, err := r.gitMgr.GetCurrentCommit(r.config.EdgeCDRepoPath)
 	if err != nil {
 		slog.Error("Failed to get current commit", "error", err)
+		state.AddError("reconcile edge-cd", err)
 		return
 	}
 
@@ -231,6 +442,7 @@ func (r *Reconciler) reconcileEdgeCD(state *runtime.RuntimeState) {
 	// Ensure edge-cd service is always enabled
 	if err := r.svcMgr.Enable("edge-cd"); err != nil {
 		slog.Error("Failed to enable edge-cd service", "error", err)
+		state.AddError("enable edge-cd", err)
 	}
 
 	// Write current commit
@@ -238,23 +450,96 @@ func (r *Reconciler) reconcileEdgeCD(state *runtime.RuntimeState) {
 	os.WriteFile(r.config.EdgeCDCommitPath, []byte(currentCommit), 0644)
 }
 
-// reconcileFiles reconciles all files defined in the configuration.
-func (r *Reconciler) reconcileFiles(state *runtime.RuntimeState) {
-	if len(r.config.Spec.Files) == 0 {
+// reconcileSelfUpdate installs the pinned edge-cd release and marks the service
+// for restart when the binary was replaced.
+func (r *Reconciler) reconcileSelfUpdate(state *runtime.RuntimeState) {
+	spec := r.config.Spec.EdgeCD.SelfUpdate
+	if spec == nil || r.updater == nil {
 		return
 	}
 
+	slog.Info("Reconciling EdgeCD release", "version", spec.Version)
+
+	updated, err := r.updater.Reconcile(*spec)
+	if errors.Is(err, selfupdate.ErrVersionRejected) {
+		// Recorded as an error so that it is notified, until the version changes
+		slog.Warn("Skipping EdgeCD release rolled back after a failed health check", "version", spec.Version)
+		state.AddError("update edge-cd binary", err)
+	} else if err != nil {
+		slog.Error("Failed to update EdgeCD binary", "version", spec.Version, "error", err)
+		state.AddError("update edge-cd binary", err)
+	}
+
+	if updated {
+		// The new binary is verified once restarted and rolled back if unhealthy
+		if err := r.updater.StartVerification(*spec); err != nil {
+			slog.Error("Failed to start EdgeCD update verification", "error", err)
+		}
+
+		slog.Info("EdgeCD binary updated, marking service for restart", "version", spec.Version)
+		state.AddServiceRestart("edge-cd")
+	}
+}
+
+// reconcileFiles reconciles all files defined in the configuration, the canary
+// files first if a canary is configured. It returns false if the files of the
+// config commit were not applied, because its canary failed.
+func (r *Reconciler) reconcileFiles(ctx context.Context, state *runtime.RuntimeState) bool {
+	if r.config.Spec.Canary == nil {
+		r.reconcileFileSpecs(state, r.config.Spec.Files)
+		return true
+	}
+
+	// The canary and the other files are reconciled separately: the manifest
+	// is written whole once both are
+	applied, checksums := r.reconcileCanary(ctx, state, r.config.Spec.Files)
+	if checksums != nil && r.config.FilesManifestPath != "" {
+		if err := files.WriteManifest(r.config.FilesManifestPath, checksums); err != nil {
+			slog.Warn("Failed to write file manifest", "path", r.config.FilesManifestPath, "error", err)
+		}
+	}
+	return applied
+}
+
+// reconcileFileSpecs reconciles the files of specs, recording the changes, the
+// services to restart and the reboot in state. It returns the result of the
+// file reconciler, nil if nothing was reconciled.
+func (r *Reconciler) reconcileFileSpecs(state *runtime.RuntimeState, specs []userconfig.FileSpec) *files.ReconcileResult {
+	specs = r.allowedFileSpecs(state, r.resolvedFileSpecs(state, specs))
+	if len(specs) == 0 {
+		return nil
+	}
+
 	slog.Info("Reconciling files")
 
 	result, err := r.fileRec.ReconcileFiles(
 		r.config.ConfigRepoPath,
 		r.config.Spec.Config.Path,
-		r.config.Spec.Files,
+		specs,
 	)
 
 	if err != nil {
 		slog.Error("Failed to reconcile files", "error", err)
-		return
+		state.AddError("reconcile files", err)
+		return nil
+	}
+
+	if len(result.Changes) > 0 {
+		counts := result.Counts()
+		slog.Info("Reconciled files",
+			"content", counts[files.ChangeContent],
+			"mode", counts[files.ChangeMode],
+			"owner", counts[files.ChangeOwner],
+			"removed", counts[files.ChangeRemoved],
+		)
+		state.AddFileChanges(counts)
+	}
+
+	// One error per read-only filesystem, not per file
+	for _, ro := range result.ReadOnly {
+		if len(ro.Drifted) > 0 {
+			state.AddError("reconcile files", fmt.Errorf("%d drifted files not updated: %s is mounted read-only", len(ro.Drifted), ro.Mount))
+		}
 	}
 
 	// Add services to restart
@@ -266,20 +551,147 @@ func (r *Reconciler) reconcileFiles(state *runtime.RuntimeState) {
 	if result.RequiresReboot {
 		state.RequireReboot = true
 	}
+	return result
+}
+
+// resolvedFileSpecs returns the specs with the placeholders of their paths
+// replaced by the host facts (see config.ResolveFileSpec). Each spec that cannot
+// be resolved is logged and recorded as an error in state, and skipped.
+func (r *Reconciler) resolvedFileSpecs(state *runtime.RuntimeState, specs []userconfig.FileSpec) []userconfig.FileSpec {
+	resolved := make([]userconfig.FileSpec, 0, len(specs))
+	for _, spec := range specs {
+		spec, err := config.ResolveFileSpec(spec, r.gatherFacts)
+		if err != nil {
+			slog.Error("Skipping file whose paths cannot be resolved", "destPath", spec.DestPath, "error", err)
+			state.AddError("reconcile files", err)
+			continue
+		}
+		resolved = append(resolved, spec)
+	}
+	return resolved
+}
+
+// gatherFacts returns the host facts of the iteration, gathered once if the
+// reconciler has a facts cache.
+func (r *Reconciler) gatherFacts() (*facts.Facts, error) {
+	if r.facts == nil {
+		return facts.Gather()
+	}
+	return r.facts.Get()
 }
 
-// reboot reboots the system (placeholder implementation).
-func (r *Reconciler) reboot() {
+// allowedFileSpecs returns the specs whose destPath is under the allowed path
+// prefixes of the spec. Each other spec is logged and recorded as an error in
+// state, and skipped.
+func (r *Reconciler) allowedFileSpecs(state *runtime.RuntimeState, specs []userconfig.FileSpec) []userconfig.FileSpec {
+	allowed := make([]userconfig.FileSpec, 0, len(specs))
+	for _, spec := range specs {
+		if !r.config.Spec.IsPathAllowed(spec.DestPath) {
+			err := fmt.Errorf("destPath %s is outside the allowed path prefixes %v", spec.DestPath, r.config.Spec.AllowedPathPrefixes)
+			slog.Error("Skipping file outside the allowed path prefixes", "destPath", spec.DestPath, "allowedPathPrefixes", r.config.Spec.AllowedPathPrefixes)
+			state.AddError("reconcile files", err)
+			continue
+		}
+		allowed = append(allowed, spec)
+	}
+	return allowed
+}
+
+// ReconcilePaths reconciles once the file specs managing paths, for a targeted
+// fix, leaving the other files untouched. A path matches the spec with this
+// destPath, or the directory spec holding it, which is reconciled whole. Only
+// the services of the reconciled specs are restarted.
+//
+// The file reconciler must not write the manifest itself (see
+// files.WithManifest): only the entries of the reconciled files are updated.
+// It returns an error, without reconciling anything, if a path is not managed
+// by the config, and an error listing the failed steps otherwise.
+func (r *Reconciler) ReconcilePaths(ctx context.Context, paths []string) (runtime.Result, error) {
+	specs, err := r.fileSpecsFor(paths)
+	if err != nil {
+		return runtime.Result{}, err
+	}
+
+	r.refreshFacts()
+	state := runtime.NewRuntimeState()
+
+	fileResult := r.reconcileFileSpecs(state, specs)
+	if fileResult != nil && r.config.FilesManifestPath != "" {
+		if err := files.UpdateManifest(r.config.FilesManifestPath, fileResult.Checksums); err != nil {
+			slog.Warn("Failed to update file manifest", "path", r.config.FilesManifestPath, "error", err)
+		}
+	}
+
+	if state.RequireReboot {
+		return r.reboot(ctx, state, false), nil
+	}
+
+	r.restartServices(ctx, state)
+
+	result := r.result(state, false)
+	r.writeSummary(result)
+	r.notify(ctx, result)
+	if len(result.Errors) > 0 {
+		return result, fmt.Errorf("failed to reconcile %s: %s", strings.Join(paths, ", "), strings.Join(result.Errors, "; "))
+	}
+	return result, nil
+}
+
+// fileSpecsFor returns the file specs managing paths, in the order of the
+// config. It returns an error if a path is not managed by any file spec.
+func (r *Reconciler) fileSpecsFor(paths []string) ([]userconfig.FileSpec, error) {
+	matched := make([]bool, len(r.config.Spec.Files))
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		found := false
+		for i, spec := range r.config.Spec.Files {
+			// A spec whose paths cannot be resolved fails once reconciled
+			if resolved, err := config.ResolveFileSpec(spec, r.gatherFacts); err == nil {
+				spec = resolved
+			}
+			dest := filepath.Clean(spec.DestPath)
+			if path == dest || (spec.Type == "directory" && strings.HasPrefix(path, dest+string(filepath.Separator))) {
+				matched[i], found = true, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no file spec manages %s", path)
+		}
+	}
+
+	var specs []userconfig.FileSpec
+	for i, spec := range r.config.Spec.Files {
+		if matched[i] {
+			specs = append(specs, spec)
+		}
+	}
+	return specs, nil
+}
+
+// reboot reports the result of the iteration whose state is given, as the
+// device goes down right after, then reboots it with the service manager. A
+// failed reboot is recorded in state and the result is reported again. It
+// returns the last reported result.
+func (r *Reconciler) reboot(ctx context.Context, state *runtime.RuntimeState, configChanged bool) runtime.Result {
+	result := r.result(state, configChanged)
+	r.writeSummary(result)
+	r.notify(ctx, result)
+
 	slog.Info("Rebooting now")
-	// In a real implementation, this would execute: exec.Command("reboot").Run()
-	// For testing, we just log
-	fmt.Println("REBOOT TRIGGERED") // Special marker for tests
+	if err := r.svcMgr.Reboot(); err != nil {
+		slog.Error("Failed to reboot", "error", err)
+		state.AddError("reboot", err)
+		result = r.result(state, configChanged)
+		r.writeSummary(result)
+		r.notify(ctx, result)
+	}
+	return result
 }
 
 // restartServices restarts all services that were marked for restart.
 // Services are enabled before restarting to ensure they start on boot.
-func (r *Reconciler) restartServices(state *runtime.RuntimeState) {
-	services := state.GetServicesToRestart()
+func (r *Reconciler) restartServices(ctx context.Context, state *runtime.RuntimeState) {
+	services := restartOrder(state.GetServicesToRestart(), r.config.Spec.ServiceManager.RestartOrder)
 	if len(services) == 0 {
 		return
 	}
@@ -290,35 +702,197 @@ func (r *Reconciler) restartServices(state *runtime.RuntimeState) {
 		// Enable service first to ensure it starts on boot
 		if err := r.svcMgr.Enable(svc); err != nil {
 			slog.Error("Failed to enable service", "service", svc, "error", err)
+			state.AddError("enable "+svc, err)
 		}
 
 		// Then restart the service
 		if err := r.svcMgr.Restart(svc); err != nil {
 			slog.Error("Failed to restart service", "service", svc, "error", err)
+			state.AddError("restart "+svc, err)
+			continue
+		}
+
+		if err := r.verifyActive(ctx, svc); err != nil {
+			slog.Error("Restarted service is not active", "service", svc, "error", err)
+			state.AddError("verify "+svc, err)
+		}
+	}
+}
+
+// restartOrder returns the sorted services to restart with the ones of order
+// moved first, in the order they are listed.
+func restartOrder(services, order []string) []string {
+	pending := make(map[string]bool, len(services))
+	for _, svc := range services {
+		pending[svc] = true
+	}
+
+	ordered := make([]string, 0, len(services))
+	for _, svc := range order {
+		if pending[svc] {
+			ordered = append(ordered, svc)
+			delete(pending, svc)
+		}
+	}
+	for _, svc := range services {
+		if pending[svc] {
+			ordered = append(ordered, svc)
+		}
+	}
+	return ordered
+}
+
+// verifyActive waits for the restarted service svc to become active, for up
+// to serviceManager.verifyActiveSeconds or until ctx is done. Nothing is
+// verified if unset.
+func (r *Reconciler) verifyActive(ctx context.Context, svc string) error {
+	timeout := time.Duration(r.config.Spec.ServiceManager.VerifyActiveSeconds) * time.Second
+	if timeout <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		active, err := r.svcMgr.IsActive(svc)
+		if err == nil && active {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if err != nil {
+				return fmt.Errorf("failed to check service %s: %w", svc, err)
+			}
+			return fmt.Errorf("service %s is not active %s after its restart", svc, timeout)
+		}
+
+		timer := time.NewTimer(min(verifyActiveInterval, remaining))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("stopped waiting for service %s to become active: %w", svc, ctx.Err())
+		case <-timer.C:
 		}
 	}
 }
 
 // commitLastChange writes the current config commit to file.
-func (r *Reconciler) commitLastChange() {
+// It returns the error preventing the commit from being recorded, which is also logged.
+func (r *Reconciler) commitLastChange() error {
 	// Skip for file:// URLs
 	if strings.HasPrefix(r.config.Spec.Config.Repo.URL, "file://") {
-		return
+		return nil
 	}
 
 	currentCommit, err := r.gitMgr.GetCurrentCommit(r.config.ConfigRepoPath)
 	if err != nil {
 		slog.Error("Failed to get current commit", "error", err)
+		return err
+	}
+
+	// The file is only written when the commit changes: its mtime is when the
+	// applied commit last advanced (see commitAge)
+	lastCommitData, _ := os.ReadFile(r.config.ConfigCommitPath)
+	if strings.TrimSpace(string(lastCommitData)) != currentCommit {
+		os.MkdirAll(filepath.Dir(r.config.ConfigCommitPath), 0755)
+		if err := os.WriteFile(r.config.ConfigCommitPath, []byte(currentCommit), 0644); err != nil {
+			slog.Error("Failed to write commit file", "error", err)
+			return err
+		}
+	}
+
+	slog.Info("Synced commit successfully", "commit", currentCommit)
+	return nil
+}
+
+// commitAge returns the time since the applied config commit last changed, or
+// false if no commit was applied yet.
+func (r *Reconciler) commitAge(now time.Time) (time.Duration, bool) {
+	info, err := os.Stat(r.config.ConfigCommitPath)
+	if err != nil {
+		return 0, false
+	}
+	return max(now.Sub(info.ModTime()), 0), true
+}
+
+// result builds the Result of the iteration whose state is given.
+func (r *Reconciler) result(state *runtime.RuntimeState, configChanged bool) runtime.Result {
+	hostname, _ := os.Hostname()
+	result := runtime.Result{
+		Hostname:          hostname,
+		Time:              time.Now().UTC(),
+		ConfigChanged:     configChanged,
+		ServicesRestarted: state.GetServicesToRestart(),
+		Reboot:            state.RequireReboot,
+		FileChanges:       state.FileChanges,
+		Errors:            state.Errors,
+	}
+
+	if !strings.HasPrefix(r.config.Spec.Config.Repo.URL, "file://") {
+		if commit, err := r.gitMgr.GetCurrentCommit(r.config.ConfigRepoPath); err == nil {
+			result.Commit = commit
+		}
+		if age, ok := r.commitAge(result.Time); ok {
+			seconds := int64(age.Seconds())
+			result.CommitAgeSeconds = &seconds
+			slog.Info("Applied config commit age", "commit", result.Commit, "commitAgeSeconds", seconds)
+		}
+	}
+	return result
+}
+
+// writeSummary writes the result of the iteration to the summary file, if
+// configured. Failures are logged and ignored.
+func (r *Reconciler) writeSummary(result runtime.Result) {
+	if r.config.SummaryPath == "" {
 		return
 	}
+	if err := runtime.WriteSummary(r.config.SummaryPath, result); err != nil {
+		slog.Warn("Failed to write reconcile summary", "path", r.config.SummaryPath, "error", err)
+	}
+}
 
-	os.MkdirAll(filepath.Dir(r.config.ConfigCommitPath), 0755)
-	if err := os.WriteFile(r.config.ConfigCommitPath, []byte(currentCommit), 0644); err != nil {
-		slog.Error("Failed to write commit file", "error", err)
+// notify sends the result of the iteration to the notifier when the iteration
+// changed the device or failed. Notification failures are logged and ignored.
+func (r *Reconciler) notify(ctx context.Context, result runtime.Result) {
+	if r.notifier == nil {
 		return
 	}
 
-	slog.Info("Synced commit successfully", "commit", currentCommit)
+	if !result.Changed() {
+		return
+	}
+
+	if err := r.notifier.Notify(ctx, result); err != nil {
+		slog.Warn("Failed to send reconcile notification", "error", err)
+	}
+}
+
+// beat sends a heartbeat after a successful iteration. The heartbeat is sent in
+// the background so that a slow or unreachable endpoint never delays the
+// reconciliation, and is skipped while the previous one is still being sent.
+func (r *Reconciler) beat(ctx context.Context, result runtime.Result) {
+	if r.heartbeat == nil || len(result.Errors) > 0 {
+		return
+	}
+
+	if !r.beating.CompareAndSwap(false, true) {
+		slog.Warn("Previous heartbeat still being sent, skipping heartbeat")
+		return
+	}
+
+	hb := notify.Heartbeat{
+		Hostname:         result.Hostname,
+		Time:             result.Time,
+		Commit:           result.Commit,
+		CommitAgeSeconds: result.CommitAgeSeconds,
+	}
+	go func() {
+		defer r.beating.Store(false)
+		if err := r.heartbeat.Beat(ctx, hb); err != nil {
+			slog.Warn("Failed to send heartbeat", "error", err)
+		}
+	}()
 }
 
 // sleep pauses for the configured polling interval or until context is cancelled.
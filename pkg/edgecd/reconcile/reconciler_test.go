@@ -2,16 +2,27 @@ package reconcile
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/config"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/facts"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/files"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/git"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/notify"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/pkgmgr"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/runtime"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/selfupdate"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/svcmgr"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
 )
@@ -23,7 +34,7 @@ func TestNewReconciler(t *testing.T) {
 	svcMgr := &svcmgr.MockServiceManager{}
 	fileRec := &files.MockFileReconciler{}
 
-	r := NewReconciler(cfg, gitMgr, pkgMgr, svcMgr, fileRec)
+	r := NewReconciler(cfg, gitMgr, pkgMgr, svcMgr, fileRec, nil, nil, nil, nil)
 
 	if r == nil {
 		t.Fatal("NewReconciler returned nil")
@@ -83,7 +94,7 @@ func TestSyncEdgeCDRepo_CloneOnFirstRun(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, gitMgr, nil, nil, nil)
+	r := NewReconciler(cfg, gitMgr, nil, nil, nil, nil, nil, nil, nil)
 	r.syncEdgeCDRepo()
 
 	// Verify CloneRepo was called
@@ -123,7 +134,7 @@ func TestSyncEdgeCDRepo_SyncOnSubsequentRun(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, gitMgr, nil, nil, nil)
+	r := NewReconciler(cfg, gitMgr, nil, nil, nil, nil, nil, nil, nil)
 	r.syncEdgeCDRepo()
 
 	if !syncCalled {
@@ -131,6 +142,114 @@ func TestSyncEdgeCDRepo_SyncOnSubsequentRun(t *testing.T) {
 	}
 }
 
+func TestSyncRepos_CheckoutPaths(t *testing.T) {
+	full := false
+
+	tests := []struct {
+		name       string
+		edgeCDRepo userconfig.RepoConfig
+		configRepo userconfig.ConfigRepo
+		runtime    string
+		wantEdgeCD []string
+		wantConfig []string
+	}{
+		{
+			name:       "sparse by default",
+			wantEdgeCD: []string{"cmd/edge-cd"},
+			wantConfig: []string{"devices/test"},
+		},
+		{
+			name:       "full checkout",
+			edgeCDRepo: userconfig.RepoConfig{SparseCheckout: &full},
+			configRepo: userconfig.ConfigRepo{SparseCheckout: &full},
+			wantEdgeCD: nil,
+			wantConfig: nil,
+		},
+		{
+			name:       "explicit paths",
+			edgeCDRepo: userconfig.RepoConfig{SparseCheckoutPaths: []string{"cmd/edge-cd-go", "pkg"}},
+			configRepo: userconfig.ConfigRepo{SparseCheckoutPaths: []string{"devices/test", "common"}},
+			wantEdgeCD: []string{"cmd/edge-cd-go", "pkg"},
+			wantConfig: []string{"devices/test", "common"},
+		},
+		{
+			name:       "go runtime",
+			runtime:    userconfig.RuntimeGo,
+			wantEdgeCD: []string{"cmd/edge-cd", "cmd/edge-cd-go", "pkg"},
+			wantConfig: []string{"devices/test"},
+		},
+	}
+
+	for _, tt := range tests {
+		// Both the first clone and the following syncs use the configured paths
+		for _, existing := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/existing=%v", tt.name, existing), func(t *testing.T) {
+				tempDir := t.TempDir()
+				edgeCDPath := filepath.Join(tempDir, "edge-cd")
+				configPath := filepath.Join(tempDir, "config")
+				if existing {
+					os.MkdirAll(edgeCDPath, 0755)
+					os.MkdirAll(configPath, 0755)
+				}
+
+				edgeCDRepo := tt.edgeCDRepo
+				edgeCDRepo.URL = "https://github.com/test/edge-cd.git"
+				edgeCDRepo.DestinationPath = edgeCDPath
+				configRepo := tt.configRepo
+				configRepo.URL = "https://github.com/test/config.git"
+				configRepo.DestPath = configPath
+
+				cfg := &config.Config{
+					Spec: &userconfig.Spec{
+						EdgeCD: userconfig.EdgeCDSection{Repo: edgeCDRepo, Runtime: tt.runtime},
+						Config: userconfig.ConfigSection{Path: "devices/test", Repo: configRepo},
+					},
+					EdgeCDRepoPath: edgeCDPath,
+					ConfigRepoPath: configPath,
+				}
+
+				got := map[string][]string{}
+				record := func(path string, paths []string) error {
+					got[filepath.Base(path)] = paths
+					return nil
+				}
+				gitMgr := &git.MockRepoManager{
+					CloneRepoFunc: func(url, branch, destPath string, sparseCheckoutPaths []string) error {
+						if existing {
+							t.Errorf("CloneRepo called for existing repo %s", destPath)
+						}
+						return record(destPath, sparseCheckoutPaths)
+					},
+					SyncRepoFunc: func(repoPath, branch string, sparseCheckoutPaths []string) error {
+						if !existing {
+							t.Errorf("SyncRepo called for missing repo %s", repoPath)
+						}
+						return record(repoPath, sparseCheckoutPaths)
+					},
+				}
+
+				r := NewReconciler(cfg, gitMgr, nil, nil, nil, nil, nil, nil, nil)
+				if err := r.syncEdgeCDRepo(); err != nil {
+					t.Fatalf("syncEdgeCDRepo() error = %v", err)
+				}
+				if err := r.syncConfigRepo(); err != nil {
+					t.Fatalf("syncConfigRepo() error = %v", err)
+				}
+
+				if len(got) != 2 {
+					t.Fatalf("cloned or synced repos = %v, want edge-cd and config", got)
+				}
+				if !reflect.DeepEqual(got["edge-cd"], tt.wantEdgeCD) {
+					t.Errorf("edge-cd sparseCheckoutPaths = %v, want %v", got["edge-cd"], tt.wantEdgeCD)
+				}
+				if !reflect.DeepEqual(got["config"], tt.wantConfig) {
+					t.Errorf("config sparseCheckoutPaths = %v, want %v", got["config"], tt.wantConfig)
+				}
+			})
+		}
+	}
+}
+
 func TestSyncConfigRepo_SkipsFileURL(t *testing.T) {
 	cfg := &config.Config{
 		Spec: &userconfig.Spec{
@@ -154,7 +273,7 @@ func TestSyncConfigRepo_SkipsFileURL(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, gitMgr, nil, nil, nil)
+	r := NewReconciler(cfg, gitMgr, nil, nil, nil, nil, nil, nil, nil)
 	r.syncConfigRepo()
 
 	// Should NOT call CloneRepo for file:// URLs
@@ -188,7 +307,7 @@ func TestIsConfigChanged_DetectsChange(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, gitMgr, nil, nil, nil)
+	r := NewReconciler(cfg, gitMgr, nil, nil, nil, nil, nil, nil, nil)
 	changed := r.isConfigChanged()
 
 	if !changed {
@@ -221,7 +340,7 @@ func TestIsConfigChanged_NoChange(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, gitMgr, nil, nil, nil)
+	r := NewReconciler(cfg, gitMgr, nil, nil, nil, nil, nil, nil, nil)
 	changed := r.isConfigChanged()
 
 	if changed {
@@ -240,7 +359,7 @@ func TestIsConfigChanged_SkipsFileURL(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, nil, nil, nil, nil)
+	r := NewReconciler(cfg, nil, nil, nil, nil, nil, nil, nil, nil)
 	changed := r.isConfigChanged()
 
 	if changed {
@@ -248,6 +367,144 @@ func TestIsConfigChanged_SkipsFileURL(t *testing.T) {
 	}
 }
 
+func TestReconcile_PackagesOnlyOnPackageConfigChange(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastCommit  string
+		changed     []string
+		diffErr     error
+		wantInstall bool
+	}{
+		{name: "spec file changed", lastCommit: "abc123", changed: []string{"README.md", "devices/test/spec.yaml"}, wantInstall: true},
+		{name: "package group changed", lastCommit: "abc123", changed: []string{"groups/base.yaml"}, wantInstall: true},
+		{name: "reconcileOn file changed", lastCommit: "abc123", changed: []string{"devices/test/packages/extra.list"}, wantInstall: true},
+		{name: "only files changed", lastCommit: "abc123", changed: []string{"devices/test/etc/motd", "devices/other/spec.yaml"}, wantInstall: false},
+		{name: "first sync", lastCommit: "", wantInstall: true},
+		{name: "diff failure", lastCommit: "abc123", diffErr: errors.New("bad object"), wantInstall: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			configCommitPath := filepath.Join(tempDir, "config-commit.txt")
+			if tt.lastCommit != "" {
+				os.WriteFile(configCommitPath, []byte(tt.lastCommit), 0644)
+			}
+			configRepoPath := filepath.Join(tempDir, "config")
+			os.MkdirAll(filepath.Join(configRepoPath, "groups"), 0755)
+			os.WriteFile(filepath.Join(configRepoPath, "groups", "base.yaml"), []byte("requiredPackages: [git]\n"), 0644)
+
+			cfg := &config.Config{
+				Spec: &userconfig.Spec{
+					EdgeCD: userconfig.EdgeCDSection{
+						Repo: userconfig.RepoConfig{URL: "file:///opt/edge-cd"},
+					},
+					Config: userconfig.ConfigSection{
+						Path: "devices/test",
+						Repo: userconfig.ConfigRepo{
+							URL:    "https://github.com/test/config.git",
+							Branch: "main",
+						},
+					},
+					PackageManager: userconfig.PackageManagerSection{
+						RequiredPackages: []string{"curl"},
+						PackageGroups:    []string{"../../groups/base.yaml"},
+						ReconcileOn:      []string{"packages/*.list"},
+					},
+				},
+				EdgeCDRepoPath:   tempDir,
+				EdgeCDCommitPath: filepath.Join(tempDir, "edge-cd-commit.txt"),
+				ConfigRepoPath:   configRepoPath,
+				ConfigCommitPath: configCommitPath,
+				ConfigSpecPath:   filepath.Join(configRepoPath, "devices", "test", "spec.yaml"),
+			}
+
+			gitMgr := &git.MockRepoManager{
+				GetCurrentCommitFunc: func(repoPath string) (string, error) {
+					return "def456", nil
+				},
+				GetCommitDiffFunc: func(repoPath, oldCommit, newCommit string) ([]string, error) {
+					if oldCommit != "abc123" || newCommit != "def456" {
+						t.Errorf("GetCommitDiff(%q, %q), want (abc123, def456)", oldCommit, newCommit)
+					}
+					return tt.changed, tt.diffErr
+				},
+			}
+			installed := false
+			pkgMgr := &pkgmgr.MockPackageManager{
+				InstallFunc: func(packages []string) error {
+					installed = true
+					return nil
+				},
+			}
+
+			r := NewReconciler(cfg, gitMgr, pkgMgr, &svcmgr.MockServiceManager{}, &files.MockFileReconciler{}, nil, nil, nil, nil)
+			r.reconcile(context.Background())
+
+			if installed != tt.wantInstall {
+				t.Errorf("packages installed = %v, want %v", installed, tt.wantInstall)
+			}
+		})
+	}
+}
+
+func TestResult_CommitAge(t *testing.T) {
+	commitPath := filepath.Join(t.TempDir(), "last-commit.txt")
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			Config: userconfig.ConfigSection{
+				Repo: userconfig.ConfigRepo{URL: "https://github.com/test/config.git"},
+			},
+		},
+		ConfigRepoPath:   "/opt/config",
+		ConfigCommitPath: commitPath,
+	}
+	commit := "abc123"
+	gitMgr := &git.MockRepoManager{
+		GetCurrentCommitFunc: func(repoPath string) (string, error) { return commit, nil },
+	}
+	r := NewReconciler(cfg, gitMgr, nil, nil, nil, nil, nil, nil, nil)
+
+	// reconcile applies the current commit and returns the age of the applied commit
+	reconcile := func() int64 {
+		t.Helper()
+		if err := r.commitLastChange(); err != nil {
+			t.Fatalf("commitLastChange() error = %v", err)
+		}
+		result := r.result(runtime.NewRuntimeState(), false)
+		if result.CommitAgeSeconds == nil {
+			t.Fatal("CommitAgeSeconds = nil, want the age of the applied commit")
+		}
+		return *result.CommitAgeSeconds
+	}
+
+	if result := r.result(runtime.NewRuntimeState(), false); result.CommitAgeSeconds != nil {
+		t.Errorf("CommitAgeSeconds = %d before any commit was applied, want nil", *result.CommitAgeSeconds)
+	}
+
+	if age := reconcile(); age > 1 {
+		t.Errorf("CommitAgeSeconds = %d after the first commit was applied, want 0", age)
+	}
+
+	// The commit was applied an hour ago: the age grows across no-change reconciles
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(commitPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if age := reconcile(); age < 3600 {
+		t.Errorf("CommitAgeSeconds = %d after a no-change reconcile, want at least 3600", age)
+	}
+	if age := reconcile(); age < 3600 {
+		t.Errorf("CommitAgeSeconds = %d after a second no-change reconcile, want at least 3600", age)
+	}
+
+	// A new commit resets the age
+	commit = "def456"
+	if age := reconcile(); age > 1 {
+		t.Errorf("CommitAgeSeconds = %d after a new commit was applied, want 0", age)
+	}
+}
+
 func TestReconcilePackages(t *testing.T) {
 	cfg := &config.Config{
 		Spec: &userconfig.Spec{
@@ -268,7 +525,7 @@ func TestReconcilePackages(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, nil, pkgMgr, nil, nil)
+	r := NewReconciler(cfg, nil, pkgMgr, nil, nil, nil, nil, nil, nil)
 	r.reconcilePackages()
 
 	if !installCalled {
@@ -280,6 +537,46 @@ func TestReconcilePackages(t *testing.T) {
 	}
 }
 
+func TestReconcilePackages_PackageGroups(t *testing.T) {
+	repoDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(repoDir, "config", "groups"), 0755); err != nil {
+		t.Fatalf("Failed to create groups directory: %v", err)
+	}
+	group := "requiredPackages: [git, curl, nano]\n"
+	if err := os.WriteFile(filepath.Join(repoDir, "config", "groups", "base.yaml"), []byte(group), 0644); err != nil {
+		t.Fatalf("Failed to write package group: %v", err)
+	}
+
+	cfg := &config.Config{
+		ConfigRepoPath: repoDir,
+		Spec: &userconfig.Spec{
+			Config: userconfig.ConfigSection{Path: "config"},
+			PackageManager: userconfig.PackageManagerSection{
+				PackageGroups:    []string{"groups/base.yaml"},
+				RequiredPackages: []string{"htop"},
+				ExcludedPackages: []string{"nano"},
+			},
+		},
+	}
+
+	var installedPkgs []string
+	pkgMgr := &pkgmgr.MockPackageManager{
+		InstallFunc: func(packages []string) error {
+			installedPkgs = packages
+			return nil
+		},
+	}
+
+	r := NewReconciler(cfg, nil, pkgMgr, nil, nil, nil, nil, nil, nil)
+	if err := r.reconcilePackages(); err != nil {
+		t.Fatalf("reconcilePackages() error = %v", err)
+	}
+
+	if want := []string{"git", "curl", "htop"}; !reflect.DeepEqual(installedPkgs, want) {
+		t.Errorf("Installed %v, want %v", installedPkgs, want)
+	}
+}
+
 func TestReconcileAutoUpgrade_WhenEnabled(t *testing.T) {
 	cfg := &config.Config{
 		Spec: &userconfig.Spec{
@@ -298,7 +595,7 @@ func TestReconcileAutoUpgrade_WhenEnabled(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, nil, pkgMgr, nil, nil)
+	r := NewReconciler(cfg, nil, pkgMgr, nil, nil, nil, nil, nil, nil)
 	r.reconcileAutoUpgrade()
 
 	if !upgradeCalled {
@@ -324,7 +621,7 @@ func TestReconcileAutoUpgrade_WhenDisabled(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, nil, pkgMgr, nil, nil)
+	r := NewReconciler(cfg, nil, pkgMgr, nil, nil, nil, nil, nil, nil)
 	r.reconcileAutoUpgrade()
 
 	if upgradeCalled {
@@ -370,7 +667,7 @@ func TestReconcileEdgeCD_MarksServiceForRestart(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, gitMgr, nil, svcMgr, nil)
+	r := NewReconciler(cfg, gitMgr, nil, svcMgr, nil, nil, nil, nil, nil)
 	state := &runtime.RuntimeState{
 		ServicesToRestart: make(map[string]bool),
 	}
@@ -389,6 +686,90 @@ func TestReconcileEdgeCD_MarksServiceForRestart(t *testing.T) {
 	}
 }
 
+func TestReconcileSelfUpdate(t *testing.T) {
+	tests := []struct {
+		name        string
+		updated     bool
+		err         error
+		wantRestart bool
+	}{
+		{name: "version changed", updated: true, wantRestart: true},
+		{name: "version unchanged", updated: false, wantRestart: false},
+		{name: "download failed", updated: false, err: errors.New("404"), wantRestart: false},
+		{name: "swapped but version not recorded", updated: true, err: errors.New("read-only"), wantRestart: true},
+		{name: "version rolled back", updated: false, err: fmt.Errorf("%w: v1.2.0", selfupdate.ErrVersionRejected), wantRestart: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Spec: &userconfig.Spec{
+					EdgeCD: userconfig.EdgeCDSection{
+						SelfUpdate: &userconfig.SelfUpdateSection{
+							Version:     "v1.2.0",
+							URLTemplate: "https://example.com/{{ .Version }}/edge-cd-go",
+						},
+					},
+				},
+			}
+
+			var gotVersion string
+			updater := &selfupdate.MockUpdater{
+				ReconcileFunc: func(spec userconfig.SelfUpdateSection) (bool, error) {
+					gotVersion = spec.Version
+					return tt.updated, tt.err
+				},
+			}
+
+			r := NewReconciler(cfg, nil, nil, nil, nil, updater, nil, nil, nil)
+			state := runtime.NewRuntimeState()
+			r.reconcileSelfUpdate(state)
+
+			if gotVersion != "v1.2.0" {
+				t.Errorf("Reconcile called with version %q, want v1.2.0", gotVersion)
+			}
+			// The errors are notified
+			if (len(state.Errors) > 0) != (tt.err != nil) {
+				t.Errorf("Errors = %v, want an error: %v", state.Errors, tt.err != nil)
+			}
+
+			services := state.GetServicesToRestart()
+			restart := len(services) == 1 && services[0] == "edge-cd"
+			if restart != tt.wantRestart {
+				t.Errorf("Services to restart = %v, want edge-cd restart: %v", services, tt.wantRestart)
+			}
+
+			// The update is verified after the restart
+			wantVerifications := 0
+			if tt.wantRestart {
+				wantVerifications = 1
+			}
+			if updater.StartVerificationCalls != wantVerifications {
+				t.Errorf("StartVerification calls = %d, want %d", updater.StartVerificationCalls, wantVerifications)
+			}
+		})
+	}
+}
+
+func TestReconcileSelfUpdate_NotConfigured(t *testing.T) {
+	cfg := &config.Config{Spec: &userconfig.Spec{}}
+
+	updater := &selfupdate.MockUpdater{
+		ReconcileFunc: func(spec userconfig.SelfUpdateSection) (bool, error) {
+			t.Error("Reconcile should not be called without a selfUpdate section")
+			return true, nil
+		},
+	}
+
+	r := NewReconciler(cfg, nil, nil, nil, nil, updater, nil, nil, nil)
+	state := runtime.NewRuntimeState()
+	r.reconcileSelfUpdate(state)
+
+	if services := state.GetServicesToRestart(); len(services) != 0 {
+		t.Errorf("Services to restart = %v, want none", services)
+	}
+}
+
 func TestReconcileFiles(t *testing.T) {
 	cfg := &config.Config{
 		Spec: &userconfig.Spec{
@@ -413,12 +794,12 @@ func TestReconcileFiles(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, nil, nil, nil, fileRec)
+	r := NewReconciler(cfg, nil, nil, nil, fileRec, nil, nil, nil, nil)
 	state := &runtime.RuntimeState{
 		ServicesToRestart: make(map[string]bool),
 	}
 
-	r.reconcileFiles(state)
+	r.reconcileFiles(context.Background(), state)
 
 	if !fileRecCalled {
 		t.Error("FileReconciler.ReconcileFiles was not called")
@@ -436,8 +817,271 @@ func TestReconcileFiles(t *testing.T) {
 	}
 }
 
+func TestReconcileFiles_CountsChangesPerAction(t *testing.T) {
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			Files: []userconfig.FileSpec{
+				{Type: "content", DestPath: "/etc/test", Content: "test"},
+			},
+		},
+	}
+
+	fileRec := &files.MockFileReconciler{
+		ReconcileFilesFunc: func(configRepoPath, configPath string, fileSpecs []userconfig.FileSpec) (*files.ReconcileResult, error) {
+			return &files.ReconcileResult{
+				Changes: []files.FileChange{
+					{Path: "/etc/a", Action: files.ChangeContent},
+					{Path: "/etc/b", Action: files.ChangeMode},
+					{Path: "/etc/c", Action: files.ChangeMode},
+				},
+			}, nil
+		},
+	}
+
+	r := NewReconciler(cfg, nil, nil, nil, fileRec, nil, nil, nil, nil)
+	state := runtime.NewRuntimeState()
+
+	r.reconcileFiles(context.Background(), state)
+
+	expected := map[string]int{files.ChangeContent: 1, files.ChangeMode: 2}
+	if !reflect.DeepEqual(state.FileChanges, expected) {
+		t.Errorf("FileChanges = %v, want %v", state.FileChanges, expected)
+	}
+}
+
+func TestReconcileFiles_ReadOnlyMount(t *testing.T) {
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			Files: []userconfig.FileSpec{
+				{Type: "content", DestPath: "/etc/test", Content: "test"},
+			},
+		},
+	}
+
+	fileRec := &files.MockFileReconciler{
+		ReconcileFilesFunc: func(configRepoPath, configPath string, fileSpecs []userconfig.FileSpec) (*files.ReconcileResult, error) {
+			return &files.ReconcileResult{
+				ReadOnly: []files.ReadOnlyMount{
+					{Mount: "/etc", Drifted: []string{"/etc/a", "/etc/b"}},
+					{Mount: "/usr"},
+				},
+			}, nil
+		},
+	}
+
+	r := NewReconciler(cfg, nil, nil, nil, fileRec, nil, nil, nil, nil)
+	state := runtime.NewRuntimeState()
+
+	r.reconcileFiles(context.Background(), state)
+
+	expected := []string{"reconcile files: 2 drifted files not updated: /etc is mounted read-only"}
+	if !reflect.DeepEqual(state.Errors, expected) {
+		t.Errorf("Errors = %v, want %v", state.Errors, expected)
+	}
+}
+
+func TestReconcilePaths(t *testing.T) {
+	fsys := files.NewMemFS()
+	fsys.WriteFile("/opt/config/devices/test/app/a.conf", []byte("a"), 0644)
+	fsys.WriteFile("/etc/motd", []byte("old"), 0644)
+	fsys.WriteFile("/etc/issue", []byte("old"), 0644)
+
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	if err := files.WriteManifest(manifestPath, files.Manifest{"/etc/issue": "unrelated"}); err != nil {
+		t.Fatalf("WriteManifest() error = %v", err)
+	}
+
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			Config: userconfig.ConfigSection{
+				Repo: userconfig.ConfigRepo{URL: "file:///opt/config"},
+				Path: "devices/test",
+			},
+			Files: []userconfig.FileSpec{
+				{
+					Type: "content", DestPath: "/etc/motd", Content: "new", FileMod: "644",
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"motd"}},
+				},
+				{
+					Type: "content", DestPath: "/etc/issue", Content: "new", FileMod: "644",
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"getty"}},
+				},
+				{
+					Type: "directory", SrcPath: "app", DestPath: "/etc/app", FileMod: "644",
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"app"}},
+				},
+			},
+		},
+		ConfigRepoPath:    "/opt/config",
+		FilesManifestPath: manifestPath,
+	}
+
+	var restarted []string
+	svcMgr := &svcmgr.MockServiceManager{
+		RestartFunc: func(serviceName string) error {
+			restarted = append(restarted, serviceName)
+			return nil
+		},
+	}
+	fileRec := files.NewFileReconciler(files.WithFS(fsys))
+	r := NewReconciler(cfg, nil, nil, svcMgr, fileRec, nil, nil, nil, nil)
+
+	// A file of a directory spec selects the whole directory
+	result, err := r.ReconcilePaths(context.Background(), []string{"/etc/motd", "/etc/app/a.conf"})
+	if err != nil {
+		t.Fatalf("ReconcilePaths() error = %v", err)
+	}
+
+	for path, want := range map[string]string{"/etc/motd": "new", "/etc/app/a.conf": "a", "/etc/issue": "old"} {
+		if got, err := fsys.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("content of %s = %q (error %v), want %q", path, got, err, want)
+		}
+	}
+	if want := []string{"app", "motd"}; !reflect.DeepEqual(restarted, want) {
+		t.Errorf("restarted services = %v, want %v", restarted, want)
+	}
+	if want := []string{"app", "motd"}; !reflect.DeepEqual(result.ServicesRestarted, want) {
+		t.Errorf("ServicesRestarted = %v, want %v", result.ServicesRestarted, want)
+	}
+
+	manifest, err := files.ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if manifest["/etc/issue"] != "unrelated" {
+		t.Errorf("manifest entry of the unrelated file = %q, want it kept", manifest["/etc/issue"])
+	}
+	if _, ok := manifest["/etc/motd"]; !ok {
+		t.Errorf("manifest has no entry for the reconciled /etc/motd: %v", manifest)
+	}
+}
+
+func TestReconcilePaths_UnmanagedPath(t *testing.T) {
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			Files: []userconfig.FileSpec{
+				{Type: "content", DestPath: "/etc/motd", Content: "new"},
+				{Type: "content", DestPath: "/etc/app", Content: "new"},
+			},
+		},
+	}
+
+	fileRec := &files.MockFileReconciler{
+		ReconcileFilesFunc: func(configRepoPath, configPath string, fileSpecs []userconfig.FileSpec) (*files.ReconcileResult, error) {
+			t.Errorf("ReconcileFiles() called with %v, want nothing reconciled", fileSpecs)
+			return &files.ReconcileResult{}, nil
+		},
+	}
+	r := NewReconciler(cfg, nil, nil, nil, fileRec, nil, nil, nil, nil)
+
+	// Only a directory spec holds the files under its destPath
+	if _, err := r.ReconcilePaths(context.Background(), []string{"/etc/motd", "/etc/app/a.conf"}); err == nil {
+		t.Error("ReconcilePaths() error = nil, want an error for the unmanaged path")
+	}
+}
+
+func TestReconcileFiles_AllowedPathPrefixes(t *testing.T) {
+	fsys := files.NewMemFS()
+	fsys.WriteFile("/etc/passwd", []byte("root:x:0:0"), 0644)
+
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			AllowedPathPrefixes: []string{"/etc/edge-cd-managed", "/opt/app"},
+			Files: []userconfig.FileSpec{
+				{Type: "content", DestPath: "/etc/edge-cd-managed/motd", Content: "welcome", FileMod: "644"},
+				{Type: "content", DestPath: "/opt/app/app.conf", Content: "port=80", FileMod: "644"},
+				{Type: "content", DestPath: "/etc/passwd", Content: "pwned", FileMod: "644",
+					SyncBehavior: &userconfig.SyncBehavior{RestartServices: []string{"sshd"}}},
+				{Type: "content", DestPath: "/opt/app/../../etc/shadow", Content: "pwned", FileMod: "644"},
+				{Type: "content", DestPath: "/opt/application/app.conf", Content: "port=80", FileMod: "644"},
+			},
+		},
+	}
+
+	r := NewReconciler(cfg, nil, nil, nil, files.NewFileReconciler(files.WithFS(fsys)), nil, nil, nil, nil)
+	state := runtime.NewRuntimeState()
+
+	r.reconcileFiles(context.Background(), state)
+
+	for path, want := range map[string]string{
+		"/etc/edge-cd-managed/motd": "welcome",
+		"/opt/app/app.conf":         "port=80",
+		"/etc/passwd":               "root:x:0:0",
+	} {
+		if got, err := fsys.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("content of %s = %q (error %v), want %q", path, got, err, want)
+		}
+	}
+	for _, path := range []string{"/etc/shadow", "/opt/application/app.conf"} {
+		if _, err := fsys.Stat(path); err == nil {
+			t.Errorf("%s written outside the allowed path prefixes", path)
+		}
+	}
+
+	expected := []string{
+		"reconcile files: destPath /etc/passwd is outside the allowed path prefixes [/etc/edge-cd-managed /opt/app]",
+		"reconcile files: destPath /opt/app/../../etc/shadow is outside the allowed path prefixes [/etc/edge-cd-managed /opt/app]",
+		"reconcile files: destPath /opt/application/app.conf is outside the allowed path prefixes [/etc/edge-cd-managed /opt/app]",
+	}
+	if !reflect.DeepEqual(state.Errors, expected) {
+		t.Errorf("Errors = %v, want %v", state.Errors, expected)
+	}
+	if services := state.GetServicesToRestart(); len(services) != 0 {
+		t.Errorf("services to restart = %v, want none for the skipped files", services)
+	}
+}
+
+func TestReconcileFiles_PathPlaceholders(t *testing.T) {
+	cache := facts.NewCache()
+	hostFacts, err := cache.Get()
+	if err != nil {
+		t.Fatalf("Failed to get facts: %v", err)
+	}
+	hostname := hostFacts.Hostname
+
+	fsys := files.NewMemFS()
+	fsys.WriteFile("/config/hosts/"+hostname+".conf", []byte("role=edge"), 0644)
+
+	cfg := &config.Config{
+		ConfigRepoPath: "/config",
+		Spec: &userconfig.Spec{
+			AllowedPathPrefixes: []string{"/var/log", "/etc/app"},
+			Files: []userconfig.FileSpec{
+				{Type: "content", DestPath: "/var/log/${HOSTNAME}/app.log", Content: "started", FileMod: "644"},
+				{Type: "file", SrcPath: "hosts/${HOSTNAME}.conf", DestPath: "/etc/app/app.conf", FileMod: "644"},
+				{Type: "content", DestPath: "/etc/motd", Content: "literal", FileMod: "644"},
+				{Type: "content", DestPath: "/var/log/${UUID}.log", Content: "unknown", FileMod: "644"},
+			},
+		},
+	}
+
+	r := NewReconciler(cfg, nil, nil, nil, files.NewFileReconciler(files.WithFS(fsys)), nil, nil, nil, cache)
+	state := runtime.NewRuntimeState()
+
+	r.reconcileFiles(context.Background(), state)
+
+	for path, want := range map[string]string{
+		"/var/log/" + hostname + "/app.log": "started",
+		"/etc/app/app.conf":                 "role=edge",
+	} {
+		if got, err := fsys.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("content of %s = %q (error %v), want %q", path, got, err, want)
+		}
+	}
+	if _, err := fsys.Stat("/etc/motd"); err == nil {
+		t.Error("/etc/motd written outside the allowed path prefixes")
+	}
+
+	// The allowed path prefixes are checked against the resolved destPath
+	if len(state.Errors) != 2 ||
+		!strings.Contains(state.Errors[0], "unknown placeholders [UUID]") ||
+		state.Errors[1] != "reconcile files: destPath /etc/motd is outside the allowed path prefixes [/var/log /etc/app]" {
+		t.Errorf("Errors = %v, want the unknown placeholder and /etc/motd outside the allowed path prefixes", state.Errors)
+	}
+}
+
 func TestRestartServices(t *testing.T) {
-	cfg := &config.Config{}
+	cfg := &config.Config{Spec: &userconfig.Spec{}}
 
 	restartCalls := []string{}
 	svcMgr := &svcmgr.MockServiceManager{
@@ -447,7 +1091,7 @@ func TestRestartServices(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, nil, nil, svcMgr, nil)
+	r := NewReconciler(cfg, nil, nil, svcMgr, nil, nil, nil, nil, nil)
 
 	state := &runtime.RuntimeState{
 		ServicesToRestart: map[string]bool{
@@ -457,13 +1101,142 @@ func TestRestartServices(t *testing.T) {
 		},
 	}
 
-	r.restartServices(state)
+	r.restartServices(context.Background(), state)
 
 	if len(restartCalls) != 3 {
 		t.Errorf("Restart called %d times, want 3", len(restartCalls))
 	}
 }
 
+func TestRestartServices_RestartOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []string
+		want  []string
+	}{
+		{name: "sorted by default", want: []string{"backend", "cache", "proxy", "worker"}},
+		{
+			name:  "ordered services first",
+			order: []string{"proxy", "backend"},
+			want:  []string{"proxy", "backend", "cache", "worker"},
+		},
+		{
+			name:  "services not restarted are ignored",
+			order: []string{"database", "worker", "proxy"},
+			want:  []string{"worker", "proxy", "backend", "cache"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Spec: &userconfig.Spec{
+					ServiceManager: userconfig.ServiceManagerSection{Name: "systemd", RestartOrder: tt.order},
+				},
+			}
+			svcMgr := &svcmgr.MockServiceManager{}
+			r := NewReconciler(cfg, nil, nil, svcMgr, nil, nil, nil, nil, nil)
+
+			state := runtime.NewRuntimeState()
+			for _, svc := range []string{"worker", "proxy", "cache", "backend"} {
+				state.AddServiceRestart(svc)
+			}
+
+			r.restartServices(context.Background(), state)
+
+			if !reflect.DeepEqual(svcMgr.RestartCalls, tt.want) {
+				t.Errorf("RestartCalls = %v, want %v", svcMgr.RestartCalls, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartServices_VerifyActive(t *testing.T) {
+	interval := verifyActiveInterval
+	verifyActiveInterval = 10 * time.Millisecond
+	t.Cleanup(func() { verifyActiveInterval = interval })
+
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			ServiceManager: userconfig.ServiceManagerSection{Name: "systemd", VerifyActiveSeconds: 1},
+		},
+	}
+
+	// nginx comes up after a few checks, redis never does
+	nginxChecks := 0
+	svcMgr := &svcmgr.MockServiceManager{
+		IsActiveFunc: func(serviceName string) (bool, error) {
+			if serviceName == "nginx" {
+				nginxChecks++
+				return nginxChecks > 2, nil
+			}
+			return false, nil
+		},
+	}
+
+	r := NewReconciler(cfg, nil, nil, svcMgr, nil, nil, nil, nil, nil)
+	state := runtime.NewRuntimeState()
+	state.AddServiceRestart("nginx")
+	state.AddServiceRestart("redis")
+
+	r.restartServices(context.Background(), state)
+
+	expected := []string{"verify redis: service redis is not active 1s after its restart"}
+	if !reflect.DeepEqual(state.Errors, expected) {
+		t.Errorf("Errors = %v, want %v", state.Errors, expected)
+	}
+	if want := []string{"nginx", "redis"}; !reflect.DeepEqual(svcMgr.RestartCalls, want) {
+		t.Errorf("RestartCalls = %v, want %v", svcMgr.RestartCalls, want)
+	}
+}
+
+func TestVerifyActive_StopsWhenCanceled(t *testing.T) {
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			ServiceManager: userconfig.ServiceManagerSection{Name: "systemd", VerifyActiveSeconds: 60},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	svcMgr := &svcmgr.MockServiceManager{
+		IsActiveFunc: func(serviceName string) (bool, error) {
+			cancel()
+			return false, nil
+		},
+	}
+	r := NewReconciler(cfg, nil, nil, svcMgr, nil, nil, nil, nil, nil)
+
+	start := time.Now()
+	err := r.verifyActive(ctx, "nginx")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("verifyActive() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("verifyActive() returned after %s, want it to stop once canceled", elapsed)
+	}
+}
+
+func TestRestartServices_NoVerifyByDefault(t *testing.T) {
+	cfg := &config.Config{Spec: &userconfig.Spec{}}
+
+	svcMgr := &svcmgr.MockServiceManager{
+		IsActiveFunc: func(serviceName string) (bool, error) {
+			t.Errorf("IsActive(%q) called, want no verification", serviceName)
+			return false, nil
+		},
+	}
+
+	r := NewReconciler(cfg, nil, nil, svcMgr, nil, nil, nil, nil, nil)
+	state := runtime.NewRuntimeState()
+	state.AddServiceRestart("nginx")
+
+	r.restartServices(context.Background(), state)
+
+	if len(state.Errors) != 0 {
+		t.Errorf("Errors = %v, want none", state.Errors)
+	}
+}
+
 func TestSleep_RespectsInterval(t *testing.T) {
 	cfg := &config.Config{
 		Spec: &userconfig.Spec{
@@ -471,7 +1244,7 @@ func TestSleep_RespectsInterval(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, nil, nil, nil, nil)
+	r := NewReconciler(cfg, nil, nil, nil, nil, nil, nil, nil, nil)
 
 	ctx := context.Background()
 	start := time.Now()
@@ -491,7 +1264,7 @@ func TestSleep_RespectsContextCancellation(t *testing.T) {
 		},
 	}
 
-	r := NewReconciler(cfg, nil, nil, nil, nil)
+	r := NewReconciler(cfg, nil, nil, nil, nil, nil, nil, nil, nil)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -546,7 +1319,7 @@ func TestRun_ExitsOnContextCancel(t *testing.T) {
 	}
 	fileRec := &files.MockFileReconciler{}
 
-	r := NewReconciler(cfg, gitMgr, pkgMgr, svcMgr, fileRec)
+	r := NewReconciler(cfg, gitMgr, pkgMgr, svcMgr, fileRec, nil, nil, nil, nil)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer cancel()
@@ -560,3 +1333,587 @@ func TestRun_ExitsOnContextCancel(t *testing.T) {
 		t.Errorf("Run duration = %v, should exit quickly after context timeout", elapsed)
 	}
 }
+
+func TestReconcile_SyncFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          string
+		wantFilesCalled bool
+	}{
+		{name: "fail-closed skips files", policy: userconfig.SyncFailurePolicyFailClosed, wantFilesCalled: false},
+		{name: "fail-open reconciles files", policy: userconfig.SyncFailurePolicyFailOpen, wantFilesCalled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			configCommitPath := filepath.Join(tempDir, "config-commit.txt")
+
+			cfg := &config.Config{
+				Spec: &userconfig.Spec{
+					EdgeCD: userconfig.EdgeCDSection{
+						Repo: userconfig.RepoConfig{URL: "file:///opt/edge-cd"},
+					},
+					Config: userconfig.ConfigSection{
+						Path: "devices/test",
+						Repo: userconfig.ConfigRepo{
+							URL:    "https://github.com/test/config.git",
+							Branch: "main",
+						},
+					},
+					Files: []userconfig.FileSpec{
+						{Type: "content", DestPath: "/etc/test", Content: "test"},
+					},
+				},
+				EdgeCDRepoPath:    tempDir,
+				EdgeCDCommitPath:  filepath.Join(tempDir, "edge-cd-commit.txt"),
+				ConfigRepoPath:    tempDir,
+				ConfigCommitPath:  configCommitPath,
+				SyncFailurePolicy: tt.policy,
+			}
+
+			gitMgr := &git.MockRepoManager{
+				SyncRepoFunc: func(repoPath, branch string, sparseCheckoutPaths []string) error {
+					return errors.New("network unreachable")
+				},
+				GetCurrentCommitFunc: func(repoPath string) (string, error) {
+					return "abc123", nil
+				},
+			}
+			filesCalled := false
+			fileRec := &files.MockFileReconciler{
+				ReconcileFilesFunc: func(configRepoPath, configPath string, fileSpecs []userconfig.FileSpec) (*files.ReconcileResult, error) {
+					filesCalled = true
+					return &files.ReconcileResult{}, nil
+				},
+			}
+
+			r := NewReconciler(cfg, gitMgr, &pkgmgr.MockPackageManager{}, &svcmgr.MockServiceManager{}, fileRec, nil, nil, nil, nil)
+			r.reconcile(context.Background())
+
+			if filesCalled != tt.wantFilesCalled {
+				t.Errorf("ReconcileFiles called = %v, want %v", filesCalled, tt.wantFilesCalled)
+			}
+
+			// The config commit is only recorded when its files were reconciled
+			_, err := os.Stat(configCommitPath)
+			if recorded := err == nil; recorded != tt.wantFilesCalled {
+				t.Errorf("config commit recorded = %v, want %v", recorded, tt.wantFilesCalled)
+			}
+		})
+	}
+}
+
+func TestReconcile_MinFetchInterval(t *testing.T) {
+	tempDir := t.TempDir()
+	edgeCDRepoPath := filepath.Join(tempDir, "edge-cd")
+	configRepoPath := filepath.Join(tempDir, "config")
+	for _, dir := range []string{edgeCDRepoPath, configRepoPath} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			EdgeCD: userconfig.EdgeCDSection{
+				Repo: userconfig.RepoConfig{URL: "https://github.com/test/edge-cd.git", Branch: "main"},
+			},
+			Config: userconfig.ConfigSection{
+				Path: "devices/test",
+				Repo: userconfig.ConfigRepo{URL: "https://github.com/test/config.git", Branch: "main"},
+			},
+			Files: []userconfig.FileSpec{
+				{Type: "content", DestPath: "/etc/test", Content: "test"},
+			},
+		},
+		EdgeCDRepoPath:   edgeCDRepoPath,
+		EdgeCDCommitPath: filepath.Join(tempDir, "edge-cd-commit.txt"),
+		ConfigRepoPath:   configRepoPath,
+		ConfigCommitPath: filepath.Join(tempDir, "config-commit.txt"),
+		MinFetchInterval: 60,
+	}
+
+	syncs := map[string]int{}
+	var syncErr error
+	gitMgr := &git.MockRepoManager{
+		SyncRepoFunc: func(repoPath, branch string, sparseCheckoutPaths []string) error {
+			syncs[repoPath]++
+			if repoPath == configRepoPath {
+				return syncErr
+			}
+			return nil
+		},
+	}
+	reconciles := 0
+	fileRec := &files.MockFileReconciler{
+		ReconcileFilesFunc: func(configRepoPath, configPath string, fileSpecs []userconfig.FileSpec) (*files.ReconcileResult, error) {
+			reconciles++
+			return &files.ReconcileResult{}, nil
+		},
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	r := NewReconciler(cfg, gitMgr, &pkgmgr.MockPackageManager{}, &svcmgr.MockServiceManager{}, fileRec, nil, nil, nil, nil)
+	r.now = func() time.Time { return now }
+
+	steps := []struct {
+		name         string
+		elapsed      time.Duration
+		syncErr      error
+		wantEdgeCD   int
+		wantConfig   int
+		wantFileRuns int
+	}{
+		{name: "first iteration fetches", elapsed: 0, wantEdgeCD: 1, wantConfig: 1, wantFileRuns: 1},
+		{name: "fetched recently", elapsed: 10 * time.Second, wantEdgeCD: 1, wantConfig: 1, wantFileRuns: 2},
+		{name: "still below the minimum", elapsed: 59 * time.Second, wantEdgeCD: 1, wantConfig: 1, wantFileRuns: 3},
+		{name: "minimum elapsed", elapsed: 60 * time.Second, syncErr: errors.New("network unreachable"), wantEdgeCD: 2, wantConfig: 2, wantFileRuns: 3},
+		{name: "failed sync is retried", elapsed: 70 * time.Second, wantEdgeCD: 2, wantConfig: 3, wantFileRuns: 4},
+		{name: "retried sync resets the interval", elapsed: 80 * time.Second, wantEdgeCD: 2, wantConfig: 3, wantFileRuns: 5},
+	}
+	for _, step := range steps {
+		now = start.Add(step.elapsed)
+		syncErr = step.syncErr
+		r.reconcile(context.Background())
+
+		if syncs[edgeCDRepoPath] != step.wantEdgeCD || syncs[configRepoPath] != step.wantConfig {
+			t.Errorf("%s: synced edge-cd %d and config %d times, want %d and %d",
+				step.name, syncs[edgeCDRepoPath], syncs[configRepoPath], step.wantEdgeCD, step.wantConfig)
+		}
+		// The files are reconciled from the checkout even when nothing is fetched
+		if reconciles != step.wantFileRuns {
+			t.Errorf("%s: reconciled files %d times, want %d", step.name, reconciles, step.wantFileRuns)
+		}
+	}
+}
+
+func TestReconcile_NoMinFetchInterval(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := &config.Config{
+		Spec: &userconfig.Spec{
+			EdgeCD: userconfig.EdgeCDSection{
+				Repo: userconfig.RepoConfig{URL: "https://github.com/test/edge-cd.git", Branch: "main"},
+			},
+			Config: userconfig.ConfigSection{
+				Path: "devices/test",
+				Repo: userconfig.ConfigRepo{URL: "https://github.com/test/config.git", Branch: "main"},
+			},
+		},
+		EdgeCDRepoPath:   tempDir,
+		EdgeCDCommitPath: filepath.Join(tempDir, "edge-cd-commit.txt"),
+		ConfigRepoPath:   tempDir,
+		ConfigCommitPath: filepath.Join(tempDir, "config-commit.txt"),
+	}
+	syncs := 0
+	gitMgr := &git.MockRepoManager{
+		SyncRepoFunc: func(repoPath, branch string, sparseCheckoutPaths []string) error {
+			syncs++
+			return nil
+		},
+	}
+
+	r := NewReconciler(cfg, gitMgr, &pkgmgr.MockPackageManager{}, &svcmgr.MockServiceManager{}, &files.MockFileReconciler{}, nil, nil, nil, nil)
+	r.reconcile(context.Background())
+	r.reconcile(context.Background())
+
+	// Both repos are synced in each iteration
+	if syncs != 4 {
+		t.Errorf("SyncRepo called %d times, want 4", syncs)
+	}
+}
+
+// newNotifyTestConfig returns a config whose iteration changes the config commit
+// and restarts nginx.
+func newNotifyTestConfig(t *testing.T, notifyURL string) *config.Config {
+	tempDir := t.TempDir()
+	return &config.Config{
+		Spec: &userconfig.Spec{
+			EdgeCD: userconfig.EdgeCDSection{
+				Repo: userconfig.RepoConfig{URL: "file:///opt/edge-cd"},
+			},
+			Config: userconfig.ConfigSection{
+				Path: "devices/test",
+				Repo: userconfig.ConfigRepo{
+					URL:    "https://github.com/test/config.git",
+					Branch: "main",
+				},
+			},
+			Files: []userconfig.FileSpec{
+				{Type: "content", DestPath: "/etc/test", Content: "test"},
+			},
+			Notify: &userconfig.NotifySection{URL: notifyURL, AuthHeader: "Bearer token"},
+		},
+		EdgeCDRepoPath:   tempDir,
+		EdgeCDCommitPath: filepath.Join(tempDir, "edge-cd-commit.txt"),
+		ConfigRepoPath:   tempDir,
+		ConfigCommitPath: filepath.Join(tempDir, "config-commit.txt"),
+	}
+}
+
+func newNotifyTestReconciler(cfg *config.Config, svcMgr *svcmgr.MockServiceManager) *Reconciler {
+	gitMgr := &git.MockRepoManager{
+		GetCurrentCommitFunc: func(repoPath string) (string, error) {
+			return "abc123", nil
+		},
+	}
+	fileRec := &files.MockFileReconciler{
+		ReconcileFilesFunc: func(configRepoPath, configPath string, fileSpecs []userconfig.FileSpec) (*files.ReconcileResult, error) {
+			return &files.ReconcileResult{ServicesToRestart: []string{"nginx"}}, nil
+		},
+	}
+	notifier := notify.NewWebhookNotifier(*cfg.Spec.Notify)
+	return NewReconciler(cfg, gitMgr, &pkgmgr.MockPackageManager{}, svcMgr, fileRec, nil, notifier, nil, nil)
+}
+
+func TestReconcile_NotifiesResult(t *testing.T) {
+	var (
+		results    []runtime.Result
+		authHeader string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		var result runtime.Result
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		results = append(results, result)
+	}))
+	defer server.Close()
+
+	cfg := newNotifyTestConfig(t, server.URL)
+	svcMgr := &svcmgr.MockServiceManager{
+		RestartFunc: func(serviceName string) error {
+			if serviceName == "nginx" {
+				return errors.New("unit not found")
+			}
+			return nil
+		},
+	}
+	r := newNotifyTestReconciler(cfg, svcMgr)
+
+	r.reconcile(context.Background())
+
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(results))
+	}
+	result := results[0]
+	if authHeader != "Bearer token" {
+		t.Errorf("Expected Authorization header 'Bearer token', got %q", authHeader)
+	}
+	if result.Commit != "abc123" {
+		t.Errorf("Expected commit abc123, got %q", result.Commit)
+	}
+	if !result.ConfigChanged {
+		t.Error("Expected configChanged to be true")
+	}
+	if len(result.ServicesRestarted) != 1 || result.ServicesRestarted[0] != "nginx" {
+		t.Errorf("Expected servicesRestarted [nginx], got %v", result.ServicesRestarted)
+	}
+	if result.Reboot {
+		t.Error("Expected reboot to be false")
+	}
+	if len(result.Errors) != 1 || result.Errors[0] != "restart nginx: unit not found" {
+		t.Errorf("Expected the nginx restart error, got %v", result.Errors)
+	}
+	if result.Hostname == "" || result.Time.IsZero() {
+		t.Errorf("Expected hostname and time to be set, got %q and %v", result.Hostname, result.Time)
+	}
+
+	// A second iteration without changes is not notified
+	r.fileRec = &files.MockFileReconciler{}
+	r.reconcile(context.Background())
+	if len(results) != 1 {
+		t.Errorf("Expected no notification for an unchanged iteration, got %d", len(results))
+	}
+}
+
+func TestReconcile_NotifyFailureDoesNotBlock(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := newNotifyTestConfig(t, server.URL)
+	svcMgr := &svcmgr.MockServiceManager{}
+	r := newNotifyTestReconciler(cfg, svcMgr)
+
+	// Retries stop when the iteration context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	r.reconcile(ctx)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("reconcile took %s, a failing webhook must not block it", elapsed)
+	}
+
+	if calls == 0 {
+		t.Error("Expected the webhook to be called")
+	}
+	if len(svcMgr.RestartCalls) != 1 {
+		t.Errorf("Expected nginx to be restarted, got %v", svcMgr.RestartCalls)
+	}
+	data, err := os.ReadFile(cfg.ConfigCommitPath)
+	if err != nil || string(data) != "abc123" {
+		t.Errorf("Expected commit abc123 to be recorded, got %q (%v)", data, err)
+	}
+}
+
+func TestReconcile_WritesSummary(t *testing.T) {
+	cfg := newNotifyTestConfig(t, "")
+	cfg.Spec.Notify = nil
+	cfg.SummaryPath = filepath.Join(t.TempDir(), "state", "reconcile-summary.json")
+	gitMgr := &git.MockRepoManager{
+		GetCurrentCommitFunc: func(repoPath string) (string, error) {
+			return "abc123", nil
+		},
+	}
+	fileRec := &files.MockFileReconciler{
+		ReconcileFilesFunc: func(configRepoPath, configPath string, fileSpecs []userconfig.FileSpec) (*files.ReconcileResult, error) {
+			return &files.ReconcileResult{ServicesToRestart: []string{"nginx"}}, nil
+		},
+	}
+	svcMgr := &svcmgr.MockServiceManager{
+		RestartFunc: func(serviceName string) error {
+			return errors.New("unit not found")
+		},
+	}
+	r := NewReconciler(cfg, gitMgr, &pkgmgr.MockPackageManager{}, svcMgr, fileRec, nil, nil, nil, nil)
+
+	readSummary := func() runtime.Result {
+		t.Helper()
+		raw, err := os.ReadFile(cfg.SummaryPath)
+		if err != nil {
+			t.Fatalf("Failed to read the summary: %v", err)
+		}
+		var summary runtime.Result
+		if err := json.Unmarshal(raw, &summary); err != nil {
+			t.Fatalf("Summary is not valid JSON: %v\n%s", err, raw)
+		}
+		if _, err := os.Stat(cfg.SummaryPath + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("Expected no temporary summary file left, stat error: %v", err)
+		}
+		return summary
+	}
+
+	r.reconcile(context.Background())
+	first := readSummary()
+	if first.Commit != "abc123" || !first.ConfigChanged {
+		t.Errorf("Expected commit abc123 and configChanged, got %q and %v", first.Commit, first.ConfigChanged)
+	}
+	if !reflect.DeepEqual(first.ServicesRestarted, []string{"nginx"}) {
+		t.Errorf("Expected servicesRestarted [nginx], got %v", first.ServicesRestarted)
+	}
+	if !reflect.DeepEqual(first.Errors, []string{"restart nginx: unit not found"}) {
+		t.Errorf("Expected the nginx restart error, got %v", first.Errors)
+	}
+	if first.Time.IsZero() {
+		t.Error("Expected the time to be set")
+	}
+
+	// The summary is replaced by each iteration, even an unchanged one
+	r.fileRec = &files.MockFileReconciler{}
+	r.reconcile(context.Background())
+	second := readSummary()
+	if second.ConfigChanged || len(second.ServicesRestarted) > 0 || len(second.Errors) > 0 {
+		t.Errorf("Expected an unchanged iteration, got %+v", second)
+	}
+	if second.Commit != "abc123" || second.Time.Before(first.Time) {
+		t.Errorf("Expected the summary of the second iteration, got %+v", second)
+	}
+}
+
+func TestReconcile_Reboot(t *testing.T) {
+	tests := []struct {
+		name       string
+		rebootErr  error
+		wantErrors []string
+	}{
+		{name: "rebooted"},
+		{name: "reboot failed", rebootErr: errors.New("exit status 1"), wantErrors: []string{"reboot: exit status 1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newNotifyTestConfig(t, "")
+			cfg.Spec.Notify = nil
+			cfg.SummaryPath = filepath.Join(t.TempDir(), "reconcile-summary.json")
+			gitMgr := &git.MockRepoManager{
+				GetCurrentCommitFunc: func(repoPath string) (string, error) {
+					return "abc123", nil
+				},
+			}
+			fileRec := &files.MockFileReconciler{
+				ReconcileFilesFunc: func(configRepoPath, configPath string, fileSpecs []userconfig.FileSpec) (*files.ReconcileResult, error) {
+					return &files.ReconcileResult{ServicesToRestart: []string{"nginx"}, RequiresReboot: true}, nil
+				},
+			}
+			svcMgr := &svcmgr.MockServiceManager{RebootFunc: func() error { return tt.rebootErr }}
+			r := NewReconciler(cfg, gitMgr, &pkgmgr.MockPackageManager{}, svcMgr, fileRec, nil, nil, nil, nil)
+
+			r.reconcile(context.Background())
+
+			if svcMgr.RebootCalls != 1 {
+				t.Errorf("Expected 1 reboot, got %d", svcMgr.RebootCalls)
+			}
+			// The services are restarted by the reboot
+			if len(svcMgr.RestartCalls) != 0 {
+				t.Errorf("Expected no service restart, got %v", svcMgr.RestartCalls)
+			}
+			// The commit is recorded before rebooting, so that the next iteration
+			// does not apply it again
+			if commit, err := os.ReadFile(cfg.ConfigCommitPath); err != nil || string(commit) != "abc123" {
+				t.Errorf("Expected commit abc123 recorded, got %q (error %v)", commit, err)
+			}
+
+			raw, err := os.ReadFile(cfg.SummaryPath)
+			if err != nil {
+				t.Fatalf("Failed to read the summary: %v", err)
+			}
+			var summary runtime.Result
+			if err := json.Unmarshal(raw, &summary); err != nil {
+				t.Fatalf("Summary is not valid JSON: %v\n%s", err, raw)
+			}
+			if !summary.Reboot {
+				t.Error("Expected reboot to be true")
+			}
+			if !reflect.DeepEqual(summary.Errors, tt.wantErrors) {
+				t.Errorf("Expected errors %v, got %v", tt.wantErrors, summary.Errors)
+			}
+		})
+	}
+}
+
+func newHeartbeatTestReconciler(t *testing.T, heartbeatURL string, fileRec *files.MockFileReconciler) *Reconciler {
+	cfg := newNotifyTestConfig(t, "")
+	cfg.Spec.Notify = nil
+	gitMgr := &git.MockRepoManager{
+		GetCurrentCommitFunc: func(repoPath string) (string, error) {
+			return "abc123", nil
+		},
+	}
+	heartbeat := notify.NewWebhookHeartbeater(userconfig.HeartbeatSection{URL: heartbeatURL})
+	return NewReconciler(cfg, gitMgr, &pkgmgr.MockPackageManager{}, &svcmgr.MockServiceManager{}, fileRec, nil, nil, heartbeat, nil)
+}
+
+func TestReconcile_HeartbeatPerSuccessfulReconcile(t *testing.T) {
+	heartbeats := make(chan notify.Heartbeat, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hb notify.Heartbeat
+		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+			t.Errorf("Failed to decode heartbeat: %v", err)
+		}
+		heartbeats <- hb
+	}))
+	defer server.Close()
+
+	r := newHeartbeatTestReconciler(t, server.URL, &files.MockFileReconciler{})
+	hostname, _ := os.Hostname()
+
+	var last time.Time
+	for i := 0; i < 3; i++ {
+		r.reconcile(context.Background())
+
+		select {
+		case hb := <-heartbeats:
+			if hb.Hostname != hostname {
+				t.Errorf("Expected hostname %q, got %q", hostname, hb.Hostname)
+			}
+			if hb.Commit != "abc123" {
+				t.Errorf("Expected commit abc123, got %q", hb.Commit)
+			}
+			if hb.Time.IsZero() || hb.Time.Before(last) {
+				t.Errorf("Expected a timestamp after %v, got %v", last, hb.Time)
+			}
+			last = hb.Time
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No heartbeat received for iteration %d", i)
+		}
+
+		// Wait for the request to complete before the next iteration
+		deadline := time.Now().Add(5 * time.Second)
+		for r.beating.Load() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestReconcile_NoHeartbeatOnFailure(t *testing.T) {
+	r := newHeartbeatTestReconciler(t, "http://127.0.0.1:0", &files.MockFileReconciler{
+		ReconcileFilesFunc: func(configRepoPath, configPath string, fileSpecs []userconfig.FileSpec) (*files.ReconcileResult, error) {
+			return nil, errors.New("permission denied")
+		},
+	})
+	beats := 0
+	r.heartbeat = &notify.MockHeartbeater{
+		BeatFunc: func(ctx context.Context, hb notify.Heartbeat) error {
+			beats++
+			return nil
+		},
+	}
+
+	r.reconcile(context.Background())
+
+	if r.beating.Load() || beats != 0 {
+		t.Error("Expected no heartbeat after a failed reconcile")
+	}
+}
+
+func TestReconcile_HeartbeatDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	r := newHeartbeatTestReconciler(t, server.URL, &files.MockFileReconciler{})
+
+	start := time.Now()
+	r.reconcile(context.Background())
+	r.reconcile(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("reconcile took %s, a hanging heartbeat endpoint must not block it", elapsed)
+	}
+
+	// The second heartbeat is skipped while the first one is still being sent
+	deadline := time.Now().Add(5 * time.Second)
+	for calls.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected 1 heartbeat request in flight, got %d", got)
+	}
+}
+
+func TestReconcile_RefreshesFactsEachIteration(t *testing.T) {
+	cfg := newNotifyTestConfig(t, "")
+	cfg.Spec.Notify = nil
+	cache := facts.NewCache()
+	r := NewReconciler(cfg, &git.MockRepoManager{}, &pkgmgr.MockPackageManager{}, &svcmgr.MockServiceManager{},
+		&files.MockFileReconciler{}, nil, nil, nil, cache)
+
+	r.reconcile(context.Background())
+	first, err := cache.Get()
+	if err != nil {
+		t.Fatalf("Failed to get facts: %v", err)
+	}
+	if first.Hostname == "" || first.OS == "" || first.Arch == "" {
+		t.Errorf("Expected facts to be gathered, got %+v", first)
+	}
+
+	r.reconcile(context.Background())
+	second, err := cache.Get()
+	if err != nil {
+		t.Fatalf("Failed to get facts: %v", err)
+	}
+	if first == second {
+		t.Error("Expected the facts to be gathered again for the second iteration")
+	}
+}
@@ -1,12 +1,40 @@
 package runtime
 
-import "sort"
+import (
+	"fmt"
+	"sort"
+	"time"
+)
 
 // RuntimeState tracks state within a single reconciliation loop iteration.
-// It accumulates services that need restarting and tracks whether a reboot is required.
+// It accumulates services that need restarting, tracks whether a reboot is required,
+// counts the files changed per action and records the errors of the failed steps.
 type RuntimeState struct {
 	ServicesToRestart map[string]bool // Set for deduplication
 	RequireReboot     bool
+	FileChanges       map[string]int // Number of changed files per action, e.g. "content" or "mode"
+	Errors            []string
+}
+
+// Result is the outcome of a reconciliation loop iteration, as reported to
+// external sinks.
+type Result struct {
+	Hostname          string         `json:"hostname"`
+	Time              time.Time      `json:"time"`
+	Commit            string         `json:"commit,omitempty"`
+	ConfigChanged     bool           `json:"configChanged"`
+	ServicesRestarted []string       `json:"servicesRestarted,omitempty"`
+	Reboot            bool           `json:"reboot"`
+	FileChanges       map[string]int `json:"fileChanges,omitempty"`
+	Errors            []string       `json:"errors,omitempty"`
+	// CommitAgeSeconds is the time since the applied config commit last changed,
+	// to spot devices stuck on an old config. It is nil if the commit is unknown.
+	CommitAgeSeconds *int64 `json:"commitAgeSeconds,omitempty"`
+}
+
+// Changed returns true if the iteration applied a change to the device or failed.
+func (r Result) Changed() bool {
+	return r.ConfigChanged || len(r.ServicesRestarted) > 0 || r.Reboot || len(r.FileChanges) > 0 || len(r.Errors) > 0
 }
 
 // NewRuntimeState creates a new RuntimeState with empty state.
@@ -14,6 +42,7 @@ func NewRuntimeState() *RuntimeState {
 	return &RuntimeState{
 		ServicesToRestart: make(map[string]bool),
 		RequireReboot:     false,
+		FileChanges:       make(map[string]int),
 	}
 }
 
@@ -33,8 +62,26 @@ func (rs *RuntimeState) GetServicesToRestart() []string {
 	return services
 }
 
+// AddFileChanges adds counts, the number of changed files per action, to the
+// file changes of the iteration.
+func (rs *RuntimeState) AddFileChanges(counts map[string]int) {
+	if rs.FileChanges == nil {
+		rs.FileChanges = make(map[string]int)
+	}
+	for action, n := range counts {
+		rs.FileChanges[action] += n
+	}
+}
+
+// AddError records that step failed with err.
+func (rs *RuntimeState) AddError(step string, err error) {
+	rs.Errors = append(rs.Errors, fmt.Sprintf("%s: %s", step, err))
+}
+
 // Reset clears all state, preparing for the next reconciliation loop.
 func (rs *RuntimeState) Reset() {
 	rs.ServicesToRestart = make(map[string]bool)
 	rs.RequireReboot = false
+	rs.FileChanges = make(map[string]int)
+	rs.Errors = nil
 }
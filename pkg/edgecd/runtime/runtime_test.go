@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -144,3 +145,60 @@ func TestRequireReboot(t *testing.T) {
 		t.Error("RequireReboot should be false after reset")
 	}
 }
+
+func TestAddError(t *testing.T) {
+	state := NewRuntimeState()
+
+	state.AddError("install packages", errors.New("apt failed"))
+	state.AddError("restart nginx", errors.New("unit not found"))
+
+	expected := []string{"install packages: apt failed", "restart nginx: unit not found"}
+	if !reflect.DeepEqual(state.Errors, expected) {
+		t.Errorf("Expected %v, got %v", expected, state.Errors)
+	}
+
+	state.Reset()
+	if len(state.Errors) != 0 {
+		t.Errorf("Errors not cleared after reset, got %v", state.Errors)
+	}
+}
+
+func TestAddFileChanges(t *testing.T) {
+	state := NewRuntimeState()
+
+	state.AddFileChanges(map[string]int{"content": 2, "mode": 1})
+	state.AddFileChanges(map[string]int{"mode": 1})
+
+	expected := map[string]int{"content": 2, "mode": 2}
+	if !reflect.DeepEqual(state.FileChanges, expected) {
+		t.Errorf("Expected %v, got %v", expected, state.FileChanges)
+	}
+
+	state.Reset()
+	if len(state.FileChanges) != 0 {
+		t.Errorf("FileChanges not cleared after reset, got %v", state.FileChanges)
+	}
+}
+
+func TestResultChanged(t *testing.T) {
+	testCases := []struct {
+		name     string
+		result   Result
+		expected bool
+	}{
+		{name: "no-op iteration", result: Result{Commit: "abc123"}, expected: false},
+		{name: "config changed", result: Result{ConfigChanged: true}, expected: true},
+		{name: "services restarted", result: Result{ServicesRestarted: []string{"nginx"}}, expected: true},
+		{name: "reboot", result: Result{Reboot: true}, expected: true},
+		{name: "file mode fixed", result: Result{FileChanges: map[string]int{"mode": 1}}, expected: true},
+		{name: "errors", result: Result{Errors: []string{"sync config repo: timeout"}}, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.result.Changed(); got != tc.expected {
+				t.Errorf("Changed() = %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteSummary writes result as JSON to path, for the local tools reading the
+// outcome of the latest iteration. The summary is written to a temporary file
+// renamed over path, so that a reader never sees a partially written summary.
+func WriteSummary(path string, result Result) error {
+	raw, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode reconcile summary: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return fmt.Errorf("failed to write reconcile summary: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write reconcile summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package runtime
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge-cd", "reconcile-summary.json")
+
+	for _, result := range []Result{
+		{
+			Hostname:          "edge-1",
+			Time:              time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+			Commit:            "abc123",
+			ConfigChanged:     true,
+			ServicesRestarted: []string{"nginx"},
+			FileChanges:       map[string]int{"content": 2},
+			Errors:            []string{"restart nginx: unit not found"},
+		},
+		{
+			Hostname: "edge-1",
+			Time:     time.Date(2025, 1, 1, 12, 1, 0, 0, time.UTC),
+			Commit:   "abc123",
+		},
+	} {
+		if err := WriteSummary(path, result); err != nil {
+			t.Fatalf("WriteSummary() error = %v", err)
+		}
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read the summary: %v", err)
+		}
+		var got Result
+		if err := json.Unmarshal(raw, &got); err != nil {
+			t.Fatalf("Summary is not valid JSON: %v\n%s", err, raw)
+		}
+		if !reflect.DeepEqual(got, result) {
+			t.Errorf("Summary = %+v, want %+v", got, result)
+		}
+		if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("Expected no temporary file left, stat error: %v", err)
+		}
+	}
+}
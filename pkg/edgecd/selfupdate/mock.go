@@ -0,0 +1,50 @@
+package selfupdate
+
+import (
+	"io"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// MockDownloader is a mock implementation of Downloader for testing.
+type MockDownloader struct {
+	DownloadFunc func(url string, w io.Writer) error
+
+	// Track calls for verification
+	DownloadCalls []string
+}
+
+// Download calls the mock function if provided, otherwise writes nothing and returns nil.
+func (m *MockDownloader) Download(url string, w io.Writer) error {
+	m.DownloadCalls = append(m.DownloadCalls, url)
+	if m.DownloadFunc != nil {
+		return m.DownloadFunc(url, w)
+	}
+	return nil
+}
+
+// MockUpdater is a mock implementation of Updater for testing.
+type MockUpdater struct {
+	ReconcileFunc         func(spec userconfig.SelfUpdateSection) (bool, error)
+	StartVerificationFunc func(spec userconfig.SelfUpdateSection) error
+
+	// Track calls for verification
+	StartVerificationCalls int
+}
+
+// Reconcile calls the mock function if provided, otherwise reports no update.
+func (m *MockUpdater) Reconcile(spec userconfig.SelfUpdateSection) (bool, error) {
+	if m.ReconcileFunc != nil {
+		return m.ReconcileFunc(spec)
+	}
+	return false, nil
+}
+
+// StartVerification calls the mock function if provided, otherwise returns nil.
+func (m *MockUpdater) StartVerification(spec userconfig.SelfUpdateSection) error {
+	m.StartVerificationCalls++
+	if m.StartVerificationFunc != nil {
+		return m.StartVerificationFunc(spec)
+	}
+	return nil
+}
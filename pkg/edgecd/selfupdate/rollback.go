@@ -0,0 +1,257 @@
+package selfupdate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/svcmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// ErrRolledBack is returned when the updated service failed its health check
+// and the previous binary was restored.
+var ErrRolledBack = errors.New("updated edge-cd failed its health check and was rolled back")
+
+const (
+	// DefaultStableSeconds is how long the service must stay active after an update.
+	DefaultStableSeconds = 30
+
+	// VerifyUpdateFlag is the edge-cd-go flag running the post-update verification.
+	VerifyUpdateFlag = "verify-update"
+	// VerifyAfterPIDFlag is the edge-cd-go flag naming the process to wait for before verifying.
+	VerifyAfterPIDFlag = "verify-after-pid"
+
+	// exitTimeout bounds how long the verification waits for the previous process to exit
+	exitTimeout = 5 * time.Minute
+)
+
+// HealthChecker checks whether the running edge-cd instance is healthy.
+type HealthChecker interface {
+	Check() error
+}
+
+// httpHealthChecker is the implementation of HealthChecker querying an HTTP endpoint.
+type httpHealthChecker struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPHealthChecker creates a HealthChecker expecting a 200 response from url.
+func NewHTTPHealthChecker(url string) HealthChecker {
+	return &httpHealthChecker{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Check queries the health endpoint.
+func (h *httpHealthChecker) Check() error {
+	resp, err := h.client.Get(h.url)
+	if err != nil {
+		return fmt.Errorf("health check %s failed: %w", h.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check %s failed: unexpected status %s", h.url, resp.Status)
+	}
+	return nil
+}
+
+// VerifyConfig configures the verification of the service after an update.
+type VerifyConfig struct {
+	// ServiceName is the service running the updated binary
+	ServiceName string
+	// BinaryPath is the path of the updated binary
+	BinaryPath string
+	// AfterPID is the process running the previous binary. The verification
+	// starts once it has exited. Ignored if zero
+	AfterPID int
+	// StableFor is how long the service must stay active
+	StableFor time.Duration
+	// Interval is how often the service state is checked
+	Interval time.Duration
+	// Health is an optional additional check run once the service is stable
+	Health HealthChecker
+}
+
+// NewVerifyConfig creates a VerifyConfig for the edge-cd service from the spec.
+func NewVerifyConfig(spec userconfig.SelfUpdateSection, binaryPath string, afterPID int) VerifyConfig {
+	cfg := VerifyConfig{
+		ServiceName: "edge-cd",
+		BinaryPath:  binaryPath,
+		AfterPID:    afterPID,
+		StableFor:   DefaultStableSeconds * time.Second,
+		Interval:    time.Second,
+	}
+
+	if hc := spec.HealthCheck; hc != nil {
+		if hc.StableSeconds > 0 {
+			cfg.StableFor = time.Duration(hc.StableSeconds) * time.Second
+		}
+		if hc.URL != "" {
+			cfg.Health = NewHTTPHealthChecker(hc.URL)
+		}
+	}
+	return cfg
+}
+
+// Verify checks that the service stays active and healthy after it was
+// restarted on an updated binary. On failure, the previous binary is restored,
+// the service is restarted and ErrRolledBack is returned.
+func Verify(svcMgr svcmgr.ServiceManager, cfg VerifyConfig) error {
+	if cfg.AfterPID > 0 {
+		waitForExit(cfg.AfterPID, cfg.Interval, exitTimeout)
+	}
+
+	checkErr := checkHealth(svcMgr, cfg)
+	if checkErr == nil {
+		slog.Info("Updated EdgeCD is healthy", "service", cfg.ServiceName)
+		return nil
+	}
+
+	slog.Error("Updated EdgeCD is unhealthy, rolling back", "service", cfg.ServiceName, "error", checkErr)
+
+	if err := Rollback(cfg.BinaryPath); err != nil {
+		return fmt.Errorf("%v: rollback failed: %w", checkErr, err)
+	}
+	if err := svcMgr.Restart(cfg.ServiceName); err != nil {
+		return fmt.Errorf("%v: failed to restart %s on the previous binary: %w", checkErr, cfg.ServiceName, err)
+	}
+
+	return fmt.Errorf("%w: %v", ErrRolledBack, checkErr)
+}
+
+// checkHealth polls the service state until it has been active for StableFor,
+// then runs the health check.
+func checkHealth(svcMgr svcmgr.ServiceManager, cfg VerifyConfig) error {
+	deadline := time.Now().Add(cfg.StableFor)
+	for {
+		active, err := svcMgr.IsActive(cfg.ServiceName)
+		if err != nil {
+			return fmt.Errorf("failed to check service %s: %w", cfg.ServiceName, err)
+		}
+		if !active {
+			return fmt.Errorf("service %s is not active", cfg.ServiceName)
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		time.Sleep(min(cfg.Interval, remaining))
+	}
+
+	if cfg.Health != nil {
+		return cfg.Health.Check()
+	}
+	return nil
+}
+
+// Rollback restores the binary kept at PrevPath and its recorded version. The
+// version rolled back from is recorded at FailedVersionPath, so that the next
+// reconciliations do not install it again.
+func Rollback(binaryPath string) error {
+	prevPath := PrevPath(binaryPath)
+	if _, err := os.Stat(prevPath); err != nil {
+		return fmt.Errorf("no previous binary to roll back to: %w", err)
+	}
+
+	if failed, err := os.ReadFile(VersionPath(binaryPath)); err == nil && len(failed) > 0 {
+		if err := os.WriteFile(FailedVersionPath(binaryPath), failed, 0o644); err != nil {
+			return fmt.Errorf("failed to record rolled back version: %w", err)
+		}
+	}
+
+	if err := os.Rename(prevPath, binaryPath); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", prevPath, err)
+	}
+
+	prevVersionPath := VersionPath(prevPath)
+	if err := os.Rename(prevVersionPath, VersionPath(binaryPath)); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to restore %s: %w", prevVersionPath, err)
+		}
+		// The previous binary was not a pinned release
+		os.Remove(VersionPath(binaryPath))
+	}
+
+	slog.Info("Restored previous EdgeCD binary", "binary", binaryPath)
+	return nil
+}
+
+// waitForExit waits until the process pid has exited or the timeout elapses.
+func waitForExit(pid int, interval, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(pid, 0); err != nil {
+			return
+		}
+		time.Sleep(interval)
+	}
+	slog.Warn("Previous EdgeCD process did not exit, verifying anyway", "pid", pid)
+}
+
+// startDetached starts a process in its own session, so it survives the
+// restart of the service that started it.
+func startDetached(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Env = os.Environ()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// supportsVerifyFlag reports whether the binary at binaryPath knows the
+// VerifyUpdateFlag, from the usage it prints.
+func supportsVerifyFlag(binaryPath string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The usage is printed with a non-zero exit status
+	output, _ := exec.CommandContext(ctx, binaryPath, "-h").CombinedOutput()
+	return strings.Contains(string(output), "-"+VerifyUpdateFlag)
+}
+
+// StartVerification starts a detached process verifying the service once this
+// process has been restarted. The verification runs from the previous binary,
+// which is known to work, unless it predates the verification: then it runs
+// from the updated binary, which fails to roll back if it does not start.
+func (u *updater) StartVerification(spec userconfig.SelfUpdateSection) error {
+	binaryPath, err := resolveBinaryPath(spec.BinaryPath)
+	if err != nil {
+		return err
+	}
+
+	prevPath := PrevPath(binaryPath)
+	if _, err := os.Stat(prevPath); err != nil {
+		return fmt.Errorf("no previous binary to verify the update with: %w", err)
+	}
+
+	verifier := prevPath
+	if !u.supportsVerify(prevPath) {
+		slog.Error("Previous EdgeCD binary cannot verify the update, verifying it with the updated binary: "+
+			"the update is not rolled back if the updated binary does not start",
+			"previous", prevPath, "binary", binaryPath)
+		verifier = binaryPath
+	}
+
+	if err := u.launch(
+		verifier,
+		"-"+VerifyUpdateFlag, binaryPath,
+		"-"+VerifyAfterPIDFlag, strconv.Itoa(os.Getpid()),
+	); err != nil {
+		return fmt.Errorf("failed to start update verification: %w", err)
+	}
+	return nil
+}
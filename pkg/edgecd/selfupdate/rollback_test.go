@@ -0,0 +1,249 @@
+package selfupdate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/svcmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// mockHealthChecker returns err on every check.
+type mockHealthChecker struct {
+	err    error
+	checks int
+}
+
+func (m *mockHealthChecker) Check() error {
+	m.checks++
+	return m.err
+}
+
+// setupUpdatedBinary lays out a binary updated from v1.1.0 to v1.2.0.
+func setupUpdatedBinary(t *testing.T) string {
+	t.Helper()
+	binaryPath := filepath.Join(t.TempDir(), "edge-cd")
+	os.WriteFile(binaryPath, []byte("new binary"), 0o755)
+	os.WriteFile(VersionPath(binaryPath), []byte("v1.2.0"), 0o644)
+	os.WriteFile(PrevPath(binaryPath), []byte("old binary"), 0o755)
+	os.WriteFile(VersionPath(PrevPath(binaryPath)), []byte("v1.1.0"), 0o644)
+	return binaryPath
+}
+
+func testVerifyConfig(binaryPath string, health HealthChecker) VerifyConfig {
+	return VerifyConfig{
+		ServiceName: "edge-cd",
+		BinaryPath:  binaryPath,
+		StableFor:   30 * time.Millisecond,
+		Interval:    5 * time.Millisecond,
+		Health:      health,
+	}
+}
+
+func assertBinary(t *testing.T, binaryPath, wantContent, wantVersion string) {
+	t.Helper()
+	content, _ := os.ReadFile(binaryPath)
+	if string(content) != wantContent {
+		t.Errorf("binary content = %q, want %q", content, wantContent)
+	}
+	version, _ := os.ReadFile(VersionPath(binaryPath))
+	if string(version) != wantVersion {
+		t.Errorf("recorded version = %q, want %q", version, wantVersion)
+	}
+}
+
+func TestVerify_HealthyKeepsUpdate(t *testing.T) {
+	binaryPath := setupUpdatedBinary(t)
+	svcMgr := &svcmgr.MockServiceManager{}
+	health := &mockHealthChecker{}
+
+	if err := Verify(svcMgr, testVerifyConfig(binaryPath, health)); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if health.checks != 1 {
+		t.Errorf("health checks = %d, want 1", health.checks)
+	}
+	if len(svcMgr.RestartCalls) != 0 {
+		t.Errorf("Restart calls = %v, want none", svcMgr.RestartCalls)
+	}
+	assertBinary(t, binaryPath, "new binary", "v1.2.0")
+}
+
+func TestVerify_CrashLoopRollsBack(t *testing.T) {
+	binaryPath := setupUpdatedBinary(t)
+
+	// The service is active right after the restart, then crashes
+	calls := 0
+	svcMgr := &svcmgr.MockServiceManager{
+		IsActiveFunc: func(serviceName string) (bool, error) {
+			calls++
+			return calls < 3, nil
+		},
+	}
+	health := &mockHealthChecker{}
+
+	err := Verify(svcMgr, testVerifyConfig(binaryPath, health))
+	if !errors.Is(err, ErrRolledBack) {
+		t.Fatalf("Verify() error = %v, want ErrRolledBack", err)
+	}
+
+	if health.checks != 0 {
+		t.Errorf("health checks = %d, want none once the service is down", health.checks)
+	}
+	if len(svcMgr.RestartCalls) != 1 || svcMgr.RestartCalls[0] != "edge-cd" {
+		t.Errorf("Restart calls = %v, want [edge-cd]", svcMgr.RestartCalls)
+	}
+	assertBinary(t, binaryPath, "old binary", "v1.1.0")
+	if _, err := os.Stat(PrevPath(binaryPath)); !os.IsNotExist(err) {
+		t.Errorf("expected the previous binary to be moved back, got err = %v", err)
+	}
+}
+
+func TestReconcile_SkipsRolledBackVersion(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "edge-cd")
+	os.WriteFile(binaryPath, []byte("old binary"), 0o755)
+	os.WriteFile(VersionPath(binaryPath), []byte("v1.1.0"), 0o644)
+	downloader := releaseDownloader()
+	u := NewUpdater(downloader)
+
+	if updated, err := u.Reconcile(newTestSpec(binaryPath, "v1.2.0")); err != nil || !updated {
+		t.Fatalf("Reconcile() = %v, %v, want the update installed", updated, err)
+	}
+	svcMgr := &svcmgr.MockServiceManager{}
+	health := &mockHealthChecker{err: errors.New("/healthz returned 500")}
+	if err := Verify(svcMgr, testVerifyConfig(binaryPath, health)); !errors.Is(err, ErrRolledBack) {
+		t.Fatalf("Verify() error = %v, want ErrRolledBack", err)
+	}
+
+	// The spec still pins the rolled back version
+	updated, err := u.Reconcile(newTestSpec(binaryPath, "v1.2.0"))
+	if !errors.Is(err, ErrVersionRejected) || updated {
+		t.Fatalf("Reconcile() = %v, %v, want ErrVersionRejected", updated, err)
+	}
+	if len(downloader.DownloadCalls) != 1 {
+		t.Errorf("Download calls = %v, want the rolled back version downloaded once", downloader.DownloadCalls)
+	}
+	assertBinary(t, binaryPath, "old binary", "v1.1.0")
+
+	// A new pinned version is installed, and clears the rejected one
+	if updated, err := u.Reconcile(newTestSpec(binaryPath, "v1.2.1")); err != nil || !updated {
+		t.Fatalf("Reconcile() = %v, %v, want the new version installed", updated, err)
+	}
+	if _, err := os.Stat(FailedVersionPath(binaryPath)); !os.IsNotExist(err) {
+		t.Errorf("expected the rejected version to be cleared, got err = %v", err)
+	}
+}
+
+func TestVerify_UnhealthyEndpointRollsBack(t *testing.T) {
+	binaryPath := setupUpdatedBinary(t)
+	svcMgr := &svcmgr.MockServiceManager{}
+	health := &mockHealthChecker{err: errors.New("/healthz returned 500")}
+
+	err := Verify(svcMgr, testVerifyConfig(binaryPath, health))
+	if !errors.Is(err, ErrRolledBack) {
+		t.Fatalf("Verify() error = %v, want ErrRolledBack", err)
+	}
+
+	if len(svcMgr.RestartCalls) != 1 {
+		t.Errorf("Restart calls = %v, want [edge-cd]", svcMgr.RestartCalls)
+	}
+	assertBinary(t, binaryPath, "old binary", "v1.1.0")
+}
+
+func TestVerify_NoPreviousBinary(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "edge-cd")
+	os.WriteFile(binaryPath, []byte("new binary"), 0o755)
+	svcMgr := &svcmgr.MockServiceManager{
+		IsActiveFunc: func(serviceName string) (bool, error) { return false, nil },
+	}
+
+	err := Verify(svcMgr, testVerifyConfig(binaryPath, nil))
+	if err == nil || errors.Is(err, ErrRolledBack) {
+		t.Fatalf("Verify() error = %v, want rollback failure", err)
+	}
+	if len(svcMgr.RestartCalls) != 0 {
+		t.Errorf("Restart calls = %v, want none", svcMgr.RestartCalls)
+	}
+}
+
+func TestNewVerifyConfig(t *testing.T) {
+	cfg := NewVerifyConfig(userconfig.SelfUpdateSection{}, "/usr/bin/edge-cd", 42)
+	if cfg.StableFor != DefaultStableSeconds*time.Second || cfg.Health != nil || cfg.AfterPID != 42 {
+		t.Errorf("unexpected default config: %+v", cfg)
+	}
+
+	cfg = NewVerifyConfig(userconfig.SelfUpdateSection{
+		HealthCheck: &userconfig.SelfUpdateHealthCheck{StableSeconds: 5, URL: "http://127.0.0.1:8080/healthz"},
+	}, "/usr/bin/edge-cd", 0)
+	if cfg.StableFor != 5*time.Second || cfg.Health == nil {
+		t.Errorf("health check settings not applied: %+v", cfg)
+	}
+}
+
+func TestStartVerification_LaunchesPreviousBinary(t *testing.T) {
+	binaryPath := setupUpdatedBinary(t)
+
+	var launched []string
+	u := &updater{
+		launch: func(name string, args ...string) error {
+			launched = append([]string{name}, args...)
+			return nil
+		},
+		supportsVerify: func(string) bool { return true },
+	}
+
+	if err := u.StartVerification(userconfig.SelfUpdateSection{BinaryPath: binaryPath}); err != nil {
+		t.Fatalf("StartVerification() error = %v", err)
+	}
+
+	if len(launched) != 5 || launched[0] != PrevPath(binaryPath) ||
+		launched[1] != "-verify-update" || launched[2] != binaryPath || launched[3] != "-verify-after-pid" {
+		t.Errorf("launched %v, want the previous binary verifying %s", launched, binaryPath)
+	}
+}
+
+func TestStartVerification_PreviousBinaryWithoutVerification(t *testing.T) {
+	binaryPath := setupUpdatedBinary(t)
+
+	var launched []string
+	u := &updater{
+		launch: func(name string, args ...string) error {
+			launched = append([]string{name}, args...)
+			return nil
+		},
+		supportsVerify: func(string) bool { return false },
+	}
+
+	if err := u.StartVerification(userconfig.SelfUpdateSection{BinaryPath: binaryPath}); err != nil {
+		t.Fatalf("StartVerification() error = %v", err)
+	}
+
+	if len(launched) != 5 || launched[0] != binaryPath || launched[2] != binaryPath {
+		t.Errorf("launched %v, want the updated binary verifying itself", launched)
+	}
+}
+
+func TestSupportsVerifyFlag(t *testing.T) {
+	dir := t.TempDir()
+	for name, usage := range map[string]string{
+		"new": "  -verify-update string\n    \tVerify the updated binary",
+		"old": "  -inventory\n    \tPrint the inventory",
+	} {
+		script := "#!/bin/sh\nprintf '%s\\n' 'Usage of edge-cd-go:' '" + usage + "' >&2\nexit 2\n"
+		os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755)
+	}
+
+	if !supportsVerifyFlag(filepath.Join(dir, "new")) {
+		t.Error("supportsVerifyFlag() = false for a binary printing the flag")
+	}
+	if supportsVerifyFlag(filepath.Join(dir, "old")) {
+		t.Error("supportsVerifyFlag() = true for a binary without the flag")
+	}
+	if supportsVerifyFlag(filepath.Join(dir, "missing")) {
+		t.Error("supportsVerifyFlag() = true for a missing binary")
+	}
+}
@@ -0,0 +1,254 @@
+package selfupdate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+// DefaultChannel is the release channel used when none is configured.
+const DefaultChannel = "stable"
+
+// ErrVersionRejected is returned by Updater.Reconcile when the pinned version
+// was rolled back after a failed health check. It is not installed again until
+// the pinned version changes.
+var ErrVersionRejected = errors.New("pinned edge-cd version was rolled back after a failed health check")
+
+// ErrChecksumMismatch is returned by Updater.Reconcile when the downloaded
+// binary does not match the pinned checksum. The binary is left untouched.
+var ErrChecksumMismatch = errors.New("downloaded edge-cd binary does not match the pinned sha256")
+
+// Downloader fetches release artifacts.
+type Downloader interface {
+	// Download writes the content found at url to w.
+	Download(url string, w io.Writer) error
+}
+
+// httpDownloader is the implementation of Downloader over HTTP(S).
+type httpDownloader struct {
+	client *http.Client
+}
+
+// NewHTTPDownloader creates a new Downloader fetching artifacts over HTTP(S).
+func NewHTTPDownloader() Downloader {
+	return &httpDownloader{client: &http.Client{Timeout: 5 * time.Minute}}
+}
+
+// Download fetches url and writes the response body to w.
+func (d *httpDownloader) Download(url string, w io.Writer) error {
+	resp, err := d.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: unexpected status %s", url, resp.Status)
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	return nil
+}
+
+// Updater keeps the edge-cd binary at the release version pinned in the spec.
+type Updater interface {
+	// Reconcile installs the pinned release if it differs from the installed one.
+	// It returns true if the binary was replaced.
+	Reconcile(spec userconfig.SelfUpdateSection) (bool, error)
+	// StartVerification starts a detached process that verifies the service once
+	// it has restarted on the updated binary, and rolls it back on failure.
+	StartVerification(spec userconfig.SelfUpdateSection) error
+}
+
+// updater is the implementation of Updater.
+type updater struct {
+	downloader     Downloader
+	launch         func(name string, args ...string) error
+	supportsVerify func(binaryPath string) bool
+}
+
+// NewUpdater creates a new Updater downloading releases with the given Downloader.
+func NewUpdater(downloader Downloader) Updater {
+	return &updater{downloader: downloader, launch: startDetached, supportsVerify: supportsVerifyFlag}
+}
+
+// URLParams are the values available to the self-update URL template.
+type URLParams struct {
+	Version string
+	Channel string
+	OS      string
+	Arch    string
+}
+
+// RenderURL renders a URL template such as
+// "https://example.com/releases/{{ .Version }}/edge-cd-go_{{ .OS }}_{{ .Arch }}".
+func RenderURL(urlTemplate string, params URLParams) (string, error) {
+	tmpl, err := template.New("url").Option("missingkey=error").Parse(urlTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse URL template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return "", fmt.Errorf("failed to render URL template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// VersionPath returns the path of the file recording the version installed at binaryPath.
+func VersionPath(binaryPath string) string {
+	return binaryPath + ".version"
+}
+
+// FailedVersionPath returns the path of the file recording the version rolled
+// back from at binaryPath.
+func FailedVersionPath(binaryPath string) string {
+	return binaryPath + ".failed-version"
+}
+
+// PrevPath returns the path where the binary replaced by the last update is kept.
+func PrevPath(binaryPath string) string {
+	return binaryPath + ".prev"
+}
+
+// resolveBinaryPath returns the configured binary path or the path of the running binary.
+func resolveBinaryPath(binaryPath string) (string, error) {
+	if binaryPath != "" {
+		return binaryPath, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate edge-cd binary: %w", err)
+	}
+	return exe, nil
+}
+
+// writeVersion records the version installed at binaryPath. The file is replaced
+// rather than rewritten in place, since it may be hard-linked to the previous version file.
+func writeVersion(binaryPath, version string) error {
+	versionPath := VersionPath(binaryPath)
+	tmpPath := versionPath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(version), 0o644); err != nil {
+		return fmt.Errorf("failed to record installed version: %w", err)
+	}
+	if err := os.Rename(tmpPath, versionPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to record installed version: %w", err)
+	}
+	return nil
+}
+
+// keepPrevious hard-links the current binary and its version file to their
+// ".prev" paths, so the update can be rolled back.
+func keepPrevious(binaryPath string) error {
+	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	for _, p := range []struct{ src, dst string }{
+		{binaryPath, PrevPath(binaryPath)},
+		{VersionPath(binaryPath), VersionPath(PrevPath(binaryPath))},
+	} {
+		os.Remove(p.dst)
+		if err := os.Link(p.src, p.dst); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to keep previous binary at %s: %w", p.dst, err)
+		}
+	}
+	return nil
+}
+
+// Reconcile downloads the pinned release next to the binary and renames it over
+// the binary, so the swap is atomic and a failed download leaves it untouched.
+// The replaced binary is kept at PrevPath for rollback. A version recorded at
+// FailedVersionPath is not installed: ErrVersionRejected is returned instead.
+func (u *updater) Reconcile(spec userconfig.SelfUpdateSection) (bool, error) {
+	binaryPath, err := resolveBinaryPath(spec.BinaryPath)
+	if err != nil {
+		return false, err
+	}
+
+	versionPath := VersionPath(binaryPath)
+	installedData, _ := os.ReadFile(versionPath)
+	installed := strings.TrimSpace(string(installedData))
+	if installed == spec.Version {
+		slog.Info("EdgeCD binary already at pinned version", "version", spec.Version)
+		return false, nil
+	}
+
+	failedData, _ := os.ReadFile(FailedVersionPath(binaryPath))
+	if strings.TrimSpace(string(failedData)) == spec.Version {
+		return false, fmt.Errorf("%w: %s", ErrVersionRejected, spec.Version)
+	}
+
+	channel := spec.Channel
+	if channel == "" {
+		channel = DefaultChannel
+	}
+
+	url, err := RenderURL(spec.URLTemplate, URLParams{
+		Version: spec.Version,
+		Channel: channel,
+		OS:      goruntime.GOOS,
+		Arch:    goruntime.GOARCH,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	slog.Info("Updating EdgeCD binary",
+		"from", installed, "to", spec.Version, "url", url, "binary", binaryPath)
+
+	// Download into the binary directory so the final rename stays on the same filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(binaryPath), "."+filepath.Base(binaryPath)+".download-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	hash := sha256.New()
+	if err := u.downloader.Download(url, io.MultiWriter(tmp, hash)); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, fmt.Errorf("failed to write downloaded binary: %w", err)
+	}
+	if spec.SHA256 != "" {
+		if got := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(got, spec.SHA256) {
+			return false, fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, got, spec.SHA256)
+		}
+	}
+	if err := os.Chmod(tmpPath, 0o755); err != nil {
+		return false, fmt.Errorf("failed to make downloaded binary executable: %w", err)
+	}
+	if err := keepPrevious(binaryPath); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmpPath, binaryPath); err != nil {
+		return false, fmt.Errorf("failed to replace %s: %w", binaryPath, err)
+	}
+
+	if err := writeVersion(binaryPath, spec.Version); err != nil {
+		return true, err
+	}
+	// A version rolled back before may be pinned again once a new one is installed
+	os.Remove(FailedVersionPath(binaryPath))
+
+	return true, nil
+}
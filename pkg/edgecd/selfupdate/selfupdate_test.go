@@ -0,0 +1,238 @@
+package selfupdate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+)
+
+func newTestSpec(binaryPath, version string) userconfig.SelfUpdateSection {
+	return userconfig.SelfUpdateSection{
+		Version:     version,
+		URLTemplate: "https://example.com/{{ .Channel }}/{{ .Version }}/edge-cd-go_{{ .OS }}_{{ .Arch }}",
+		BinaryPath:  binaryPath,
+	}
+}
+
+func releaseDownloader() *MockDownloader {
+	return &MockDownloader{
+		DownloadFunc: func(url string, w io.Writer) error {
+			_, err := io.WriteString(w, "binary from "+url)
+			return err
+		},
+	}
+}
+
+func TestRenderURL(t *testing.T) {
+	got, err := RenderURL(
+		"https://example.com/{{ .Channel }}/{{ .Version }}/edge-cd-go_{{ .OS }}_{{ .Arch }}",
+		URLParams{Version: "v1.2.0", Channel: "stable", OS: "linux", Arch: "arm64"},
+	)
+	if err != nil {
+		t.Fatalf("RenderURL() error = %v", err)
+	}
+	want := "https://example.com/stable/v1.2.0/edge-cd-go_linux_arm64"
+	if got != want {
+		t.Errorf("RenderURL() = %v, want %v", got, want)
+	}
+
+	if _, err := RenderURL("https://example.com/{{ .Unknown }}", URLParams{}); err == nil {
+		t.Error("RenderURL() expected error for unknown field")
+	}
+}
+
+func TestReconcile_SwapsBinaryOnVersionChange(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "edge-cd-go")
+	os.WriteFile(binaryPath, []byte("old binary"), 0o755)
+	os.WriteFile(VersionPath(binaryPath), []byte("v1.1.0\n"), 0o644)
+
+	downloader := releaseDownloader()
+	u := NewUpdater(downloader)
+
+	updated, err := u.Reconcile(newTestSpec(binaryPath, "v1.2.0"))
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if !updated {
+		t.Error("Reconcile() updated = false, want true")
+	}
+
+	wantURL := fmt.Sprintf("https://example.com/stable/v1.2.0/edge-cd-go_%s_%s", goruntime.GOOS, goruntime.GOARCH)
+	if len(downloader.DownloadCalls) != 1 || downloader.DownloadCalls[0] != wantURL {
+		t.Errorf("Download calls = %v, want [%v]", downloader.DownloadCalls, wantURL)
+	}
+
+	content, _ := os.ReadFile(binaryPath)
+	if string(content) != "binary from "+wantURL {
+		t.Errorf("binary content = %q, want downloaded release", content)
+	}
+
+	info, err := os.Stat(binaryPath)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Errorf("binary mode = %v, want 0755", info.Mode().Perm())
+	}
+
+	version, _ := os.ReadFile(VersionPath(binaryPath))
+	if string(version) != "v1.2.0" {
+		t.Errorf("recorded version = %q, want v1.2.0", version)
+	}
+
+	// The replaced binary is kept for rollback
+	prev, _ := os.ReadFile(PrevPath(binaryPath))
+	if string(prev) != "old binary" {
+		t.Errorf("previous binary content = %q, want old binary", prev)
+	}
+	prevVersion, _ := os.ReadFile(VersionPath(PrevPath(binaryPath)))
+	if string(prevVersion) != "v1.1.0\n" {
+		t.Errorf("previous version = %q, want v1.1.0", prevVersion)
+	}
+
+	// No temporary download is left behind
+	entries, _ := os.ReadDir(filepath.Dir(binaryPath))
+	if len(entries) != 4 {
+		t.Errorf("expected only the binaries and their version files, got %v", entries)
+	}
+}
+
+func TestReconcile_NoopWhenVersionUnchanged(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "edge-cd-go")
+	os.WriteFile(binaryPath, []byte("current binary"), 0o755)
+	os.WriteFile(VersionPath(binaryPath), []byte("v1.2.0"), 0o644)
+
+	downloader := releaseDownloader()
+	u := NewUpdater(downloader)
+
+	updated, err := u.Reconcile(newTestSpec(binaryPath, "v1.2.0"))
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if updated {
+		t.Error("Reconcile() updated = true, want false")
+	}
+	if len(downloader.DownloadCalls) != 0 {
+		t.Errorf("Download calls = %v, want none", downloader.DownloadCalls)
+	}
+
+	content, _ := os.ReadFile(binaryPath)
+	if string(content) != "current binary" {
+		t.Errorf("binary content = %q, want it unchanged", content)
+	}
+}
+
+func TestReconcile_InstallsWhenNoVersionRecorded(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "edge-cd-go")
+	os.WriteFile(binaryPath, []byte("binary built from git"), 0o755)
+
+	u := NewUpdater(releaseDownloader())
+
+	updated, err := u.Reconcile(newTestSpec(binaryPath, "v1.2.0"))
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if !updated {
+		t.Error("Reconcile() updated = false, want true")
+	}
+}
+
+func TestReconcile_DownloadFailureKeepsBinary(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "edge-cd-go")
+	os.WriteFile(binaryPath, []byte("old binary"), 0o755)
+	os.WriteFile(VersionPath(binaryPath), []byte("v1.1.0"), 0o644)
+
+	downloader := &MockDownloader{
+		DownloadFunc: func(url string, w io.Writer) error {
+			io.WriteString(w, "partial")
+			return errors.New("connection reset")
+		},
+	}
+	u := NewUpdater(downloader)
+
+	updated, err := u.Reconcile(newTestSpec(binaryPath, "v1.2.0"))
+	if err == nil {
+		t.Fatal("Reconcile() expected error")
+	}
+	if updated {
+		t.Error("Reconcile() updated = true, want false")
+	}
+
+	content, _ := os.ReadFile(binaryPath)
+	if string(content) != "old binary" {
+		t.Errorf("binary content = %q, want it unchanged", content)
+	}
+	version, _ := os.ReadFile(VersionPath(binaryPath))
+	if string(version) != "v1.1.0" {
+		t.Errorf("recorded version = %q, want v1.1.0", version)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(binaryPath))
+	if len(entries) != 2 {
+		t.Errorf("expected the partial download to be removed, got %v", entries)
+	}
+}
+
+func TestReconcile_ChecksumMismatchKeepsBinary(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "edge-cd-go")
+	os.WriteFile(binaryPath, []byte("old binary"), 0o755)
+	os.WriteFile(VersionPath(binaryPath), []byte("v1.1.0"), 0o644)
+
+	spec := newTestSpec(binaryPath, "v1.2.0")
+	spec.SHA256 = "0000000000000000000000000000000000000000000000000000000000000000"
+	u := NewUpdater(releaseDownloader())
+
+	updated, err := u.Reconcile(spec)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Reconcile() error = %v, want ErrChecksumMismatch", err)
+	}
+	if updated {
+		t.Error("Reconcile() updated = true, want false")
+	}
+
+	content, _ := os.ReadFile(binaryPath)
+	if string(content) != "old binary" {
+		t.Errorf("binary content = %q, want it unchanged", content)
+	}
+	version, _ := os.ReadFile(VersionPath(binaryPath))
+	if string(version) != "v1.1.0" {
+		t.Errorf("recorded version = %q, want v1.1.0", version)
+	}
+	if _, err := os.Stat(PrevPath(binaryPath)); !os.IsNotExist(err) {
+		t.Errorf("previous binary kept for a rejected download: %v", err)
+	}
+}
+
+func TestReconcile_ChecksumMatchSwapsBinary(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "edge-cd-go")
+	os.WriteFile(binaryPath, []byte("old binary"), 0o755)
+
+	spec := newTestSpec(binaryPath, "v1.2.0")
+	url, _ := RenderURL(spec.URLTemplate, URLParams{
+		Version: "v1.2.0", Channel: DefaultChannel, OS: goruntime.GOOS, Arch: goruntime.GOARCH,
+	})
+	sum := sha256.Sum256([]byte("binary from " + url))
+	spec.SHA256 = strings.ToUpper(hex.EncodeToString(sum[:]))
+	u := NewUpdater(releaseDownloader())
+
+	updated, err := u.Reconcile(spec)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if !updated {
+		t.Error("Reconcile() updated = false, want true")
+	}
+	content, _ := os.ReadFile(binaryPath)
+	if string(content) != "binary from "+url {
+		t.Errorf("binary content = %q, want the downloaded release", content)
+	}
+}
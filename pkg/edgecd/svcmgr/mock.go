@@ -2,14 +2,17 @@ package svcmgr
 
 // MockServiceManager is a mock implementation of ServiceManager for testing
 type MockServiceManager struct {
-	EnableFunc  func(serviceName string) error
-	RestartFunc func(serviceName string) error
-	StartFunc   func(serviceName string) error
+	EnableFunc   func(serviceName string) error
+	RestartFunc  func(serviceName string) error
+	StartFunc    func(serviceName string) error
+	IsActiveFunc func(serviceName string) (bool, error)
+	RebootFunc   func() error
 
 	// Track calls for verification
 	EnableCalls  []string
 	RestartCalls []string
 	StartCalls   []string
+	RebootCalls  int
 }
 
 // Enable calls the mock function if provided, otherwise returns nil
@@ -38,3 +41,20 @@ func (m *MockServiceManager) Start(serviceName string) error {
 	}
 	return nil
 }
+
+// IsActive calls the mock function if provided, otherwise returns true
+func (m *MockServiceManager) IsActive(serviceName string) (bool, error) {
+	if m.IsActiveFunc != nil {
+		return m.IsActiveFunc(serviceName)
+	}
+	return true, nil
+}
+
+// Reboot calls the mock function if provided, otherwise returns nil
+func (m *MockServiceManager) Reboot() error {
+	m.RebootCalls++
+	if m.RebootFunc != nil {
+		return m.RebootFunc()
+	}
+	return nil
+}
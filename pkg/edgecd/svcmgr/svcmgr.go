@@ -1,6 +1,7 @@
 package svcmgr
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -15,6 +16,10 @@ type ServiceManager interface {
 	Enable(serviceName string) error
 	Restart(serviceName string) error
 	Start(serviceName string) error
+	// IsActive reports whether the service is currently running
+	IsActive(serviceName string) (bool, error)
+	// Reboot reboots the device
+	Reboot() error
 }
 
 // serviceManager is the concrete implementation
@@ -29,15 +34,42 @@ type ServiceManagerConfig struct {
 		Enable  []string `yaml:"enable"`
 		Restart []string `yaml:"restart"`
 		Start   []string `yaml:"start,omitempty"`
+		// IsActive must exit 0 if the service is running and non-zero otherwise
+		IsActive []string `yaml:"isActive,omitempty"`
+		// Reboot reboots the device. Defaults to DefaultRebootCommand
+		Reboot []string `yaml:"reboot,omitempty"`
 	} `yaml:"commands"`
 	EdgeCDService struct {
 		DestinationPath string `yaml:"destinationPath"`
 	} `yaml:"edgeCDService"`
 }
 
+// DefaultRebootCommand reboots the device if the service manager does not
+// configure a reboot command.
+var DefaultRebootCommand = []string{"reboot"}
+
+// Option configures a ServiceManager.
+type Option func(*ServiceManagerConfig)
+
+// WithEscalation prefixes the commands changing the services with escalation,
+// e.g. []string{"sudo", "-n"}, when edge-cd runs as an unprivileged user.
+func WithEscalation(escalation []string) Option {
+	return func(c *ServiceManagerConfig) {
+		if len(escalation) == 0 {
+			return
+		}
+		for _, cmd := range []*[]string{&c.Commands.Enable, &c.Commands.Restart, &c.Commands.Start, &c.Commands.Reboot} {
+			// Commands already escalated, e.g. "sudo systemctl restart", are kept
+			if len(*cmd) > 0 && (*cmd)[0] != escalation[0] {
+				*cmd = append(append([]string{}, escalation...), *cmd...)
+			}
+		}
+	}
+}
+
 // NewServiceManager creates a new ServiceManager by loading configuration
 // from {edgeCDRepoPath}/cmd/edge-cd/service-managers/{name}/config.yaml
-func NewServiceManager(name string, edgeCDRepoPath string) (ServiceManager, error) {
+func NewServiceManager(name string, edgeCDRepoPath string, opts ...Option) (ServiceManager, error) {
 	// Load config from cmd/edge-cd/service-managers/{name}/config.yaml
 	configPath := fmt.Sprintf("%s/cmd/edge-cd/service-managers/%s/config.yaml", edgeCDRepoPath, name)
 	data, err := os.ReadFile(configPath)
@@ -49,6 +81,12 @@ func NewServiceManager(name string, edgeCDRepoPath string) (ServiceManager, erro
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse service manager config: %w", err)
 	}
+	if len(config.Commands.Reboot) == 0 {
+		config.Commands.Reboot = DefaultRebootCommand
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
 
 	return &serviceManager{
 		name:   name,
@@ -107,6 +145,46 @@ func (sm *serviceManager) Start(serviceName string) error {
 	return nil
 }
 
+// IsActive runs the isActive command and reports whether the service is running.
+// A non-zero exit code means the service is not active.
+func (sm *serviceManager) IsActive(serviceName string) (bool, error) {
+	if len(sm.config.Commands.IsActive) == 0 {
+		return false, fmt.Errorf("isActive command not configured for service manager %s", sm.name)
+	}
+
+	cmdArgs := sm.replaceServiceName(sm.config.Commands.IsActive, serviceName)
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return false, nil
+		}
+		slog.Error("Service status check failed", "service", serviceName, "error", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Reboot runs the reboot command. It returns once the reboot is scheduled,
+// before the device goes down.
+func (sm *serviceManager) Reboot() error {
+	slog.Info("Rebooting device", "serviceManager", sm.name)
+
+	if len(sm.config.Commands.Reboot) == 0 {
+		return fmt.Errorf("reboot command not configured for service manager %s", sm.name)
+	}
+
+	cmd := exec.Command(sm.config.Commands.Reboot[0], sm.config.Commands.Reboot[1:]...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		slog.Error("Reboot failed", "error", err, "output", strings.TrimSpace(string(out)))
+		return fmt.Errorf("failed to reboot: %w", err)
+	}
+
+	return nil
+}
+
 // replaceServiceName replaces __SERVICE_NAME__ placeholder in command templates
 func (sm *serviceManager) replaceServiceName(cmdTemplate []string, serviceName string) []string {
 	result := make([]string, len(cmdTemplate))
@@ -97,6 +97,42 @@ func TestNewServiceManager_Procd(t *testing.T) {
 	}
 }
 
+func TestNewServiceManager_Escalation(t *testing.T) {
+	repoRoot := findRepoRoot(t)
+
+	sm, err := NewServiceManager("systemd", repoRoot, WithEscalation([]string{"sudo", "-n"}))
+	if err != nil {
+		t.Fatalf("NewServiceManager(systemd) failed: %v", err)
+	}
+	concrete := sm.(*serviceManager)
+
+	expectedRestart := []string{"sudo", "-n", "systemctl", "restart", "__SERVICE_NAME__"}
+	if !slicesEqual(concrete.config.Commands.Restart, expectedRestart) {
+		t.Errorf("Expected restart=%v, got %v", expectedRestart, concrete.config.Commands.Restart)
+	}
+
+	// Checking the status needs no privileges
+	expectedIsActive := []string{"systemctl", "is-active", "--quiet", "__SERVICE_NAME__"}
+	if !slicesEqual(concrete.config.Commands.IsActive, expectedIsActive) {
+		t.Errorf("Expected isActive=%v, got %v", expectedIsActive, concrete.config.Commands.IsActive)
+	}
+}
+
+func TestWithEscalation_KeepsEscalatedCommands(t *testing.T) {
+	var config ServiceManagerConfig
+	config.Commands.Restart = []string{"systemctl", "restart", "__SERVICE_NAME__"}
+	config.Commands.Reboot = []string{"sudo", "reboot"}
+
+	WithEscalation([]string{"sudo", "-n"})(&config)
+
+	if want := []string{"sudo", "-n", "systemctl", "restart", "__SERVICE_NAME__"}; !slicesEqual(config.Commands.Restart, want) {
+		t.Errorf("Expected restart=%v, got %v", want, config.Commands.Restart)
+	}
+	if want := []string{"sudo", "reboot"}; !slicesEqual(config.Commands.Reboot, want) {
+		t.Errorf("Expected reboot=%v, got %v", want, config.Commands.Reboot)
+	}
+}
+
 func TestNewServiceManager_UnknownManager(t *testing.T) {
 	repoRoot := findRepoRoot(t)
 
@@ -187,3 +223,95 @@ func slicesEqual(a, b []string) bool {
 	}
 	return true
 }
+
+func TestIsActive(t *testing.T) {
+	tests := []struct {
+		name       string
+		isActive   []string
+		wantActive bool
+		wantErr    bool
+	}{
+		{name: "running", isActive: []string{"true"}, wantActive: true},
+		{name: "not running", isActive: []string{"false"}, wantActive: false},
+		{name: "not configured", isActive: nil, wantErr: true},
+		{name: "command not found", isActive: []string{"/nonexistent/__SERVICE_NAME__"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &serviceManager{name: "test", config: &ServiceManagerConfig{}}
+			sm.config.Commands.IsActive = tt.isActive
+
+			active, err := sm.IsActive("nginx")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IsActive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if active != tt.wantActive {
+				t.Errorf("IsActive() = %v, want %v", active, tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestNewServiceManager_IsActiveCommands(t *testing.T) {
+	repoRoot := findRepoRoot(t)
+
+	expected := map[string][]string{
+		"systemd": {"systemctl", "is-active", "--quiet", "__SERVICE_NAME__"},
+		"procd":   {"/etc/init.d/__SERVICE_NAME__", "running"},
+	}
+
+	for name, want := range expected {
+		sm, err := NewServiceManager(name, repoRoot)
+		if err != nil {
+			t.Fatalf("NewServiceManager(%s) failed: %v", name, err)
+		}
+		got := sm.(*serviceManager).config.Commands.IsActive
+		if !slicesEqual(got, want) {
+			t.Errorf("%s: expected isActive=%v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestReboot(t *testing.T) {
+	tests := []struct {
+		name    string
+		reboot  []string
+		wantErr bool
+	}{
+		{name: "rebooted", reboot: []string{"true"}},
+		{name: "failed", reboot: []string{"false"}, wantErr: true},
+		{name: "not configured", reboot: nil, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &serviceManager{name: "test", config: &ServiceManagerConfig{}}
+			sm.config.Commands.Reboot = tt.reboot
+
+			if err := sm.Reboot(); (err != nil) != tt.wantErr {
+				t.Fatalf("Reboot() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewServiceManager_RebootCommands(t *testing.T) {
+	repoRoot := findRepoRoot(t)
+
+	expected := map[string][]string{
+		"systemd": {"sudo", "-n", "systemctl", "reboot"},
+		"procd":   {"sudo", "-n", "reboot"},
+	}
+
+	for name, want := range expected {
+		sm, err := NewServiceManager(name, repoRoot, WithEscalation([]string{"sudo", "-n"}))
+		if err != nil {
+			t.Fatalf("NewServiceManager(%s) failed: %v", name, err)
+		}
+		got := sm.(*serviceManager).config.Commands.Reboot
+		if !slicesEqual(got, want) {
+			t.Errorf("%s: expected reboot=%v, got %v", name, want, got)
+		}
+	}
+}
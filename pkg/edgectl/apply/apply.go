@@ -0,0 +1,52 @@
+package apply
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/runtime"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errNoPaths    = errors.New("no path to apply")
+	errRunApply   = errors.New("failed to run apply command on target")
+	errParseApply = errors.New("failed to parse apply result returned by target")
+)
+
+// Run reconciles the files at paths on a device over SSH by running
+// "<edgeCDBinary> --apply <paths>" on the target, and returns the parsed result.
+// Only the file specs managing paths are reconciled, and only their services restarted.
+// If the reconciliation fails, the result, when the target returned one, is
+// returned with the error.
+// The context must carry the environment edge-cd needs to load its configuration (e.g., CONFIG_PATH).
+func Run(
+	execCtx execcontext.Context,
+	runner ssh.Runner,
+	edgeCDBinary string,
+	paths []string,
+) (*runtime.Result, error) {
+	if len(paths) == 0 {
+		return nil, errNoPaths
+	}
+
+	stdout, stderr, runErr := runner.Run(execCtx, edgeCDBinary, "--apply", strings.Join(paths, ","))
+
+	var result runtime.Result
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		if runErr != nil {
+			return nil, flaterrors.Join(runErr, fmt.Errorf("stderr=%s", stderr), errRunApply)
+		}
+		return nil, flaterrors.Join(err, fmt.Errorf("stdout=%s", stdout), errParseApply)
+	}
+
+	if runErr != nil {
+		return &result, flaterrors.Join(runErr, fmt.Errorf("stderr=%s", stderr), errRunApply)
+	}
+
+	return &result, nil
+}
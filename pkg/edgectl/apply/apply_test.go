@@ -0,0 +1,70 @@
+package apply_test
+
+import (
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/apply"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	ctx := execcontext.New(map[string]string{"CONFIG_PATH": "devices/router-1"}, []string{"sudo", "-E"})
+	paths := []string{"/etc/motd", "/etc/app/app.conf"}
+	expectedCmd := execcontext.FormatCmd(ctx, "edge-cd-go", "--apply", "/etc/motd,/etc/app/app.conf")
+
+	t.Run("should parse the result returned by target", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(expectedCmd, `{
+  "hostname": "router-1",
+  "time": "2025-01-01T00:00:00Z",
+  "configChanged": false,
+  "servicesRestarted": ["app"],
+  "reboot": false,
+  "fileChanges": {"content": 2}
+}`, "", nil)
+
+		result, err := apply.Run(ctx, mock, "edge-cd-go", paths)
+		require.NoError(t, err)
+		require.NoError(t, mock.AssertCommandRun(expectedCmd))
+
+		assert.Equal(t, "router-1", result.Hostname)
+		assert.Equal(t, []string{"app"}, result.ServicesRestarted)
+		assert.Equal(t, 2, result.FileChanges["content"])
+	})
+
+	t.Run("should return the result with the error when the reconciliation fails", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(expectedCmd, `{"hostname": "router-1", "errors": ["restart app: exit status 1"]}`, "", assert.AnError)
+
+		result, err := apply.Run(ctx, mock, "edge-cd-go", paths)
+		assert.Error(t, err)
+		require.NotNil(t, result)
+		assert.Equal(t, []string{"restart app: exit status 1"}, result.Errors)
+	})
+
+	t.Run("should fail when the command fails", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(expectedCmd, "", "edge-cd-go: not found", assert.AnError)
+
+		_, err := apply.Run(ctx, mock, "edge-cd-go", paths)
+		assert.Error(t, err)
+	})
+
+	t.Run("should fail on invalid JSON", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(expectedCmd, "not json", "", nil)
+
+		_, err := apply.Run(ctx, mock, "edge-cd-go", paths)
+		assert.Error(t, err)
+	})
+
+	t.Run("should fail without paths", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+
+		_, err := apply.Run(ctx, mock, "edge-cd-go", nil)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,188 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+// LibvirtSocket is the socket of the system libvirt daemon the e2e VMs are
+// created with (qemu:///system).
+const LibvirtSocket = "/var/run/libvirt/libvirt-sock"
+
+// Tool is an external prerequisite of a command: a binary looked up in PATH,
+// or a daemon listening on a socket.
+type Tool struct {
+	// Name is the binary looked up in PATH, or the name of the daemon
+	Name string
+	// VersionArgs print the version of the binary, e.g. ["--version"]. The
+	// version is not reported if empty
+	VersionArgs []string
+	// Socket, if set, makes the tool a daemon that is present if the socket exists
+	Socket string
+	// Remediation tells how to install the missing tool
+	Remediation string
+}
+
+var (
+	toolGit = Tool{Name: "git", VersionArgs: []string{"--version"},
+		Remediation: "install git, e.g. apt-get install git"}
+	toolGo = Tool{Name: "go", VersionArgs: []string{"version"},
+		Remediation: "install Go from https://go.dev/dl/"}
+	toolSSHKeygen = Tool{Name: "ssh-keygen",
+		Remediation: "install the OpenSSH client, e.g. apt-get install openssh-client"}
+	toolWget = Tool{Name: "wget", VersionArgs: []string{"--version"},
+		Remediation: "install wget, e.g. apt-get install wget"}
+	toolXorriso = Tool{Name: "xorriso", VersionArgs: []string{"-version"},
+		Remediation: "install xorriso, e.g. apt-get install xorriso"}
+	toolQemuImg = Tool{Name: "qemu-img", VersionArgs: []string{"--version"},
+		Remediation: "install qemu-img, e.g. apt-get install qemu-utils"}
+	toolLibvirt = Tool{Name: "libvirtd", Socket: LibvirtSocket,
+		Remediation: "install and start libvirt, e.g. apt-get install libvirt-daemon-system && systemctl start libvirtd, and add your user to the libvirt group"}
+)
+
+// Requirements are the tools each command needs on the local host. The commands
+// talking to a device over SSH only, e.g. inventory, need none.
+var Requirements = map[string][]Tool{
+	"bootstrap": {toolGit},
+	"e2e":       {toolGit, toolGo, toolSSHKeygen, toolWget, toolXorriso, toolQemuImg, toolLibvirt},
+}
+
+// Commands returns the sorted commands with requirements.
+func Commands() []string {
+	commands := make([]string, 0, len(Requirements))
+	for command := range Requirements {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+// ToolsFor returns the tools commands need, each listed once, or the tools of
+// all the commands if commands is empty.
+func ToolsFor(commands ...string) ([]Tool, error) {
+	if len(commands) == 0 {
+		commands = Commands()
+	}
+
+	var tools []Tool
+	seen := make(map[string]bool)
+	for _, command := range commands {
+		required, ok := Requirements[command]
+		if !ok {
+			return nil, fmt.Errorf("unknown command %q, expected one of: %s", command, strings.Join(Commands(), ", "))
+		}
+		for _, tool := range required {
+			if !seen[tool.Name] {
+				seen[tool.Name] = true
+				tools = append(tools, tool)
+			}
+		}
+	}
+	return tools, nil
+}
+
+// Result is the outcome of the check of a Tool.
+type Result struct {
+	Tool Tool
+	// Path is where the binary or the socket was found
+	Path string
+	// Version is the first line printed by the version command, if any
+	Version string
+	// Err is why the tool is missing, nil if present
+	Err error
+}
+
+// Missing reports whether the tool was not found.
+func (r Result) Missing() bool {
+	return r.Err != nil
+}
+
+// Checker checks the tools on the local host. Its functions are replaced in
+// tests to fake the host.
+type Checker struct {
+	LookPath func(file string) (string, error)
+	Output   func(name string, args ...string) ([]byte, error)
+	Stat     func(name string) (os.FileInfo, error)
+}
+
+// NewChecker returns a Checker of the local host.
+func NewChecker() *Checker {
+	return &Checker{
+		LookPath: exec.LookPath,
+		Output: func(name string, args ...string) ([]byte, error) {
+			return exec.Command(name, args...).CombinedOutput()
+		},
+		Stat: os.Stat,
+	}
+}
+
+// Check checks each tool, in order.
+func (c *Checker) Check(tools []Tool) []Result {
+	results := make([]Result, 0, len(tools))
+	for _, tool := range tools {
+		results = append(results, c.check(tool))
+	}
+	return results
+}
+
+func (c *Checker) check(tool Tool) Result {
+	result := Result{Tool: tool}
+
+	if tool.Socket != "" {
+		if _, err := c.Stat(tool.Socket); err != nil {
+			result.Err = fmt.Errorf("socket %s not found: %w", tool.Socket, err)
+			return result
+		}
+		result.Path = tool.Socket
+		return result
+	}
+
+	path, err := c.LookPath(tool.Name)
+	if err != nil {
+		result.Err = fmt.Errorf("not found in PATH: %w", err)
+		return result
+	}
+	result.Path = path
+
+	if len(tool.VersionArgs) > 0 {
+		// A binary printing no version is still usable
+		if out, err := c.Output(path, tool.VersionArgs...); err == nil {
+			result.Version, _, _ = strings.Cut(strings.TrimSpace(string(out)), "\n")
+		}
+	}
+	return result
+}
+
+// ErrMissingTools is returned by Report when a tool is missing.
+var ErrMissingTools = errors.New("missing prerequisites")
+
+// Report writes one line per result to w, with the remediation of the missing
+// tools, and returns ErrMissingTools if a tool is missing.
+func Report(w io.Writer, results []Result) error {
+	var missing []string
+	for _, r := range results {
+		if r.Missing() {
+			missing = append(missing, r.Tool.Name)
+			fmt.Fprintf(w, "[MISSING] %s: %v\n          %s\n", r.Tool.Name, r.Err, r.Tool.Remediation)
+			continue
+		}
+
+		version := r.Version
+		if version == "" {
+			version = "version unknown"
+		}
+		fmt.Fprintf(w, "[OK]      %s: %s (%s)\n", r.Tool.Name, version, r.Path)
+	}
+
+	if len(missing) > 0 {
+		return flaterrors.Join(fmt.Errorf("missing=%s", strings.Join(missing, ",")), ErrMissingTools)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package doctor_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/doctor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeChecker returns a Checker of a host with the binaries of installed and
+// the sockets of sockets.
+func fakeChecker(installed map[string]string, sockets ...string) *doctor.Checker {
+	return &doctor.Checker{
+		LookPath: func(file string) (string, error) {
+			if _, ok := installed[file]; ok {
+				return "/usr/bin/" + file, nil
+			}
+			return "", exec.ErrNotFound
+		},
+		Output: func(name string, args ...string) ([]byte, error) {
+			for file, version := range installed {
+				if name == "/usr/bin/"+file {
+					return []byte(version + "\nmore details\n"), nil
+				}
+			}
+			return nil, errors.New("unexpected command " + name)
+		},
+		Stat: func(name string) (os.FileInfo, error) {
+			for _, socket := range sockets {
+				if name == socket {
+					return nil, nil
+				}
+			}
+			return nil, fs.ErrNotExist
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tools, err := doctor.ToolsFor("e2e")
+	require.NoError(t, err)
+
+	checker := fakeChecker(map[string]string{
+		"git":        "git version 2.43.0",
+		"go":         "go version go1.24.1 linux/amd64",
+		"ssh-keygen": "",
+		"qemu-img":   "qemu-img version 8.2.2",
+	}, doctor.LibvirtSocket)
+
+	results := checker.Check(tools)
+	require.Len(t, results, len(tools))
+
+	byName := make(map[string]doctor.Result)
+	for _, r := range results {
+		byName[r.Tool.Name] = r
+	}
+
+	t.Run("present tools pass with their version", func(t *testing.T) {
+		for _, name := range []string{"git", "go", "ssh-keygen", "qemu-img", "libvirtd"} {
+			assert.False(t, byName[name].Missing(), "%s should be present: %v", name, byName[name].Err)
+		}
+		assert.Equal(t, "git version 2.43.0", byName["git"].Version)
+		assert.Equal(t, "/usr/bin/git", byName["git"].Path)
+		assert.Equal(t, doctor.LibvirtSocket, byName["libvirtd"].Path)
+	})
+
+	t.Run("missing tools are reported", func(t *testing.T) {
+		for _, name := range []string{"wget", "xorriso"} {
+			assert.True(t, byName[name].Missing(), "%s should be missing", name)
+			assert.ErrorIs(t, byName[name].Err, exec.ErrNotFound)
+		}
+	})
+
+	t.Run("report flags the missing tools with their remediation", func(t *testing.T) {
+		var out bytes.Buffer
+		err := doctor.Report(&out, results)
+		assert.ErrorIs(t, err, doctor.ErrMissingTools)
+		assert.Contains(t, err.Error(), "wget,xorriso")
+		assert.Contains(t, out.String(), "[MISSING] xorriso")
+		assert.Contains(t, out.String(), "apt-get install xorriso")
+		assert.Contains(t, out.String(), "[OK]      git: git version 2.43.0 (/usr/bin/git)")
+	})
+}
+
+func TestCheck_MissingDaemon(t *testing.T) {
+	tools, err := doctor.ToolsFor("e2e")
+	require.NoError(t, err)
+
+	for _, r := range fakeChecker(nil).Check(tools) {
+		if r.Tool.Name == "libvirtd" {
+			assert.True(t, r.Missing())
+			assert.ErrorIs(t, r.Err, fs.ErrNotExist)
+		}
+	}
+}
+
+func TestToolsFor(t *testing.T) {
+	t.Run("per command", func(t *testing.T) {
+		tools, err := doctor.ToolsFor("bootstrap")
+		require.NoError(t, err)
+		require.Len(t, tools, 1)
+		assert.Equal(t, "git", tools[0].Name)
+	})
+
+	t.Run("all commands list each tool once", func(t *testing.T) {
+		tools, err := doctor.ToolsFor()
+		require.NoError(t, err)
+		seen := make(map[string]bool)
+		for _, tool := range tools {
+			assert.False(t, seen[tool.Name], "%s listed twice", tool.Name)
+			seen[tool.Name] = true
+		}
+		assert.True(t, seen["git"])
+		assert.True(t, seen["xorriso"])
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		_, err := doctor.ToolsFor("unknown")
+		assert.Error(t, err)
+	})
+}
+
+func TestReport_AllPresent(t *testing.T) {
+	results := fakeChecker(map[string]string{"git": "git version 2.43.0"}).Check([]doctor.Tool{{Name: "git", VersionArgs: []string{"--version"}}})
+
+	var out bytes.Buffer
+	assert.NoError(t, doctor.Report(&out, results))
+}
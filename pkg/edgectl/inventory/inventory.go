@@ -0,0 +1,38 @@
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	edgecdinventory "github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/inventory"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errRunInventory   = errors.New("failed to run inventory command on target")
+	errParseInventory = errors.New("failed to parse inventory returned by target")
+)
+
+// Fetch gathers the inventory of a device over SSH by running
+// "<edgeCDBinary> --inventory" on the target and parsing its JSON output.
+// The context must carry the environment edge-cd needs to load its configuration (e.g., CONFIG_PATH).
+func Fetch(
+	execCtx execcontext.Context,
+	runner ssh.Runner,
+	edgeCDBinary string,
+) (*edgecdinventory.Inventory, error) {
+	stdout, stderr, err := runner.Run(execCtx, edgeCDBinary, "--inventory")
+	if err != nil {
+		return nil, flaterrors.Join(err, fmt.Errorf("stderr=%s", stderr), errRunInventory)
+	}
+
+	var inv edgecdinventory.Inventory
+	if err := json.Unmarshal([]byte(stdout), &inv); err != nil {
+		return nil, flaterrors.Join(err, fmt.Errorf("stdout=%s", stdout), errParseInventory)
+	}
+
+	return &inv, nil
+}
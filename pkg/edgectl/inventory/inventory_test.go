@@ -0,0 +1,52 @@
+package inventory_test
+
+import (
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/inventory"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetch(t *testing.T) {
+	ctx := execcontext.New(map[string]string{"CONFIG_PATH": "devices/router-1"}, []string{"sudo", "-E"})
+	expectedCmd := execcontext.FormatCmd(ctx, "edge-cd-go", "--inventory")
+
+	t.Run("should parse inventory returned by target", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(expectedCmd, `{
+  "hostname": "router-1",
+  "config": {"url": "https://github.com/test/config.git", "path": "/usr/local/src/edge-cd-config", "headCommit": "abc", "appliedCommit": "abc"},
+  "packages": {"git": "2.43.0"},
+  "services": [{"name": "edge-cd", "active": true}]
+}`, "", nil)
+
+		inv, err := inventory.Fetch(ctx, mock, "edge-cd-go")
+		require.NoError(t, err)
+		require.NoError(t, mock.AssertCommandRun(expectedCmd))
+
+		assert.Equal(t, "router-1", inv.Hostname)
+		assert.Equal(t, "abc", inv.Config.AppliedCommit)
+		assert.Equal(t, "2.43.0", inv.Packages["git"])
+		require.Len(t, inv.Services, 1)
+		assert.True(t, inv.Services[0].Active)
+	})
+
+	t.Run("should fail when the command fails", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(expectedCmd, "", "edge-cd-go: not found", assert.AnError)
+
+		_, err := inventory.Fetch(ctx, mock, "edge-cd-go")
+		assert.Error(t, err)
+	})
+
+	t.Run("should fail on invalid JSON", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(expectedCmd, "not json", "", nil)
+
+		_, err := inventory.Fetch(ctx, mock, "edge-cd-go")
+		assert.Error(t, err)
+	})
+}
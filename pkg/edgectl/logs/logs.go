@@ -0,0 +1,93 @@
+package logs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+// LogFilePath is the file the edge-cd service logs to on the target.
+const LogFilePath = "/var/log/edge-cd.log"
+
+// DefaultLines is the number of lines printed when Options.Lines is not set.
+const DefaultLines = 100
+
+var (
+	errInvalidLines = errors.New("lines must not be negative")
+	errStreamLogs   = errors.New("failed to stream edge-cd logs from target")
+)
+
+// Options configures Stream.
+type Options struct {
+	// Follow keeps streaming new log lines until the connection is closed
+	Follow bool
+	// Lines is the number of last lines to print first. Zero means all the lines
+	Lines int
+}
+
+// Stream writes the logs of the edge-cd service on the target to stdout, and
+// the errors of the remote command to stderr.
+//
+// The logs are read from LogFilePath if it exists and is not empty, and from
+// the journal of the edge-cd unit otherwise.
+func Stream(
+	execCtx execcontext.Context,
+	runner ssh.StreamRunner,
+	stdout, stderr io.Writer,
+	opts Options,
+) error {
+	cmd, err := Command(execCtx, runner, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := runner.Stream(execCtx, stdout, stderr, cmd...); err != nil {
+		return flaterrors.Join(err, fmt.Errorf("cmd=%v", cmd), errStreamLogs)
+	}
+	return nil
+}
+
+// Command returns the command Stream runs on the target to print the logs of
+// the edge-cd service, for callers running it themselves.
+func Command(execCtx execcontext.Context, runner ssh.Runner, opts Options) ([]string, error) {
+	if opts.Lines < 0 {
+		return nil, flaterrors.Join(fmt.Errorf("lines=%d", opts.Lines), errInvalidLines)
+	}
+
+	if _, _, err := runner.Run(execCtx, "test", "-s", LogFilePath); err == nil {
+		return tailCmd(opts), nil
+	}
+	return journalctlCmd(opts), nil
+}
+
+// tailCmd returns the command printing the logs of LogFilePath.
+func tailCmd(opts Options) []string {
+	lines := "+1" // from the first line
+	if opts.Lines > 0 {
+		lines = strconv.Itoa(opts.Lines)
+	}
+
+	cmd := []string{"tail", "-n", lines}
+	if opts.Follow {
+		// -F keeps following the file if it is rotated
+		cmd = append(cmd, "-F")
+	}
+	return append(cmd, LogFilePath)
+}
+
+// journalctlCmd returns the command printing the logs of the edge-cd unit.
+func journalctlCmd(opts Options) []string {
+	cmd := []string{"journalctl", "-u", "edge-cd", "--no-pager"}
+	if opts.Lines > 0 {
+		cmd = append(cmd, "-n", strconv.Itoa(opts.Lines))
+	}
+	if opts.Follow {
+		cmd = append(cmd, "-f")
+	}
+	return cmd
+}
@@ -0,0 +1,81 @@
+package logs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/logs"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	ctx := execcontext.New(map[string]string{}, []string{"sudo"})
+	logFileCheck := execcontext.FormatCmd(ctx, "test", "-s", logs.LogFilePath)
+	canned := "time=2025-01-01T00:00:00Z level=INFO msg=\"Reconciling files\"\n" +
+		"time=2025-01-01T00:00:01Z level=INFO msg=Sleeping seconds=60\n"
+
+	t.Run("should print the last lines of the log file", func(t *testing.T) {
+		tail := execcontext.FormatCmd(ctx, "tail", "-n", "50", logs.LogFilePath)
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(tail, canned, "", nil)
+
+		var stdout, stderr bytes.Buffer
+		err := logs.Stream(ctx, mock, &stdout, &stderr, logs.Options{Lines: 50})
+
+		require.NoError(t, err)
+		assert.Equal(t, canned, stdout.String())
+		assert.Equal(t, []string{logFileCheck, tail}, mock.Commands)
+	})
+
+	t.Run("should follow the log file", func(t *testing.T) {
+		tail := execcontext.FormatCmd(ctx, "tail", "-n", "+1", "-F", logs.LogFilePath)
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(tail, canned, "", nil)
+
+		var stdout, stderr bytes.Buffer
+		err := logs.Stream(ctx, mock, &stdout, &stderr, logs.Options{Follow: true})
+
+		require.NoError(t, err)
+		assert.Equal(t, canned, stdout.String())
+		require.NoError(t, mock.AssertCommandRun(tail))
+	})
+
+	t.Run("should fall back to the journal without a log file", func(t *testing.T) {
+		journal := execcontext.FormatCmd(ctx, "journalctl", "-u", "edge-cd", "--no-pager", "-n", "10", "-f")
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(logFileCheck, "", "", assert.AnError)
+		mock.SetResponse(journal, canned, "", nil)
+
+		var stdout, stderr bytes.Buffer
+		err := logs.Stream(ctx, mock, &stdout, &stderr, logs.Options{Follow: true, Lines: 10})
+
+		require.NoError(t, err)
+		assert.Equal(t, canned, stdout.String())
+		assert.Equal(t, []string{logFileCheck, journal}, mock.Commands)
+	})
+
+	t.Run("should fail when the logs cannot be read", func(t *testing.T) {
+		journal := execcontext.FormatCmd(ctx, "journalctl", "-u", "edge-cd", "--no-pager")
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(logFileCheck, "", "", assert.AnError)
+		mock.SetResponse(journal, "", "No journal files were found.", assert.AnError)
+
+		var stdout, stderr bytes.Buffer
+		err := logs.Stream(ctx, mock, &stdout, &stderr, logs.Options{})
+
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Equal(t, "No journal files were found.", stderr.String())
+	})
+
+	t.Run("should reject negative lines", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+
+		err := logs.Stream(ctx, mock, &bytes.Buffer{}, &bytes.Buffer{}, logs.Options{Lines: -1})
+
+		assert.Error(t, err)
+		assert.Empty(t, mock.Commands)
+	})
+}
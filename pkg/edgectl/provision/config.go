@@ -23,6 +23,9 @@ var (
 	errReadLocalConfig      = errors.New("failed to read local config file")
 	errUnmarshalConfig      = errors.New("failed to unmarshal config")
 	errMarshalConfig        = errors.New("failed to marshal config")
+	errWriteRenderedFile    = errors.New("failed to write rendered file")
+	errInvalidConfig        = errors.New("config placed on the target is invalid")
+	errRollbackConfig       = errors.New("failed to remove invalid config")
 )
 
 const configTemplate = `
@@ -116,6 +119,59 @@ func PlaceConfigYAML(
 	return nil
 }
 
+// ConfigValidation describes how to validate the config placed on the device
+// before its edge-cd service is enabled. Validation is disabled if Command is empty.
+type ConfigValidation struct {
+	// Command validates the config at its last argument, e.g. []string{"edge-cd-go", "--validate"}
+	Command []string
+	// ConfigDestPath is where the config was placed by PlaceConfigYAML
+	ConfigDestPath string
+}
+
+// ValidateConfig runs the validation command against the config placed on the
+// device. If the config is invalid, it is removed so that the device is not left
+// with a config the edge-cd service crash loops on.
+func ValidateConfig(
+	execCtx execcontext.Context,
+	runner ssh.Runner,
+	validation ConfigValidation,
+) error {
+	if len(validation.Command) == 0 {
+		return nil
+	}
+
+	cmd := append(append([]string{}, validation.Command...), validation.ConfigDestPath)
+	stdout, stderr, err := runner.Run(execCtx, cmd...)
+	if err == nil {
+		return nil
+	}
+	err = flaterrors.Join(err,
+		fmt.Errorf("destPath=%s stdout=%s stderr=%s", validation.ConfigDestPath, stdout, stderr),
+		errInvalidConfig)
+
+	if rmStdout, rmStderr, rmErr := runner.Run(execCtx, "rm", "-f", validation.ConfigDestPath); rmErr != nil {
+		return flaterrors.Join(err, rmErr,
+			fmt.Errorf("stdout=%s stderr=%s", rmStdout, rmStderr), errRollbackConfig)
+	}
+	return err
+}
+
+// WriteRenderedFile writes content, e.g. the rendered config.yaml, to the file
+// name in the local directory outputDir, creating it if needed, so that the
+// operator can review what is placed on the device.
+func WriteRenderedFile(outputDir, name, content string) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return flaterrors.Join(err, fmt.Errorf("outputDir=%s", outputDir), errWriteRenderedFile)
+	}
+
+	path := filepath.Join(outputDir, name)
+	// The config may hold credentials, e.g. notify.authHeader
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return flaterrors.Join(err, fmt.Errorf("path=%s", path), errWriteRenderedFile)
+	}
+	return nil
+}
+
 // ReadLocalConfig reads a configuration file from the local filesystem.
 func ReadLocalConfig(configPath, configSpec string) (string, error) {
 	fullPath := filepath.Join(configPath, configSpec)
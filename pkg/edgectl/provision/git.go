@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
@@ -106,3 +107,27 @@ func CloneOrPullRepo(
 
 	return nil
 }
+
+// GitSSHCommand returns the GIT_SSH_COMMAND authenticating with the private key
+// at keyPath, if not empty, and passing each of opts, e.g.
+// "StrictHostKeyChecking=yes", as a "-o" option of ssh. The arguments are
+// quoted as needed, since git runs the command with a shell.
+func GitSSHCommand(keyPath string, opts []string) string {
+	args := []string{"ssh"}
+	if keyPath != "" {
+		args = append(args, "-i", shellArg(keyPath))
+	}
+	for _, opt := range opts {
+		args = append(args, "-o", shellArg(opt))
+	}
+	return strings.Join(args, " ")
+}
+
+// shellArg single-quotes s for a POSIX shell unless it only holds characters
+// the shell does not interpret.
+func shellArg(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,50 @@
+package provision_test
+
+import (
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/provision"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitSSHCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyPath string
+		opts    []string
+		want    string
+	}{
+		{
+			name: "no key nor options",
+			want: "ssh",
+		},
+		{
+			name:    "key only",
+			keyPath: "/home/ubuntu/.ssh/id_ed25519",
+			want:    "ssh -i /home/ubuntu/.ssh/id_ed25519",
+		},
+		{
+			name:    "key and options",
+			keyPath: "/home/ubuntu/.ssh/id_ed25519",
+			opts:    []string{"StrictHostKeyChecking=no", "UserKnownHostsFile=/dev/null"},
+			want:    "ssh -i /home/ubuntu/.ssh/id_ed25519 -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null",
+		},
+		{
+			name: "strict host checking with a known_hosts",
+			opts: []string{"StrictHostKeyChecking=yes", "UserKnownHostsFile=/etc/edge-cd/known_hosts"},
+			want: "ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile=/etc/edge-cd/known_hosts",
+		},
+		{
+			name:    "arguments with shell characters are quoted",
+			keyPath: "/root/my keys/id_ed25519",
+			opts:    []string{"ProxyCommand=ssh -W %h:%p bastion", "User=o'brien"},
+			want:    `ssh -i '/root/my keys/id_ed25519' -o 'ProxyCommand=ssh -W %h:%p bastion' -o 'User=o'\''brien'`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, provision.GitSSHCommand(tt.keyPath, tt.opts))
+		})
+	}
+}
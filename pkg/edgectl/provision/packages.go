@@ -29,6 +29,7 @@ var (
 type PackageManager struct {
 	Update  []string `yaml:"update"`
 	Install []string `yaml:"install"`
+	Upgrade []string `yaml:"upgrade"`
 }
 
 // LoadPackageManager reads a package manager's configuration from a local path.
@@ -54,6 +55,7 @@ func LoadPackageManager(pkgMgr string, rootConfigsPath string) (*PackageManager,
 	return &PackageManager{
 		Update:  commands.Update,
 		Install: commands.Install,
+		Upgrade: commands.Upgrade,
 	}, nil
 }
 
@@ -82,39 +84,33 @@ func ProvisionPackages(
 		slog.Info("cloning edge-cd repository to remote with sparse checkout", "url", remoteEdgeCDRepoURL, "destPath", remoteEdgeCDRepoDestPath)
 
 		// git clone --filter=blob:none --no-checkout
-		stdout, stderr, cloneErr := runner.Run(execCtx, "git", "clone", "--filter=blob:none", "--no-checkout", remoteEdgeCDRepoURL, remoteEdgeCDRepoDestPath)
-		if cloneErr != nil {
-			return flaterrors.Join(cloneErr, fmt.Errorf("url=%s stdout=%s stderr=%s", remoteEdgeCDRepoURL, stdout, stderr), errCloneEdgeCDRepo)
+		if err := runStep(execCtx, runner, StepClone, "git", "clone", "--filter=blob:none", "--no-checkout", remoteEdgeCDRepoURL, remoteEdgeCDRepoDestPath); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("url=%s", remoteEdgeCDRepoURL), errCloneEdgeCDRepo)
 		}
 
 		// git sparse-checkout init
-		stdout, stderr, err = runner.Run(execCtx, "git", "-C", remoteEdgeCDRepoDestPath, "sparse-checkout", "init")
-		if err != nil {
-			return flaterrors.Join(err, fmt.Errorf("destPath=%s stdout=%s stderr=%s", remoteEdgeCDRepoDestPath, stdout, stderr), errCloneEdgeCDRepo)
+		if err := runStep(execCtx, runner, StepSparseCheckoutInit, "git", "-C", remoteEdgeCDRepoDestPath, "sparse-checkout", "init"); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("destPath=%s", remoteEdgeCDRepoDestPath), errCloneEdgeCDRepo)
 		}
 
 		// git sparse-checkout set "cmd/edge-cd"
-		stdout, stderr, err = runner.Run(execCtx, "git", "-C", remoteEdgeCDRepoDestPath, "sparse-checkout", "set", "cmd/edge-cd")
-		if err != nil {
-			return flaterrors.Join(err, fmt.Errorf("destPath=%s stdout=%s stderr=%s", remoteEdgeCDRepoDestPath, stdout, stderr), errCloneEdgeCDRepo)
+		if err := runStep(execCtx, runner, StepSparseCheckoutSet, "git", "-C", remoteEdgeCDRepoDestPath, "sparse-checkout", "set", "cmd/edge-cd"); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("destPath=%s", remoteEdgeCDRepoDestPath), errCloneEdgeCDRepo)
 		}
 
 		// git checkout main
-		stdout, stderr, err = runner.Run(execCtx, "git", "-C", remoteEdgeCDRepoDestPath, "checkout", "main")
-		if err != nil {
-			return flaterrors.Join(err, fmt.Errorf("destPath=%s stdout=%s stderr=%s", remoteEdgeCDRepoDestPath, stdout, stderr), errCloneEdgeCDRepo)
+		if err := runStep(execCtx, runner, StepCheckout, "git", "-C", remoteEdgeCDRepoDestPath, "checkout", "main"); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("destPath=%s", remoteEdgeCDRepoDestPath), errCloneEdgeCDRepo)
 		}
 
 		// git fetch origin main
-		stdout, stderr, err = runner.Run(execCtx, "git", "-C", remoteEdgeCDRepoDestPath, "fetch", "origin", "main")
-		if err != nil {
-			return flaterrors.Join(err, fmt.Errorf("destPath=%s stdout=%s stderr=%s", remoteEdgeCDRepoDestPath, stdout, stderr), errCloneEdgeCDRepo)
+		if err := runStep(execCtx, runner, StepFetch, "git", "-C", remoteEdgeCDRepoDestPath, "fetch", "origin", "main"); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("destPath=%s", remoteEdgeCDRepoDestPath), errCloneEdgeCDRepo)
 		}
 
 		// git pull (final sync after checkout)
-		stdout, stderr, err = runner.Run(execCtx, "git", "-C", remoteEdgeCDRepoDestPath, "pull")
-		if err != nil {
-			return flaterrors.Join(err, fmt.Errorf("destPath=%s stdout=%s stderr=%s", remoteEdgeCDRepoDestPath, stdout, stderr), errCloneEdgeCDRepo)
+		if err := runStep(execCtx, runner, StepPull, "git", "-C", remoteEdgeCDRepoDestPath, "pull"); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("destPath=%s", remoteEdgeCDRepoDestPath), errCloneEdgeCDRepo)
 		}
 
 		slog.Info("edge-cd repository cloned successfully with sparse checkout", "destPath", remoteEdgeCDRepoDestPath)
@@ -123,21 +119,18 @@ func ProvisionPackages(
 		slog.Info("edge-cd repository already exists, syncing latest changes", "destPath", remoteEdgeCDRepoDestPath)
 
 		// git sparse-checkout set "cmd/edge-cd"
-		stdout, stderr, err := runner.Run(execCtx, "git", "-C", remoteEdgeCDRepoDestPath, "sparse-checkout", "set", "cmd/edge-cd")
-		if err != nil {
-			return flaterrors.Join(err, fmt.Errorf("destPath=%s stdout=%s stderr=%s", remoteEdgeCDRepoDestPath, stdout, stderr), errCloneEdgeCDRepo)
+		if err := runStep(execCtx, runner, StepSparseCheckoutSet, "git", "-C", remoteEdgeCDRepoDestPath, "sparse-checkout", "set", "cmd/edge-cd"); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("destPath=%s", remoteEdgeCDRepoDestPath), errCloneEdgeCDRepo)
 		}
 
 		// git fetch origin main
-		stdout, stderr, err = runner.Run(execCtx, "git", "-C", remoteEdgeCDRepoDestPath, "fetch", "origin", "main")
-		if err != nil {
-			return flaterrors.Join(err, fmt.Errorf("destPath=%s stdout=%s stderr=%s", remoteEdgeCDRepoDestPath, stdout, stderr), errCloneEdgeCDRepo)
+		if err := runStep(execCtx, runner, StepFetch, "git", "-C", remoteEdgeCDRepoDestPath, "fetch", "origin", "main"); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("destPath=%s", remoteEdgeCDRepoDestPath), errCloneEdgeCDRepo)
 		}
 
 		// git reset --hard FETCH_HEAD (force update to match remote exactly)
-		stdout, stderr, err = runner.Run(execCtx, "git", "-C", remoteEdgeCDRepoDestPath, "reset", "--hard", "FETCH_HEAD")
-		if err != nil {
-			return flaterrors.Join(err, fmt.Errorf("destPath=%s stdout=%s stderr=%s", remoteEdgeCDRepoDestPath, stdout, stderr), errCloneEdgeCDRepo)
+		if err := runStep(execCtx, runner, StepReset, "git", "-C", remoteEdgeCDRepoDestPath, "reset", "--hard", "FETCH_HEAD"); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("destPath=%s", remoteEdgeCDRepoDestPath), errCloneEdgeCDRepo)
 		}
 
 		slog.Info("edge-cd repository synced successfully", "destPath", remoteEdgeCDRepoDestPath)
@@ -146,16 +139,16 @@ func ProvisionPackages(
 	// Update package manager repos once
 	if len(pm.Update) > 0 {
 		slog.Info("updating package manager", "packageManager", pkgMgr)
-		if stdout, stderr, updateErr := runner.Run(execCtx, pm.Update...); updateErr != nil {
-			return flaterrors.Join(updateErr, fmt.Errorf("stdout=%s stderr=%s", stdout, stderr), errUpdatePackageManager)
+		if err := runStep(execCtx, runner, StepUpdate, pm.Update...); err != nil {
+			return flaterrors.Join(err, errUpdatePackageManager)
 		}
 	}
 
 	// Install all packages in one command
 	if len(packages) > 0 {
 		slog.Info("installing packages", "packageManager", pkgMgr, "packages", packages)
-		if stdout, stderr, installErr := runner.Run(execCtx, append(pm.Install, packages...)...); installErr != nil {
-			return flaterrors.Join(installErr, fmt.Errorf("stdout=%s stderr=%s", stdout, stderr), errInstallPackages)
+		if err := runStep(execCtx, runner, StepInstall, append(pm.Install, packages...)...); err != nil {
+			return flaterrors.Join(err, errInstallPackages)
 		}
 	}
 
@@ -1,6 +1,7 @@
 package provision_test
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -185,4 +186,23 @@ install: ["apt-get", "install", "-y"]
 		// Both calls should succeed - proving idempotency
 		t.Log("✓ ProvisionPackages is idempotent: first call clones with sparse checkout, second call syncs with fetch+reset")
 	})
+
+	t.Run("should label the error of a failing fetch with its step and command", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		remoteEdgeCDRepoDestPath := "/usr/local/src/edge-cd"
+		ctx := execcontext.New(make(map[string]string), []string{"sudo"})
+
+		fetchCmd := []string{"git", "-C", remoteEdgeCDRepoDestPath, "fetch", "origin", "main"}
+		mock.SetResponse(execcontext.FormatCmd(ctx, fetchCmd...), "", "fatal: unable to access remote", assert.AnError)
+
+		err := provision.ProvisionPackages(ctx, mock, []string{"git"}, "apt", tmpDir, "https://github.com/alexandremahdhaoui/edge-cd.git", remoteEdgeCDRepoDestPath)
+		require.Error(t, err)
+
+		var stepErr *provision.StepError
+		require.True(t, errors.As(err, &stepErr), "error should carry a StepError: %v", err)
+		assert.Equal(t, provision.StepFetch, stepErr.Step)
+		assert.Equal(t, fetchCmd, stepErr.Command)
+		assert.Equal(t, "fatal: unable to access remote", stepErr.Stderr)
+		assert.ErrorIs(t, err, assert.AnError)
+	})
 }
@@ -0,0 +1,48 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+// ErrInsufficientPrivileges is returned by CheckPrivileges when the target user
+// can run neither privileged commands as root nor sudo without a password.
+var ErrInsufficientPrivileges = errors.New("target user cannot escalate privileges")
+
+var errCheckUID = errors.New("failed to get the uid of the target user")
+
+// CheckPrivileges verifies the commands run with execCtx will be able to install
+// packages and write system files, before anything is changed on the device.
+//
+// If execCtx prepends sudo, the target user must be able to run it without a
+// password prompt ("sudo -n true"). Otherwise the target user must be root.
+func CheckPrivileges(execCtx execcontext.Context, runner ssh.Runner) error {
+	// The checks run as the target user, without the prepended command
+	plainCtx := execcontext.New(nil, nil)
+
+	if prepend := execCtx.PrependCmd(); len(prepend) > 0 && prepend[0] == "sudo" {
+		if _, stderr, err := runner.Run(plainCtx, "sudo", "-n", "true"); err != nil {
+			return flaterrors.Join(
+				err,
+				fmt.Errorf("stderr=%s", strings.TrimSpace(stderr)),
+				errors.New("sudo -n true failed: sudo is missing or requires a password"),
+				ErrInsufficientPrivileges,
+			)
+		}
+		return nil
+	}
+
+	stdout, stderr, err := runner.Run(plainCtx, "id", "-u")
+	if err != nil {
+		return flaterrors.Join(err, fmt.Errorf("stderr=%s", strings.TrimSpace(stderr)), errCheckUID)
+	}
+	if uid := strings.TrimSpace(stdout); uid != "0" {
+		return flaterrors.Join(fmt.Errorf("uid=%s: target user is not root and sudo is not used", uid), ErrInsufficientPrivileges)
+	}
+	return nil
+}
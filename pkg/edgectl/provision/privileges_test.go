@@ -0,0 +1,66 @@
+package provision_test
+
+import (
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/provision"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPrivileges(t *testing.T) {
+	sudoCtx := execcontext.New(map[string]string{"GIT_SSH_COMMAND": "ssh"}, []string{"sudo", "-E"})
+	rootCtx := execcontext.New(map[string]string{}, []string{})
+
+	// The checks are run without sudo -E or the injected envs
+	plainCtx := execcontext.New(nil, nil)
+	sudoCheck := execcontext.FormatCmd(plainCtx, "sudo", "-n", "true")
+	uidCheck := execcontext.FormatCmd(plainCtx, "id", "-u")
+
+	t.Run("sudo without password", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+
+		require.NoError(t, provision.CheckPrivileges(sudoCtx, mock))
+		assert.Equal(t, []string{sudoCheck}, mock.Commands)
+	})
+
+	t.Run("sudo requires a password", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(sudoCheck, "", "sudo: a password is required", assert.AnError)
+
+		err := provision.CheckPrivileges(sudoCtx, mock)
+
+		assert.ErrorIs(t, err, provision.ErrInsufficientPrivileges)
+		assert.Contains(t, err.Error(), "a password is required")
+		assert.Equal(t, []string{sudoCheck}, mock.Commands, "nothing else must run on the target")
+	})
+
+	t.Run("root without sudo", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(uidCheck, "0\n", "", nil)
+
+		require.NoError(t, provision.CheckPrivileges(rootCtx, mock))
+		assert.Equal(t, []string{uidCheck}, mock.Commands)
+	})
+
+	t.Run("unprivileged user without sudo", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(uidCheck, "1000\n", "", nil)
+
+		err := provision.CheckPrivileges(rootCtx, mock)
+
+		assert.ErrorIs(t, err, provision.ErrInsufficientPrivileges)
+	})
+
+	t.Run("uid cannot be read", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(uidCheck, "", "id: not found", assert.AnError)
+
+		err := provision.CheckPrivileges(rootCtx, mock)
+
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, provision.ErrInsufficientPrivileges)
+	})
+}
@@ -51,6 +51,7 @@ type ServiceTemplateData struct {
 	EdgeCDRepoURL      string
 	User               string
 	Group              string
+	Capabilities       []string // Granted to the service, e.g. ServiceCapabilities
 	EnvironmentVars    []EnvVar
 	Args               []string
 }
@@ -65,6 +66,8 @@ type EnvVar struct {
 // The context parameter should contain any required prepend commands (e.g., "sudo").
 // localEdgeCDRepoPath is used to read config files and templates locally.
 // remoteEdgeCDRepoPath is the path to the edge-cd repo on the remote target VM.
+// The placed config is first validated with validation, if enabled: the service
+// is neither placed nor enabled if the config is invalid.
 func SetupEdgeCDService(
 	execCtx execcontext.Context,
 	runner ssh.Runner,
@@ -72,6 +75,7 @@ func SetupEdgeCDService(
 	localEdgeCDRepoPath string,
 	remoteEdgeCDRepoPath string,
 	templateData ServiceTemplateData,
+	validation ConfigValidation,
 ) error {
 	var stdout, stderr string
 	// Load the service manager configuration from LOCAL repo
@@ -80,6 +84,13 @@ func SetupEdgeCDService(
 		return err
 	}
 
+	if len(validation.Command) > 0 {
+		slog.Info("validating config", "path", validation.ConfigDestPath)
+		if err := ValidateConfig(execCtx, runner, validation); err != nil {
+			return err
+		}
+	}
+
 	// Render service file template
 	slog.Info("rendering service file template", "serviceManager", svcmgrName)
 	serviceContent, err := RenderServiceFile(localEdgeCDRepoPath, svcmgrName, templateData)
@@ -148,6 +159,16 @@ func loadServiceManagerConfig(
 	return &config, nil
 }
 
+// ServiceFileDestPath returns the path the service file of the service manager
+// svcmgrName is placed at on the device, read from the local edge-cd repo.
+func ServiceFileDestPath(localEdgeCDRepoPath, svcmgrName string) (string, error) {
+	config, err := loadServiceManagerConfig(localEdgeCDRepoPath, svcmgrName)
+	if err != nil {
+		return "", err
+	}
+	return config.EdgeCDService.DestinationPath, nil
+}
+
 // substituteServiceName replaces "__SERVICE_NAME__" placeholder in command arguments
 func substituteServiceName(cmdArgs []string, serviceName string) []string {
 	result := make([]string, len(cmdArgs))
@@ -1,6 +1,7 @@
 package provision
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -62,7 +63,7 @@ func TestSetupEdgeCDService(t *testing.T) {
 				Args:             []string{},
 			}
 
-			err := SetupEdgeCDService(ctx, mockRunner, tt.serviceManager, repoPath, repoPath, templateData)
+			err := SetupEdgeCDService(ctx, mockRunner, tt.serviceManager, repoPath, repoPath, templateData, ConfigValidation{})
 			if err != nil {
 				t.Fatalf("SetupEdgeCDService failed: %v", err)
 			}
@@ -94,6 +95,116 @@ func TestSetupEdgeCDService(t *testing.T) {
 	}
 }
 
+func TestSetupEdgeCDService_ConfigValidation(t *testing.T) {
+	repoPath, err := findEdgeCDRepoPath()
+	if err != nil {
+		t.Skipf("Skipping test: could not find edge-cd repository: %v", err)
+	}
+
+	ctx := execcontext.New(make(map[string]string), []string{"sudo", "-E"})
+	validation := ConfigValidation{
+		Command:        []string{"edge-cd-go", "--validate"},
+		ConfigDestPath: "/etc/edge-cd/config.yaml",
+	}
+	validateCmd := execcontext.FormatCmd(ctx, "edge-cd-go", "--validate", "/etc/edge-cd/config.yaml")
+	rollbackCmd := execcontext.FormatCmd(ctx, "rm", "-f", "/etc/edge-cd/config.yaml")
+	enableCmd := execcontext.FormatCmd(ctx, "systemctl", "enable", "edge-cd")
+
+	t.Run("invalid config", func(t *testing.T) {
+		mockRunner := ssh.NewMockRunner()
+		mockRunner.SetResponse(validateCmd, "", "config.path is required", fmt.Errorf("exit status 1"))
+
+		err := SetupEdgeCDService(ctx, mockRunner, "systemd", repoPath, repoPath, ServiceTemplateData{}, validation)
+		if !errors.Is(err, errInvalidConfig) {
+			t.Fatalf("SetupEdgeCDService error = %v, want %v", err, errInvalidConfig)
+		}
+		if !strings.Contains(err.Error(), "config.path is required") {
+			t.Errorf("expected the error to hold the output of the validation, got %v", err)
+		}
+
+		// The invalid config is removed, and nothing else is run
+		want := []string{validateCmd, rollbackCmd}
+		if strings.Join(mockRunner.Commands, "\n") != strings.Join(want, "\n") {
+			t.Errorf("Commands = %q, want %q", mockRunner.Commands, want)
+		}
+		if err := mockRunner.AssertCommandRun(enableCmd); err == nil {
+			t.Errorf("expected the service not to be enabled")
+		}
+	})
+
+	t.Run("rollback fails", func(t *testing.T) {
+		mockRunner := ssh.NewMockRunner()
+		mockRunner.SetResponse(validateCmd, "", "", fmt.Errorf("exit status 1"))
+		mockRunner.SetResponse(rollbackCmd, "", "read-only file system", fmt.Errorf("exit status 1"))
+
+		err := SetupEdgeCDService(ctx, mockRunner, "systemd", repoPath, repoPath, ServiceTemplateData{}, validation)
+		if !errors.Is(err, errInvalidConfig) || !errors.Is(err, errRollbackConfig) {
+			t.Fatalf("SetupEdgeCDService error = %v, want %v and %v", err, errInvalidConfig, errRollbackConfig)
+		}
+		if err := mockRunner.AssertCommandRun(enableCmd); err == nil {
+			t.Errorf("expected the service not to be enabled")
+		}
+	})
+
+	t.Run("valid config", func(t *testing.T) {
+		mockRunner := ssh.NewMockRunner()
+
+		err := SetupEdgeCDService(ctx, mockRunner, "systemd", repoPath, repoPath, ServiceTemplateData{}, validation)
+		if err != nil {
+			t.Fatalf("SetupEdgeCDService failed: %v", err)
+		}
+		if len(mockRunner.Commands) == 0 || mockRunner.Commands[0] != validateCmd {
+			t.Errorf("expected the config to be validated first, got %q", mockRunner.Commands)
+		}
+		if err := mockRunner.AssertCommandRun(rollbackCmd); err == nil {
+			t.Errorf("expected a valid config not to be removed")
+		}
+		if err := mockRunner.AssertCommandRun(enableCmd); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRenderServiceFile_RunAs(t *testing.T) {
+	repoPath, err := findEdgeCDRepoPath()
+	if err != nil {
+		t.Skipf("Skipping test: could not find edge-cd repository: %v", err)
+	}
+
+	data := ServiceTemplateData{
+		EdgeCDScriptPath: "/usr/local/src/edge-cd/cmd/edge-cd/edge-cd",
+		User:             "edge-cd",
+		Group:            "edge-cd",
+		Capabilities:     ServiceCapabilities,
+	}
+
+	unit, err := RenderServiceFile(repoPath, "systemd", data)
+	if err != nil {
+		t.Fatalf("RenderServiceFile failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"\nUser=edge-cd\n",
+		"\nGroup=edge-cd\n",
+		"\nAmbientCapabilities=CAP_DAC_OVERRIDE CAP_CHOWN CAP_FOWNER\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("Expected unit to contain %q, got:\n%s", want, unit)
+		}
+	}
+
+	// Running as root: no User= nor capabilities
+	unit, err = RenderServiceFile(repoPath, "systemd", ServiceTemplateData{EdgeCDScriptPath: data.EdgeCDScriptPath})
+	if err != nil {
+		t.Fatalf("RenderServiceFile failed: %v", err)
+	}
+	for _, unwanted := range []string{"User=", "AmbientCapabilities="} {
+		if strings.Contains(unit, unwanted) {
+			t.Errorf("Expected unit not to contain %q, got:\n%s", unwanted, unit)
+		}
+	}
+}
+
 // findEdgeCDRepoPath finds the edge-cd repository root by looking for the cmd/edge-cd directory
 func findEdgeCDRepoPath() (string, error) {
 	cwd, err := os.Getwd()
@@ -0,0 +1,54 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+)
+
+// Steps of ProvisionPackages, labelling their StepError.
+const (
+	StepClone              = "clone"
+	StepSparseCheckoutInit = "sparse-checkout-init"
+	StepSparseCheckoutSet  = "sparse-checkout-set"
+	StepCheckout           = "checkout"
+	StepFetch              = "fetch"
+	StepPull               = "pull"
+	StepReset              = "reset"
+	StepUpdate             = "update"
+	StepInstall            = "install"
+)
+
+// StepError is the error of a provisioning step whose command failed on the
+// target. It is joined to the error returned by ProvisionPackages: callers get
+// it with errors.As, e.g. to aggregate the failed steps across a fleet.
+type StepError struct {
+	// Step is the name of the failed step, e.g. StepFetch
+	Step string
+	// Command is the command run on the target, without the prepended command
+	// and environment of the context
+	Command []string
+	Stdout  string
+	Stderr  string
+	Err     error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %s: command %q failed: %v: stdout=%s stderr=%s",
+		e.Step, strings.Join(e.Command, " "), e.Err, e.Stdout, e.Stderr)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// runStep runs cmd on the target, returning a StepError labelled step if it fails.
+func runStep(execCtx execcontext.Context, runner ssh.Runner, step string, cmd ...string) error {
+	stdout, stderr, err := runner.Run(execCtx, cmd...)
+	if err != nil {
+		return &StepError{Step: step, Command: cmd, Stdout: stdout, Stderr: stderr, Err: err}
+	}
+	return nil
+}
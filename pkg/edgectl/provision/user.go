@@ -0,0 +1,202 @@
+package provision
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/files"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgecd/svcmgr"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errCreateServiceUser   = errors.New("failed to create the edge-cd service user")
+	errResolveCommand      = errors.New("failed to resolve a command escalated by the edge-cd service user")
+	errGrantSudo           = errors.New("failed to grant sudo to the edge-cd service user")
+	errInvalidSudoers      = errors.New("sudoers rule of the edge-cd service user is invalid")
+	errChownServicePaths   = errors.New("failed to give the edge-cd service user its paths")
+	errNoEscalatedCommands = errors.New("no command to escalate for the edge-cd service user")
+)
+
+const (
+	// SudoersPath is the sudoers drop-in granting the service user passwordless
+	// sudo with the userconfig.EscalationSudo strategy, restricted to the
+	// commands edge-cd escalates.
+	SudoersPath = "/etc/sudoers.d/edge-cd"
+	// sudoersTmpPath is where the drop-in is checked before it is moved to
+	// SudoersPath. sudo skips the files of /etc/sudoers.d containing a ".".
+	sudoersTmpPath = SudoersPath + ".tmp"
+)
+
+// ServiceCapabilities are the capabilities granted to the service with the
+// userconfig.EscalationCapabilities strategy, to write and chmod any file.
+var ServiceCapabilities = []string{"CAP_DAC_OVERRIDE", "CAP_CHOWN", "CAP_FOWNER"}
+
+// RunAsFromConfig returns the runAs section of the config, with its defaults
+// set, or nil if edge-cd runs as root.
+func RunAsFromConfig(configContent string) (*userconfig.RunAsSection, error) {
+	var spec userconfig.Spec
+	if err := yaml.Unmarshal([]byte(configContent), &spec); err != nil {
+		return nil, flaterrors.Join(err, errUnmarshalConfig)
+	}
+	if spec.RunAs == nil {
+		return nil, nil
+	}
+
+	spec.SetDefaults()
+	if err := spec.RunAs.Validate(); err != nil {
+		return nil, err
+	}
+	return spec.RunAs, nil
+}
+
+// EscalatedCommands returns the commands edge-cd runs with sudo for the config:
+// the file writes and the commands of its package and service managers, read
+// from the local edge-cd repo. A command is a name looked up in the PATH of the
+// device or a path, which may contain the "*" wildcard of sudoers.
+func EscalatedCommands(configContent, localEdgeCDRepoPath string) ([]string, error) {
+	var spec userconfig.Spec
+	if err := yaml.Unmarshal([]byte(configContent), &spec); err != nil {
+		return nil, flaterrors.Join(err, errUnmarshalConfig)
+	}
+
+	commands := append([]string{}, files.EscalatedCommands...)
+	if spec.PackageManager.Name != "" {
+		pkgMgr, err := LoadPackageManager(spec.PackageManager.Name, localEdgeCDRepoPath)
+		if err != nil {
+			return nil, err
+		}
+		commands = appendCommandNames(commands, pkgMgr.Update, pkgMgr.Install, pkgMgr.Upgrade)
+	}
+	if spec.ServiceManager.Name != "" {
+		config, err := loadServiceManagerConfig(localEdgeCDRepoPath, spec.ServiceManager.Name)
+		if err != nil {
+			return nil, err
+		}
+		reboot := config.Commands["reboot"]
+		if len(reboot) == 0 {
+			reboot = svcmgr.DefaultRebootCommand
+		}
+		// Any service of the spec is enabled and restarted
+		commands = appendCommandNames(commands,
+			substituteServiceName(config.Commands["enable"], "*"),
+			substituteServiceName(config.Commands["restart"], "*"),
+			substituteServiceName(config.Commands["start"], "*"),
+			reboot,
+		)
+	}
+	return commands, nil
+}
+
+// appendCommandNames appends the command run by each of cmds to commands, once.
+// The command of an already escalated cmd, e.g. "sudo apt-get update", is the
+// one following sudo.
+func appendCommandNames(commands []string, cmds ...[]string) []string {
+	for _, cmd := range cmds {
+		if len(cmd) > 1 && cmd[0] == "sudo" {
+			cmd = cmd[1:]
+		}
+		if len(cmd) > 0 && !slices.Contains(commands, cmd[0]) {
+			commands = append(commands, cmd[0])
+		}
+	}
+	return commands
+}
+
+// SetupServiceUser creates the dedicated user edge-cd runs as, grants it the
+// privileges of its escalation strategy, and gives it ownership of ownedPaths,
+// e.g. the repositories and state directories edge-cd writes to. runAs must
+// have its defaults set (see userconfig.Spec.SetDefaults). With the
+// userconfig.EscalationSudo strategy, sudo is restricted to escalatedCommands
+// (see EscalatedCommands).
+func SetupServiceUser(
+	execCtx execcontext.Context,
+	runner ssh.Runner,
+	runAs userconfig.RunAsSection,
+	ownedPaths []string,
+	escalatedCommands []string,
+) error {
+	if _, _, err := runner.Run(execCtx, "id", "-u", runAs.User); err != nil {
+		for _, cmd := range [][]string{
+			{"groupadd", "-f", "--system", runAs.Group},
+			{"useradd", "--system", "--no-create-home", "--shell", "/usr/sbin/nologin", "--gid", runAs.Group, runAs.User},
+		} {
+			if stdout, stderr, err := runner.Run(execCtx, cmd...); err != nil {
+				return flaterrors.Join(err, fmt.Errorf("user=%s stdout=%s stderr=%s", runAs.User, stdout, stderr), errCreateServiceUser)
+			}
+		}
+	}
+
+	if runAs.Escalation == userconfig.EscalationSudo {
+		if err := grantSudo(execCtx, runner, runAs.User, escalatedCommands); err != nil {
+			return err
+		}
+	}
+
+	owner := runAs.User + ":" + runAs.Group
+	for _, path := range ownedPaths {
+		for _, cmd := range [][]string{{"mkdir", "-p", path}, {"chown", "-R", owner, path}} {
+			if stdout, stderr, err := runner.Run(execCtx, cmd...); err != nil {
+				return flaterrors.Join(err, fmt.Errorf("path=%s stdout=%s stderr=%s", path, stdout, stderr), errChownServicePaths)
+			}
+		}
+	}
+
+	return nil
+}
+
+// grantSudo allows user to run commands as root without a password. The
+// sudoers drop-in is checked with visudo before it replaces SudoersPath, as an
+// invalid one breaks sudo for every user.
+func grantSudo(execCtx execcontext.Context, runner ssh.Runner, user string, commands []string) error {
+	if len(commands) == 0 {
+		return flaterrors.Join(fmt.Errorf("user=%s", user), errNoEscalatedCommands)
+	}
+
+	// sudoers requires the full path of the commands
+	paths := make([]string, 0, len(commands))
+	for _, command := range commands {
+		if strings.Contains(command, "/") {
+			paths = append(paths, command)
+			continue
+		}
+		stdout, stderr, err := runner.Run(execCtx, "sh", "-c", "command -v "+command)
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(stdout), "/") {
+			return flaterrors.Join(err, fmt.Errorf("command=%s stdout=%s stderr=%s", command, stdout, stderr), errResolveCommand)
+		}
+		paths = append(paths, strings.TrimSpace(stdout))
+	}
+
+	sudoers := fmt.Sprintf("%s ALL=(root) NOPASSWD: %s\n", user, strings.Join(paths, ", "))
+	encoded := base64.StdEncoding.EncodeToString([]byte(sudoers))
+	for _, cmd := range [][]string{
+		{"mkdir", "-p", "/etc/sudoers.d"},
+		{"sh", "-c", fmt.Sprintf("echo %s | base64 -d > %s", encoded, sudoersTmpPath)},
+	} {
+		if stdout, stderr, err := runner.Run(execCtx, cmd...); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("stdout=%s stderr=%s", stdout, stderr), errGrantSudo)
+		}
+	}
+
+	if stdout, stderr, err := runner.Run(execCtx, "visudo", "-cf", sudoersTmpPath); err != nil {
+		runner.Run(execCtx, "rm", "-f", sudoersTmpPath)
+		return flaterrors.Join(err, fmt.Errorf("sudoers=%q stdout=%s stderr=%s", sudoers, stdout, stderr), errInvalidSudoers)
+	}
+
+	for _, cmd := range [][]string{
+		{"chmod", "440", sudoersTmpPath},
+		{"mv", "-f", sudoersTmpPath, SudoersPath},
+	} {
+		if stdout, stderr, err := runner.Run(execCtx, cmd...); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("stdout=%s stderr=%s", stdout, stderr), errGrantSudo)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package provision_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/provision"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupServiceUser(t *testing.T) {
+	ctx := execcontext.New(map[string]string{}, []string{"sudo"})
+	format := func(cmd ...string) string { return execcontext.FormatCmd(ctx, cmd...) }
+	userCheck := format("id", "-u", "edge-cd")
+
+	t.Run("sudo escalation creates the user", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(userCheck, "", "id: 'edge-cd': no such user", assert.AnError)
+		mock.SetResponse(format("sh", "-c", "command -v tee"), "/usr/bin/tee\n", "", nil)
+		mock.SetResponse(format("sh", "-c", "command -v systemctl"), "/bin/systemctl\n", "", nil)
+		runAs := userconfig.RunAsSection{User: "edge-cd", Group: "edge-cd", Escalation: userconfig.EscalationSudo}
+
+		require.NoError(t, provision.SetupServiceUser(ctx, mock, runAs, []string{"/usr/local/src/edge-cd"},
+			[]string{"tee", "systemctl", "/etc/init.d/*"}))
+
+		sudoers := base64.StdEncoding.EncodeToString([]byte("edge-cd ALL=(root) NOPASSWD: /usr/bin/tee, /bin/systemctl, /etc/init.d/*\n"))
+		tmpPath := provision.SudoersPath + ".tmp"
+		assert.Equal(t, []string{
+			userCheck,
+			format("groupadd", "-f", "--system", "edge-cd"),
+			format("useradd", "--system", "--no-create-home", "--shell", "/usr/sbin/nologin", "--gid", "edge-cd", "edge-cd"),
+			format("sh", "-c", "command -v tee"),
+			format("sh", "-c", "command -v systemctl"),
+			format("mkdir", "-p", "/etc/sudoers.d"),
+			format("sh", "-c", fmt.Sprintf("echo %s | base64 -d > %s", sudoers, tmpPath)),
+			format("visudo", "-cf", tmpPath),
+			format("chmod", "440", tmpPath),
+			format("mv", "-f", tmpPath, provision.SudoersPath),
+			format("mkdir", "-p", "/usr/local/src/edge-cd"),
+			format("chown", "-R", "edge-cd:edge-cd", "/usr/local/src/edge-cd"),
+		}, mock.Commands)
+	})
+
+	t.Run("invalid sudoers is not installed", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(format("sh", "-c", "command -v tee"), "/usr/bin/tee\n", "", nil)
+		tmpPath := provision.SudoersPath + ".tmp"
+		mock.SetResponse(format("visudo", "-cf", tmpPath), "", "syntax error", assert.AnError)
+		runAs := userconfig.RunAsSection{User: "edge-cd", Group: "edge-cd", Escalation: userconfig.EscalationSudo}
+
+		err := provision.SetupServiceUser(ctx, mock, runAs, nil, []string{"tee"})
+
+		assert.ErrorContains(t, err, "sudoers rule of the edge-cd service user is invalid")
+		assert.Equal(t, format("rm", "-f", tmpPath), mock.Commands[len(mock.Commands)-1])
+		assert.NotContains(t, mock.Commands, format("mv", "-f", tmpPath, provision.SudoersPath))
+	})
+
+	t.Run("unresolved command", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.SetResponse(format("sh", "-c", "command -v apt-get"), "", "", assert.AnError)
+		runAs := userconfig.RunAsSection{User: "edge-cd", Group: "edge-cd", Escalation: userconfig.EscalationSudo}
+
+		err := provision.SetupServiceUser(ctx, mock, runAs, nil, []string{"apt-get"})
+
+		assert.ErrorContains(t, err, "failed to resolve a command escalated by the edge-cd service user")
+	})
+
+	t.Run("capabilities escalation with an existing user", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		runAs := userconfig.RunAsSection{User: "edge-cd", Group: "edge-cd", Escalation: userconfig.EscalationCapabilities}
+
+		require.NoError(t, provision.SetupServiceUser(ctx, mock, runAs, nil, nil))
+
+		assert.Equal(t, []string{userCheck}, mock.Commands, "no user is created and no sudo is granted")
+	})
+
+	t.Run("user cannot be created", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.DefaultErr = assert.AnError
+		runAs := userconfig.RunAsSection{User: "edge-cd", Group: "edge-cd", Escalation: userconfig.EscalationSudo}
+
+		err := provision.SetupServiceUser(ctx, mock, runAs, nil, []string{"tee"})
+
+		assert.ErrorContains(t, err, "failed to create the edge-cd service user")
+	})
+}
+
+func TestRunAsFromConfig(t *testing.T) {
+	runAs, err := provision.RunAsFromConfig("pollingIntervalSecond: 60\n")
+	require.NoError(t, err)
+	assert.Nil(t, runAs, "edge-cd runs as root without runAs")
+
+	runAs, err = provision.RunAsFromConfig("runAs:\n  escalation: capabilities\n")
+	require.NoError(t, err)
+	assert.Equal(t, &userconfig.RunAsSection{
+		User:       userconfig.DefaultRunAsUser,
+		Group:      userconfig.DefaultRunAsUser,
+		Escalation: userconfig.EscalationCapabilities,
+	}, runAs)
+
+	_, err = provision.RunAsFromConfig("runAs:\n  escalation: doas\n")
+	assert.Error(t, err)
+}
+
+func TestEscalatedCommands(t *testing.T) {
+	repoPath := filepath.Join("..", "..", "..")
+
+	commands, err := provision.EscalatedCommands(
+		"packageManager:\n  name: apt\nserviceManager:\n  name: systemd\n", repoPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mkdir", "tee", "chmod", "chown", "mv", "rm", "ln", "apt-get", "systemctl"}, commands)
+
+	commands, err = provision.EscalatedCommands(
+		"packageManager:\n  name: opkg\nserviceManager:\n  name: procd\n", repoPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mkdir", "tee", "chmod", "chown", "mv", "rm", "ln", "opkg", "/etc/init.d/*", "reboot"}, commands)
+
+	_, err = provision.EscalatedCommands("serviceManager:\n  name: unknown\n", repoPath)
+	assert.Error(t, err)
+}
@@ -1,13 +1,19 @@
 package gitserver
 
 import (
+	"context"
 	_ "embed"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -43,6 +49,7 @@ var (
 	errGitCommit               = errors.New("failed to git commit")
 	errAddGitRemote            = errors.New("failed to add git remote")
 	errPushRepo                = errors.New("failed to push repo to server")
+	errInstallHook             = errors.New("failed to install git hook")
 )
 
 type SourceType int
@@ -61,6 +68,23 @@ type Source struct {
 type Repo struct {
 	Name   string
 	Source Source
+	// Hooks are server-side git hooks installed in the bare repository, keyed by
+	// hook name (e.g. "post-receive") with the script as value. They are
+	// installed once the source is pushed, so the seed does not trigger them.
+	Hooks map[string]string
+}
+
+const (
+	// DefaultGitHome is the default home directory of the git user on the VM.
+	DefaultGitHome = "/srv/git"
+	// DefaultRepoRoot is the default directory of the bare repositories on the VM.
+	DefaultRepoRoot = "/srv/git"
+)
+
+// DefaultAwaitPolicy is how the git server waits for the SSH server of the VM by default.
+var DefaultAwaitPolicy = ssh.AwaitPolicy{
+	Timeout:  30 * time.Second,
+	Interval: ssh.DefaultAwaitInterval,
 }
 
 type Server struct {
@@ -70,8 +94,24 @@ type Server struct {
 	AuthorizedKeys []string
 	BaseDir        string
 	Repo           []Repo
+	Network        string // Libvirt network the VM is attached to. Defaults to "default" if empty
+	GitHome        string // Home directory of the git user. Defaults to DefaultGitHome
+	RepoRoot       string // Directory holding the bare repositories. Defaults to DefaultRepoRoot
+	CloudInitDir   string // Directory where the rendered cloud-init files are saved. Not saved if empty
 	clientKeyPath  string
 
+	// AwaitPolicy configures the wait for the SSH server of the VM. Zero fields
+	// default to DefaultAwaitPolicy
+	AwaitPolicy ssh.AwaitPolicy
+
+	// VMMOptions are additional options of the VMM, e.g. a fake connection in tests
+	VMMOptions []vmm.VMMOption
+
+	// WriteFiles are extra files written by cloud-init on the server, e.g. a git
+	// hook or a motd. They are written after the default files, so an entry with
+	// the same path replaces the default file.
+	WriteFiles []cloudinit.WriteFile
+
 	// -- VM related fields
 	vmm            *vmm.VMM
 	vmConfig       vmm.VMConfig
@@ -82,6 +122,9 @@ type Server struct {
 	gitSSHUrls     map[string]string // Repository name -> SSH URL mapping
 
 	authorizedKeysFile string
+
+	// newRunner connects to the server to edit its authorized keys. Defaults to sshClient
+	newRunner func(ctx context.Context) (ssh.Runner, error)
 	buildDir           string
 	gitDir             string
 }
@@ -91,6 +134,8 @@ func NewServer(baseDir, imageQCOW2Path string, repo []Repo) *Server {
 		name:           fmt.Sprintf("gitserver-%d", time.Now().UnixNano()),
 		ServerAddr:     "localhost",
 		SSHPort:        22,
+		GitHome:        DefaultGitHome,
+		RepoRoot:       DefaultRepoRoot,
 		AuthorizedKeys: []string{},
 		BaseDir:        baseDir,
 		Repo:           repo,
@@ -101,11 +146,23 @@ func NewServer(baseDir, imageQCOW2Path string, repo []Repo) *Server {
 }
 
 // Run creates and starts the git server VM.
-// Accepts context for cancellation support.
+// Cancelling ctx aborts the VM creation, the SSH wait and the repo pushes, and
+// tears down whatever was created so far.
 // After successful Run(), call Status() to get complete server information.
 func (s *Server) Run(
+	ctx context.Context,
 	execCtx execcontext.Context,
-) error {
+) (err error) {
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			s.teardownCancelled()
+		}
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Initialize base directories first
 	if err := s.init(); err != nil {
 		return flaterrors.Join(err, errInitDirectories)
@@ -115,14 +172,13 @@ func (s *Server) Run(
 		return flaterrors.Join(err, errInitVM)
 	}
 
-	var err error
-	s.vmm, err = vmm.NewVMM(vmm.WithBaseDir(s.tempDir))
+	s.vmm, err = vmm.NewVMM(append([]vmm.VMMOption{vmm.WithBaseDir(s.tempDir)}, s.VMMOptions...)...)
 	if err != nil {
 		return flaterrors.Join(err, errCreateVMM)
 	}
 
 	// Create VM and get metadata
-	metadata, err := s.vmm.CreateVM(s.vmConfig)
+	metadata, err := s.vmm.CreateVMContext(ctx, s.vmConfig)
 	if err != nil {
 		return flaterrors.Join(err, errCreateVM)
 	}
@@ -138,7 +194,7 @@ func (s *Server) Run(
 	s.ServerAddr = s.vmIPAddress
 
 	if len(s.Repo) > 0 {
-		sshClient, err := s.sshClient()
+		sshClient, err := s.sshClient(ctx)
 		if err != nil {
 			return flaterrors.Join(err, errCreateSSHClient)
 		}
@@ -147,19 +203,12 @@ func (s *Server) Run(
 			if repo.Source.Type != LocalSource {
 				return flaterrors.Join(fmt.Errorf("repoName=%s", repo.Name), errUnsupportedRepoSource)
 			}
-			if err := s.initAndPushRepo(execCtx, sshClient, repo.Name, repo.Source.LocalPath); err != nil {
+			if err := s.initAndPushRepo(ctx, execCtx, sshClient, repo.Name, repo.Source.LocalPath, repo.Hooks); err != nil {
 				return flaterrors.Join(err, fmt.Errorf("repoName=%s", repo.Name), errInitPushRepo)
 			}
 
 			// Build GitSSHURLs as repos are created
-			// Format: ssh://git@<IP>:<port>/srv/git/<repoName>.git
-			repoURL := fmt.Sprintf(
-				"ssh://git@%s:%d/srv/git/%s.git",
-				s.vmIPAddress,
-				s.SSHPort,
-				repo.Name,
-			)
-			s.gitSSHUrls[repo.Name] = repoURL
+			s.gitSSHUrls[repo.Name] = s.repoURL(repo.Name)
 		}
 	}
 
@@ -238,40 +287,116 @@ func (s *Server) initVM() error {
 		return flaterrors.Join(err, errReadSSHPubKey)
 	}
 
-	// Create a git user without using cloud-init's authorized_keys
-	// (since we have a custom home directory at /srv/git)
-	gitUser := cloudinit.NewUserWithAuthorizedKeys(
-		"git",
+	userData := newUserData(
+		s.name,
+		s.gitHome(),
 		append(s.AuthorizedKeys, strings.TrimSpace(string(clientPublicKey))),
 	)
-	gitUser.HomeDir = "/srv/git"
+	userData.WriteFiles = append(userData.WriteFiles, s.WriteFiles...)
+
+	// 3. Populate s.vmConfig
+	s.vmConfig = vmm.NewVMConfig(s.name, s.imageQCOW2Path, userData)
+	// Set temp directory for VM artifacts (disk, ISO files)
+	s.vmConfig.TempDir = s.tempDir
+	if s.Network != "" {
+		s.vmConfig.Network = s.Network
+	}
+	s.vmConfig.CloudInitDir = s.CloudInitDir
 
-	userData := cloudinit.UserData{
-		Hostname:      s.name,
+	return nil
+}
+
+const (
+	// sshdDropInPath is the sshd configuration drop-in written by cloud-init.
+	// sshd keeps the first value it reads for a keyword and includes the drop-ins
+	// in lexical order, so the "00-" prefix takes precedence over the drop-ins
+	// shipped by the image (e.g. 50-cloud-init.conf).
+	sshdDropInPath = "/etc/ssh/sshd_config.d/00-edge-cd.conf"
+
+	sshdDropInContent = `PasswordAuthentication no
+PermitRootLogin no
+`
+
+	// gitShellCommandsDir holds the commands the git user may run through
+	// git-shell. It is linked into the git home once the git user exists.
+	gitShellCommandsDir = "/usr/local/lib/edge-cd/git-shell-commands"
+)
+
+// Commands the git user can run over SSH besides the git transport commands.
+const (
+	// AuthorizeKeyCommand adds the public key given as argument to the authorized keys of the git user.
+	AuthorizeKeyCommand = "authorize-key"
+	// RevokeKeyCommand removes the public key given as argument from the authorized keys of the git user.
+	RevokeKeyCommand = "revoke-key"
+	// PingCommand does nothing, it checks a key can log in.
+	PingCommand = "ping"
+)
+
+// gitShellCommands are the scripts installed in gitShellCommandsDir, keyed by command.
+var gitShellCommands = map[string]string{
+	AuthorizeKeyCommand: `#!/bin/sh
+set -e
+key="$*"
+file="$HOME/.ssh/authorized_keys"
+grep -qxF "$key" "$file" || echo "$key" >> "$file"
+`,
+	RevokeKeyCommand: `#!/bin/sh
+set -e
+key="$*"
+file="$HOME/.ssh/authorized_keys"
+grep -vxF "$key" "$file" > "$file.tmp" || true
+cat "$file.tmp" > "$file"
+rm -f "$file.tmp"
+`,
+	PingCommand: `#!/bin/sh
+exit 0
+`,
+}
+
+// newUserData returns the cloud-init UserData of the Git server VM.
+// sshd is hardened with a declarative drop-in instead of editing the
+// distribution's sshd_config, so it does not depend on the image defaults.
+func newUserData(hostname, gitHome string, authorizedKeys []string) cloudinit.UserData {
+	// Create a git user without using cloud-init's authorized_keys
+	// (since we have a custom home directory)
+	gitUser := cloudinit.NewUserWithAuthorizedKeys("git", authorizedKeys)
+	gitUser.HomeDir = gitHome
+
+	writeFiles := []cloudinit.WriteFile{
+		{
+			Path:        sshdDropInPath,
+			Permissions: "0644",
+			Content:     sshdDropInContent,
+		},
+	}
+	for _, name := range []string{AuthorizeKeyCommand, RevokeKeyCommand, PingCommand} {
+		writeFiles = append(writeFiles, cloudinit.WriteFile{
+			Path:        path.Join(gitShellCommandsDir, name),
+			Permissions: "0755",
+			Content:     gitShellCommands[name],
+		})
+	}
+
+	return cloudinit.UserData{
+		Hostname:      hostname,
 		PackageUpdate: true,
 		Packages:      []string{"git", "openssh-server", "qemu-guest-agent"},
 		Users:         []cloudinit.User{gitUser},
-		WriteFiles:    []cloudinit.WriteFile{},
+		WriteFiles:    writeFiles,
 		RunCommands: []string{
-			"sed -i 's/^#PasswordAuthentication yes/PasswordAuthentication no/' /etc/ssh/sshd_config",
-			"sed -i 's/^PasswordAuthentication yes/PasswordAuthentication no/' /etc/ssh/sshd_config",
-			"sed -i 's/^#PermitRootLogin prohibit-password/PermitRootLogin no/' /etc/ssh/sshd_config",
-			"sed -i 's/^PermitRootLogin yes/PermitRootLogin no/' /etc/ssh/sshd_config",
-			"systemctl restart sshd",
+			// Validate the configuration so a bad drop-in does not lock us out
+			"sshd -t && systemctl restart sshd",
 			"chsh -s /usr/bin/git-shell git",
+			fmt.Sprintf("ln -sfn %s %s", gitShellCommandsDir, path.Join(gitHome, "git-shell-commands")),
 		},
 	}
-
-	// 3. Populate s.vmConfig
-	s.vmConfig = vmm.NewVMConfig(s.name, s.imageQCOW2Path, userData)
-	// Set temp directory for VM artifacts (disk, ISO files)
-	s.vmConfig.TempDir = s.tempDir
-
-	return nil
 }
 
+// Teardown destroys the VM of the server and removes its temporary files. It is
+// safe to call on a nil Server and more than once: the calls after the first
+// one do nothing.
 func (s *Server) Teardown() error {
-	if s.vmm == nil {
+	if s == nil || s.vmm == nil {
 		return nil // Nothing to do if VMM was not initialized
 	}
 
@@ -290,6 +415,9 @@ func (s *Server) Teardown() error {
 		errs = errors.Join(errs, flaterrors.Join(err, errRemoveTempDir))
 	}
 
+	// The VMM is closed: a later Teardown has nothing left to do
+	s.vmm = nil
+
 	if errs != nil {
 		slog.Error(
 			"encountered unexpected error while tearing down git server",
@@ -301,7 +429,15 @@ func (s *Server) Teardown() error {
 	return errs
 }
 
-func (s *Server) sshClient() (*ssh.Client, error) {
+// teardownCancelled tears down the partially created server after Run was cancelled.
+func (s *Server) teardownCancelled() {
+	slog.Info("git server setup cancelled, tearing down", "name", s.name)
+	if err := s.Teardown(); err != nil {
+		slog.Warn("failed to tear down cancelled git server", "name", s.name, "error", err.Error())
+	}
+}
+
+func (s *Server) sshClient(ctx context.Context) (*ssh.Client, error) {
 	sshClient, err := ssh.NewClient(
 		s.ServerAddr,
 		"git",
@@ -311,25 +447,25 @@ func (s *Server) sshClient() (*ssh.Client, error) {
 	if err != nil {
 		return nil, flaterrors.Join(err, errCreateSSHClient)
 	}
-	if err := sshClient.AwaitServer(30 * time.Second); err != nil {
+	if err := sshClient.AwaitServerPolicy(ctx, s.AwaitPolicy.WithDefaults(DefaultAwaitPolicy)); err != nil {
 		return nil, flaterrors.Join(err, errGitServerNotReady)
 	}
 	return sshClient, nil
 }
 
 func (s *Server) initAndPushRepo(
+	ctx context.Context,
 	execCtx execcontext.Context,
 	sshClient *ssh.Client,
 	repoName, srcPath string,
+	hooks map[string]string,
 ) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if stdout, stderr, err := sshClient.Run(
 		execCtx,
-		"git",
-		"init",
-		"-b",
-		"main",
-		"--bare",
-		fmt.Sprintf("/srv/git/%s.git", repoName),
+		s.initBareRepoCommand(repoName)...,
 	); err != nil {
 		return flaterrors.Join(err, fmt.Errorf("stdout=%s; stderr=%s", stdout, stderr), errInitBareRepo)
 	}
@@ -342,75 +478,27 @@ func (s *Server) initAndPushRepo(
 	}
 	defer os.RemoveAll(tempLocalRepoDir)
 
-	// Copy the source repo to the temp directory
-	cpCmd := exec.Command("cp", "-r", srcPath, filepath.Join(tempLocalRepoDir, "repo"))
-	if output, err := cpCmd.CombinedOutput(); err != nil {
-		return flaterrors.Join(err, fmt.Errorf("output: %s", output), errCopyRepo)
-	}
-
-	tempRepoDirPath := filepath.Join(tempLocalRepoDir, "repo")
-
-	// Initialize git if not already initialized (with main as default branch)
-	if _, err := os.Stat(filepath.Join(tempRepoDirPath, ".git")); os.IsNotExist(err) {
-		initGitCmd := exec.Command("git", "init", "-b", "main")
-		initGitCmd.Dir = tempRepoDirPath
-		if output, err := initGitCmd.CombinedOutput(); err != nil {
-			return flaterrors.Join(err, fmt.Errorf("output: %s", output), errGitInit)
-		}
-
-		// Configure git user
-		configCmds := [][]string{
-			{"git", "config", "user.email", "gitserver@example.com"},
-			{"git", "config", "user.name", "Git Server"},
-		}
-		for _, args := range configCmds {
-			cmd := exec.Command(args[0], args[1:]...)
-			cmd.Dir = tempRepoDirPath
-			if output, err := cmd.CombinedOutput(); err != nil {
-				return flaterrors.Join(err, fmt.Errorf("output: %s", output), errGitConfig)
-			}
-		}
-
-		// Add and commit all files
-		addCmd := exec.Command("git", "add", ".")
-		addCmd.Dir = tempRepoDirPath
-		if output, err := addCmd.CombinedOutput(); err != nil {
-			return flaterrors.Join(err, fmt.Errorf("output: %s", output), errGitAdd)
-		}
-
-		commitCmd := exec.Command("git", "commit", "-m", "Initial commit")
-		commitCmd.Dir = tempRepoDirPath
-		if output, err := commitCmd.CombinedOutput(); err != nil {
-			return flaterrors.Join(err, fmt.Errorf("output: %s", output), errGitCommit)
-		}
+	tempRepoDirPath, err := SeedRepo(ctx, srcPath, tempLocalRepoDir)
+	if err != nil {
+		return err
 	}
 
 	// Add remote and push
-	remoteURL := fmt.Sprintf("ssh://git@%s:%d/srv/git/%s.git", s.vmIPAddress, s.SSHPort, repoName)
-
 	// Remove existing origin remote if it exists
-	cmd := exec.Command("git", "remote", "remove", "origin")
+	cmd := exec.CommandContext(ctx, "git", "remote", "remove", "origin")
 	cmd.Dir = tempRepoDirPath
 	_ = cmd.Run()
 
 	// Add new remote
-	cmd = exec.Command("git", "remote", "add", "origin", remoteURL)
+	addRemote := s.addRemoteCommand(repoName)
+	cmd = exec.CommandContext(ctx, addRemote[0], addRemote[1:]...)
 	cmd.Dir = tempRepoDirPath
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return flaterrors.Join(err, fmt.Errorf("output: %s", output), errAddGitRemote)
 	}
 
-	// Commit any uncommitted changes
-	cmd = exec.Command("git", "add", ".")
-	cmd.Dir = tempRepoDirPath
-	_ = cmd.Run()
-
-	cmd = exec.Command("git", "commit", "-m", "Sync from source", "--allow-empty")
-	cmd.Dir = tempRepoDirPath
-	_ = cmd.Run()
-
 	// Push to the server
-	cmd = exec.Command("git", "push", "-u", "origin", "HEAD")
+	cmd = exec.CommandContext(ctx, "git", "push", "-u", "origin", "HEAD")
 	cmd.Dir = tempRepoDirPath
 	cmd.Env = append(
 		os.Environ(),
@@ -423,11 +511,84 @@ func (s *Server) initAndPushRepo(
 		return flaterrors.Join(err, fmt.Errorf("output: %s", output), errPushRepo)
 	}
 
+	// Install the server-side hooks
+	for _, hookCmd := range s.installHookCommands(repoName, hooks) {
+		if stdout, stderr, err := sshClient.Run(execCtx, hookCmd...); err != nil {
+			return flaterrors.Join(
+				err,
+				fmt.Errorf("repoName=%s; stdout=%s; stderr=%s", repoName, stdout, stderr),
+				errInstallHook,
+			)
+		}
+	}
+
 	return nil
 }
 
+// GetRepoUrl returns the SSH URL of the repository repoName.
 func (s *Server) GetRepoUrl(repoName string) string {
-	return fmt.Sprintf("ssh://git@%s/srv/git/%s.git", s.ServerAddr, repoName)
+	return s.repoURL(repoName)
+}
+
+// repoURL builds the SSH URL of the repository repoName. All the URLs of the
+// server are built here, so the status, the push remote and GetRepoUrl agree.
+// Format: ssh://git@<addr>[:<port>]<RepoRoot>/<repoName>.git, where the port
+// is omitted when it is the default SSH port.
+func (s *Server) repoURL(repoName string) string {
+	host := s.ServerAddr
+	if s.SSHPort != 0 && s.SSHPort != 22 {
+		host = net.JoinHostPort(host, strconv.Itoa(s.SSHPort))
+	}
+	return fmt.Sprintf("ssh://git@%s%s", host, s.repoPath(repoName))
+}
+
+// addRemoteCommand returns the command adding the server as the origin remote of repoName.
+func (s *Server) addRemoteCommand(repoName string) []string {
+	return []string{"git", "remote", "add", "origin", s.repoURL(repoName)}
+}
+
+// gitHome returns the home directory of the git user.
+func (s *Server) gitHome() string {
+	if s.GitHome == "" {
+		return DefaultGitHome
+	}
+	return s.GitHome
+}
+
+// repoPath returns the path of the bare repository repoName on the VM.
+func (s *Server) repoPath(repoName string) string {
+	repoRoot := s.RepoRoot
+	if repoRoot == "" {
+		repoRoot = DefaultRepoRoot
+	}
+	return path.Join(repoRoot, repoName+".git")
+}
+
+// initBareRepoCommand returns the command initializing the bare repository repoName on the VM.
+func (s *Server) initBareRepoCommand(repoName string) []string {
+	return []string{"git", "init", "-b", "main", "--bare", s.repoPath(repoName)}
+}
+
+// installHookCommands returns the commands writing hooks into the hooks
+// directory of the bare repository repoName, sorted by hook name. The scripts
+// are base64-encoded so they reach the file unchanged whatever their quoting.
+func (s *Server) installHookCommands(repoName string, hooks map[string]string) [][]string {
+	names := make([]string, 0, len(hooks))
+	for name := range hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cmds := make([][]string, 0, len(names))
+	for _, name := range names {
+		hookPath := path.Join(s.repoPath(repoName), "hooks", name)
+		script := base64.StdEncoding.EncodeToString([]byte(hooks[name]))
+		cmds = append(cmds, []string{
+			"sh", "-c",
+			fmt.Sprintf("echo %s | base64 -d > %s && chmod 0755 %s", script, hookPath, hookPath),
+		})
+	}
+	return cmds
 }
 
 func (s *Server) GetVMIPAddress() string {
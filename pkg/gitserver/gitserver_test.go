@@ -1,6 +1,7 @@
 package gitserver_test
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -135,7 +136,7 @@ func TestGitServerLifecycle(t *testing.T) {
 
 	t.Log("Running Git server VM...")
 	execCtx := execcontext.New(make(map[string]string), []string{})
-	if err := server.Run(execCtx); err != nil {
+	if err := server.Run(context.Background(), execCtx); err != nil {
 		t.Fatalf("Failed to run Git server VM: %v", err)
 	}
 	t.Logf("Git server VM started successfully with IP: %s", server.GetVMIPAddress())
@@ -222,7 +223,7 @@ func TestGitServerWithRepo(t *testing.T) {
 
 	t.Log("Running Git server VM with repo...")
 	execCtx := execcontext.New(make(map[string]string), []string{})
-	if err := server.Run(execCtx); err != nil {
+	if err := server.Run(context.Background(), execCtx); err != nil {
 		t.Fatalf("Failed to run Git server VM: %v", err)
 	}
 	t.Cleanup(func() {
@@ -242,9 +243,8 @@ func TestGitServerWithRepo(t *testing.T) {
 	cloneCmd.Env = append(
 		os.Environ(),
 		fmt.Sprintf(
-			"GIT_SSH_COMMAND=ssh -i %s -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no -p %d",
+			"GIT_SSH_COMMAND=ssh -i %s -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no",
 			clientKeyPath,
-			server.SSHPort,
 		),
 	)
 	if output, err := cloneCmd.CombinedOutput(); err != nil {
@@ -0,0 +1,67 @@
+package gitserver
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// runLocally runs a command the server would run over SSH on the local host.
+func runLocally(t *testing.T, cmd []string) {
+	t.Helper()
+	if output, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput(); err != nil {
+		t.Fatalf("%q failed: %v\nOutput: %s", cmd, err, output)
+	}
+}
+
+func TestInstallHookCommands(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	if _, err := exec.LookPath("base64"); err != nil {
+		t.Skip("base64 is not available")
+	}
+
+	s := NewServer(t.TempDir(), "image.qcow2", nil)
+	s.RepoRoot = t.TempDir()
+
+	// Quotes and variables must reach the hook unchanged
+	postReceive := "#!/bin/sh\nwhile read old new ref; do echo \"pushed $ref\" >> '/tmp/pushes'; done\n"
+	hooks := map[string]string{
+		"post-receive": postReceive,
+		"pre-receive":  "#!/bin/sh\nexit 0\n",
+	}
+
+	runLocally(t, s.initBareRepoCommand("edge-cd"))
+	cmds := s.installHookCommands("edge-cd", hooks)
+	if len(cmds) != 2 {
+		t.Fatalf("installHookCommands() returned %d commands, want 2", len(cmds))
+	}
+	for _, cmd := range cmds {
+		runLocally(t, cmd)
+	}
+
+	hookPath := filepath.Join(s.RepoRoot, "edge-cd.git", "hooks", "post-receive")
+	info, err := os.Stat(hookPath)
+	if err != nil {
+		t.Fatalf("post-receive hook not installed: %v", err)
+	}
+	if info.Mode().Perm()&0o111 == 0 {
+		t.Errorf("post-receive hook mode = %v, want it executable", info.Mode())
+	}
+	content, err := os.ReadFile(hookPath)
+	if err != nil {
+		t.Fatalf("failed to read hook: %v", err)
+	}
+	if string(content) != postReceive {
+		t.Errorf("post-receive hook = %q, want %q", content, postReceive)
+	}
+}
+
+func TestInstallHookCommandsWithoutHooks(t *testing.T) {
+	s := NewServer(t.TempDir(), "image.qcow2", nil)
+	if cmds := s.installHookCommands("edge-cd", nil); len(cmds) != 0 {
+		t.Errorf("installHookCommands() = %q, want no command", cmds)
+	}
+}
@@ -0,0 +1,86 @@
+package gitserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errServerNotRunning = errors.New("git server is not running")
+	errEmptyPublicKey   = errors.New("public key is empty")
+	errAuthorizeKey     = errors.New("failed to authorize key on git server")
+	errRevokeKey        = errors.New("failed to revoke key on git server")
+)
+
+// AddAuthorizedKey authorizes pubKey to access the running git server, e.g. to
+// rotate a key mid-test. The key is also added to AuthorizedKeys. Adding a key
+// that is already authorized does nothing.
+func (s *Server) AddAuthorizedKey(ctx context.Context, pubKey string) error {
+	pubKey = strings.TrimSpace(pubKey)
+	if err := s.runKeyCommand(ctx, AuthorizeKeyCommand, pubKey); err != nil {
+		return flaterrors.Join(err, errAuthorizeKey)
+	}
+
+	if !slices.ContainsFunc(s.AuthorizedKeys, func(key string) bool {
+		return strings.TrimSpace(key) == pubKey
+	}) {
+		s.AuthorizedKeys = append(s.AuthorizedKeys, pubKey)
+	}
+	return nil
+}
+
+// RemoveAuthorizedKey revokes the access of pubKey to the running git server,
+// and removes it from AuthorizedKeys. Removing a key that is not authorized
+// does nothing.
+func (s *Server) RemoveAuthorizedKey(ctx context.Context, pubKey string) error {
+	pubKey = strings.TrimSpace(pubKey)
+	if err := s.runKeyCommand(ctx, RevokeKeyCommand, pubKey); err != nil {
+		return flaterrors.Join(err, errRevokeKey)
+	}
+
+	s.AuthorizedKeys = slices.DeleteFunc(s.AuthorizedKeys, func(key string) bool {
+		return strings.TrimSpace(key) == pubKey
+	})
+	return nil
+}
+
+// runKeyCommand runs the git-shell command editing the authorized keys of the
+// git user on the server.
+func (s *Server) runKeyCommand(ctx context.Context, command, pubKey string) error {
+	if pubKey == "" {
+		return errEmptyPublicKey
+	}
+	if s.vmMetadata == nil {
+		return errServerNotRunning
+	}
+
+	runner, err := s.runner(ctx)
+	if err != nil {
+		return err
+	}
+	// git-shell only runs the command, so no environment variable may be prepended
+	execCtx := execcontext.New(nil, nil)
+	if _, stderr, err := runner.Run(execCtx, command, pubKey); err != nil {
+		return flaterrors.Join(err, fmt.Errorf("stderr=%s", stderr))
+	}
+	return nil
+}
+
+// runner returns the Runner connected to the server as the git user.
+func (s *Server) runner(ctx context.Context) (ssh.Runner, error) {
+	if s.newRunner != nil {
+		return s.newRunner(ctx)
+	}
+	sshClient, err := s.sshClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sshClient, nil
+}
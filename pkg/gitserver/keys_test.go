@@ -0,0 +1,141 @@
+package gitserver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+)
+
+// gitShellRunner runs the git-shell commands of the server locally, with home
+// as the home directory of the git user.
+type gitShellRunner struct {
+	home     string
+	commands [][]string
+}
+
+func (r *gitShellRunner) Run(ctx execcontext.Context, cmd ...string) (string, string, error) {
+	r.commands = append(r.commands, cmd)
+	script, ok := gitShellCommands[cmd[0]]
+	if !ok {
+		return "", "unrecognized command", errors.New("exit status 128")
+	}
+	c := exec.Command("sh", append([]string{"-c", script, cmd[0]}, cmd[1:]...)...)
+	c.Env = append(os.Environ(), "HOME="+r.home)
+	output, err := c.CombinedOutput()
+	return "", string(output), err
+}
+
+// newRunningServer returns a server as Run leaves it, whose commands run with runner.
+func newRunningServer(t *testing.T, runner ssh.Runner) *Server {
+	t.Helper()
+	s := NewServer(t.TempDir(), "image.qcow2", nil)
+	s.vmMetadata = &vmm.VMMetadata{IP: "192.168.1.1"}
+	s.newRunner = func(ctx context.Context) (ssh.Runner, error) { return runner, nil }
+	return s
+}
+
+func TestAddAndRemoveAuthorizedKey(t *testing.T) {
+	const (
+		clientKey = "ssh-rsa AAAAclient gitserver"
+		oldKey    = "ssh-ed25519 AAAAold host@e2e"
+		newKey    = "ssh-ed25519 AAAAnew host@e2e"
+	)
+
+	home := t.TempDir()
+	authorizedKeys := filepath.Join(home, ".ssh", "authorized_keys")
+	if err := os.MkdirAll(filepath.Dir(authorizedKeys), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(authorizedKeys, []byte(oldKey+"\n"+clientKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &gitShellRunner{home: home}
+	s := newRunningServer(t, runner)
+	s.AuthorizedKeys = []string{oldKey + "\n"}
+
+	// Adding a key twice authorizes it once
+	for range 2 {
+		if err := s.AddAuthorizedKey(context.Background(), newKey+"\n"); err != nil {
+			t.Fatalf("AddAuthorizedKey() error = %v", err)
+		}
+	}
+	if err := s.RemoveAuthorizedKey(context.Background(), oldKey); err != nil {
+		t.Fatalf("RemoveAuthorizedKey() error = %v", err)
+	}
+
+	content, err := os.ReadFile(authorizedKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := clientKey + "\n" + newKey + "\n"; string(content) != want {
+		t.Errorf("authorized_keys = %q, want %q", content, want)
+	}
+	if len(s.AuthorizedKeys) != 1 || s.AuthorizedKeys[0] != newKey {
+		t.Errorf("AuthorizedKeys = %q, want [%q]", s.AuthorizedKeys, newKey)
+	}
+
+	wantCommands := [][]string{
+		{AuthorizeKeyCommand, newKey},
+		{AuthorizeKeyCommand, newKey},
+		{RevokeKeyCommand, oldKey},
+	}
+	if len(runner.commands) != len(wantCommands) {
+		t.Fatalf("ran %q, want %q", runner.commands, wantCommands)
+	}
+	for i, want := range wantCommands {
+		if strings.Join(runner.commands[i], " ") != strings.Join(want, " ") {
+			t.Errorf("command %d = %q, want %q", i, runner.commands[i], want)
+		}
+	}
+}
+
+func TestAuthorizedKeyErrors(t *testing.T) {
+	failing := &gitShellRunner{home: filepath.Join(t.TempDir(), "missing")}
+
+	tests := []struct {
+		name    string
+		server  *Server
+		pubKey  string
+		wantErr error
+	}{
+		{
+			name:    "server not running",
+			server:  NewServer(t.TempDir(), "image.qcow2", nil),
+			pubKey:  "ssh-ed25519 AAAA host",
+			wantErr: errServerNotRunning,
+		},
+		{
+			name:    "empty key",
+			server:  newRunningServer(t, failing),
+			pubKey:  " \n",
+			wantErr: errEmptyPublicKey,
+		},
+		{
+			name:    "command fails",
+			server:  newRunningServer(t, failing),
+			pubKey:  "ssh-ed25519 AAAA host",
+			wantErr: errAuthorizeKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.server.AddAuthorizedKey(context.Background(), tt.pubKey)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AddAuthorizedKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(tt.server.AuthorizedKeys) != 0 {
+				t.Errorf("AuthorizedKeys = %q, want no key recorded on error", tt.server.AuthorizedKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package gitserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+)
+
+// fakeCommandRunner creates the files qemu-img and xorriso would produce.
+func fakeCommandRunner(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "qemu-img":
+		return nil, os.WriteFile(args[len(args)-2], []byte("qcow2"), 0o644)
+	case "xorriso":
+		for i, arg := range args {
+			if arg == "-o" {
+				return nil, os.WriteFile(args[i+1], []byte("iso"), 0o644)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// closedPort returns a local TCP port nothing listens on.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+func TestRunCancelledDuringRepoPush(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not available")
+	}
+
+	conn := vmm.NewFakeConnection()
+	s := NewServer(t.TempDir(), "/images/base.qcow2", []Repo{
+		{Name: "edge-cd", Source: Source{Type: LocalSource, LocalPath: t.TempDir()}},
+	})
+	s.VMMOptions = []vmm.VMMOption{vmm.WithConnection(conn), vmm.WithCommandRunner(fakeCommandRunner)}
+	// The VM comes up immediately but its SSH server never answers, so Run
+	// blocks waiting to push the repos
+	conn.LeaseIPs[s.name] = "127.0.0.1"
+	s.SSHPort = closedPort(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(500*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := s.Run(ctx, execcontext.New(make(map[string]string), []string{}))
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want context.Canceled", err)
+	}
+	if !errors.Is(err, errGitServerNotReady) {
+		t.Errorf("Run() error = %v, want it to be cancelled while awaiting the git server", err)
+	}
+	if elapsed > 3*time.Second {
+		t.Errorf("Run() returned %s after being cancelled, want a prompt return", elapsed)
+	}
+
+	// The partially created VM is torn down
+	if _, ok := conn.Domains[s.name]; ok {
+		t.Errorf("domain %s was not destroyed", s.name)
+	}
+	if !conn.Closed {
+		t.Error("VMM connection was not closed")
+	}
+	if _, err := os.Stat(s.tempDir); !os.IsNotExist(err) {
+		t.Errorf("temp dir %s was not removed", s.tempDir)
+	}
+	if s.vmm != nil {
+		t.Error("server still holds the VMM after teardown")
+	}
+}
+
+func TestRunAlreadyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := NewServer(t.TempDir(), "/images/base.qcow2", nil)
+	s.VMMOptions = []vmm.VMMOption{vmm.WithConnection(vmm.NewFakeConnection())}
+
+	err := s.Run(ctx, execcontext.New(make(map[string]string), []string{}))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want context.Canceled", err)
+	}
+	if s.vmm != nil {
+		t.Error("no VMM should be created once the context is cancelled")
+	}
+}
+
+func TestTeardownTwice(t *testing.T) {
+	conn := vmm.NewFakeConnection()
+	v, err := vmm.NewVMM(vmm.WithConnection(conn), vmm.WithCommandRunner(fakeCommandRunner))
+	if err != nil {
+		t.Fatalf("NewVMM() failed: %v", err)
+	}
+
+	s := NewServer(t.TempDir(), "/images/base.qcow2", nil)
+	s.vmm = v
+	s.tempDir = t.TempDir()
+
+	if err := s.Teardown(); err != nil {
+		t.Fatalf("Teardown() failed: %v", err)
+	}
+	if !conn.Closed {
+		t.Error("VMM connection was not closed")
+	}
+	if _, err := os.Stat(s.tempDir); !os.IsNotExist(err) {
+		t.Errorf("temp dir %s was not removed", s.tempDir)
+	}
+
+	// The second teardown has nothing left to do
+	if err := s.Teardown(); err != nil {
+		t.Errorf("second Teardown() error = %v, want nil", err)
+	}
+
+	var nilServer *Server
+	if err := nilServer.Teardown(); err != nil {
+		t.Errorf("Teardown() on a nil Server error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,78 @@
+package gitserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+// seedCommitter is the identity of the commits created while seeding a repo.
+var seedCommitter = []string{
+	"-c", "user.email=gitserver@example.com",
+	"-c", "user.name=Git Server",
+}
+
+// SeedRepo copies srcPath into destDir and turns the copy into a local git
+// repository ready to be pushed: a directory that is not a git repository is
+// initialized on the "main" branch, and any uncommitted change is committed.
+// It returns the path of the seeded repository. srcPath is left untouched.
+func SeedRepo(ctx context.Context, srcPath, destDir string) (string, error) {
+	repoPath := filepath.Join(destDir, "repo")
+
+	// Copy the source repo to the destination directory
+	cpCmd := exec.CommandContext(ctx, "cp", "-r", srcPath, repoPath)
+	if output, err := cpCmd.CombinedOutput(); err != nil {
+		return "", flaterrors.Join(err, fmt.Errorf("output: %s", output), errCopyRepo)
+	}
+
+	// Initialize git if not already initialized (with main as default branch)
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); os.IsNotExist(err) {
+		if err := runGit(ctx, repoPath, errGitInit, "init", "-b", "main"); err != nil {
+			return "", err
+		}
+
+		// Configure git user
+		for _, args := range [][]string{
+			{"config", "user.email", "gitserver@example.com"},
+			{"config", "user.name", "Git Server"},
+		} {
+			if err := runGit(ctx, repoPath, errGitConfig, args...); err != nil {
+				return "", err
+			}
+		}
+
+		// Add and commit all files
+		if err := runGit(ctx, repoPath, errGitAdd, "add", "."); err != nil {
+			return "", err
+		}
+		if err := runGit(ctx, repoPath, errGitCommit, "commit", "-m", "Initial commit"); err != nil {
+			return "", err
+		}
+		return repoPath, nil
+	}
+
+	// Commit any uncommitted changes of an existing repository
+	if err := runGit(ctx, repoPath, errGitAdd, "add", "."); err != nil {
+		return "", err
+	}
+	commitArgs := append(append([]string{}, seedCommitter...), "commit", "-m", "Sync from source", "--allow-empty")
+	if err := runGit(ctx, repoPath, errGitCommit, commitArgs...); err != nil {
+		return "", err
+	}
+
+	return repoPath, nil
+}
+
+// runGit runs git with args in dir and joins sentinel to any failure.
+func runGit(ctx context.Context, dir string, sentinel error, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return flaterrors.Join(err, fmt.Errorf("output: %s", output), sentinel)
+	}
+	return nil
+}
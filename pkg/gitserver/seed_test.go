@@ -0,0 +1,98 @@
+package gitserver_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/gitserver"
+)
+
+// gitOutput runs git in dir and returns its trimmed output
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\nOutput: %s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestSeedRepoFromPlainDirectory(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	srcPath := filepath.Join(t.TempDir(), "src")
+	if err := os.MkdirAll(filepath.Join(srcPath, "config"), 0o755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for name, content := range map[string]string{
+		"README.md":          "hello\n",
+		"config/config.yaml": "pollingIntervalSecond: 5\n",
+	} {
+		if err := os.WriteFile(filepath.Join(srcPath, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	repoPath, err := gitserver.SeedRepo(context.Background(), srcPath, t.TempDir())
+	if err != nil {
+		t.Fatalf("SeedRepo() error = %v", err)
+	}
+
+	if got := gitOutput(t, repoPath, "ls-files"); got != "README.md\nconfig/config.yaml" {
+		t.Errorf("committed files = %q, want README.md and config/config.yaml", got)
+	}
+	if got := gitOutput(t, repoPath, "status", "--porcelain"); got != "" {
+		t.Errorf("seeded repo has uncommitted changes: %q", got)
+	}
+	if got := gitOutput(t, repoPath, "rev-parse", "--abbrev-ref", "HEAD"); got != "main" {
+		t.Errorf("branch = %q, want main", got)
+	}
+
+	// The source directory is left untouched
+	if _, err := os.Stat(filepath.Join(srcPath, ".git")); !os.IsNotExist(err) {
+		t.Error("SeedRepo() initialized the source directory")
+	}
+}
+
+func TestSeedRepoCommitsPendingChanges(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	srcPath := filepath.Join(t.TempDir(), "src")
+	createLocalGitRepo(t, srcPath, map[string]string{"hello.txt": "Hello"})
+	// An uncommitted change in the source repository
+	if err := os.WriteFile(filepath.Join(srcPath, "new.txt"), []byte("new"), 0o644); err != nil {
+		t.Fatalf("Failed to write new.txt: %v", err)
+	}
+
+	repoPath, err := gitserver.SeedRepo(context.Background(), srcPath, t.TempDir())
+	if err != nil {
+		t.Fatalf("SeedRepo() error = %v", err)
+	}
+
+	if got := gitOutput(t, repoPath, "ls-files"); got != "hello.txt\nnew.txt" {
+		t.Errorf("committed files = %q, want hello.txt and new.txt", got)
+	}
+	if got := gitOutput(t, repoPath, "status", "--porcelain"); got != "" {
+		t.Errorf("seeded repo has uncommitted changes: %q", got)
+	}
+	if got := gitOutput(t, srcPath, "status", "--porcelain"); got != "?? new.txt" {
+		t.Errorf("source repo status = %q, want new.txt still untracked", got)
+	}
+}
+
+func TestSeedRepoMissingSource(t *testing.T) {
+	_, err := gitserver.SeedRepo(context.Background(), filepath.Join(t.TempDir(), "missing"), t.TempDir())
+	if err == nil {
+		t.Fatal("SeedRepo() expected an error for a missing source")
+	}
+}
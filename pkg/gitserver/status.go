@@ -14,7 +14,8 @@ type Status struct {
 	BaseDir string
 
 	// GitSSHURLs maps repository names to their SSH clone URLs
-	// Example: GitSSHURLs["edge-cd"] = "ssh://git@192.168.1.1:22/srv/git/edge-cd.git"
+	// Example: GitSSHURLs["edge-cd"] = "ssh://git@192.168.1.1/srv/git/edge-cd.git"
+	// The port is only part of the URL when it is not 22
 	GitSSHURLs map[string]string
 
 	// ServicePort is the SSH port on which the git server listens
@@ -0,0 +1,183 @@
+package gitserver
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/cloudinit"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"sigs.k8s.io/yaml"
+)
+
+func TestNewUserDataConfiguresSSHDWithDropIn(t *testing.T) {
+	rendered, err := newUserData("gitserver-test", DefaultGitHome, []string{"ssh-ed25519 AAAA"}).Render()
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	var parsed cloudinit.UserData
+	if err := yaml.UnmarshalStrict([]byte(rendered), &parsed); err != nil {
+		t.Fatalf("rendered user-data does not match UserData schema: %v", err)
+	}
+
+	var dropIn *cloudinit.WriteFile
+	for i, wf := range parsed.WriteFiles {
+		if wf.Path == sshdDropInPath {
+			dropIn = &parsed.WriteFiles[i]
+		}
+	}
+	if dropIn == nil {
+		t.Fatalf("expected a write_files entry for %s, got %+v", sshdDropInPath, parsed.WriteFiles)
+	}
+	for _, directive := range []string{"PasswordAuthentication no", "PermitRootLogin no"} {
+		if !strings.Contains(dropIn.Content, directive+"\n") {
+			t.Errorf("sshd drop-in does not set %q: %q", directive, dropIn.Content)
+		}
+	}
+
+	restarts := 0
+	for _, cmd := range parsed.RunCommands {
+		if strings.Contains(cmd, "sed") && strings.Contains(cmd, "sshd_config") {
+			t.Errorf("sshd_config must not be edited in place: %q", cmd)
+		}
+		if strings.Contains(cmd, "restart sshd") {
+			restarts++
+		}
+	}
+	if restarts != 1 {
+		t.Errorf("expected sshd to be restarted exactly once, got %d in %q", restarts, parsed.RunCommands)
+	}
+}
+
+func TestCustomGitHomeAndRepoRoot(t *testing.T) {
+	s := NewServer(t.TempDir(), "image.qcow2", nil)
+	s.ServerAddr = "192.168.1.1"
+	s.GitHome = "/home/git"
+	s.RepoRoot = "/var/lib/git/repos"
+
+	if got, want := s.GetRepoUrl("edge-cd"), "ssh://git@192.168.1.1/var/lib/git/repos/edge-cd.git"; got != want {
+		t.Errorf("GetRepoUrl() = %q, want %q", got, want)
+	}
+
+	wantCmd := []string{"git", "init", "-b", "main", "--bare", "/var/lib/git/repos/edge-cd.git"}
+	if got := s.initBareRepoCommand("edge-cd"); !reflect.DeepEqual(got, wantCmd) {
+		t.Errorf("initBareRepoCommand() = %q, want %q", got, wantCmd)
+	}
+
+	ud := newUserData("gitserver-test", s.gitHome(), nil)
+	if got := ud.Users[0].HomeDir; got != "/home/git" {
+		t.Errorf("git user home = %q, want %q", got, "/home/git")
+	}
+}
+
+func TestDefaultGitHomeAndRepoRoot(t *testing.T) {
+	s := NewServer(t.TempDir(), "image.qcow2", nil)
+	s.ServerAddr = "192.168.1.1"
+
+	if got, want := s.GetRepoUrl("edge-cd"), "ssh://git@192.168.1.1/srv/git/edge-cd.git"; got != want {
+		t.Errorf("GetRepoUrl() = %q, want %q", got, want)
+	}
+	if got := s.initBareRepoCommand("edge-cd"); got[len(got)-1] != "/srv/git/edge-cd.git" {
+		t.Errorf("initBareRepoCommand() = %q, want the repo under /srv/git", got)
+	}
+	if got := newUserData("gitserver-test", s.gitHome(), nil).Users[0].HomeDir; got != "/srv/git" {
+		t.Errorf("git user home = %q, want %q", got, "/srv/git")
+	}
+}
+
+func TestRepoURLsAreConsistent(t *testing.T) {
+	tests := []struct {
+		name    string
+		sshPort int
+		want    string
+	}{
+		{name: "default port", sshPort: 22, want: "ssh://git@192.168.1.1/srv/git/edge-cd.git"},
+		{name: "custom port", sshPort: 2222, want: "ssh://git@192.168.1.1:2222/srv/git/edge-cd.git"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(t.TempDir(), "image.qcow2", nil)
+			s.SSHPort = tt.sshPort
+			// What Run records once the VM is up and the repo pushed
+			s.ServerAddr = "192.168.1.1"
+			s.vmMetadata = &vmm.VMMetadata{IP: "192.168.1.1"}
+			s.gitSSHUrls["edge-cd"] = s.repoURL("edge-cd")
+
+			if got := s.GetRepoUrl("edge-cd"); got != tt.want {
+				t.Errorf("GetRepoUrl() = %q, want %q", got, tt.want)
+			}
+			if got := s.Status().GitSSHURLs["edge-cd"]; got != tt.want {
+				t.Errorf("Status().GitSSHURLs = %q, want %q", got, tt.want)
+			}
+			if got := s.addRemoteCommand("edge-cd"); got[len(got)-1] != tt.want {
+				t.Errorf("push remote = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewUserDataInstallsGitShellCommands(t *testing.T) {
+	ud := newUserData("gitserver-test", "/home/git", nil)
+
+	installed := map[string]cloudinit.WriteFile{}
+	for _, wf := range ud.WriteFiles {
+		installed[wf.Path] = wf
+	}
+	for _, name := range []string{AuthorizeKeyCommand, RevokeKeyCommand, PingCommand} {
+		wf, ok := installed[gitShellCommandsDir+"/"+name]
+		if !ok {
+			t.Errorf("git-shell command %q is not installed", name)
+			continue
+		}
+		if wf.Permissions != "0755" || !strings.HasPrefix(wf.Content, "#!/bin/sh\n") {
+			t.Errorf("git-shell command %q is not an executable script: %+v", name, wf)
+		}
+	}
+
+	// The commands are linked into the git home by runcmd, once the git user exists
+	wantLink := "ln -sfn " + gitShellCommandsDir + " /home/git/git-shell-commands"
+	for _, cmd := range ud.RunCommands {
+		if cmd == wantLink {
+			return
+		}
+	}
+	t.Errorf("expected runcmd to contain %q, got %q", wantLink, ud.RunCommands)
+}
+
+func TestInitVMAddsWriteFiles(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not available")
+	}
+
+	s := NewServer(t.TempDir(), "image.qcow2", nil)
+	hook := cloudinit.WriteFile{
+		Path:        "/srv/git/hooks/post-receive",
+		Permissions: "0755",
+		Content:     "#!/bin/sh\necho received\n",
+	}
+	s.WriteFiles = []cloudinit.WriteFile{hook}
+
+	if err := s.initVM(); err != nil {
+		t.Fatalf("initVM() error = %v", err)
+	}
+
+	rendered, err := s.vmConfig.UserData.Render()
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	var parsed cloudinit.UserData
+	if err := yaml.UnmarshalStrict([]byte(rendered), &parsed); err != nil {
+		t.Fatalf("rendered user-data does not match UserData schema: %v", err)
+	}
+
+	// The configured file is written last, after the default files
+	if n := len(parsed.WriteFiles); n < 2 || !reflect.DeepEqual(parsed.WriteFiles[n-1], hook) {
+		t.Errorf("write_files = %+v, want the default files followed by %+v", parsed.WriteFiles, hook)
+	}
+	if parsed.WriteFiles[0].Path != sshdDropInPath {
+		t.Errorf("first write_files entry = %q, want the sshd drop-in", parsed.WriteFiles[0].Path)
+	}
+}
@@ -0,0 +1,118 @@
+// Package multierror provides an aggregate error whose sub-errors keep a label
+// (e.g. the name of a verification or the path of a file), so callers can
+// inspect what failed instead of parsing a joined error string.
+package multierror
+
+import (
+	"errors"
+	"strings"
+)
+
+// LabeledError is a sub-error of an Error.
+type LabeledError struct {
+	Label string
+	Err   error
+}
+
+// Error returns the label followed by the error message.
+func (e LabeledError) Error() string {
+	return e.Label + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e LabeledError) Unwrap() error {
+	return e.Err
+}
+
+// Error aggregates labeled errors. The zero value is ready to use.
+//
+// Error matches errors.Is and errors.As against any of its sub-errors through
+// its Is and As methods. It deliberately does not implement Unwrap() []error,
+// so flaterrors.Join keeps it as a single error instead of flattening away
+// the labels.
+type Error struct {
+	errs []LabeledError
+}
+
+// Append adds err under label. A nil err is ignored.
+func (e *Error) Append(label string, err error) {
+	if err == nil {
+		return
+	}
+	e.errs = append(e.errs, LabeledError{Label: label, Err: err})
+}
+
+// Len returns the number of sub-errors.
+func (e *Error) Len() int {
+	return len(e.errs)
+}
+
+// Errors returns the sub-errors in the order they were appended.
+func (e *Error) Errors() []LabeledError {
+	return append([]LabeledError(nil), e.errs...)
+}
+
+// Labels returns the label of each sub-error in the order they were appended.
+func (e *Error) Labels() []string {
+	labels := make([]string, 0, len(e.errs))
+	for _, le := range e.errs {
+		labels = append(labels, le.Label)
+	}
+	return labels
+}
+
+// Get returns the first sub-error appended under label, or nil.
+func (e *Error) Get(label string) error {
+	for _, le := range e.errs {
+		if le.Label == label {
+			return le.Err
+		}
+	}
+	return nil
+}
+
+// ErrorOrNil returns e if it holds at least one sub-error, nil otherwise.
+func (e *Error) ErrorOrNil() error {
+	if e == nil || len(e.errs) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error lists each sub-error on its own line.
+func (e *Error) Error() string {
+	lines := make([]string, 0, len(e.errs))
+	for _, le := range e.errs {
+		lines = append(lines, le.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Is reports whether any sub-error matches target.
+func (e *Error) Is(target error) bool {
+	for _, le := range e.errs {
+		if errors.Is(le.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first sub-error that matches target.
+func (e *Error) As(target any) bool {
+	for _, le := range e.errs {
+		if errors.As(le.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Labels returns the labels of the first Error found in err's chain, or nil.
+func Labels(err error) []string {
+	var me *Error
+	if !errors.As(err, &me) {
+		return nil
+	}
+	return me.Labels()
+}
@@ -0,0 +1,99 @@
+package multierror
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var errSentinel = errors.New("sentinel")
+
+func TestError_Empty(t *testing.T) {
+	var errs Error
+	errs.Append("ignored", nil)
+
+	if errs.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", errs.Len())
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		t.Errorf("ErrorOrNil() = %v, want nil", err)
+	}
+}
+
+func TestError_LabelsAndGet(t *testing.T) {
+	var errs Error
+	errs.Append("git installed", errors.New("dpkg: package 'git' is not installed"))
+	errs.Append("config placed", errSentinel)
+
+	if got, want := errs.Labels(), []string{"git installed", "config placed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Labels() = %v, want %v", got, want)
+	}
+	if got := errs.Get("config placed"); got != errSentinel {
+		t.Errorf("Get() = %v, want %v", got, errSentinel)
+	}
+	if got := errs.Get("unknown"); got != nil {
+		t.Errorf("Get() = %v, want nil", got)
+	}
+
+	want := "git installed: dpkg: package 'git' is not installed\nconfig placed: sentinel"
+	if errs.Error() != want {
+		t.Errorf("Error() = %q, want %q", errs.Error(), want)
+	}
+}
+
+func TestError_IsNestedSentinel(t *testing.T) {
+	var errs Error
+	errs.Append("first", errors.New("unrelated"))
+	errs.Append("second", fmt.Errorf("wrapped: %w", flaterrors.Join(errors.New("cause"), errSentinel)))
+
+	err := errs.ErrorOrNil()
+	if !errors.Is(err, errSentinel) {
+		t.Error("errors.Is() should match the nested sentinel")
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Error("errors.Is() should not match an absent sentinel")
+	}
+}
+
+func TestError_As(t *testing.T) {
+	var errs Error
+	errs.Append("read", &fs.PathError{Op: "open", Path: "/etc/edge-cd/config.yaml", Err: os.ErrNotExist})
+
+	var pathErr *fs.PathError
+	if !errors.As(errs.ErrorOrNil(), &pathErr) {
+		t.Fatal("errors.As() should find the *fs.PathError sub-error")
+	}
+	if pathErr.Path != "/etc/edge-cd/config.yaml" {
+		t.Errorf("Path = %s, want /etc/edge-cd/config.yaml", pathErr.Path)
+	}
+}
+
+func TestError_SurvivesFlatErrorsJoin(t *testing.T) {
+	var errs Error
+	errs.Append("files synchronized", errSentinel)
+	outer := errors.New("bootstrap verification failed")
+
+	err := flaterrors.Join(errs.ErrorOrNil(), outer)
+
+	if !errors.Is(err, errSentinel) || !errors.Is(err, outer) {
+		t.Error("errors.Is() should match both the nested and the outer sentinel")
+	}
+	if got, want := Labels(err), []string{"files synchronized"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Labels() = %v, want %v", got, want)
+	}
+	if !strings.Contains(err.Error(), "files synchronized: sentinel") {
+		t.Errorf("Error() = %q, want the labeled sub-error", err.Error())
+	}
+}
+
+func TestLabels_NoAggregate(t *testing.T) {
+	if got := Labels(errSentinel); got != nil {
+		t.Errorf("Labels() = %v, want nil", got)
+	}
+}
@@ -0,0 +1,367 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/waitutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an in-process SSH server whose exec requests fail until
+// readyAfter commands have been run. Each command writes stdout and stderr,
+// then waits for hang to be closed if it is set.
+type testServer struct {
+	addr       string
+	readyAfter int
+	stdout     []byte
+	stderr     []byte
+	hang       chan struct{}
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func (s *testServer) ran() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// startTestServer starts a testServer and returns it with the private key of an authorized client.
+func startTestServer(t *testing.T, readyAfter int) (*testServer, []byte) {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("failed to create host signer: %v", err)
+	}
+
+	clientPub, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatalf("failed to create client public key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatalf("failed to marshal client key: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, net.ErrClosed
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	srv := &testServer{addr: l.Addr().String(), readyAfter: readyAfter}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn, config)
+		}
+	}()
+
+	return srv, pem.EncodeToMemory(block)
+}
+
+func (s *testServer) serve(netConn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(netConn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			_ = newChan.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveSession(ch, chReqs)
+	}
+}
+
+func (s *testServer) serveSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+
+		// The payload is the command as an SSH string: uint32 length followed by the bytes
+		cmd := string(req.Payload[4:])
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		attempt := len(s.commands)
+		s.mu.Unlock()
+
+		_, _ = ch.Write(s.stdout)
+		_, _ = ch.Stderr().Write(s.stderr)
+		if s.hang != nil {
+			<-s.hang
+		}
+
+		status := uint32(0)
+		if attempt < s.readyAfter {
+			status = 1
+		}
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, status)
+		_, _ = ch.SendRequest("exit-status", false, payload)
+		return
+	}
+}
+
+func newTestClient(srv *testServer, key []byte) *Client {
+	host, port, _ := net.SplitHostPort(srv.addr)
+	return &Client{
+		Host:          host,
+		Port:          port,
+		User:          "ubuntu",
+		PrivateKey:    key,
+		AwaitInterval: 10 * time.Millisecond,
+	}
+}
+
+func TestAwaitServerWaitsForReadinessCommand(t *testing.T) {
+	srv, key := startTestServer(t, 3)
+	c := newTestClient(srv, key)
+	c.ReadinessCommand = []string{"test", "-d", "/home/ubuntu"}
+
+	if err := c.AwaitServer(5 * time.Second); err != nil {
+		t.Fatalf("AwaitServer() error = %v", err)
+	}
+
+	// The dial succeeded every time, but the server is only ready once the command passes
+	cmds := srv.ran()
+	if len(cmds) != 3 {
+		t.Fatalf("readiness command ran %d times, want 3: %q", len(cmds), cmds)
+	}
+	want := execcontext.FormatCmd(execcontext.New(nil, nil), "test", "-d", "/home/ubuntu")
+	for _, cmd := range cmds {
+		if cmd != want {
+			t.Errorf("readiness command = %q, want %q", cmd, want)
+		}
+	}
+}
+
+func TestAwaitServerTimesOutWhenNeverReady(t *testing.T) {
+	srv, key := startTestServer(t, 1000)
+	c := newTestClient(srv, key)
+	c.ReadinessCommand = []string{"false"}
+
+	err := c.AwaitServer(200 * time.Millisecond)
+	if !errors.Is(err, waitutil.ErrTimeout) {
+		t.Fatalf("AwaitServer() error = %v, want a timeout", err)
+	}
+	// The last failure is reported with the timeout
+	if !strings.Contains(err.Error(), "readiness command") {
+		t.Errorf("AwaitServer() error = %v, want the last readiness command failure", err)
+	}
+	if len(srv.ran()) < 2 {
+		t.Errorf("readiness command ran %d times, want it retried at the configured interval", len(srv.ran()))
+	}
+}
+
+func TestAwaitServerReportsDialError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close() // Nothing listens on the port anymore
+
+	_, key := startTestServer(t, 1000)
+	c := &Client{Host: host, Port: port, User: "ubuntu", PrivateKey: key, AwaitInterval: 10 * time.Millisecond}
+
+	err = c.AwaitServer(100 * time.Millisecond)
+	if !errors.Is(err, waitutil.ErrTimeout) {
+		t.Fatalf("AwaitServer() error = %v, want a timeout", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("AwaitServer() error = %v, want the dial error wrapped", err)
+	}
+}
+
+func TestAwaitServerWithoutReadinessCommand(t *testing.T) {
+	srv, key := startTestServer(t, 1000)
+	c := newTestClient(srv, key)
+
+	if err := c.AwaitServerContext(context.Background(), 5*time.Second); err != nil {
+		t.Fatalf("AwaitServerContext() error = %v", err)
+	}
+	if cmds := srv.ran(); len(cmds) != 0 {
+		t.Errorf("no command should run without a readiness command, got %q", cmds)
+	}
+}
+
+func TestAwaitServerPolicyAppliesTimeoutAndInterval(t *testing.T) {
+	srv, key := startTestServer(t, 1000)
+	c := newTestClient(srv, key)
+
+	start := time.Now()
+	err := c.AwaitServerPolicy(context.Background(), AwaitPolicy{
+		Timeout:          250 * time.Millisecond,
+		Interval:         100 * time.Millisecond,
+		ReadinessCommand: []string{"false"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("AwaitServerPolicy() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("AwaitServerPolicy() returned after %v, want it to wait for the configured timeout", elapsed)
+	}
+	// Checked at 100ms, 200ms and at the 250ms deadline, not every 10ms like the client's interval
+	if n := len(srv.ran()); n < 2 || n > 4 {
+		t.Errorf("readiness command ran %d times, want it retried at the configured interval", n)
+	}
+	// The policy does not change the client
+	if c.AwaitInterval != 10*time.Millisecond || c.ReadinessCommand != nil {
+		t.Errorf("AwaitServerPolicy() modified the client: %+v", c)
+	}
+}
+
+func TestAwaitServerPolicyWaitsForReadinessCommand(t *testing.T) {
+	srv, key := startTestServer(t, 2)
+	c := newTestClient(srv, key)
+
+	err := c.AwaitServerPolicy(context.Background(), AwaitPolicy{
+		Timeout:          5 * time.Second,
+		Interval:         10 * time.Millisecond,
+		ReadinessCommand: []string{"cloud-init", "status"},
+	})
+	if err != nil {
+		t.Fatalf("AwaitServerPolicy() error = %v", err)
+	}
+	cmds := srv.ran()
+	if len(cmds) != 2 {
+		t.Fatalf("readiness command ran %d times, want 2: %q", len(cmds), cmds)
+	}
+	want := execcontext.FormatCmd(execcontext.New(nil, nil), "cloud-init", "status")
+	if cmds[1] != want {
+		t.Errorf("readiness command = %q, want %q", cmds[1], want)
+	}
+}
+
+func TestAwaitPolicyWithDefaults(t *testing.T) {
+	defaults := AwaitPolicy{Timeout: time.Minute, Interval: 5 * time.Second, ReadinessCommand: []string{"true"}}
+
+	got := AwaitPolicy{}.WithDefaults(defaults)
+	if got.Timeout != time.Minute || got.Interval != 5*time.Second || len(got.ReadinessCommand) != 1 {
+		t.Errorf("WithDefaults() = %+v, want the defaults", got)
+	}
+
+	got = AwaitPolicy{Timeout: time.Second, Interval: time.Millisecond, ReadinessCommand: []string{}}.WithDefaults(defaults)
+	if got.Timeout != time.Second || got.Interval != time.Millisecond || len(got.ReadinessCommand) != 0 {
+		t.Errorf("WithDefaults() = %+v, want the configured values", got)
+	}
+}
+
+func TestRunSendsFormattedCommand(t *testing.T) {
+	srv, key := startTestServer(t, 0)
+	srv.stdout = []byte("ok")
+	ctx := execcontext.New(map[string]string{"LANG": "C"}, []string{"sudo", "-E"})
+
+	stdout, _, err := newTestClient(srv, key).Run(ctx, "cat", "/etc/edge-cd/config.yaml")
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if stdout != "ok" {
+		t.Errorf("stdout = %q, want %q", stdout, "ok")
+	}
+	want := execcontext.FormatCmd(ctx, "cat", "/etc/edge-cd/config.yaml")
+	if cmds := srv.ran(); len(cmds) != 1 || cmds[0] != want {
+		t.Errorf("server ran %q, want [%q]", cmds, want)
+	}
+}
+
+func TestRunErrConnect(t *testing.T) {
+	srv, key := startTestServer(t, 2)
+	c := newTestClient(srv, key)
+	ctx := execcontext.New(nil, nil)
+
+	// A failed command is not a connection error
+	if _, _, err := c.Run(ctx, "false"); err == nil || errors.Is(err, ErrConnect) {
+		t.Errorf("Run() error = %v, want a command error", err)
+	}
+
+	// Nothing listens on the port of a closed listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	_, c.Port, _ = net.SplitHostPort(l.Addr().String())
+	l.Close()
+	if _, _, err := c.Run(ctx, "true"); !errors.Is(err, ErrConnect) {
+		t.Errorf("Run() error = %v, want %v", err, ErrConnect)
+	}
+}
+
+func TestStreamContextStopsOnCancel(t *testing.T) {
+	srv, key := startTestServer(t, 0)
+	srv.hang = make(chan struct{})
+	t.Cleanup(func() { close(srv.hang) })
+	c := newTestClient(srv, key)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.StreamContext(ctx, execcontext.New(nil, nil), io.Discard, io.Discard, "tail", "-F", "/var/log/edge-cd.log")
+	}()
+
+	// Cancel once the command is running
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.ran()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the command was never run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("StreamContext() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StreamContext() did not return after cancellation")
+	}
+}
@@ -2,24 +2,68 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
 	"time"
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/waitutil"
 	"golang.org/x/crypto/ssh"
 )
 
+// DefaultAwaitInterval is how often AwaitServer retries by default.
+const DefaultAwaitInterval = 5 * time.Second
+
+// AwaitPolicy configures how AwaitServerPolicy waits for a server to be ready.
+type AwaitPolicy struct {
+	// Timeout is how long to wait for the server before giving up
+	Timeout time.Duration
+	// Interval is how often to retry. Defaults to DefaultAwaitInterval
+	Interval time.Duration
+	// ReadinessCommand must succeed for the server to be ready. See Client.ReadinessCommand
+	ReadinessCommand []string
+}
+
+// WithDefaults returns a copy of p where the zero fields are taken from defaults.
+// A nil ReadinessCommand uses the default one, an empty non-nil one disables it.
+func (p AwaitPolicy) WithDefaults(defaults AwaitPolicy) AwaitPolicy {
+	if p.Timeout <= 0 {
+		p.Timeout = defaults.Timeout
+	}
+	if p.Interval <= 0 {
+		p.Interval = defaults.Interval
+	}
+	if p.ReadinessCommand == nil {
+		p.ReadinessCommand = defaults.ReadinessCommand
+	}
+	return p
+}
+
 // Client implements the Runner interface for real SSH connections.
 type Client struct {
 	Host       string
 	User       string
 	PrivateKey []byte
 	Port       string
+
+	// AwaitInterval is how often AwaitServer retries. Defaults to DefaultAwaitInterval
+	AwaitInterval time.Duration
+	// ReadinessCommand is run by AwaitServer once connected. If set, the server
+	// is only ready when the command succeeds (e.g. once cloud-init has set up
+	// the account), not as soon as sshd accepts connections
+	ReadinessCommand []string
+	// MaxOutputBytes is how much of the stdout and of the stderr of a command
+	// Run captures, the rest being replaced by a truncation marker. Defaults to
+	// DefaultMaxOutputBytes, a negative value captures everything
+	MaxOutputBytes int
 }
 
+var _ ContextStreamRunner = &Client{}
+
 // NewClient creates a new SSH client.
 func NewClient(host, user, privateKeyPath, port string) (*Client, error) {
 	key, err := os.ReadFile(privateKeyPath)
@@ -36,13 +80,41 @@ func NewClient(host, user, privateKeyPath, port string) (*Client, error) {
 		nil
 }
 
+// Run runs the command and returns its output, truncated to MaxOutputBytes.
 func (c *Client) Run(
 	ctx execcontext.Context,
 	cmd ...string,
 ) (stdout, stderr string, err error) {
+	limit := c.MaxOutputBytes
+	if limit == 0 {
+		limit = DefaultMaxOutputBytes
+	}
+	stdoutBuf, stderrBuf := NewLimitedBuffer(limit), NewLimitedBuffer(limit)
+	err = c.Stream(ctx, stdoutBuf, stderrBuf, cmd...)
+	return stdoutBuf.String(), stderrBuf.String(), err
+}
+
+// Stream runs the command like Run, but writes its output to stdout and stderr
+// as it is produced.
+func (c *Client) Stream(
+	ctx execcontext.Context,
+	stdout, stderr io.Writer,
+	cmd ...string,
+) error {
+	return c.StreamContext(context.Background(), ctx, stdout, stderr, cmd...)
+}
+
+// StreamContext is like Stream but closes the connection when ctx is cancelled,
+// e.g. to stop following logs. It then returns the error of ctx.
+func (c *Client) StreamContext(
+	ctx context.Context,
+	execCtx execcontext.Context,
+	stdout, stderr io.Writer,
+	cmd ...string,
+) error {
 	signer, err := ssh.ParsePrivateKey(c.PrivateKey)
 	if err != nil {
-		return "", "", fmt.Errorf("unable to parse private key: %w", err)
+		return fmt.Errorf("unable to parse private key: %w", err)
 	}
 
 	config := &ssh.ClientConfig{
@@ -55,31 +127,44 @@ func (c *Client) Run(
 	}
 
 	addr := net.JoinHostPort(c.Host, c.Port)
-	conn, err := ssh.Dial("tcp", addr, config)
+	conn, err := dialSSH(ctx, &net.Dialer{Timeout: config.Timeout}, addr, config)
 	if err != nil {
-		return "", "", fmt.Errorf("unable to connect to %s: %w", addr, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w to %s: %w", ErrConnect, addr, err)
 	}
 	defer runFuncAndLogErr(conn.Close)
+	// Closing the connection interrupts the command
+	stop := context.AfterFunc(ctx, func() { runFuncAndLogErr(conn.Close) })
+	defer stop()
 
 	session, err := conn.NewSession()
 	if err != nil {
-		return "", "", fmt.Errorf("unable to create SSH session: %w", err)
+		return fmt.Errorf("unable to create SSH session: %w", err)
 	}
 	defer runFuncAndLogErr(session.Close)
 
-	var stdoutBuf, stderrBuf bytes.Buffer
-	session.Stdout = &stdoutBuf
-	session.Stderr = &stderrBuf
+	session.Stdout = stdout
+	session.Stderr = stderr
 
-	if err := session.Run(execcontext.FormatCmd(ctx, cmd...)); err != nil {
-		return stdoutBuf.String(), stderrBuf.String(), fmt.Errorf("remote command failed: %w", err)
+	if err := session.Run(execcontext.FormatCmd(execCtx, cmd...)); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("remote command failed: %w", err)
 	}
 
-	return stdoutBuf.String(), stderrBuf.String(), nil
+	return nil
 }
 
 // AwaitAvailability waits for the SSH server to be available.
 func (c *Client) AwaitServer(timeout time.Duration) error {
+	return c.AwaitServerContext(context.Background(), timeout)
+}
+
+// AwaitServerContext is like AwaitServer but stops waiting when ctx is cancelled.
+func (c *Client) AwaitServerContext(ctx context.Context, timeout time.Duration) error {
 	signer, err := ssh.ParsePrivateKey(c.PrivateKey)
 	if err != nil {
 		return fmt.Errorf("unable to parse private key: %w", err)
@@ -94,30 +179,97 @@ func (c *Client) AwaitServer(timeout time.Duration) error {
 		Timeout:         10 * time.Second,
 	}
 
+	interval := c.AwaitInterval
+	if interval <= 0 {
+		interval = DefaultAwaitInterval
+	}
+
 	addr := net.JoinHostPort(c.Host, c.Port)
-	timeoutChan := time.After(timeout)
-	tick := time.NewTicker(5 * time.Second)
-	defer tick.Stop()
-
-	for {
-		select {
-		case <-timeoutChan:
-			return fmt.Errorf("timed out waiting for SSH server at %s", addr)
-		case <-tick.C:
-			conn, err := ssh.Dial("tcp", addr, config)
-			if err != nil {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	var lastErr error
+	err = waitutil.Poll(ctx, interval, timeout, func() (bool, error) {
+		conn, err := dialSSH(ctx, dialer, addr, config)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			lastErr = err
+			fmt.Printf(
+				"failed to ssh to addr=%s\nwith err=%v\n",
+				addr,
+				err,
+			)
+			return false, nil
+		}
+
+		defer runFuncAndLogErr(conn.Close)
+
+		if len(c.ReadinessCommand) > 0 {
+			if err := runReadinessCommand(conn, c.ReadinessCommand); err != nil {
+				lastErr = err
 				fmt.Printf(
-					"failed to ssh to addr=%s\nwith err=%v\n",
+					"ssh server at addr=%s is not ready yet\nwith err=%v\n",
 					addr,
 					err,
 				)
-				continue
+				return false, nil
 			}
+		}
 
-			_ = conn.Close()
-			return nil // SSH server is available
+		return true, nil // SSH server is available
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("stopped waiting for SSH server at %s: %w", addr, ctx.Err())
 		}
+		if lastErr != nil {
+			return fmt.Errorf("timed out waiting for SSH server at %s: %w (last error: %w)", addr, err, lastErr)
+		}
+		return fmt.Errorf("timed out waiting for SSH server at %s: %w", addr, err)
+	}
+
+	return nil
+}
+
+// AwaitServerPolicy is like AwaitServerContext but the timeout, the retry
+// interval and the readiness command are taken from p instead of the client.
+func (c *Client) AwaitServerPolicy(ctx context.Context, p AwaitPolicy) error {
+	client := *c
+	client.AwaitInterval = p.Interval
+	client.ReadinessCommand = p.ReadinessCommand
+	return client.AwaitServerContext(ctx, p.Timeout)
+}
+
+// runReadinessCommand runs cmd in a new session of conn.
+func runReadinessCommand(conn *ssh.Client, cmd []string) error {
+	session, err := conn.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	defer runFuncAndLogErr(session.Close)
+
+	var out bytes.Buffer
+	session.Stdout = &out
+	session.Stderr = &out
+	formatted := execcontext.FormatCmd(execcontext.New(nil, nil), cmd...)
+	if err := session.Run(formatted); err != nil {
+		return fmt.Errorf("readiness command %q failed: %w; output: %s", formatted, err, out.String())
+	}
+	return nil
+}
+
+// dialSSH is like ssh.Dial but the TCP connection is cancelled with ctx.
+func dialSSH(ctx context.Context, dialer *net.Dialer, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if err != nil {
+		netConn.Close()
+		return nil, err
 	}
+	return ssh.NewClient(conn, chans, reqs), nil
 }
 
 func runFuncAndLogErr(f func() error) {
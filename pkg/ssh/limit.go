@@ -0,0 +1,53 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DefaultMaxOutputBytes is how much of the stdout and of the stderr of a
+// command Client.Run captures by default.
+const DefaultMaxOutputBytes = 1 << 20
+
+// LimitedBuffer is an io.Writer keeping the first bytes written to it, up to
+// its limit, and counting the others. It bounds the memory used to capture the
+// output of a command, e.g. cat of a big log file. A command whose whole
+// output is needed must be streamed to a writer instead.
+type LimitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated int64
+}
+
+// NewLimitedBuffer returns a LimitedBuffer keeping up to limit bytes. A
+// negative limit keeps everything.
+func NewLimitedBuffer(limit int) *LimitedBuffer {
+	return &LimitedBuffer{limit: limit}
+}
+
+// Write keeps what fits in the buffer and drops the rest. It never fails, so
+// that the command writing to it is not interrupted.
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	if b.limit < 0 {
+		return b.buf.Write(p)
+	}
+
+	keep := min(len(p), b.limit-b.buf.Len())
+	b.buf.Write(p[:keep])
+	b.truncated += int64(len(p) - keep)
+	return len(p), nil
+}
+
+// Truncated returns the number of bytes dropped.
+func (b *LimitedBuffer) Truncated() int64 {
+	return b.truncated
+}
+
+// String returns the bytes kept, followed by a marker with the number of bytes
+// dropped if the output was truncated.
+func (b *LimitedBuffer) String() string {
+	if b.truncated == 0 {
+		return b.buf.String()
+	}
+	return b.buf.String() + fmt.Sprintf("\n[output truncated: %d more bytes]", b.truncated)
+}
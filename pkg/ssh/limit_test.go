@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+)
+
+func TestLimitedBuffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         int
+		writes        []string
+		want          string
+		wantTruncated int64
+	}{
+		{name: "under the limit", limit: 10, writes: []string{"hello"}, want: "hello"},
+		{name: "at the limit", limit: 5, writes: []string{"hel", "lo"}, want: "hello"},
+		{
+			name:          "over the limit",
+			limit:         5,
+			writes:        []string{"hello", " world"},
+			want:          "hello\n[output truncated: 6 more bytes]",
+			wantTruncated: 6,
+		},
+		{
+			name:          "write across the limit",
+			limit:         4,
+			writes:        []string{"ab", "cdef"},
+			want:          "abcd\n[output truncated: 2 more bytes]",
+			wantTruncated: 2,
+		},
+		{name: "unlimited", limit: -1, writes: []string{strings.Repeat("a", 100)}, want: strings.Repeat("a", 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewLimitedBuffer(tt.limit)
+			for _, w := range tt.writes {
+				n, err := b.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v, want %d, nil", w, n, err, len(w))
+				}
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+			if got := b.Truncated(); got != tt.wantTruncated {
+				t.Errorf("Truncated() = %d, want %d", got, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestRunTruncatesOutput(t *testing.T) {
+	srv, key := startTestServer(t, 0)
+	srv.stdout = []byte(strings.Repeat("o", 100))
+	srv.stderr = []byte(strings.Repeat("e", 10))
+	ctx := execcontext.New(nil, nil)
+
+	t.Run("over the limit", func(t *testing.T) {
+		c := newTestClient(srv, key)
+		c.MaxOutputBytes = 64
+
+		stdout, stderr, err := c.Run(ctx, "cat", "/var/log/edge-cd.log")
+		if err != nil {
+			t.Fatalf("Run() failed: %v", err)
+		}
+		if want := strings.Repeat("o", 64) + "\n[output truncated: 36 more bytes]"; stdout != want {
+			t.Errorf("stdout = %q, want %q", stdout, want)
+		}
+		if stderr != strings.Repeat("e", 10) {
+			t.Errorf("stderr = %q, want it intact", stderr)
+		}
+	})
+
+	t.Run("default limit", func(t *testing.T) {
+		stdout, stderr, err := newTestClient(srv, key).Run(ctx, "cat", "/var/log/edge-cd.log")
+		if err != nil {
+			t.Fatalf("Run() failed: %v", err)
+		}
+		if stdout != string(srv.stdout) || stderr != string(srv.stderr) {
+			t.Errorf("Run() = %q, %q, want the output intact", stdout, stderr)
+		}
+	})
+}
@@ -1,7 +1,9 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"sync"
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
@@ -19,8 +21,13 @@ type MockRunner struct {
 	DefaultStdout string
 	DefaultStderr string
 	DefaultErr    error
+	// Follow makes StreamContext wait for its context to be cancelled after
+	// writing the response, like a command following logs
+	Follow bool
 }
 
+var _ ContextStreamRunner = &MockRunner{}
+
 // NewMockRunner creates a new MockRunner.
 func NewMockRunner() *MockRunner {
 	return &MockRunner{
@@ -53,6 +60,35 @@ func (m *MockRunner) Run(
 	return m.DefaultStdout, m.DefaultStderr, m.DefaultErr
 }
 
+// Stream records the command like Run and writes its predefined response or the
+// default to stdout and stderr.
+func (m *MockRunner) Stream(
+	ctx execcontext.Context,
+	stdout, stderr io.Writer,
+	cmd ...string,
+) error {
+	outStr, errStr, err := m.Run(ctx, cmd...)
+	io.WriteString(stdout, outStr)
+	io.WriteString(stderr, errStr)
+	return err
+}
+
+// StreamContext records the command like Stream and writes its predefined
+// response or the default. If Follow is set, it then waits for ctx to be
+// cancelled and returns its error.
+func (m *MockRunner) StreamContext(
+	ctx context.Context,
+	execCtx execcontext.Context,
+	stdout, stderr io.Writer,
+	cmd ...string,
+) error {
+	if err := m.Stream(execCtx, stdout, stderr, cmd...); err != nil || !m.Follow {
+		return err
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
 // SetResponse sets a specific response for a given command.
 func (m *MockRunner) SetResponse(cmd, stdout, stderr string, err error) {
 	m.mu.Lock()
@@ -1,10 +1,32 @@
 package ssh
 
 import (
+	"context"
+	"errors"
+	"io"
+
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
 )
 
+// ErrConnect is wrapped by the errors of the commands that did not run because
+// the connection to the host failed, as opposed to commands that failed.
+var ErrConnect = errors.New("unable to connect")
+
 // Runner defines the interface for executing commands on a remote host.
 type Runner interface {
 	Run(ctx execcontext.Context, cmd ...string) (stdout, stderr string, err error)
 }
+
+// StreamRunner is a Runner that can also write the output of a command as it is
+// produced, e.g. to follow logs.
+type StreamRunner interface {
+	Runner
+	Stream(ctx execcontext.Context, stdout, stderr io.Writer, cmd ...string) error
+}
+
+// ContextStreamRunner is a StreamRunner whose streams can be stopped, e.g. to
+// stop following logs once they are not needed anymore.
+type ContextStreamRunner interface {
+	StreamRunner
+	StreamContext(ctx context.Context, execCtx execcontext.Context, stdout, stderr io.Writer, cmd ...string) error
+}
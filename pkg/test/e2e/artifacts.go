@@ -186,9 +186,10 @@ func (j *JSONArtifactStore) GetStorePath() string {
 	return j.filePath
 }
 
-// Close performs any necessary cleanup
+// Close performs any necessary cleanup. It is safe to call on a nil store and
+// more than once.
 func (j *JSONArtifactStore) Close() error {
-	// For file-based storage, no cleanup needed
+	// For file-based storage, no cleanup needed: every write is flushed to disk
 	return nil
 }
 
@@ -427,6 +427,13 @@ func TestCloseMethod(t *testing.T) {
 
 	err := store.Close()
 	assert.NoError(t, err)
+
+	// Close is idempotent, and safe on a nil store
+	assert.NotPanics(t, func() { err = store.Close() })
+	assert.NoError(t, err)
+	var nilStore *JSONArtifactStore
+	assert.NotPanics(t, func() { err = nilStore.Close() })
+	assert.NoError(t, err)
 }
 
 // TestEnvironmentWithGitSSHURLs verifies Git SSH URLs persistence
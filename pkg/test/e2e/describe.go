@@ -0,0 +1,191 @@
+package e2e
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+)
+
+// describeIPTimeout bounds the wait for the IP address of a running VM.
+const describeIPTimeout = 10 * time.Second
+
+// DomainIPFunc returns the IP address currently leased to a running libvirt domain.
+type DomainIPFunc func(ctx execcontext.Context, name string) (string, error)
+
+// LiveVM is the live state of a VM of a test environment.
+type LiveVM struct {
+	// Name is the libvirt domain name recorded in the environment
+	Name string
+	// State is the domain state (e.g. "running"), empty if it could not be read
+	State string
+	// StoredIP is the IP address recorded in the environment
+	StoredIP string
+	// IP is the IP address leased to the domain, StoredIP if it could not be refreshed
+	IP string
+	// Err is the reason the state or the IP address could not be read
+	Err error
+}
+
+// IPChanged returns true if the VM got another IP address than the recorded one,
+// e.g. after a reboot.
+func (v LiveVM) IPChanged() bool {
+	return v.IP != v.StoredIP
+}
+
+// Description is the stored metadata of a test environment together with its
+// live state.
+type Description struct {
+	// Env is a copy of the stored environment with the live IP addresses of its VMs
+	Env *TestEnvironment
+
+	TargetVM    LiveVM
+	GitServerVM LiveVM
+
+	// Health checks the environment at the live IP addresses
+	Health *HealthReport
+
+	// ServiceState is the output of "systemctl is-active" for edge-cd on the target VM
+	ServiceState string
+	// Commit is the commit of the user config repository checked out on the target VM
+	Commit string
+	// TargetErr is the reason ServiceState or Commit could not be read
+	TargetErr error
+}
+
+// WriteTable writes the live state of the VMs and of the target VM as an aligned table.
+func (d *Description) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VM\tDOMAIN\tSTATE\tSTORED IP\tLIVE IP")
+	for _, vm := range []struct {
+		name string
+		live LiveVM
+	}{{"target VM", d.TargetVM}, {"git server", d.GitServerVM}} {
+		state, liveIP := vm.live.State, vm.live.IP
+		if vm.live.Err != nil {
+			state = "error: " + vm.live.Err.Error()
+		}
+		if vm.live.IPChanged() {
+			liveIP += " (changed)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", vm.name, vm.live.Name, state, vm.live.StoredIP, liveIP)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	if d.TargetErr != nil {
+		fmt.Fprintf(w, "Target VM: %v\n", d.TargetErr)
+		return nil
+	}
+	fmt.Fprintf(w, "Service: %s\n", d.ServiceState)
+	fmt.Fprintf(w, "Commit: %s\n", d.Commit)
+	return nil
+}
+
+// Describer reads the live state of a test environment. It runs the health
+// checks of its HealthChecker against the live IP addresses.
+// Each dependency can be replaced for testing.
+type Describer struct {
+	HealthChecker
+	DomainIP DomainIPFunc
+}
+
+// Describe refreshes the IP addresses of the VMs of env through libvirt and
+// reads the state of the edge-cd service and the applied commit over SSH.
+// Unreachable components are reported in the Description, the error is only
+// returned if libvirt cannot be reached at all. env is not modified.
+func Describe(ctx execcontext.Context, env *TestEnvironment) (*Description, error) {
+	vmManager, err := vmm.NewVMM()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to libvirt: %w", err)
+	}
+	defer vmManager.Close()
+
+	describer := Describer{
+		HealthChecker: HealthChecker{
+			DomainState: vmManager.DomainState,
+			NewRunner:   newSSHRunner,
+			CheckRepo:   lsRemote,
+		},
+		DomainIP: func(ctx execcontext.Context, name string) (string, error) {
+			return vmManager.GetDomainIP(ctx, name, describeIPTimeout)
+		},
+	}
+	return describer.Describe(ctx, env), nil
+}
+
+// Describe reads the live state of env. A VM whose domain is not running keeps
+// its recorded IP address, and the target VM is only queried over SSH if it
+// passed its health checks.
+func (d Describer) Describe(ctx execcontext.Context, env *TestEnvironment) *Description {
+	live := *env
+	desc := &Description{
+		Env:         &live,
+		TargetVM:    d.liveVM(ctx, env.TargetVM),
+		GitServerVM: d.liveVM(ctx, env.GitServerVM),
+	}
+	live.TargetVM.IP = desc.TargetVM.IP
+	live.GitServerVM.IP = desc.GitServerVM.IP
+
+	desc.Health = d.Check(ctx, &live)
+	for _, c := range desc.Health.Checks {
+		if c.Component == "target VM" && !c.OK {
+			desc.TargetErr = fmt.Errorf("not reachable: %s", c.Detail)
+			return desc
+		}
+	}
+
+	desc.ServiceState, desc.Commit, desc.TargetErr = d.targetState(ctx, &live)
+	return desc
+}
+
+// liveVM reads the state of the domain of vm and, if it is running, its IP address.
+func (d Describer) liveVM(ctx execcontext.Context, vm vmm.VMMetadata) LiveVM {
+	live := LiveVM{Name: vm.Name, StoredIP: vm.IP, IP: vm.IP}
+	if vm.Name == "" {
+		return live
+	}
+
+	if live.State, live.Err = d.DomainState(ctx, vm.Name); live.Err != nil || live.State != "running" {
+		return live
+	}
+
+	ip, err := d.DomainIP(ctx, vm.Name)
+	if err != nil {
+		live.Err = fmt.Errorf("failed to refresh IP address: %w", err)
+		return live
+	}
+	live.IP = ip
+	return live
+}
+
+// targetState returns the state of the edge-cd service and the commit of the
+// user config repository on the target VM.
+func (d Describer) targetState(ctx execcontext.Context, env *TestEnvironment) (string, string, error) {
+	access := targetVMAccess(env.TargetVM.IP)
+	runner, err := d.NewRunner(access.host, access.user, env.SSHKeys.HostKeyPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	// is-active exits non-zero for an inactive service, its output is still the state
+	stdout, stderr, err := runner.Run(ctx, "systemctl", "is-active", "edge-cd.service")
+	service := strings.TrimSpace(stdout)
+	if service == "" && err != nil {
+		return "", "", fmt.Errorf("failed to read service state: %w: %s", err, strings.TrimSpace(stderr))
+	}
+
+	stdout, stderr, err = runner.Run(ctx,
+		"git", "-c", "safe.directory="+remoteUserConfigRepoDestPath,
+		"-C", remoteUserConfigRepoDestPath, "rev-parse", "HEAD")
+	if err != nil {
+		return service, "", fmt.Errorf("failed to read commit: %w: %s", err, strings.TrimSpace(stderr))
+	}
+	return service, strings.TrimSpace(stdout), nil
+}
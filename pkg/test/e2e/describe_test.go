@@ -0,0 +1,146 @@
+package e2e
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// describeRunner answers the commands Describe runs on the target VM.
+type describeRunner struct {
+	serviceState string
+	commit       string
+}
+
+func (r *describeRunner) Run(ctx execcontext.Context, cmd ...string) (string, string, error) {
+	switch cmd[0] {
+	case "systemctl":
+		if r.serviceState != "active" {
+			return r.serviceState + "\n", "", errors.New("exit status 3")
+		}
+		return r.serviceState + "\n", "", nil
+	case "git":
+		return r.commit + "\n", "", nil
+	}
+	return "", "", nil
+}
+
+// newDescribeRunnerFactory only connects to the given hosts.
+func newDescribeRunnerFactory(runner *describeRunner, hosts ...string) (RunnerFactory, *[]string) {
+	var dialed []string
+	return func(host, user, keyPath string) (ssh.Runner, error) {
+		dialed = append(dialed, host)
+		for _, h := range hosts {
+			if h == host {
+				return runner, nil
+			}
+		}
+		return nil, fmt.Errorf("dial tcp %s:22: no route to host", host)
+	}, &dialed
+}
+
+func newDescribeTestEnv() *TestEnvironment {
+	return &TestEnvironment{
+		ID:          "e2e-20231025-describe",
+		TargetVM:    vmm.VMMetadata{Name: "target", IP: "192.168.1.100"},
+		GitServerVM: vmm.VMMetadata{Name: "gitserver", IP: "192.168.1.101"},
+		GitSSHURLs:  map[string]string{"edge-cd": "ssh://git@192.168.1.101/srv/git/edge-cd.git"},
+		SSHKeys:     SSHKeyInfo{HostKeyPath: "/tmp/id_rsa_host"},
+	}
+}
+
+func TestDescribeRefreshesIP(t *testing.T) {
+	env := newDescribeTestEnv()
+	runner := &describeRunner{serviceState: "active", commit: "0123abcd"}
+	// The target VM got a new IP address after a reboot, the old one is unreachable
+	factory, dialed := newDescribeRunnerFactory(runner, "192.168.1.200", "192.168.1.101")
+
+	describer := Describer{
+		HealthChecker: HealthChecker{
+			DomainState: runningDomains(map[string]string{"target": "running", "gitserver": "running"}),
+			NewRunner:   factory,
+			CheckRepo:   func(ctx execcontext.Context, repoURL, keyPath string) error { return nil },
+		},
+		DomainIP: func(ctx execcontext.Context, name string) (string, error) {
+			return map[string]string{"target": "192.168.1.200", "gitserver": "192.168.1.101"}[name], nil
+		},
+	}
+
+	desc := describer.Describe(execcontext.New(nil, nil), env)
+
+	assert.Equal(t, "192.168.1.100", desc.TargetVM.StoredIP)
+	assert.Equal(t, "192.168.1.200", desc.TargetVM.IP)
+	assert.True(t, desc.TargetVM.IPChanged())
+	assert.False(t, desc.GitServerVM.IPChanged())
+	assert.Equal(t, "192.168.1.200", desc.Env.TargetVM.IP)
+	assert.Equal(t, "192.168.1.100", env.TargetVM.IP, "the stored environment must not be modified")
+	assert.NotContains(t, *dialed, "192.168.1.100")
+
+	assert.True(t, desc.Health.Healthy())
+	require.NoError(t, desc.TargetErr)
+	assert.Equal(t, "active", desc.ServiceState)
+	assert.Equal(t, "0123abcd", desc.Commit)
+
+	var table bytes.Buffer
+	require.NoError(t, desc.WriteTable(&table))
+	assert.Contains(t, table.String(), "192.168.1.200 (changed)")
+	assert.Contains(t, table.String(), "Commit: 0123abcd")
+}
+
+func TestDescribeStoppedTargetVM(t *testing.T) {
+	env := newDescribeTestEnv()
+	factory, dialed := newDescribeRunnerFactory(&describeRunner{}, "192.168.1.101")
+
+	describer := Describer{
+		HealthChecker: HealthChecker{
+			DomainState: runningDomains(map[string]string{"target": "shutoff", "gitserver": "running"}),
+			NewRunner:   factory,
+			CheckRepo:   func(ctx execcontext.Context, repoURL, keyPath string) error { return nil },
+		},
+		DomainIP: func(ctx execcontext.Context, name string) (string, error) {
+			if name == "target" {
+				t.Error("the IP address of a stopped VM must not be refreshed")
+			}
+			return "192.168.1.101", nil
+		},
+	}
+
+	desc := describer.Describe(execcontext.New(nil, nil), env)
+
+	assert.Equal(t, "shutoff", desc.TargetVM.State)
+	assert.Equal(t, "192.168.1.100", desc.TargetVM.IP)
+	assert.False(t, desc.TargetVM.IPChanged())
+	assert.Error(t, desc.TargetErr)
+	assert.Empty(t, desc.ServiceState)
+	assert.Equal(t, []string{"192.168.1.101"}, *dialed)
+}
+
+func TestDescribeInactiveService(t *testing.T) {
+	env := newDescribeTestEnv()
+	factory, _ := newDescribeRunnerFactory(
+		&describeRunner{serviceState: "inactive", commit: "0123abcd"}, "192.168.1.100", "192.168.1.101")
+
+	describer := Describer{
+		HealthChecker: HealthChecker{
+			DomainState: runningDomains(map[string]string{"target": "running", "gitserver": "running"}),
+			NewRunner:   factory,
+			CheckRepo:   func(ctx execcontext.Context, repoURL, keyPath string) error { return nil },
+		},
+		DomainIP: func(ctx execcontext.Context, name string) (string, error) {
+			return map[string]string{"target": "192.168.1.100", "gitserver": "192.168.1.101"}[name], nil
+		},
+	}
+
+	desc := describer.Describe(execcontext.New(nil, nil), env)
+
+	require.NoError(t, desc.TargetErr)
+	assert.Equal(t, "inactive", desc.ServiceState)
+	assert.Equal(t, "0123abcd", desc.Commit)
+}
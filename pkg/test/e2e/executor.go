@@ -1,6 +1,7 @@
 package e2e
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,11 +10,16 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"text/tabwriter"
 	"time"
 
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/logs"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/provision"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/multierror"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/userconfig"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/waitutil"
 	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
 	"sigs.k8s.io/yaml"
 )
@@ -40,6 +46,7 @@ var (
 	errParseConfig             = errors.New("failed to parse config YAML")
 	errFileNotCreatedByService = errors.New("file not created by edge-cd service within timeout")
 	errReconciliationTestFailed = errors.New("reconciliation test scenario failed")
+	errInvalidExecutorConfig    = errors.New("invalid executor config")
 )
 
 // ReconciliationTestScenario defines a test scenario for reconciliation testing
@@ -76,6 +83,179 @@ type ExecutorConfig struct {
 
 	// PackageManager is the package manager to use (apt/opkg)
 	PackageManager string
+
+	// SkipReconciliation stops the test once the bootstrap is verified, without
+	// running the reconciliation test scenarios. Useful for fast smoke tests.
+	SkipReconciliation bool
+}
+
+// Default values of the ExecutorConfig fields, see ExecutorConfig.WithDefaults.
+const (
+	DefaultExecutorConfigPath     = "./test/edgectl/e2e/config"
+	DefaultExecutorConfigSpec     = "config.yaml"
+	DefaultExecutorPackages       = "git,curl,openssh-client"
+	DefaultExecutorServiceManager = "systemd"
+	DefaultExecutorPackageManager = "apt"
+)
+
+// supportedServiceManagers maps each package manager to the service managers
+// it is found with on the supported distributions: apt on Debian and Ubuntu,
+// opkg on OpenWrt (procd) and on Yocto-based images (systemd).
+var supportedServiceManagers = map[string][]string{
+	"apt":  {"systemd"},
+	"opkg": {"procd", "systemd"},
+}
+
+// WithDefaults returns a copy of c with its empty fields, except
+// EdgectlBinaryPath, set to their default value.
+func (c ExecutorConfig) WithDefaults() ExecutorConfig {
+	if c.ConfigPath == "" {
+		c.ConfigPath = DefaultExecutorConfigPath
+	}
+	if c.ConfigSpec == "" {
+		c.ConfigSpec = DefaultExecutorConfigSpec
+	}
+	if c.Packages == "" {
+		c.Packages = DefaultExecutorPackages
+	}
+	if c.ServiceManager == "" {
+		c.ServiceManager = DefaultExecutorServiceManager
+	}
+	if c.PackageManager == "" {
+		c.PackageManager = DefaultExecutorPackageManager
+	}
+	return c
+}
+
+// Validate returns an error if a field of c is missing or unknown, or if its
+// package and service managers are not found together on a supported distribution.
+// Defaults are not applied: call WithDefaults first.
+func (c ExecutorConfig) Validate() error {
+	if c.EdgectlBinaryPath == "" {
+		return flaterrors.Join(errEdgectlBinaryRequired, errInvalidExecutorConfig)
+	}
+	if c.ConfigPath == "" || c.ConfigSpec == "" {
+		return flaterrors.Join(errors.New("ConfigPath and ConfigSpec are required"), errInvalidExecutorConfig)
+	}
+	for _, pkg := range strings.Split(c.Packages, ",") {
+		if strings.TrimSpace(pkg) == "" {
+			return flaterrors.Join(fmt.Errorf("packages=%q: empty package name", c.Packages), errInvalidExecutorConfig)
+		}
+	}
+
+	serviceManagers, ok := supportedServiceManagers[c.PackageManager]
+	if !ok {
+		return flaterrors.Join(fmt.Errorf("unknown package manager %q: must be apt or opkg", c.PackageManager), errInvalidExecutorConfig)
+	}
+	if c.ServiceManager != "systemd" && c.ServiceManager != "procd" {
+		return flaterrors.Join(fmt.Errorf("unknown service manager %q: must be systemd or procd", c.ServiceManager), errInvalidExecutorConfig)
+	}
+	for _, svcMgr := range serviceManagers {
+		if svcMgr == c.ServiceManager {
+			return nil
+		}
+	}
+	return flaterrors.Join(
+		fmt.Errorf("package manager %s cannot be used with service manager %s: must be one of %v",
+			c.PackageManager, c.ServiceManager, serviceManagers),
+		errInvalidExecutorConfig,
+	)
+}
+
+// Paths the repositories are cloned to on the target VM.
+const (
+	remoteEdgeCDRepoDestPath     = "/home/ubuntu/edge-cd"
+	remoteUserConfigRepoDestPath = "/home/ubuntu/edge-cd-config"
+)
+
+// Bootstrap test phases, in the order they run. Each reconciliation scenario
+// is a phase of its own, see ScenarioPhase.
+const (
+	BootstrapPhaseBootstrap    = "bootstrap"
+	BootstrapPhaseVerification = "verification"
+)
+
+// ScenarioPhase returns the name of the phase running the i-th (0-based)
+// reconciliation scenario.
+func ScenarioPhase(i int, scenario ReconciliationTestScenario) string {
+	return fmt.Sprintf("scenario %d: %s", i+1, scenario.Name)
+}
+
+// BootstrapPhaseResult is the outcome of one bootstrap test phase.
+type BootstrapPhaseResult struct {
+	// Phase is one of the BootstrapPhase* constants or a ScenarioPhase
+	Phase string
+	// Duration is how long the phase ran, zero if it was skipped
+	Duration time.Duration
+	// Skipped is true if the phase did not run because a previous phase failed
+	Skipped bool
+	// Err is nil if the phase succeeded
+	Err error
+}
+
+// OK returns true if the phase succeeded or was skipped.
+func (p BootstrapPhaseResult) OK() bool {
+	return p.Err == nil
+}
+
+// BootstrapTestResult lists the BootstrapPhaseResults of a bootstrap test, in
+// the order the phases ran.
+type BootstrapTestResult struct {
+	EnvID  string
+	Phases []BootstrapPhaseResult
+}
+
+// Failed returns true if any phase failed.
+func (r *BootstrapTestResult) Failed() bool {
+	return len(r.FailedPhases()) > 0
+}
+
+// FailedPhases returns the names of the failed phases.
+func (r *BootstrapTestResult) FailedPhases() []string {
+	var failed []string
+	for _, p := range r.Phases {
+		if !p.OK() {
+			failed = append(failed, p.Phase)
+		}
+	}
+	return failed
+}
+
+// Phase returns the result of the named phase, or nil if it was not reached.
+func (r *BootstrapTestResult) Phase(name string) *BootstrapPhaseResult {
+	for i := range r.Phases {
+		if r.Phases[i].Phase == name {
+			return &r.Phases[i]
+		}
+	}
+	return nil
+}
+
+// WriteTable writes the phases as an aligned table.
+func (r *BootstrapTestResult) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tSTATUS\tDURATION\tDETAIL")
+	for _, p := range r.Phases {
+		status, duration, detail := "OK", p.Duration.Round(time.Millisecond).String(), ""
+		switch {
+		case p.Skipped:
+			status, duration, detail = "SKIPPED", "-", "previous phase failed"
+		case !p.OK():
+			status, detail = "FAIL", strings.ReplaceAll(p.Err.Error(), "\n", "; ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Phase, status, duration, detail)
+	}
+	return tw.Flush()
+}
+
+// record appends the result of a phase started at start and returns err.
+func (r *BootstrapTestResult) record(phase string, start time.Time, err error) error {
+	r.Phases = append(r.Phases, BootstrapPhaseResult{
+		Phase:    phase,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	return err
 }
 
 // ExecuteBootstrapTest runs the bootstrap test on a pre-configured test environment.
@@ -83,51 +263,38 @@ type ExecutorConfig struct {
 //
 // This is the test-logic-only function that is called by both the test harness and CLI.
 // Caller must have already called SetupTestEnvironment().
+//
+// The returned BootstrapTestResult is never nil. It records the outcome and the
+// duration of each phase that ran; it has no phases if the inputs are invalid.
 func ExecuteBootstrapTest(
 	ctx execcontext.Context,
 	env *TestEnvironment,
 	config ExecutorConfig,
-) error {
+) (*BootstrapTestResult, error) {
+	result := &BootstrapTestResult{}
+
 	// Validate inputs
+	config = config.WithDefaults()
+	if err := config.Validate(); err != nil {
+		return result, err
+	}
 	if env == nil || env.ID == "" {
-		return errInvalidTestEnvironment
+		return result, errInvalidTestEnvironment
 	}
+	result.EnvID = env.ID
 	if env.TargetVM.IP == "" {
-		return errTargetVMIPNotSet
+		return result, errTargetVMIPNotSet
 	}
 	if env.GitServerVM.IP == "" {
-		return errGitServerVMIPNotSet
-	}
-	if config.EdgectlBinaryPath == "" {
-		return errEdgectlBinaryRequired
-	}
-
-	// Set defaults
-	if config.ConfigPath == "" {
-		config.ConfigPath = "./test/edgectl/e2e/config"
-	}
-	if config.ConfigSpec == "" {
-		config.ConfigSpec = "config.yaml"
-	}
-	if config.Packages == "" {
-		config.Packages = "git,curl,openssh-client"
-	}
-	if config.ServiceManager == "" {
-		config.ServiceManager = "systemd"
-	}
-	if config.PackageManager == "" {
-		config.PackageManager = "apt"
+		return result, errGitServerVMIPNotSet
 	}
 
-	// Create SSH client to target VM
-	sshClient, err := ssh.NewClient(
-		env.TargetVM.IP,
-		"ubuntu",
-		env.SSHKeys.HostKeyPath,
-		"22",
-	)
+	// Create SSH client to target VM, following its IP address if a reboot changes it
+	sshClient, err := newReconnectingRunner(env, libvirtDomainIP, func(host string) (ssh.StreamRunner, error) {
+		return ssh.NewClient(host, "ubuntu", env.SSHKeys.HostKeyPath, "22")
+	})
 	if err != nil {
-		return flaterrors.Join(err, errCreateSSHClientForExecutor)
+		return result, flaterrors.Join(err, errCreateSSHClientForExecutor)
 	}
 
 	// Get repository URLs from environment
@@ -135,18 +302,12 @@ func ExecuteBootstrapTest(
 	userConfigRepoURL := env.GitSSHURLs["user-config"]
 
 	if edgeCDRepoURL == "" {
-		return errEdgeCDRepoURLNotFound
+		return result, errEdgeCDRepoURLNotFound
 	}
 	if userConfigRepoURL == "" {
-		return errUserConfigRepoURLNotFound
+		return result, errUserConfigRepoURLNotFound
 	}
 
-	// Define remote destination paths
-	remoteEdgeCDRepoDestPath := "/home/ubuntu/edge-cd"
-	remoteUserConfigRepoDestPath := "/home/ubuntu/edge-cd-config"
-
-	injectEnv := "GIT_SSH_COMMAND=ssh -i /home/ubuntu/.ssh/id_ed25519 -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null"
-
 	// Build bootstrap command
 	cmd := exec.Command(
 		config.EdgectlBinaryPath,
@@ -163,23 +324,18 @@ func ExecuteBootstrapTest(
 		"--package-manager", config.PackageManager,
 		"--edge-cd-repo-dest", remoteEdgeCDRepoDestPath,
 		"--user-config-repo-dest", remoteUserConfigRepoDestPath,
-		"--inject-env", injectEnv,
+		"--git-ssh-key", "/home/ubuntu/.ssh/id_ed25519",
+		"--ssh-opts", strings.Join(e2eSSHOpts, ","),
 	)
 
 	// Set up environment for git operations
-	cmd.Env = append(
-		os.Environ(),
-		fmt.Sprintf(
-			"GIT_SSH_COMMAND=ssh -i %s -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null",
-			env.SSHKeys.HostKeyPath,
-		),
-	)
+	cmd.Env = gitSSHEnv(env.SSHKeys.HostKeyPath)
 
 	// Create bootstrap log file
 	bootstrapLogPath := filepath.Join(env.ArtifactPath, "bootstrap.log")
 	bootstrapLogFile, err := os.Create(bootstrapLogPath)
 	if err != nil {
-		return flaterrors.Join(
+		return result, flaterrors.Join(
 			err,
 			fmt.Errorf("failed to create bootstrap log file at %s", bootstrapLogPath),
 		)
@@ -196,24 +352,73 @@ func ExecuteBootstrapTest(
 	cmd.Stderr = multiWriter
 
 	// Run bootstrap command
-	if err := cmd.Run(); err != nil {
-		return flaterrors.Join(err, errBootstrapCommand)
+	start := time.Now()
+	if err := result.record(BootstrapPhaseBootstrap, start, cmd.Run()); err != nil {
+		return result, flaterrors.Join(err, errBootstrapCommand)
 	}
 
 	// Verify bootstrap results
-	verifyErrors := verifyBootstrapResults(
+	start = time.Now()
+	if err := result.record(BootstrapPhaseVerification, start, verifyBootstrapResults(
 		sshClient,
 		remoteEdgeCDRepoDestPath,
 		remoteUserConfigRepoDestPath,
 		config.ServiceManager,
-	)
-	if len(verifyErrors) > 0 {
-		return flaterrors.Join(fmt.Errorf("errors=%v", verifyErrors), errBootstrapVerification)
+	)); err != nil {
+		return result, flaterrors.Join(err, errBootstrapVerification)
 	}
 
 	// Reconciliation Tests: Verify edge-cd can detect and reconcile configuration changes
+	run := func(scenario ReconciliationTestScenario) error {
+		return executeReconciliationTest(ctx, env, sshClient, scenario)
+	}
+	if err := runReconciliationScenarios(config, reconciliationScenarios(), run, result); err != nil {
+		return result, err
+	}
+
+	// Update environment status to passed
+	env.Status = "passed"
+
+	return result, nil
+}
+
+// runReconciliationScenarios runs the scenarios sequentially with run, unless
+// config.SkipReconciliation is set, and records a phase per scenario in result.
+// It stops at the first failed scenario and records the remaining ones as skipped.
+func runReconciliationScenarios(
+	config ExecutorConfig,
+	scenarios []ReconciliationTestScenario,
+	run func(ReconciliationTestScenario) error,
+	result *BootstrapTestResult,
+) error {
+	if config.SkipReconciliation {
+		slog.Info("Skipping reconciliation test scenarios")
+		return nil
+	}
+
 	slog.Info("Running reconciliation test scenarios")
+	for i, scenario := range scenarios {
+		if err := result.record(ScenarioPhase(i, scenario), time.Now(), run(scenario)); err != nil {
+			for j := i + 1; j < len(scenarios); j++ {
+				result.Phases = append(result.Phases, BootstrapPhaseResult{
+					Phase:   ScenarioPhase(j, scenarios[j]),
+					Skipped: true,
+				})
+			}
+			return flaterrors.Join(
+				err,
+				fmt.Errorf("scenario=%s", scenario.Name),
+				errReconciliationTestFailed,
+			)
+		}
+	}
+
+	slog.Info("All reconciliation test scenarios passed")
+	return nil
+}
 
+// reconciliationScenarios returns the reconciliation test scenarios run after the bootstrap.
+func reconciliationScenarios() []ReconciliationTestScenario {
 	// Scenario 1: Modify existing file content
 	scenario1 := ReconciliationTestScenario{
 		Name: "modify existing file content",
@@ -297,38 +502,21 @@ files:
 		CommitMessage: "test: update multiple config files",
 	}
 
-	// Execute all scenarios sequentially
-	for _, scenario := range []ReconciliationTestScenario{scenario1, scenario2, scenario3} {
-		if err := executeReconciliationTest(ctx, env, sshClient, scenario); err != nil {
-			return flaterrors.Join(
-				err,
-				fmt.Errorf("scenario=%s", scenario.Name),
-				errReconciliationTestFailed,
-			)
-		}
-	}
-
-	slog.Info("All reconciliation test scenarios passed")
-
-	// Update environment status to passed
-	env.Status = "passed"
-
-	return nil
+	return []ReconciliationTestScenario{scenario1, scenario2, scenario3}
 }
 
 // waitForFiles polls for a file to exist on the target VM, up to maxWait duration
 func waitForFiles(
 	ctx execcontext.Context,
-	sshClient *ssh.Client,
+	sshClient ssh.Runner,
 	files []string,
 	maxWait time.Duration,
 ) error {
-	deadline := time.Now().Add(maxWait)
 	checkInterval := 2 * time.Second
 
 	fSet := make(map[string]struct{})
 
-	for time.Now().Before(deadline) {
+	err := waitutil.PollImmediate(context.Background(), checkInterval, maxWait, func() (bool, error) {
 		for _, f := range files {
 			_, _, err := sshClient.Run(ctx, "[", "-f", f, "]")
 			if err != nil {
@@ -336,29 +524,31 @@ func waitForFiles(
 			}
 			fSet[f] = struct{}{}
 		}
-		if len(fSet) == len(files) {
-			slog.Info(
-				"all exepcted files created by edge-cd service",
-				"files",
-				fmt.Sprintf("%+v", files),
-			)
-			return nil
-		}
-		time.Sleep(checkInterval)
+		return len(fSet) == len(files), nil
+	})
+	if err != nil {
+		return flaterrors.Join(
+			err,
+			fmt.Errorf("files=%s timeout=%s", files, maxWait),
+			errFileNotCreatedByService,
+		)
 	}
 
-	return flaterrors.Join(
-		fmt.Errorf("files=%s timeout=%s", files, maxWait),
-		errFileNotCreatedByService,
+	slog.Info(
+		"all exepcted files created by edge-cd service",
+		"files",
+		fmt.Sprintf("%+v", files),
 	)
+	return nil
 }
 
-// verifyBootstrapResults checks that all expected files and services exist after bootstrap
+// verifyBootstrapResults checks that all expected files and services exist after bootstrap.
+// The returned *multierror.Error is labeled with the name of each failed verification.
 func verifyBootstrapResults(
-	sshClient *ssh.Client,
+	sshClient ssh.Runner,
 	edgeCDRepoPath, userConfigRepoPath, serviceManager string,
-) []error {
-	var errors []error
+) error {
+	var errs multierror.Error
 
 	verifications := []struct {
 		name    string
@@ -433,10 +623,7 @@ func verifyBootstrapResults(
 	for _, v := range verifications {
 		_, _, err := sshClient.Run(verifyCtx, v.command...)
 		if err != nil {
-			errors = append(
-				errors,
-				flaterrors.Join(err, fmt.Errorf("verification=%s", v.name), errVerificationFailed),
-			)
+			errs.Append(v.name, flaterrors.Join(err, errVerificationFailed))
 		}
 	}
 
@@ -444,19 +631,19 @@ func verifyBootstrapResults(
 	slog.Info("fetching config.yaml from target VM to verify edge-cd service file synchronization")
 	configContent, stderr, err := sshClient.Run(verifyCtx, "cat", "/etc/edge-cd/config.yaml")
 	if err != nil {
-		errors = append(errors, flaterrors.Join(
+		errs.Append("fetch config", flaterrors.Join(
 			err,
 			fmt.Errorf("stderr=%s", stderr),
 			errFetchConfig,
 		))
-		return errors
+		return errs.ErrorOrNil()
 	}
 
 	// Parse spec to extract files list
 	var spec userconfig.Spec
 	if err := yaml.Unmarshal([]byte(configContent), &spec); err != nil {
-		errors = append(errors, flaterrors.Join(err, errParseConfig))
-		return errors
+		errs.Append("parse config", flaterrors.Join(err, errParseConfig))
+		return errs.ErrorOrNil()
 	}
 	expectedFiles := make([]string, 0)
 	for _, f := range spec.Files {
@@ -467,13 +654,13 @@ func verifyBootstrapResults(
 	if len(spec.Files) > 0 {
 		slog.Info("waiting for edge-cd service to create files", "count", len(spec.Files))
 		if err := waitForFiles(verifyCtx, sshClient, expectedFiles, 60*time.Second); err != nil {
-			errors = append(errors, err)
+			errs.Append("files synchronized", err)
 		}
 	} else {
 		slog.Info("no files specified in config.yaml, skipping file verification")
 	}
 
-	return errors
+	return errs.ErrorOrNil()
 }
 
 // BuildEdgectlBinary builds the edgectl binary and returns its path.
@@ -500,26 +687,21 @@ func BuildEdgectlBinary(edgectlSourceDir string) (string, error) {
 	return binaryPath, nil
 }
 
-// getEdgeCDServiceLogs retrieves the edge-cd service logs
-// edge-cd writes logs to both journald and /var/log/edge-cd.log
-func getEdgeCDServiceLogs(ctx execcontext.Context, sshClient *ssh.Client) (string, error) {
-	// Try reading from the log file first (more reliable for recent logs)
-	stdout, _, err := sshClient.Run(ctx, "cat", "/var/log/edge-cd.log")
-	if err == nil && len(strings.TrimSpace(stdout)) > 0 {
-		return stdout, nil
-	}
-
-	// Fall back to journalctl if log file doesn't exist
-	stdout, stderr, err := sshClient.Run(ctx, "journalctl", "-u", "edge-cd", "--no-pager")
-	if err != nil {
-		return "", fmt.Errorf("failed to get edge-cd service logs: %w (stderr: %s)", err, stderr)
+// getEdgeCDServiceLogs retrieves the edge-cd service logs, from the log file
+// or the journal like `edgectl logs`. Only the first ssh.DefaultMaxOutputBytes
+// of the logs are kept.
+func getEdgeCDServiceLogs(ctx execcontext.Context, sshClient ssh.StreamRunner) (string, error) {
+	stdout := ssh.NewLimitedBuffer(ssh.DefaultMaxOutputBytes)
+	stderr := ssh.NewLimitedBuffer(ssh.DefaultMaxOutputBytes)
+	if err := logs.Stream(ctx, sshClient, stdout, stderr, logs.Options{}); err != nil {
+		return "", fmt.Errorf("failed to get edge-cd service logs: %w (stderr: %s)", err, stderr.String())
 	}
-	return stdout, nil
+	return stdout.String(), nil
 }
 
 // waitForReconciliationLoop waits for edge-cd to complete a reconciliation loop
 // It checks that the edge-cd service is active and reconciliation has occurred
-func waitForReconciliationLoop(ctx execcontext.Context, sshClient *ssh.Client, timeoutSeconds int) error {
+func waitForReconciliationLoop(ctx execcontext.Context, sshClient ssh.Runner, timeoutSeconds int) error {
 	// Simple approach: wait for the service to be running and stable
 	// edge-cd polls every 5 seconds, so wait at least one full cycle plus buffer
 	waitTime := 10 * time.Second
@@ -528,11 +710,10 @@ func waitForReconciliationLoop(ctx execcontext.Context, sshClient *ssh.Client, t
 	}
 
 	slog.Debug("Waiting for edge-cd reconciliation loop", "waitTime", waitTime)
-	time.Sleep(waitTime)
 
-	// Verify the service is still active
-	_, _, err := sshClient.Run(ctx, "systemctl", "is-active", "edge-cd")
-	if err != nil {
+	// Verify the service is still active once the wait time has elapsed
+	time.Sleep(waitTime)
+	if _, _, err := sshClient.Run(ctx, "systemctl", "is-active", "edge-cd"); err != nil {
 		return fmt.Errorf("edge-cd service is not active after waiting: %w", err)
 	}
 
@@ -543,7 +724,7 @@ func waitForReconciliationLoop(ctx execcontext.Context, sshClient *ssh.Client, t
 // verifyFileContent verifies that a file on the target VM has the expected content
 func verifyFileContent(
 	ctx execcontext.Context,
-	sshClient *ssh.Client,
+	sshClient ssh.Runner,
 	filePath string,
 	expectedContent string,
 ) error {
@@ -581,6 +762,17 @@ func verifyFileContent(
 	return nil
 }
 
+// e2eSSHOpts are the ssh options of the git operations against the git server
+// VM, whose host key is new to every environment.
+var e2eSSHOpts = []string{"StrictHostKeyChecking=no", "UserKnownHostsFile=/dev/null"}
+
+// gitSSHEnv returns the environment of a git command authenticating to the
+// git server with the private key at sshKeyPath.
+func gitSSHEnv(sshKeyPath string) []string {
+	gitSSHCommand := provision.GitSSHCommand(sshKeyPath, e2eSSHOpts)
+	return append(os.Environ(), fmt.Sprintf("GIT_SSH_COMMAND=%s", gitSSHCommand))
+}
+
 // pushChangesToGitRepo pushes configuration changes to the git server
 func pushChangesToGitRepo(
 	ctx execcontext.Context,
@@ -597,11 +789,7 @@ func pushChangesToGitRepo(
 	defer os.RemoveAll(tempDir)
 
 	// Set up git SSH command for authentication
-	gitSSHCommand := fmt.Sprintf(
-		"ssh -i %s -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null",
-		sshKeyPath,
-	)
-	gitEnv := append(os.Environ(), fmt.Sprintf("GIT_SSH_COMMAND=%s", gitSSHCommand))
+	gitEnv := gitSSHEnv(sshKeyPath)
 
 	// Clone the repository
 	cloneCmd := exec.Command("git", "clone", gitRepoURL, tempDir)
@@ -700,7 +888,7 @@ func pushChangesToGitRepo(
 func executeReconciliationTest(
 	ctx execcontext.Context,
 	env *TestEnvironment,
-	sshClient *ssh.Client,
+	sshClient ssh.Runner,
 	scenario ReconciliationTestScenario,
 ) error {
 	slog.Info("starting reconciliation test scenario", "name", scenario.Name)
@@ -1,14 +1,173 @@
 package e2e
 
 import (
+	"bytes"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
 
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+func TestExecutorConfig_WithDefaults(t *testing.T) {
+	t.Run("empty fields are defaulted", func(t *testing.T) {
+		config := ExecutorConfig{EdgectlBinaryPath: "/tmp/edgectl"}.WithDefaults()
+
+		assert.Equal(t, ExecutorConfig{
+			EdgectlBinaryPath: "/tmp/edgectl",
+			ConfigPath:        DefaultExecutorConfigPath,
+			ConfigSpec:        DefaultExecutorConfigSpec,
+			Packages:          DefaultExecutorPackages,
+			ServiceManager:    DefaultExecutorServiceManager,
+			PackageManager:    DefaultExecutorPackageManager,
+		}, config)
+		assert.NoError(t, config.Validate())
+	})
+
+	t.Run("set fields are kept", func(t *testing.T) {
+		config := ExecutorConfig{
+			EdgectlBinaryPath: "/tmp/edgectl",
+			Packages:          "git",
+			ServiceManager:    "procd",
+			PackageManager:    "opkg",
+		}.WithDefaults()
+
+		assert.Equal(t, "git", config.Packages)
+		assert.Equal(t, "procd", config.ServiceManager)
+		assert.Equal(t, "opkg", config.PackageManager)
+		assert.Equal(t, DefaultExecutorConfigSpec, config.ConfigSpec)
+	})
+}
+
+func TestExecutorConfig_Validate(t *testing.T) {
+	valid := ExecutorConfig{EdgectlBinaryPath: "/tmp/edgectl"}.WithDefaults()
+
+	tests := []struct {
+		name   string
+		modify func(c *ExecutorConfig)
+		errMsg string
+	}{
+		{name: "apt with procd", modify: func(c *ExecutorConfig) { c.ServiceManager = "procd" }, errMsg: "apt cannot be used with service manager procd"},
+		{name: "unknown package manager", modify: func(c *ExecutorConfig) { c.PackageManager = "yum" }, errMsg: `unknown package manager "yum"`},
+		{name: "unknown service manager", modify: func(c *ExecutorConfig) { c.ServiceManager = "openrc" }, errMsg: `unknown service manager "openrc"`},
+		{name: "empty package name", modify: func(c *ExecutorConfig) { c.Packages = "git,,curl" }, errMsg: "empty package name"},
+		{name: "missing binary", modify: func(c *ExecutorConfig) { c.EdgectlBinaryPath = "" }, errMsg: "EdgectlBinaryPath is required"},
+		{name: "defaults not applied", modify: func(c *ExecutorConfig) { c.ConfigSpec = "" }, errMsg: "ConfigPath and ConfigSpec are required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid
+			tt.modify(&config)
+
+			err := config.Validate()
+
+			assert.ErrorIs(t, err, errInvalidExecutorConfig)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	t.Run("opkg with procd or systemd", func(t *testing.T) {
+		for _, svcMgr := range []string{"procd", "systemd"} {
+			config := valid
+			config.PackageManager, config.ServiceManager = "opkg", svcMgr
+			assert.NoError(t, config.Validate())
+		}
+	})
+}
+
+func TestRunReconciliationScenarios(t *testing.T) {
+	scenarios := reconciliationScenarios()
+	require.NotEmpty(t, scenarios)
+
+	t.Run("all scenarios are run", func(t *testing.T) {
+		var ran []string
+		err := runReconciliationScenarios(ExecutorConfig{}, scenarios, func(s ReconciliationTestScenario) error {
+			ran = append(ran, s.Name)
+			return nil
+		}, &BootstrapTestResult{})
+
+		require.NoError(t, err)
+		assert.Len(t, ran, len(scenarios))
+	})
+
+	t.Run("scenarios are skipped", func(t *testing.T) {
+		err := runReconciliationScenarios(ExecutorConfig{SkipReconciliation: true}, scenarios, func(s ReconciliationTestScenario) error {
+			t.Errorf("scenario %q must not run when SkipReconciliation is set", s.Name)
+			return nil
+		}, &BootstrapTestResult{})
+
+		require.NoError(t, err)
+	})
+
+	t.Run("stops at the first failure", func(t *testing.T) {
+		calls := 0
+		err := runReconciliationScenarios(ExecutorConfig{}, scenarios, func(s ReconciliationTestScenario) error {
+			calls++
+			return assert.AnError
+		}, &BootstrapTestResult{})
+
+		assert.ErrorIs(t, err, errReconciliationTestFailed)
+		assert.Contains(t, err.Error(), scenarios[0].Name)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestExecuteBootstrapTest_RejectsInvalidConfig(t *testing.T) {
+	config := ExecutorConfig{EdgectlBinaryPath: "/tmp/edgectl", ServiceManager: "procd"}
+
+	ctx := execcontext.New(make(map[string]string), []string{})
+
+	result, err := ExecuteBootstrapTest(ctx, &TestEnvironment{ID: "e2e-20231025-abc123"}, config)
+
+	assert.ErrorIs(t, err, errInvalidExecutorConfig)
+	require.NotNil(t, result)
+	assert.Empty(t, result.Phases)
+}
+
+func TestRunReconciliationScenarios_RecordsPhases(t *testing.T) {
+	scenarios := reconciliationScenarios()
+	require.GreaterOrEqual(t, len(scenarios), 3)
+
+	result := &BootstrapTestResult{EnvID: "e2e-20231025-abc123"}
+	err := runReconciliationScenarios(ExecutorConfig{}, scenarios, func(s ReconciliationTestScenario) error {
+		if s.Name == scenarios[1].Name {
+			return assert.AnError
+		}
+		return nil
+	}, result)
+
+	require.ErrorIs(t, err, errReconciliationTestFailed)
+	require.Len(t, result.Phases, len(scenarios))
+	assert.True(t, result.Failed())
+	assert.Equal(t, []string{ScenarioPhase(1, scenarios[1])}, result.FailedPhases())
+
+	first := result.Phase(ScenarioPhase(0, scenarios[0]))
+	require.NotNil(t, first)
+	assert.True(t, first.OK())
+	assert.False(t, first.Skipped)
+
+	second := result.Phase(ScenarioPhase(1, scenarios[1]))
+	require.NotNil(t, second)
+	assert.ErrorIs(t, second.Err, assert.AnError)
+
+	for i := 2; i < len(scenarios); i++ {
+		p := result.Phase(ScenarioPhase(i, scenarios[i]))
+		require.NotNil(t, p)
+		assert.True(t, p.Skipped, "scenario %d must be skipped after a failure", i+1)
+		assert.Zero(t, p.Duration)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, result.WriteTable(&buf))
+	assert.Contains(t, buf.String(), "FAIL")
+	assert.Contains(t, buf.String(), "SKIPPED")
+	assert.Contains(t, buf.String(), scenarios[1].Name)
+}
+
 // TestIdempotentGitPush tests that pushing the same changes twice succeeds
 // This verifies the idempotent behavior needed for rerunning tests on the same environment
 func TestIdempotentGitPush(t *testing.T) {
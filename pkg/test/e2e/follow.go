@@ -0,0 +1,72 @@
+package e2e
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/logs"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+const (
+	// followLines is the number of last lines printed when the logs start being followed.
+	followLines = 10
+	// followRetryInterval is how long to wait before following the logs again
+	// once the command stopped, e.g. because the target VM rebooted.
+	followRetryInterval = 5 * time.Second
+)
+
+// FollowServiceLogs writes the logs of the edge-cd service on the target VM of
+// env to w as they are produced, until ctx is cancelled. The logs are followed
+// again if the connection is lost, e.g. while the target VM reboots, so that
+// they can be tailed for the whole bootstrap test.
+//
+// It returns nil once ctx is cancelled.
+func FollowServiceLogs(ctx context.Context, env *TestEnvironment, w io.Writer) error {
+	if env == nil || env.ID == "" {
+		return errInvalidTestEnvironment
+	}
+	if env.TargetVM.IP == "" {
+		return errTargetVMIPNotSet
+	}
+
+	sshClient, err := ssh.NewClient(env.TargetVM.IP, "ubuntu", env.SSHKeys.HostKeyPath, "22")
+	if err != nil {
+		return flaterrors.Join(err, errCreateSSHClientForExecutor)
+	}
+
+	execCtx := execcontext.New(nil, []string{"sudo", "-E"})
+	return followServiceLogs(ctx, execCtx, sshClient, w, followRetryInterval)
+}
+
+func followServiceLogs(
+	ctx context.Context,
+	execCtx execcontext.Context,
+	runner ssh.ContextStreamRunner,
+	w io.Writer,
+	retryInterval time.Duration,
+) error {
+	for {
+		// The log file may only be created once edge-cd is installed, so the
+		// command is picked again on each attempt
+		cmd, err := logs.Command(execCtx, runner, logs.Options{Follow: true, Lines: followLines})
+		if err != nil {
+			return err
+		}
+		err = runner.StreamContext(ctx, execCtx, w, w, cmd...)
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.Debug("stopped following edge-cd service logs, retrying", "cmd", cmd, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retryInterval):
+		}
+	}
+}
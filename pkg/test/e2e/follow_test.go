@@ -0,0 +1,88 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/edgectl/logs"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStreamRunner counts the streams started on a MockRunner.
+type countingStreamRunner struct {
+	*ssh.MockRunner
+	streams atomic.Int32
+}
+
+func (r *countingStreamRunner) StreamContext(
+	ctx context.Context,
+	execCtx execcontext.Context,
+	stdout, stderr io.Writer,
+	cmd ...string,
+) error {
+	r.streams.Add(1)
+	return r.MockRunner.StreamContext(ctx, execCtx, stdout, stderr, cmd...)
+}
+
+func TestFollowServiceLogs(t *testing.T) {
+	execCtx := execcontext.New(nil, []string{"sudo", "-E"})
+	tail := execcontext.FormatCmd(execCtx, "tail", "-n", "10", "-F", logs.LogFilePath)
+	canned := "time=2025-01-01T00:00:00Z level=INFO msg=\"Reconciling files\"\n"
+
+	t.Run("should follow the logs until cancelled", func(t *testing.T) {
+		mock := ssh.NewMockRunner()
+		mock.Follow = true
+		mock.SetResponse(tail, canned, "", nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var out bytes.Buffer
+		done := make(chan error, 1)
+		go func() { done <- followServiceLogs(ctx, execCtx, mock, &out, time.Hour) }()
+
+		require.Eventually(t, func() bool { return mock.AssertCommandRun(tail) == nil }, 5*time.Second, 10*time.Millisecond)
+		cancel()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("followServiceLogs did not return after cancellation")
+		}
+		assert.Equal(t, canned, out.String())
+		require.NoError(t, mock.AssertNumberOfCommandsRun(2))
+	})
+
+	t.Run("should follow the logs again once the command stopped", func(t *testing.T) {
+		runner := &countingStreamRunner{MockRunner: ssh.NewMockRunner()}
+		runner.SetResponse(tail, "", "", assert.AnError)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- followServiceLogs(ctx, execCtx, runner, &bytes.Buffer{}, time.Millisecond) }()
+
+		require.Eventually(t, func() bool { return runner.streams.Load() >= 3 }, 5*time.Second, 10*time.Millisecond)
+		cancel()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("followServiceLogs did not return after cancellation")
+		}
+	})
+
+	t.Run("should fail without a target VM", func(t *testing.T) {
+		err := FollowServiceLogs(context.Background(), &TestEnvironment{ID: "e2e-test"}, &bytes.Buffer{})
+		require.ErrorIs(t, err, errTargetVMIPNotSet)
+
+		err = FollowServiceLogs(context.Background(), nil, &bytes.Buffer{})
+		require.ErrorIs(t, err, errInvalidTestEnvironment)
+	})
+}
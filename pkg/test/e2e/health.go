@@ -0,0 +1,197 @@
+package e2e
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+)
+
+// HealthCheck is the result of checking one component of a test environment.
+type HealthCheck struct {
+	// Component is the checked part of the environment, e.g. "target VM" or "repo edge-cd"
+	Component string
+	// Check names what was verified: "domain", "ssh" or "repo"
+	Check string
+	// OK is true if the component passed the check
+	OK bool
+	// Detail is the observed state or the reason of the failure
+	Detail string
+}
+
+// HealthReport lists the HealthChecks of a test environment.
+type HealthReport struct {
+	EnvID  string
+	Checks []HealthCheck
+}
+
+// Healthy returns true if every check passed.
+func (r *HealthReport) Healthy() bool {
+	for _, c := range r.Checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTable writes the checks as an aligned table.
+func (r *HealthReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMPONENT\tCHECK\tSTATUS\tDETAIL")
+	for _, c := range r.Checks {
+		status := "OK"
+		if !c.OK {
+			status = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Component, c.Check, status, c.Detail)
+	}
+	return tw.Flush()
+}
+
+// DomainStateFunc returns the state of a libvirt domain (e.g. "running").
+type DomainStateFunc func(ctx execcontext.Context, name string) (string, error)
+
+// RepoCheckFunc checks the git repository at repoURL can be read with the private key at keyPath.
+type RepoCheckFunc func(ctx execcontext.Context, repoURL, keyPath string) error
+
+// HealthChecker checks the components of a test environment are up and reachable.
+// Each dependency can be replaced for testing.
+type HealthChecker struct {
+	DomainState DomainStateFunc
+	NewRunner   RunnerFactory
+	CheckRepo   RepoCheckFunc
+}
+
+// CheckHealth checks the VMs of env exist and are running, accept SSH
+// connections with the host key, and that the git server serves the repos of
+// the environment. Unreachable components are reported in the HealthReport,
+// the error is only returned if libvirt cannot be reached at all.
+func CheckHealth(ctx execcontext.Context, env *TestEnvironment) (*HealthReport, error) {
+	vmManager, err := vmm.NewVMM()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to libvirt: %w", err)
+	}
+	defer vmManager.Close()
+
+	checker := HealthChecker{
+		DomainState: vmManager.DomainState,
+		NewRunner:   newSSHRunner,
+		CheckRepo:   lsRemote,
+	}
+	return checker.Check(ctx, env), nil
+}
+
+// Check runs every check against env. A VM whose domain is not running is not
+// checked over SSH, and the repos are only checked if the git server is reachable.
+func (h HealthChecker) Check(ctx execcontext.Context, env *TestEnvironment) *HealthReport {
+	report := &HealthReport{EnvID: env.ID}
+
+	h.checkVM(ctx, report, env.TargetVM.Name, targetVMAccess(env.TargetVM.IP), env.SSHKeys.HostKeyPath)
+	gitServerReachable := h.checkVM(
+		ctx, report, env.GitServerVM.Name, gitServerAccess(env.GitServerVM.IP), env.SSHKeys.HostKeyPath)
+
+	// Repos are reported in a stable order
+	names := make([]string, 0, len(env.GitSSHURLs))
+	for name := range env.GitSSHURLs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		check := HealthCheck{Component: "repo " + name, Check: "repo"}
+		if !gitServerReachable {
+			check.Detail = "skipped: git server is not reachable"
+		} else if err := h.CheckRepo(ctx, env.GitSSHURLs[name], env.SSHKeys.HostKeyPath); err != nil {
+			check.Detail = err.Error()
+		} else {
+			check.OK = true
+			check.Detail = env.GitSSHURLs[name]
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	return report
+}
+
+// checkVM reports the domain and SSH checks of a VM and returns true if it is reachable.
+func (h HealthChecker) checkVM(
+	ctx execcontext.Context,
+	report *HealthReport,
+	name string,
+	access keyAccess,
+	keyPath string,
+) bool {
+	if !h.checkDomain(ctx, report, access.name, name) {
+		report.Checks = append(report.Checks, HealthCheck{
+			Component: access.name,
+			Check:     "ssh",
+			Detail:    "skipped: domain is not running",
+		})
+		return false
+	}
+	return h.checkSSH(ctx, report, access, keyPath)
+}
+
+// checkDomain reports the state of the domain and returns true if it is running.
+func (h HealthChecker) checkDomain(
+	ctx execcontext.Context,
+	report *HealthReport,
+	component, name string,
+) bool {
+	check := HealthCheck{Component: component, Check: "domain"}
+	defer func() { report.Checks = append(report.Checks, check) }()
+
+	if name == "" {
+		check.Detail = "no VM recorded in the environment"
+		return false
+	}
+
+	state, err := h.DomainState(ctx, name)
+	if err != nil {
+		check.Detail = err.Error()
+		return false
+	}
+
+	check.OK = state == "running"
+	check.Detail = fmt.Sprintf("%s (%s)", name, state)
+	return check.OK
+}
+
+// checkSSH reports whether the host key can log in to the VM of access.
+func (h HealthChecker) checkSSH(
+	ctx execcontext.Context,
+	report *HealthReport,
+	access keyAccess,
+	keyPath string,
+) bool {
+	check := HealthCheck{Component: access.name, Check: "ssh"}
+	if err := runKeyCommand(ctx, h.NewRunner, access, keyPath, access.pingCmd); err != nil {
+		check.Detail = err.Error()
+	} else {
+		check.OK = true
+		check.Detail = fmt.Sprintf("%s@%s", access.user, access.host)
+	}
+	report.Checks = append(report.Checks, check)
+	return check.OK
+}
+
+// lsRemote is the default RepoCheckFunc: it lists the refs of the repository
+// and fails if the repository has none.
+func lsRemote(ctx execcontext.Context, repoURL, keyPath string) error {
+	cmd := exec.Command("git", "ls-remote", "--heads", repoURL)
+	cmd.Env = gitSSHEnv(keyPath)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git ls-remote failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	if strings.TrimSpace(string(output)) == "" {
+		return fmt.Errorf("repository has no branch")
+	}
+	return nil
+}
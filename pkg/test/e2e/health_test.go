@@ -0,0 +1,124 @@
+package e2e
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// healthStatus maps "component/check" to whether the check passed.
+func healthStatus(report *HealthReport) map[string]bool {
+	status := make(map[string]bool)
+	for _, c := range report.Checks {
+		status[c.Component+"/"+c.Check] = c.OK
+	}
+	return status
+}
+
+func newHealthTestEnv(t *testing.T) (*TestEnvironment, map[string]*fakeVM, RunnerFactory) {
+	env, vms, factory := newRotateTestEnv(t)
+	env.GitSSHURLs = map[string]string{
+		"edge-cd":     "ssh://git@192.168.1.101/srv/git/edge-cd.git",
+		"user-config": "ssh://git@192.168.1.101/srv/git/user-config.git",
+	}
+	return env, vms, factory
+}
+
+func runningDomains(states map[string]string) DomainStateFunc {
+	return func(ctx execcontext.Context, name string) (string, error) {
+		if state, ok := states[name]; ok {
+			return state, nil
+		}
+		return "", errors.New("VM not found")
+	}
+}
+
+func TestHealthCheckAllReachable(t *testing.T) {
+	env, _, factory := newHealthTestEnv(t)
+
+	var checkedRepos []string
+	checker := HealthChecker{
+		DomainState: runningDomains(map[string]string{"target": "running", "gitserver": "running"}),
+		NewRunner:   factory,
+		CheckRepo: func(ctx execcontext.Context, repoURL, keyPath string) error {
+			assert.Equal(t, env.SSHKeys.HostKeyPath, keyPath)
+			checkedRepos = append(checkedRepos, repoURL)
+			return nil
+		},
+	}
+
+	report := checker.Check(execcontext.New(nil, nil), env)
+
+	assert.True(t, report.Healthy())
+	assert.Equal(t, map[string]bool{
+		"target VM/domain":      true,
+		"target VM/ssh":         true,
+		"git server/domain":     true,
+		"git server/ssh":        true,
+		"repo edge-cd/repo":     true,
+		"repo user-config/repo": true,
+	}, healthStatus(report))
+	assert.Equal(t, []string{env.GitSSHURLs["edge-cd"], env.GitSSHURLs["user-config"]}, checkedRepos)
+
+	var table bytes.Buffer
+	require.NoError(t, report.WriteTable(&table))
+	assert.Contains(t, table.String(), "COMPONENT")
+	assert.Contains(t, table.String(), "ubuntu@192.168.1.100")
+}
+
+func TestHealthCheckReportsUnreachableComponents(t *testing.T) {
+	env, vms, factory := newHealthTestEnv(t)
+	// The git server no longer accepts the host key
+	vms[env.GitServerVM.IP].authorized = map[string]bool{}
+
+	repoChecked := false
+	checker := HealthChecker{
+		DomainState: runningDomains(map[string]string{"target": "shutoff", "gitserver": "running"}),
+		NewRunner:   factory,
+		CheckRepo: func(ctx execcontext.Context, repoURL, keyPath string) error {
+			repoChecked = true
+			return nil
+		},
+	}
+
+	report := checker.Check(execcontext.New(nil, nil), env)
+
+	assert.False(t, report.Healthy())
+	assert.Equal(t, map[string]bool{
+		"target VM/domain":      false,
+		"target VM/ssh":         false,
+		"git server/domain":     true,
+		"git server/ssh":        false,
+		"repo edge-cd/repo":     false,
+		"repo user-config/repo": false,
+	}, healthStatus(report))
+	assert.False(t, repoChecked, "repos must not be checked when the git server is unreachable")
+	assert.Empty(t, vms[env.TargetVM.IP].commands, "a VM that is not running must not be checked over SSH")
+}
+
+func TestHealthCheckReportsMissingRepo(t *testing.T) {
+	env, _, factory := newHealthTestEnv(t)
+
+	checker := HealthChecker{
+		DomainState: runningDomains(map[string]string{"target": "running", "gitserver": "running"}),
+		NewRunner:   factory,
+		CheckRepo: func(ctx execcontext.Context, repoURL, keyPath string) error {
+			if repoURL == env.GitSSHURLs["user-config"] {
+				return errors.New("repository not found")
+			}
+			return nil
+		},
+	}
+
+	report := checker.Check(execcontext.New(nil, nil), env)
+
+	assert.False(t, report.Healthy())
+	status := healthStatus(report)
+	assert.True(t, status["repo edge-cd/repo"])
+	assert.False(t, status["repo user-config/repo"])
+	assert.True(t, status["git server/ssh"])
+}
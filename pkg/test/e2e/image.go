@@ -0,0 +1,219 @@
+package e2e
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errImageChecksumMismatch = errors.New("VM image checksum mismatch")
+	errVerifyImageChecksum   = errors.New("failed to verify VM image checksum")
+	errImageSizeMismatch     = errors.New("VM image size does not match its Content-Length")
+	errVerifyImageSize       = errors.New("failed to verify VM image size")
+	errImageDownloadCanceled = errors.New("VM image download canceled")
+	errPromoteImage          = errors.New("failed to move downloaded VM image into place")
+)
+
+const (
+	// DefaultImageDownloadAttempts is the number of times an image download is
+	// tried, each attempt resuming from the bytes already downloaded.
+	DefaultImageDownloadAttempts = 5
+	// DefaultImageDownloadRetryDelay is how long to wait between two attempts.
+	DefaultImageDownloadRetryDelay = 5 * time.Second
+)
+
+// ImageProvider fetches VM images into the image cache.
+type ImageProvider interface {
+	// Ensure writes the image at url to dest, whose directory exists. On error,
+	// dest must not be left partially written.
+	Ensure(ctx execcontext.Context, url, dest string) error
+}
+
+// WgetImageProvider downloads VM images with wget. It is the default ImageProvider.
+//
+// The image is downloaded to dest + ".part" and only moved to dest once
+// complete, and verified against its checksum if known, or else against the
+// Content-Length the server reports. An interrupted download
+// resumes from the partial file with an HTTP Range request, whether on the next
+// attempt or on the next call, instead of restarting from scratch.
+type WgetImageProvider struct {
+	// Attempts is the number of times the download is tried.
+	// Default: DefaultImageDownloadAttempts
+	Attempts int
+
+	// RetryDelay is how long to wait between two attempts.
+	// Default: DefaultImageDownloadRetryDelay
+	RetryDelay time.Duration
+
+	// Checksums are the expected hex-encoded SHA-256 of the images, by URL. The
+	// size of the images without checksum is checked against the Content-Length
+	// of a HEAD request instead.
+	Checksums map[string]string
+
+	// Cancel aborts the download between two attempts when closed.
+	Cancel <-chan struct{}
+}
+
+// Ensure downloads the image at url to dest with wget, showing the progress on stderr.
+func (p WgetImageProvider) Ensure(ctx execcontext.Context, url, dest string) error {
+	attempts := p.Attempts
+	if attempts < 1 {
+		attempts = DefaultImageDownloadAttempts
+	}
+	retryDelay := p.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = DefaultImageDownloadRetryDelay
+	}
+	partial := dest + ".part"
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			slog.Warn("VM image download failed, resuming",
+				"url", url, "attempt", attempt, "attempts", attempts, "err", err)
+			select {
+			case <-p.Cancel:
+				return flaterrors.Join(err, fmt.Errorf("url=%s attempt=%d", url, attempt), errImageDownloadCanceled)
+			case <-time.After(retryDelay):
+			}
+		}
+		if err = wgetContinue(url, partial); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		// The partial file is kept, so that the next call resumes the download
+		return flaterrors.Join(err, fmt.Errorf("url=%s attempts=%d", url, attempts), errDownloadImage)
+	}
+
+	if want := p.Checksums[url]; want != "" {
+		if err := verifySHA256(partial, want); err != nil {
+			// A corrupted partial file cannot be resumed
+			os.Remove(partial)
+			return flaterrors.Join(err, fmt.Errorf("url=%s", url), errDownloadImage)
+		}
+	} else if err := verifyContentLength(url, partial); err != nil {
+		if errors.Is(err, errImageSizeMismatch) {
+			os.Remove(partial)
+		}
+		return flaterrors.Join(err, fmt.Errorf("url=%s", url), errDownloadImage)
+	}
+
+	if err := os.Rename(partial, dest); err != nil {
+		return flaterrors.Join(err, fmt.Errorf("dest=%s", dest), errPromoteImage)
+	}
+	return nil
+}
+
+// wgetContinue downloads url to path in a single try, continuing from the
+// content already at path.
+func wgetContinue(url, path string) error {
+	cmd := exec.Command(
+		"wget",
+		"--continue",
+		"--tries=1",
+		"--progress=dot",
+		"-e", "dotbytes=3M",
+		"-O", path,
+		url,
+	)
+
+	// Show progress on stderr
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// verifySHA256 checks the hex-encoded SHA-256 of the file at path is want.
+func verifySHA256(path, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return flaterrors.Join(err, errVerifyImageChecksum)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return flaterrors.Join(err, errVerifyImageChecksum)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+		return flaterrors.Join(fmt.Errorf("sha256=%s want=%s", got, want), errImageChecksumMismatch)
+	}
+	return nil
+}
+
+// verifyContentLength checks the size of the file at path is the Content-Length
+// the server reports for url. An unknown Content-Length is not checked.
+func verifyContentLength(url, path string) error {
+	resp, err := http.Head(url)
+	if err != nil {
+		return flaterrors.Join(err, errVerifyImageSize)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return flaterrors.Join(fmt.Errorf("status=%s", resp.Status), errVerifyImageSize)
+	}
+	if resp.ContentLength < 0 {
+		slog.Warn("VM image size cannot be verified without Content-Length", "url", url)
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return flaterrors.Join(err, errVerifyImageSize)
+	}
+	if info.Size() != resp.ContentLength {
+		return flaterrors.Join(
+			fmt.Errorf("size=%d contentLength=%d", info.Size(), resp.ContentLength),
+			errImageSizeMismatch,
+		)
+	}
+	return nil
+}
+
+// ensureVMImage makes the image at url available at imageCachePath. A cached
+// image is used as is; a missing one is fetched with provider if download is
+// set, and is an error otherwise. The default provider verifies the image
+// against checksum, its hex-encoded SHA-256, if set.
+func ensureVMImage(
+	ctx execcontext.Context,
+	provider ImageProvider,
+	download bool,
+	url, checksum, imageCachePath string,
+) error {
+	if _, err := os.Stat(imageCachePath); !os.IsNotExist(err) {
+		return nil
+	}
+	if !download {
+		return flaterrors.Join(fmt.Errorf("imageCachePath=%s", imageCachePath), errVMImageNotFound)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(imageCachePath), 0o755); err != nil {
+		return flaterrors.Join(err, errCreateImageCacheDir)
+	}
+	if provider == nil {
+		wget := WgetImageProvider{}
+		if checksum != "" {
+			wget.Checksums = map[string]string{url: checksum}
+		}
+		provider = wget
+	}
+	if err := provider.Ensure(ctx, url, imageCachePath); err != nil {
+		return flaterrors.Join(err, errDownloadVMImage)
+	}
+	return nil
+}
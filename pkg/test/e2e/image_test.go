@@ -0,0 +1,298 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeImageProvider records the fetched images and writes content to dest, or fails with err.
+type fakeImageProvider struct {
+	content string
+	err     error
+	calls   []string
+}
+
+func (p *fakeImageProvider) Ensure(ctx execcontext.Context, url, dest string) error {
+	p.calls = append(p.calls, url+" -> "+dest)
+	if p.err != nil {
+		return p.err
+	}
+	return os.WriteFile(dest, []byte(p.content), 0o644)
+}
+
+const testImageURL = "https://cloud-images.example.com/noble.img"
+
+// TestEnsureVMImageCacheMiss verifies a missing image is fetched with the provider
+func TestEnsureVMImageCacheMiss(t *testing.T) {
+	imageCachePath := filepath.Join(t.TempDir(), "cache", "noble.img")
+	provider := &fakeImageProvider{content: "image"}
+
+	err := ensureVMImage(execcontext.New(nil, nil), provider, true, testImageURL, "", imageCachePath)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{testImageURL + " -> " + imageCachePath}, provider.calls)
+	content, err := os.ReadFile(imageCachePath)
+	require.NoError(t, err, "the cache directory should be created for the provider")
+	assert.Equal(t, "image", string(content))
+}
+
+// TestEnsureVMImageCached verifies a cached image is used without calling the provider
+func TestEnsureVMImageCached(t *testing.T) {
+	imageCachePath := filepath.Join(t.TempDir(), "noble.img")
+	require.NoError(t, os.WriteFile(imageCachePath, []byte("cached"), 0o644))
+	provider := &fakeImageProvider{content: "image"}
+
+	for _, download := range []bool{true, false} {
+		err := ensureVMImage(execcontext.New(nil, nil), provider, download, testImageURL, "", imageCachePath)
+		require.NoError(t, err)
+	}
+
+	assert.Empty(t, provider.calls, "the provider should not be called on a cache hit")
+	content, err := os.ReadFile(imageCachePath)
+	require.NoError(t, err)
+	assert.Equal(t, "cached", string(content))
+}
+
+// TestEnsureVMImageDownloadDisabled verifies a missing image is an error without DownloadImages
+func TestEnsureVMImageDownloadDisabled(t *testing.T) {
+	imageCachePath := filepath.Join(t.TempDir(), "noble.img")
+	provider := &fakeImageProvider{content: "image"}
+
+	err := ensureVMImage(execcontext.New(nil, nil), provider, false, testImageURL, "", imageCachePath)
+	assert.ErrorIs(t, err, errVMImageNotFound)
+	assert.Empty(t, provider.calls)
+}
+
+// TestEnsureVMImageProviderError verifies the error of the provider is returned
+func TestEnsureVMImageProviderError(t *testing.T) {
+	imageCachePath := filepath.Join(t.TempDir(), "noble.img")
+	providerErr := errors.New("access denied")
+	provider := &fakeImageProvider{err: providerErr}
+
+	err := ensureVMImage(execcontext.New(nil, nil), provider, true, testImageURL, "", imageCachePath)
+	assert.ErrorIs(t, err, providerErr)
+	assert.ErrorIs(t, err, errDownloadVMImage)
+}
+
+// rangeServer serves content with support for HTTP Range requests, recording
+// the Range header of each request. The first truncateFirst bytes only are sent
+// on the first request, before the connection is closed.
+type rangeServer struct {
+	content       []byte
+	truncateFirst int
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	first := len(s.ranges) == 1
+	s.mu.Unlock()
+
+	if first && s.truncateFirst > 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(s.content[:s.truncateFirst])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	http.ServeContent(w, r, "noble.img", time.Time{}, bytes.NewReader(s.content))
+}
+
+func (s *rangeServer) requestedRanges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func newTestImage() []byte {
+	return bytes.Repeat([]byte("edge-cd VM image\n"), 4096)
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func requireWget(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("wget"); err != nil {
+		t.Skip("wget not found")
+	}
+}
+
+// TestWgetImageProviderResumesPartialFile verifies a partial file left by a
+// previous download is resumed with a Range request instead of restarted
+func TestWgetImageProviderResumesPartialFile(t *testing.T) {
+	requireWget(t)
+	content := newTestImage()
+	server := &rangeServer{content: content}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "noble.img")
+	half := len(content) / 2
+	require.NoError(t, os.WriteFile(dest+".part", content[:half], 0o644))
+
+	provider := WgetImageProvider{Checksums: map[string]string{ts.URL: sha256Hex(content)}}
+	require.NoError(t, provider.Ensure(execcontext.New(nil, nil), ts.URL, dest))
+
+	assert.Equal(t, []string{fmt.Sprintf("bytes=%d-", half)}, server.requestedRanges())
+	got, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+	assert.NoFileExists(t, dest+".part")
+}
+
+// TestWgetImageProviderRetriesInterruptedDownload verifies an interrupted
+// download is retried from where it stopped
+func TestWgetImageProviderRetriesInterruptedDownload(t *testing.T) {
+	requireWget(t)
+	content := newTestImage()
+	server := &rangeServer{content: content, truncateFirst: len(content) / 3}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "noble.img")
+	provider := WgetImageProvider{
+		RetryDelay: time.Millisecond,
+		Checksums:  map[string]string{ts.URL: sha256Hex(content)},
+	}
+	require.NoError(t, provider.Ensure(execcontext.New(nil, nil), ts.URL, dest))
+
+	ranges := server.requestedRanges()
+	require.Len(t, ranges, 2)
+	assert.Empty(t, ranges[0])
+	assert.Equal(t, fmt.Sprintf("bytes=%d-", len(content)/3), ranges[1])
+	got, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+}
+
+// TestWgetImageProviderChecksumMismatch verifies a corrupted download is
+// removed instead of being moved into the cache
+func TestWgetImageProviderChecksumMismatch(t *testing.T) {
+	requireWget(t)
+	content := newTestImage()
+	ts := httptest.NewServer(&rangeServer{content: content})
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "noble.img")
+	provider := WgetImageProvider{Checksums: map[string]string{ts.URL: sha256Hex([]byte("another image"))}}
+	err := provider.Ensure(execcontext.New(nil, nil), ts.URL, dest)
+
+	assert.ErrorIs(t, err, errImageChecksumMismatch)
+	assert.NoFileExists(t, dest)
+	assert.NoFileExists(t, dest+".part", "a corrupted partial file cannot be resumed")
+}
+
+// TestWgetImageProviderAttemptsExhausted verifies the download gives up after
+// the configured attempts, keeping the partial file to resume later
+func TestWgetImageProviderAttemptsExhausted(t *testing.T) {
+	requireWget(t)
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "noble.img")
+	provider := WgetImageProvider{Attempts: 3, RetryDelay: time.Millisecond}
+	err := provider.Ensure(execcontext.New(nil, nil), ts.URL, dest)
+
+	assert.ErrorIs(t, err, errDownloadImage)
+	assert.Equal(t, int32(3), requests.Load())
+	assert.NoFileExists(t, dest)
+}
+
+// TestWgetImageProviderCanceled verifies a canceled download stops waiting for
+// the next attempt
+func TestWgetImageProviderCanceled(t *testing.T) {
+	requireWget(t)
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	cancel := make(chan struct{})
+	close(cancel)
+	dest := filepath.Join(t.TempDir(), "noble.img")
+	provider := WgetImageProvider{Attempts: 3, RetryDelay: time.Hour, Cancel: cancel}
+	err := provider.Ensure(execcontext.New(nil, nil), ts.URL, dest)
+
+	assert.ErrorIs(t, err, errImageDownloadCanceled)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+// TestWgetImageProviderChecksContentLength verifies the size of a download
+// without checksum is checked against its Content-Length
+func TestWgetImageProviderChecksContentLength(t *testing.T) {
+	requireWget(t)
+	content := newTestImage()
+	ts := httptest.NewServer(&rangeServer{content: content})
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "noble.img")
+	require.NoError(t, WgetImageProvider{}.Ensure(execcontext.New(nil, nil), ts.URL, dest))
+
+	got, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+}
+
+// TestWgetImageProviderContentLengthMismatch verifies a download without
+// checksum whose size is not its Content-Length is removed
+func TestWgetImageProviderContentLengthMismatch(t *testing.T) {
+	requireWget(t)
+	content := newTestImage()
+	ts := httptest.NewServer(&rangeServer{content: content})
+	defer ts.Close()
+
+	dest := filepath.Join(t.TempDir(), "noble.img")
+	// A stale partial file longer than the image is not resumed by wget
+	stale := append(append([]byte(nil), content...), "stale"...)
+	require.NoError(t, os.WriteFile(dest+".part", stale, 0o644))
+
+	err := WgetImageProvider{Attempts: 1}.Ensure(execcontext.New(nil, nil), ts.URL, dest)
+
+	assert.ErrorIs(t, err, errImageSizeMismatch)
+	assert.NoFileExists(t, dest)
+	assert.NoFileExists(t, dest+".part")
+}
+
+// TestEnsureVMImageVerifiesChecksum verifies the checksum is passed to the
+// default provider
+func TestEnsureVMImageVerifiesChecksum(t *testing.T) {
+	requireWget(t)
+	content := newTestImage()
+	ts := httptest.NewServer(&rangeServer{content: content})
+	defer ts.Close()
+
+	imageCachePath := filepath.Join(t.TempDir(), "noble.img")
+	err := ensureVMImage(execcontext.New(nil, nil), nil, true, ts.URL, sha256Hex([]byte("another image")), imageCachePath)
+
+	assert.ErrorIs(t, err, errImageChecksumMismatch)
+	assert.NoFileExists(t, imageCachePath)
+}
@@ -0,0 +1,77 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errDomainNameRequired = errors.New("domain name is required")
+	errImportTargetVM     = errors.New("failed to import target VM")
+)
+
+// ImportDomainFunc reads the metadata of an existing libvirt domain.
+type ImportDomainFunc func(ctx context.Context, name string) (*vmm.VMMetadata, error)
+
+// ImportConfig configures ImportEnvironment.
+type ImportConfig struct {
+	// DomainName is the existing libvirt domain used as the target VM
+	DomainName string
+
+	// HostKeyPath is the private key logging in to the VM as ubuntu. The public
+	// key is expected at HostKeyPath + ".pub". Optional, but the commands
+	// connecting to the VM fail without it.
+	HostKeyPath string
+
+	// Own makes teardown destroy and undefine the domain. By default teardown
+	// only forgets the environment and leaves the domain untouched.
+	Own bool
+}
+
+// ImportEnvironment registers an existing libvirt domain, e.g. a VM created by
+// hand, as the target VM of a new test environment. The environment has no git
+// server. The caller is responsible for saving it to the artifact store.
+func ImportEnvironment(ctx execcontext.Context, config ImportConfig) (*TestEnvironment, error) {
+	vmManager, err := vmm.NewVMM()
+	if err != nil {
+		return nil, flaterrors.Join(err, errCreateVMM)
+	}
+	defer vmManager.Close()
+
+	return importEnvironment(ctx, config, vmManager.ImportDomain)
+}
+
+func importEnvironment(
+	ctx execcontext.Context,
+	config ImportConfig,
+	importDomain ImportDomainFunc,
+) (*TestEnvironment, error) {
+	if config.DomainName == "" {
+		return nil, errDomainNameRequired
+	}
+
+	targetVM, err := importDomain(context.Background(), config.DomainName)
+	if err != nil {
+		return nil, flaterrors.Join(err, fmt.Errorf("domain=%s", config.DomainName), errImportTargetVM)
+	}
+
+	env, err := NewManager("").CreateEnvironment(ctx)
+	if err != nil {
+		return nil, flaterrors.Join(err, errCreateTestEnvironment)
+	}
+	env.TargetVM = *targetVM
+	env.KeepTargetVM = !config.Own
+	env.Status = StatusCreated
+	env.Notes = fmt.Sprintf("imported from libvirt domain %s", config.DomainName)
+	if config.HostKeyPath != "" {
+		env.SSHKeys.HostKeyPath = config.HostKeyPath
+		env.SSHKeys.HostKeyPubPath = config.HostKeyPath + ".pub"
+	}
+
+	return env, nil
+}
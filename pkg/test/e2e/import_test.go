@@ -0,0 +1,60 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestImportEnvironment verifies an existing domain imported with the fake
+// libvirt connection becomes the target VM of a new environment
+func TestImportEnvironment(t *testing.T) {
+	conn := vmm.NewFakeConnection()
+	dom, err := conn.DomainDefineXML(`<domain type="kvm"><name>dev-vm</name><memory unit="MiB">1024</memory><vcpu>2</vcpu></domain>`)
+	require.NoError(t, err)
+	require.NoError(t, dom.Create())
+	conn.LeaseIPs["dev-vm"] = "192.168.122.60"
+	vmManager, err := vmm.NewVMM(vmm.WithConnection(conn))
+	require.NoError(t, err)
+
+	env, err := importEnvironment(execcontext.New(nil, nil), ImportConfig{
+		DomainName:  "dev-vm",
+		HostKeyPath: "/home/dev/.ssh/id_ed25519",
+	}, vmManager.ImportDomain)
+	require.NoError(t, err)
+
+	assert.Regexp(t, `^e2e-\d{8}-[a-zA-Z0-9]{8}$`, env.ID)
+	assert.Equal(t, StatusCreated, env.Status)
+	assert.Equal(t, "dev-vm", env.TargetVM.Name)
+	assert.Equal(t, "192.168.122.60", env.TargetVM.IP)
+	assert.Equal(t, uint(1024), env.TargetVM.MemoryMB)
+	assert.Equal(t, uint(2), env.TargetVM.VCPUs)
+	assert.Equal(t, "/home/dev/.ssh/id_ed25519.pub", env.SSHKeys.HostKeyPubPath)
+	// Without ownership, teardown leaves the domain and removes nothing
+	assert.True(t, env.KeepTargetVM)
+	assert.Empty(t, env.ManagedResources)
+	assert.Empty(t, env.TempDirRoot)
+
+	owned, err := importEnvironment(execcontext.New(nil, nil), ImportConfig{DomainName: "dev-vm", Own: true}, vmManager.ImportDomain)
+	require.NoError(t, err)
+	assert.False(t, owned.KeepTargetVM)
+	assert.NotEqual(t, env.ID, owned.ID)
+}
+
+func TestImportEnvironmentErrors(t *testing.T) {
+	importDomain := func(ctx context.Context, name string) (*vmm.VMMetadata, error) {
+		return nil, errors.New("domain not found")
+	}
+
+	_, err := importEnvironment(execcontext.New(nil, nil), ImportConfig{}, importDomain)
+	assert.ErrorIs(t, err, errDomainNameRequired)
+
+	_, err = importEnvironment(execcontext.New(nil, nil), ImportConfig{DomainName: "missing"}, importDomain)
+	assert.ErrorIs(t, err, errImportTargetVM)
+	assert.ErrorContains(t, err, "domain not found")
+}
@@ -2,8 +2,10 @@ package e2e
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"math/big"
+	"regexp"
 	"sync"
 	"time"
 
@@ -23,20 +25,23 @@ const (
 
 // TestEnvironment represents a complete test execution context
 type TestEnvironment struct {
-	ID               string            // Unique identifier (e.g., "e2e-20231025-abc123")
-	CreatedAt        time.Time         // When the environment was created
-	UpdatedAt        time.Time         // Last time environment was updated
-	TargetVM         vmm.VMMetadata    // Target VM being tested
-	GitServerVM      vmm.VMMetadata    // Git server VM for config repos
-	ArtifactPath     string            // Root directory for all test artifacts
-	BootstrapLogPath string            // Path to the bootstrap command log file (stored in ArtifactPath)
-	TempDirRoot      string            // Root temp directory: /tmp/e2e-<test-id>. All component subdirs created here
-	SSHKeys          SSHKeyInfo        // Paths to SSH keys used in this environment
-	Status           string            // Current status: "setup", "running", "passed", "failed", "cleanup"
-	Notes            string            // Optional notes for this environment
-	GitSSHURLs       map[string]string // Git repository SSH URLs, keyed by repo name
-	ManagedResources []string          // List of files/directories created during test (for audit and cleanup)
-	TempDirs         []string          // Deprecated: kept for backward compatibility. Use TempDirRoot instead.
+	ID                   string            // Unique identifier (e.g., "e2e-20231025-abc123")
+	CreatedAt            time.Time         // When the environment was created
+	UpdatedAt            time.Time         // Last time environment was updated
+	TargetVM             vmm.VMMetadata    // Target VM being tested
+	KeepTargetVM         bool              // Teardown leaves the target VM in place, e.g. a VM imported without taking ownership
+	GitServerVM          vmm.VMMetadata    // Git server VM for config repos
+	ArtifactPath         string            // Root directory for all test artifacts
+	BootstrapLogPath     string            // Path to the bootstrap command log file (stored in ArtifactPath)
+	TargetConsoleLogPath string            // Copy of the target VM serial console log taken after setup (stored in ArtifactPath)
+	TempDirRoot          string            // Root temp directory: /tmp/e2e-<test-id>. All component subdirs created here
+	NetworkName          string            // Dedicated libvirt network for this environment (empty when using the default network)
+	SSHKeys              SSHKeyInfo        // Paths to SSH keys used in this environment
+	Status               string            // Current status: "setup", "running", "passed", "failed", "cleanup"
+	Notes                string            // Optional notes for this environment
+	GitSSHURLs           map[string]string // Git repository SSH URLs, keyed by repo name
+	ManagedResources     []string          // List of files/directories created during test (for audit and cleanup)
+	TempDirs             []string          // Deprecated: kept for backward compatibility. Use TempDirRoot instead.
 }
 
 // SSHKeyInfo stores paths to SSH key files
@@ -70,24 +75,64 @@ type TestEnvironmentManager interface {
 	GetArtifactDir() string
 }
 
+// maxIDAttempts is how many IDs CreateEnvironment generates before giving up
+// when they all collide with existing environments.
+const maxIDAttempts = 10
+
+var (
+	errInvalidEnvironmentID = errors.New("invalid environment ID")
+	errEnvironmentIDTaken   = errors.New("no unused environment ID generated")
+)
+
+// idPattern is the format of the environment IDs: e2e-YYYYMMDD-XXXXXXXX
+var idPattern = regexp.MustCompile(`^e2e-\d{8}-[a-zA-Z0-9]{8}$`)
+
+// ValidateID checks id has the format of the environment IDs, e.g. "e2e-20231025-abc12345".
+func ValidateID(id string) error {
+	if !idPattern.MatchString(id) {
+		return fmt.Errorf("%w: %q does not match %s", errInvalidEnvironmentID, id, idPattern)
+	}
+	return nil
+}
+
+// IDGenerator returns a new environment ID, in the format checked by ValidateID.
+type IDGenerator func() string
+
 // Manager implements TestEnvironmentManager with in-memory storage
 type Manager struct {
 	mu           sync.RWMutex
 	environments map[string]*TestEnvironment
 	artifactDir  string
+	generateID   IDGenerator
+}
+
+// ManagerOption configures a Manager.
+type ManagerOption func(*Manager)
+
+// WithIDGenerator makes the Manager use generateID instead of random IDs, e.g.
+// to get reproducible IDs in tests.
+func WithIDGenerator(generateID IDGenerator) ManagerOption {
+	return func(m *Manager) {
+		m.generateID = generateID
+	}
 }
 
 // NewManager creates a new Manager instance with the given artifact directory
-func NewManager(artifactDir string) *Manager {
-	return &Manager{
+func NewManager(artifactDir string, opts ...ManagerOption) *Manager {
+	m := &Manager{
 		environments: make(map[string]*TestEnvironment),
 		artifactDir:  artifactDir,
+		generateID:   GenerateID,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
-// generateID creates a unique test environment ID in format: e2e-YYYYMMDD-XXXXXXXX
+// GenerateID creates a unique test environment ID in format: e2e-YYYYMMDD-XXXXXXXX
 // where YYYYMMDD is the current date and XXXXXXXX is 8-character random alphanumeric
-func (m *Manager) generateID() string {
+func GenerateID() string {
 	now := time.Now().UTC()
 	dateStr := now.Format("20060102")
 	randomStr := randString(8)
@@ -118,11 +163,9 @@ func (m *Manager) CreateEnvironment(
 	defer m.mu.Unlock()
 
 	now := time.Now().UTC()
-	id := m.generateID()
-
-	// Ensure uniqueness (though extremely unlikely)
-	for m.environmentExists(id) {
-		id = m.generateID()
+	id, err := m.newID()
+	if err != nil {
+		return nil, err
 	}
 
 	env := &TestEnvironment{
@@ -138,6 +181,21 @@ func (m *Manager) CreateEnvironment(
 	return env, nil
 }
 
+// newID returns a valid ID not used by any environment (must be called under lock).
+// Collisions are unlikely with random IDs, but not with a custom IDGenerator.
+func (m *Manager) newID() (string, error) {
+	for range maxIDAttempts {
+		id := m.generateID()
+		if err := ValidateID(id); err != nil {
+			return "", err
+		}
+		if !m.environmentExists(id) {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("%w after %d attempts", errEnvironmentIDTaken, maxIDAttempts)
+}
+
 // environmentExists checks if an environment with the given ID exists (must be called under lock)
 func (m *Manager) environmentExists(id string) bool {
 	_, exists := m.environments[id]
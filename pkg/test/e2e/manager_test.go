@@ -90,6 +90,79 @@ func TestIDUniqueness(t *testing.T) {
 	assert.Equal(t, 100, len(ids), "Should have 100 unique IDs")
 }
 
+// sequentialIDs returns an IDGenerator returning ids in order, then the last one forever.
+func sequentialIDs(ids ...string) IDGenerator {
+	i := 0
+	return func() string {
+		id := ids[min(i, len(ids)-1)]
+		i++
+		return id
+	}
+}
+
+// TestCreateEnvironmentWithIDGenerator verifies the environments get the IDs of the generator
+func TestCreateEnvironmentWithIDGenerator(t *testing.T) {
+	manager := NewManager("/tmp/artifacts", WithIDGenerator(sequentialIDs("e2e-20250101-00000001", "e2e-20250101-00000002")))
+	ctx := execcontext.New(make(map[string]string), []string{})
+
+	env, err := manager.CreateEnvironment(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "e2e-20250101-00000001", env.ID)
+
+	env, err = manager.CreateEnvironment(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "e2e-20250101-00000002", env.ID)
+}
+
+// TestCreateEnvironmentIDCollision verifies a colliding ID is regenerated, up to a bound
+func TestCreateEnvironmentIDCollision(t *testing.T) {
+	ctx := execcontext.New(make(map[string]string), []string{})
+
+	t.Run("should regenerate a colliding ID", func(t *testing.T) {
+		manager := NewManager("/tmp/artifacts", WithIDGenerator(sequentialIDs(
+			"e2e-20250101-00000001", "e2e-20250101-00000001", "e2e-20250101-00000002")))
+
+		first, err := manager.CreateEnvironment(ctx)
+		require.NoError(t, err)
+		second, err := manager.CreateEnvironment(ctx)
+		require.NoError(t, err)
+
+		assert.Equal(t, "e2e-20250101-00000001", first.ID)
+		assert.Equal(t, "e2e-20250101-00000002", second.ID)
+	})
+
+	t.Run("should fail when the generator keeps colliding", func(t *testing.T) {
+		manager := NewManager("/tmp/artifacts", WithIDGenerator(sequentialIDs("e2e-20250101-00000001")))
+
+		_, err := manager.CreateEnvironment(ctx)
+		require.NoError(t, err)
+		_, err = manager.CreateEnvironment(ctx)
+		assert.ErrorIs(t, err, errEnvironmentIDTaken)
+
+		envs, err := manager.ListEnvironments(ctx)
+		require.NoError(t, err)
+		assert.Len(t, envs, 1)
+	})
+}
+
+// TestCreateEnvironmentInvalidGeneratedID verifies the format of the generated IDs is enforced
+func TestCreateEnvironmentInvalidGeneratedID(t *testing.T) {
+	manager := NewManager("/tmp/artifacts", WithIDGenerator(sequentialIDs("test-env-1")))
+	ctx := execcontext.New(make(map[string]string), []string{})
+
+	_, err := manager.CreateEnvironment(ctx)
+	assert.ErrorIs(t, err, errInvalidEnvironmentID)
+}
+
+// TestValidateID verifies the accepted ID format
+func TestValidateID(t *testing.T) {
+	assert.NoError(t, ValidateID("e2e-20231025-abc12345"))
+	assert.NoError(t, ValidateID(GenerateID()))
+	for _, id := range []string{"", "e2e-2023102-abc12345", "e2e-20231025-abc1234", "e2e-20231025-abc-1234", "env-20231025-abc12345"} {
+		assert.ErrorIs(t, ValidateID(id), errInvalidEnvironmentID, "ID %q", id)
+	}
+}
+
 // TestGetEnvironment verifies retrieval of existing environment
 func TestGetEnvironment(t *testing.T) {
 	manager := NewManager("/tmp/artifacts")
@@ -446,11 +519,9 @@ func BenchmarkCreateEnvironment(b *testing.B) {
 
 // BenchmarkIDGeneration measures ID generation performance
 func BenchmarkIDGeneration(b *testing.B) {
-	manager := NewManager("/tmp/artifacts")
-
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = manager.generateID()
+		_ = GenerateID()
 	}
 }
 
@@ -0,0 +1,166 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errInsufficientDiskSpace = errors.New("insufficient disk space")
+	errCheckDiskSpace        = errors.New("failed to check available disk space")
+)
+
+const (
+	// vmImageSize is the space reserved for downloading the VM image.
+	// The Ubuntu cloud image is about 600MiB.
+	vmImageSize uint64 = 1 << 30
+
+	// vmOverlaySize is the space reserved for the qcow2 overlay of each VM.
+	// Overlays start small but grow as cloud-init installs packages.
+	vmOverlaySize uint64 = 2 << 30
+
+	// vmCount is the number of VMs of a test environment: the target and the git server.
+	vmCount = 2
+)
+
+// DiskStat describes the filesystem holding a path.
+type DiskStat struct {
+	// Device identifies the filesystem, so requirements on the same one are summed
+	Device uint64
+	// Available is the number of bytes available to unprivileged users
+	Available uint64
+}
+
+// DiskStatFunc returns the DiskStat of the filesystem holding path.
+type DiskStatFunc func(path string) (DiskStat, error)
+
+// StatDisk is the default DiskStatFunc. If path does not exist yet, the
+// filesystem of its closest existing parent is used.
+func StatDisk(path string) (DiskStat, error) {
+	path = existingParent(path)
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return DiskStat{}, err
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return DiskStat{}, err
+	}
+
+	return DiskStat{
+		Device:    uint64(st.Dev),
+		Available: fs.Bavail * uint64(fs.Bsize),
+	}, nil
+}
+
+// existingParent returns path or its closest existing parent.
+func existingParent(path string) string {
+	path = filepath.Clean(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// diskRequirement is the space an operation of the setup needs under a path.
+type diskRequirement struct {
+	path   string
+	bytes  uint64
+	reason string
+}
+
+// preflightDiskSpace checks that the image cache and the temp directory have
+// room for the image download and the VM overlays, so the setup fails early
+// with a clear message instead of in the middle of a download or VM creation.
+func preflightDiskSpace(
+	stat DiskStatFunc,
+	config SetupConfig,
+	imageCachePath, tempDirRoot string,
+) error {
+	var reqs []diskRequirement
+
+	if _, err := os.Stat(imageCachePath); os.IsNotExist(err) && config.DownloadImages {
+		reqs = append(reqs, diskRequirement{
+			path:   config.ImageCacheDir,
+			bytes:  vmImageSize,
+			reason: "the VM image download",
+		})
+	}
+
+	reqs = append(reqs, diskRequirement{
+		path:   tempDirRoot,
+		bytes:  vmCount * vmOverlaySize,
+		reason: fmt.Sprintf("the disk overlays of %d VMs", vmCount),
+	})
+
+	return checkDiskSpace(stat, reqs)
+}
+
+// checkDiskSpace verifies each filesystem has room for the sum of the
+// requirements it holds.
+func checkDiskSpace(stat DiskStatFunc, reqs []diskRequirement) error {
+	type usage struct {
+		stat    DiskStat
+		needed  uint64
+		paths   []string
+		reasons []string
+	}
+
+	var devices []uint64
+	usages := make(map[uint64]*usage)
+	for _, req := range reqs {
+		st, err := stat(req.path)
+		if err != nil {
+			return flaterrors.Join(err, fmt.Errorf("path=%s", req.path), errCheckDiskSpace)
+		}
+
+		u, ok := usages[st.Device]
+		if !ok {
+			u = &usage{stat: st}
+			usages[st.Device] = u
+			devices = append(devices, st.Device)
+		}
+		u.needed += req.bytes
+		u.paths = append(u.paths, req.path)
+		u.reasons = append(u.reasons, req.reason)
+	}
+
+	for _, dev := range devices {
+		u := usages[dev]
+		if u.stat.Available < u.needed {
+			return flaterrors.Join(
+				fmt.Errorf(
+					"%s needs %s for %s but only %s is available",
+					strings.Join(u.paths, " and "),
+					formatBytes(u.needed),
+					strings.Join(u.reasons, " and "),
+					formatBytes(u.stat.Available),
+				),
+				errInsufficientDiskSpace,
+			)
+		}
+	}
+
+	return nil
+}
+
+// formatBytes formats a size in GiB, or MiB below 1GiB.
+func formatBytes(b uint64) string {
+	if b >= 1<<30 {
+		return fmt.Sprintf("%.1fGiB", float64(b)/(1<<30))
+	}
+	return fmt.Sprintf("%.1fMiB", float64(b)/(1<<20))
+}
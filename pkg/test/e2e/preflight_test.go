@@ -0,0 +1,144 @@
+package e2e
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDiskStat reports the DiskStat configured for the closest configured parent of a path.
+type fakeDiskStat struct {
+	stats map[string]DiskStat
+	calls []string
+}
+
+func (f *fakeDiskStat) stat(path string) (DiskStat, error) {
+	f.calls = append(f.calls, path)
+	for p := path; ; p = filepath.Dir(p) {
+		if st, ok := f.stats[p]; ok {
+			return st, nil
+		}
+		if p == filepath.Dir(p) {
+			return DiskStat{}, errors.New("no such filesystem")
+		}
+	}
+}
+
+func TestPreflightDiskSpace(t *testing.T) {
+	cacheDir := t.TempDir()
+	tempDirRoot := filepath.Join(t.TempDir(), "e2e-test")
+	missingImage := filepath.Join(cacheDir, "image.img")
+
+	cachedImage := filepath.Join(cacheDir, "cached.img")
+	require.NoError(t, os.WriteFile(cachedImage, []byte("qcow2"), 0o644))
+
+	const gib = uint64(1 << 30)
+
+	tests := []struct {
+		name           string
+		downloadImages bool
+		imagePath      string
+		stats          map[string]DiskStat
+		wantErr        bool
+	}{
+		{
+			name:           "enough space on separate filesystems",
+			downloadImages: true,
+			imagePath:      missingImage,
+			stats: map[string]DiskStat{
+				cacheDir:    {Device: 1, Available: 1 * gib},
+				tempDirRoot: {Device: 2, Available: 4 * gib},
+			},
+		},
+		{
+			name:           "not enough space for the image download",
+			downloadImages: true,
+			imagePath:      missingImage,
+			stats: map[string]DiskStat{
+				cacheDir:    {Device: 1, Available: 500 << 20},
+				tempDirRoot: {Device: 2, Available: 100 * gib},
+			},
+			wantErr: true,
+		},
+		{
+			name:           "not enough space for the VM overlays",
+			downloadImages: true,
+			imagePath:      missingImage,
+			stats: map[string]DiskStat{
+				cacheDir:    {Device: 1, Available: 100 * gib},
+				tempDirRoot: {Device: 2, Available: 3 * gib},
+			},
+			wantErr: true,
+		},
+		{
+			name:           "image and overlays share a filesystem too small for both",
+			downloadImages: true,
+			imagePath:      missingImage,
+			stats: map[string]DiskStat{
+				cacheDir:    {Device: 1, Available: 4 * gib},
+				tempDirRoot: {Device: 1, Available: 4 * gib},
+			},
+			wantErr: true,
+		},
+		{
+			name:      "cached image needs no room for a download",
+			imagePath: cachedImage,
+			stats: map[string]DiskStat{
+				cacheDir:    {Device: 1, Available: 0},
+				tempDirRoot: {Device: 1, Available: 4 * gib},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDiskStat{stats: tt.stats}
+			config := SetupConfig{ImageCacheDir: cacheDir, DownloadImages: tt.downloadImages}
+
+			err := preflightDiskSpace(fake.stat, config, tt.imagePath, tempDirRoot)
+
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errInsufficientDiskSpace)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPreflightDiskSpaceMessage(t *testing.T) {
+	tempDirRoot := "/tmp/e2e-test"
+	fake := &fakeDiskStat{stats: map[string]DiskStat{"/tmp": {Device: 1, Available: 1 << 30}}}
+
+	err := preflightDiskSpace(fake.stat, SetupConfig{ImageCacheDir: "/cache"}, "/cache/image.img", tempDirRoot)
+
+	require.ErrorIs(t, err, errInsufficientDiskSpace)
+	assert.Contains(t, err.Error(), "/tmp/e2e-test needs 4.0GiB for the disk overlays of 2 VMs but only 1.0GiB is available")
+	// The image is not downloaded, so the cache is not checked
+	assert.Equal(t, []string{tempDirRoot}, fake.calls)
+}
+
+func TestPreflightDiskSpaceStatError(t *testing.T) {
+	fake := &fakeDiskStat{stats: map[string]DiskStat{}}
+
+	err := preflightDiskSpace(fake.stat, SetupConfig{ImageCacheDir: "/cache"}, "/cache/image.img", "/tmp/e2e-test")
+
+	assert.ErrorIs(t, err, errCheckDiskSpace)
+}
+
+func TestStatDisk(t *testing.T) {
+	dir := t.TempDir()
+
+	st, err := StatDisk(dir)
+	require.NoError(t, err)
+	assert.NotZero(t, st.Available)
+
+	// A path that does not exist yet is checked on its closest existing parent
+	missing, err := StatDisk(filepath.Join(dir, "not", "created"))
+	require.NoError(t, err)
+	assert.Equal(t, st.Device, missing.Device)
+}
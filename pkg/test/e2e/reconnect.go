@@ -0,0 +1,138 @@
+package e2e
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+)
+
+// reconnectIPTimeout bounds the wait for the IP address of the target VM when
+// reconnecting, e.g. while it reboots.
+const reconnectIPTimeout = 60 * time.Second
+
+// hostRunnerFactory returns a runner executing commands on host.
+type hostRunnerFactory func(host string) (ssh.StreamRunner, error)
+
+// reconnectingRunner runs commands on the target VM of a test environment. A
+// reboot triggered by a reconciliation may give the VM another DHCP lease:
+// when a command cannot connect, the IP address of the VM is resolved again
+// and, if it changed, the command is retried once on the new address, which
+// is recorded in the environment.
+type reconnectingRunner struct {
+	env       *TestEnvironment
+	domainIP  DomainIPFunc
+	newRunner hostRunnerFactory
+
+	mu     sync.Mutex
+	runner ssh.StreamRunner
+}
+
+// newReconnectingRunner returns a reconnectingRunner to the target VM of env,
+// resolving its IP address with domainIP and connecting with newRunner.
+func newReconnectingRunner(
+	env *TestEnvironment,
+	domainIP DomainIPFunc,
+	newRunner hostRunnerFactory,
+) (*reconnectingRunner, error) {
+	runner, err := newRunner(env.TargetVM.IP)
+	if err != nil {
+		return nil, err
+	}
+	return &reconnectingRunner{
+		env:       env,
+		domainIP:  domainIP,
+		newRunner: newRunner,
+		runner:    runner,
+	}, nil
+}
+
+func (r *reconnectingRunner) Run(
+	ctx execcontext.Context,
+	cmd ...string,
+) (stdout, stderr string, err error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err = r.Stream(ctx, &stdoutBuf, &stderrBuf, cmd...)
+	return stdoutBuf.String(), stderrBuf.String(), err
+}
+
+// Stream runs the command like Run, but writes its output to stdout and stderr
+// as it is produced. A command that could not connect wrote no output, so it
+// can be retried on the new address.
+func (r *reconnectingRunner) Stream(
+	ctx execcontext.Context,
+	stdout, stderr io.Writer,
+	cmd ...string,
+) error {
+	runner := r.current()
+	err := runner.Stream(ctx, stdout, stderr, cmd...)
+	if !errors.Is(err, ssh.ErrConnect) {
+		return err
+	}
+
+	runner, reconnectErr := r.reconnect(ctx, runner)
+	if reconnectErr != nil {
+		return fmt.Errorf("%w (reconnecting: %w)", err, reconnectErr)
+	}
+	if runner == nil {
+		return err // Same address: the VM is down, not moved
+	}
+	return runner.Stream(ctx, stdout, stderr, cmd...)
+}
+
+func (r *reconnectingRunner) current() ssh.StreamRunner {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runner
+}
+
+// reconnect resolves the IP address of the target VM and returns a runner to
+// its new address, or nil if it did not change. failed is the runner that could
+// not connect: if another command already reconnected, its runner is reused.
+func (r *reconnectingRunner) reconnect(ctx execcontext.Context, failed ssh.StreamRunner) (ssh.StreamRunner, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runner != failed {
+		return r.runner, nil
+	}
+
+	ip, err := r.domainIP(ctx, r.env.TargetVM.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the IP address of %s: %w", r.env.TargetVM.Name, err)
+	}
+	if ip == r.env.TargetVM.IP {
+		return nil, nil
+	}
+
+	runner, err := r.newRunner(ip)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("target VM IP address changed, reconnecting",
+		"vm", r.env.TargetVM.Name, "oldIP", r.env.TargetVM.IP, "newIP", ip)
+	r.env.TargetVM.IP = ip
+	r.runner = runner
+	return runner, nil
+}
+
+// libvirtDomainIP is the default DomainIPFunc of the executor: it looks the
+// domain up through a new libvirt connection and waits for its IP address.
+func libvirtDomainIP(ctx execcontext.Context, name string) (string, error) {
+	vmManager, err := vmm.NewVMM()
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to libvirt: %w", err)
+	}
+	defer vmManager.Close()
+
+	if _, err := vmManager.DomainState(ctx, name); err != nil {
+		return "", err
+	}
+	return vmManager.GetDomainIP(ctx, name, reconnectIPTimeout)
+}
@@ -0,0 +1,121 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUnreachableRunner returns a runner failing to connect to host, like the
+// SSH client to an address that is no longer leased.
+func newUnreachableRunner(host string) *ssh.MockRunner {
+	runner := ssh.NewMockRunner()
+	runner.DefaultErr = fmt.Errorf("%w to %s:22: dial tcp: no route to host", ssh.ErrConnect, host)
+	return runner
+}
+
+// newRebootedTestEnv returns an environment whose target VM got a new address
+// after a reboot, with the runners to its hosts: the stored address is
+// unreachable, 192.168.1.150 answers.
+func newRebootedTestEnv() (*TestEnvironment, map[string]*ssh.MockRunner, hostRunnerFactory) {
+	env := &TestEnvironment{
+		ID:       "e2e-20231025-reboot",
+		TargetVM: vmm.VMMetadata{Name: "target", IP: "192.168.1.100"},
+	}
+	runners := map[string]*ssh.MockRunner{
+		"192.168.1.100": newUnreachableRunner("192.168.1.100"),
+		"192.168.1.150": ssh.NewMockRunner(),
+	}
+	runners["192.168.1.150"].DefaultStdout = "active\n"
+
+	newRunner := func(host string) (ssh.StreamRunner, error) {
+		runner, ok := runners[host]
+		if !ok {
+			return nil, fmt.Errorf("unexpected host %s", host)
+		}
+		return runner, nil
+	}
+	return env, runners, newRunner
+}
+
+func TestReconnectingRunner_RetriesOnNewIP(t *testing.T) {
+	env, runners, newRunner := newRebootedTestEnv()
+	var resolved []string
+	domainIP := func(ctx execcontext.Context, name string) (string, error) {
+		resolved = append(resolved, name)
+		return "192.168.1.150", nil
+	}
+
+	runner, err := newReconnectingRunner(env, domainIP, newRunner)
+	require.NoError(t, err)
+
+	ctx := execcontext.New(nil, nil)
+	stdout, _, err := runner.Run(ctx, "systemctl", "is-active", "edge-cd")
+	require.NoError(t, err, "the command should be retried on the new IP address")
+	assert.Equal(t, "active\n", stdout)
+
+	assert.Equal(t, []string{"target"}, resolved)
+	assert.Equal(t, "192.168.1.150", env.TargetVM.IP, "the new IP address should be recorded in the environment")
+	assert.NoError(t, runners["192.168.1.150"].AssertCommandRun(`"systemctl" "is-active" "edge-cd"`))
+
+	// The next commands use the new address directly
+	_, _, err = runner.Run(ctx, "cat", "/etc/motd")
+	require.NoError(t, err)
+	assert.Len(t, resolved, 1)
+	assert.NoError(t, runners["192.168.1.100"].AssertNumberOfCommandsRun(1))
+}
+
+func TestReconnectingRunner_SameIP(t *testing.T) {
+	env, runners, newRunner := newRebootedTestEnv()
+	domainIP := func(ctx execcontext.Context, name string) (string, error) {
+		return "192.168.1.100", nil
+	}
+
+	runner, err := newReconnectingRunner(env, domainIP, newRunner)
+	require.NoError(t, err)
+
+	_, _, err = runner.Run(execcontext.New(nil, nil), "true")
+	assert.ErrorIs(t, err, ssh.ErrConnect, "the connection error should be returned while the VM is down")
+	assert.Equal(t, "192.168.1.100", env.TargetVM.IP)
+	assert.NoError(t, runners["192.168.1.100"].AssertNumberOfCommandsRun(1))
+}
+
+func TestReconnectingRunner_ResolveError(t *testing.T) {
+	env, _, newRunner := newRebootedTestEnv()
+	resolveErr := errors.New("timed out waiting for VM IP address")
+	domainIP := func(ctx execcontext.Context, name string) (string, error) {
+		return "", resolveErr
+	}
+
+	runner, err := newReconnectingRunner(env, domainIP, newRunner)
+	require.NoError(t, err)
+
+	_, _, err = runner.Run(execcontext.New(nil, nil), "true")
+	assert.ErrorIs(t, err, ssh.ErrConnect)
+	assert.ErrorIs(t, err, resolveErr)
+}
+
+func TestReconnectingRunner_CommandFailureIsNotRetried(t *testing.T) {
+	env := &TestEnvironment{TargetVM: vmm.VMMetadata{Name: "target", IP: "192.168.1.100"}}
+	mock := ssh.NewMockRunner()
+	mock.DefaultErr = errors.New("remote command failed: exit status 1")
+	domainIP := func(ctx execcontext.Context, name string) (string, error) {
+		t.Fatal("the IP address should only be resolved when the connection fails")
+		return "", nil
+	}
+
+	runner, err := newReconnectingRunner(env, domainIP, func(host string) (ssh.StreamRunner, error) {
+		return mock, nil
+	})
+	require.NoError(t, err)
+
+	_, _, err = runner.Run(execcontext.New(nil, nil), "false")
+	assert.Error(t, err)
+	assert.NoError(t, mock.AssertNumberOfCommandsRun(1))
+}
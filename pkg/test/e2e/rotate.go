@@ -0,0 +1,233 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/gitserver"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	errHostKeyNotSet       = errors.New("host SSH key path not set")
+	errAuthorizeNewHostKey = errors.New("failed to authorize new host key")
+	errVerifyNewHostKey    = errors.New("failed to connect with new host key")
+	errRevokeOldHostKey    = errors.New("failed to revoke old host key")
+	errReadNewHostPubKey   = errors.New("failed to read new host public key")
+)
+
+// authorizedKeysPath is relative to the home directory of the SSH user.
+const authorizedKeysPath = ".ssh/authorized_keys"
+
+// RunnerFactory returns an ssh.Runner connected to host as user with the private key at keyPath.
+type RunnerFactory func(host, user, keyPath string) (ssh.Runner, error)
+
+// KeyGenFunc generates an SSH key pair at keyPath and keyPath+".pub".
+type KeyGenFunc func(keyPath string) error
+
+// newSSHRunner is the default RunnerFactory.
+func newSSHRunner(host, user, keyPath string) (ssh.Runner, error) {
+	return ssh.NewClient(host, user, keyPath, "22")
+}
+
+// keyAccess is an account the host key gives access to, with the commands
+// editing its authorized keys.
+type keyAccess struct {
+	name string
+	host string
+	user string
+
+	authorizeCmd func(pubKey string) []string
+	revokeCmd    func(pubKey string) []string
+	pingCmd      []string
+}
+
+// targetVMAccess edits the authorized keys of the ubuntu user with a shell.
+func targetVMAccess(host string) keyAccess {
+	return keyAccess{
+		name: "target VM",
+		host: host,
+		user: "ubuntu",
+		authorizeCmd: func(pubKey string) []string {
+			return []string{"sh", "-c", fmt.Sprintf(
+				"grep -qxF %[1]s %[2]s || echo %[1]s >> %[2]s",
+				shellQuote(pubKey), authorizedKeysPath,
+			)}
+		},
+		// The file is rewritten in place to keep its ownership and permissions
+		revokeCmd: func(pubKey string) []string {
+			return []string{"sh", "-c", fmt.Sprintf(
+				"grep -vxF %[1]s %[2]s > %[2]s.tmp; cat %[2]s.tmp > %[2]s && rm -f %[2]s.tmp",
+				shellQuote(pubKey), authorizedKeysPath,
+			)}
+		},
+		pingCmd: []string{"true"},
+	}
+}
+
+// gitServerAccess uses the git-shell commands installed for the git user,
+// which cannot run a regular shell.
+func gitServerAccess(host string) keyAccess {
+	return keyAccess{
+		name: "git server",
+		host: host,
+		user: "git",
+		authorizeCmd: func(pubKey string) []string {
+			return []string{gitserver.AuthorizeKeyCommand, pubKey}
+		},
+		revokeCmd: func(pubKey string) []string {
+			return []string{gitserver.RevokeKeyCommand, pubKey}
+		},
+		pingCmd: []string{gitserver.PingCommand},
+	}
+}
+
+// RotateKeys replaces the host SSH key of an existing environment.
+//
+// A new key pair is generated next to the current one and authorized on the
+// target VM and the git server. Once the new key is verified to work, the old
+// key is removed from both VMs and from disk, and env.SSHKeys points to the new
+// key. The caller is responsible for persisting env.
+//
+// If the new key cannot be authorized or verified, the old key keeps working and
+// the new one is removed again on a best-effort basis.
+func RotateKeys(ctx execcontext.Context, env *TestEnvironment) error {
+	return rotateKeys(ctx, env, newSSHRunner, generateSSHKeyPair)
+}
+
+func rotateKeys(
+	ctx execcontext.Context,
+	env *TestEnvironment,
+	newRunner RunnerFactory,
+	keyGen KeyGenFunc,
+) error {
+	if env.SSHKeys.HostKeyPath == "" {
+		return errHostKeyNotSet
+	}
+	if env.TargetVM.IP == "" {
+		return errTargetVMIPNotSet
+	}
+	if env.GitServerVM.IP == "" {
+		return errGitServerVMIPNotSet
+	}
+
+	accesses := []keyAccess{
+		targetVMAccess(env.TargetVM.IP),
+		gitServerAccess(env.GitServerVM.IP),
+	}
+
+	oldKeyPath := env.SSHKeys.HostKeyPath
+	oldPubKeyPath := env.SSHKeys.HostKeyPubPath
+	if oldPubKeyPath == "" {
+		oldPubKeyPath = oldKeyPath + ".pub"
+	}
+	oldPubKey, err := readPubKey(oldPubKeyPath)
+	if err != nil {
+		return flaterrors.Join(err, errReadHostPubKey)
+	}
+
+	// Generate the new key pair next to the current one
+	newKeyPath := filepath.Join(
+		filepath.Dir(oldKeyPath),
+		"id_rsa_host-"+time.Now().UTC().Format("20060102150405"),
+	)
+	if err := keyGen(newKeyPath); err != nil {
+		return flaterrors.Join(err, errGenerateHostSSHKey)
+	}
+	newPubKey, err := readPubKey(newKeyPath + ".pub")
+	if err != nil {
+		removeKeyPair(newKeyPath)
+		return flaterrors.Join(err, errReadNewHostPubKey)
+	}
+
+	// Authorize the new key with the old one, and check the new key works
+	var authorized []keyAccess
+	rollback := func() {
+		for _, a := range authorized {
+			if err := runKeyCommand(ctx, newRunner, a, oldKeyPath, a.revokeCmd(newPubKey)); err != nil {
+				slog.Warn("failed to remove new host key after failed rotation", "vm", a.name, "error", err.Error())
+			}
+		}
+		removeKeyPair(newKeyPath)
+	}
+
+	for _, a := range accesses {
+		if err := runKeyCommand(ctx, newRunner, a, oldKeyPath, a.authorizeCmd(newPubKey)); err != nil {
+			rollback()
+			return flaterrors.Join(err, fmt.Errorf("vm=%s", a.name), errAuthorizeNewHostKey)
+		}
+		authorized = append(authorized, a)
+	}
+
+	for _, a := range accesses {
+		if err := runKeyCommand(ctx, newRunner, a, newKeyPath, a.pingCmd); err != nil {
+			rollback()
+			return flaterrors.Join(err, fmt.Errorf("vm=%s", a.name), errVerifyNewHostKey)
+		}
+	}
+
+	// The new key works everywhere: remove the old one
+	for _, a := range accesses {
+		if err := runKeyCommand(ctx, newRunner, a, newKeyPath, a.revokeCmd(oldPubKey)); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("vm=%s", a.name), errRevokeOldHostKey)
+		}
+	}
+
+	env.SSHKeys.HostKeyPath = newKeyPath
+	env.SSHKeys.HostKeyPubPath = newKeyPath + ".pub"
+	env.UpdatedAt = time.Now().UTC()
+	removeKeyPair(oldKeyPath)
+
+	slog.Info("rotated host SSH key", "testID", env.ID, "keyPath", newKeyPath)
+
+	return nil
+}
+
+// runKeyCommand runs cmd on a, logged in with the private key at keyPath.
+func runKeyCommand(
+	ctx execcontext.Context,
+	newRunner RunnerFactory,
+	a keyAccess,
+	keyPath string,
+	cmd []string,
+) error {
+	runner, err := newRunner(a.host, a.user, keyPath)
+	if err != nil {
+		return flaterrors.Join(err, errCreateSSHClient)
+	}
+
+	if _, stderr, err := runner.Run(ctx, cmd...); err != nil {
+		return flaterrors.Join(err, fmt.Errorf("stderr=%s", stderr))
+	}
+	return nil
+}
+
+// readPubKey reads a public key file and trims surrounding whitespace.
+func readPubKey(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// removeKeyPair removes a private key and its public key, ignoring missing files.
+func removeKeyPair(keyPath string) {
+	for _, p := range []string{keyPath, keyPath + ".pub"} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			slog.Warn("failed to remove SSH key file", "path", p, "error", err.Error())
+		}
+	}
+}
+
+// shellQuote single-quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,184 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/gitserver"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/ssh"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVM simulates the authorized_keys of an SSH account.
+type fakeVM struct {
+	authorized map[string]bool
+	commands   [][]string
+	failAdd    bool
+}
+
+// fakeVMRunner runs commands on a fakeVM as if connected with pubKey.
+type fakeVMRunner struct {
+	vm     *fakeVM
+	pubKey string
+}
+
+func (r *fakeVMRunner) Run(ctx execcontext.Context, cmd ...string) (string, string, error) {
+	if !r.vm.authorized[r.pubKey] {
+		return "", "", errors.New("ssh: unable to authenticate")
+	}
+	r.vm.commands = append(r.vm.commands, cmd)
+
+	// The target VM edits authorized_keys with a shell, the git server with git-shell commands
+	var op, key string
+	switch {
+	case cmd[0] == "sh" && strings.HasPrefix(cmd[2], "grep -qxF "):
+		op, key = gitserver.AuthorizeKeyCommand, quotedKey(cmd[2])
+	case cmd[0] == "sh" && strings.HasPrefix(cmd[2], "grep -vxF "):
+		op, key = gitserver.RevokeKeyCommand, quotedKey(cmd[2])
+	case cmd[0] == gitserver.AuthorizeKeyCommand || cmd[0] == gitserver.RevokeKeyCommand:
+		op, key = cmd[0], cmd[1]
+	}
+
+	switch op {
+	case gitserver.AuthorizeKeyCommand:
+		if r.vm.failAdd {
+			return "", "read-only file system", errors.New("exit status 1")
+		}
+		r.vm.authorized[key] = true
+	case gitserver.RevokeKeyCommand:
+		delete(r.vm.authorized, key)
+	}
+	return "", "", nil
+}
+
+// quotedKey returns the first single-quoted string of script.
+func quotedKey(script string) string {
+	start := strings.Index(script, "'")
+	end := strings.Index(script[start+1:], "'")
+	return script[start+1 : start+1+end]
+}
+
+// fakeKeyGen writes a key pair whose public key is derived from the file name.
+func fakeKeyGen(keyPath string) error {
+	if err := os.WriteFile(keyPath, []byte("private "+filepath.Base(keyPath)), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(keyPath+".pub", []byte(pubKeyFor(keyPath)+"\n"), 0o644)
+}
+
+func pubKeyFor(keyPath string) string {
+	return "ssh-rsa " + filepath.Base(keyPath) + " test@host"
+}
+
+// newFakeRunnerFactory connects to the fakeVMs keyed by host, as the given user.
+func newFakeRunnerFactory(t *testing.T, vms map[string]*fakeVM, users map[string]string) RunnerFactory {
+	return func(host, user, keyPath string) (ssh.Runner, error) {
+		vm, ok := vms[host]
+		if !ok {
+			return nil, fmt.Errorf("unknown host %s", host)
+		}
+		assert.Equal(t, users[host], user, "user for host %s", host)
+		pubKey, err := readPubKey(keyPath + ".pub")
+		if err != nil {
+			return nil, err
+		}
+		return &fakeVMRunner{vm: vm, pubKey: pubKey}, nil
+	}
+}
+
+func newRotateTestEnv(t *testing.T) (*TestEnvironment, map[string]*fakeVM, RunnerFactory) {
+	dir := t.TempDir()
+	oldKeyPath := filepath.Join(dir, "id_rsa_host")
+	require.NoError(t, fakeKeyGen(oldKeyPath))
+	oldPubKey := pubKeyFor(oldKeyPath)
+
+	env := &TestEnvironment{
+		ID:          "e2e-20231025-rotate",
+		TargetVM:    vmm.VMMetadata{Name: "target", IP: "192.168.1.100"},
+		GitServerVM: vmm.VMMetadata{Name: "gitserver", IP: "192.168.1.101"},
+		SSHKeys: SSHKeyInfo{
+			HostKeyPath:    oldKeyPath,
+			HostKeyPubPath: oldKeyPath + ".pub",
+		},
+	}
+
+	// The git server also authorizes the target VM key, which must be left alone
+	vms := map[string]*fakeVM{
+		env.TargetVM.IP:    {authorized: map[string]bool{oldPubKey: true}},
+		env.GitServerVM.IP: {authorized: map[string]bool{oldPubKey: true, "ssh-ed25519 target": true}},
+	}
+	users := map[string]string{env.TargetVM.IP: "ubuntu", env.GitServerVM.IP: "git"}
+
+	return env, vms, newFakeRunnerFactory(t, vms, users)
+}
+
+func TestRotateKeys(t *testing.T) {
+	env, vms, factory := newRotateTestEnv(t)
+	oldKeyPath := env.SSHKeys.HostKeyPath
+	oldPubKey := pubKeyFor(oldKeyPath)
+
+	err := rotateKeys(execcontext.New(nil, nil), env, factory, fakeKeyGen)
+	require.NoError(t, err)
+
+	newKeyPath := env.SSHKeys.HostKeyPath
+	assert.NotEqual(t, oldKeyPath, newKeyPath)
+	assert.Equal(t, newKeyPath+".pub", env.SSHKeys.HostKeyPubPath)
+	assert.FileExists(t, newKeyPath)
+	assert.FileExists(t, newKeyPath+".pub")
+	assert.NoFileExists(t, oldKeyPath)
+	assert.NoFileExists(t, oldKeyPath+".pub")
+	assert.False(t, env.UpdatedAt.IsZero())
+
+	// The new key is distributed and the old access is removed on both VMs
+	newPubKey := pubKeyFor(newKeyPath)
+	for host, vm := range vms {
+		assert.True(t, vm.authorized[newPubKey], "new key not authorized on %s", host)
+		assert.False(t, vm.authorized[oldPubKey], "old key still authorized on %s", host)
+	}
+	assert.True(t, vms[env.GitServerVM.IP].authorized["ssh-ed25519 target"], "target VM key was removed from the git server")
+
+	// The git user cannot run a shell, so only git-shell commands are used on the git server
+	for _, cmd := range vms[env.GitServerVM.IP].commands {
+		assert.Contains(t,
+			[]string{gitserver.AuthorizeKeyCommand, gitserver.RevokeKeyCommand, gitserver.PingCommand},
+			cmd[0])
+	}
+}
+
+func TestRotateKeysKeepsOldKeyWhenDistributionFails(t *testing.T) {
+	env, vms, factory := newRotateTestEnv(t)
+	oldKeyPath := env.SSHKeys.HostKeyPath
+	oldPubKey := pubKeyFor(oldKeyPath)
+	vms[env.GitServerVM.IP].failAdd = true
+
+	err := rotateKeys(execcontext.New(nil, nil), env, factory, fakeKeyGen)
+	require.ErrorIs(t, err, errAuthorizeNewHostKey)
+
+	// The environment still uses the old key, which still works everywhere
+	assert.Equal(t, oldKeyPath, env.SSHKeys.HostKeyPath)
+	assert.FileExists(t, oldKeyPath)
+	for host, vm := range vms {
+		assert.True(t, vm.authorized[oldPubKey], "old key revoked on %s", host)
+	}
+
+	// The new key was removed from the target VM and from disk
+	assert.Len(t, vms[env.TargetVM.IP].authorized, 1)
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(oldKeyPath), "id_rsa_host-*"))
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestRotateKeysRequiresVMs(t *testing.T) {
+	env, _, factory := newRotateTestEnv(t)
+	env.GitServerVM.IP = ""
+
+	err := rotateKeys(execcontext.New(nil, nil), env, factory, fakeKeyGen)
+	assert.ErrorIs(t, err, errGitServerVMIPNotSet)
+}
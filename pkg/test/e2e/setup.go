@@ -1,8 +1,10 @@
 package e2e
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log/slog"
 	"os"
 	"os/exec"
@@ -46,6 +48,15 @@ var (
 	errSetSSHKeyPerms         = errors.New("failed to set SSH key permissions")
 	errCreateImageCacheDir    = errors.New("failed to create image cache directory")
 	errDownloadImage          = errors.New("failed to download VM image")
+	errSetupNetwork           = errors.New("failed to setup isolated network")
+	errNoFreeSubnet           = errors.New("no free subnet available for isolated network")
+)
+
+const (
+	// isolatedSubnetFirstOctet and isolatedSubnetCount bound the 192.168.X.0/24
+	// subnets handed out to isolated networks. 192.168.122.0/24 (libvirt default) is outside the range.
+	isolatedSubnetFirstOctet = 150
+	isolatedSubnetCount      = 100
 )
 
 // SetupConfig contains configuration for test environment setup
@@ -61,6 +72,48 @@ type SetupConfig struct {
 
 	// DownloadImages controls whether to download missing VM images
 	DownloadImages bool
+
+	// ImageProvider fetches the missing VM images if DownloadImages is set.
+	// Defaults to WgetImageProvider.
+	ImageProvider ImageProvider
+
+	// ImageSHA256 is the expected hex-encoded SHA-256 of the downloaded VM
+	// image, verified by the default ImageProvider. Optional.
+	ImageSHA256 string
+
+	// IsolatedNetwork creates a dedicated libvirt network (named after the test ID)
+	// for this environment instead of attaching VMs to the shared "default" network.
+	// This lets multiple environments run in parallel without IP collisions.
+	IsolatedNetwork bool
+
+	// ExtraPackages are installed on the target VM by cloud-init at first boot
+	// (e.g. preinstalling git to speed up bootstrap).
+	ExtraPackages []string
+
+	// ExtraRunCommands are run on the target VM by cloud-init after the default
+	// SSH setup commands.
+	ExtraRunCommands []string
+
+	// DiskStat reports the space available on a filesystem, used to check there
+	// is room for the image and the VM disks before creating them. Defaults to StatDisk.
+	DiskStat DiskStatFunc
+
+	// TargetAwaitPolicy configures the wait for the SSH server of the target VM.
+	// Zero fields default to DefaultTargetAwaitPolicy.
+	TargetAwaitPolicy ssh.AwaitPolicy
+
+	// GitServerAwaitPolicy configures the wait for the SSH server of the git
+	// server VM. Zero fields default to gitserver.DefaultAwaitPolicy.
+	GitServerAwaitPolicy ssh.AwaitPolicy
+}
+
+// DefaultTargetAwaitPolicy is how the setup waits for the target VM by default.
+// sshd may accept connections before cloud-init has set up the ubuntu user, so
+// a command must run before the VM is considered ready.
+var DefaultTargetAwaitPolicy = ssh.AwaitPolicy{
+	Timeout:          60 * time.Second,
+	Interval:         ssh.DefaultAwaitInterval,
+	ReadinessCommand: []string{"true"},
 }
 
 // SetupTestEnvironment creates a complete test environment with VMs, git server, and SSH keys.
@@ -96,9 +149,10 @@ func SetupTestEnvironment(
 	}
 
 	// Create the root temp directory with marker file: /tmp/e2e-<test-id>
-	// The marker file ensures we only delete managed temp directories
-	tempDirRoot := filepath.Join(os.TempDir(), testEnv.ID)
-	if _, err := CreateTempDirectory(tempDirRoot); err != nil {
+	// The marker file ensures we only delete managed temp directories, and a
+	// dirty directory left at this path is never reused
+	tempDirRoot, err := NewTempDirRoot(os.TempDir(), testEnv.ID)
+	if err != nil {
 		return nil, flaterrors.Join(err, errCreateManagedTempDir)
 	}
 	testEnv.TempDirRoot = tempDirRoot
@@ -125,14 +179,26 @@ func SetupTestEnvironment(
 	imageURL := "https://cloud-images.ubuntu.com/releases/noble/release/" + imageName
 	imageCachePath := filepath.Join(config.ImageCacheDir, imageName)
 
-	if _, err := os.Stat(imageCachePath); os.IsNotExist(err) {
-		if config.DownloadImages {
-			if err := downloadVMImage(imageURL, imageCachePath); err != nil {
-				return nil, flaterrors.Join(err, errDownloadVMImage)
-			}
-		} else {
-			return nil, flaterrors.Join(fmt.Errorf("imageCachePath=%s", imageCachePath), errVMImageNotFound)
+	diskStat := config.DiskStat
+	if diskStat == nil {
+		diskStat = StatDisk
+	}
+	if err := preflightDiskSpace(diskStat, config, imageCachePath, tempDirRoot); err != nil {
+		return nil, err
+	}
+
+	if err := ensureVMImage(execCtx, config.ImageProvider, config.DownloadImages, imageURL, config.ImageSHA256, imageCachePath); err != nil {
+		return nil, err
+	}
+
+	// Create a dedicated network if requested (must exist before any VM is attached to it)
+	if config.IsolatedNetwork {
+		networkName, err := setupIsolatedNetwork(execCtx, testEnv.ID)
+		if err != nil {
+			return nil, flaterrors.Join(err, errSetupNetwork)
 		}
+		testEnv.NetworkName = networkName
+		testEnv.ManagedResources = append(testEnv.ManagedResources, networkResource(networkName))
 	}
 
 	// Generate SSH key pair for host access to target VM
@@ -145,13 +211,14 @@ func SetupTestEnvironment(
 	testEnv.SSHKeys.HostKeyPubPath = hostKeyPath + ".pub"
 
 	// Create target VM (pass VMM temp directory)
-	targetVM, err := setupTargetVM(execCtx, testEnv, imageCachePath, vmmTempDir)
+	targetVM, err := setupTargetVM(execCtx, testEnv, config, imageCachePath, vmmTempDir)
 	if err != nil {
 		return nil, flaterrors.Join(err, errSetupTargetVM)
 	}
 	testEnv.TargetVM = *targetVM
 	// Track created files from target VM
 	testEnv.ManagedResources = append(testEnv.ManagedResources, targetVM.CreatedFiles...)
+	testEnv.TargetConsoleLogPath = saveConsoleLog(targetVM.ConsoleLogPath, filepath.Join(artifactDir, "console", "target.log"))
 
 	// Create git server VM (pass git server temp directory)
 	gitServerVM, err := setupGitServer(
@@ -160,6 +227,7 @@ func SetupTestEnvironment(
 		imageCachePath,
 		config.EdgeCDRepoPath,
 		gitServerTempDir,
+		config.GitServerAwaitPolicy,
 	)
 	if err != nil {
 		return nil, flaterrors.Join(err, errSetupGitServer)
@@ -184,10 +252,58 @@ func SetupTestEnvironment(
 	return testEnv, nil
 }
 
+// saveConsoleLog copies the console log written by libvirt at src to dst and
+// returns dst, or "" if there is no log to copy. Failing to copy the log, e.g.
+// when virtlogd created it unreadable, does not fail the setup.
+func saveConsoleLog(src, dst string) string {
+	if src == "" {
+		return ""
+	}
+	content, err := os.ReadFile(src)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(dst), 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(dst, content, 0o644)
+	}
+	if err != nil {
+		slog.Warn("failed to save the console log", "src", src, "dst", dst, "error", err)
+		return ""
+	}
+	return dst
+}
+
+// targetVMUserData builds the cloud-init user data of the target VM.
+// The ubuntu user is authorized with the host's public key and gets its own
+// ed25519 key pair. Extra packages and commands from the SetupConfig are appended.
+func targetVMUserData(testID, hostPubKey string, config SetupConfig) cloudinit.UserData {
+	// Create ubuntu user with host's public key in authorized_keys
+	ubuntuUser := cloudinit.NewUserWithAuthorizedKeys("ubuntu", []string{hostPubKey})
+
+	runCommands := []string{
+		"KEY_PATH='/home/ubuntu/.ssh/id_ed25519'",
+		"USER_HOME='/home/ubuntu'",
+		"mkdir -p ${USER_HOME}/.ssh",
+		"chmod 700 ${USER_HOME}/.ssh",
+		"/usr/bin/ssh-keygen -t ed25519 -N \"\" -f ${KEY_PATH} -q",
+		"chown ubuntu:ubuntu -R ${USER_HOME}",
+		"chmod 600 ${KEY_PATH}",
+		"systemctl restart sshd",
+	}
+
+	return cloudinit.UserData{
+		Hostname:    fmt.Sprintf("test-target-%s", testID),
+		Packages:    config.ExtraPackages,
+		Users:       []cloudinit.User{ubuntuUser},
+		RunCommands: append(runCommands, config.ExtraRunCommands...),
+	}
+}
+
 // setupTargetVM creates and configures the target VM for testing
 func setupTargetVM(
 	execCtx execcontext.Context,
 	env *TestEnvironment,
+	config SetupConfig,
 	imageCachePath string,
 	vmmTempDir string,
 ) (*vmm.VMMetadata, error) {
@@ -197,24 +313,7 @@ func setupTargetVM(
 		return nil, flaterrors.Join(err, errReadHostPubKey)
 	}
 
-	// Create ubuntu user with host's public key in authorized_keys
-	ubuntuUser := cloudinit.NewUserWithAuthorizedKeys("ubuntu", []string{string(hostPubKey)})
-
-	// Setup cloud-init user data
-	userData := cloudinit.UserData{
-		Hostname: fmt.Sprintf("test-target-%s", env.ID),
-		Users:    []cloudinit.User{ubuntuUser},
-		RunCommands: []string{
-			"KEY_PATH='/home/ubuntu/.ssh/id_ed25519'",
-			"USER_HOME='/home/ubuntu'",
-			"mkdir -p ${USER_HOME}/.ssh",
-			"chmod 700 ${USER_HOME}/.ssh",
-			"/usr/bin/ssh-keygen -t ed25519 -N \"\" -f ${KEY_PATH} -q",
-			"chown ubuntu:ubuntu -R ${USER_HOME}",
-			"chmod 600 ${KEY_PATH}",
-			"systemctl restart sshd",
-		},
-	}
+	userData := targetVMUserData(env.ID, string(hostPubKey), config)
 
 	// Create VM config
 	vmConfig := vmm.NewVMConfig(
@@ -224,6 +323,13 @@ func setupTargetVM(
 	)
 	// Set temp directory for VM artifacts
 	vmConfig.TempDir = vmmTempDir
+	if env.NetworkName != "" {
+		vmConfig.Network = env.NetworkName
+	}
+	// Keep the rendered cloud-init with the test artifacts for debugging
+	vmConfig.CloudInitDir = filepath.Join(env.ArtifactPath, "cloud-init", "target")
+	// Have libvirt capture the whole boot log, copied to the artifacts once the VM is ready
+	vmConfig.ConsoleLog = true
 
 	// Create VMM with base directory option and provision VM
 	vmManager, err := vmm.NewVMM(vmm.WithBaseDir(vmmTempDir))
@@ -252,7 +358,8 @@ func setupTargetVM(
 		return nil, flaterrors.Join(err, errCreateSSHClient)
 	}
 
-	if err := sshClient.AwaitServer(60 * time.Second); err != nil {
+	awaitPolicy := config.TargetAwaitPolicy.WithDefaults(DefaultTargetAwaitPolicy)
+	if err := sshClient.AwaitServerPolicy(context.Background(), awaitPolicy); err != nil {
 		return nil, flaterrors.Join(err, errTargetVMSSHNotReady)
 	}
 
@@ -306,6 +413,7 @@ func setupGitServer(
 	env *TestEnvironment,
 	imageCachePath, edgeCDRepoPath string,
 	gitServerTempDir string,
+	awaitPolicy ssh.AwaitPolicy,
 ) (*gitserver.Status, error) {
 	// Use provided temp directory for git server
 	repos := []gitserver.Repo{
@@ -326,6 +434,11 @@ func setupGitServer(
 	}
 
 	server := gitserver.NewServer(gitServerTempDir, imageCachePath, repos)
+	if env.NetworkName != "" {
+		server.Network = env.NetworkName
+	}
+	server.CloudInitDir = filepath.Join(env.ArtifactPath, "cloud-init", "gitserver")
+	server.AwaitPolicy = awaitPolicy
 
 	// Configure authorized keys
 	// Get public key from host
@@ -346,7 +459,7 @@ func setupGitServer(
 	}
 
 	// Run git server (this creates the VM and sets up repositories)
-	if err := server.Run(execCtx); err != nil {
+	if err := server.Run(context.Background(), execCtx); err != nil {
 		return nil, flaterrors.Join(err, errRunGitServer)
 	}
 
@@ -358,6 +471,56 @@ func setupGitServer(
 	return status, nil
 }
 
+// setupIsolatedNetwork creates a libvirt network named after the test ID.
+// Subnets are tried starting from one derived from the ID so that concurrent
+// environments usually pick different subnets on the first attempt.
+func setupIsolatedNetwork(execCtx execcontext.Context, testID string) (string, error) {
+	vmManager, err := vmm.NewVMM()
+	if err != nil {
+		return "", flaterrors.Join(err, errCreateVMM)
+	}
+	defer vmManager.Close()
+
+	return createIsolatedNetwork(execCtx, testID, vmManager.CreateNetwork)
+}
+
+// createIsolatedNetwork creates the network with createNetwork, moving to the
+// next subnet only while the subnet or bridge is used by another network.
+func createIsolatedNetwork(
+	execCtx execcontext.Context,
+	testID string,
+	createNetwork func(execcontext.Context, vmm.NetworkConfig) error,
+) (string, error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(testID))
+	offset := int(h.Sum32() % isolatedSubnetCount)
+
+	var errs error
+	for i := 0; i < isolatedSubnetCount; i++ {
+		octet := uint8(isolatedSubnetFirstOctet + (offset+i)%isolatedSubnetCount)
+		cfg := vmm.NewNetworkConfig(testID, octet)
+		err := createNetwork(execCtx, cfg)
+		if err == nil {
+			return cfg.Name, nil
+		}
+		if !vmm.IsAddressConflict(err) {
+			return "", flaterrors.Join(err, fmt.Errorf("networkName=%s octet=%d", testID, octet))
+		}
+		slog.Debug("subnet already in use, trying next subnet", "networkName", testID, "octet", octet, "error", err.Error())
+		errs = err
+	}
+
+	return "", flaterrors.Join(errs, fmt.Errorf("networkName=%s", testID), errNoFreeSubnet)
+}
+
+// networkResourcePrefix marks the libvirt networks recorded in ManagedResources, which are not files.
+const networkResourcePrefix = "libvirt-network:"
+
+// networkResource returns the ManagedResources entry used to track a libvirt network.
+func networkResource(networkName string) string {
+	return networkResourcePrefix + networkName
+}
+
 // generateSSHKeyPair generates an RSA SSH key pair
 func generateSSHKeyPair(keyPath string) error {
 	cmd := exec.Command(
@@ -379,31 +542,3 @@ func generateSSHKeyPair(keyPath string) error {
 
 	return nil
 }
-
-// downloadVMImage downloads a VM image using wget
-func downloadVMImage(imageURL, destPath string) error {
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
-		return flaterrors.Join(err, errCreateImageCacheDir)
-	}
-
-	cmd := exec.Command(
-		"wget",
-		"--progress=dot",
-		"-e", "dotbytes=3M",
-		"-O", destPath,
-		imageURL,
-	)
-
-	// Show progress on stderr
-	cmd.Stdout = os.Stderr
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		// Clean up partial file
-		os.Remove(destPath)
-		return flaterrors.Join(err, errDownloadImage)
-	}
-
-	return nil
-}
@@ -0,0 +1,111 @@
+package e2e
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirt"
+)
+
+// TestTargetVMUserDataDefaults verifies the default target VM user-data has no extras
+func TestTargetVMUserDataDefaults(t *testing.T) {
+	userData := targetVMUserData("e2e-20231025-abc123", "ssh-ed25519 AAAA host", SetupConfig{})
+
+	rendered, err := userData.Render()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(rendered, "#cloud-config\n"))
+	assert.Contains(t, rendered, "hostname: test-target-e2e-20231025-abc123")
+	assert.Contains(t, rendered, "ssh-ed25519 AAAA host")
+	assert.Contains(t, rendered, "systemctl restart sshd")
+	assert.NotContains(t, rendered, "packages:")
+}
+
+// TestTargetVMUserDataExtras verifies extra packages and run commands appear in the rendered user-data
+func TestTargetVMUserDataExtras(t *testing.T) {
+	config := SetupConfig{
+		ExtraPackages:    []string{"git", "curl"},
+		ExtraRunCommands: []string{"echo extra > /tmp/extra", "touch /tmp/ready"},
+	}
+
+	userData := targetVMUserData("e2e-20231025-abc123", "ssh-ed25519 AAAA host", config)
+
+	rendered, err := userData.Render()
+	require.NoError(t, err)
+
+	assert.Contains(t, rendered, "packages:\n- git\n- curl\n")
+	assert.Contains(t, rendered, "- echo extra > /tmp/extra\n")
+	assert.Contains(t, rendered, "- touch /tmp/ready\n")
+
+	// Extra commands run after the default SSH setup
+	require.Len(t, userData.RunCommands, 10)
+	assert.Equal(t, "systemctl restart sshd", userData.RunCommands[7])
+	assert.Equal(t, config.ExtraRunCommands, userData.RunCommands[8:])
+}
+
+// TestSaveConsoleLog verifies the console log is copied to the artifacts, and that a missing log is not an error
+func TestSaveConsoleLog(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "vmm", "test-target-console.log")
+	require.NoError(t, os.MkdirAll(filepath.Dir(src), 0o755))
+	require.NoError(t, os.WriteFile(src, []byte("[    0.000000] Linux version\n"), 0o600))
+
+	dst := filepath.Join(tmpDir, "artifacts", "console", "target.log")
+	assert.Equal(t, dst, saveConsoleLog(src, dst))
+	content, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "[    0.000000] Linux version\n", string(content))
+
+	assert.Empty(t, saveConsoleLog("", dst), "no console log configured")
+	assert.Empty(t, saveConsoleLog(filepath.Join(tmpDir, "missing.log"), dst), "console log not written")
+}
+
+// TestCreateIsolatedNetwork verifies the next subnet is only tried when the subnet is in use
+func TestCreateIsolatedNetwork(t *testing.T) {
+	inUse := libvirt.Error{Code: libvirt.ERR_INTERNAL_ERROR, Message: "Network is already in use by interface virbr0"}
+
+	t.Run("subnet in use", func(t *testing.T) {
+		var gateways []string
+		name, err := createIsolatedNetwork(execcontext.New(nil, nil), "e2e-test", func(_ execcontext.Context, cfg vmm.NetworkConfig) error {
+			gateways = append(gateways, cfg.Gateway)
+			if len(gateways) < 3 {
+				return inUse
+			}
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, "e2e-test", name)
+		require.Len(t, gateways, 3)
+		assert.NotEqual(t, gateways[0], gateways[2])
+	})
+
+	t.Run("other error", func(t *testing.T) {
+		calls := 0
+		_, err := createIsolatedNetwork(execcontext.New(nil, nil), "e2e-test", func(execcontext.Context, vmm.NetworkConfig) error {
+			calls++
+			return libvirt.Error{Code: libvirt.ERR_AUTH_FAILED, Message: "authentication failed"}
+		})
+
+		assert.ErrorContains(t, err, "authentication failed")
+		assert.NotErrorIs(t, err, errNoFreeSubnet)
+		assert.Equal(t, 1, calls, "no other subnet is tried")
+	})
+
+	t.Run("no free subnet", func(t *testing.T) {
+		calls := 0
+		_, err := createIsolatedNetwork(execcontext.New(nil, nil), "e2e-test", func(execcontext.Context, vmm.NetworkConfig) error {
+			calls++
+			return inUse
+		})
+
+		assert.ErrorIs(t, err, errNoFreeSubnet)
+		assert.Equal(t, isolatedSubnetCount, calls)
+	})
+}
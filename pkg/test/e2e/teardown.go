@@ -3,60 +3,313 @@ package e2e
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/multierror"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
 )
 
+// Labels of the failed steps in the *multierror.Error returned by the teardown functions.
+const (
+	TeardownStepTargetVM         = "target VM"
+	TeardownStepGitServerVM      = "git server VM"
+	TeardownStepNetwork          = "network"
+	TeardownStepTempDir          = "temp directory"
+	TeardownStepArtifacts        = "artifacts"
+	TeardownStepManagedResources = "managed resources"
+)
+
+// Teardown phases, in the order they run.
+const (
+	TeardownPhaseDestroyVMs       = "destroy VMs"
+	TeardownPhaseTrackedResources = "remove tracked resources"
+	TeardownPhaseTempRoot         = "remove temp root"
+	TeardownPhaseNetwork          = "remove network"
+)
+
+var errTempDirNotManaged = errors.New("temp directory root is not marked as managed, skipping deletion")
+
+// TeardownPhaseResult is the outcome of one teardown phase.
+type TeardownPhaseResult struct {
+	// Phase is one of the TeardownPhase* constants
+	Phase string
+	// Targets lists the VMs, paths or network the phase removed or tried to remove
+	Targets []string
+	// Skipped is true if the environment records nothing for the phase to remove
+	Skipped bool
+	// Err is a *multierror.Error labeled with the failed TeardownStep*, nil if the phase succeeded
+	Err error
+}
+
+// OK returns true if the phase succeeded or had nothing to do.
+func (p TeardownPhaseResult) OK() bool {
+	return p.Err == nil
+}
+
+// TeardownReport lists the TeardownPhaseResults of a test environment, in the
+// order the phases ran.
+type TeardownReport struct {
+	EnvID  string
+	Phases []TeardownPhaseResult
+}
+
+// Failed returns true if any phase failed.
+func (r *TeardownReport) Failed() bool {
+	return len(r.FailedPhases()) > 0
+}
+
+// FailedPhases returns the names of the failed phases.
+func (r *TeardownReport) FailedPhases() []string {
+	var failed []string
+	for _, p := range r.Phases {
+		if !p.OK() {
+			failed = append(failed, p.Phase)
+		}
+	}
+	return failed
+}
+
+// Phase returns the result of the named phase, or nil if it did not run.
+func (r *TeardownReport) Phase(name string) *TeardownPhaseResult {
+	for i := range r.Phases {
+		if r.Phases[i].Phase == name {
+			return &r.Phases[i]
+		}
+	}
+	return nil
+}
+
+// Err returns a *multierror.Error labeled with the failed TeardownStep* of
+// every phase, or nil if teardown succeeded.
+func (r *TeardownReport) Err() error {
+	var errs multierror.Error
+	for _, p := range r.Phases {
+		var phaseErrs *multierror.Error
+		if !errors.As(p.Err, &phaseErrs) {
+			errs.Append(p.Phase, p.Err)
+			continue
+		}
+		for _, le := range phaseErrs.Errors() {
+			errs.Append(le.Label, le.Err)
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// WriteTable writes the phases as an aligned table.
+func (r *TeardownReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tSTATUS\tDETAIL")
+	for _, p := range r.Phases {
+		status, detail := "OK", strings.Join(p.Targets, ", ")
+		switch {
+		case p.Skipped:
+			status, detail = "SKIPPED", "nothing to remove"
+		case !p.OK():
+			// The errors of a phase are reported on one line each
+			status, detail = "FAIL", strings.ReplaceAll(p.Err.Error(), "\n", "; ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Phase, status, detail)
+	}
+	return tw.Flush()
+}
+
+// Teardowner removes the resources of a test environment phase by phase.
+// Each dependency can be replaced for testing.
+type Teardowner struct {
+	DestroyVM      func(ctx execcontext.Context, name string) error
+	DestroyNetwork func(ctx execcontext.Context, name string) error
+	RemoveAll      func(path string) error
+
+	// Out and ErrOut receive the progress and the warnings; nil discards them
+	Out    io.Writer
+	ErrOut io.Writer
+}
+
+// newTeardowner returns the Teardowner backed by libvirt and the local filesystem.
+func newTeardowner(out, errOut io.Writer) Teardowner {
+	return Teardowner{
+		DestroyVM:      destroyVMByName,
+		DestroyNetwork: destroyNetworkByName,
+		RemoveAll:      os.RemoveAll,
+		Out:            out,
+		ErrOut:         errOut,
+	}
+}
+
 // TeardownTestEnvironment destroys a test environment and cleans up all associated resources.
 // This is the single source of truth for test cleanup and is used by both the test harness and CLI.
 //
 // This function is best-effort - it attempts to clean up all resources even if some operations fail.
-// Returns a combined error if any cleanup operations failed, but other cleanup continues.
+// Returns a *multierror.Error labeled with the failed TeardownStep* if any cleanup
+// operations failed, but other cleanup continues.
 func TeardownTestEnvironment(ctx execcontext.Context, env *TestEnvironment) error {
+	if env == nil || env.ID == "" {
+		return errInvalidTestEnvironment
+	}
+	return newTeardowner(nil, nil).Teardown(ctx, env).Err()
+}
+
+// TeardownTestEnvironmentWithLogging is like TeardownTestEnvironment but logs all cleanup operations.
+// Useful for CLI tools that want to show progress to the user.
+func TeardownTestEnvironmentWithLogging(ctx execcontext.Context, env *TestEnvironment) error {
+	report, err := TeardownTestEnvironmentWithReport(ctx, env)
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
 
+// TeardownTestEnvironmentWithReport is like TeardownTestEnvironmentWithLogging
+// but returns the result of each phase, so callers can tell which part of the
+// environment is left. The error is only returned if env is invalid.
+func TeardownTestEnvironmentWithReport(ctx execcontext.Context, env *TestEnvironment) (*TeardownReport, error) {
 	if env == nil || env.ID == "" {
-		return fmt.Errorf("invalid test environment: nil or empty ID")
+		return nil, errInvalidTestEnvironment
 	}
 
-	var combinedErr error
+	report := newTeardowner(os.Stdout, os.Stderr).Teardown(ctx, env)
+	if report.Failed() {
+		slog.Error(
+			"encountered errors while tearing down test environment",
+			"environment_id", env.ID,
+			"failed_phases", report.FailedPhases(),
+			"error", report.Err().Error(),
+		)
+	}
+	return report, nil
+}
 
-	// Destroy target VM
-	if env.TargetVM.Name != "" {
-		if err := destroyVMByName(ctx, env.TargetVM.Name); err != nil {
-			combinedErr = errors.Join(combinedErr, fmt.Errorf("failed to destroy target VM: %w", err))
-		}
+// Teardown runs every phase against env, in order: the VMs are destroyed, then
+// the tracked resources and the temp directory root are removed, and finally
+// the network once no VM is attached to it anymore. A failed phase does not
+// stop the next ones.
+func (t Teardowner) Teardown(ctx execcontext.Context, env *TestEnvironment) *TeardownReport {
+	return &TeardownReport{
+		EnvID: env.ID,
+		Phases: []TeardownPhaseResult{
+			t.destroyVMs(ctx, env),
+			t.removeTrackedResources(env),
+			t.removeTempRoot(env),
+			t.removeNetwork(ctx, env),
+		},
 	}
+}
 
-	// Destroy git server VM
+// step runs one removal of a phase, logs its outcome and records its error under label.
+func (t Teardowner) step(
+	phase *TeardownPhaseResult,
+	errs *multierror.Error,
+	label, target string,
+	run func() error,
+) {
+	phase.Targets = append(phase.Targets, target)
+	t.printf(t.Out, "Removing %s: %s\n", label, target)
+	if err := run(); err != nil {
+		t.printf(t.ErrOut, "Warning: failed to remove %s: %v\n", label, err)
+		errs.Append(label, err)
+		return
+	}
+	t.printf(t.Out, "  ✓ %s removed\n", label)
+}
+
+func (t Teardowner) printf(w io.Writer, format string, args ...any) {
+	if w != nil {
+		fmt.Fprintf(w, format, args...)
+	}
+}
+
+// finish sets the error and skipped state of a phase once its steps ran.
+func finish(phase TeardownPhaseResult, errs *multierror.Error) TeardownPhaseResult {
+	phase.Skipped = len(phase.Targets) == 0
+	if errs.Len() > 0 {
+		phase.Err = errs
+	}
+	return phase
+}
+
+func (t Teardowner) destroyVMs(ctx execcontext.Context, env *TestEnvironment) TeardownPhaseResult {
+	phase := TeardownPhaseResult{Phase: TeardownPhaseDestroyVMs}
+	errs := &multierror.Error{}
+
+	if env.TargetVM.Name != "" && env.KeepTargetVM {
+		t.printf(t.Out, "Keeping %s: %s\n", TeardownStepTargetVM, env.TargetVM.Name)
+	} else if env.TargetVM.Name != "" {
+		t.step(&phase, errs, TeardownStepTargetVM, env.TargetVM.Name, func() error {
+			return t.DestroyVM(ctx, env.TargetVM.Name)
+		})
+	}
 	if env.GitServerVM.Name != "" {
-		if err := destroyVMByName(ctx, env.GitServerVM.Name); err != nil {
-			combinedErr = errors.Join(combinedErr, fmt.Errorf("failed to destroy git server VM: %w", err))
+		t.step(&phase, errs, TeardownStepGitServerVM, env.GitServerVM.Name, func() error {
+			return t.DestroyVM(ctx, env.GitServerVM.Name)
+		})
+	}
+
+	return finish(phase, errs)
+}
+
+// removeTrackedResources removes the files recorded in env.ManagedResources and
+// the artifacts directory, which is kept apart from TempDirRoot for backward compatibility.
+// Recorded networks are left to the network phase.
+func (t Teardowner) removeTrackedResources(env *TestEnvironment) TeardownPhaseResult {
+	phase := TeardownPhaseResult{Phase: TeardownPhaseTrackedResources}
+	errs := &multierror.Error{}
+
+	for _, resource := range env.ManagedResources {
+		if strings.HasPrefix(resource, networkResourcePrefix) {
+			continue
 		}
+		t.step(&phase, errs, TeardownStepManagedResources, resource, func() error {
+			if err := t.RemoveAll(resource); err != nil {
+				return flaterrors.Join(err, fmt.Errorf("path=%s", resource))
+			}
+			return nil
+		})
+	}
+	if env.ArtifactPath != "" {
+		t.step(&phase, errs, TeardownStepArtifacts, env.ArtifactPath, func() error {
+			return t.RemoveAll(env.ArtifactPath)
+		})
 	}
 
-	// Clean up entire temp directory root (contains all component subdirs)
-	// Only remove if it's a managed temp directory (has marker file) for safety
+	return finish(phase, errs)
+}
+
+// removeTempRoot removes the temp directory root with all component subdirs.
+// It is only removed if it is a managed temp directory (has marker file) for safety.
+func (t Teardowner) removeTempRoot(env *TestEnvironment) TeardownPhaseResult {
+	phase := TeardownPhaseResult{Phase: TeardownPhaseTempRoot}
+	errs := &multierror.Error{}
+
 	if env.TempDirRoot != "" {
-		if IsManagedTempDirectory(env.TempDirRoot) {
-			if err := os.RemoveAll(env.TempDirRoot); err != nil {
-				combinedErr = errors.Join(combinedErr, fmt.Errorf("failed to remove temp directory root: %w", err))
+		t.step(&phase, errs, TeardownStepTempDir, env.TempDirRoot, func() error {
+			if !IsManagedTempDirectory(env.TempDirRoot) {
+				return flaterrors.Join(errTempDirNotManaged, fmt.Errorf("path=%s", env.TempDirRoot))
 			}
-		} else {
-			combinedErr = errors.Join(combinedErr, fmt.Errorf("temp directory root is not marked as managed, skipping deletion: %s", env.TempDirRoot))
-		}
+			return t.RemoveAll(env.TempDirRoot)
+		})
 	}
 
-	// Clean up artifacts directory (backward compat, separate from TempDirRoot)
-	if env.ArtifactPath != "" {
-		if err := os.RemoveAll(env.ArtifactPath); err != nil {
-			combinedErr = errors.Join(combinedErr, fmt.Errorf("failed to remove artifact directory: %w", err))
-		}
+	return finish(phase, errs)
+}
+
+func (t Teardowner) removeNetwork(ctx execcontext.Context, env *TestEnvironment) TeardownPhaseResult {
+	phase := TeardownPhaseResult{Phase: TeardownPhaseNetwork}
+	errs := &multierror.Error{}
+
+	if env.NetworkName != "" {
+		t.step(&phase, errs, TeardownStepNetwork, env.NetworkName, func() error {
+			return t.DestroyNetwork(ctx, env.NetworkName)
+		})
 	}
 
-	return combinedErr
+	return finish(phase, errs)
 }
 
 // destroyVMByName destroys a VM by name via libvirt.
@@ -89,72 +342,18 @@ func destroyVMByName(ctx execcontext.Context, vmName string) error {
 	return nil
 }
 
-// TeardownTestEnvironmentWithLogging is like TeardownTestEnvironment but logs all cleanup operations.
-// Useful for CLI tools that want to show progress to the user.
-func TeardownTestEnvironmentWithLogging(ctx execcontext.Context, env *TestEnvironment) error {
-
-	if env == nil || env.ID == "" {
-		return fmt.Errorf("invalid test environment: nil or empty ID")
-	}
-
-	var combinedErr error
-
-	// Destroy target VM
-	if env.TargetVM.Name != "" {
-		fmt.Printf("Destroying target VM: %s\n", env.TargetVM.Name)
-		if err := destroyVMByName(ctx, env.TargetVM.Name); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to destroy target VM: %v\n", err)
-			combinedErr = errors.Join(combinedErr, err)
-		} else {
-			fmt.Println("  ✓ Target VM destroyed")
-		}
-	}
-
-	// Destroy git server VM
-	if env.GitServerVM.Name != "" {
-		fmt.Printf("Destroying git server VM: %s\n", env.GitServerVM.Name)
-		if err := destroyVMByName(ctx, env.GitServerVM.Name); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to destroy git server VM: %v\n", err)
-			combinedErr = errors.Join(combinedErr, err)
-		} else {
-			fmt.Println("  ✓ Git server VM destroyed")
-		}
-	}
-
-	// Clean up entire temp directory root (contains all component subdirs)
-	// Only remove if it's a managed temp directory (has marker file) for safety
-	if env.TempDirRoot != "" {
-		fmt.Printf("Removing temp directory: %s\n", env.TempDirRoot)
-		if !IsManagedTempDirectory(env.TempDirRoot) {
-			err := fmt.Errorf("temp directory root is not marked as managed, skipping deletion: %s", env.TempDirRoot)
-			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
-			combinedErr = errors.Join(combinedErr, err)
-		} else if err := os.RemoveAll(env.TempDirRoot); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to remove temp directory: %v\n", err)
-			combinedErr = errors.Join(combinedErr, err)
-		} else {
-			fmt.Println("  ✓ Temp directory removed")
-		}
-	}
-
-	// Clean up artifacts directory (backward compat, separate from TempDirRoot)
-	if env.ArtifactPath != "" {
-		fmt.Printf("Removing artifacts from: %s\n", env.ArtifactPath)
-		if err := os.RemoveAll(env.ArtifactPath); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to remove artifact directory: %v\n", err)
-			combinedErr = errors.Join(combinedErr, err)
-		} else {
-			fmt.Println("  ✓ Artifacts removed")
-		}
+// destroyNetworkByName destroys a libvirt network by name.
+// If the network doesn't exist, it returns nil (not an error) since the goal is cleanup.
+func destroyNetworkByName(ctx execcontext.Context, networkName string) error {
+	vmManager, err := vmm.NewVMM()
+	if err != nil {
+		return fmt.Errorf("failed to connect to libvirt: %w", err)
 	}
+	defer vmManager.Close()
 
-	if combinedErr != nil {
-		slog.Error(
-			"encountered errors while tearing down test environment",
-			"environment_id", env.ID,
-			"error", combinedErr.Error(),
-		)
+	if err := vmManager.DestroyNetwork(ctx, networkName); err != nil {
+		return fmt.Errorf("failed to destroy network %s: %w", networkName, err)
 	}
 
-	return combinedErr
+	return nil
 }
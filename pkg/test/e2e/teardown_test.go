@@ -1,12 +1,15 @@
 package e2e
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/multierror"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -156,3 +159,171 @@ func TestTempDirRootPersistsInStore(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, tempDirRoot, retrieved.TempDirRoot)
 }
+
+// TestTeardownReportsFailedStepsByLabel verifies teardown errors can be inspected programmatically
+func TestTeardownReportsFailedStepsByLabel(t *testing.T) {
+	// Not marked as managed, so teardown refuses to delete it
+	unmanagedDir := t.TempDir()
+
+	env := &TestEnvironment{
+		ID:          "e2e-20231025-labels",
+		TempDirRoot: unmanagedDir,
+	}
+
+	ctx := execcontext.New(make(map[string]string), []string{})
+	for name, teardown := range map[string]func(execcontext.Context, *TestEnvironment) error{
+		"TeardownTestEnvironment":            TeardownTestEnvironment,
+		"TeardownTestEnvironmentWithLogging": TeardownTestEnvironmentWithLogging,
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := teardown(ctx, env)
+			require.Error(t, err)
+
+			assert.ErrorIs(t, err, errTempDirNotManaged)
+			assert.Equal(t, []string{TeardownStepTempDir}, multierror.Labels(err))
+
+			var errs *multierror.Error
+			require.ErrorAs(t, err, &errs)
+			assert.ErrorIs(t, errs.Get(TeardownStepTempDir), errTempDirNotManaged)
+
+			_, statErr := os.Stat(unmanagedDir)
+			assert.NoError(t, statErr, "unmanaged directory must not be deleted")
+		})
+	}
+}
+
+// newFakeTeardowner records the removed VMs, network and paths, and fails to
+// destroy the VMs listed in failVMs.
+func newFakeTeardowner(failVMs ...string) (Teardowner, *[]string) {
+	var removed []string
+	failing := make(map[string]bool)
+	for _, name := range failVMs {
+		failing[name] = true
+	}
+	return Teardowner{
+		DestroyVM: func(ctx execcontext.Context, name string) error {
+			if failing[name] {
+				return errors.New("domain is busy")
+			}
+			removed = append(removed, "vm "+name)
+			return nil
+		},
+		DestroyNetwork: func(ctx execcontext.Context, name string) error {
+			removed = append(removed, "network "+name)
+			return nil
+		},
+		RemoveAll: func(path string) error {
+			removed = append(removed, "path "+filepath.Base(path))
+			return os.RemoveAll(path)
+		},
+	}, &removed
+}
+
+func newTeardownTestEnv(t *testing.T) *TestEnvironment {
+	tempDirRoot, err := CreateTempDirectory(filepath.Join(t.TempDir(), "e2e-20231025-phases"))
+	require.NoError(t, err)
+	return &TestEnvironment{
+		ID:               "e2e-20231025-phases",
+		TargetVM:         vmm.VMMetadata{Name: "target"},
+		GitServerVM:      vmm.VMMetadata{Name: "gitserver"},
+		NetworkName:      "edgecd-phases",
+		TempDirRoot:      tempDirRoot,
+		ManagedResources: []string{
+			networkResource("edgecd-phases"),
+			filepath.Join(tempDirRoot, "vmm", "target.qcow2"),
+		},
+	}
+}
+
+func TestTeardownPhasesSucceed(t *testing.T) {
+	env := newTeardownTestEnv(t)
+	teardowner, removed := newFakeTeardowner()
+
+	report := teardowner.Teardown(execcontext.New(nil, nil), env)
+
+	assert.False(t, report.Failed())
+	assert.NoError(t, report.Err())
+	assert.Equal(t, env.ID, report.EnvID)
+
+	phases := make([]string, 0, len(report.Phases))
+	for _, p := range report.Phases {
+		phases = append(phases, p.Phase)
+		assert.True(t, p.OK(), "phase %s", p.Phase)
+		assert.False(t, p.Skipped, "phase %s", p.Phase)
+	}
+	assert.Equal(t, []string{
+		TeardownPhaseDestroyVMs,
+		TeardownPhaseTrackedResources,
+		TeardownPhaseTempRoot,
+		TeardownPhaseNetwork,
+	}, phases)
+
+	// The network is removed once the VMs are gone
+	assert.Equal(t, []string{
+		"vm target",
+		"vm gitserver",
+		"path target.qcow2",
+		"path e2e-20231025-phases",
+		"network edgecd-phases",
+	}, *removed)
+	assert.NoDirExists(t, env.TempDirRoot)
+}
+
+func TestTeardownKeepsImportedTargetVM(t *testing.T) {
+	env := newTeardownTestEnv(t)
+	env.KeepTargetVM = true
+	teardowner, removed := newFakeTeardowner()
+
+	report := teardowner.Teardown(execcontext.New(nil, nil), env)
+
+	require.False(t, report.Failed())
+	assert.Equal(t, []string{"gitserver"}, report.Phase(TeardownPhaseDestroyVMs).Targets)
+	assert.NotContains(t, *removed, "vm target")
+}
+
+func TestTeardownReportsFailedPhase(t *testing.T) {
+	env := newTeardownTestEnv(t)
+	env.ManagedResources = nil
+	teardowner, removed := newFakeTeardowner("gitserver")
+
+	report := teardowner.Teardown(execcontext.New(nil, nil), env)
+
+	require.True(t, report.Failed())
+	assert.Equal(t, []string{TeardownPhaseDestroyVMs}, report.FailedPhases())
+
+	vms := report.Phase(TeardownPhaseDestroyVMs)
+	require.NotNil(t, vms)
+	assert.Equal(t, []string{"target", "gitserver"}, vms.Targets)
+	assert.Equal(t, []string{TeardownStepGitServerVM}, multierror.Labels(vms.Err))
+
+	tracked := report.Phase(TeardownPhaseTrackedResources)
+	require.NotNil(t, tracked)
+	assert.True(t, tracked.Skipped)
+	assert.True(t, tracked.OK())
+
+	// A failed phase does not stop the next ones
+	assert.True(t, report.Phase(TeardownPhaseTempRoot).OK())
+	assert.True(t, report.Phase(TeardownPhaseNetwork).OK())
+	assert.Contains(t, *removed, "network edgecd-phases")
+
+	// The steps are still labeled in the aggregated error
+	assert.Equal(t, []string{TeardownStepGitServerVM}, multierror.Labels(report.Err()))
+
+	var table bytes.Buffer
+	require.NoError(t, report.WriteTable(&table))
+	assert.Regexp(t, `destroy VMs\s+FAIL\s+git server VM: domain is busy`, table.String())
+	assert.Regexp(t, `remove tracked resources\s+SKIPPED`, table.String())
+	assert.Regexp(t, `remove network\s+OK\s+edgecd-phases`, table.String())
+}
+
+func TestTeardownReportsUnmanagedTempRoot(t *testing.T) {
+	env := newTeardownTestEnv(t)
+	env.TempDirRoot = t.TempDir()
+	teardowner, _ := newFakeTeardowner()
+
+	report := teardowner.Teardown(execcontext.New(nil, nil), env)
+
+	assert.Equal(t, []string{TeardownPhaseTempRoot}, report.FailedPhases())
+	assert.ErrorIs(t, report.Phase(TeardownPhaseTempRoot).Err, errTempDirNotManaged)
+	assert.DirExists(t, env.TempDirRoot)
+}
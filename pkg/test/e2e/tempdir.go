@@ -1,6 +1,7 @@
 package e2e
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -27,6 +28,65 @@ func CreateTempDirectory(dirPath string) (string, error) {
 	return dirPath, nil
 }
 
+// maxTempDirRootSuffix bounds the suffixes NewTempDirRoot tries on collisions.
+const maxTempDirRootSuffix = 100
+
+// errTempDirRootCollision is returned by NewTempDirRoot when no candidate
+// directory is free.
+var errTempDirRootCollision = errors.New("no free temp directory root")
+
+// NewTempDirRoot creates the managed temp directory root of the environment id
+// under base, e.g. /tmp/e2e-<test-id>. A directory left at this path, e.g. by a
+// prior run with the same id, is never reused unless it is empty or a managed
+// directory holding nothing but its marker file: the root is then created with
+// the first free suffix, e.g. /tmp/e2e-<test-id>-1.
+func NewTempDirRoot(base, id string) (string, error) {
+	for i := 0; i <= maxTempDirRootSuffix; i++ {
+		candidate := filepath.Join(base, id)
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d", candidate, i)
+		}
+
+		clean, err := isCleanTempDirRoot(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !clean {
+			continue
+		}
+		return CreateTempDirectory(candidate)
+	}
+	return "", fmt.Errorf("%w: %s and its %d suffixed alternatives already exist", errTempDirRootCollision, filepath.Join(base, id), maxTempDirRootSuffix)
+}
+
+// isCleanTempDirRoot reports whether dirPath can be used as a temp directory
+// root: it does not exist, is empty, or is a managed directory holding only
+// its marker file.
+func isCleanTempDirRoot(dirPath string) (bool, error) {
+	info, err := os.Lstat(dirPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to stat temp directory %s: %w", dirPath, err)
+	}
+	if !info.IsDir() {
+		return false, nil
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read temp directory %s: %w", dirPath, err)
+	}
+	switch len(entries) {
+	case 0:
+		return true, nil
+	case 1:
+		return entries[0].Name() == TempDirMarkerFile, nil
+	default:
+		return false, nil
+	}
+}
+
 // IsManagedTempDirectory checks if a directory is a managed temporary directory
 // A directory is considered managed if:
 // 1. It exists
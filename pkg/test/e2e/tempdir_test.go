@@ -1,6 +1,8 @@
 package e2e
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -124,3 +126,131 @@ func TestIsManagedTempDirectory_Invalid_IsFile(t *testing.T) {
 		t.Errorf("IsManagedTempDirectory returned true for a file")
 	}
 }
+
+// TestNewTempDirRoot tests that a dirty directory is never reused as temp root
+func TestNewTempDirRoot(t *testing.T) {
+	const id = "e2e-20231025-abcdef"
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, base string)
+		want    string
+	}{
+		{
+			name:    "fresh path",
+			prepare: func(t *testing.T, base string) {},
+			want:    id,
+		},
+		{
+			name: "empty directory is reused",
+			prepare: func(t *testing.T, base string) {
+				mustMkdir(t, filepath.Join(base, id))
+			},
+			want: id,
+		},
+		{
+			name: "clean managed directory is reused",
+			prepare: func(t *testing.T, base string) {
+				if _, err := CreateTempDirectory(filepath.Join(base, id)); err != nil {
+					t.Fatalf("CreateTempDirectory failed: %v", err)
+				}
+			},
+			want: id,
+		},
+		{
+			name: "non-managed directory gets a suffix",
+			prepare: func(t *testing.T, base string) {
+				mustWriteFile(t, filepath.Join(base, id, "data.txt"))
+			},
+			want: id + "-1",
+		},
+		{
+			name: "dirty managed directory gets a suffix",
+			prepare: func(t *testing.T, base string) {
+				if _, err := CreateTempDirectory(filepath.Join(base, id)); err != nil {
+					t.Fatalf("CreateTempDirectory failed: %v", err)
+				}
+				mustWriteFile(t, filepath.Join(base, id, "vmm", "target.qcow2"))
+			},
+			want: id + "-1",
+		},
+		{
+			name: "file gets a suffix",
+			prepare: func(t *testing.T, base string) {
+				mustWriteFile(t, filepath.Join(base, id))
+			},
+			want: id + "-1",
+		},
+		{
+			name: "first free suffix",
+			prepare: func(t *testing.T, base string) {
+				mustWriteFile(t, filepath.Join(base, id, "data.txt"))
+				mustWriteFile(t, filepath.Join(base, id+"-1", "data.txt"))
+			},
+			want: id + "-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			tt.prepare(t, base)
+
+			path, err := NewTempDirRoot(base, id)
+			if err != nil {
+				t.Fatalf("NewTempDirRoot failed: %v", err)
+			}
+
+			if want := filepath.Join(base, tt.want); path != want {
+				t.Errorf("Expected path %s, got %s", want, path)
+			}
+			if !IsManagedTempDirectory(path) {
+				t.Errorf("Expected %s to be a managed temp directory", path)
+			}
+		})
+	}
+
+	t.Run("leftover content is untouched", func(t *testing.T) {
+		base := t.TempDir()
+		leftover := filepath.Join(base, id, "data.txt")
+		mustWriteFile(t, leftover)
+
+		if _, err := NewTempDirRoot(base, id); err != nil {
+			t.Fatalf("NewTempDirRoot failed: %v", err)
+		}
+
+		if IsManagedTempDirectory(filepath.Join(base, id)) {
+			t.Errorf("Expected the non-managed directory not to be marked as managed")
+		}
+		if _, err := os.Stat(leftover); err != nil {
+			t.Errorf("Expected leftover file to be preserved: %v", err)
+		}
+	})
+
+	t.Run("fails when every candidate is taken", func(t *testing.T) {
+		base := t.TempDir()
+		mustWriteFile(t, filepath.Join(base, id))
+		for i := 1; i <= maxTempDirRootSuffix; i++ {
+			mustWriteFile(t, filepath.Join(base, fmt.Sprintf("%s-%d", id, i)))
+		}
+
+		if _, err := NewTempDirRoot(base, id); !errors.Is(err, errTempDirRootCollision) {
+			t.Errorf("Expected errTempDirRootCollision, got %v", err)
+		}
+	})
+}
+
+func mustMkdir(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+}
+
+func mustWriteFile(t *testing.T, path string) {
+	t.Helper()
+	mustMkdir(t, filepath.Dir(path))
+	if err := os.WriteFile(path, []byte("leftover"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+}
@@ -1,5 +1,11 @@
 package userconfig
 
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
 // Spec represents the complete edge-cd configuration structure.
 // This is the authoritative definition based on cmd/edge-cd/edge-cd script.
 type Spec struct {
@@ -10,36 +16,120 @@ type Spec struct {
 	ServiceManager  ServiceManagerSection  `yaml:"serviceManager,omitempty" json:"serviceManager,omitempty"`
 	PackageManager  PackageManagerSection  `yaml:"packageManager,omitempty" json:"packageManager,omitempty"`
 	Files           []FileSpec             `yaml:"files,omitempty" json:"files,omitempty"`
+	DefaultFileMode string                 `yaml:"defaultFileMode,omitempty" json:"defaultFileMode,omitempty"` // Mode of the files without fileMod. Default: "644"
+	RootPrefix      string                 `yaml:"rootPrefix,omitempty" json:"rootPrefix,omitempty"`           // Root filesystem the destPaths are written to, e.g. a mounted image. Default: "/"
+	// AllowedPathPrefixes are the only directories the destPaths may be
+	// written under, as a guardrail: the other files are skipped. Default: any
+	AllowedPathPrefixes []string `yaml:"allowedPathPrefixes,omitempty" json:"allowedPathPrefixes,omitempty"`
 	Directories     []DirectorySpec        `yaml:"directories,omitempty" json:"directories,omitempty"`
 	Log             *LogSection            `yaml:"log,omitempty" json:"log,omitempty"`
+	Notify          *NotifySection         `yaml:"notify,omitempty" json:"notify,omitempty"`
+	Heartbeat       *HeartbeatSection      `yaml:"heartbeat,omitempty" json:"heartbeat,omitempty"`
+	RunAs           *RunAsSection          `yaml:"runAs,omitempty" json:"runAs,omitempty"`
+	// Values are passed to templated files. They override the values of ValuesFrom,
+	// which override the built-in host facts
+	Values          map[string]any         `yaml:"values,omitempty" json:"values,omitempty"`
+	ValuesFrom      []ValuesSource         `yaml:"valuesFrom,omitempty" json:"valuesFrom,omitempty"`
+	// Canary reconciles the files marked canary first, and the other files only
+	// once the health check passes. Optional
+	Canary *CanarySection `yaml:"canary,omitempty" json:"canary,omitempty"`
+}
+
+// IsPathAllowed reports whether destPath is under one of AllowedPathPrefixes,
+// always true without prefixes.
+func (c *Spec) IsPathAllowed(destPath string) bool {
+	if len(c.AllowedPathPrefixes) == 0 {
+		return true
+	}
+
+	destPath = path.Clean("/" + destPath)
+	for _, prefix := range c.AllowedPathPrefixes {
+		prefix = path.Clean(prefix)
+		if destPath == prefix || prefix == "/" || strings.HasPrefix(destPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
 }
 
+// Bounds of Spec.PollingInterval, in seconds.
+const (
+	// DefaultPollingInterval is used when the spec does not set an interval.
+	DefaultPollingInterval = 60
+	// DefaultMinPollingInterval is the shortest interval edge-cd polls at, unless
+	// overridden with MIN_POLLING_INTERVAL_SECOND. Shorter intervals are raised to
+	// it to avoid hammering the git servers.
+	DefaultMinPollingInterval = 10
+)
+
 // EdgeCDSection defines how edge-cd manages itself
 type EdgeCDSection struct {
 	Repo       RepoConfig         `yaml:"repo" json:"repo"`
 	CommitPath string             `yaml:"commitPath,omitempty" json:"commitPath,omitempty"`
 	AutoUpdate *AutoUpdateSection `yaml:"autoUpdate,omitempty" json:"autoUpdate,omitempty"`
+	SelfUpdate *SelfUpdateSection `yaml:"selfUpdate,omitempty" json:"selfUpdate,omitempty"`
+	// Runtime is the edge-cd built from the repo: "shell" (default) or "go".
+	// The go runtime checks out the Go module along with cmd/edge-cd.
+	Runtime string `yaml:"runtime,omitempty" json:"runtime,omitempty"`
 }
 
+// Runtimes supported by EdgeCDSection.Runtime.
+const (
+	RuntimeShell = "shell"
+	RuntimeGo    = "go"
+)
+
 // AutoUpdateSection controls edge-cd auto-update behavior
 type AutoUpdateSection struct {
 	Enabled bool `yaml:"enabled" json:"enabled"`
 }
 
+// SelfUpdateSection pins the edge-cd binary to a released version.
+// The binary is downloaded and swapped when the pinned version changes.
+type SelfUpdateSection struct {
+	Version     string `yaml:"version" json:"version"`                           // Required, e.g. "v1.2.0"
+	Channel     string `yaml:"channel,omitempty" json:"channel,omitempty"`       // Default: "stable"
+	URLTemplate string `yaml:"urlTemplate" json:"urlTemplate"`                   // Required, may use {{ .Version }}, {{ .Channel }}, {{ .OS }} and {{ .Arch }}
+	BinaryPath  string `yaml:"binaryPath,omitempty" json:"binaryPath,omitempty"` // Default: path of the running binary
+	// SHA256 is the hex-encoded checksum of the downloaded binary, required when urlTemplate is not https
+	SHA256 string `yaml:"sha256,omitempty" json:"sha256,omitempty"`
+
+	HealthCheck *SelfUpdateHealthCheck `yaml:"healthCheck,omitempty" json:"healthCheck,omitempty"`
+}
+
+// SelfUpdateHealthCheck defines when an updated edge-cd is considered healthy.
+// An unhealthy update is rolled back to the previous binary.
+type SelfUpdateHealthCheck struct {
+	StableSeconds int    `yaml:"stableSeconds,omitempty" json:"stableSeconds,omitempty"` // Default: 30
+	URL           string `yaml:"url,omitempty" json:"url,omitempty"`                     // Optional, e.g. "http://127.0.0.1:8080/healthz"
+}
+
 // ConfigSection defines user configuration repository settings
 type ConfigSection struct {
 	Spec       string     `yaml:"spec" json:"spec"`                       // Default: "spec.yaml"
 	Path       string     `yaml:"path" json:"path"`                       // Required
 	Repo       ConfigRepo `yaml:"repo" json:"repo"`
 	CommitPath string     `yaml:"commitPath,omitempty" json:"commitPath,omitempty"`
+	// SyncFailurePolicy is what to do when the repo cannot be synced: "fail-closed" (default)
+	// skips file reconciliation, "fail-open" reconciles files from the current checkout
+	SyncFailurePolicy string `yaml:"syncFailurePolicy,omitempty" json:"syncFailurePolicy,omitempty"`
 }
 
+// Policies supported by ConfigSection.SyncFailurePolicy.
+const (
+	SyncFailurePolicyFailClosed = "fail-closed"
+	SyncFailurePolicyFailOpen   = "fail-open"
+)
+
 // RepoConfig represents a git repository configuration for edge-cd itself
 // Uses "destinationPath" field name
 type RepoConfig struct {
 	URL             string `yaml:"url" json:"url"`
 	Branch          string `yaml:"branch,omitempty" json:"branch,omitempty"`
 	DestinationPath string `yaml:"destinationPath" json:"destinationPath"`
+	// SparseCheckout false checks out the whole repo. Default: true
+	SparseCheckout      *bool    `yaml:"sparseCheckout,omitempty" json:"sparseCheckout,omitempty"`
+	SparseCheckoutPaths []string `yaml:"sparseCheckoutPaths,omitempty" json:"sparseCheckoutPaths,omitempty"` // Default: cmd/edge-cd
 }
 
 // ConfigRepo represents a git repository configuration for user config
@@ -48,11 +138,74 @@ type ConfigRepo struct {
 	URL      string `yaml:"url" json:"url"`
 	Branch   string `yaml:"branch,omitempty" json:"branch,omitempty"`
 	DestPath string `yaml:"destPath" json:"destPath"` // NOTE: Different from RepoConfig!
+	// SparseCheckout false checks out the whole repo. Default: true
+	SparseCheckout      *bool    `yaml:"sparseCheckout,omitempty" json:"sparseCheckout,omitempty"`
+	SparseCheckoutPaths []string `yaml:"sparseCheckoutPaths,omitempty" json:"sparseCheckoutPaths,omitempty"` // Default: config.path
+	// CredentialHelper is the git credential.helper resolving the credentials of
+	// a private HTTPS repo, e.g. "store" or the path of a helper binary
+	CredentialHelper string `yaml:"credentialHelper,omitempty" json:"credentialHelper,omitempty"`
+}
+
+// DefaultEdgeCDCheckoutPath is the path of the edge-cd repo checked out by default.
+const DefaultEdgeCDCheckoutPath = "cmd/edge-cd"
+
+// GoRuntimeCheckoutPaths are the paths of the edge-cd repo required to build
+// edge-cd-go: the Go packages and the scripts of the service and package
+// managers it reads from cmd/edge-cd. go.mod and go.sum, at the root of the
+// repo, are always checked out by a cone mode sparse checkout.
+var GoRuntimeCheckoutPaths = []string{DefaultEdgeCDCheckoutPath, "cmd/edge-cd-go", "pkg"}
+
+// CheckoutPaths returns the paths of the edge-cd repo to sparse-check out, or
+// nil if the whole repo is checked out.
+func (r RepoConfig) CheckoutPaths() []string {
+	return checkoutPaths(r.SparseCheckout, r.SparseCheckoutPaths, DefaultEdgeCDCheckoutPath)
+}
+
+// CheckoutPaths returns the paths of the edge-cd repo to sparse-check out for
+// the selected runtime, or nil if the whole repo is checked out. The go runtime
+// adds GoRuntimeCheckoutPaths to the configured paths, so that edge-cd-go can
+// be built from the checkout.
+func (e EdgeCDSection) CheckoutPaths() []string {
+	paths := e.Repo.CheckoutPaths()
+	if paths == nil || e.Runtime != RuntimeGo {
+		return paths
+	}
+
+	merged := append([]string{}, paths...)
+	for _, p := range GoRuntimeCheckoutPaths {
+		if !slices.Contains(merged, p) {
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
+
+// CheckoutPaths returns the paths of the config repo to sparse-check out, or
+// nil if the whole repo is checked out. configPath is checked out by default.
+func (r ConfigRepo) CheckoutPaths(configPath string) []string {
+	return checkoutPaths(r.SparseCheckout, r.SparseCheckoutPaths, configPath)
+}
+
+func checkoutPaths(sparse *bool, paths []string, defaultPath string) []string {
+	if sparse != nil && !*sparse {
+		return nil
+	}
+	if len(paths) > 0 {
+		return paths
+	}
+	return []string{defaultPath}
 }
 
 // ServiceManagerSection defines the service manager to use
 type ServiceManagerSection struct {
 	Name string `yaml:"name" json:"name"`
+	// VerifyActiveSeconds is how long a restarted service has to become
+	// active before the restart is reported as failed. Default: 0, not verified
+	VerifyActiveSeconds int `yaml:"verifyActiveSeconds,omitempty" json:"verifyActiveSeconds,omitempty"`
+	// RestartOrder lists the services restarted first, in this order, e.g. a
+	// backend before its proxy. The other services are restarted after them,
+	// in sorted order
+	RestartOrder []string `yaml:"restartOrder,omitempty" json:"restartOrder,omitempty"`
 }
 
 // PackageManagerSection defines package management settings
@@ -60,25 +213,99 @@ type PackageManagerSection struct {
 	Name             string   `yaml:"name" json:"name"`
 	AutoUpgrade      bool     `yaml:"autoUpgrade,omitempty" json:"autoUpgrade,omitempty"`
 	RequiredPackages []string `yaml:"requiredPackages,omitempty" json:"requiredPackages,omitempty"`
+	PackageGroups    []string `yaml:"packageGroups,omitempty" json:"packageGroups,omitempty"`       // PackageGroup files, relative to config.path in the config repo
+	ExcludedPackages []string `yaml:"excludedPackages,omitempty" json:"excludedPackages,omitempty"` // Packages not installed even if required by a group
+	// ReconcileOn are the glob patterns, relative to config.path, of the files
+	// whose change in a new config commit triggers a package reconcile, in
+	// addition to the spec file and the package groups, which always do
+	ReconcileOn []string `yaml:"reconcileOn,omitempty" json:"reconcileOn,omitempty"`
+}
+
+// PackageGroup is a package list shared by the specs including it in
+// packageManager.packageGroups, e.g. the base packages of a fleet.
+type PackageGroup struct {
+	RequiredPackages []string `yaml:"requiredPackages,omitempty" json:"requiredPackages,omitempty"`
 }
 
 // FileSpec represents a single file to be managed
-// Supports three types: "file", "directory", "content"
+// Supports five types: "file", "directory", "content", "symlink", "delete"
 type FileSpec struct {
-	Type         string        `yaml:"type" json:"type"`                                 // "file", "directory", "content"
-	SrcPath      string        `yaml:"srcPath,omitempty" json:"srcPath,omitempty"`       // For type: file or directory
-	DestPath     string        `yaml:"destPath" json:"destPath"`                         // Required
+	Type         string        `yaml:"type" json:"type"`                                 // "file", "directory", "content", "symlink", "delete"
+	SrcPath      string        `yaml:"srcPath,omitempty" json:"srcPath,omitempty"`       // For type: file or directory, the link target for symlink. May use ${VAR} host facts
+	DestPath     string        `yaml:"destPath" json:"destPath"`                         // Required. May use ${VAR} host facts
 	Content      string        `yaml:"content,omitempty" json:"content,omitempty"`       // For type: content
-	FileMod      string        `yaml:"fileMod,omitempty" json:"fileMod,omitempty"`       // Default: "644"
+	FileMod      string        `yaml:"fileMod,omitempty" json:"fileMod,omitempty"`       // Default: Spec.DefaultFileMode
+	Owner        string        `yaml:"owner,omitempty" json:"owner,omitempty"`           // User name or uid of the file. Default: unchanged
+	Group        string        `yaml:"group,omitempty" json:"group,omitempty"`           // Group name or gid of the file. Default: unchanged
+	Template     bool          `yaml:"template,omitempty" json:"template,omitempty"`     // Render the content as a Go text/template
+	Compression  string        `yaml:"compression,omitempty" json:"compression,omitempty"` // "gzip" to decompress the source before writing it
 	SyncBehavior *SyncBehavior `yaml:"syncBehavior,omitempty" json:"syncBehavior,omitempty"`
+	// Enabled false skips the file: it is neither written nor removed, e.g. to
+	// commit a spec before rolling it out. Default: true
+	Enabled *bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	// CreateParents false requires the parent directory of DestPath to exist
+	// instead of creating it, so that a mistyped DestPath fails. Default: true
+	CreateParents *bool `yaml:"createParents,omitempty" json:"createParents,omitempty"`
+	// Canary reconciles the file before the others, see Spec.Canary
+	Canary bool `yaml:"canary,omitempty" json:"canary,omitempty"`
+	// AllowEmpty allows the source of a "file" spec to be empty. An empty
+	// source is an error by default, as it is more likely truncated. Default: false
+	AllowEmpty bool `yaml:"allowEmpty,omitempty" json:"allowEmpty,omitempty"`
+	// Prune removes the files under the DestPath of a "directory" spec that are
+	// absent from its source. Default: false
+	Prune bool `yaml:"prune,omitempty" json:"prune,omitempty"`
+	// Variables are passed to the template of this file only, e.g. its IP
+	// address. They override Spec.Values and the built-in host facts
+	Variables map[string]string `yaml:"variables,omitempty" json:"variables,omitempty"`
+}
+
+// IsEnabled returns false if the file is disabled and must be skipped.
+func (f FileSpec) IsEnabled() bool {
+	return f.Enabled == nil || *f.Enabled
+}
+
+// ShouldCreateParents returns false if the parent directory of DestPath must
+// already exist.
+func (f FileSpec) ShouldCreateParents() bool {
+	return f.CreateParents == nil || *f.CreateParents
+}
+
+// Compressions supported by FileSpec.Compression. The inline content of a
+// compressed "content" spec is base64-encoded.
+const (
+	CompressionGzip = "gzip"
+)
+
+// CanarySection checks the device is healthy once the canary files changed
+// and their services were restarted. The other files are only reconciled if it
+// is; the canary files are rolled back otherwise, and the config commit is not
+// applied again until a new one is pushed.
+type CanarySection struct {
+	// Services must stay active for StableSeconds
+	Services []string `yaml:"services,omitempty" json:"services,omitempty"`
+	// URL must then answer 200, e.g. "http://127.0.0.1:8080/healthz"
+	URL           string `yaml:"url,omitempty" json:"url,omitempty"`
+	StableSeconds int    `yaml:"stableSeconds,omitempty" json:"stableSeconds,omitempty"` // Default: 10
 }
 
+// DefaultCanaryStableSeconds is how long the canary services must stay active
+// by default.
+const DefaultCanaryStableSeconds = 10
+
 // SyncBehavior defines actions to take when a file changes
 type SyncBehavior struct {
 	RestartServices []string `yaml:"restartServices,omitempty" json:"restartServices,omitempty"`
 	Reboot          bool     `yaml:"reboot,omitempty" json:"reboot,omitempty"`
 }
 
+// ValuesSource loads template values from a file or an environment variable.
+// Exactly one of File or Env must be set.
+type ValuesSource struct {
+	File string `yaml:"file,omitempty" json:"file,omitempty"` // YAML map of values, relative to config.path in the config repo
+	Env  string `yaml:"env,omitempty" json:"env,omitempty"`   // Environment variable holding a single value
+	Key  string `yaml:"key,omitempty" json:"key,omitempty"`   // Name of the value read from Env. Default: the name of the variable
+}
+
 // DirectorySpec represents a directory to be managed
 type DirectorySpec struct {
 	SourceDir          string              `yaml:"sourceDir" json:"sourceDir"`
@@ -88,6 +315,40 @@ type DirectorySpec struct {
 	RebootOnChange     []string            `yaml:"rebootOnChange,omitempty" json:"rebootOnChange,omitempty"`
 }
 
+// NotifySection defines the webhook the result of each reconciliation that
+// changed the device or failed is POSTed to.
+type NotifySection struct {
+	URL        string `yaml:"url" json:"url"`                                   // Required, http(s) URL
+	AuthHeader string `yaml:"authHeader,omitempty" json:"authHeader,omitempty"` // Optional, sent as the Authorization header
+}
+
+// HeartbeatSection defines the dead man's switch a heartbeat is POSTed to after
+// each successful reconciliation.
+type HeartbeatSection struct {
+	URL        string `yaml:"url" json:"url"`                                   // Required, http(s) URL
+	AuthHeader string `yaml:"authHeader,omitempty" json:"authHeader,omitempty"` // Optional, sent as the Authorization header
+}
+
+// RunAsSection runs edge-cd as a dedicated unprivileged user instead of root.
+// The privileged operations, e.g. writing the managed files, are escalated with
+// the Escalation strategy.
+type RunAsSection struct {
+	User       string `yaml:"user,omitempty" json:"user,omitempty"`             // Default: "edge-cd"
+	Group      string `yaml:"group,omitempty" json:"group,omitempty"`           // Default: User
+	Escalation string `yaml:"escalation,omitempty" json:"escalation,omitempty"` // "sudo" (default) or "capabilities"
+}
+
+// Defaults and strategies of RunAsSection.
+const (
+	DefaultRunAsUser = "edge-cd"
+	// EscalationSudo runs the privileged commands and file writes with "sudo -n".
+	// The user is granted passwordless sudo.
+	EscalationSudo = "sudo"
+	// EscalationCapabilities grants the service the capabilities to write any
+	// file, e.g. CAP_DAC_OVERRIDE, so that nothing is escalated at runtime.
+	EscalationCapabilities = "capabilities"
+)
+
 // LogSection defines logging configuration
 type LogSection struct {
 	Format string `yaml:"format,omitempty" json:"format,omitempty"`
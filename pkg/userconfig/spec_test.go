@@ -1,6 +1,8 @@
 package userconfig
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"gopkg.in/yaml.v3"
@@ -74,6 +76,254 @@ func TestSpec_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "selfUpdate missing version",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+					SelfUpdate: &SelfUpdateSection{
+						URLTemplate: "https://example.com/{{ .Version }}/edge-cd-go",
+					},
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "selfUpdate http without sha256",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+					SelfUpdate: &SelfUpdateSection{
+						Version:     "v1.2.0",
+						URLTemplate: "http://example.com/{{ .Version }}/edge-cd-go",
+					},
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "selfUpdate http with sha256",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+					SelfUpdate: &SelfUpdateSection{
+						Version:     "v1.2.0",
+						URLTemplate: "http://example.com/{{ .Version }}/edge-cd-go",
+						SHA256:      "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+					},
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "selfUpdate invalid sha256",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+					SelfUpdate: &SelfUpdateSection{
+						Version:     "v1.2.0",
+						URLTemplate: "https://example.com/{{ .Version }}/edge-cd-go",
+						SHA256:      "not-a-checksum",
+					},
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown runtime",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+					Runtime: "python",
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative polling interval",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+				PollingInterval: -5,
+			},
+			wantErr: true,
+		},
+		{
+			name: "absolute package group",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+				PackageManager: PackageManagerSection{
+					PackageGroups: []string{"/etc/groups/base.yaml"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relative allowedPathPrefixes",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+				AllowedPathPrefixes: []string{"/etc/edge-cd-managed", "opt/app"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative verifyActiveSeconds",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+				ServiceManager: ServiceManagerSection{VerifyActiveSeconds: -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate service in restartOrder",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+				ServiceManager: ServiceManagerSection{RestartOrder: []string{"backend", "proxy", "backend"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid reconcileOn pattern",
+			config: &Spec{
+				EdgeCD: EdgeCDSection{
+					Repo: RepoConfig{
+						URL:             "https://github.com/example/edge-cd.git",
+						DestinationPath: "/usr/local/src/edge-cd",
+					},
+				},
+				Config: ConfigSection{
+					Spec: "spec.yaml",
+					Path: "./devices/${HOSTNAME}",
+					Repo: ConfigRepo{
+						URL:      "https://github.com/example/config.git",
+						DestPath: "/usr/local/src/config",
+					},
+				},
+				PackageManager: PackageManagerSection{
+					ReconcileOn: []string{"packages/[a-"},
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "missing config.repo.destPath",
 			config: &Spec{
@@ -162,6 +412,141 @@ func TestFileSpec_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "gzip compression",
+			file: FileSpec{
+				Type:        "file",
+				SrcPath:     "/src/file.txt.gz",
+				DestPath:    "/dest/file.txt",
+				Compression: CompressionGzip,
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown compression",
+			file: FileSpec{
+				Type:        "file",
+				SrcPath:     "/src/file.txt.xz",
+				DestPath:    "/dest/file.txt",
+				Compression: "xz",
+			},
+			wantErr: true,
+		},
+		{
+			name: "symlink",
+			file: FileSpec{
+				Type:     "symlink",
+				SrcPath:  "/etc/nginx/sites-available/app",
+				DestPath: "/etc/nginx/sites-enabled/app",
+			},
+			wantErr: false,
+		},
+		{
+			name: "symlink without target",
+			file: FileSpec{
+				Type:     "symlink",
+				DestPath: "/etc/nginx/sites-enabled/app",
+			},
+			wantErr: true,
+		},
+		{
+			name: "symlink with a mode",
+			file: FileSpec{
+				Type:     "symlink",
+				SrcPath:  "/etc/nginx/sites-available/app",
+				DestPath: "/etc/nginx/sites-enabled/app",
+				FileMod:  "644",
+			},
+			wantErr: true,
+		},
+		{
+			name: "delete",
+			file: FileSpec{
+				Type:     "delete",
+				DestPath: "/etc/cron.d/legacy",
+			},
+			wantErr: false,
+		},
+		{
+			name: "delete with a source",
+			file: FileSpec{
+				Type:     "delete",
+				SrcPath:  "/src/legacy",
+				DestPath: "/etc/cron.d/legacy",
+			},
+			wantErr: true,
+		},
+		{
+			name: "delete with content",
+			file: FileSpec{
+				Type:     "delete",
+				DestPath: "/etc/cron.d/legacy",
+				Content:  "* * * * * root true",
+			},
+			wantErr: true,
+		},
+		{
+			name: "variables",
+			file: FileSpec{
+				Type:      "content",
+				DestPath:  "/etc/hosts",
+				Content:   "{{ .IP }} {{ .Hostname }}",
+				Template:  true,
+				Variables: map[string]string{"IP": "10.0.0.1"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "variables without template",
+			file: FileSpec{
+				Type:      "content",
+				DestPath:  "/etc/hosts",
+				Content:   "{{ .IP }} {{ .Hostname }}",
+				Variables: map[string]string{"IP": "10.0.0.1"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "owner and group",
+			file: FileSpec{
+				Type:     "content",
+				DestPath: "/etc/app.conf",
+				Content:  "port=80",
+				Owner:    "app",
+				Group:    "1000",
+			},
+			wantErr: false,
+		},
+		{
+			name: "symlink with an owner",
+			file: FileSpec{
+				Type:     "symlink",
+				SrcPath:  "/etc/nginx/sites-available/app",
+				DestPath: "/etc/nginx/sites-enabled/app",
+				Owner:    "www-data",
+			},
+			wantErr: true,
+		},
+		{
+			name: "directory with prune",
+			file: FileSpec{
+				Type:     "directory",
+				SrcPath:  "/src/conf.d",
+				DestPath: "/etc/app/conf.d",
+				Prune:    true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "file with prune",
+			file: FileSpec{
+				Type:     "file",
+				SrcPath:  "/src/file.txt",
+				DestPath: "/dest/file.txt",
+				Prune:    true,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -174,6 +559,95 @@ func TestFileSpec_Validate(t *testing.T) {
 	}
 }
 
+func TestSpec_DefaultFileMode(t *testing.T) {
+	spec := &Spec{
+		DefaultFileMode: "640",
+		Files: []FileSpec{
+			{Type: "content", DestPath: "/etc/motd", Content: "hello"},
+			{Type: "content", DestPath: "/etc/issue", Content: "hello", FileMod: "600"},
+		},
+	}
+	spec.SetDefaults()
+
+	if spec.Files[0].FileMod != "640" {
+		t.Errorf("Expected the spec default file mode '640', got '%s'", spec.Files[0].FileMod)
+	}
+	if spec.Files[1].FileMod != "600" {
+		t.Errorf("Expected the explicit file mode '600', got '%s'", spec.Files[1].FileMod)
+	}
+
+	for mode, wantErr := range map[string]bool{"640": false, "0755": false, "rw-r-----": true, "1000": true} {
+		if err := ValidateFileMode("defaultFileMode", mode); (err != nil) != wantErr {
+			t.Errorf("ValidateFileMode(%q) error = %v, wantErr %v", mode, err, wantErr)
+		}
+	}
+}
+
+func TestValidateRootPrefix(t *testing.T) {
+	for prefix, wantErr := range map[string]bool{"": false, "/mnt/target": false, "mnt/target": true, "./rootfs": true} {
+		if err := ValidateRootPrefix("rootPrefix", prefix); (err != nil) != wantErr {
+			t.Errorf("ValidateRootPrefix(%q) error = %v, wantErr %v", prefix, err, wantErr)
+		}
+	}
+}
+
+func TestSpec_IsPathAllowed(t *testing.T) {
+	spec := &Spec{AllowedPathPrefixes: []string{"/etc/edge-cd-managed", "/opt/app/"}}
+
+	tests := map[string]bool{
+		"/etc/edge-cd-managed":          true,
+		"/etc/edge-cd-managed/motd":     true,
+		"/opt/app/conf/app.conf":        true,
+		"/etc/passwd":                   false,
+		"/etc/edge-cd-managed-evil/x":   false,
+		"/opt/app/../../etc/shadow":     false,
+		"/etc/edge-cd-managed/../hosts": false,
+	}
+	for destPath, want := range tests {
+		if got := spec.IsPathAllowed(destPath); got != want {
+			t.Errorf("IsPathAllowed(%q) = %v, want %v", destPath, got, want)
+		}
+	}
+
+	if !(&Spec{}).IsPathAllowed("/etc/passwd") {
+		t.Error("IsPathAllowed() = false without prefixes, want true")
+	}
+}
+
+func TestRunAsSection(t *testing.T) {
+	spec := &Spec{RunAs: &RunAsSection{}}
+	spec.SetDefaults()
+
+	want := RunAsSection{User: DefaultRunAsUser, Group: DefaultRunAsUser, Escalation: EscalationSudo}
+	if *spec.RunAs != want {
+		t.Errorf("RunAs after SetDefaults() = %+v, want %+v", *spec.RunAs, want)
+	}
+
+	tests := []struct {
+		name    string
+		runAs   RunAsSection
+		wantErr bool
+	}{
+		{name: "sudo", runAs: RunAsSection{User: "edge-cd", Escalation: EscalationSudo}},
+		{name: "capabilities", runAs: RunAsSection{User: "edge-cd", Escalation: EscalationCapabilities}},
+		{name: "unknown escalation", runAs: RunAsSection{User: "edge-cd", Escalation: "doas"}, wantErr: true},
+		{name: "root", runAs: RunAsSection{User: "root"}, wantErr: true},
+		{name: "sudoers injection", runAs: RunAsSection{User: "edge-cd ALL=(ALL) ALL"}, wantErr: true},
+		{name: "uppercase user", runAs: RunAsSection{User: "EdgeCD"}, wantErr: true},
+		{name: "invalid group", runAs: RunAsSection{User: "edge-cd", Group: "edge-cd\nroot"}, wantErr: true},
+		{name: "user with digits", runAs: RunAsSection{User: "_edge-cd2", Group: "edge_cd"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.runAs.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestSpec_SetDefaults(t *testing.T) {
 	config := &Spec{
 		EdgeCD: EdgeCDSection{
@@ -222,6 +696,44 @@ func TestSpec_SetDefaults(t *testing.T) {
 	}
 }
 
+func TestSpec_SetDefaults_SymlinkAndDelete(t *testing.T) {
+	config := &Spec{
+		EdgeCD: EdgeCDSection{
+			Repo: RepoConfig{
+				URL:             "https://github.com/example/edge-cd.git",
+				DestinationPath: "/usr/local/src/edge-cd",
+			},
+		},
+		Config: ConfigSection{
+			Path: "./devices/${HOSTNAME}",
+			Repo: ConfigRepo{
+				URL:      "https://github.com/example/config.git",
+				DestPath: "/usr/local/src/config",
+			},
+		},
+		DefaultFileMode: "600",
+		Files: []FileSpec{
+			{Type: "content", Content: "welcome\n", DestPath: "/etc/motd"},
+			{Type: "symlink", SrcPath: "/etc/motd", DestPath: "/etc/issue"},
+			{Type: "delete", DestPath: "/etc/legacy.conf"},
+		},
+	}
+
+	config.SetDefaults()
+
+	if config.Files[0].FileMod != "600" {
+		t.Errorf("Expected content fileMod to be '600', got '%s'", config.Files[0].FileMod)
+	}
+	for _, f := range config.Files[1:] {
+		if f.FileMod != "" {
+			t.Errorf("Expected no fileMod for type '%s', got '%s'", f.Type, f.FileMod)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate() after SetDefaults() error = %v", err)
+	}
+}
+
 func TestSpec_YAMLMarshaling(t *testing.T) {
 	yamlData := `edgeCD:
   repo:
@@ -347,3 +859,156 @@ destPath: /usr/local/src/config
 		t.Errorf("ConfigRepo should use 'destPath', got '%s'", configRepo.DestPath)
 	}
 }
+
+func TestCheckoutPaths(t *testing.T) {
+	full := false
+	sparse := true
+
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{
+			name: "edge-cd repo defaults to cmd/edge-cd",
+			got:  RepoConfig{}.CheckoutPaths(),
+			want: []string{"cmd/edge-cd"},
+		},
+		{
+			name: "edge-cd repo explicit paths",
+			got:  RepoConfig{SparseCheckout: &sparse, SparseCheckoutPaths: []string{"cmd/edge-cd-go", "pkg"}}.CheckoutPaths(),
+			want: []string{"cmd/edge-cd-go", "pkg"},
+		},
+		{
+			name: "edge-cd repo full checkout",
+			got:  RepoConfig{SparseCheckout: &full}.CheckoutPaths(),
+			want: nil,
+		},
+		{
+			name: "go runtime adds the Go module",
+			got:  EdgeCDSection{Runtime: RuntimeGo}.CheckoutPaths(),
+			want: []string{"cmd/edge-cd", "cmd/edge-cd-go", "pkg"},
+		},
+		{
+			name: "go runtime merges the explicit paths",
+			got: EdgeCDSection{
+				Runtime: RuntimeGo,
+				Repo:    RepoConfig{SparseCheckoutPaths: []string{"pkg", "scripts"}},
+			}.CheckoutPaths(),
+			want: []string{"pkg", "scripts", "cmd/edge-cd", "cmd/edge-cd-go"},
+		},
+		{
+			name: "go runtime full checkout",
+			got:  EdgeCDSection{Runtime: RuntimeGo, Repo: RepoConfig{SparseCheckout: &full}}.CheckoutPaths(),
+			want: nil,
+		},
+		{
+			name: "shell runtime keeps the repo paths",
+			got:  EdgeCDSection{Runtime: RuntimeShell}.CheckoutPaths(),
+			want: []string{"cmd/edge-cd"},
+		},
+		{
+			name: "config repo defaults to config.path",
+			got:  ConfigRepo{}.CheckoutPaths("devices/host"),
+			want: []string{"devices/host"},
+		},
+		{
+			name: "config repo explicit paths",
+			got:  ConfigRepo{SparseCheckoutPaths: []string{"devices/host", "common"}}.CheckoutPaths("devices/host"),
+			want: []string{"devices/host", "common"},
+		},
+		{
+			name: "config repo full checkout",
+			got:  ConfigRepo{SparseCheckout: &full}.CheckoutPaths("devices/host"),
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("CheckoutPaths() = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigRepo_Validate_SparseCheckout(t *testing.T) {
+	full := false
+
+	tests := []struct {
+		name    string
+		repo    ConfigRepo
+		wantErr bool
+	}{
+		{name: "default", repo: ConfigRepo{}},
+		{name: "full checkout", repo: ConfigRepo{SparseCheckout: &full}},
+		{name: "relative paths", repo: ConfigRepo{SparseCheckoutPaths: []string{"devices/host", "common/"}}},
+		{name: "paths with full checkout", repo: ConfigRepo{SparseCheckout: &full, SparseCheckoutPaths: []string{"common"}}, wantErr: true},
+		{name: "absolute path", repo: ConfigRepo{SparseCheckoutPaths: []string{"/etc"}}, wantErr: true},
+		{name: "path outside the repo", repo: ConfigRepo{SparseCheckoutPaths: []string{"common/../../etc"}}, wantErr: true},
+		{name: "empty path", repo: ConfigRepo{SparseCheckoutPaths: []string{""}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.repo.URL = "https://github.com/example/config.git"
+			tt.repo.DestPath = "/usr/local/src/config"
+			err := tt.repo.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRepoConfig_SparseCheckoutYAML(t *testing.T) {
+	var repo RepoConfig
+	data := "url: https://github.com/example/edge-cd.git\ndestinationPath: /usr/local/src/edge-cd\nsparseCheckout: false\n"
+	if err := yaml.Unmarshal([]byte(data), &repo); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+
+	if repo.CheckoutPaths() != nil {
+		t.Errorf("CheckoutPaths() = %v, want a full checkout", repo.CheckoutPaths())
+	}
+}
+
+func TestSpec_ValidateCanary(t *testing.T) {
+	canaryFile := FileSpec{Type: "content", DestPath: "/etc/app.conf", Content: "port=80", Canary: true}
+	rebootFile := canaryFile
+	rebootFile.SyncBehavior = &SyncBehavior{Reboot: true}
+	otherFile := FileSpec{Type: "content", DestPath: "/etc/web.conf", Content: "port=80"}
+
+	tests := []struct {
+		name    string
+		files   []FileSpec
+		canary  *CanarySection
+		wantErr string
+	}{
+		{name: "no canary", files: []FileSpec{otherFile}},
+		{name: "services", files: []FileSpec{canaryFile, otherFile}, canary: &CanarySection{Services: []string{"app"}}},
+		{name: "url", files: []FileSpec{canaryFile}, canary: &CanarySection{URL: "http://127.0.0.1:8080/healthz"}},
+		{name: "canary file without canary", files: []FileSpec{canaryFile}, wantErr: "file[0] is marked canary but canary is not set"},
+		{name: "canary without canary file", files: []FileSpec{otherFile}, canary: &CanarySection{Services: []string{"app"}}, wantErr: "no file is marked canary"},
+		{name: "no health check", files: []FileSpec{canaryFile}, canary: &CanarySection{}, wantErr: "canary.services or canary.url is required"},
+		{name: "negative stable period", files: []FileSpec{canaryFile}, canary: &CanarySection{Services: []string{"app"}, StableSeconds: -1}, wantErr: "canary.stableSeconds must not be negative"},
+		{name: "canary file rebooting", files: []FileSpec{rebootFile}, canary: &CanarySection{Services: []string{"app"}}, wantErr: "cannot require a reboot"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &Spec{Files: tt.files, Canary: tt.canary}
+			err := spec.validateCanary()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateCanary() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateCanary() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,9 +2,20 @@ package userconfig
 
 import (
 	"fmt"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
+var (
+	// sha256Regex matches a hex-encoded SHA-256 checksum
+	sha256Regex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	// posixNameRegex matches a portable user or group name, which is written
+	// as is to the sudoers rule of the user
+	posixNameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+)
+
 // Validate checks if the Spec is valid
 func (c *Spec) Validate() error {
 	if err := c.EdgeCD.Validate(); err != nil {
@@ -15,6 +26,34 @@ func (c *Spec) Validate() error {
 		return fmt.Errorf("config validation failed: %w", err)
 	}
 
+	if err := ValidatePollingInterval("pollingIntervalSecond", c.PollingInterval); err != nil {
+		return err
+	}
+
+	if c.DefaultFileMode != "" {
+		if err := ValidateFileMode("defaultFileMode", c.DefaultFileMode); err != nil {
+			return err
+		}
+	}
+
+	if err := ValidateRootPrefix("rootPrefix", c.RootPrefix); err != nil {
+		return err
+	}
+
+	for i, prefix := range c.AllowedPathPrefixes {
+		if !path.IsAbs(prefix) {
+			return fmt.Errorf("allowedPathPrefixes[%d] must be an absolute path, got %q", i, prefix)
+		}
+	}
+
+	if err := c.ServiceManager.Validate(); err != nil {
+		return fmt.Errorf("serviceManager validation failed: %w", err)
+	}
+
+	if err := c.PackageManager.Validate(); err != nil {
+		return fmt.Errorf("packageManager validation failed: %w", err)
+	}
+
 	// Validate files if present
 	for i, file := range c.Files {
 		if err := file.Validate(); err != nil {
@@ -29,6 +68,66 @@ func (c *Spec) Validate() error {
 		}
 	}
 
+	for i, source := range c.ValuesFrom {
+		if err := source.Validate(); err != nil {
+			return fmt.Errorf("valuesFrom[%d] validation failed: %w", i, err)
+		}
+	}
+
+	if c.Notify != nil {
+		if err := c.Notify.Validate(); err != nil {
+			return fmt.Errorf("notify validation failed: %w", err)
+		}
+	}
+
+	if c.Heartbeat != nil {
+		if err := c.Heartbeat.Validate(); err != nil {
+			return fmt.Errorf("heartbeat validation failed: %w", err)
+		}
+	}
+
+	if c.RunAs != nil {
+		if err := c.RunAs.Validate(); err != nil {
+			return fmt.Errorf("runAs validation failed: %w", err)
+		}
+	}
+
+	if err := c.validateCanary(); err != nil {
+		return fmt.Errorf("canary validation failed: %w", err)
+	}
+
+	return nil
+}
+
+// validateCanary checks the canary section and the files marked canary. The
+// canary files cannot require a reboot, as the health check runs before it.
+func (c *Spec) validateCanary() error {
+	hasCanaryFiles := false
+	for i, file := range c.Files {
+		if !file.Canary {
+			continue
+		}
+		hasCanaryFiles = true
+		if c.Canary == nil {
+			return fmt.Errorf("file[%d] is marked canary but canary is not set", i)
+		}
+		if file.SyncBehavior != nil && file.SyncBehavior.Reboot {
+			return fmt.Errorf("file[%d] is marked canary and cannot require a reboot", i)
+		}
+	}
+
+	if c.Canary == nil {
+		return nil
+	}
+	if !hasCanaryFiles {
+		return fmt.Errorf("canary is set but no file is marked canary")
+	}
+	if len(c.Canary.Services) == 0 && c.Canary.URL == "" {
+		return fmt.Errorf("canary.services or canary.url is required")
+	}
+	if c.Canary.StableSeconds < 0 {
+		return fmt.Errorf("canary.stableSeconds must not be negative, got %d", c.Canary.StableSeconds)
+	}
 	return nil
 }
 
@@ -37,6 +136,40 @@ func (e *EdgeCDSection) Validate() error {
 	if err := e.Repo.Validate(); err != nil {
 		return fmt.Errorf("repo validation failed: %w", err)
 	}
+
+	switch e.Runtime {
+	case "", RuntimeShell, RuntimeGo:
+	default:
+		return fmt.Errorf("edgeCD.runtime must be one of: %s, %s", RuntimeShell, RuntimeGo)
+	}
+
+	if e.SelfUpdate != nil {
+		if err := e.SelfUpdate.Validate(); err != nil {
+			return fmt.Errorf("selfUpdate validation failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// Validate checks if the SelfUpdateSection is valid
+func (s *SelfUpdateSection) Validate() error {
+	if s.Version == "" {
+		return fmt.Errorf("selfUpdate.version is required")
+	}
+
+	if s.URLTemplate == "" {
+		return fmt.Errorf("selfUpdate.urlTemplate is required")
+	}
+
+	if s.SHA256 != "" && !sha256Regex.MatchString(s.SHA256) {
+		return fmt.Errorf("selfUpdate.sha256 must be 64 hexadecimal characters, got %q", s.SHA256)
+	}
+
+	// Only TLS authenticates a download without a pinned checksum
+	if s.SHA256 == "" && !strings.HasPrefix(s.URLTemplate, "https://") {
+		return fmt.Errorf("selfUpdate.urlTemplate must use https unless selfUpdate.sha256 is set")
+	}
+
 	return nil
 }
 
@@ -54,9 +187,49 @@ func (c *ConfigSection) Validate() error {
 		return fmt.Errorf("repo validation failed: %w", err)
 	}
 
+	if err := ValidateSyncFailurePolicy(c.SyncFailurePolicy); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidatePollingInterval checks the interval in seconds of the given field is
+// not negative. Zero means the default interval.
+func ValidatePollingInterval(field string, interval int) error {
+	if interval < 0 {
+		return fmt.Errorf("%s must not be negative, got %d", field, interval)
+	}
+	return nil
+}
+
+// ValidateFileMode checks the file mode of the given field is an octal mode, e.g. "640"
+func ValidateFileMode(field, mode string) error {
+	if m, err := strconv.ParseUint(mode, 8, 32); err != nil || m > 0777 {
+		return fmt.Errorf("%s must be an octal file mode, e.g. \"640\", got %q", field, mode)
+	}
 	return nil
 }
 
+// ValidateRootPrefix checks the root prefix is empty or an absolute path
+func ValidateRootPrefix(field, prefix string) error {
+	if prefix != "" && !path.IsAbs(prefix) {
+		return fmt.Errorf("%s must be an absolute path, got %q", field, prefix)
+	}
+	return nil
+}
+
+// ValidateSyncFailurePolicy checks the policy is empty or one of the supported policies
+func ValidateSyncFailurePolicy(policy string) error {
+	switch policy {
+	case "", SyncFailurePolicyFailClosed, SyncFailurePolicyFailOpen:
+		return nil
+	default:
+		return fmt.Errorf("config.syncFailurePolicy must be one of: %s, %s",
+			SyncFailurePolicyFailClosed, SyncFailurePolicyFailOpen)
+	}
+}
+
 // Validate checks if the RepoConfig is valid
 func (r *RepoConfig) Validate() error {
 	if r.URL == "" {
@@ -67,7 +240,7 @@ func (r *RepoConfig) Validate() error {
 		return fmt.Errorf("repo.destinationPath is required")
 	}
 
-	return nil
+	return validateSparseCheckout(r.SparseCheckout, r.SparseCheckoutPaths)
 }
 
 // Validate checks if the ConfigRepo is valid
@@ -80,6 +253,21 @@ func (r *ConfigRepo) Validate() error {
 		return fmt.Errorf("repo.destPath is required")
 	}
 
+	return validateSparseCheckout(r.SparseCheckout, r.SparseCheckoutPaths)
+}
+
+// validateSparseCheckout checks the sparse checkout paths are relative paths inside the repo
+func validateSparseCheckout(sparse *bool, paths []string) error {
+	if sparse != nil && !*sparse && len(paths) > 0 {
+		return fmt.Errorf("repo.sparseCheckoutPaths cannot be set when repo.sparseCheckout is false")
+	}
+
+	for _, p := range paths {
+		if p == "" || path.IsAbs(p) || p == ".." || strings.HasPrefix(path.Clean(p), "../") {
+			return fmt.Errorf("repo.sparseCheckoutPaths must be relative paths inside the repo, got %q", p)
+		}
+	}
+
 	return nil
 }
 
@@ -89,7 +277,7 @@ func (f *FileSpec) Validate() error {
 		return fmt.Errorf("file.type is required")
 	}
 
-	validTypes := []string{"file", "directory", "content"}
+	validTypes := []string{"file", "directory", "content", "symlink", "delete"}
 	isValidType := false
 	for _, vt := range validTypes {
 		if f.Type == vt {
@@ -115,6 +303,32 @@ func (f *FileSpec) Validate() error {
 		if f.Content == "" {
 			return fmt.Errorf("file.content is required for type 'content'")
 		}
+	case "symlink":
+		if f.SrcPath == "" {
+			return fmt.Errorf("file.srcPath, the target of the link, is required for type 'symlink'")
+		}
+		if f.Content != "" || f.FileMod != "" || f.Owner != "" || f.Group != "" || f.Template || f.Compression != "" || f.AllowEmpty {
+			return fmt.Errorf("file.content, fileMod, owner, group, template, compression and allowEmpty are not supported for type 'symlink'")
+		}
+	case "delete":
+		if f.SrcPath != "" || f.Content != "" {
+			return fmt.Errorf("file.srcPath and file.content are not supported for type 'delete'")
+		}
+		if f.FileMod != "" || f.Owner != "" || f.Group != "" || f.Template || f.Compression != "" || f.AllowEmpty {
+			return fmt.Errorf("file.fileMod, owner, group, template, compression and allowEmpty are not supported for type 'delete'")
+		}
+	}
+
+	if f.Prune && f.Type != "directory" {
+		return fmt.Errorf("file.prune is only supported for type 'directory'")
+	}
+
+	if len(f.Variables) > 0 && !f.Template {
+		return fmt.Errorf("file.variables requires template")
+	}
+
+	if f.Compression != "" && f.Compression != CompressionGzip {
+		return fmt.Errorf("file.compression must be empty or %s, got %q", CompressionGzip, f.Compression)
 	}
 
 	return nil
@@ -133,6 +347,110 @@ func (d *DirectorySpec) Validate() error {
 	return nil
 }
 
+// Validate checks if the ServiceManagerSection is valid
+func (s *ServiceManagerSection) Validate() error {
+	if s.VerifyActiveSeconds < 0 {
+		return fmt.Errorf("serviceManager.verifyActiveSeconds must not be negative, got %d", s.VerifyActiveSeconds)
+	}
+
+	seen := make(map[string]bool, len(s.RestartOrder))
+	for i, svc := range s.RestartOrder {
+		if svc == "" {
+			return fmt.Errorf("serviceManager.restartOrder[%d] is empty", i)
+		}
+		if seen[svc] {
+			return fmt.Errorf("serviceManager.restartOrder[%d]: duplicate service %q", i, svc)
+		}
+		seen[svc] = true
+	}
+
+	return nil
+}
+
+// Validate checks if the PackageManagerSection is valid
+func (p *PackageManagerSection) Validate() error {
+	for i, group := range p.PackageGroups {
+		if group == "" {
+			return fmt.Errorf("packageManager.packageGroups[%d] is empty", i)
+		}
+		if path.IsAbs(group) {
+			return fmt.Errorf("packageManager.packageGroups[%d] must be relative to config.path, got %q", i, group)
+		}
+	}
+
+	for i, pattern := range p.ReconcileOn {
+		if pattern == "" {
+			return fmt.Errorf("packageManager.reconcileOn[%d] is empty", i)
+		}
+		if path.IsAbs(pattern) {
+			return fmt.Errorf("packageManager.reconcileOn[%d] must be relative to config.path, got %q", i, pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("packageManager.reconcileOn[%d] is an invalid pattern %q: %w", i, pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks if the ValuesSource is valid
+func (v *ValuesSource) Validate() error {
+	if (v.File == "") == (v.Env == "") {
+		return fmt.Errorf("exactly one of valuesFrom.file and valuesFrom.env is required")
+	}
+
+	if v.Key != "" && v.Env == "" {
+		return fmt.Errorf("valuesFrom.key is only supported with valuesFrom.env")
+	}
+
+	return nil
+}
+
+// Validate checks if the NotifySection is valid
+func (n *NotifySection) Validate() error {
+	return validateWebhookURL("notify.url", n.URL)
+}
+
+// Validate checks if the HeartbeatSection is valid
+func (h *HeartbeatSection) Validate() error {
+	return validateWebhookURL("heartbeat.url", h.URL)
+}
+
+// Validate checks if the RunAsSection is valid
+func (r *RunAsSection) Validate() error {
+	if r.User == "root" {
+		return fmt.Errorf("runAs.user must not be root: omit runAs to run as root")
+	}
+
+	if r.User != "" && !posixNameRegex.MatchString(r.User) {
+		return fmt.Errorf("runAs.user must be a POSIX user name, got %q", r.User)
+	}
+
+	if r.Group != "" && !posixNameRegex.MatchString(r.Group) {
+		return fmt.Errorf("runAs.group must be a POSIX group name, got %q", r.Group)
+	}
+
+	switch r.Escalation {
+	case "", EscalationSudo, EscalationCapabilities:
+		return nil
+	default:
+		return fmt.Errorf("runAs.escalation must be %s or %s, got %q", EscalationSudo, EscalationCapabilities, r.Escalation)
+	}
+}
+
+// validateWebhookURL checks the webhook URL of the given field is a non-empty http(s) URL
+func validateWebhookURL(field, url string) error {
+	if url == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("%s must be an http or https URL, got %q", field, url)
+	}
+
+	return nil
+}
+
 // SetDefaults sets default values for optional fields
 func (c *Spec) SetDefaults() {
 	// Set default spec file name if not provided
@@ -151,13 +469,33 @@ func (c *Spec) SetDefaults() {
 
 	// Set default polling interval if not provided
 	if c.PollingInterval == 0 {
-		c.PollingInterval = 60 // Default to 60 seconds
+		c.PollingInterval = DefaultPollingInterval
+	}
+
+	if c.RunAs != nil {
+		if c.RunAs.User == "" {
+			c.RunAs.User = DefaultRunAsUser
+		}
+		if c.RunAs.Group == "" {
+			c.RunAs.Group = c.RunAs.User
+		}
+		if c.RunAs.Escalation == "" {
+			c.RunAs.Escalation = EscalationSudo
+		}
 	}
 
 	// Set default file mode for files
+	defaultFileMode := c.DefaultFileMode
+	if defaultFileMode == "" {
+		defaultFileMode = "644"
+	}
 	for i := range c.Files {
+		// Symlinks and deleted files have no mode
+		if c.Files[i].Type == "symlink" || c.Files[i].Type == "delete" {
+			continue
+		}
 		if c.Files[i].FileMod == "" {
-			c.Files[i].FileMod = "644"
+			c.Files[i].FileMod = defaultFileMode
 		}
 	}
 
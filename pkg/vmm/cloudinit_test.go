@@ -0,0 +1,55 @@
+package vmm_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/cloudinit"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"sigs.k8s.io/yaml"
+)
+
+// TestSaveCloudInit verifies the rendered user-data and meta-data are written and are valid YAML
+func TestSaveCloudInit(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cloud-init", "target")
+	userData := cloudinit.UserData{
+		Hostname:    "test-vm",
+		Packages:    []string{"git"},
+		Users:       []cloudinit.User{cloudinit.NewUserWithAuthorizedKeys("ubuntu", []string{"ssh-ed25519 AAAA"})},
+		RunCommands: []string{"systemctl restart sshd"},
+	}
+
+	if err := vmm.SaveCloudInit(dir, "test-vm", userData); err != nil {
+		t.Fatalf("SaveCloudInit failed: %v", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "user-data"))
+	if err != nil {
+		t.Fatalf("user-data was not written: %v", err)
+	}
+	if !strings.HasPrefix(string(b), "#cloud-config\n") {
+		t.Errorf("user-data must start with #cloud-config header, got %q", string(b))
+	}
+
+	var parsed cloudinit.UserData
+	if err := yaml.UnmarshalStrict(b, &parsed); err != nil {
+		t.Fatalf("user-data is not valid YAML: %v", err)
+	}
+	if parsed.Hostname != "test-vm" || len(parsed.Users) != 1 || parsed.Users[0].Name != "ubuntu" {
+		t.Errorf("unexpected user-data content: %+v", parsed)
+	}
+
+	b, err = os.ReadFile(filepath.Join(dir, "meta-data"))
+	if err != nil {
+		t.Fatalf("meta-data was not written: %v", err)
+	}
+	metaData := map[string]string{}
+	if err := yaml.Unmarshal(b, &metaData); err != nil {
+		t.Fatalf("meta-data is not valid YAML: %v", err)
+	}
+	if metaData["instance-id"] != "test-vm" || metaData["local-hostname"] != "test-vm" {
+		t.Errorf("unexpected meta-data content: %+v", metaData)
+	}
+}
@@ -0,0 +1,327 @@
+package vmm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+	"libvirt.org/go/libvirtxml"
+)
+
+var (
+	errInvalidVMConfig      = errors.New("invalid VM configuration")
+	errDuplicateVirtioFSTag = errors.New("duplicate virtiofs tag")
+	errVirtioFSMountNotAbs  = errors.New("virtiofs mount point must be an absolute path")
+	errMissingVMConfigField = errors.New("missing required VM configuration field")
+	errInvalidExtraDisk     = errors.New("invalid extra disk")
+)
+
+// buildDomainXML validates the VM configuration and builds the libvirt domain
+// booting the overlay disk and the cloud-init ISO that CreateVM writes to
+// cfg.TempDir. It does not create any file nor talk to libvirt.
+func buildDomainXML(cfg VMConfig) (*libvirtxml.Domain, error) {
+	if err := validateVMConfig(cfg); err != nil {
+		return nil, err
+	}
+	cpu, err := domainCPU(cfg)
+	if err != nil {
+		return nil, err
+	}
+	memoryBacking, err := domainMemoryBacking(cfg)
+	if err != nil {
+		return nil, err
+	}
+	extraDisks, err := domainExtraDisks(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// libvirt (virtlogd) writes the whole console output to the log file, from
+	// the first boot message on, whether or not a client is attached.
+	var consoleLog *libvirtxml.DomainChardevLog
+	if cfg.ConsoleLog {
+		consoleLog = &libvirtxml.DomainChardevLog{File: consoleLogFile(cfg.TempDir, cfg.Name), Append: "off"}
+	}
+
+	domain := &libvirtxml.Domain{
+		Type: domainVirt,
+		Name: cfg.Name,
+		Memory: &libvirtxml.DomainMemory{
+			Value: cfg.MemoryMB,
+			Unit:  "MiB",
+		},
+		VCPU: &libvirtxml.DomainVCPU{
+			Value: cfg.VCPUs,
+		},
+		OS: &libvirtxml.DomainOS{
+			Type: &libvirtxml.DomainOSType{
+				Arch:    domainArch,
+				Machine: domainMachine,
+				Type:    "hvm",
+			},
+			BootDevices: []libvirtxml.DomainBootDevice{
+				{Dev: "hd"},
+			},
+		},
+		Features: &libvirtxml.DomainFeatureList{
+			ACPI: &libvirtxml.DomainFeature{},
+			APIC: &libvirtxml.DomainFeatureAPIC{},
+		},
+		CPU: cpu,
+		Clock: &libvirtxml.DomainClock{
+			Offset: "utc",
+		},
+		OnPoweroff:    "destroy",
+		OnReboot:      "restart",
+		OnCrash:       "destroy",
+		MemoryBacking: memoryBacking,
+		Devices: &libvirtxml.DomainDeviceList{
+			Disks: append([]libvirtxml.DomainDisk{
+				{
+					Device: "disk",
+					Driver: &libvirtxml.DomainDiskDriver{
+						Name: "qemu",
+						Type: "qcow2",
+					},
+					Source: &libvirtxml.DomainDiskSource{
+						File: &libvirtxml.DomainDiskSourceFile{
+							File: vmDiskFile(cfg.TempDir, cfg.Name),
+						},
+					},
+					Target: &libvirtxml.DomainDiskTarget{
+						Dev: "vda",
+						Bus: "virtio",
+					},
+				},
+				{
+					Device: "cdrom",
+					Driver: &libvirtxml.DomainDiskDriver{
+						Name: "qemu",
+						Type: "raw",
+					},
+					Source: &libvirtxml.DomainDiskSource{
+						File: &libvirtxml.DomainDiskSourceFile{
+							File: cloudInitISOFile(cfg.TempDir, cfg.Name),
+						},
+					},
+					Target: &libvirtxml.DomainDiskTarget{
+						Dev: "sdb",
+						Bus: "sata",
+					},
+					ReadOnly: &libvirtxml.DomainDiskReadOnly{},
+				},
+			}, extraDisks...),
+			Interfaces: []libvirtxml.DomainInterface{
+				{
+					Source: &libvirtxml.DomainInterfaceSource{
+						Network: &libvirtxml.DomainInterfaceSourceNetwork{
+							Network: cfg.Network,
+						},
+					},
+					Model: &libvirtxml.DomainInterfaceModel{
+						Type: "virtio",
+					},
+				},
+			},
+			Consoles: []libvirtxml.DomainConsole{
+				{
+					Target: &libvirtxml.DomainConsoleTarget{
+						Type: "serial",
+						Port: ptr(uint(0)),
+					},
+					Source: &libvirtxml.DomainChardevSource{
+						Pty: &libvirtxml.DomainChardevSourcePty{},
+					},
+					Log: consoleLog,
+				},
+			},
+			Channels: []libvirtxml.DomainChannel{
+				{
+					Target: &libvirtxml.DomainChannelTarget{
+						VirtIO: &libvirtxml.DomainChannelTargetVirtIO{
+							Name: "org.qemu.guest_agent.0",
+						},
+					},
+					Address: &libvirtxml.DomainAddress{
+						VirtioSerial: &libvirtxml.DomainAddressVirtioSerial{
+							Controller: ptr(uint(0)),
+							Bus:        ptr(uint(0)),
+							Port:       ptr(uint(1)),
+						},
+					},
+				},
+			},
+			RNGs: []libvirtxml.DomainRNG{
+				{
+					Model: "virtio",
+					Backend: &libvirtxml.DomainRNGBackend{
+						Random: &libvirtxml.DomainRNGBackendRandom{
+							Device: "/dev/urandom",
+						},
+					},
+				},
+			},
+			Filesystems: domainFilesystems(cfg),
+		},
+	}
+
+	return domain, nil
+}
+
+// validateVMConfig checks the fields the domain cannot be built without. The
+// CPU and memory backing are checked by domainCPU and domainMemoryBacking.
+func validateVMConfig(cfg VMConfig) error {
+	for _, field := range []struct{ name, value string }{
+		{"name", cfg.Name},
+		{"imageQCOW2Path", cfg.ImageQCOW2Path},
+		{"tempDir", cfg.TempDir},
+		{"network", cfg.Network},
+	} {
+		if field.value == "" {
+			return flaterrors.Join(fmt.Errorf("field=%s", field.name), errMissingVMConfigField, errInvalidVMConfig)
+		}
+	}
+	if cfg.MemoryMB == 0 {
+		return flaterrors.Join(errors.New("memoryMB must be greater than 0"), errInvalidVMConfig)
+	}
+	if cfg.VCPUs == 0 {
+		return flaterrors.Join(errors.New("vcpus must be greater than 0"), errInvalidVMConfig)
+	}
+
+	tags := make(map[string]bool, len(cfg.VirtioFS))
+	for _, fs := range cfg.VirtioFS {
+		if fs.Tag == "" {
+			return flaterrors.Join(fmt.Errorf("mountPoint=%s", fs.MountPoint), errors.New("virtiofs tag is required"), errInvalidVMConfig)
+		}
+		if tags[fs.Tag] {
+			return flaterrors.Join(fmt.Errorf("tag=%s", fs.Tag), errDuplicateVirtioFSTag, errInvalidVMConfig)
+		}
+		tags[fs.Tag] = true
+		if !filepath.IsAbs(fs.MountPoint) {
+			return flaterrors.Join(fmt.Errorf("tag=%s mountPoint=%s", fs.Tag, fs.MountPoint), errVirtioFSMountNotAbs, errInvalidVMConfig)
+		}
+	}
+	return nil
+}
+
+// domainFilesystems builds the virtiofs filesystems of the domain. libvirt
+// manages virtiofsd, so only the DomainFilesystem elements are needed.
+func domainFilesystems(cfg VMConfig) []libvirtxml.DomainFilesystem {
+	var filesystems []libvirtxml.DomainFilesystem
+	for _, fs := range cfg.VirtioFS {
+		filesystems = append(filesystems, libvirtxml.DomainFilesystem{
+			AccessMode: "passthrough",
+			Driver: &libvirtxml.DomainFilesystemDriver{
+				Type:  "virtiofs",
+				Queue: 1024,
+			},
+			Target: &libvirtxml.DomainFilesystemTarget{
+				Dir: fs.Tag, // guest mount tag
+			},
+			Source: &libvirtxml.DomainFilesystemSource{
+				Mount: &libvirtxml.DomainFilesystemSourceMount{
+					Dir: fs.MountPoint, // host-side path
+				},
+			},
+		})
+	}
+	return filesystems
+}
+
+// domainExtraDisks builds the disks of cfg.ExtraDisks. The virtio disks follow
+// the root disk (vda) and the sata disks skip the cloud-init cdrom (sdb).
+func domainExtraDisks(cfg VMConfig) ([]libvirtxml.DomainDisk, error) {
+	var (
+		disks []libvirtxml.DomainDisk
+		next  = map[string]int{DiskBusVirtio: 1, DiskBusSATA: 0}
+	)
+	for i, disk := range cfg.ExtraDisks {
+		bus := disk.Bus
+		if bus == "" {
+			bus = DiskBusVirtio
+		}
+		index, ok := next[bus]
+		if !ok {
+			return nil, flaterrors.Join(fmt.Errorf("index=%d bus=%s", i, bus), errInvalidExtraDisk, errInvalidVMConfig)
+		}
+		if disk.Size == "" {
+			return nil, flaterrors.Join(fmt.Errorf("index=%d", i), errors.New("size is required"), errInvalidExtraDisk, errInvalidVMConfig)
+		}
+
+		prefix := "vd"
+		if bus == DiskBusSATA {
+			prefix = "sd"
+			if index == 1 {
+				index++
+			}
+		}
+		if index >= 26 {
+			return nil, flaterrors.Join(fmt.Errorf("index=%d bus=%s", i, bus), errors.New("too many disks on the bus"), errInvalidExtraDisk, errInvalidVMConfig)
+		}
+		next[bus] = index + 1
+
+		disks = append(disks, libvirtxml.DomainDisk{
+			Device: "disk",
+			Driver: &libvirtxml.DomainDiskDriver{
+				Name: "qemu",
+				Type: "qcow2",
+			},
+			Source: &libvirtxml.DomainDiskSource{
+				File: &libvirtxml.DomainDiskSourceFile{
+					File: extraDiskFile(cfg.TempDir, cfg.Name, i),
+				},
+			},
+			Target: &libvirtxml.DomainDiskTarget{
+				Dev: prefix + string(rune('a'+index)),
+				Bus: bus,
+			},
+		})
+	}
+	return disks, nil
+}
+
+// vmDiskFile returns the path of the overlay disk of the VM vmName.
+func vmDiskFile(tempDir, vmName string) string {
+	return filepath.Join(tempDir, fmt.Sprintf("%s.qcow2", vmName))
+}
+
+// cloudInitISOFile returns the path of the cloud-init ISO of the VM vmName.
+func cloudInitISOFile(tempDir, vmName string) string {
+	return filepath.Join(tempDir, fmt.Sprintf("%s-cloud-init.iso", vmName))
+}
+
+// consoleLogFile returns the path of the serial console log of the VM vmName.
+func consoleLogFile(tempDir, vmName string) string {
+	return filepath.Join(tempDir, fmt.Sprintf("%s-console.log", vmName))
+}
+
+// extraDiskFile returns the path of the i-th extra disk of the VM vmName.
+func extraDiskFile(tempDir, vmName string, i int) string {
+	return filepath.Join(tempDir, fmt.Sprintf("%s-data%d.qcow2", vmName, i))
+}
+
+// extraDiskFiles returns the paths of the extra disks of the VM vmName found in
+// tempDir, so that they are deleted without knowing the VM configuration.
+func extraDiskFiles(tempDir, vmName string) []string {
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, entry := range entries {
+		index, ok := strings.CutPrefix(entry.Name(), vmName+"-data")
+		if !ok {
+			continue
+		}
+		index, ok = strings.CutSuffix(index, ".qcow2")
+		if _, err := strconv.Atoi(index); !ok || err != nil {
+			continue
+		}
+		paths = append(paths, filepath.Join(tempDir, entry.Name()))
+	}
+	return paths
+}
@@ -0,0 +1,230 @@
+package vmm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/cloudinit"
+)
+
+func newTestDomainConfig() VMConfig {
+	cfg := NewVMConfig("test-vm", "/images/base.qcow2", cloudinit.UserData{Hostname: "test-vm"})
+	cfg.TempDir = "/var/lib/edge-cd"
+	return cfg
+}
+
+func TestBuildDomainXML(t *testing.T) {
+	tests := []struct {
+		name        string
+		configure   func(cfg *VMConfig)
+		contains    []string
+		notContains []string
+	}{
+		{
+			name: "defaults",
+			contains: []string{
+				`<domain type="kvm">`,
+				`<name>test-vm</name>`,
+				`<memory unit="MiB">2048</memory>`,
+				`<vcpu>2</vcpu>`,
+				`<disk type="file" device="disk"><driver name="qemu" type="qcow2"></driver><source file="/var/lib/edge-cd/test-vm.qcow2"></source><target dev="vda" bus="virtio"></target></disk>`,
+				`<disk type="file" device="cdrom"><driver name="qemu" type="raw"></driver><source file="/var/lib/edge-cd/test-vm-cloud-init.iso"></source><target dev="sdb" bus="sata"></target><readonly></readonly></disk>`,
+				`<interface type="network"><source network="default"></source><model type="virtio"></model></interface>`,
+				`<memoryBacking><source type="memfd"></source><access mode="shared"></access></memoryBacking>`,
+			},
+			notContains: []string{"<filesystem", "<log "},
+		},
+		{
+			name: "sizing and network",
+			configure: func(cfg *VMConfig) {
+				cfg.MemoryMB = 4096
+				cfg.VCPUs = 4
+				cfg.Network = "edge-cd-net"
+			},
+			contains: []string{
+				`<memory unit="MiB">4096</memory>`,
+				`<vcpu>4</vcpu>`,
+				`<source network="edge-cd-net"></source>`,
+			},
+		},
+		{
+			name: "virtiofs mounts",
+			configure: func(cfg *VMConfig) {
+				cfg.VirtioFS = []VirtioFSConfig{
+					{Tag: "config", MountPoint: "/srv/config"},
+					{Tag: "cache", MountPoint: "/srv/cache"},
+				}
+			},
+			contains: []string{
+				`<filesystem type="mount" accessmode="passthrough"><driver type="virtiofs" queue="1024"></driver><source dir="/srv/config"></source><target dir="config"></target></filesystem>`,
+				`<filesystem type="mount" accessmode="passthrough"><driver type="virtiofs" queue="1024"></driver><source dir="/srv/cache"></source><target dir="cache"></target></filesystem>`,
+				`<memoryBacking><source type="memfd"></source><access mode="shared"></access></memoryBacking>`,
+			},
+		},
+		{
+			name: "extra disks",
+			configure: func(cfg *VMConfig) {
+				cfg.ExtraDisks = []DiskConfig{
+					{Size: "10G"},
+					{Size: "1G", Bus: DiskBusSATA},
+					{Size: "5G", Bus: DiskBusVirtio},
+					{Size: "1G", Bus: DiskBusSATA},
+				}
+			},
+			contains: []string{
+				`<source file="/var/lib/edge-cd/test-vm.qcow2"></source><target dev="vda" bus="virtio"></target>`,
+				`<source file="/var/lib/edge-cd/test-vm-cloud-init.iso"></source><target dev="sdb" bus="sata"></target>`,
+				`<source file="/var/lib/edge-cd/test-vm-data0.qcow2"></source><target dev="vdb" bus="virtio"></target>`,
+				`<source file="/var/lib/edge-cd/test-vm-data1.qcow2"></source><target dev="sda" bus="sata"></target>`,
+				`<source file="/var/lib/edge-cd/test-vm-data2.qcow2"></source><target dev="vdc" bus="virtio"></target>`,
+				`<source file="/var/lib/edge-cd/test-vm-data3.qcow2"></source><target dev="sdc" bus="sata"></target>`,
+			},
+		},
+		{
+			name:      "console log",
+			configure: func(cfg *VMConfig) { cfg.ConsoleLog = true },
+			contains: []string{
+				`<console type="pty"><target type="serial" port="0"></target><log file="/var/lib/edge-cd/test-vm-console.log" append="off"></log></console>`,
+			},
+		},
+		{
+			name: "file-backed private memory",
+			configure: func(cfg *VMConfig) {
+				cfg.MemoryBacking = MemoryBackingConfig{SourceType: MemorySourceFile, AccessMode: MemoryAccessPrivate}
+			},
+			contains: []string{`<memoryBacking><source type="file"></source><access mode="private"></access></memoryBacking>`},
+		},
+		{
+			name:        "memory backing disabled",
+			configure:   func(cfg *VMConfig) { cfg.MemoryBacking.Disabled = true },
+			notContains: []string{"<memoryBacking>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestDomainConfig()
+			if tt.configure != nil {
+				tt.configure(&cfg)
+			}
+
+			domain, err := buildDomainXML(cfg)
+			if err != nil {
+				t.Fatalf("buildDomainXML failed: %v", err)
+			}
+			xml, err := domain.Marshal()
+			if err != nil {
+				t.Fatalf("failed to marshal domain: %v", err)
+			}
+			// Compare the stanzas regardless of the indentation
+			compact := strings.Join(strings.Fields(strings.ReplaceAll(xml, ">\n", ">")), " ")
+			compact = strings.ReplaceAll(compact, "> <", "><")
+
+			for _, want := range tt.contains {
+				if !strings.Contains(compact, want) {
+					t.Errorf("expected domain XML to contain %q, got %s", want, xml)
+				}
+			}
+			for _, unwanted := range tt.notContains {
+				if strings.Contains(compact, unwanted) {
+					t.Errorf("expected domain XML not to contain %q, got %s", unwanted, xml)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildDomainXML_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *VMConfig)
+		wantErr   error
+	}{
+		{
+			name:      "missing name",
+			configure: func(cfg *VMConfig) { cfg.Name = "" },
+			wantErr:   errMissingVMConfigField,
+		},
+		{
+			name:      "missing image",
+			configure: func(cfg *VMConfig) { cfg.ImageQCOW2Path = "" },
+			wantErr:   errMissingVMConfigField,
+		},
+		{
+			name:      "missing temp dir",
+			configure: func(cfg *VMConfig) { cfg.TempDir = "" },
+			wantErr:   errMissingVMConfigField,
+		},
+		{
+			name:      "missing network",
+			configure: func(cfg *VMConfig) { cfg.Network = "" },
+			wantErr:   errMissingVMConfigField,
+		},
+		{
+			name:      "no memory",
+			configure: func(cfg *VMConfig) { cfg.MemoryMB = 0 },
+			wantErr:   errInvalidVMConfig,
+		},
+		{
+			name:      "no vcpu",
+			configure: func(cfg *VMConfig) { cfg.VCPUs = 0 },
+			wantErr:   errInvalidVMConfig,
+		},
+		{
+			name:      "virtiofs without tag",
+			configure: func(cfg *VMConfig) { cfg.VirtioFS = []VirtioFSConfig{{MountPoint: "/srv"}} },
+			wantErr:   errInvalidVMConfig,
+		},
+		{
+			name: "duplicate virtiofs tag",
+			configure: func(cfg *VMConfig) {
+				cfg.VirtioFS = []VirtioFSConfig{{Tag: "share", MountPoint: "/srv/a"}, {Tag: "share", MountPoint: "/srv/b"}}
+			},
+			wantErr: errDuplicateVirtioFSTag,
+		},
+		{
+			name:      "relative virtiofs mount point",
+			configure: func(cfg *VMConfig) { cfg.VirtioFS = []VirtioFSConfig{{Tag: "share", MountPoint: "srv"}} },
+			wantErr:   errVirtioFSMountNotAbs,
+		},
+		{
+			name: "virtiofs without shared memory",
+			configure: func(cfg *VMConfig) {
+				cfg.VirtioFS = []VirtioFSConfig{{Tag: "share", MountPoint: "/srv"}}
+				cfg.MemoryBacking.Disabled = true
+			},
+			wantErr: errVirtioFSNeedsSharedMemory,
+		},
+		{
+			name:      "extra disk without size",
+			configure: func(cfg *VMConfig) { cfg.ExtraDisks = []DiskConfig{{Bus: DiskBusVirtio}} },
+			wantErr:   errInvalidExtraDisk,
+		},
+		{
+			name:      "extra disk on an unknown bus",
+			configure: func(cfg *VMConfig) { cfg.ExtraDisks = []DiskConfig{{Size: "1G", Bus: "ide"}} },
+			wantErr:   errInvalidExtraDisk,
+		},
+		{
+			name:      "custom CPU mode without model",
+			configure: func(cfg *VMConfig) { cfg.CPUMode = CPUModeCustom },
+			wantErr:   errInvalidCPUConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestDomainConfig()
+			tt.configure(&cfg)
+
+			domain, err := buildDomainXML(cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("buildDomainXML error = %v, want %v", err, tt.wantErr)
+			}
+			if domain != nil {
+				t.Errorf("expected no domain on error, got %+v", domain)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package vmm
+
+import (
+	"errors"
+	"fmt"
+
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+var (
+	errFakeStreamUnsupported = errors.New("streams are not supported by FakeConnection")
+	errFakeConnectionClosed  = errors.New("FakeConnection is already closed")
+)
+
+var (
+	_ Connection = (*FakeConnection)(nil)
+	_ Domain     = (*FakeDomain)(nil)
+	_ Network    = (*FakeNetwork)(nil)
+)
+
+// FakeConnection is an in-memory implementation of Connection for testing.
+// It keeps track of defined domains and networks and can inject errors.
+type FakeConnection struct {
+	Domains  map[string]*FakeDomain
+	Networks map[string]*FakeNetwork
+
+	// LeaseIPs maps a domain name to the IPv4 address reported while it is running
+	LeaseIPs map[string]string
+
+	// DomainCapabilities is the domain capabilities XML reported by the fake.
+	// When empty, GetDomainCapabilities fails as if the host did not support the query.
+	DomainCapabilities string
+
+	// Closed is set once Close is called
+	Closed bool
+
+	// errs holds injected errors per operation, consumed in order
+	errs map[string][]error
+}
+
+// NewFakeConnection creates an empty FakeConnection.
+func NewFakeConnection() *FakeConnection {
+	return &FakeConnection{
+		Domains:  make(map[string]*FakeDomain),
+		Networks: make(map[string]*FakeNetwork),
+		LeaseIPs: make(map[string]string),
+		errs:     make(map[string][]error),
+	}
+}
+
+// InjectErrors makes the next calls to op fail with errs, one error per call.
+// Valid ops are: DomainDefineXML, LookupDomainByName, NetworkDefineXML,
+// LookupNetworkByName, GetDomainCapabilities, DomainCreate, DomainDestroy,
+// DomainUndefine, DomainGetState, ListAllInterfaceAddresses, NetworkCreate,
+// NetworkDestroy and NetworkUndefine.
+func (f *FakeConnection) InjectErrors(op string, errs ...error) {
+	f.errs[op] = append(f.errs[op], errs...)
+}
+
+// nextErr pops the next injected error for op, if any.
+func (f *FakeConnection) nextErr(op string) error {
+	errs := f.errs[op]
+	if len(errs) == 0 {
+		return nil
+	}
+	f.errs[op] = errs[1:]
+	return errs[0]
+}
+
+// DomainDefineXML defines (or redefines) a domain from its XML.
+func (f *FakeConnection) DomainDefineXML(xml string) (Domain, error) {
+	if err := f.nextErr("DomainDefineXML"); err != nil {
+		return nil, err
+	}
+
+	var def libvirtxml.Domain
+	if err := def.Unmarshal(xml); err != nil {
+		return nil, libvirt.Error{Code: libvirt.ERR_XML_ERROR, Message: err.Error()}
+	}
+
+	if dom, ok := f.Domains[def.Name]; ok {
+		dom.XML = xml
+		return dom, nil
+	}
+
+	dom := &FakeDomain{Name: def.Name, XML: xml, State: libvirt.DOMAIN_SHUTOFF, conn: f}
+	f.Domains[def.Name] = dom
+	return dom, nil
+}
+
+// LookupDomainByName returns a defined domain or a libvirt "no domain" error.
+func (f *FakeConnection) LookupDomainByName(name string) (Domain, error) {
+	if err := f.nextErr("LookupDomainByName"); err != nil {
+		return nil, err
+	}
+
+	dom, ok := f.Domains[name]
+	if !ok {
+		return nil, noDomainError(name)
+	}
+	return dom, nil
+}
+
+// NetworkDefineXML defines a network from its XML.
+func (f *FakeConnection) NetworkDefineXML(xml string) (Network, error) {
+	if err := f.nextErr("NetworkDefineXML"); err != nil {
+		return nil, err
+	}
+
+	var def libvirtxml.Network
+	if err := def.Unmarshal(xml); err != nil {
+		return nil, libvirt.Error{Code: libvirt.ERR_XML_ERROR, Message: err.Error()}
+	}
+
+	if _, ok := f.Networks[def.Name]; ok {
+		return nil, libvirt.Error{
+			Code:    libvirt.ERR_NETWORK_EXIST,
+			Message: fmt.Sprintf("network '%s' already exists", def.Name),
+		}
+	}
+
+	net := &FakeNetwork{Name: def.Name, XML: xml, conn: f}
+	f.Networks[def.Name] = net
+	return net, nil
+}
+
+// LookupNetworkByName returns a defined network or a libvirt "no network" error.
+func (f *FakeConnection) LookupNetworkByName(name string) (Network, error) {
+	if err := f.nextErr("LookupNetworkByName"); err != nil {
+		return nil, err
+	}
+
+	net, ok := f.Networks[name]
+	if !ok {
+		return nil, libvirt.Error{
+			Code:    libvirt.ERR_NO_NETWORK,
+			Message: fmt.Sprintf("network '%s' not found", name),
+		}
+	}
+	return net, nil
+}
+
+// GetDomainCapabilities returns the configured domain capabilities XML.
+func (f *FakeConnection) GetDomainCapabilities(emulatorbin, arch, machine, virttype string) (string, error) {
+	if err := f.nextErr("GetDomainCapabilities"); err != nil {
+		return "", err
+	}
+	if f.DomainCapabilities == "" {
+		return "", libvirt.Error{Code: libvirt.ERR_NO_SUPPORT, Message: "domain capabilities are not available"}
+	}
+	return f.DomainCapabilities, nil
+}
+
+// NewStream is not supported by the fake.
+func (f *FakeConnection) NewStream(flags libvirt.StreamFlags) (*libvirt.Stream, error) {
+	return nil, errFakeStreamUnsupported
+}
+
+// Close marks the connection as closed. Closing it twice is an error, like
+// closing a freed libvirt connection.
+func (f *FakeConnection) Close() (int, error) {
+	if f.Closed {
+		return 0, errFakeConnectionClosed
+	}
+	f.Closed = true
+	return 0, nil
+}
+
+// FakeDomain is an in-memory domain managed by a FakeConnection.
+type FakeDomain struct {
+	Name  string
+	XML   string
+	State libvirt.DomainState
+
+	conn *FakeConnection
+}
+
+// Create starts the domain.
+func (d *FakeDomain) Create() error {
+	if err := d.check("DomainCreate"); err != nil {
+		return err
+	}
+	if d.State == libvirt.DOMAIN_RUNNING {
+		return libvirt.Error{Code: libvirt.ERR_OPERATION_INVALID, Message: "domain is already running"}
+	}
+	d.State = libvirt.DOMAIN_RUNNING
+	return nil
+}
+
+// Destroy stops the domain.
+func (d *FakeDomain) Destroy() error {
+	if err := d.check("DomainDestroy"); err != nil {
+		return err
+	}
+	if d.State != libvirt.DOMAIN_RUNNING {
+		return libvirt.Error{Code: libvirt.ERR_OPERATION_INVALID, Message: "domain is not running"}
+	}
+	d.State = libvirt.DOMAIN_SHUTOFF
+	return nil
+}
+
+// Undefine removes the domain from the connection.
+func (d *FakeDomain) Undefine() error {
+	if err := d.check("DomainUndefine"); err != nil {
+		return err
+	}
+	delete(d.conn.Domains, d.Name)
+	return nil
+}
+
+// Free is a no-op.
+func (d *FakeDomain) Free() error {
+	return nil
+}
+
+// GetName returns the domain name.
+func (d *FakeDomain) GetName() (string, error) {
+	return d.Name, nil
+}
+
+// GetState returns the domain state.
+func (d *FakeDomain) GetState() (libvirt.DomainState, int, error) {
+	if err := d.check("DomainGetState"); err != nil {
+		return 0, 0, err
+	}
+	return d.State, 0, nil
+}
+
+// GetXMLDesc returns the XML the domain was defined with.
+func (d *FakeDomain) GetXMLDesc(flags libvirt.DomainXMLFlags) (string, error) {
+	if err := d.check(""); err != nil {
+		return "", err
+	}
+	return d.XML, nil
+}
+
+// ListAllInterfaceAddresses reports the lease IP of the domain while it is running.
+func (d *FakeDomain) ListAllInterfaceAddresses(
+	src libvirt.DomainInterfaceAddressesSource,
+) ([]libvirt.DomainInterface, error) {
+	if err := d.check("ListAllInterfaceAddresses"); err != nil {
+		return nil, err
+	}
+
+	ip, ok := d.conn.LeaseIPs[d.Name]
+	if !ok || d.State != libvirt.DOMAIN_RUNNING {
+		return nil, nil
+	}
+
+	return []libvirt.DomainInterface{{
+		Name: "vnet0",
+		Addrs: []libvirt.DomainIPAddress{
+			{Type: libvirt.IP_ADDR_TYPE_IPV4, Addr: ip, Prefix: 24},
+		},
+	}}, nil
+}
+
+// OpenConsole is not supported by the fake.
+func (d *FakeDomain) OpenConsole(devname string, stream *libvirt.Stream, flags libvirt.DomainConsoleFlags) error {
+	return errFakeStreamUnsupported
+}
+
+// check returns the injected error for op, or a "no domain" error once undefined.
+func (d *FakeDomain) check(op string) error {
+	if op != "" {
+		if err := d.conn.nextErr(op); err != nil {
+			return err
+		}
+	}
+	if _, ok := d.conn.Domains[d.Name]; !ok {
+		return noDomainError(d.Name)
+	}
+	return nil
+}
+
+// FakeNetwork is an in-memory network managed by a FakeConnection.
+type FakeNetwork struct {
+	Name   string
+	XML    string
+	Active bool
+
+	conn *FakeConnection
+}
+
+// Create starts the network.
+func (n *FakeNetwork) Create() error {
+	if err := n.conn.nextErr("NetworkCreate"); err != nil {
+		return err
+	}
+	n.Active = true
+	return nil
+}
+
+// Destroy stops the network.
+func (n *FakeNetwork) Destroy() error {
+	if err := n.conn.nextErr("NetworkDestroy"); err != nil {
+		return err
+	}
+	n.Active = false
+	return nil
+}
+
+// Undefine removes the network from the connection.
+func (n *FakeNetwork) Undefine() error {
+	if err := n.conn.nextErr("NetworkUndefine"); err != nil {
+		return err
+	}
+	delete(n.conn.Networks, n.Name)
+	return nil
+}
+
+// Free is a no-op.
+func (n *FakeNetwork) Free() error {
+	return nil
+}
+
+// IsActive reports whether the network is started.
+func (n *FakeNetwork) IsActive() (bool, error) {
+	return n.Active, nil
+}
+
+func noDomainError(name string) error {
+	return libvirt.Error{
+		Code:    libvirt.ERR_NO_DOMAIN,
+		Message: fmt.Sprintf("Domain not found: no domain with matching name '%s'", name),
+	}
+}
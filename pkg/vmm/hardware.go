@@ -0,0 +1,213 @@
+package vmm
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+	"libvirt.org/go/libvirtxml"
+)
+
+var (
+	errInvalidCPUConfig           = errors.New("invalid CPU configuration")
+	errInvalidMemoryBacking       = errors.New("invalid memory backing configuration")
+	errUnsupportedByHost          = errors.New("configuration is not supported by the host")
+	errParseDomainCapabilities    = errors.New("failed to parse domain capabilities")
+	errVirtioFSNeedsSharedMemory  = errors.New("virtiofs mounts require shared memory backing")
+	errCPUModelRequiresCustomMode = errors.New("a CPU model can only be set with the custom CPU mode")
+)
+
+// CPU modes supported by VMConfig.CPUMode.
+const (
+	// CPUModeHostPassthrough exposes the host CPU as is. This is the default.
+	CPUModeHostPassthrough = "host-passthrough"
+	// CPUModeHostModel exposes a CPU model close to the host CPU, which is more portable.
+	CPUModeHostModel = "host-model"
+	// CPUModeCustom exposes the named model set in VMConfig.CPUModel.
+	CPUModeCustom = "custom"
+)
+
+// Memory source types supported by MemoryBackingConfig.SourceType.
+const (
+	MemorySourceMemfd     = "memfd" // default
+	MemorySourceFile      = "file"
+	MemorySourceAnonymous = "anonymous"
+)
+
+// Memory access modes supported by MemoryBackingConfig.AccessMode.
+const (
+	MemoryAccessShared  = "shared" // default
+	MemoryAccessPrivate = "private"
+)
+
+const (
+	domainArch    = "x86_64"
+	domainMachine = "pc-q35-8.0"
+	domainVirt    = "kvm"
+)
+
+// MemoryBackingConfig configures the memory backing of a VM.
+// The zero value uses memfd-backed shared memory, which virtiofs requires.
+type MemoryBackingConfig struct {
+	// Disabled omits the memory backing element, letting the hypervisor pick its defaults
+	Disabled bool
+	// SourceType is one of "memfd", "file" or "anonymous". Defaults to "memfd"
+	SourceType string
+	// AccessMode is one of "shared" or "private". Defaults to "shared"
+	AccessMode string
+}
+
+// domainCPU builds the CPU element of the domain from the VM configuration.
+func domainCPU(cfg VMConfig) (*libvirtxml.DomainCPU, error) {
+	mode := cfg.CPUMode
+	if mode == "" {
+		mode = CPUModeHostPassthrough
+	}
+
+	switch mode {
+	case CPUModeHostPassthrough, CPUModeHostModel:
+		if cfg.CPUModel != "" {
+			return nil, flaterrors.Join(
+				fmt.Errorf("cpuMode=%s cpuModel=%s", mode, cfg.CPUModel),
+				errCPUModelRequiresCustomMode,
+				errInvalidCPUConfig,
+			)
+		}
+		return &libvirtxml.DomainCPU{Mode: mode}, nil
+	case CPUModeCustom:
+		if cfg.CPUModel == "" {
+			return nil, flaterrors.Join(
+				errors.New("the custom CPU mode requires a CPU model"),
+				errInvalidCPUConfig,
+			)
+		}
+		return &libvirtxml.DomainCPU{
+			Mode:  mode,
+			Match: "exact",
+			Model: &libvirtxml.DomainCPUModel{Value: cfg.CPUModel},
+		}, nil
+	default:
+		return nil, flaterrors.Join(fmt.Errorf("cpuMode=%s", mode), errInvalidCPUConfig)
+	}
+}
+
+// domainMemoryBacking builds the memory backing element of the domain from the VM configuration.
+// It returns nil when memory backing is disabled.
+func domainMemoryBacking(cfg VMConfig) (*libvirtxml.DomainMemoryBacking, error) {
+	mb := cfg.MemoryBacking
+	if mb.Disabled {
+		if len(cfg.VirtioFS) > 0 {
+			return nil, flaterrors.Join(errVirtioFSNeedsSharedMemory, errInvalidMemoryBacking)
+		}
+		return nil, nil
+	}
+
+	source := mb.SourceType
+	if source == "" {
+		source = MemorySourceMemfd
+	}
+	if !slices.Contains([]string{MemorySourceMemfd, MemorySourceFile, MemorySourceAnonymous}, source) {
+		return nil, flaterrors.Join(fmt.Errorf("sourceType=%s", source), errInvalidMemoryBacking)
+	}
+
+	access := mb.AccessMode
+	if access == "" {
+		access = MemoryAccessShared
+	}
+	switch access {
+	case MemoryAccessShared:
+	case MemoryAccessPrivate:
+		if len(cfg.VirtioFS) > 0 {
+			return nil, flaterrors.Join(errVirtioFSNeedsSharedMemory, errInvalidMemoryBacking)
+		}
+	default:
+		return nil, flaterrors.Join(fmt.Errorf("accessMode=%s", access), errInvalidMemoryBacking)
+	}
+
+	return &libvirtxml.DomainMemoryBacking{
+		MemorySource: &libvirtxml.DomainMemorySource{Type: source},
+		MemoryAccess: &libvirtxml.DomainMemoryAccess{Mode: access},
+	}, nil
+}
+
+// checkDomainCapabilities verifies the CPU and memory backing against the host
+// domain capabilities. When the capabilities cannot be queried, the check is
+// skipped and libvirt reports any incompatibility when the domain is started.
+func (v *VMM) checkDomainCapabilities(
+	cpu *libvirtxml.DomainCPU,
+	memoryBacking *libvirtxml.DomainMemoryBacking,
+) error {
+	capsXML, err := v.conn.GetDomainCapabilities("", domainArch, domainMachine, domainVirt)
+	if err != nil {
+		slog.Warn("cannot query domain capabilities, skipping validation", "error", err.Error())
+		return nil
+	}
+
+	var caps libvirtxml.DomainCaps
+	if err := caps.Unmarshal(capsXML); err != nil {
+		return flaterrors.Join(err, errParseDomainCapabilities)
+	}
+
+	if err := checkCPUCapabilities(caps.CPU, cpu); err != nil {
+		return err
+	}
+	return checkMemoryBackingCapabilities(caps.MemoryBacking, memoryBacking)
+}
+
+func checkCPUCapabilities(caps *libvirtxml.DomainCapsCPU, cpu *libvirtxml.DomainCPU) error {
+	if caps == nil {
+		return nil
+	}
+
+	for _, mode := range caps.Modes {
+		if mode.Name != cpu.Mode {
+			continue
+		}
+		if mode.Supported != "yes" {
+			return flaterrors.Join(fmt.Errorf("cpuMode=%s", cpu.Mode), errUnsupportedByHost)
+		}
+		if cpu.Model == nil {
+			return nil
+		}
+		for _, model := range mode.Models {
+			if model.Name != cpu.Model.Value {
+				continue
+			}
+			if model.Usable == "no" {
+				return flaterrors.Join(
+					fmt.Errorf("cpuModel=%s is not usable on this host", model.Name),
+					errUnsupportedByHost,
+				)
+			}
+			return nil
+		}
+		return flaterrors.Join(fmt.Errorf("cpuModel=%s", cpu.Model.Value), errUnsupportedByHost)
+	}
+
+	// The mode is not listed by the host: let libvirt decide
+	return nil
+}
+
+func checkMemoryBackingCapabilities(
+	caps *libvirtxml.DomainCapsMemoryBacking,
+	memoryBacking *libvirtxml.DomainMemoryBacking,
+) error {
+	if caps == nil || memoryBacking == nil || memoryBacking.MemorySource == nil {
+		return nil
+	}
+
+	if caps.Supported != "yes" {
+		return flaterrors.Join(errors.New("memory backing"), errUnsupportedByHost)
+	}
+	for _, enum := range caps.Enums {
+		if enum.Name == "sourceType" && !slices.Contains(enum.Values, memoryBacking.MemorySource.Type) {
+			return flaterrors.Join(
+				fmt.Errorf("memorySourceType=%s", memoryBacking.MemorySource.Type),
+				errUnsupportedByHost,
+			)
+		}
+	}
+	return nil
+}
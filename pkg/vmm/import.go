@@ -0,0 +1,123 @@
+package vmm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+	"libvirt.org/go/libvirtxml"
+)
+
+var (
+	errParseDomainXML = errors.New("failed to parse domain XML")
+	errUnknownMemUnit = errors.New("unknown memory unit")
+	errImportDomain   = errors.New("failed to import domain")
+	errDomainNoMemory = errors.New("domain XML has no memory")
+	errDomainNoVCPUs  = errors.New("domain XML has no vcpu")
+)
+
+// memoryUnits are the size of the memory units of the libvirt domain XML, in bytes.
+var memoryUnits = map[string]uint64{
+	"b":     1,
+	"bytes": 1,
+	"KB":    1000,
+	"k":     1 << 10,
+	"KiB":   1 << 10,
+	"MB":    1000 * 1000,
+	"M":     1 << 20,
+	"MiB":   1 << 20,
+	"GB":    1000 * 1000 * 1000,
+	"G":     1 << 30,
+	"GiB":   1 << 30,
+	"TB":    1000 * 1000 * 1000 * 1000,
+	"T":     1 << 40,
+	"TiB":   1 << 40,
+}
+
+// ImportDomain returns the metadata of an existing domain that was not created
+// by CreateVM, e.g. a VM created by hand, read from its XML definition. The
+// IP address is only set if the domain is running and has a DHCP lease.
+//
+// The domain is not taken over: CreatedFiles is empty, so that its disks are
+// not mistaken for files of the VMM.
+func (v *VMM) ImportDomain(ctx context.Context, name string) (*VMMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	dom, err := v.GetDomainByName(execcontext.New(nil, nil), name)
+	if err != nil {
+		return nil, flaterrors.Join(err, fmt.Errorf("vmName=%s", name), errImportDomain)
+	}
+	if dom == nil {
+		return nil, flaterrors.Join(fmt.Errorf("vmName=%s", name), errVMNotFound)
+	}
+
+	xml, err := dom.GetXMLDesc(0)
+	if err != nil {
+		return nil, flaterrors.Join(err, fmt.Errorf("vmName=%s", name), errGetDomainXML)
+	}
+	metadata, err := metadataFromDomainXML(xml)
+	if err != nil {
+		return nil, flaterrors.Join(err, fmt.Errorf("vmName=%s", name), errImportDomain)
+	}
+
+	if ip, ok := domainIPv4(dom); ok {
+		metadata.IP = ip
+	} else {
+		slog.Warn("imported domain has no IP address, is it running?", "vmName", name)
+	}
+
+	return metadata, nil
+}
+
+// metadataFromDomainXML returns the metadata described by a domain XML.
+func metadataFromDomainXML(xml string) (*VMMetadata, error) {
+	var domain libvirtxml.Domain
+	if err := domain.Unmarshal(xml); err != nil {
+		return nil, flaterrors.Join(err, errParseDomainXML)
+	}
+
+	if domain.Memory == nil {
+		return nil, errDomainNoMemory
+	}
+	memoryMB, err := memoryMiB(domain.Memory.Value, domain.Memory.Unit)
+	if err != nil {
+		return nil, err
+	}
+	if domain.VCPU == nil {
+		return nil, errDomainNoVCPUs
+	}
+
+	metadata := &VMMetadata{
+		Name:      domain.Name,
+		DomainXML: xml,
+		MemoryMB:  memoryMB,
+		VCPUs:     domain.VCPU.Value,
+	}
+	if domain.Devices != nil {
+		for _, console := range domain.Devices.Consoles {
+			if console.Log != nil && console.Log.File != "" {
+				metadata.ConsoleLogPath = console.Log.File
+				break
+			}
+		}
+	}
+
+	return metadata, nil
+}
+
+// memoryMiB converts a memory size of the domain XML to MiB. An empty unit is KiB.
+func memoryMiB(value uint, unit string) (uint, error) {
+	if unit == "" {
+		unit = "KiB"
+	}
+	size, ok := memoryUnits[unit]
+	if !ok {
+		return 0, flaterrors.Join(fmt.Errorf("unit=%s", unit), errUnknownMemUnit)
+	}
+	return uint(uint64(value) * size / (1 << 20)), nil
+}
@@ -0,0 +1,55 @@
+package vmm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMemoryMiB(t *testing.T) {
+	tests := []struct {
+		value uint
+		unit  string
+		want  uint
+	}{
+		{value: 2097152, unit: "", want: 2048},
+		{value: 2097152, unit: "KiB", want: 2048},
+		{value: 512, unit: "MiB", want: 512},
+		{value: 2, unit: "G", want: 2048},
+		{value: 1073741824, unit: "bytes", want: 1024},
+		{value: 1000, unit: "MB", want: 953},
+	}
+
+	for _, tt := range tests {
+		got, err := memoryMiB(tt.value, tt.unit)
+		if err != nil {
+			t.Fatalf("memoryMiB(%d, %q) failed: %v", tt.value, tt.unit, err)
+		}
+		if got != tt.want {
+			t.Errorf("memoryMiB(%d, %q) = %d, want %d", tt.value, tt.unit, got, tt.want)
+		}
+	}
+
+	if _, err := memoryMiB(1, "pages"); !errors.Is(err, errUnknownMemUnit) {
+		t.Errorf("memoryMiB with an unknown unit error = %v, want %v", err, errUnknownMemUnit)
+	}
+}
+
+func TestMetadataFromDomainXML_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		xml     string
+		wantErr error
+	}{
+		{name: "not XML", xml: "dev-vm", wantErr: errParseDomainXML},
+		{name: "no memory", xml: `<domain type="kvm"><name>dev-vm</name><vcpu>1</vcpu></domain>`, wantErr: errDomainNoMemory},
+		{name: "no vcpu", xml: `<domain type="kvm"><name>dev-vm</name><memory>1048576</memory></domain>`, wantErr: errDomainNoVCPUs},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := metadataFromDomainXML(tt.xml); !errors.Is(err, tt.wantErr) {
+				t.Errorf("metadataFromDomainXML error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package vmm
+
+import (
+	"libvirt.org/go/libvirt"
+)
+
+// Connection is the subset of the libvirt connection API used by VMM.
+// It allows the VM lifecycle logic to be tested without a running libvirt daemon.
+type Connection interface {
+	DomainDefineXML(xml string) (Domain, error)
+	LookupDomainByName(name string) (Domain, error)
+	NetworkDefineXML(xml string) (Network, error)
+	LookupNetworkByName(name string) (Network, error)
+	GetDomainCapabilities(emulatorbin, arch, machine, virttype string) (string, error)
+	NewStream(flags libvirt.StreamFlags) (*libvirt.Stream, error)
+	Close() (int, error)
+}
+
+// Domain is the subset of the libvirt domain API used by VMM.
+// *libvirt.Domain implements it.
+type Domain interface {
+	Create() error
+	Destroy() error
+	Undefine() error
+	Free() error
+	GetName() (string, error)
+	GetState() (libvirt.DomainState, int, error)
+	GetXMLDesc(flags libvirt.DomainXMLFlags) (string, error)
+	ListAllInterfaceAddresses(src libvirt.DomainInterfaceAddressesSource) ([]libvirt.DomainInterface, error)
+	OpenConsole(devname string, stream *libvirt.Stream, flags libvirt.DomainConsoleFlags) error
+}
+
+// Network is the subset of the libvirt network API used by VMM.
+// *libvirt.Network implements it.
+type Network interface {
+	Create() error
+	Destroy() error
+	Undefine() error
+	Free() error
+	IsActive() (bool, error)
+}
+
+var (
+	_ Connection = (*libvirtConnection)(nil)
+	_ Domain     = (*libvirt.Domain)(nil)
+	_ Network    = (*libvirt.Network)(nil)
+)
+
+// libvirtConnection adapts *libvirt.Connect to the Connection interface.
+type libvirtConnection struct {
+	conn *libvirt.Connect
+}
+
+// newLibvirtConnection connects to the given libvirt URI.
+func newLibvirtConnection(uri string) (Connection, error) {
+	conn, err := libvirt.NewConnect(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &libvirtConnection{conn: conn}, nil
+}
+
+func (c *libvirtConnection) DomainDefineXML(xml string) (Domain, error) {
+	dom, err := c.conn.DomainDefineXML(xml)
+	if err != nil {
+		// Return an untyped nil so callers can compare the interface with nil
+		return nil, err
+	}
+	return dom, nil
+}
+
+func (c *libvirtConnection) LookupDomainByName(name string) (Domain, error) {
+	dom, err := c.conn.LookupDomainByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return dom, nil
+}
+
+func (c *libvirtConnection) NetworkDefineXML(xml string) (Network, error) {
+	net, err := c.conn.NetworkDefineXML(xml)
+	if err != nil {
+		return nil, err
+	}
+	return net, nil
+}
+
+func (c *libvirtConnection) LookupNetworkByName(name string) (Network, error) {
+	net, err := c.conn.LookupNetworkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return net, nil
+}
+
+func (c *libvirtConnection) GetDomainCapabilities(emulatorbin, arch, machine, virttype string) (string, error) {
+	return c.conn.GetDomainCapabilities(emulatorbin, arch, machine, virttype, 0)
+}
+
+func (c *libvirtConnection) NewStream(flags libvirt.StreamFlags) (*libvirt.Stream, error) {
+	return c.conn.NewStream(flags)
+}
+
+func (c *libvirtConnection) Close() (int, error) {
+	return c.conn.Close()
+}
@@ -0,0 +1,90 @@
+package vmm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var errWaitCreationSlot = errors.New("cancelled while waiting for a VM creation slot")
+
+// DefaultMaxConcurrentCreations is the number of VMs created at the same time by
+// default, across all the VMMs of the process.
+const DefaultMaxConcurrentCreations = 2
+
+// CreationLimiter bounds the number of VM creations running at the same time.
+// A creation holds its slot from the disk creation until the VM has an IP address,
+// which is when qemu-img and the booting guest put the most pressure on the host.
+type CreationLimiter struct {
+	slots chan struct{}
+}
+
+// NewCreationLimiter creates a CreationLimiter allowing max concurrent creations.
+// Values lower than 1 are treated as 1.
+func NewCreationLimiter(max int) *CreationLimiter {
+	if max < 1 {
+		max = 1
+	}
+	return &CreationLimiter{slots: make(chan struct{}, max)}
+}
+
+// Max returns the number of concurrent creations allowed.
+func (l *CreationLimiter) Max() int {
+	return cap(l.slots)
+}
+
+// acquire blocks until a slot is free or ctx is done.
+func (l *CreationLimiter) acquire(ctx context.Context, vmName string) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	slog.Info("waiting for a free VM creation slot", "vmName", vmName, "maxConcurrentCreations", l.Max())
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return flaterrors.Join(ctx.Err(), fmt.Errorf("vmName=%s", vmName), errWaitCreationSlot)
+	}
+}
+
+// release frees the slot taken by acquire.
+func (l *CreationLimiter) release() {
+	<-l.slots
+}
+
+// defaultCreationLimiter is shared by the VMMs created without WithCreationLimiter.
+var defaultCreationLimiter atomic.Pointer[CreationLimiter]
+
+func init() {
+	defaultCreationLimiter.Store(NewCreationLimiter(DefaultMaxConcurrentCreations))
+}
+
+// SetMaxConcurrentCreations sets the number of VMs the VMMs of the process may
+// create at the same time, unless they were given their own CreationLimiter.
+// Creations already running keep their slot of the previous limit.
+func SetMaxConcurrentCreations(max int) {
+	defaultCreationLimiter.Store(NewCreationLimiter(max))
+}
+
+// WithCreationLimiter returns an option that makes the VMM share the given
+// CreationLimiter instead of the default one of the process.
+func WithCreationLimiter(l *CreationLimiter) VMMOption {
+	return func(v *VMM) {
+		v.limiter = l
+	}
+}
+
+// creationLimiter returns the CreationLimiter of the VMM.
+func (v *VMM) creationLimiter() *CreationLimiter {
+	if v.limiter != nil {
+		return v.limiter
+	}
+	return defaultCreationLimiter.Load()
+}
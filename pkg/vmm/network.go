@@ -0,0 +1,164 @@
+package vmm
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+	"libvirt.org/go/libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+var (
+	errMarshalNetworkXML  = errors.New("failed to marshal network XML")
+	errDefineNetwork      = errors.New("failed to define network")
+	errCreateNetwork      = errors.New("failed to create network")
+	errGetNetworkState    = errors.New("failed to get network state")
+	errDestroyNetwork     = errors.New("failed to destroy network")
+	errUndefineNetwork    = errors.New("failed to undefine network")
+	errNetworkNameMissing = errors.New("network name is required")
+)
+
+// NetworkConfig describes a NAT network managed by the VMM.
+// Each network gets its own bridge and /24 subnet so that VMs attached to
+// different networks cannot collide on IP addresses.
+type NetworkConfig struct {
+	Name       string // Libvirt network name
+	BridgeName string // Host bridge interface name (max 15 chars)
+	Gateway    string // Host-side gateway IP (e.g., "192.168.150.1")
+	Netmask    string // Subnet mask (e.g., "255.255.255.0")
+	DHCPStart  string // First address leased to guests
+	DHCPEnd    string // Last address leased to guests
+}
+
+// NewNetworkConfig returns a NetworkConfig for the 192.168.<subnetOctet>.0/24 subnet.
+func NewNetworkConfig(name string, subnetOctet uint8) NetworkConfig {
+	prefix := fmt.Sprintf("192.168.%d", subnetOctet)
+	return NetworkConfig{
+		Name:       name,
+		BridgeName: fmt.Sprintf("virbr-e2e-%d", subnetOctet),
+		Gateway:    prefix + ".1",
+		Netmask:    "255.255.255.0",
+		DHCPStart:  prefix + ".2",
+		DHCPEnd:    prefix + ".254",
+	}
+}
+
+// IsAddressConflict reports whether err, returned by CreateNetwork, is caused by
+// the subnet or the bridge of the network being used by another interface, e.g.
+// another network. Creating the network on another subnet may then succeed.
+func IsAddressConflict(err error) bool {
+	var lvErr libvirt.Error
+	if !errors.As(err, &lvErr) || lvErr.Code != libvirt.ERR_INTERNAL_ERROR {
+		return false
+	}
+	// libvirt reports "Network is already in use by interface <name>" and
+	// "bridge name '<name>' already in use."
+	return strings.Contains(lvErr.Message, "already in use")
+}
+
+// CreateNetwork defines and starts a NAT network in libvirt.
+func (v *VMM) CreateNetwork(ctx execcontext.Context, cfg NetworkConfig) error {
+	if cfg.Name == "" {
+		return errNetworkNameMissing
+	}
+	if v.conn == nil {
+		return errLibvirtNotInitialized
+	}
+
+	network := &libvirtxml.Network{
+		Name: cfg.Name,
+		Forward: &libvirtxml.NetworkForward{
+			Mode: "nat",
+		},
+		Bridge: &libvirtxml.NetworkBridge{
+			Name:  cfg.BridgeName,
+			STP:   "on",
+			Delay: "0",
+		},
+		IPs: []libvirtxml.NetworkIP{
+			{
+				Address: cfg.Gateway,
+				Netmask: cfg.Netmask,
+				DHCP: &libvirtxml.NetworkDHCP{
+					Ranges: []libvirtxml.NetworkDHCPRange{
+						{Start: cfg.DHCPStart, End: cfg.DHCPEnd},
+					},
+				},
+			},
+		},
+	}
+
+	netXML, err := network.Marshal()
+	if err != nil {
+		return flaterrors.Join(err, errMarshalNetworkXML)
+	}
+
+	net, err := retryLibvirt("NetworkDefineXML", func() (Network, error) {
+		return v.conn.NetworkDefineXML(netXML)
+	})
+	if err != nil {
+		return flaterrors.Join(err, fmt.Errorf("networkName=%s", cfg.Name), errDefineNetwork)
+	}
+	defer net.Free()
+
+	if err := retryLibvirtErr("NetworkCreate", net.Create); err != nil {
+		// Do not leave a defined-but-inactive network behind
+		_ = net.Undefine()
+		return flaterrors.Join(err, fmt.Errorf("networkName=%s", cfg.Name), errCreateNetwork)
+	}
+
+	slog.Info("successfully created network", "networkName", cfg.Name, "gateway", cfg.Gateway)
+	return nil
+}
+
+// NetworkExists checks if a network with the given name is defined in libvirt.
+func (v *VMM) NetworkExists(ctx execcontext.Context, name string) (bool, error) {
+	if v.conn == nil {
+		return false, errLibvirtNotInitialized
+	}
+
+	net, err := v.conn.LookupNetworkByName(name)
+	if err != nil {
+		// Network not found in libvirt
+		return false, nil
+	}
+	net.Free()
+
+	return true, nil
+}
+
+// DestroyNetwork stops and undefines a network.
+// Returns nil if the network does not exist (allows idempotent cleanup).
+func (v *VMM) DestroyNetwork(ctx execcontext.Context, name string) error {
+	if v.conn == nil {
+		return errLibvirtNotInitialized
+	}
+
+	net, err := v.conn.LookupNetworkByName(name)
+	if err != nil {
+		slog.Info("network not found in libvirt, skipping destroy", "networkName", name)
+		return nil
+	}
+	defer net.Free()
+
+	active, err := net.IsActive()
+	if err != nil {
+		return flaterrors.Join(err, fmt.Errorf("networkName=%s", name), errGetNetworkState)
+	}
+
+	if active {
+		if err := net.Destroy(); err != nil {
+			return flaterrors.Join(err, fmt.Errorf("networkName=%s", name), errDestroyNetwork)
+		}
+	}
+
+	if err := net.Undefine(); err != nil {
+		return flaterrors.Join(err, fmt.Errorf("networkName=%s", name), errUndefineNetwork)
+	}
+
+	return nil
+}
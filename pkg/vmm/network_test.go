@@ -0,0 +1,105 @@
+package vmm_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+	"libvirt.org/go/libvirt"
+)
+
+// TestNewNetworkConfig verifies the subnet and bridge are derived from the subnet octet
+func TestNewNetworkConfig(t *testing.T) {
+	cfg := vmm.NewNetworkConfig("e2e-20231025-abc123", 150)
+
+	if cfg.Name != "e2e-20231025-abc123" {
+		t.Errorf("expected Name e2e-20231025-abc123, got %s", cfg.Name)
+	}
+	if cfg.Gateway != "192.168.150.1" {
+		t.Errorf("expected Gateway 192.168.150.1, got %s", cfg.Gateway)
+	}
+	if cfg.DHCPStart != "192.168.150.2" || cfg.DHCPEnd != "192.168.150.254" {
+		t.Errorf("unexpected DHCP range %s-%s", cfg.DHCPStart, cfg.DHCPEnd)
+	}
+	if len(cfg.BridgeName) > 15 {
+		t.Errorf("bridge name %q exceeds the 15 character interface name limit", cfg.BridgeName)
+	}
+}
+
+// TestIsAddressConflict verifies only subnet and bridge conflicts are reported
+func TestIsAddressConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"subnet in use", libvirt.Error{Code: libvirt.ERR_INTERNAL_ERROR, Message: "internal error: Network is already in use by interface virbr0"}, true},
+		{"bridge in use", libvirt.Error{Code: libvirt.ERR_INTERNAL_ERROR, Message: "internal error: bridge name 'virbr-e2e-150' already in use."}, true},
+		{"wrapped", flaterrors.Join(libvirt.Error{Code: libvirt.ERR_INTERNAL_ERROR, Message: "Network is already in use by interface eth0"}, errors.New("failed to create network")), true},
+		{"network exists", libvirt.Error{Code: libvirt.ERR_NETWORK_EXIST, Message: "network 'e2e' already exists"}, false},
+		{"permission denied", libvirt.Error{Code: libvirt.ERR_AUTH_FAILED, Message: "authentication failed"}, false},
+		{"not a libvirt error", errors.New("already in use"), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vmm.IsAddressConflict(tt.err); got != tt.want {
+				t.Errorf("IsAddressConflict(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNetworkLifecycle verifies a dedicated network can be created and removed
+func TestNetworkLifecycle(t *testing.T) {
+	// Skip if libvirt not available
+	if os.Getenv("CI") == "true" && os.Getenv("LIBVIRT_TEST") != "true" {
+		t.Skip("Skipping libvirt test in CI without LIBVIRT_TEST=true")
+	}
+
+	vmmInstance, err := vmm.NewVMM()
+	if err != nil {
+		t.Fatalf("Failed to create VMM: %v", err)
+	}
+	defer vmmInstance.Close()
+
+	execCtx := execcontext.New(make(map[string]string), []string{})
+	netName := fmt.Sprintf("test-net-%d", time.Now().UnixNano())
+	cfg := vmm.NewNetworkConfig(netName, 251)
+
+	if err := vmmInstance.CreateNetwork(execCtx, cfg); err != nil {
+		t.Fatalf("Failed to create network: %v", err)
+	}
+	defer vmmInstance.DestroyNetwork(execCtx, netName)
+
+	exists, err := vmmInstance.NetworkExists(execCtx, netName)
+	if err != nil {
+		t.Fatalf("NetworkExists failed: %v", err)
+	}
+	if !exists {
+		t.Fatal("NetworkExists should return true after CreateNetwork")
+	}
+
+	if err := vmmInstance.DestroyNetwork(execCtx, netName); err != nil {
+		t.Fatalf("Failed to destroy network: %v", err)
+	}
+
+	exists, err = vmmInstance.NetworkExists(execCtx, netName)
+	if err != nil {
+		t.Fatalf("NetworkExists failed: %v", err)
+	}
+	if exists {
+		t.Error("NetworkExists should return false after DestroyNetwork")
+	}
+
+	// Destroying a missing network is a no-op
+	if err := vmmInstance.DestroyNetwork(execCtx, netName); err != nil {
+		t.Errorf("DestroyNetwork should be idempotent: %v", err)
+	}
+}
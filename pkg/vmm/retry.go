@@ -0,0 +1,90 @@
+package vmm
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/waitutil"
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+	"libvirt.org/go/libvirt"
+)
+
+var errRetriesExhausted = errors.New("libvirt call kept failing with transient errors")
+
+// retryPolicy controls how libvirt calls failing with transient errors are retried.
+type retryPolicy struct {
+	attempts int
+	backoff  waitutil.Backoff
+	sleep    func(time.Duration)
+}
+
+// defaultRetryPolicy retries up to 5 times over roughly 7.5 seconds.
+var defaultRetryPolicy = retryPolicy{
+	attempts: 5,
+	backoff:  waitutil.Backoff{Initial: 500 * time.Millisecond, Max: 4 * time.Second, Factor: 2},
+	sleep:    time.Sleep,
+}
+
+// isTransientLibvirtError reports whether err is a libvirt error worth retrying,
+// e.g. the daemon being busy or the connection being reset. Permanent errors
+// such as "already exists" or invalid XML are not retried, and neither is a
+// missing connection: the retry would reuse the same dead connection.
+func isTransientLibvirtError(err error) bool {
+	var lvErr libvirt.Error
+	if !errors.As(err, &lvErr) {
+		return false
+	}
+
+	switch lvErr.Code {
+	case libvirt.ERR_RPC,
+		libvirt.ERR_OPERATION_TIMEOUT,
+		libvirt.ERR_AGENT_UNRESPONSIVE,
+		libvirt.ERR_RESOURCE_BUSY:
+		return true
+	case libvirt.ERR_SYSTEM_ERROR:
+		// Only system errors raised by the RPC layer (e.g. connection reset by peer)
+		return lvErr.Domain == libvirt.FROM_RPC
+	default:
+		return false
+	}
+}
+
+// retryLibvirt calls fn until it succeeds, fails with a permanent error, or the
+// attempts of the default policy are exhausted.
+func retryLibvirt[T any](op string, fn func() (T, error)) (T, error) {
+	return retryWithPolicy(defaultRetryPolicy, op, fn)
+}
+
+// retryLibvirtErr is retryLibvirt for calls that only return an error.
+func retryLibvirtErr(op string, fn func() error) error {
+	_, err := retryLibvirt(op, func() (struct{}, error) {
+		return struct{}{}, fn()
+	})
+	return err
+}
+
+func retryWithPolicy[T any](p retryPolicy, op string, fn func() (T, error)) (T, error) {
+	interval := p.backoff.Initial
+
+	for attempt := 1; ; attempt++ {
+		out, err := fn()
+		if err == nil || !isTransientLibvirtError(err) {
+			return out, err
+		}
+
+		if attempt >= p.attempts {
+			return out, flaterrors.Join(
+				err,
+				fmt.Errorf("op=%s attempts=%d", op, attempt),
+				errRetriesExhausted,
+			)
+		}
+
+		slog.Warn("transient libvirt error, retrying",
+			"op", op, "attempt", attempt, "retryIn", interval, "error", err.Error())
+		p.sleep(interval)
+		interval = p.backoff.Next(interval)
+	}
+}
@@ -0,0 +1,136 @@
+package vmm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/waitutil"
+	"libvirt.org/go/libvirt"
+)
+
+// fakeLibvirtClient fails with the queued errors before succeeding.
+type fakeLibvirtClient struct {
+	errs  []error
+	calls int
+}
+
+func (f *fakeLibvirtClient) DomainDefineXML(xml string) (string, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return "", err
+	}
+	return "defined:" + xml, nil
+}
+
+func testRetryPolicy(slept *[]time.Duration) retryPolicy {
+	return retryPolicy{
+		attempts: 4,
+		backoff:  waitutil.Backoff{Initial: 10 * time.Millisecond, Max: 30 * time.Millisecond, Factor: 2},
+		sleep:    func(d time.Duration) { *slept = append(*slept, d) },
+	}
+}
+
+func TestRetryWithPolicy_TransientThenSuccess(t *testing.T) {
+	var slept []time.Duration
+	client := &fakeLibvirtClient{errs: []error{
+		libvirt.Error{Code: libvirt.ERR_RESOURCE_BUSY, Message: "resource busy"},
+		libvirt.Error{Code: libvirt.ERR_SYSTEM_ERROR, Domain: libvirt.FROM_RPC, Message: "Connection reset by peer"},
+	}}
+
+	out, err := retryWithPolicy(testRetryPolicy(&slept), "DomainDefineXML", func() (string, error) {
+		return client.DomainDefineXML("<domain/>")
+	})
+	if err != nil {
+		t.Fatalf("expected success after transient errors, got %v", err)
+	}
+	if out != "defined:<domain/>" {
+		t.Errorf("unexpected result %q", out)
+	}
+	if client.calls != 3 {
+		t.Errorf("expected 3 calls, got %d", client.calls)
+	}
+
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}
+	if len(slept) != len(want) || slept[0] != want[0] || slept[1] != want[1] {
+		t.Errorf("expected backoff %v, got %v", want, slept)
+	}
+}
+
+func TestRetryWithPolicy_PermanentErrorFailsFast(t *testing.T) {
+	var slept []time.Duration
+	permanent := libvirt.Error{Code: libvirt.ERR_DOM_EXIST, Message: "domain already exists"}
+	client := &fakeLibvirtClient{errs: []error{permanent}}
+
+	_, err := retryWithPolicy(testRetryPolicy(&slept), "DomainDefineXML", func() (string, error) {
+		return client.DomainDefineXML("<domain/>")
+	})
+
+	var lvErr libvirt.Error
+	if !errors.As(err, &lvErr) || lvErr.Code != libvirt.ERR_DOM_EXIST {
+		t.Fatalf("expected the permanent error to be returned, got %v", err)
+	}
+	if client.calls != 1 {
+		t.Errorf("expected no retry on permanent error, got %d calls", client.calls)
+	}
+	if len(slept) != 0 {
+		t.Errorf("expected no backoff on permanent error, got %v", slept)
+	}
+}
+
+func TestRetryWithPolicy_ExhaustsAttempts(t *testing.T) {
+	var slept []time.Duration
+	busy := libvirt.Error{Code: libvirt.ERR_RPC, Message: "rpc failure"}
+	client := &fakeLibvirtClient{errs: []error{busy, busy, busy, busy, busy}}
+
+	_, err := retryWithPolicy(testRetryPolicy(&slept), "DomainDefineXML", func() (string, error) {
+		return client.DomainDefineXML("<domain/>")
+	})
+	if !errors.Is(err, errRetriesExhausted) {
+		t.Fatalf("expected errRetriesExhausted, got %v", err)
+	}
+	if client.calls != 4 {
+		t.Errorf("expected 4 attempts, got %d", client.calls)
+	}
+
+	// Capped at 30ms
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond}
+	if len(slept) != len(want) {
+		t.Fatalf("expected backoff %v, got %v", want, slept)
+	}
+	for i := range want {
+		if slept[i] != want[i] {
+			t.Errorf("backoff[%d] = %s, want %s", i, slept[i], want[i])
+		}
+	}
+}
+
+func TestIsTransientLibvirtError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rpc", libvirt.Error{Code: libvirt.ERR_RPC}, true},
+		{"no connect", libvirt.Error{Code: libvirt.ERR_NO_CONNECT}, false},
+		{"timeout", libvirt.Error{Code: libvirt.ERR_OPERATION_TIMEOUT}, true},
+		{"busy", libvirt.Error{Code: libvirt.ERR_RESOURCE_BUSY}, true},
+		{"connection reset", libvirt.Error{Code: libvirt.ERR_SYSTEM_ERROR, Domain: libvirt.FROM_RPC}, true},
+		{"local system error", libvirt.Error{Code: libvirt.ERR_SYSTEM_ERROR}, false},
+		{"domain exists", libvirt.Error{Code: libvirt.ERR_DOM_EXIST}, false},
+		{"network exists", libvirt.Error{Code: libvirt.ERR_NETWORK_EXIST}, false},
+		{"invalid operation", libvirt.Error{Code: libvirt.ERR_OPERATION_INVALID}, false},
+		{"not a libvirt error", errors.New("boom"), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientLibvirtError(tt.err); got != tt.want {
+				t.Errorf("isTransientLibvirtError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -16,9 +16,9 @@ import (
 
 	"github.com/alexandremahdhaoui/edge-cd/pkg/cloudinit"
 	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/waitutil"
 	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
 	"libvirt.org/go/libvirt"
-	"libvirt.org/go/libvirtxml"
 )
 
 var (
@@ -41,6 +41,7 @@ var (
 	errCreateCloudInitDir      = errors.New("failed to create cloud-init config directory")
 	errWriteUserData           = errors.New("failed to write user-data file")
 	errWriteMetaData           = errors.New("failed to write meta-data file")
+	errPersistCloudInit        = errors.New("failed to save rendered cloud-init files")
 	errCreateCloudInitISO      = errors.New("failed to create cloud-init ISO with xorriso")
 	errGetDomainName           = errors.New("failed to get domain name")
 	errCreateStream            = errors.New("failed to create new stream")
@@ -56,9 +57,11 @@ const (
 
 // VMM manages libvirt virtual machines.
 type VMM struct {
-	conn    *libvirt.Connect
-	domains map[string]*libvirt.Domain
-	baseDir string // Optional base directory for VM temporary files
+	conn    Connection
+	domains map[string]Domain
+	baseDir string           // Optional base directory for VM temporary files
+	runCmd  CommandRunner    // Runs host commands (qemu-img, xorriso)
+	limiter *CreationLimiter // Bounds concurrent CreateVM calls, nil for the process default
 	// virtiofsds stores the virtiofsd processes started for each VM,
 	// along with their cancellation functions.
 	virtiofsds map[string][]struct {
@@ -70,6 +73,30 @@ type VMM struct {
 // VMMOption is a function that modifies VMM configuration
 type VMMOption func(*VMM)
 
+// CommandRunner runs a host command and returns its combined output.
+type CommandRunner func(name string, args ...string) ([]byte, error)
+
+// runCombined is the default CommandRunner.
+func runCombined(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// WithConnection returns an option that makes the VMM use the given connection
+// instead of connecting to the local libvirt daemon (e.g., a FakeConnection in tests).
+func WithConnection(conn Connection) VMMOption {
+	return func(v *VMM) {
+		v.conn = conn
+	}
+}
+
+// WithCommandRunner returns an option that sets how host commands such as
+// qemu-img and xorriso are run.
+func WithCommandRunner(runCmd CommandRunner) VMMOption {
+	return func(v *VMM) {
+		v.runCmd = runCmd
+	}
+}
+
 // WithBaseDir returns an option that sets the base directory for VM temporary files
 func WithBaseDir(baseDir string) VMMOption {
 	return func(v *VMM) {
@@ -80,14 +107,10 @@ func WithBaseDir(baseDir string) VMMOption {
 // NewVMM creates a new VMM instance and connects to libvirt.
 // Optional options can be passed to configure the VMM.
 func NewVMM(opts ...VMMOption) (*VMM, error) {
-	conn, err := libvirt.NewConnect("qemu:///system")
-	if err != nil {
-		return nil, flaterrors.Join(err, errConnectLibvirt)
-	}
 	vmm := &VMM{
-		conn:    conn,
-		domains: make(map[string]*libvirt.Domain),
+		domains: make(map[string]Domain),
 		baseDir: "",
+		runCmd:  runCombined,
 		virtiofsds: make(map[string][]struct {
 			Cmd    *exec.Cmd
 			Cancel context.CancelFunc
@@ -99,15 +122,26 @@ func NewVMM(opts ...VMMOption) (*VMM, error) {
 		opt(vmm)
 	}
 
+	if vmm.conn == nil {
+		conn, err := newLibvirtConnection("qemu:///system")
+		if err != nil {
+			return nil, flaterrors.Join(err, errConnectLibvirt)
+		}
+		vmm.conn = conn
+	}
+
 	return vmm, nil
 }
 
-// Close closes the libvirt connection.
+// Close closes the libvirt connection. It is safe to call on a nil VMM and
+// more than once, e.g. from a defer and an error path: only the first call
+// closes the connection.
 func (v *VMM) Close() error {
-	if v.conn == nil {
+	if v == nil || v.conn == nil {
 		return nil
 	}
 	_, err := v.conn.Close()
+	v.conn = nil
 	return err
 }
 
@@ -121,6 +155,12 @@ type VMConfig struct {
 	UserData       cloudinit.UserData
 	VirtioFS       []VirtioFSConfig // New field for virtiofs mounts
 	TempDir        string           // Optional: directory for temporary VM files (disk overlay, cloud-init ISO). Defaults to os.TempDir() if empty
+	CloudInitDir   string           // Optional: directory where the rendered user-data and meta-data are saved for debugging. Not saved if empty
+	CPUMode        string           // Optional: "host-passthrough" (default), "host-model" or "custom"
+	CPUModel       string           // Optional: named CPU model (e.g. "Skylake-Client"), requires the "custom" CPU mode
+	MemoryBacking  MemoryBackingConfig // Optional: defaults to memfd-backed shared memory, as required by virtiofs
+	ExtraDisks     []DiskConfig        // Optional: empty qcow2 data disks attached after the root disk
+	ConsoleLog     bool                // Optional: have libvirt log the serial console to <TempDir>/<Name>-console.log
 }
 
 type VirtioFSConfig struct {
@@ -128,6 +168,21 @@ type VirtioFSConfig struct {
 	MountPoint string
 }
 
+// Disk buses supported by DiskConfig.Bus.
+const (
+	DiskBusVirtio = "virtio" // default, attached as /dev/vdb, /dev/vdc...
+	DiskBusSATA   = "sata"   // attached as /dev/sda, /dev/sdc... (sdb is the cloud-init cdrom)
+)
+
+// DiskConfig is an additional empty qcow2 disk attached to a VM. It is created
+// in the VM temp directory and deleted with the VM.
+type DiskConfig struct {
+	// Size is the virtual size of the disk, in the qemu-img format, e.g. "10G"
+	Size string
+	// Bus is one of "virtio" or "sata". Defaults to "virtio"
+	Bus string
+}
+
 func NewVMConfig(name, imagePath string, userData cloudinit.UserData) VMConfig {
 	return VMConfig{
 		Name:           name,
@@ -143,6 +198,19 @@ func NewVMConfig(name, imagePath string, userData cloudinit.UserData) VMConfig {
 // CreateVM creates and starts a new virtual machine.
 // Returns metadata about the created VM including its IP address and domain XML.
 func (v *VMM) CreateVM(cfg VMConfig) (*VMMetadata, error) {
+	return v.CreateVMContext(context.Background(), cfg)
+}
+
+// CreateVMContext is like CreateVM but stops waiting for the VM IP address when
+// ctx is cancelled. The VM is then left defined and running: the caller is
+// responsible for destroying it.
+func (v *VMM) CreateVMContext(ctx context.Context, cfg VMConfig) (*VMMetadata, error) {
+	limiter := v.creationLimiter()
+	if err := limiter.acquire(ctx, cfg.Name); err != nil {
+		return nil, err
+	}
+	defer limiter.release()
+
 	// Determine temp directory: cfg.TempDir > VMM.baseDir > os.TempDir()
 	tempDir := cfg.TempDir
 	if tempDir == "" && v.baseDir != "" {
@@ -151,21 +219,39 @@ func (v *VMM) CreateVM(cfg VMConfig) (*VMMetadata, error) {
 	if tempDir == "" {
 		tempDir = os.TempDir()
 	}
+	cfg.TempDir = tempDir
+
+	// The domain is built first so that an invalid configuration fails before
+	// any file is created.
+	domain, err := buildDomainXML(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.checkDomainCapabilities(domain.CPU, domain.MemoryBacking); err != nil {
+		return nil, err
+	}
 
 	userData, err := cfg.UserData.Render()
 	if err != nil {
 		return nil, err
 	}
 
-	cloudInitISOPath, err := generateCloudInitISO(cfg.Name, userData, tempDir)
+	if cfg.CloudInitDir != "" {
+		if err := writeCloudInitFiles(cfg.CloudInitDir, cfg.Name, userData); err != nil {
+			return nil, flaterrors.Join(err, fmt.Errorf("cloudInitDir=%s", cfg.CloudInitDir), errPersistCloudInit)
+		}
+		slog.Info("saved rendered cloud-init", "vmName", cfg.Name, "cloudInitDir", cfg.CloudInitDir)
+	}
+
+	cloudInitISOPath, err := v.generateCloudInitISO(cfg.Name, userData, tempDir)
 	if err != nil {
 		return nil, flaterrors.Join(err, errGenerateCloudInitISO)
 	}
 	defer os.Remove(cloudInitISOPath)
 
 	// -- Create overlay vm image
-	vmDiskPath := filepath.Join(tempDir, fmt.Sprintf("%s.qcow2", cfg.Name))
-	qemuImgCmd := exec.Command(
+	vmDiskPath := vmDiskFile(tempDir, cfg.Name)
+	if output, err := v.runCmd(
 		"qemu-img",
 		"create",
 		"-f",
@@ -174,180 +260,39 @@ func (v *VMM) CreateVM(cfg VMConfig) (*VMMetadata, error) {
 		fmt.Sprintf("backing_file=%s,backing_fmt=qcow2", cfg.ImageQCOW2Path),
 		vmDiskPath,
 		cfg.DiskSize,
-	)
-	if output, err := qemuImgCmd.CombinedOutput(); err != nil {
+	); err != nil {
 		return nil, flaterrors.Join(err, fmt.Errorf("output: %s", output), errCreateVMDisk)
 	}
+	createdFiles := []string{vmDiskPath}
 
-	var filesystems []libvirtxml.DomainFilesystem
-	for _, fs := range cfg.VirtioFS {
-		// libvirt will manage virtiofsd, so we don't start it manually here.
-		// We only need to configure the DomainFilesystem.
-		filesystems = append(filesystems, libvirtxml.DomainFilesystem{
-			AccessMode: "passthrough", // As per user's documentation
-			Driver: &libvirtxml.DomainFilesystemDriver{
-				Type:  "virtiofs",
-				Queue: 1024, // As per user's documentation
-			},
-			Target: &libvirtxml.DomainFilesystemTarget{
-				Dir: fs.Tag, // This is the guest mount tag
-			},
-			Source: &libvirtxml.DomainFilesystemSource{
-				Mount: &libvirtxml.DomainFilesystemSourceMount{
-					Dir: fs.MountPoint, // This should be the host-side path
-					// No Socket field here, libvirt will manage it implicitly
-				},
-			},
-		})
+	// -- Create the additional data disks
+	for i, disk := range cfg.ExtraDisks {
+		diskPath := extraDiskFile(tempDir, cfg.Name, i)
+		if output, err := v.runCmd("qemu-img", "create", "-f", "qcow2", diskPath, disk.Size); err != nil {
+			return nil, flaterrors.Join(err, fmt.Errorf("diskPath=%s output: %s", diskPath, output), errCreateVMDisk)
+		}
+		createdFiles = append(createdFiles, diskPath)
 	}
+
 	// Remove virtiofsd processes map as libvirt will manage virtiofsd
 	v.virtiofsds = make(map[string][]struct {
 		Cmd    *exec.Cmd
 		Cancel context.CancelFunc
 	})
 
-	domain := &libvirtxml.Domain{
-		Type: "kvm",
-		Name: cfg.Name,
-		Memory: &libvirtxml.DomainMemory{
-			Value: cfg.MemoryMB,
-			Unit:  "MiB",
-		},
-		VCPU: &libvirtxml.DomainVCPU{
-			Value: cfg.VCPUs,
-		},
-		OS: &libvirtxml.DomainOS{
-			Type: &libvirtxml.DomainOSType{
-				Arch:    "x86_64",
-				Machine: "pc-q35-8.0",
-				Type:    "hvm",
-			},
-			BootDevices: []libvirtxml.DomainBootDevice{
-				{Dev: "hd"},
-			},
-		},
-		Features: &libvirtxml.DomainFeatureList{
-			ACPI: &libvirtxml.DomainFeature{},
-			APIC: &libvirtxml.DomainFeatureAPIC{},
-		},
-		CPU: &libvirtxml.DomainCPU{
-			Mode: "host-passthrough",
-			// MigsFeature: "on", // This field is not directly available in libvirtxml.DomainCPU
-		},
-		Clock: &libvirtxml.DomainClock{
-			Offset: "utc",
-		},
-		OnPoweroff: "destroy",
-		OnReboot:   "restart",
-		OnCrash:    "destroy",
-		MemoryBacking: &libvirtxml.DomainMemoryBacking{
-			MemorySource: &libvirtxml.DomainMemorySource{
-				Type: "memfd",
-			},
-			MemoryAccess: &libvirtxml.DomainMemoryAccess{
-				Mode: "shared",
-			},
-		},
-		Devices: &libvirtxml.DomainDeviceList{
-			Disks: []libvirtxml.DomainDisk{
-				{
-					Device: "disk",
-					Driver: &libvirtxml.DomainDiskDriver{
-						Name: "qemu",
-						Type: "qcow2",
-					},
-					Source: &libvirtxml.DomainDiskSource{
-						File: &libvirtxml.DomainDiskSourceFile{
-							File: vmDiskPath,
-						},
-					},
-					Target: &libvirtxml.DomainDiskTarget{
-						Dev: "vda",
-						Bus: "virtio",
-					},
-				},
-				{
-					Device: "cdrom",
-					Driver: &libvirtxml.DomainDiskDriver{
-						Name: "qemu",
-						Type: "raw",
-					},
-					Source: &libvirtxml.DomainDiskSource{
-						File: &libvirtxml.DomainDiskSourceFile{
-							File: cloudInitISOPath,
-						},
-					},
-					Target: &libvirtxml.DomainDiskTarget{
-						Dev: "sdb",
-						Bus: "sata",
-					},
-					ReadOnly: &libvirtxml.DomainDiskReadOnly{},
-				},
-			},
-			Interfaces: []libvirtxml.DomainInterface{
-				{
-					Source: &libvirtxml.DomainInterfaceSource{
-						Network: &libvirtxml.DomainInterfaceSourceNetwork{
-							Network: cfg.Network,
-						},
-					},
-					Model: &libvirtxml.DomainInterfaceModel{
-						Type: "virtio",
-					},
-				},
-			},
-			Consoles: []libvirtxml.DomainConsole{
-				{
-					Target: &libvirtxml.DomainConsoleTarget{
-						Type: "serial",
-						Port: ptr(uint(0)),
-					},
-					Source: &libvirtxml.DomainChardevSource{
-						Pty: &libvirtxml.DomainChardevSourcePty{},
-					},
-				},
-			},
-			Channels: []libvirtxml.DomainChannel{
-				{
-					Target: &libvirtxml.DomainChannelTarget{
-						VirtIO: &libvirtxml.DomainChannelTargetVirtIO{
-							Name: "org.qemu.guest_agent.0",
-						},
-					},
-					Address: &libvirtxml.DomainAddress{
-						VirtioSerial: &libvirtxml.DomainAddressVirtioSerial{
-							Controller: ptr(uint(0)),
-							Bus:        ptr(uint(0)),
-							Port:       ptr(uint(1)),
-						},
-					},
-				},
-			},
-			RNGs: []libvirtxml.DomainRNG{
-				{
-					Model: "virtio",
-					Backend: &libvirtxml.DomainRNGBackend{
-						Random: &libvirtxml.DomainRNGBackendRandom{
-							Device: "/dev/urandom",
-						},
-					},
-				},
-			},
-			Filesystems: filesystems, // Add filesystems here
-		},
-	}
-
 	vmXML, err := domain.Marshal()
 	if err != nil {
 		return nil, flaterrors.Join(err, errMarshalDomainXML)
 	}
 
-	dom, err := v.conn.DomainDefineXML(vmXML)
+	dom, err := retryLibvirt("DomainDefineXML", func() (Domain, error) {
+		return v.conn.DomainDefineXML(vmXML)
+	})
 	if err != nil {
 		return nil, flaterrors.Join(err, errDefineDomain)
 	}
 
-	if err := dom.Create(); err != nil {
+	if err := retryLibvirtErr("DomainCreate", dom.Create); err != nil {
 		dom.Free()
 		return nil, flaterrors.Join(err, errCreateDomain)
 	}
@@ -363,19 +308,25 @@ func (v *VMM) CreateVM(cfg VMConfig) (*VMMetadata, error) {
 	}
 
 	// Get the VM's IP address with retry logic
-	execCtx := execcontext.New(make(map[string]string), []string{})
-	ipAddress, err := v.GetDomainIP(execCtx, cfg.Name, 60*time.Second)
+	ipAddress, err := v.waitDomainIP(ctx, cfg.Name, 60*time.Second)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, flaterrors.Join(ctx.Err(), fmt.Errorf("vmName=%s", cfg.Name), errTimeoutWaitingIP)
+		}
 		// Log but don't fail - IP might not be available immediately
 		slog.Debug("failed to get IP for VM", "vmName", cfg.Name, "error", err.Error())
 		ipAddress = ""
 	}
 
 	// Track created files for audit and cleanup
-	createdFiles := []string{vmDiskPath}
 	if cloudInitISOPath != "" {
 		createdFiles = append(createdFiles, cloudInitISOPath)
 	}
+	var consoleLogPath string
+	if cfg.ConsoleLog {
+		consoleLogPath = consoleLogFile(tempDir, cfg.Name)
+		createdFiles = append(createdFiles, consoleLogPath)
+	}
 
 	var (
 		user           cloudinit.User
@@ -398,13 +349,15 @@ func (v *VMM) CreateVM(cfg VMConfig) (*VMMetadata, error) {
 
 	// Return metadata about the created VM
 	return &VMMetadata{
-		Name:         cfg.Name,
-		IP:           ipAddress,
-		DomainXML:    domXML,
-		SSHPort:      22,
-		MemoryMB:     cfg.MemoryMB,
-		VCPUs:        cfg.VCPUs,
-		CreatedFiles: createdFiles,
+		Name:           cfg.Name,
+		IP:             ipAddress,
+		DomainXML:      domXML,
+		SSHPort:        22,
+		MemoryMB:       cfg.MemoryMB,
+		VCPUs:          cfg.VCPUs,
+		CreatedFiles:   createdFiles,
+		CloudInitDir:   cfg.CloudInitDir,
+		ConsoleLogPath: consoleLogPath,
 	}, nil
 }
 
@@ -444,6 +397,40 @@ func (v *VMM) DomainExists(ctx execcontext.Context, name string) (bool, error) {
 	return false, nil
 }
 
+// domainStateNames are the names reported by DomainState.
+var domainStateNames = map[libvirt.DomainState]string{
+	libvirt.DOMAIN_NOSTATE:     "nostate",
+	libvirt.DOMAIN_RUNNING:     "running",
+	libvirt.DOMAIN_BLOCKED:     "blocked",
+	libvirt.DOMAIN_PAUSED:      "paused",
+	libvirt.DOMAIN_SHUTDOWN:    "shutdown",
+	libvirt.DOMAIN_SHUTOFF:     "shutoff",
+	libvirt.DOMAIN_CRASHED:     "crashed",
+	libvirt.DOMAIN_PMSUSPENDED: "pmsuspended",
+}
+
+// DomainState returns the state of a VM domain as named by virsh (e.g. "running", "shutoff").
+// It returns errVMNotFound if the domain does not exist.
+func (v *VMM) DomainState(ctx execcontext.Context, name string) (string, error) {
+	exists, err := v.DomainExists(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", flaterrors.Join(fmt.Errorf("vmName=%s", name), errVMNotFound)
+	}
+
+	state, _, err := v.domains[name].GetState()
+	if err != nil {
+		return "", flaterrors.Join(err, fmt.Errorf("vmName=%s", name), errGetDomainState)
+	}
+
+	if s, ok := domainStateNames[state]; ok {
+		return s, nil
+	}
+	return "unknown", nil
+}
+
 // GetDomainIP retrieves the IP address of a running VM
 // Polls with backoff up to the specified timeout duration
 func (v *VMM) GetDomainIP(
@@ -451,46 +438,50 @@ func (v *VMM) GetDomainIP(
 	name string,
 	timeout time.Duration,
 ) (string, error) {
+	return v.waitDomainIP(context.Background(), name, timeout)
+}
+
+// waitDomainIP polls the IP address of a running VM until the timeout expires or ctx is cancelled.
+func (v *VMM) waitDomainIP(ctx context.Context, name string, timeout time.Duration) (string, error) {
 	dom, ok := v.domains[name]
 	if !ok || dom == nil {
 		return "", flaterrors.Join(fmt.Errorf("vmName=%s", name), errVMNotFound)
 	}
 
 	// Retry with exponential backoff up to timeout
-	deadline := time.Now().Add(timeout)
-	backoff := 1 * time.Second
-	maxBackoff := 30 * time.Second
+	var ip string
+	backoff := waitutil.Backoff{Initial: 1 * time.Second, Max: 30 * time.Second, Factor: 1.5}
+	err := waitutil.PollWithBackoff(ctx, backoff, timeout, func() (bool, error) {
+		var found bool
+		ip, found = domainIPv4(dom)
+		return found, nil
+	})
+	if err != nil {
+		return "", flaterrors.Join(err, fmt.Errorf("vmName=%s", name), errTimeoutWaitingIP)
+	}
 
-	for {
-		if time.Now().After(deadline) {
-			return "", flaterrors.Join(fmt.Errorf("vmName=%s", name), errTimeoutWaitingIP)
-		}
+	return ip, nil
+}
 
-		// Try to get IP address
-		ifaces, err := dom.ListAllInterfaceAddresses(
-			libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE,
-		)
-		if err == nil {
-			for _, iface := range ifaces {
-				for _, addr := range iface.Addrs {
-					if addr.Type == libvirt.IP_ADDR_TYPE_IPV4 {
-						return strings.Split(addr.Addr, "/")[0], nil
-					}
-				}
-			}
-		}
+// domainIPv4 returns the first IPv4 address leased to the domain, if any.
+func domainIPv4(dom Domain) (string, bool) {
+	ifaces, err := retryLibvirt("ListAllInterfaceAddresses", func() ([]libvirt.DomainInterface, error) {
+		return dom.ListAllInterfaceAddresses(libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE)
+	})
+	if err != nil {
+		slog.Debug("error listing interface addresses", "error", err.Error())
+		return "", false
+	}
 
-		// Wait before retrying with exponential backoff
-		waitTime := backoff
-		if backoff < maxBackoff {
-			backoff = time.Duration(float64(backoff) * 1.5)
-			if backoff > maxBackoff {
-				backoff = maxBackoff
+	for _, iface := range ifaces {
+		for _, addr := range iface.Addrs {
+			if addr.Type == libvirt.IP_ADDR_TYPE_IPV4 {
+				return strings.Split(addr.Addr, "/")[0], true
 			}
 		}
-
-		time.Sleep(waitTime)
 	}
+
+	return "", false
 }
 
 // GetDomainXML returns the full XML definition of a domain
@@ -511,7 +502,7 @@ func (v *VMM) GetDomainXML(ctx execcontext.Context, name string) (string, error)
 // GetDomainByName gets a domain handle by name, checking memory first then querying libvirt
 // This helper function supports cleanup scenarios where a new VMM instance is created
 // Returns nil if domain does not exist (allows idempotent cleanup)
-func (v *VMM) GetDomainByName(ctx execcontext.Context, name string) (*libvirt.Domain, error) {
+func (v *VMM) GetDomainByName(ctx execcontext.Context, name string) (Domain, error) {
 	// Check in-memory map first (optimization)
 	if dom, ok := v.domains[name]; ok && dom != nil {
 		return dom, nil
@@ -555,10 +546,14 @@ func (v *VMM) DestroyVM(ctx execcontext.Context, vmName string) error {
 		if tempDir == "" {
 			tempDir = os.TempDir()
 		}
-		vmDiskPath := filepath.Join(tempDir, fmt.Sprintf("%s.qcow2", vmName))
-		cloudInitISOPath := filepath.Join(tempDir, fmt.Sprintf("%s-cloud-init.iso", vmName))
+		vmDiskPath := vmDiskFile(tempDir, vmName)
+		cloudInitISOPath := cloudInitISOFile(tempDir, vmName)
 		os.Remove(vmDiskPath)
 		os.Remove(cloudInitISOPath)
+		for _, diskPath := range extraDiskFiles(tempDir, vmName) {
+			os.Remove(diskPath)
+		}
+		os.Remove(consoleLogFile(tempDir, vmName))
 		return nil
 	}
 
@@ -586,27 +581,36 @@ func (v *VMM) DestroyVM(ctx execcontext.Context, vmName string) error {
 	}
 
 	// Delete the VM's disk file
-	vmDiskPath := filepath.Join(tempDir, fmt.Sprintf("%s.qcow2", vmName))
+	vmDiskPath := vmDiskFile(tempDir, vmName)
 	if err := os.Remove(vmDiskPath); err != nil && !os.IsNotExist(err) {
 		return flaterrors.Join(err, fmt.Errorf("vmDiskPath=%s", vmDiskPath), errDeleteVMDisk)
 	}
+	for _, diskPath := range extraDiskFiles(tempDir, vmName) {
+		if err := os.Remove(diskPath); err != nil && !os.IsNotExist(err) {
+			return flaterrors.Join(err, fmt.Errorf("diskPath=%s", diskPath), errDeleteVMDisk)
+		}
+	}
 
 	// Delete the cloud-init ISO if it exists
-	cloudInitISOPath := filepath.Join(tempDir, fmt.Sprintf("%s-cloud-init.iso", vmName))
+	cloudInitISOPath := cloudInitISOFile(tempDir, vmName)
 	if err := os.Remove(cloudInitISOPath); err != nil && !os.IsNotExist(err) {
 		// Log but don't fail - this is just cleanup
 		slog.Debug("failed to delete cloud-init ISO", "path", cloudInitISOPath, "error", err.Error())
 	}
 
+	// Delete the console log if it exists
+	consoleLogPath := consoleLogFile(tempDir, vmName)
+	if err := os.Remove(consoleLogPath); err != nil && !os.IsNotExist(err) {
+		slog.Debug("failed to delete console log", "path", consoleLogPath, "error", err.Error())
+	}
+
 	dom.Free()
 	delete(v.domains, vmName)
 	return nil
 }
 
-func generateCloudInitISO(vmName, userData, tempDir string) (string, error) {
-	metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", vmName, vmName)
-
-	isoPath := filepath.Join(tempDir, fmt.Sprintf("%s-cloud-init.iso", vmName))
+func (v *VMM) generateCloudInitISO(vmName, userData, tempDir string) (string, error) {
+	isoPath := cloudInitISOFile(tempDir, vmName)
 
 	// Create a temporary directory for cloud-init config files
 	cloudInitDir := filepath.Join(tempDir, fmt.Sprintf("%s-cloud-init-config", vmName))
@@ -615,30 +619,55 @@ func generateCloudInitISO(vmName, userData, tempDir string) (string, error) {
 	}
 	defer os.RemoveAll(cloudInitDir)
 
-	userFile := filepath.Join(cloudInitDir, "user-data")
-	if err := os.WriteFile(userFile, []byte(userData), 0o644); err != nil {
-		return "", flaterrors.Join(err, errWriteUserData)
-	}
-
-	metaFile := filepath.Join(cloudInitDir, "meta-data")
-	if err := os.WriteFile(metaFile, []byte(metaData), 0o644); err != nil {
-		return "", flaterrors.Join(err, errWriteMetaData)
+	if err := writeCloudInitFiles(cloudInitDir, vmName, userData); err != nil {
+		return "", err
 	}
 
-	xorrisoCmd := exec.Command(
+	if output, err := v.runCmd(
 		"xorriso",
 		"-as", "mkisofs",
 		"-o", isoPath,
 		"-V", "cidata",
 		"-J", "-R",
 		cloudInitDir,
-	)
-	if output, err := xorrisoCmd.CombinedOutput(); err != nil {
+	); err != nil {
 		return "", flaterrors.Join(err, fmt.Errorf("output: %s", output), errCreateCloudInitISO)
 	}
 	return isoPath, nil
 }
 
+// SaveCloudInit renders the user data and writes the NoCloud "user-data" and
+// "meta-data" files the VM would boot with into dir, without creating the VM.
+func SaveCloudInit(dir, vmName string, userData cloudinit.UserData) error {
+	rendered, err := userData.Render()
+	if err != nil {
+		return err
+	}
+
+	return writeCloudInitFiles(dir, vmName, rendered)
+}
+
+// writeCloudInitFiles writes the rendered user-data and the generated meta-data into dir.
+func writeCloudInitFiles(dir, vmName, userData string) error {
+	metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", vmName, vmName)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return flaterrors.Join(err, errCreateCloudInitDir)
+	}
+
+	userFile := filepath.Join(dir, "user-data")
+	if err := os.WriteFile(userFile, []byte(userData), 0o644); err != nil {
+		return flaterrors.Join(err, errWriteUserData)
+	}
+
+	metaFile := filepath.Join(dir, "meta-data")
+	if err := os.WriteFile(metaFile, []byte(metaData), 0o644); err != nil {
+		return flaterrors.Join(err, errWriteMetaData)
+	}
+
+	return nil
+}
+
 // GetVMIPAddress retrieves the IP address of a running VM.
 func (v *VMM) GetVMIPAddress(vmName string) (string, error) {
 	dom, ok := v.domains[vmName]
@@ -647,33 +676,19 @@ func (v *VMM) GetVMIPAddress(vmName string) (string, error) {
 	}
 
 	// Retry for up to 60 seconds to get the VM's IP address
-	timeout := time.After(60 * time.Second)
-	tick := time.NewTicker(5 * time.Second)
-	defer tick.Stop()
-
-	for {
-		select {
-		case <-timeout:
-			return "", flaterrors.Join(fmt.Errorf("vmName=%s", vmName), errTimeoutWaitingIP)
-		case <-tick.C:
-			ifaces, err := dom.ListAllInterfaceAddresses(
-				libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE,
-			)
-			if err != nil {
-				slog.Debug("error listing interface addresses", "vmName", vmName, "error", err.Error())
-				continue
-			}
-
-			for _, iface := range ifaces {
-				for _, addr := range iface.Addrs {
-					if addr.Type == libvirt.IP_ADDR_TYPE_IPV4 {
-						return strings.Split(addr.Addr, "/")[0], nil
-					}
-				}
-			}
+	var ip string
+	err := waitutil.Poll(context.Background(), 5*time.Second, 60*time.Second, func() (bool, error) {
+		var found bool
+		if ip, found = domainIPv4(dom); !found {
 			slog.Debug("VM IP address not found, retrying...", "vmName", vmName)
 		}
+		return found, nil
+	})
+	if err != nil {
+		return "", flaterrors.Join(err, fmt.Errorf("vmName=%s", vmName), errTimeoutWaitingIP)
 	}
+
+	return ip, nil
 }
 
 // GetConsoleOutput retrieves the serial console output of a VM.
@@ -0,0 +1,776 @@
+package vmm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexandremahdhaoui/edge-cd/pkg/cloudinit"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/execcontext"
+	"github.com/alexandremahdhaoui/edge-cd/pkg/vmm"
+	"libvirt.org/go/libvirt"
+)
+
+// fakeCommandRunner records host commands and creates the files they would produce
+type fakeCommandRunner struct {
+	commands [][]string
+	failOn   string
+}
+
+func (r *fakeCommandRunner) run(name string, args ...string) ([]byte, error) {
+	r.commands = append(r.commands, append([]string{name}, args...))
+	if name == r.failOn {
+		return []byte("boom"), errors.New("exit status 1")
+	}
+
+	// The output file is the argument following "-o" for xorriso and the second to last one for qemu-img
+	switch name {
+	case "qemu-img":
+		return nil, os.WriteFile(args[len(args)-2], []byte("qcow2"), 0o644)
+	case "xorriso":
+		for i, arg := range args {
+			if arg == "-o" {
+				return nil, os.WriteFile(args[i+1], []byte("iso"), 0o644)
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeCommandRunner) ran(name string) []string {
+	for _, cmd := range r.commands {
+		if cmd[0] == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func newFakeVMM(t *testing.T) (*vmm.VMM, *vmm.FakeConnection, *fakeCommandRunner, string) {
+	t.Helper()
+	baseDir := t.TempDir()
+	conn := vmm.NewFakeConnection()
+	runner := &fakeCommandRunner{}
+
+	v, err := vmm.NewVMM(
+		vmm.WithConnection(conn),
+		vmm.WithCommandRunner(runner.run),
+		vmm.WithBaseDir(baseDir),
+	)
+	if err != nil {
+		t.Fatalf("NewVMM with fake connection failed: %v", err)
+	}
+	return v, conn, runner, baseDir
+}
+
+func newFakeVMConfig(name string) vmm.VMConfig {
+	return vmm.NewVMConfig(name, "/images/base.qcow2", cloudinit.UserData{
+		Hostname: name,
+		Users:    []cloudinit.User{cloudinit.NewUserWithAuthorizedKeys("ubuntu", []string{"ssh-ed25519 AAAA"})},
+	})
+}
+
+func TestCreateVMWithFakeConnection(t *testing.T) {
+	v, conn, runner, baseDir := newFakeVMM(t)
+	conn.LeaseIPs["fake-vm"] = "192.168.122.42"
+
+	metadata, err := v.CreateVM(newFakeVMConfig("fake-vm"))
+	if err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+
+	if metadata.IP != "192.168.122.42" {
+		t.Errorf("expected IP 192.168.122.42, got %q", metadata.IP)
+	}
+	if !strings.Contains(metadata.DomainXML, "<name>fake-vm</name>") {
+		t.Errorf("expected domain XML to contain the VM name, got %s", metadata.DomainXML)
+	}
+
+	dom, ok := conn.Domains["fake-vm"]
+	if !ok {
+		t.Fatal("expected domain to be defined")
+	}
+	if dom.State != libvirt.DOMAIN_RUNNING {
+		t.Errorf("expected domain to be running, got state %d", dom.State)
+	}
+
+	// Overlay disk is backed by the base image
+	qemuImg := runner.ran("qemu-img")
+	if qemuImg == nil {
+		t.Fatal("expected qemu-img to be run")
+	}
+	if !strings.Contains(strings.Join(qemuImg, " "), "backing_file=/images/base.qcow2") {
+		t.Errorf("unexpected qemu-img command: %v", qemuImg)
+	}
+	diskPath := filepath.Join(baseDir, "fake-vm.qcow2")
+	if metadata.CreatedFiles[0] != diskPath {
+		t.Errorf("expected disk %s to be tracked, got %v", diskPath, metadata.CreatedFiles)
+	}
+
+	if runner.ran("xorriso") == nil {
+		t.Error("expected xorriso to be run to build the cloud-init ISO")
+	}
+
+	exists, err := v.DomainExists(execcontext.New(nil, nil), "fake-vm")
+	if err != nil || !exists {
+		t.Errorf("DomainExists = %v, %v; want true, nil", exists, err)
+	}
+}
+
+func TestCreateVMWithFakeConnection_RetriesTransientDefineError(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+	conn.LeaseIPs["fake-vm"] = "192.168.122.42"
+	conn.InjectErrors("DomainDefineXML", libvirt.Error{Code: libvirt.ERR_RESOURCE_BUSY, Message: "busy"})
+
+	if _, err := v.CreateVM(newFakeVMConfig("fake-vm")); err != nil {
+		t.Fatalf("CreateVM should retry transient errors: %v", err)
+	}
+	if _, ok := conn.Domains["fake-vm"]; !ok {
+		t.Error("expected domain to be defined after retry")
+	}
+}
+
+func TestCreateVMWithFakeConnection_RetriesTransientCreateError(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+	conn.LeaseIPs["fake-vm"] = "192.168.122.42"
+	conn.InjectErrors("DomainCreate", libvirt.Error{
+		Code: libvirt.ERR_SYSTEM_ERROR, Domain: libvirt.FROM_RPC, Message: "Connection reset by peer",
+	})
+
+	if _, err := v.CreateVM(newFakeVMConfig("fake-vm")); err != nil {
+		t.Fatalf("CreateVM should retry transient errors: %v", err)
+	}
+	if dom, ok := conn.Domains["fake-vm"]; !ok || dom.State != libvirt.DOMAIN_RUNNING {
+		t.Errorf("expected domain to be running after retry, got %+v", conn.Domains)
+	}
+}
+
+func TestCreateVMWithFakeConnection_NoConnectNotRetried(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+	conn.LeaseIPs["fake-vm"] = "192.168.122.42"
+	// A retry on the same connection would succeed, and must not happen
+	conn.InjectErrors("DomainDefineXML", libvirt.Error{Code: libvirt.ERR_NO_CONNECT, Message: "no connection driver"})
+
+	if _, err := v.CreateVM(newFakeVMConfig("fake-vm")); err == nil {
+		t.Fatal("expected CreateVM to fail without retrying on a missing connection")
+	}
+	if len(conn.Domains) != 0 {
+		t.Errorf("expected no domain to be defined, got %v", conn.Domains)
+	}
+}
+
+func TestCreateVMWithFakeConnection_Errors(t *testing.T) {
+	t.Run("disk creation fails", func(t *testing.T) {
+		v, conn, runner, _ := newFakeVMM(t)
+		runner.failOn = "qemu-img"
+
+		if _, err := v.CreateVM(newFakeVMConfig("fake-vm")); err == nil {
+			t.Fatal("expected CreateVM to fail")
+		}
+		if len(conn.Domains) != 0 {
+			t.Errorf("expected no domain to be defined, got %v", conn.Domains)
+		}
+	})
+
+	t.Run("domain already running", func(t *testing.T) {
+		v, conn, _, _ := newFakeVMM(t)
+		conn.InjectErrors("DomainCreate", libvirt.Error{Code: libvirt.ERR_OPERATION_INVALID, Message: "invalid"})
+
+		if _, err := v.CreateVM(newFakeVMConfig("fake-vm")); err == nil {
+			t.Fatal("expected CreateVM to fail on permanent error")
+		}
+	})
+}
+
+func TestDestroyVMWithFakeConnection(t *testing.T) {
+	v, conn, _, baseDir := newFakeVMM(t)
+	conn.LeaseIPs["fake-vm"] = "192.168.122.42"
+	ctx := execcontext.New(nil, nil)
+
+	if _, err := v.CreateVM(newFakeVMConfig("fake-vm")); err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+
+	if err := v.DestroyVM(ctx, "fake-vm"); err != nil {
+		t.Fatalf("DestroyVM failed: %v", err)
+	}
+
+	if _, ok := conn.Domains["fake-vm"]; ok {
+		t.Error("expected domain to be undefined")
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "fake-vm.qcow2")); !os.IsNotExist(err) {
+		t.Errorf("expected disk to be deleted, stat err: %v", err)
+	}
+
+	exists, err := v.DomainExists(ctx, "fake-vm")
+	if err != nil || exists {
+		t.Errorf("DomainExists = %v, %v; want false, nil", exists, err)
+	}
+
+	// Destroying a missing VM is a no-op
+	if err := v.DestroyVM(ctx, "fake-vm"); err != nil {
+		t.Errorf("DestroyVM should be idempotent: %v", err)
+	}
+}
+
+func TestCreateVMWithFakeConnection_ExtraDisks(t *testing.T) {
+	v, conn, runner, baseDir := newFakeVMM(t)
+	conn.LeaseIPs["fake-vm"] = "192.168.122.42"
+
+	cfg := newFakeVMConfig("fake-vm")
+	cfg.ExtraDisks = []vmm.DiskConfig{{Size: "10G"}, {Size: "1G", Bus: vmm.DiskBusSATA}}
+	metadata, err := v.CreateVM(cfg)
+	if err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+
+	dataDisks := []string{filepath.Join(baseDir, "fake-vm-data0.qcow2"), filepath.Join(baseDir, "fake-vm-data1.qcow2")}
+	for i, disk := range dataDisks {
+		if !slices.Contains(metadata.CreatedFiles, disk) {
+			t.Errorf("expected disk %s to be tracked, got %v", disk, metadata.CreatedFiles)
+		}
+		if !strings.Contains(metadata.DomainXML, disk) {
+			t.Errorf("expected domain XML to attach %s, got %s", disk, metadata.DomainXML)
+		}
+		if _, err := os.Stat(disk); err != nil {
+			t.Errorf("expected disk %s to be created: %v", disk, err)
+		}
+		wantCmd := fmt.Sprintf("qemu-img create -f qcow2 %s %s", disk, cfg.ExtraDisks[i].Size)
+		if !slices.ContainsFunc(runner.commands, func(cmd []string) bool { return strings.Join(cmd, " ") == wantCmd }) {
+			t.Errorf("expected %q to be run, got %v", wantCmd, runner.commands)
+		}
+	}
+
+	if err := v.DestroyVM(execcontext.New(nil, nil), "fake-vm"); err != nil {
+		t.Fatalf("DestroyVM failed: %v", err)
+	}
+	for _, disk := range dataDisks {
+		if _, err := os.Stat(disk); !os.IsNotExist(err) {
+			t.Errorf("expected disk %s to be deleted, stat err: %v", disk, err)
+		}
+	}
+}
+
+func TestCreateVMWithFakeConnection_ConsoleLog(t *testing.T) {
+	v, conn, _, baseDir := newFakeVMM(t)
+	conn.LeaseIPs["fake-vm"] = "192.168.122.42"
+
+	cfg := newFakeVMConfig("fake-vm")
+	cfg.ConsoleLog = true
+	metadata, err := v.CreateVM(cfg)
+	if err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+
+	logPath := filepath.Join(baseDir, "fake-vm-console.log")
+	if metadata.ConsoleLogPath != logPath {
+		t.Errorf("expected console log %s, got %q", logPath, metadata.ConsoleLogPath)
+	}
+	if !slices.Contains(metadata.CreatedFiles, logPath) {
+		t.Errorf("expected console log %s to be tracked, got %v", logPath, metadata.CreatedFiles)
+	}
+	if !strings.Contains(metadata.DomainXML, fmt.Sprintf(`<log file="%s" append="off">`, logPath)) {
+		t.Errorf("expected domain XML to log the console to %s, got %s", logPath, metadata.DomainXML)
+	}
+
+	// libvirt writes the log while the VM runs
+	if err := os.WriteFile(logPath, []byte("boot"), 0o644); err != nil {
+		t.Fatalf("failed to write console log: %v", err)
+	}
+	if err := v.DestroyVM(execcontext.New(nil, nil), "fake-vm"); err != nil {
+		t.Fatalf("DestroyVM failed: %v", err)
+	}
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Errorf("expected console log to be deleted, stat err: %v", err)
+	}
+}
+
+func TestDestroyVMWithFakeConnection_StoppedDomain(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+	ctx := execcontext.New(nil, nil)
+
+	// Domain defined by a previous VMM instance and already shut off
+	if _, err := conn.DomainDefineXML("<domain type='kvm'><name>stale-vm</name></domain>"); err != nil {
+		t.Fatalf("failed to define domain: %v", err)
+	}
+
+	if err := v.DestroyVM(ctx, "stale-vm"); err != nil {
+		t.Fatalf("DestroyVM failed: %v", err)
+	}
+	if _, ok := conn.Domains["stale-vm"]; ok {
+		t.Error("expected stale domain to be undefined")
+	}
+}
+
+func TestGetDomainIPWithFakeConnection(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+	ctx := execcontext.New(nil, nil)
+
+	if _, err := v.GetDomainIP(ctx, "unknown-vm", time.Second); err == nil {
+		t.Error("expected error for unknown VM")
+	}
+
+	conn.LeaseIPs["fake-vm"] = "192.168.122.7"
+	if _, err := v.CreateVM(newFakeVMConfig("fake-vm")); err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+
+	// Lease expired: GetDomainIP times out
+	delete(conn.LeaseIPs, "fake-vm")
+	start := time.Now()
+	if _, err := v.GetDomainIP(ctx, "fake-vm", 100*time.Millisecond); err == nil {
+		t.Error("expected timeout when no IP is leased")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GetDomainIP did not honor timeout: %s", elapsed)
+	}
+
+	conn.LeaseIPs["fake-vm"] = "192.168.122.7"
+	ip, err := v.GetDomainIP(ctx, "fake-vm", time.Second)
+	if err != nil {
+		t.Fatalf("GetDomainIP failed: %v", err)
+	}
+	if ip != "192.168.122.7" {
+		t.Errorf("expected IP 192.168.122.7, got %q", ip)
+	}
+}
+
+func TestNetworkLifecycleWithFakeConnection(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+	ctx := execcontext.New(nil, nil)
+
+	if err := v.CreateNetwork(ctx, vmm.NewNetworkConfig("fake-net", 200)); err != nil {
+		t.Fatalf("CreateNetwork failed: %v", err)
+	}
+	if net, ok := conn.Networks["fake-net"]; !ok || !net.Active {
+		t.Fatalf("expected network to be defined and active, got %+v", conn.Networks)
+	}
+
+	if err := v.DestroyNetwork(ctx, "fake-net"); err != nil {
+		t.Fatalf("DestroyNetwork failed: %v", err)
+	}
+	if _, ok := conn.Networks["fake-net"]; ok {
+		t.Error("expected network to be undefined")
+	}
+
+	if err := v.Close(); err != nil || !conn.Closed {
+		t.Errorf("expected connection to be closed, err=%v", err)
+	}
+}
+
+func TestCreateNetworkWithFakeConnection_RetriesTransientErrors(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+	conn.InjectErrors("NetworkDefineXML", libvirt.Error{Code: libvirt.ERR_RESOURCE_BUSY, Message: "busy"})
+	conn.InjectErrors("NetworkCreate", libvirt.Error{Code: libvirt.ERR_OPERATION_TIMEOUT, Message: "timeout"})
+
+	if err := v.CreateNetwork(execcontext.New(nil, nil), vmm.NewNetworkConfig("fake-net", 200)); err != nil {
+		t.Fatalf("CreateNetwork should retry transient errors: %v", err)
+	}
+	if net, ok := conn.Networks["fake-net"]; !ok || !net.Active {
+		t.Errorf("expected network to be defined and active after retry, got %+v", conn.Networks)
+	}
+}
+
+func TestCreateNetworkWithFakeConnection_PermanentCreateError(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+	conn.InjectErrors("NetworkCreate", libvirt.Error{Code: libvirt.ERR_OPERATION_INVALID, Message: "invalid"})
+
+	if err := v.CreateNetwork(execcontext.New(nil, nil), vmm.NewNetworkConfig("fake-net", 200)); err == nil {
+		t.Fatal("expected CreateNetwork to fail on permanent error")
+	}
+	// The network is not left defined but inactive
+	if _, ok := conn.Networks["fake-net"]; ok {
+		t.Errorf("expected network to be undefined, got %+v", conn.Networks)
+	}
+}
+
+func TestCloseTwiceWithFakeConnection(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+
+	if err := v.Close(); err != nil || !conn.Closed {
+		t.Fatalf("expected connection to be closed, err=%v", err)
+	}
+	// The connection is not closed again
+	if err := v.Close(); err != nil {
+		t.Errorf("second Close() error = %v, want nil", err)
+	}
+
+	var nilVMM *vmm.VMM
+	if err := nilVMM.Close(); err != nil {
+		t.Errorf("Close() on a nil VMM error = %v, want nil", err)
+	}
+}
+
+const fakeDomainCapabilities = `<domainCapabilities>
+  <path>/usr/bin/qemu-system-x86_64</path>
+  <domain>kvm</domain>
+  <machine>pc-q35-8.0</machine>
+  <arch>x86_64</arch>
+  <cpu>
+    <mode name='host-passthrough' supported='yes'/>
+    <mode name='host-model' supported='yes'/>
+    <mode name='custom' supported='yes'>
+      <model usable='yes'>Skylake-Client</model>
+      <model usable='no'>EPYC</model>
+    </mode>
+  </cpu>
+  <memoryBacking supported='yes'>
+    <enum name='sourceType'>
+      <value>file</value>
+      <value>anonymous</value>
+      <value>memfd</value>
+    </enum>
+  </memoryBacking>
+</domainCapabilities>`
+
+func TestCreateVMWithFakeConnection_CPUAndMemoryBacking(t *testing.T) {
+	tests := []struct {
+		name        string
+		configure   func(cfg *vmm.VMConfig)
+		contains    []string
+		notContains []string
+	}{
+		{
+			name:     "defaults",
+			contains: []string{`<cpu mode="host-passthrough">`, `<source type="memfd">`, `<access mode="shared">`},
+		},
+		{
+			name:        "host-model",
+			configure:   func(cfg *vmm.VMConfig) { cfg.CPUMode = vmm.CPUModeHostModel },
+			contains:    []string{`<cpu mode="host-model">`},
+			notContains: []string{"host-passthrough"},
+		},
+		{
+			name: "custom model",
+			configure: func(cfg *vmm.VMConfig) {
+				cfg.CPUMode = vmm.CPUModeCustom
+				cfg.CPUModel = "Skylake-Client"
+			},
+			contains: []string{`<cpu match="exact" mode="custom">`, `<model>Skylake-Client</model>`},
+		},
+		{
+			name: "private anonymous memory",
+			configure: func(cfg *vmm.VMConfig) {
+				cfg.MemoryBacking = vmm.MemoryBackingConfig{
+					SourceType: vmm.MemorySourceAnonymous,
+					AccessMode: vmm.MemoryAccessPrivate,
+				}
+			},
+			contains: []string{`<source type="anonymous">`, `<access mode="private">`},
+		},
+		{
+			name:        "memory backing disabled",
+			configure:   func(cfg *vmm.VMConfig) { cfg.MemoryBacking.Disabled = true },
+			notContains: []string{"<memoryBacking>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, conn, _, _ := newFakeVMM(t)
+			conn.DomainCapabilities = fakeDomainCapabilities
+			conn.LeaseIPs["fake-vm"] = "192.168.122.42"
+
+			cfg := newFakeVMConfig("fake-vm")
+			if tt.configure != nil {
+				tt.configure(&cfg)
+			}
+
+			metadata, err := v.CreateVM(cfg)
+			if err != nil {
+				t.Fatalf("CreateVM failed: %v", err)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(metadata.DomainXML, want) {
+					t.Errorf("expected domain XML to contain %q, got %s", want, metadata.DomainXML)
+				}
+			}
+			for _, unwanted := range tt.notContains {
+				if strings.Contains(metadata.DomainXML, unwanted) {
+					t.Errorf("expected domain XML not to contain %q, got %s", unwanted, metadata.DomainXML)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateVMWithFakeConnection_InvalidCPUAndMemoryBacking(t *testing.T) {
+	tests := []struct {
+		name      string
+		caps      string
+		configure func(cfg *vmm.VMConfig)
+	}{
+		{
+			name:      "unknown CPU mode",
+			configure: func(cfg *vmm.VMConfig) { cfg.CPUMode = "maximum-speed" },
+		},
+		{
+			name:      "custom mode without model",
+			configure: func(cfg *vmm.VMConfig) { cfg.CPUMode = vmm.CPUModeCustom },
+		},
+		{
+			name:      "model without custom mode",
+			configure: func(cfg *vmm.VMConfig) { cfg.CPUModel = "Skylake-Client" },
+		},
+		{
+			name: "private memory with virtiofs",
+			configure: func(cfg *vmm.VMConfig) {
+				cfg.VirtioFS = []vmm.VirtioFSConfig{{Tag: "share", MountPoint: "/srv"}}
+				cfg.MemoryBacking.AccessMode = vmm.MemoryAccessPrivate
+			},
+		},
+		{
+			name: "model not usable on host",
+			caps: fakeDomainCapabilities,
+			configure: func(cfg *vmm.VMConfig) {
+				cfg.CPUMode = vmm.CPUModeCustom
+				cfg.CPUModel = "EPYC"
+			},
+		},
+		{
+			name: "mode not supported by host",
+			caps: strings.Replace(fakeDomainCapabilities,
+				`<mode name='host-model' supported='yes'/>`, `<mode name='host-model' supported='no'/>`, 1),
+			configure: func(cfg *vmm.VMConfig) { cfg.CPUMode = vmm.CPUModeHostModel },
+		},
+		{
+			name: "memory source not supported by host",
+			caps: strings.Replace(fakeDomainCapabilities, "<value>memfd</value>", "", 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, conn, runner, _ := newFakeVMM(t)
+			conn.DomainCapabilities = tt.caps
+
+			cfg := newFakeVMConfig("fake-vm")
+			if tt.configure != nil {
+				tt.configure(&cfg)
+			}
+
+			if _, err := v.CreateVM(cfg); err == nil {
+				t.Fatal("expected CreateVM to fail")
+			}
+			if len(conn.Domains) != 0 || len(runner.commands) != 0 {
+				t.Errorf("expected validation to fail before any resource is created")
+			}
+		})
+	}
+}
+
+func TestDomainStateWithFakeConnection(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+	ctx := execcontext.New(nil, nil)
+	conn.LeaseIPs["fake-vm"] = "192.168.122.42"
+
+	if _, err := v.DomainState(ctx, "fake-vm"); err == nil {
+		t.Error("DomainState of a missing VM should fail")
+	}
+
+	if _, err := v.CreateVM(newFakeVMConfig("fake-vm")); err != nil {
+		t.Fatalf("CreateVM failed: %v", err)
+	}
+	if state, err := v.DomainState(ctx, "fake-vm"); err != nil || state != "running" {
+		t.Errorf("DomainState = %q, %v; want running, nil", state, err)
+	}
+
+	conn.Domains["fake-vm"].State = libvirt.DOMAIN_SHUTOFF
+	if state, err := v.DomainState(ctx, "fake-vm"); err != nil || state != "shutoff" {
+		t.Errorf("DomainState = %q, %v; want shutoff, nil", state, err)
+	}
+}
+
+// manualDomainXML is a domain defined by hand, outside of CreateVM.
+const manualDomainXML = `<domain type="kvm">
+  <name>dev-vm</name>
+  <memory unit="GiB">4</memory>
+  <vcpu>3</vcpu>
+  <os><type arch="x86_64">hvm</type></os>
+  <devices>
+    <disk type="file" device="disk">
+      <source file="/var/lib/libvirt/images/dev-vm.qcow2"/>
+      <target dev="vda" bus="virtio"/>
+    </disk>
+    <console type="pty">
+      <target type="serial" port="0"/>
+      <log file="/var/log/libvirt/qemu/dev-vm-console.log" append="on"/>
+    </console>
+  </devices>
+</domain>`
+
+func TestImportDomainWithFakeConnection(t *testing.T) {
+	v, conn, _, _ := newFakeVMM(t)
+	dom, err := conn.DomainDefineXML(manualDomainXML)
+	if err != nil {
+		t.Fatalf("DomainDefineXML failed: %v", err)
+	}
+	if err := dom.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	conn.LeaseIPs["dev-vm"] = "192.168.122.50"
+
+	metadata, err := v.ImportDomain(context.Background(), "dev-vm")
+	if err != nil {
+		t.Fatalf("ImportDomain failed: %v", err)
+	}
+	if metadata.Name != "dev-vm" || metadata.IP != "192.168.122.50" {
+		t.Errorf("expected dev-vm at 192.168.122.50, got %q at %q", metadata.Name, metadata.IP)
+	}
+	if metadata.MemoryMB != 4096 || metadata.VCPUs != 3 {
+		t.Errorf("expected 4096 MiB and 3 vCPUs, got %d MiB and %d vCPUs", metadata.MemoryMB, metadata.VCPUs)
+	}
+	if metadata.ConsoleLogPath != "/var/log/libvirt/qemu/dev-vm-console.log" {
+		t.Errorf("unexpected console log path %q", metadata.ConsoleLogPath)
+	}
+	if metadata.DomainXML != manualDomainXML {
+		t.Errorf("expected the domain XML to be recorded, got %q", metadata.DomainXML)
+	}
+	// The disks of the imported domain do not belong to the VMM
+	if len(metadata.CreatedFiles) != 0 {
+		t.Errorf("expected no created file, got %v", metadata.CreatedFiles)
+	}
+
+	// A stopped domain is imported without IP address
+	conn.Domains["dev-vm"].State = libvirt.DOMAIN_SHUTOFF
+	metadata, err = v.ImportDomain(context.Background(), "dev-vm")
+	if err != nil {
+		t.Fatalf("ImportDomain of a stopped domain failed: %v", err)
+	}
+	if metadata.IP != "" {
+		t.Errorf("expected no IP address for a stopped domain, got %q", metadata.IP)
+	}
+
+	if _, err := v.ImportDomain(context.Background(), "unknown-vm"); err == nil {
+		t.Error("expected error for unknown VM")
+	}
+}
+
+// concurrencyRecorder records how many qemu-img commands run at the same time.
+type concurrencyRecorder struct {
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func (c *concurrencyRecorder) wrap(run vmm.CommandRunner) vmm.CommandRunner {
+	return func(name string, args ...string) ([]byte, error) {
+		if name != "qemu-img" {
+			return run(name, args...)
+		}
+
+		c.mu.Lock()
+		c.running++
+		if c.running > c.max {
+			c.max = c.running
+		}
+		c.mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		c.mu.Lock()
+		c.running--
+		c.mu.Unlock()
+		return run(name, args...)
+	}
+}
+
+func TestCreateVMWithFakeConnection_CreationLimit(t *testing.T) {
+	const (
+		maxCreations = 2
+		vms          = 6
+	)
+	limiter := vmm.NewCreationLimiter(maxCreations)
+	recorder := &concurrencyRecorder{}
+
+	// Each environment uses its own VMM, as the e2e setup does: the limiter is shared
+	var wg sync.WaitGroup
+	errs := make(chan error, vms)
+	for i := 0; i < vms; i++ {
+		name := fmt.Sprintf("fake-vm-%d", i)
+		conn := vmm.NewFakeConnection()
+		conn.LeaseIPs[name] = fmt.Sprintf("192.168.122.%d", 10+i)
+		runner := &fakeCommandRunner{}
+		v, err := vmm.NewVMM(
+			vmm.WithConnection(conn),
+			vmm.WithCommandRunner(recorder.wrap(runner.run)),
+			vmm.WithBaseDir(t.TempDir()),
+			vmm.WithCreationLimiter(limiter),
+		)
+		if err != nil {
+			t.Fatalf("NewVMM with fake connection failed: %v", err)
+		}
+
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if _, err := v.CreateVM(newFakeVMConfig(name)); err != nil {
+				errs <- err
+			}
+		}(name)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("CreateVM failed: %v", err)
+	}
+	if recorder.max > maxCreations {
+		t.Errorf("%d VMs were created concurrently, want at most %d", recorder.max, maxCreations)
+	}
+	if recorder.max < 2 {
+		t.Errorf("VMs were created one at a time, want up to %d concurrently", maxCreations)
+	}
+}
+
+func TestCreateVMWithFakeConnection_CreationLimitCancelled(t *testing.T) {
+	limiter := vmm.NewCreationLimiter(1)
+	release := make(chan struct{})
+	blocking := func(name string, args ...string) ([]byte, error) {
+		if name == "qemu-img" {
+			<-release
+		}
+		return (&fakeCommandRunner{}).run(name, args...)
+	}
+
+	newVMM := func() *vmm.VMM {
+		conn := vmm.NewFakeConnection()
+		conn.LeaseIPs["fake-vm-1"] = "192.168.122.10"
+		v, err := vmm.NewVMM(
+			vmm.WithConnection(conn),
+			vmm.WithCommandRunner(blocking),
+			vmm.WithBaseDir(t.TempDir()),
+			vmm.WithCreationLimiter(limiter),
+		)
+		if err != nil {
+			t.Fatalf("NewVMM with fake connection failed: %v", err)
+		}
+		return v
+	}
+
+	// The first creation holds the only slot until released
+	done := make(chan error)
+	go func() {
+		_, err := newVMM().CreateVM(newFakeVMConfig("fake-vm-1"))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := newVMM().CreateVMContext(ctx, newFakeVMConfig("fake-vm-2")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CreateVMContext error = %v, want the context deadline while waiting for a slot", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("CreateVM failed: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package waitutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexandremahdhaoui/tooling/pkg/flaterrors"
+)
+
+var (
+	// ErrTimeout is returned when a condition is not satisfied before the timeout expires.
+	ErrTimeout = errors.New("timed out waiting for condition")
+
+	// ErrInvalidInterval is returned when the interval between two checks is
+	// not positive, which would check the condition in a busy loop.
+	ErrInvalidInterval = errors.New("poll interval must be positive")
+)
+
+// ConditionFunc reports whether the awaited condition is satisfied.
+// Returning a non-nil error aborts polling and the error is returned to the caller.
+// Transient failures that should be retried must return (false, nil).
+type ConditionFunc func() (done bool, err error)
+
+// Backoff describes how the interval between two checks grows.
+type Backoff struct {
+	// Initial is the interval before the second check
+	Initial time.Duration
+
+	// Max caps the interval. Zero means no cap
+	Max time.Duration
+
+	// Factor multiplies the interval after each check. Values <= 1 keep the interval constant
+	Factor float64
+}
+
+// Next returns the interval following the given one.
+func (b Backoff) Next(interval time.Duration) time.Duration {
+	if b.Factor > 1 {
+		interval = time.Duration(float64(interval) * b.Factor)
+	}
+	if b.Max > 0 && interval > b.Max {
+		interval = b.Max
+	}
+	return interval
+}
+
+// Poll waits for interval, then checks fn every interval until it returns true,
+// returns an error, the timeout expires or ctx is cancelled.
+func Poll(ctx context.Context, interval, timeout time.Duration, fn ConditionFunc) error {
+	return poll(ctx, false, Backoff{Initial: interval}, timeout, fn)
+}
+
+// PollImmediate is like Poll but checks fn once before waiting for the first interval.
+func PollImmediate(ctx context.Context, interval, timeout time.Duration, fn ConditionFunc) error {
+	return poll(ctx, true, Backoff{Initial: interval}, timeout, fn)
+}
+
+// PollWithBackoff checks fn immediately, then keeps checking it with a growing
+// interval described by backoff until it returns true, returns an error,
+// the timeout expires or ctx is cancelled.
+func PollWithBackoff(ctx context.Context, backoff Backoff, timeout time.Duration, fn ConditionFunc) error {
+	return poll(ctx, true, backoff, timeout, fn)
+}
+
+// poll implements the polling loop shared by all exported helpers.
+// The last wait is shortened so that fn is checked one final time at the deadline.
+// A non-positive interval is rejected before fn is checked.
+func poll(ctx context.Context, immediate bool, backoff Backoff, timeout time.Duration, fn ConditionFunc) error {
+	if backoff.Initial <= 0 {
+		return flaterrors.Join(fmt.Errorf("interval=%s", backoff.Initial), ErrInvalidInterval)
+	}
+	deadline := time.Now().Add(timeout)
+	interval := backoff.Initial
+
+	if !immediate {
+		if err := sleep(ctx, interval, deadline); err != nil {
+			return err
+		}
+	}
+
+	for {
+		done, err := fn()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return flaterrors.Join(fmt.Errorf("timeout=%s", timeout), ErrTimeout)
+		}
+
+		if err := sleep(ctx, interval, deadline); err != nil {
+			return err
+		}
+		interval = backoff.Next(interval)
+	}
+}
+
+// sleep waits for d (capped at the deadline) or until ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration, deadline time.Time) error {
+	if remaining := time.Until(deadline); d > remaining {
+		d = remaining
+	}
+	if d < 0 {
+		d = 0
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,178 @@
+package waitutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPoll_Success(t *testing.T) {
+	calls := 0
+	err := Poll(context.Background(), 10*time.Millisecond, time.Second, func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+	if err != nil {
+		t.Fatalf("Poll returned unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestPoll_WaitsBeforeFirstCheck(t *testing.T) {
+	start := time.Now()
+	var firstCall time.Duration
+	err := Poll(context.Background(), 50*time.Millisecond, time.Second, func() (bool, error) {
+		firstCall = time.Since(start)
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("Poll returned unexpected error: %v", err)
+	}
+	if firstCall < 50*time.Millisecond {
+		t.Errorf("expected first check after at least 50ms, got %s", firstCall)
+	}
+}
+
+func TestPollImmediate_ChecksBeforeWaiting(t *testing.T) {
+	start := time.Now()
+	err := PollImmediate(context.Background(), time.Second, time.Second, func() (bool, error) {
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("PollImmediate returned unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected immediate return, took %s", elapsed)
+	}
+}
+
+func TestPoll_Timeout(t *testing.T) {
+	calls := 0
+	start := time.Now()
+	err := PollImmediate(context.Background(), 10*time.Millisecond, 50*time.Millisecond, func() (bool, error) {
+		calls++
+		return false, nil
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("timeout took too long: %s", elapsed)
+	}
+	if calls < 2 {
+		t.Errorf("expected condition to be checked several times, got %d", calls)
+	}
+}
+
+func TestPoll_ChecksOnceMoreAtDeadline(t *testing.T) {
+	// The interval is longer than the timeout: the wait is capped at the deadline
+	// and the condition gets a final check instead of sleeping past the timeout.
+	calls := 0
+	start := time.Now()
+	err := PollImmediate(context.Background(), time.Hour, 50*time.Millisecond, func() (bool, error) {
+		calls++
+		return calls == 2, nil
+	})
+	if err != nil {
+		t.Fatalf("PollImmediate returned unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("wait was not capped at the deadline: %s", elapsed)
+	}
+}
+
+func TestPoll_ConditionError(t *testing.T) {
+	wantErr := errors.New("boom")
+	calls := 0
+	err := PollImmediate(context.Background(), 10*time.Millisecond, time.Second, func() (bool, error) {
+		calls++
+		return false, wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected condition error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected polling to stop after the first error, got %d calls", calls)
+	}
+}
+
+func TestPoll_InvalidInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		calls := 0
+		err := PollImmediate(context.Background(), interval, time.Second, func() (bool, error) {
+			calls++
+			return false, nil
+		})
+		if !errors.Is(err, ErrInvalidInterval) {
+			t.Errorf("interval %s: expected ErrInvalidInterval, got %v", interval, err)
+		}
+		if calls != 0 {
+			t.Errorf("interval %s: expected no check, got %d calls", interval, calls)
+		}
+	}
+}
+
+func TestPoll_ContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	err := PollImmediate(ctx, 5*time.Millisecond, 10*time.Second, func() (bool, error) {
+		return false, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancellation was not honored promptly: %s", elapsed)
+	}
+}
+
+func TestPollWithBackoff_GrowsInterval(t *testing.T) {
+	var stamps []time.Time
+	backoff := Backoff{Initial: 10 * time.Millisecond, Max: 40 * time.Millisecond, Factor: 2}
+	err := PollWithBackoff(context.Background(), backoff, time.Second, func() (bool, error) {
+		stamps = append(stamps, time.Now())
+		return len(stamps) == 5, nil
+	})
+	if err != nil {
+		t.Fatalf("PollWithBackoff returned unexpected error: %v", err)
+	}
+
+	// Intervals: 10ms, 20ms, 40ms, 40ms (capped)
+	want := []time.Duration{10, 20, 40, 40}
+	for i, w := range want {
+		got := stamps[i+1].Sub(stamps[i])
+		if got < w*time.Millisecond {
+			t.Errorf("interval %d: expected at least %dms, got %s", i, w, got)
+		}
+	}
+}
+
+func TestBackoff_Next(t *testing.T) {
+	tests := []struct {
+		name     string
+		backoff  Backoff
+		interval time.Duration
+		want     time.Duration
+	}{
+		{"constant", Backoff{Factor: 1}, time.Second, time.Second},
+		{"zero factor is constant", Backoff{}, time.Second, time.Second},
+		{"grows", Backoff{Factor: 1.5}, time.Second, 1500 * time.Millisecond},
+		{"capped", Backoff{Factor: 2, Max: 3 * time.Second}, 2 * time.Second, 3 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.backoff.Next(tt.interval); got != tt.want {
+				t.Errorf("Next(%s) = %s, want %s", tt.interval, got, tt.want)
+			}
+		})
+	}
+}
@@ -39,6 +39,7 @@ func TestE2EBootstrapCommand(t *testing.T) {
 		ImageCacheDir:  filepath.Join(os.TempDir(), "edgectl"),
 		EdgeCDRepoPath: getEdgeCDRepoPath(t),
 		DownloadImages: true,
+		ImageSHA256:    os.Getenv("E2E_IMAGE_SHA256"),
 	}
 
 	testEnv, err := te2e.SetupTestEnvironment(ctx, setupConfig)
@@ -63,16 +64,13 @@ func TestE2EBootstrapCommand(t *testing.T) {
 	}
 
 	// Execute bootstrap test
-	executorConfig := te2e.ExecutorConfig{
-		EdgectlBinaryPath: binaryPath,
-		ConfigPath:        "./test/edgectl/e2e/config",
-		ConfigSpec:        "config.yaml",
-		Packages:          "git,curl,openssh-client",
-		ServiceManager:    "systemd",
-		PackageManager:    "apt",
-	}
+	executorConfig := te2e.ExecutorConfig{EdgectlBinaryPath: binaryPath}.WithDefaults()
 
-	if err := te2e.ExecuteBootstrapTest(ctx, testEnv, executorConfig); err != nil {
+	result, err := te2e.ExecuteBootstrapTest(ctx, testEnv, executorConfig)
+	for _, phase := range result.Phases {
+		t.Logf("Phase %q: ok=%t skipped=%t duration=%s", phase.Phase, phase.OK(), phase.Skipped, phase.Duration)
+	}
+	if err != nil {
 		testEnv.Status = "failed"
 		t.Fatalf("Bootstrap test failed: %v", err)
 	}